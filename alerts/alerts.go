@@ -0,0 +1,183 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+)
+
+const (
+	AUTH_FAILURE       = "auth_failure"
+	PANIC              = "panic"
+	DATABASE_DOWN      = "database_down"
+	DATABASE_RECONNECT = "database_reconnect"
+	DATABASE_FAILOVER  = "database_failover"
+	DELIVERY_EXHAUSTED = "delivery_exhausted"
+
+	SLACK = "slack"
+	JSON  = "json"
+)
+
+// Alert is an operational notification
+type Alert struct {
+	Kind    string            `json:"kind"`
+	Message string            `json:"message"`
+	Count   int               `json:"count"`
+	Host    string            `json:"host"`
+	Time    time.Time         `json:"time"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// Text formats the alert for humans
+func (alert Alert) Text() string {
+	text := fmt.Sprintf(":rotating_light: [%s] %s (%s, %d occurrence(s))", alert.Kind, alert.Message, alert.Host, alert.Count)
+	keys := make([]string, 0, len(alert.Fields))
+	for key := range alert.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		text += fmt.Sprintf("\n• %s: %s", key, alert.Fields[key])
+	}
+	return text
+}
+
+// Monitor counts significant events and posts an alert to the webhook when the threshold of the kind is
+// reached within the window. Each kind alerts at most once per window. A nil Monitor ignores all events.
+type Monitor struct {
+	Config     cfg.Alerts
+	Client     *http.Client
+	Thresholds map[string]int
+	host       string
+	mutex      sync.Mutex
+	counters   map[string]*counter
+}
+
+type counter struct {
+	start   time.Time
+	count   int
+	alerted bool
+}
+
+// NewMonitor creates a monitor posting to the configured webhook
+func NewMonitor(config cfg.Alerts) *Monitor {
+	host, _ := os.Hostname()
+	slog.Info("Alerts initialized", "format", config.Format, "window", config.Window)
+	return &Monitor{
+		Config: config,
+		Client: &http.Client{Timeout: 10 * time.Second},
+		Thresholds: map[string]int{
+			AUTH_FAILURE:       config.AuthFailures,
+			PANIC:              config.Panics,
+			DATABASE_DOWN:      1,
+			DATABASE_RECONNECT: 1,
+			DATABASE_FAILOVER:  1,
+			DELIVERY_EXHAUSTED: 1,
+		},
+		host:     host,
+		counters: map[string]*counter{},
+	}
+}
+
+// Record counts an event of the kind and sends the alert asynchronously once the threshold is reached,
+// kinds with a threshold of zero or less are ignored
+func (monitor *Monitor) Record(kind, message string, fields map[string]string) {
+	if monitor == nil {
+		return
+	}
+	threshold := monitor.Thresholds[kind]
+	if threshold <= 0 {
+		return
+	}
+	now := time.Now()
+	monitor.mutex.Lock()
+	current, ok := monitor.counters[kind]
+	if !ok || now.Sub(current.start) >= monitor.Config.Window {
+		current = &counter{start: now}
+		monitor.counters[kind] = current
+	}
+	current.count++
+	fire := !current.alerted && current.count >= threshold
+	if fire {
+		current.alerted = true
+	}
+	count := current.count
+	monitor.mutex.Unlock()
+	if !fire {
+		return
+	}
+	alert := Alert{Kind: kind, Message: message, Count: count, Host: monitor.host, Time: now, Fields: fields}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), monitor.Client.Timeout)
+		defer cancel()
+		err := monitor.Send(ctx, alert)
+		if err != nil {
+			slog.Error("Error sending alert", "kind", alert.Kind, "error", err)
+		}
+	}()
+}
+
+// Send posts the alert to the webhook, in the Slack incoming webhook format or as plain JSON
+func (monitor *Monitor) Send(ctx context.Context, alert Alert) error {
+	var payload any = alert
+	if monitor.Config.Format == SLACK {
+		payload = map[string]string{"text": alert.Text()}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, monitor.Config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := monitor.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusMultipleChoices {
+		details, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("alert webhook rejected alert with status %d: %s", response.StatusCode, strings.TrimSpace(string(details)))
+	}
+	return nil
+}
+
+// WatchDatabase pings the database periodically and alerts when it becomes unavailable and when it is reconnected
+func (monitor *Monitor) WatchDatabase(ctx context.Context, ping func(ctx context.Context) error) {
+	ticker := time.NewTicker(monitor.Config.DatabaseInterval)
+	defer ticker.Stop()
+	available := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, monitor.Config.DatabaseInterval)
+		err := ping(pingCtx)
+		cancel()
+		switch {
+		case err != nil && available:
+			available = false
+			slog.Error("Database is unavailable", "error", err)
+			monitor.Record(DATABASE_DOWN, "Database is unavailable", map[string]string{"error": err.Error()})
+		case err == nil && !available:
+			available = true
+			slog.Info("Database is reconnected")
+			monitor.Record(DATABASE_RECONNECT, "Database is reconnected", nil)
+		}
+	}
+}
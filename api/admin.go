@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/breaker"
+	"github.com/dzahariev/respite/cache"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/gorilla/mux"
+)
+
+// ADMIN is the resource name of the permissions required by the admin routes, admin.read and admin.write
+const ADMIN = "admin"
+
+// initAdminRoutes registers the routes used by operators
+func (server *Server) initAdminRoutes() {
+	apiAdminPath := fmt.Sprintf("/%s/admin", server.ServerConfig.APIPath)
+	server.Router.HandleFunc(apiAdminPath+"/startup", server.Permitted(ADMIN, READ, ContentTypeJSON(server.GetStartupReport()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(apiAdminPath+"/diagnostics", server.Permitted(ADMIN, READ, ContentTypeJSON(server.Diagnostics()))).Methods(http.MethodGet)
+	if server.Metrics != nil {
+		server.Router.HandleFunc(apiAdminPath+"/metrics", server.Permitted(ADMIN, READ, ContentTypeJSON(server.RouteMetrics()))).Methods(http.MethodGet)
+	}
+	if server.Meter != nil {
+		server.Router.HandleFunc(apiAdminPath+"/usage", server.Permitted(ADMIN, READ, ContentTypeJSON(server.Usage()))).Methods(http.MethodGet)
+		server.Router.HandleFunc(apiAdminPath+"/usage/export", server.Permitted(ADMIN, READ, server.ExportUsage())).Methods(http.MethodGet)
+		server.Router.HandleFunc(apiAdminPath+"/usage/refresh", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.RefreshUsage()))).Methods(http.MethodPost)
+	}
+	if server.RequestMeter != nil {
+		server.Router.HandleFunc(apiAdminPath+"/usage/requests", server.Permitted(ADMIN, READ, ContentTypeJSON(server.RequestUsage()))).Methods(http.MethodGet)
+	}
+	if server.Permissions != nil {
+		server.Router.HandleFunc(apiAdminPath+"/permissions", server.Permitted(ADMIN, READ, ContentTypeJSON(server.ListRolePermissions()))).Methods(http.MethodGet)
+		server.Router.HandleFunc(apiAdminPath+"/permissions", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.GrantRolePermission()))).Methods(http.MethodPost)
+		server.Router.HandleFunc(apiAdminPath+"/permissions/{role}/{permission}", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.RevokeRolePermission()))).Methods(http.MethodDelete)
+	}
+	// The backups are jobs keeping the archives in the storage, repositories have no database to back up
+	if server.Jobs != nil && server.Storage != nil && server.Repository == nil {
+		server.Router.HandleFunc(apiAdminPath+"/backups", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.CreateBackup()))).Methods(http.MethodPost)
+		server.Router.HandleFunc(apiAdminPath+"/restores", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.CreateRestore()))).Methods(http.MethodPost)
+	}
+	if server.CSPConfig.Store {
+		server.Router.HandleFunc(apiAdminPath+"/csp-reports", server.Permitted(ADMIN, READ, ContentTypeJSON(server.ListCSPReports()))).Methods(http.MethodGet)
+	}
+	if server.Instances != nil {
+		server.Router.HandleFunc(apiAdminPath+"/instances", server.Permitted(ADMIN, READ, ContentTypeJSON(server.ListInstances()))).Methods(http.MethodGet)
+	}
+	if server.ManifestConfig.Path != "" {
+		server.Router.HandleFunc(apiAdminPath+"/manifest", server.Permitted(ADMIN, READ, ContentTypeJSON(server.GetManifestStatus()))).Methods(http.MethodGet)
+		server.Router.HandleFunc(apiAdminPath+"/manifest/finalize", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.FinalizeManifest()))).Methods(http.MethodPost)
+	}
+	if server.Tenants != nil {
+		server.Router.HandleFunc(apiAdminPath+"/tenants", server.Permitted(ADMIN, READ, ContentTypeJSON(server.ListTenants()))).Methods(http.MethodGet)
+		server.Router.HandleFunc(apiAdminPath+"/tenants", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.CreateTenant()))).Methods(http.MethodPost)
+		server.Router.HandleFunc(apiAdminPath+"/tenants/{id}", server.Permitted(ADMIN, READ, ContentTypeJSON(server.GetTenant()))).Methods(http.MethodGet)
+		server.Router.HandleFunc(apiAdminPath+"/tenants/{id}", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.UpdateTenant()))).Methods(http.MethodPut)
+		server.Router.HandleFunc(apiAdminPath+"/tenants/{id}/suspend", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.SuspendTenant(true)))).Methods(http.MethodPost)
+		server.Router.HandleFunc(apiAdminPath+"/tenants/{id}/resume", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.SuspendTenant(false)))).Methods(http.MethodPost)
+		server.Router.HandleFunc(apiAdminPath+"/tenants/{id}/provision", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.ProvisionTenant()))).Methods(http.MethodPost)
+		server.Router.HandleFunc(apiAdminPath+"/tenants/{id}", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.DeleteTenant()))).Methods(http.MethodDelete)
+	}
+}
+
+// RouteMetrics returns the number of requests and the estimated latency quantiles per route
+func (server *Server) RouteMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		routes, err := server.Metrics.Routes()
+		if err != nil {
+			logger.Error("Error summarizing route metrics", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, routes)
+	}
+}
+
+// Diagnostics is a snapshot of the runtime state used for support
+type Diagnostics struct {
+	Time       time.Time            `json:"time"`
+	Host       string               `json:"host"`
+	GoVersion  string               `json:"go_version"`
+	Goroutines int                  `json:"goroutines"`
+	Memory     MemoryDiagnostics    `json:"memory"`
+	Database   *DatabaseDiagnostics `json:"database,omitempty"`
+	Caches     map[string]int64     `json:"caches,omitempty"`
+	Breakers   []breaker.Status     `json:"breakers,omitempty"`
+	Resources  []string             `json:"resources"`
+	Routes     []string             `json:"routes"`
+	Config     map[string]any       `json:"config"`
+}
+
+// MemoryDiagnostics are the memory statistics of the Go runtime
+type MemoryDiagnostics struct {
+	Alloc       uint64 `json:"alloc_bytes"`
+	TotalAlloc  uint64 `json:"total_alloc_bytes"`
+	Sys         uint64 `json:"sys_bytes"`
+	HeapObjects uint64 `json:"heap_objects"`
+	NumGC       uint32 `json:"gc_cycles"`
+	PauseTotal  string `json:"gc_pause_total"`
+}
+
+// DatabaseDiagnostics is the state of the database connection pool
+type DatabaseDiagnostics struct {
+	MaxOpen           int    `json:"max_open"`
+	Open              int    `json:"open"`
+	InUse             int    `json:"in_use"`
+	Idle              int    `json:"idle"`
+	WaitCount         int64  `json:"wait_count"`
+	WaitDuration      string `json:"wait_duration"`
+	MaxIdleClosed     int64  `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64  `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64  `json:"max_lifetime_closed"`
+}
+
+// Diagnostics returns the runtime state, the connection pool, cache sizes, circuit breakers, resources, routes and the
+// redacted configuration
+func (server *Server) Diagnostics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		diagnostics, err := server.diagnostics(ctx)
+		if err != nil {
+			logger.Error("Error collecting diagnostics", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, diagnostics)
+	}
+}
+
+// diagnostics collects the snapshot
+func (server *Server) diagnostics(ctx context.Context) (*Diagnostics, error) {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	host, _ := os.Hostname()
+	diagnostics := &Diagnostics{
+		Time:       time.Now().UTC(),
+		Host:       host,
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: MemoryDiagnostics{
+			Alloc:       memory.Alloc,
+			TotalAlloc:  memory.TotalAlloc,
+			Sys:         memory.Sys,
+			HeapObjects: memory.HeapObjects,
+			NumGC:       memory.NumGC,
+			PauseTotal:  time.Duration(memory.PauseTotalNs).String(),
+		},
+		Caches:    map[string]int64{},
+		Resources: server.Resources.Names(),
+		Routes:    server.routes(),
+		Config: cfg.Redacted(server.logConfig, server.dbConfig, server.ServerConfig, server.OutboxConfig,
+			server.SubscriptionsConfig, server.SchedulerConfig, server.StorageConfig, server.CacheConfig,
+			server.SearchConfig, server.FlagsConfig, server.AuditConfig, server.AlertsConfig, server.HealthConfig,
+			server.JobsConfig, server.MetricsConfig, server.TracingConfig, server.VaultConfig),
+	}
+	sort.Strings(diagnostics.Resources)
+
+	if server.DB != nil {
+		sqlDB, err := server.DB.DB()
+		if err != nil {
+			return nil, err
+		}
+		stats := sqlDB.Stats()
+		diagnostics.Database = &DatabaseDiagnostics{
+			MaxOpen:           stats.MaxOpenConnections,
+			Open:              stats.OpenConnections,
+			InUse:             stats.InUse,
+			Idle:              stats.Idle,
+			WaitCount:         stats.WaitCount,
+			WaitDuration:      stats.WaitDuration.String(),
+			MaxIdleClosed:     stats.MaxIdleClosed,
+			MaxIdleTimeClosed: stats.MaxIdleTimeClosed,
+			MaxLifetimeClosed: stats.MaxLifetimeClosed,
+		}
+	}
+
+	if server.Breakers != nil {
+		diagnostics.Breakers = server.Breakers.Statuses()
+	}
+
+	caches := map[string]cache.Cache{"cache": server.Cache}
+	if server.ResponseCache != server.Cache {
+		caches["response_cache"] = server.ResponseCache
+	}
+	for name, backend := range caches {
+		sizer, ok := backend.(cache.Sizer)
+		if !ok {
+			continue
+		}
+		size, err := sizer.Size(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read size of %s: %w", name, err)
+		}
+		diagnostics.Caches[name] = size
+	}
+	return diagnostics, nil
+}
+
+// routes lists the registered routes with their methods, e.g. GET /api/meals
+func (server *Server) routes() []string {
+	var routes []string
+	server.router.Load().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"*"}
+		}
+		routes = append(routes, fmt.Sprintf("%s %s", strings.Join(methods, ","), path))
+		return nil
+	})
+	return routes
+}
@@ -0,0 +1,362 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/fake"
+	"github.com/dzahariev/respite/jobs"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	BACKUP  = "backup"
+	RESTORE = "restore"
+
+	// backupBatchSize is the number of objects read at once by the backups
+	backupBatchSize = 500
+)
+
+// ErrRestoreNotEmpty is returned for the restores into databases that already have objects
+var ErrRestoreNotEmpty = errors.New("database is not empty")
+
+// BackupManifest describes the content of a backup
+type BackupManifest struct {
+	CreatedAt time.Time        `json:"created_at"`
+	Resources map[string]int64 `json:"resources"`
+}
+
+// CreateBackup creates a job writing the objects of all resources to a ZIP archive in the storage
+func (server *Server) CreateBackup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		job, err := server.newJob(r, BACKUP, ADMIN)
+		if err != nil {
+			logger.Error("Error creating backup job", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		err = server.Jobs.Enqueue(ctx, job)
+		if err != nil {
+			logger.Error("Error creating backup job", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		server.writeJobCreated(w, r, job)
+	}
+}
+
+// CreateRestore stores the uploaded backup archive and creates a job restoring it into the empty database
+func (server *Server) CreateRestore() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		if r.ContentLength > server.StorageConfig.MaxUploadSize {
+			ERROR(w, http.StatusRequestEntityTooLarge, fmt.Errorf("backup exceeds the maximum size of %d bytes", server.StorageConfig.MaxUploadSize))
+			return
+		}
+		// The restore is refused at once when it would fail, the job checks it again
+		err := server.checkEmpty(ctx, server.DB)
+		if err != nil {
+			ERROR(w, http.StatusConflict, err)
+			return
+		}
+		job, err := server.newJob(r, RESTORE, ADMIN)
+		if err != nil {
+			logger.Error("Error creating restore job", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, server.StorageConfig.MaxUploadSize)
+		err = server.Storage.Put(ctx, restoreKey(job), body, r.ContentLength, zipContentType)
+		if err != nil {
+			logger.Error("Error storing backup", "error", err)
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				ERROR(w, http.StatusRequestEntityTooLarge, err)
+				return
+			}
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		err = server.Jobs.Enqueue(ctx, job)
+		if err != nil {
+			logger.Error("Error creating restore job", "error", err)
+			server.Storage.Delete(ctx, restoreKey(job))
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		server.writeJobCreated(w, r, job)
+	}
+}
+
+// backupJob writes the objects of every resource, of all users and including the deleted ones, as newline
+// delimited JSON files of a ZIP archive with a manifest. The objects are read in one transaction, a snapshot of
+// the database with PostgreSQL.
+func (server *Server) backupJob(ctx context.Context, job *jobs.Job, progress jobs.Progress) error {
+	file, err := os.CreateTemp("", "backup-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	manifest := BackupManifest{CreatedAt: time.Now().UTC(), Resources: map[string]int64{}}
+	err = server.backupTransaction(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, func(tx *gorm.DB) error {
+		var total int64
+		for _, name := range server.backupOrder() {
+			var count int64
+			err := tx.Model(reflect.New(server.Resources.Resources[name].Type).Interface()).Unscoped().Count(&count).Error
+			if err != nil {
+				return fmt.Errorf("cannot count %s: %w", name, err)
+			}
+			total += count
+		}
+		var processed int64
+		for _, name := range server.backupOrder() {
+			count, err := server.backupResource(tx, archive, server.Resources.Resources[name], func(count int64) error {
+				return progress(ctx, processed+count, max(total, processed+count), 0)
+			})
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			manifest.Resources[name] = count
+			processed += count
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = writeArchiveJSON(archive, "manifest.json", manifest)
+	if err != nil {
+		return err
+	}
+	err = archive.Close()
+	if err != nil {
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	job.Artifact = fmt.Sprintf("jobs/%s/backup.zip", job.ID)
+	err = server.Storage.Put(ctx, job.Artifact, file, size, zipContentType)
+	if err != nil {
+		return err
+	}
+	return server.Jobs.Save(ctx, job)
+}
+
+// backupResource writes the objects of the resource to <resource>.ndjson of the archive and returns their number,
+// progress is called after each batch with the number written so far
+func (server *Server) backupResource(tx *gorm.DB, archive *zip.Writer, resource common.Resource, progress func(count int64) error) (int64, error) {
+	writer, err := archive.Create(resource.Name + ".ndjson")
+	if err != nil {
+		return 0, err
+	}
+	encoder := json.NewEncoder(writer)
+	rows := reflect.New(reflect.SliceOf(reflect.PointerTo(resource.Type)))
+	var count int64
+	err = tx.Model(reflect.New(resource.Type).Interface()).Unscoped().FindInBatches(rows.Interface(), backupBatchSize, func(batch *gorm.DB, _ int) error {
+		objects := rows.Elem()
+		for i := 0; i < objects.Len(); i++ {
+			err := encoder.Encode(objects.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+		}
+		count += int64(objects.Len())
+		return progress(count)
+	}).Error
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// restoreJob creates the objects of the uploaded backup in one transaction with PostgreSQL, with their IDs, owners
+// and timestamps. The validation, the webhooks and the events of the requests do not apply. The users that exist
+// already, e.g. the caller, are kept, the restore fails when any other resource has objects.
+func (server *Server) restoreJob(ctx context.Context, job *jobs.Job, progress jobs.Progress) error {
+	file, err := os.CreateTemp("", "restore-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	content, err := server.Storage.Get(ctx, restoreKey(job))
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(file, content)
+	content.Close()
+	if err != nil {
+		return err
+	}
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		return fmt.Errorf("invalid backup: %w", err)
+	}
+	manifest, err := readBackupManifest(archive)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for name, count := range manifest.Resources {
+		if _, ok := server.Resources.Resources[name]; !ok {
+			return fmt.Errorf("backup of unrecognized resource: %s", name)
+		}
+		total += count
+	}
+
+	err = server.backupTransaction(ctx, nil, func(tx *gorm.DB) error {
+		err := server.checkEmpty(ctx, tx)
+		if err != nil {
+			return err
+		}
+		var processed int64
+		for _, name := range server.backupOrder() {
+			if _, ok := manifest.Resources[name]; !ok {
+				continue
+			}
+			err := server.restoreResource(tx, archive, server.Resources.Resources[name], func() error {
+				processed++
+				if processed%importProgressStep != 0 {
+					return nil
+				}
+				return progress(ctx, processed, max(total, processed), 0)
+			})
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return progress(ctx, processed, max(total, processed), 0)
+	})
+	if err != nil {
+		return err
+	}
+	common.GetLogger(ctx).Info("Backup restored", "job", job.ID, "created_at", manifest.CreatedAt, "objects", total)
+	return server.Storage.Delete(ctx, restoreKey(job))
+}
+
+// restoreResource creates the objects of <resource>.ndjson of the archive, restored is called after each object
+func (server *Server) restoreResource(tx *gorm.DB, archive *zip.Reader, resource common.Resource, restored func() error) error {
+	content, err := archive.Open(resource.Name + ".ndjson")
+	if err != nil {
+		return fmt.Errorf("invalid backup: %w", err)
+	}
+	defer content.Close()
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		object := reflect.New(resource.Type).Interface()
+		err = json.Unmarshal(data, object)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		create := tx.Omit(clause.Associations)
+		if resource.Type == reflect.TypeFor[domain.User]() {
+			create = create.Clauses(clause.OnConflict{DoNothing: true})
+		}
+		err = create.Create(object).Error
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		err = restored()
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// backupTransaction runs fn in a transaction with PostgreSQL, the progress of the job is saved meanwhile with
+// another connection. SQLite locks the database for the transaction, e.g. in the development mode, fn runs
+// without one.
+func (server *Server) backupTransaction(ctx context.Context, options *sql.TxOptions, fn func(tx *gorm.DB) error) error {
+	db := server.DB.WithContext(ctx)
+	if db.Dialector.Name() != "postgres" {
+		return fn(db)
+	}
+	if options == nil {
+		return domain.Transaction(db, fn)
+	}
+	return db.Transaction(fn, options)
+}
+
+// checkEmpty checks that the resources other than the users have no objects
+func (server *Server) checkEmpty(ctx context.Context, db *gorm.DB) error {
+	for _, name := range server.backupOrder() {
+		resource := server.Resources.Resources[name]
+		if resource.Type == reflect.TypeFor[domain.User]() {
+			continue
+		}
+		var count int64
+		err := db.WithContext(ctx).Model(reflect.New(resource.Type).Interface()).Unscoped().Count(&count).Error
+		if err != nil {
+			return fmt.Errorf("cannot count %s: %w", name, err)
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: %s has %d objects", ErrRestoreNotEmpty, name, count)
+		}
+	}
+	return nil
+}
+
+// backupOrder returns the resources with the users first and the referenced resources before the referencing ones
+func (server *Server) backupOrder() []string {
+	userName := (&domain.User{}).ResourceName()
+	order := fake.New(server.Resources, 0).Order()
+	if index := slices.Index(order, userName); index > 0 {
+		order = append([]string{userName}, slices.Delete(order, index, index+1)...)
+	}
+	return order
+}
+
+// readBackupManifest reads the manifest of the backup archive
+func readBackupManifest(archive *zip.Reader) (*BackupManifest, error) {
+	content, err := archive.Open("manifest.json")
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+	defer content.Close()
+	manifest := &BackupManifest{}
+	err = json.NewDecoder(content).Decode(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// restoreKey is the storage key of the uploaded backup
+func restoreKey(job *jobs.Job) string {
+	return fmt.Sprintf("jobs/%s/restore.zip", job.ID)
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
 	"github.com/gofrs/uuid/v5"
 	"github.com/gorilla/mux"
 )
@@ -22,15 +23,78 @@ func (server *Server) GetAll() http.HandlerFunc {
 			return
 		}
 		logger.Debug("GetAll request received", "resource", repository.Resource.Name)
+		view, err := listView(ctx)
+		if err != nil {
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		cursorPagination := featureEnabled(w, FEATURE_CURSOR_PAGINATION)
+		if cursorPagination {
+			cursor, err := common.ParseCursor(r.URL.Query().Get(CURSOR))
+			if err != nil {
+				ERROR(w, http.StatusBadRequest, err)
+				return
+			}
+			repository.PageAfter(cursor)
+		}
+		err = server.checkOffset(repository.DBScopes.Page, repository.DBScopes.PageSize)
+		var near *common.Near
+		if err == nil {
+			near, err = common.ParseNear(r, repository.Resource)
+		}
+		var arrays []common.ArrayFilter
+		if err == nil {
+			arrays, err = common.ParseArrayFilters(r, repository.Resource)
+		}
+		if err == nil {
+			err = server.checkFilters(originConditions(repository.DBScopes.Origin) + len(arrays))
+		}
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		repository.FilterNear(near)
+		repository.FilterArrays(arrays)
 
 		list, err := repository.GetAll(ctx)
 		if err != nil {
 			logger.Error("Error getting all objects", "error", err)
-			ERROR(w, http.StatusInternalServerError, err)
+			ERROR(w, repositoryStatus(err), err)
 			return
 		}
+		// The aggregates are skipped on request, e.g. for the further pages of the same filters
+		if r.URL.Query().Get("aggregates") != "false" {
+			aggregates, err := repository.Aggregates(ctx)
+			if err != nil {
+				logger.Error("Error aggregating objects", "error", err)
+				ERROR(w, http.StatusInternalServerError, err)
+				return
+			}
+			if aggregates != nil {
+				list.Meta = map[string]any{"aggregates": aggregates}
+			}
+		}
+		if cursorPagination {
+			if next := common.NextCursor(list.Data, list.PageSize); next != nil {
+				if list.Meta == nil {
+					list.Meta = map[string]any{}
+				}
+				list.Meta["next_cursor"] = next.String()
+			}
+		}
 		logger.Debug("Objects retrieved successfully", "resource", repository.Resource.Name, "count", len(list.Data))
-		JSON(w, http.StatusOK, list)
+		presented := server.presentList(ctx, repository.Resource, list)
+		if view != nil && len(view.Columns) > 0 {
+			projected, err := projectColumns(presented, view.Columns)
+			if err != nil {
+				logger.Error("Error projecting columns", "error", err)
+				ERROR(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeList(w, repository.Resource.Name, http.StatusOK, projected)
+			return
+		}
+		writeList(w, repository.Resource.Name, http.StatusOK, presented)
 	}
 }
 
@@ -57,13 +121,13 @@ func (server *Server) Get() http.HandlerFunc {
 
 		object, err := repository.Get(ctx, uid)
 		if err != nil {
-			//TODO If the object is not found, return 404 otherwise return 500
 			logger.Error("Error getting object", "error", err)
-			ERROR(w, http.StatusNotFound, err)
+			ERROR(w, repositoryStatus(err), err)
 			return
 		}
 		logger.Debug("Object retrieved successfully", "resource", repository.Resource.Name, "id", uid)
-		JSON(w, http.StatusOK, object)
+		w.Header().Set("ETag", ETag(object))
+		writeObject(w, repository.Resource.Name, http.StatusOK, server.present(ctx, repository.Resource, object))
 	}
 }
 
@@ -87,16 +151,24 @@ func (server *Server) Create() http.HandlerFunc {
 			ERROR(w, http.StatusUnprocessableEntity, err)
 			return
 		}
+		if server.respondsAsync(r) {
+			server.startMutation(w, r, BatchOperation{Method: r.Method, Resource: repository.Resource.Name, Body: body})
+			return
+		}
 		object, err := repository.Create(ctx, body)
+		if repository.Quota != nil {
+			setQuotaHeaders(w, repository.Quota.Usage)
+		}
 		if err != nil {
 			logger.Error("Error creating object", "error", err)
-			ERROR(w, http.StatusInternalServerError, err)
+			ERROR(w, repositoryStatus(err), err)
 			return
 		}
 
 		w.Header().Set("Location", fmt.Sprintf("%s%s/%v", r.Host, r.RequestURI, object.GetID()))
+		w.Header().Set("ETag", ETag(object))
 		logger.Debug("Object created successfully", "resource", repository.Resource.Name, "id", object.GetID())
-		JSON(w, http.StatusCreated, object)
+		writeObject(w, repository.Resource.Name, http.StatusCreated, server.present(ctx, repository.Resource, object))
 	}
 }
 
@@ -128,14 +200,19 @@ func (server *Server) Update() http.HandlerFunc {
 			ERROR(w, http.StatusUnprocessableEntity, err)
 			return
 		}
+		if server.respondsAsync(r) {
+			server.startMutation(w, r, BatchOperation{Method: r.Method, Resource: repository.Resource.Name, ID: uid.String(), Body: body})
+			return
+		}
 		object, err := repository.Update(ctx, uid, body)
 		if err != nil {
 			logger.Error("Error updating object", "error", err)
-			ERROR(w, http.StatusInternalServerError, err)
+			ERROR(w, repositoryStatus(err), err)
 			return
 		}
 		logger.Debug("Object updated successfully", "resource", repository.Resource.Name, "id", uid)
-		JSON(w, http.StatusOK, object)
+		w.Header().Set("ETag", ETag(object))
+		writeObject(w, repository.Resource.Name, http.StatusOK, server.present(ctx, repository.Resource, object))
 	}
 }
 
@@ -160,10 +237,21 @@ func (server *Server) Delete() http.HandlerFunc {
 			ERROR(w, http.StatusBadRequest, err)
 			return
 		}
-		err = repository.Delete(ctx, uid)
+		// Dangerous resources are deleted only when the deletion is confirmed
+		if repository.Resource.ConfirmDelete && r.URL.Query().Get("confirm") != "true" {
+			ERROR(w, http.StatusBadRequest, WithCode(CODE_CONFIRMATION, fmt.Errorf("deleting %s requires the confirm=true parameter", repository.Resource.Name)))
+			return
+		}
+		if server.respondsAsync(r) {
+			server.startMutation(w, r, BatchOperation{Method: r.Method, Resource: repository.Resource.Name, ID: uid.String(), Confirm: repository.Resource.ConfirmDelete})
+			return
+		}
+		err = repository.DeleteIf(ctx, uid, func(object domain.Object) error {
+			return ifMatch(r, object)
+		})
 		if err != nil {
 			logger.Error("Error deleting object", "error", err)
-			ERROR(w, http.StatusInternalServerError, err)
+			ERROR(w, repositoryStatus(err), err)
 			return
 		}
 
@@ -172,3 +260,12 @@ func (server *Server) Delete() http.HandlerFunc {
 		JSON(w, http.StatusNoContent, "")
 	}
 }
+
+// checkOffset rejects pages beyond SERVER_MAX_OFFSET, the database would scan all the skipped rows
+func (server *Server) checkOffset(page, pageSize int) error {
+	maxOffset := server.ServerConfig.MaxOffset
+	if maxOffset > 0 && page*pageSize > maxOffset {
+		return fmt.Errorf("page %d with page size %d exceeds the maximum offset %d, narrow down the query instead", page, pageSize, maxOffset)
+	}
+	return nil
+}
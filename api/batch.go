@@ -0,0 +1,226 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/events"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// errBatchDryRun rolls back the transactions of the batches that are dry runs
+var errBatchDryRun = errors.New("batch dry run")
+
+// batchOperations are the operations of the methods of the batch operations
+var batchOperations = map[string]string{
+	http.MethodPost:   OPERATION_CREATE,
+	http.MethodPut:    OPERATION_UPDATE,
+	http.MethodDelete: OPERATION_DELETE,
+}
+
+// BatchRequest is an ordered list of operations executed in one transaction
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation is a creation, update or deletion in a batch, e.g.
+// {"method": "PUT", "resource": "order", "id": "6b1f0f59-8c2c-4a51-9d7e-0d8b7f4b1a2c", "body": {"status": "paid"}}
+type BatchOperation struct {
+	Method   string          `json:"method"`
+	Resource string          `json:"resource"`
+	ID       string          `json:"id,omitempty"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	// Confirm confirms the deletions of the dangerous resources like confirm=true
+	Confirm bool `json:"confirm,omitempty"`
+}
+
+// BatchResult is the result of an operation of a batch, with the status of the equal REST request
+type BatchResult struct {
+	Status  int           `json:"status"`
+	Object  domain.Object `json:"object,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Code    string        `json:"code,omitempty"`
+	Message string        `json:"message,omitempty"`
+}
+
+// BatchResponse holds the results of the operations in the order of the request
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// batchPublisher keeps the events of the operations until the transaction of the batch is committed
+type batchPublisher struct {
+	events []events.Event
+}
+
+// Publish keeps the event
+func (publisher *batchPublisher) Publish(ctx context.Context, event events.Event) error {
+	publisher.events = append(publisher.events, event)
+	return nil
+}
+
+// Close does nothing, the kept events are published by the batch
+func (publisher *batchPublisher) Close() error {
+	return nil
+}
+
+// Batch executes the operations of the request in one transaction, all or none of them. The response has 200 OK
+// and the results of all operations, or the status of the failed operation and 424 Failed Dependency for the
+// other ones, which are rolled back or not executed.
+func (server *Server) Batch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		request := BatchRequest{}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(request.Operations) == 0 {
+			ERROR(w, http.StatusBadRequest, errors.New("batch has no operations"))
+			return
+		}
+		limit := server.ServerConfig.MaxBatchOperations
+		if limit > 0 && len(request.Operations) > limit {
+			ERROR(w, http.StatusBadRequest, fmt.Errorf("batch has %d operations, the limit is %d", len(request.Operations), limit))
+			return
+		}
+		if server.consentRequired(w, r) {
+			return
+		}
+		dryRun := isDryRun(r)
+		publisher := &batchPublisher{}
+		results := make([]BatchResult, len(request.Operations))
+		failed := -1
+		err = domain.Transaction(server.DB.WithContext(ctx), func(tx *gorm.DB) error {
+			// The events of an aborted attempt were not committed
+			publisher.events = nil
+			for i, operation := range request.Operations {
+				var err error
+				results[i], err = server.batchOperation(ctx, tx, operation, publisher)
+				if err != nil {
+					failed = i
+					return err
+				}
+			}
+			if dryRun {
+				return errBatchDryRun
+			}
+			return nil
+		})
+		if failed >= 0 {
+			logger.Error("Error executing batch", "operation", failed, "error", err)
+			for i := range results {
+				if i != failed {
+					results[i] = BatchResult{Status: http.StatusFailedDependency, Error: fmt.Sprintf("operation %d failed", failed)}
+				}
+				results[i].Message = localizedMessage(w, results[i].Code)
+			}
+			JSON(w, results[failed].Status, BatchResponse{Results: results})
+			return
+		}
+		if err != nil && !errors.Is(err, errBatchDryRun) {
+			logger.Error("Error committing batch", "error", err)
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		if dryRun {
+			w.Header().Set("X-Dry-Run", "true")
+		} else {
+			server.publishBatch(ctx, request.Operations, publisher.events)
+		}
+		logger.Debug("Batch executed successfully", "operations", len(request.Operations), "dryRun", dryRun)
+		JSON(w, http.StatusOK, BatchResponse{Results: results})
+	}
+}
+
+// batchOperation executes the operation in the transaction with the permissions of the caller, failures are
+// returned with their result
+func (server *Server) batchOperation(ctx context.Context, tx *gorm.DB, operation BatchOperation, publisher events.Publisher) (BatchResult, error) {
+	fail := func(status int, err error) (BatchResult, error) {
+		return BatchResult{Status: status, Error: err.Error(), Code: errorCode(status, err)}, err
+	}
+	resource, ok := server.Resources.Resources[operation.Resource]
+	if !ok {
+		return fail(http.StatusBadRequest, fmt.Errorf("unknown resource %q", operation.Resource))
+	}
+	method := strings.ToUpper(operation.Method)
+	if method != http.MethodPost && method != http.MethodPut && method != http.MethodDelete {
+		return fail(http.StatusBadRequest, fmt.Errorf("method of batch operations must be POST, PUT or DELETE, got %q", operation.Method))
+	}
+	permissions := common.GetPermissions(ctx)
+	if !permissions.Can(resource.Name, WRITE) {
+		return fail(http.StatusUnauthorized, WithCode(CODE_PERMISSION, fmt.Errorf("unauthorized, no permission for %s.%s", resource.Name, WRITE)))
+	}
+	err := server.checkSensitive(ctx, resource, batchOperations[method])
+	if err != nil {
+		return fail(http.StatusUnauthorized, err)
+	}
+	var uid uuid.UUID
+	if method != http.MethodPost {
+		uid, err = uuid.FromString(operation.ID)
+		if err != nil {
+			return fail(http.StatusBadRequest, err)
+		}
+	}
+	user, _ := respitectx.CurrentUser(ctx)
+	requestContext := server.withComponents(ctx, common.NewRequestContextWithDetails(common.MinPageSize, 1, 0, user, resource, tx, server.Resources, permissions))
+	requestContext.Publisher = publisher
+
+	var object domain.Object
+	status := http.StatusOK
+	switch method {
+	case http.MethodPost:
+		object, err = requestContext.Create(ctx, operation.Body)
+		status = http.StatusCreated
+	case http.MethodPut:
+		object, err = requestContext.Update(ctx, uid, operation.Body)
+	case http.MethodDelete:
+		if resource.ConfirmDelete && !operation.Confirm {
+			return fail(http.StatusBadRequest, WithCode(CODE_CONFIRMATION, fmt.Errorf("deleting %s requires the confirm of the operation", resource.Name)))
+		}
+		err = requestContext.Delete(ctx, uid)
+		status = http.StatusNoContent
+	}
+	if err != nil {
+		return fail(repositoryStatus(err), err)
+	}
+	result := BatchResult{Status: status}
+	if object != nil {
+		result.Object = server.present(ctx, resource, object)
+	}
+	return result, nil
+}
+
+// publishBatch publishes the events of the committed batch and invalidates the cached responses of its resources.
+// With the outbox the events are stored in the transaction and none are kept.
+func (server *Server) publishBatch(ctx context.Context, operations []BatchOperation, batchEvents []events.Event) {
+	logger := common.GetLogger(ctx)
+	if server.Publisher != nil {
+		for _, event := range batchEvents {
+			err := server.Publisher.Publish(ctx, event)
+			if err != nil {
+				logger.Error("Error publishing event", "event", event.ID, "type", event.Type, "error", err)
+			}
+		}
+	}
+	if server.ResponseCache == nil {
+		return
+	}
+	invalidated := map[string]bool{}
+	for _, operation := range operations {
+		if !invalidated[operation.Resource] {
+			invalidated[operation.Resource] = true
+			server.invalidateResponses(ctx, operation.Resource)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+// initBootstrap maps all permissions to the bootstrap admin role unless the application defines the role,
+// it is called again when resources are registered on the running server
+func (server *Server) initBootstrap() {
+	if !server.BootstrapConfig.Enabled() {
+		return
+	}
+	role := server.BootstrapConfig.AdminRole
+	if _, ok := server.RoleToPermissions[role]; ok && !server.bootstrapMapped {
+		return
+	}
+	var permissions []string
+	names := append(server.Resources.Names(), ADMIN, CREDENTIALS, QUERIES)
+	for _, name := range names {
+		for _, permission := range []string{READ, WRITE, common.GLOBAL, EXPORT, IMPORT} {
+			permissions = append(permissions, fmt.Sprintf("%s.%s", name, permission))
+		}
+	}
+	// The mapping of the application is not changed
+	roleToPermissions := make(map[string][]string, len(server.RoleToPermissions)+1)
+	for name, rolePermissions := range server.RoleToPermissions {
+		roleToPermissions[name] = rolePermissions
+	}
+	roleToPermissions[role] = permissions
+	server.RoleToPermissions = roleToPermissions
+	server.bootstrapMapped = true
+}
+
+// isBootstrapAdmin checks if the user is the configured admin user
+func (server *Server) isBootstrapAdmin(user *domain.User) bool {
+	config := server.BootstrapConfig
+	if user == nil || !config.Enabled() {
+		return false
+	}
+	if config.AdminSubject != "" && strings.EqualFold(user.ID.String(), config.AdminSubject) {
+		return true
+	}
+	return config.AdminEmail != "" && strings.EqualFold(user.Email, config.AdminEmail)
+}
+
+// BootstrapAdmin creates the admin user given by its subject if it does not exist yet, it is called when the server is started
+func (server *Server) BootstrapAdmin(ctx context.Context) error {
+	config := server.BootstrapConfig
+	if config.AdminSubject == "" {
+		return nil
+	}
+	_, err := server.DBLoadUser(ctx, config.AdminSubject)
+	if err == nil {
+		return nil
+	}
+	user := &domain.User{
+		Base:  domain.Base{ID: uuid.FromStringOrNil(config.AdminSubject)},
+		Email: config.AdminEmail,
+	}
+	user.PreferedUserName, _, _ = strings.Cut(config.AdminEmail, "@")
+	err = server.DBSaveUser(ctx, user)
+	if err != nil {
+		return fmt.Errorf("cannot create bootstrap admin user: %w", err)
+	}
+	common.GetLogger(ctx).Warn("Bootstrap admin user created", "userID", user.ID, "role", config.AdminRole)
+	return nil
+}
@@ -0,0 +1,453 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/cache"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/events"
+	"github.com/dzahariev/respite/respitectx"
+)
+
+// cachedResponse is a response kept in the cache, a zero status marks a request in progress
+type cachedResponse struct {
+	Status      int               `json:"status"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+	RequestHash string            `json:"request_hash,omitempty"`
+}
+
+// cachedHeaders are the response headers kept together with the body
+var cachedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// responseRecorder passes the response through and keeps a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status code
+func (recorder *responseRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the body
+func (recorder *responseRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	recorder.body.Write(data)
+	return recorder.ResponseWriter.Write(data)
+}
+
+// response returns the recorded response
+func (recorder *responseRecorder) response() cachedResponse {
+	response := cachedResponse{Status: recorder.status, Header: map[string]string{}, Body: recorder.body.Bytes()}
+	for _, name := range cachedHeaders {
+		value := recorder.Header().Get(name)
+		if value != "" {
+			response.Header[name] = value
+		}
+	}
+	return response
+}
+
+// write replays the cached response
+func (response cachedResponse) write(w http.ResponseWriter) {
+	for name, value := range response.Header {
+		w.Header().Set(name, value)
+	}
+	w.WriteHeader(response.Status)
+	w.Write(response.Body)
+}
+
+// Operations of the resource routes, weighted by CACHE_REQUEST_COSTS
+const (
+	OPERATION_GET     = "get"
+	OPERATION_LIST    = "list"
+	OPERATION_CREATE  = "create"
+	OPERATION_UPDATE  = "update"
+	OPERATION_DELETE  = "delete"
+	OPERATION_EXPORT  = EXPORT
+	OPERATION_IMPORT  = IMPORT
+	OPERATION_CHANGES = "changes"
+)
+
+// rateLimit limits the number of requests per client address in a fixed window, the callers are not authenticated
+// yet, so their tokens do not identify them. Requests are allowed if the cache is not available.
+func (server *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := server.CacheConfig.RateLimit
+		if server.limited(w, r, "ratelimit:"+server.clientAddress(r), limit, 1, "X-RateLimit") {
+			ERROR(w, http.StatusTooManyRequests, fmt.Errorf("rate limit of %d requests per %s exceeded", limit, server.CacheConfig.RateLimitWindow))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resourceRateLimit charges the cost of the operation against the rate limit of the resource per caller, so that
+// expensive operations like exports are limited separately from the other requests. The rate limit of the tenant
+// of the caller replaces the configured one.
+func (server *Server) resourceRateLimit(resource common.Resource, operation string, next http.HandlerFunc) http.HandlerFunc {
+	configured, ok := server.CacheConfig.ResourceRateLimits[resource.Name]
+	if !ok {
+		configured = server.CacheConfig.ResourceRateLimits["*"]
+	}
+	if server.Cache == nil || (configured <= 0 && server.Tenants == nil) {
+		return next
+	}
+	cost, ok := server.CacheConfig.RequestCosts[resource.Name+"."+operation]
+	if !ok {
+		cost, ok = server.CacheConfig.RequestCosts[operation]
+	}
+	if !ok {
+		cost = 1
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := configured
+		user, _ := respitectx.CurrentUser(r.Context())
+		if tenant := server.tenantOf(r.Context(), user); tenant != nil {
+			if tenantLimit, ok := tenant.RateLimit(resource.Name); ok {
+				limit = tenantLimit
+			}
+		}
+		if limit <= 0 {
+			next(w, r)
+			return
+		}
+		key := fmt.Sprintf("ratelimit:%s:%s", resource.Name, server.callerKey(r))
+		if server.limited(w, r, key, limit, cost, "X-RateLimit-Resource") {
+			ERROR(w, http.StatusTooManyRequests, fmt.Errorf("rate limit of %s exceeded, %s costs %d of %d per %s", resource.Name, operation, cost, limit, server.CacheConfig.RateLimitWindow))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// limited adds the cost to the counter of the key in the current window, sets the limit headers with the prefix
+// and reports if the limit is exceeded. Requests are allowed if the cache is not available.
+func (server *Server) limited(w http.ResponseWriter, r *http.Request, key string, limit, cost int64, headerPrefix string) bool {
+	ctx := r.Context()
+	window := server.CacheConfig.RateLimitWindow
+	now := time.Now()
+	start := now.Truncate(window)
+	count, err := server.Cache.IncrementBy(ctx, fmt.Sprintf("%s:%d", key, start.Unix()), cost, window)
+	if err != nil {
+		common.GetLogger(ctx).Error("Error counting request for rate limit", "error", err)
+		return false
+	}
+	remaining := max(limit-count, 0)
+	w.Header().Set(headerPrefix+"-Limit", strconv.FormatInt(limit, 10))
+	w.Header().Set(headerPrefix+"-Remaining", strconv.FormatInt(remaining, 10))
+	if count <= limit {
+		return false
+	}
+	retryAfter := start.Add(window).Sub(now)
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+	return true
+}
+
+// responseTTL returns how long the responses of the resource are cached, the TTL of the resource or CACHE_RESPONSE_TTL
+func (server *Server) responseTTL(resource common.Resource) time.Duration {
+	if resource.CacheControl != nil && resource.CacheControl.ServerTTL() > 0 {
+		return resource.CacheControl.ServerTTL()
+	}
+	return server.CacheConfig.ResponseTTL
+}
+
+// responseCache serves GET requests from the cache, other requests invalidate all cached responses of the resource
+func (server *Server) responseCache(resource common.Resource, next http.HandlerFunc) http.HandlerFunc {
+	ttl := server.responseTTL(resource)
+	if server.ResponseCache == nil || ttl <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		recorder := &responseRecorder{ResponseWriter: w}
+		if r.Method != http.MethodGet {
+			next(recorder, r)
+			if recorder.status < http.StatusBadRequest && !isDryRun(r) {
+				server.invalidateResponses(ctx, resource.Name)
+			}
+			return
+		}
+
+		generation, _, err := server.ResponseCache.Get(ctx, generationKey(resource.Name))
+		if err != nil {
+			logger.Error("Error reading cache generation", "resource", resource.Name, "error", err)
+			next(w, r)
+			return
+		}
+		variant := r.URL.RequestURI()
+		// The responses differ by the negotiated features
+		if features := w.Header().Get(FEATURES_HEADER); features != "" {
+			variant += " " + features
+		}
+		key := fmt.Sprintf("response:%s:%s:%s:%s", resource.Name, generation, callerID(r), hash([]byte(variant)))
+		value, ok, err := server.ResponseCache.Get(ctx, key)
+		if err != nil {
+			logger.Error("Error reading cached response", "resource", resource.Name, "error", err)
+		}
+		if ok {
+			var response cachedResponse
+			err = json.Unmarshal(value, &response)
+			if err == nil {
+				w.Header().Set("X-Cache", "HIT")
+				response.write(w)
+				return
+			}
+			logger.Error("Error decoding cached response", "resource", resource.Name, "error", err)
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		next(recorder, r)
+		if recorder.status != http.StatusOK {
+			return
+		}
+		value, err = json.Marshal(recorder.response())
+		if err == nil {
+			err = server.ResponseCache.Set(ctx, key, value, ttl)
+		}
+		if err != nil {
+			logger.Error("Error caching response", "resource", resource.Name, "error", err)
+		}
+	}
+}
+
+// invalidateResponses drops the cached responses of the resource, replicas keeping the responses in
+// process memory are notified through the shared cache
+func (server *Server) invalidateResponses(ctx context.Context, resourceName string) {
+	logger := common.GetLogger(ctx)
+	_, err := server.ResponseCache.Increment(ctx, generationKey(resourceName), 0)
+	if err != nil {
+		logger.Error("Error invalidating cached responses", "resource", resourceName, "error", err)
+	}
+	broadcaster, ok := server.Cache.(cache.Broadcaster)
+	if !ok || server.ResponseCache == server.Cache {
+		return
+	}
+	err = broadcaster.Broadcast(ctx, server.CacheConfig.Invalidations, []byte(resourceName))
+	if err != nil {
+		logger.Error("Error broadcasting cache invalidation", "resource", resourceName, "error", err)
+	}
+}
+
+// invalidateLocalResponses handles invalidations broadcasted by other replicas
+func (server *Server) invalidateLocalResponses(message []byte) {
+	_, err := server.ResponseCache.Increment(context.Background(), generationKey(string(message)), 0)
+	if err != nil {
+		slog.Error("Error invalidating cached responses", "resource", string(message), "error", err)
+	}
+}
+
+// responseInvalidator invalidates cached responses on mutation events, so that writes through
+// GraphQL, gRPC and scheduled tasks are covered as well
+type responseInvalidator struct {
+	server *Server
+}
+
+// Publish invalidates the cached responses of the event resource, unless its responses are not cached
+func (invalidator responseInvalidator) Publish(ctx context.Context, event events.Event) error {
+	resource, ok := invalidator.server.Resources.Resources[event.Resource]
+	if ok && invalidator.server.responseTTL(resource) <= 0 {
+		return nil
+	}
+	invalidator.server.invalidateResponses(ctx, event.Resource)
+	return nil
+}
+
+// Close does nothing
+func (invalidator responseInvalidator) Close() error {
+	return nil
+}
+
+// cacheControl sets the Cache-Control header of the successful responses of resources that declare their
+// cacheability, errors are not cached by the clients
+func (server *Server) cacheControl(resource common.Resource, next http.HandlerFunc) http.HandlerFunc {
+	if resource.CacheControl == nil {
+		return next
+	}
+	value := resource.CacheControl.Header()
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(&cacheControlWriter{ResponseWriter: w, value: value}, r)
+	}
+}
+
+// cacheControlWriter sets the Cache-Control header when the response is OK or Not Modified
+type cacheControlWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+// WriteHeader sets the header before the status code is written
+func (writer *cacheControlWriter) WriteHeader(status int) {
+	if !writer.wroteHeader && (status == http.StatusOK || status == http.StatusNotModified) {
+		writer.Header().Set("Cache-Control", writer.value)
+	}
+	writer.wroteHeader = true
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the body, the status code is OK when it is not written before
+func (writer *cacheControlWriter) Write(data []byte) (int, error) {
+	if !writer.wroteHeader {
+		writer.WriteHeader(http.StatusOK)
+	}
+	return writer.ResponseWriter.Write(data)
+}
+
+// idempotent replays the stored response of requests repeated with the same Idempotency-Key header.
+// Server errors and dry runs are not stored, so that the request can be retried.
+func (server *Server) idempotent(resource common.Resource, next http.HandlerFunc) http.HandlerFunc {
+	if server.Cache == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get("Idempotency-Key")
+		if idempotencyKey == "" || isDryRun(r) {
+			next(w, r)
+			return
+		}
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			ERROR(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		requestHash := hash(body)
+
+		key := fmt.Sprintf("idempotency:%s:%s:%s", resource.Name, callerID(r), hash([]byte(idempotencyKey)))
+		pending, _ := json.Marshal(cachedResponse{RequestHash: requestHash})
+		stored, err := server.Cache.SetNX(ctx, key, pending, server.CacheConfig.IdempotencyTTL)
+		if err != nil {
+			logger.Error("Error storing idempotency key", "error", err)
+			ERROR(w, http.StatusServiceUnavailable, fmt.Errorf("idempotency keys are not available"))
+			return
+		}
+		if !stored {
+			value, _, err := server.Cache.Get(ctx, key)
+			var response cachedResponse
+			if err == nil {
+				err = json.Unmarshal(value, &response)
+			}
+			switch {
+			case err != nil:
+				logger.Error("Error reading idempotency key", "error", err)
+				ERROR(w, http.StatusServiceUnavailable, fmt.Errorf("idempotency keys are not available"))
+			case response.RequestHash != requestHash:
+				ERROR(w, http.StatusUnprocessableEntity, WithCode(CODE_IDEMPOTENCY_KEY, fmt.Errorf("idempotency key is already used for a different request")))
+			case response.Status == 0:
+				ERROR(w, http.StatusConflict, fmt.Errorf("request with the same idempotency key is in progress"))
+			default:
+				w.Header().Set("Idempotent-Replayed", "true")
+				response.write(w)
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+		if recorder.status >= http.StatusInternalServerError {
+			err = server.Cache.Delete(ctx, key)
+		} else {
+			response := recorder.response()
+			response.RequestHash = requestHash
+			var value []byte
+			value, err = json.Marshal(response)
+			if err == nil {
+				err = server.Cache.Set(ctx, key, value, server.CacheConfig.IdempotencyTTL)
+			}
+		}
+		if err != nil {
+			logger.Error("Error storing idempotent response", "error", err)
+		}
+	}
+}
+
+// callerID returns the ID of the authenticated user
+func callerID(r *http.Request) string {
+	user, ok := respitectx.CurrentUser(r.Context())
+	if !ok || user == nil {
+		return "anonymous"
+	}
+	return user.ID.String()
+}
+
+// callerKey identifies the caller by the authenticated user, or by the client address of the anonymous callers
+func (server *Server) callerKey(r *http.Request) string {
+	user, ok := respitectx.CurrentUser(r.Context())
+	if ok && user != nil {
+		return "user:" + user.ID.String()
+	}
+	return server.clientAddress(r)
+}
+
+// clientAddress returns the address of the client. The requests of CACHE_TRUSTED_PROXIES are from the last address
+// of X-Forwarded-For that is not a trusted proxy, the header of the other requests is set by the clients themselves.
+func (server *Server) clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	proxies, _ := server.CacheConfig.Proxies()
+	if len(proxies) == 0 || !trusted(proxies, host) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if address == "" {
+			continue
+		}
+		if !trusted(proxies, address) {
+			return address
+		}
+		host = address
+	}
+	return host
+}
+
+// trusted reports if the address is in one of the ranges of the proxies
+func trusted(proxies []netip.Prefix, address string) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// generationKey is incremented on writes, cached responses of older generations are not used anymore
+func generationKey(resourceName string) string {
+	return fmt.Sprintf("generation:%s", resourceName)
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/events"
+	"github.com/gofrs/uuid/v5"
+)
+
+// Changes returns the created, updated and deleted object references of the resource after the since cursor
+func (server *Server) Changes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository := common.GetRequestContext(ctx)
+		if repository == nil {
+			logger.Error("Error reading repository from context")
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+			return
+		}
+		var owner *uuid.UUID
+		if !repository.DBScopes.Global && !getPermissions(r).CanGlobal(repository.Resource.Name) {
+			owner = &repository.DBScopes.User.ID
+		}
+		changes, err := server.Outbox.Changes(ctx, repository.Resource.Name, owner, r.URL.Query().Get("since"), repository.DBScopes.PageSize)
+		if err != nil {
+			logger.Error("Error getting changes", "error", err)
+			if errors.Is(err, events.ErrInvalidCursor) {
+				ERROR(w, http.StatusBadRequest, err)
+				return
+			}
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		logger.Debug("Changes retrieved successfully", "resource", repository.Resource.Name, "count", len(changes.Data))
+		JSON(w, http.StatusOK, changes)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// checkFilters rejects requests with more filter conditions than SERVER_MAX_FILTER_CONDITIONS
+func (server *Server) checkFilters(conditions int) error {
+	maxFilters := server.ServerConfig.MaxFilterConditions
+	if maxFilters > 0 && conditions > maxFilters {
+		return fmt.Errorf("%d filter conditions exceed the maximum of %d", conditions, maxFilters)
+	}
+	return nil
+}
+
+// originConditions counts the region and the labels of the origin filter of list requests
+func originConditions(origin domain.Origin) int {
+	conditions := len(origin.Labels)
+	if origin.Region != "" {
+		conditions++
+	}
+	return conditions
+}
+
+// queryComplexity measures the selections of a GraphQL document
+type queryComplexity struct {
+	resources map[*graphql.Object]bool
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	depth     int
+	relations int
+	filters   int
+	cycle     string
+}
+
+// checkGraphQL rejects GraphQL requests nested deeper than SERVER_MAX_EXPAND_DEPTH, selecting more related
+// objects than SERVER_MAX_RELATIONS or with more filter conditions than SERVER_MAX_FILTER_CONDITIONS.
+// Fragments that spread themselves are rejected, as the validation of the execution does not terminate for them.
+// Documents that cannot be parsed are left to the execution, which reports the syntax errors.
+func (server *Server) checkGraphQL(graphQLSchema graphql.Schema, resources map[*graphql.Object]bool, request graphQLRequest) error {
+	document, err := parser.Parse(parser.ParseParams{Source: request.Query})
+	if err != nil {
+		return nil
+	}
+	complexity := &queryComplexity{
+		resources: resources,
+		fragments: map[string]*ast.FragmentDefinition{},
+		variables: request.Variables,
+	}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			complexity.fragments[fragment.Name.Value] = fragment
+		}
+	}
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok || (request.OperationName != "" && (operation.Name == nil || operation.Name.Value != request.OperationName)) {
+			continue
+		}
+		root := graphQLSchema.QueryType()
+		if operation.Operation == ast.OperationTypeMutation {
+			root = graphQLSchema.MutationType()
+		}
+		complexity.selections(operation.SelectionSet, root, 0, map[string]bool{})
+	}
+
+	if complexity.cycle != "" {
+		return fmt.Errorf("fragment %s spreads itself", complexity.cycle)
+	}
+	config := server.ServerConfig
+	if config.MaxExpandDepth > 0 && complexity.depth > config.MaxExpandDepth {
+		return fmt.Errorf("query depth %d exceeds the maximum of %d", complexity.depth, config.MaxExpandDepth)
+	}
+	if config.MaxRelations > 0 && complexity.relations > config.MaxRelations {
+		return fmt.Errorf("%d selected relations exceed the maximum of %d", complexity.relations, config.MaxRelations)
+	}
+	return server.checkFilters(complexity.filters)
+}
+
+// selections walks the selection set of the parent type, fragments are expanded once per path
+func (complexity *queryComplexity) selections(selectionSet *ast.SelectionSet, parent *graphql.Object, depth int, visiting map[string]bool) {
+	if selectionSet == nil {
+		return
+	}
+	for _, selection := range selectionSet.Selections {
+		switch typed := selection.(type) {
+		case *ast.Field:
+			complexity.field(typed, parent, depth+1, visiting)
+		case *ast.InlineFragment:
+			complexity.selections(typed.SelectionSet, parent, depth, visiting)
+		case *ast.FragmentSpread:
+			name := typed.Name.Value
+			fragment, ok := complexity.fragments[name]
+			if !ok {
+				continue
+			}
+			if visiting[name] {
+				complexity.cycle = name
+				continue
+			}
+			visiting[name] = true
+			complexity.selections(fragment.SelectionSet, parent, depth, visiting)
+			delete(visiting, name)
+		}
+	}
+}
+
+// field counts the depth, the filter conditions and the relation of the field and walks its selections
+func (complexity *queryComplexity) field(field *ast.Field, parent *graphql.Object, depth int, visiting map[string]bool) {
+	complexity.depth = max(complexity.depth, depth)
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != "filter" {
+			continue
+		}
+		switch value := argument.Value.(type) {
+		case *ast.ObjectValue:
+			complexity.filters += len(value.Fields)
+		case *ast.Variable:
+			filter, _ := complexity.variables[value.Name.Value].(map[string]interface{})
+			complexity.filters += len(filter)
+		}
+	}
+	if parent == nil {
+		return
+	}
+	definition, ok := parent.Fields()[field.Name.Value]
+	if !ok {
+		return
+	}
+	object, ok := graphql.GetNamed(definition.Type).(*graphql.Object)
+	if !ok {
+		return
+	}
+	// Objects of a resource nested in another resource are loaded with it
+	if complexity.resources[parent] && complexity.resources[object] {
+		complexity.relations++
+	}
+	complexity.selections(field.SelectionSet, object, depth, visiting)
+}
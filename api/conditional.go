@@ -0,0 +1,47 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/domain"
+)
+
+// errPreconditionFailed is returned when the If-Match header does not match the current version of the object
+var errPreconditionFailed = errors.New("precondition failed")
+
+// ETag returns the entity tag of the version of the object, it changes with every update of the object
+func ETag(object domain.Object) string {
+	hash := sha256.New()
+	hash.Write(object.GetID().Bytes())
+	if updatedAt := object.GetUpdatedAt(); updatedAt != nil {
+		hash.Write([]byte(updatedAt.UTC().Format(time.RFC3339Nano)))
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// ifMatch checks the If-Match header against the entity tag of the object, requests without it match.
+// Weak tags never match, as If-Match requires the strong comparison.
+func ifMatch(r *http.Request, object domain.Object) error {
+	return matchTag(r, ETag(object))
+}
+
+// matchTag checks the If-Match header against the current entity tag, requests without it match
+func matchTag(r *http.Request, current string) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s does not match the current version %s", errPreconditionFailed, header, current)
+}
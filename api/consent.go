@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// ErrConsentRequired is returned for the mutations of users that did not accept the current versions of the
+// documents of CONSENT_DOCUMENTS
+var ErrConsentRequired = errors.New("consent required")
+
+// ConsentStatus is the acceptance of the current version of a document by the caller
+type ConsentStatus struct {
+	Document   string     `json:"document"`
+	Version    string     `json:"version"`
+	Accepted   bool       `json:"accepted"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+// consentStatus returns the acceptance of the current documents by the user, ordered by document
+func (server *Server) consentStatus(ctx context.Context, user *domain.User) ([]ConsentStatus, error) {
+	var consents []domain.Consent
+	err := server.DB.WithContext(ctx).Where("user_id = ?", user.ID).Find(&consents).Error
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]ConsentStatus, 0, len(server.ConsentConfig.Documents))
+	for document, version := range server.ConsentConfig.Documents {
+		status := ConsentStatus{Document: document, Version: version}
+		for _, consent := range consents {
+			if consent.Document == document && consent.Version == version {
+				status.Accepted = true
+				status.AcceptedAt = &consent.AcceptedAt
+				break
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Document < statuses[j].Document
+	})
+	return statuses, nil
+}
+
+// checkConsent returns ErrConsentRequired with the documents whose current version the user did not accept.
+// Callers without a user, e.g. in the development mode, and servers without documents are not checked.
+func (server *Server) checkConsent(ctx context.Context, user *domain.User) error {
+	if len(server.ConsentConfig.Documents) == 0 || user == nil {
+		return nil
+	}
+	statuses, err := server.consentStatus(ctx, user)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, status := range statuses {
+		if !status.Accepted {
+			missing = append(missing, fmt.Sprintf("%s version %s", status.Document, status.Version))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: accept %s with POST /%s/me/consents", ErrConsentRequired, strings.Join(missing, ", "), server.ServerConfig.APIPath)
+	}
+	return nil
+}
+
+// consentRequired writes the error of mutations without the consents and returns true, or returns false when the
+// caller accepted the current documents
+func (server *Server) consentRequired(w http.ResponseWriter, r *http.Request) bool {
+	ctx := r.Context()
+	user, _ := respitectx.CurrentUser(ctx)
+	err := server.checkConsent(ctx, user)
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrConsentRequired) {
+		common.GetLogger(ctx).Debug("Mutation without consent", "error", err)
+		ERROR(w, http.StatusForbidden, WithCode(CODE_CONSENT, err))
+		return true
+	}
+	common.GetLogger(ctx).Error("Error checking consent", "error", err)
+	ERROR(w, repositoryStatus(err), err)
+	return true
+}
+
+// MyConsents returns the current documents and whether the caller accepted them
+func (server *Server) MyConsents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, ok := respitectx.CurrentUser(ctx)
+		if !ok || user == nil {
+			ERROR(w, http.StatusUnauthorized, errors.New("unauthorized, no user"))
+			return
+		}
+		statuses, err := server.consentStatus(ctx, user)
+		if err != nil {
+			common.GetLogger(ctx).Error("Error loading consents", "error", err)
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		JSON(w, http.StatusOK, statuses)
+	}
+}
+
+// AcceptConsent records the acceptance of the current version of a document by the caller, e.g.
+// {"document": "terms", "version": "2024-05"}. Accepting a version again returns the recorded consent.
+func (server *Server) AcceptConsent() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		user, ok := respitectx.CurrentUser(ctx)
+		if !ok || user == nil {
+			ERROR(w, http.StatusUnauthorized, errors.New("unauthorized, no user"))
+			return
+		}
+		consent := &domain.Consent{}
+		err := json.NewDecoder(r.Body).Decode(consent)
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		version, ok := server.ConsentConfig.Documents[consent.Document]
+		if !ok {
+			ERROR(w, http.StatusUnprocessableEntity, fmt.Errorf("unknown document %q", consent.Document))
+			return
+		}
+		if consent.Version != version {
+			ERROR(w, http.StatusUnprocessableEntity, fmt.Errorf("version %q of %s is not the current version %s", consent.Version, consent.Document, version))
+			return
+		}
+		existing := &domain.Consent{}
+		err = server.DB.WithContext(ctx).Where("user_id = ? AND document = ? AND version = ?", user.ID, consent.Document, consent.Version).First(existing).Error
+		if err == nil {
+			JSON(w, http.StatusOK, existing)
+			return
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error("Error loading consent", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		consent.ID = uuid.Must(uuid.NewV4())
+		consent.UserID = user.ID
+		consent.AcceptedAt = time.Now().UTC()
+		err = server.DB.WithContext(ctx).Create(consent).Error
+		if err != nil {
+			logger.Error("Error recording consent", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		logger.Info("Consent recorded", "userID", user.ID, "document", consent.Document, "version", consent.Version)
+		JSON(w, http.StatusCreated, consent)
+	}
+}
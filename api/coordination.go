@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/coordination"
+)
+
+// OUTBOX_LOCK is the name of the lock of the leader relaying the outbox
+const OUTBOX_LOCK = "respite.outbox"
+
+// WithCoordination configures the coordination of the replicas, the leader election of the outbox relay and the
+// registry of the instances
+func WithCoordination(coordinationConfig cfg.Coordination) Option {
+	return func(server *Server) {
+		server.CoordinationConfig = coordinationConfig
+	}
+}
+
+// configureElector adapts the leader election to the database, the lock is held in a transaction behind poolers in
+// transaction mode, as a lease on CockroachDB, and not at all in the development mode
+func (server *Server) configureElector(elector *coordination.Elector) {
+	elector.Standalone = server.devMode
+	elector.TransactionLock = server.dbConfig.TransactionPooling
+	elector.Lease = server.dbConfig.Backend == "cockroachdb"
+}
+
+// initInstances creates the registry of the instances if configured
+func (server *Server) initInstances() {
+	if !server.CoordinationConfig.Instances || server.DB == nil {
+		return
+	}
+	server.Instances = coordination.NewRegistry(server.DB, server.CoordinationConfig.HeartbeatInterval)
+}
+
+// ListInstances returns the instances of the deployment that are alive
+func (server *Server) ListInstances() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		instances, err := server.Instances.Instances(ctx)
+		if err != nil {
+			logger.Error("Error loading instances", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, instances)
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/events"
+	"github.com/dzahariev/respite/scheduler"
+)
+
+// COUNTERS_TASK is the scheduled task correcting the counters that differ from the counts
+const COUNTERS_TASK = "counters"
+
+// WithCounters configures the reconciliation of the counter fields of the resources, see domain.CountedObject
+func WithCounters(countersConfig cfg.Counters) Option {
+	return func(server *Server) {
+		server.CountersConfig = countersConfig
+	}
+}
+
+// validateCounters reports the counters of the resources that count unknown resources or fields, and the counters
+// of the objects of repositories, as the counters are updated in the database
+func (server *Server) validateCounters() error {
+	var problems []error
+	for _, resource := range server.Resources.Resources {
+		for _, counter := range resource.Counters {
+			counted, ok := server.Resources.Resources[counter.Counted.Resource]
+			if !ok {
+				problems = append(problems, fmt.Errorf("resource %s: counter %s counts unknown resource %s", resource.Name, counter.Field, counter.Counted.Resource))
+				continue
+			}
+			if _, ok := domain.JSONField(counted.Type, counter.Counted.Field); !ok {
+				problems = append(problems, fmt.Errorf("resource %s: counter %s counts by unknown field %s of %s", resource.Name, counter.Field, counter.Counted.Field, counted.Name))
+			}
+			if server.Repository != nil {
+				problems = append(problems, fmt.Errorf("resource %s: counter %s requires the database, the objects are kept in the repository", resource.Name, counter.Field))
+			}
+		}
+	}
+	err := errors.Join(problems...)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+// initCounters schedules the reconciliation of the counters, when the resources have counters
+func (server *Server) initCounters() error {
+	if server.CountersConfig.ReconcileInterval <= 0 || server.Repository != nil {
+		return nil
+	}
+	for _, resource := range server.Resources.Resources {
+		if len(resource.Counters) > 0 {
+			return server.Scheduler.Register(COUNTERS_TASK, "@every "+server.CountersConfig.ReconcileInterval.String(), server.reconcileCounters)
+		}
+	}
+	return nil
+}
+
+// reconcileCounters corrects the counters that differ from the counts
+func (server *Server) reconcileCounters(ctx context.Context, taskContext *scheduler.TaskContext) error {
+	corrected, err := server.Resources.Reconcile(ctx, taskContext.DB)
+	if err != nil {
+		return err
+	}
+	if corrected > 0 {
+		common.GetLogger(ctx).Info("Counters reconciled", "objects", corrected)
+	}
+	return nil
+}
+
+// counterPublisher recounts the objects that the objects of the mutation events refer to, for the counters updated
+// from the events. The updates of the objects that refer to other objects are recounted for the current objects,
+// the previous ones are corrected by the reconciliation.
+type counterPublisher struct {
+	server *Server
+}
+
+// Publish recounts the objects that the object of the event refers to
+func (publisher counterPublisher) Publish(ctx context.Context, event events.Event) error {
+	resources := publisher.server.Resources
+	if resources == nil || len(event.Data) == 0 {
+		return nil
+	}
+	var countings []common.Counting
+	for _, counting := range resources.Counting(event.Resource) {
+		if !counting.Counter.InTransaction() {
+			countings = append(countings, counting)
+		}
+	}
+	if len(countings) == 0 {
+		return nil
+	}
+	object, err := resources.New(event.Resource)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(event.Data, object)
+	if err != nil {
+		return fmt.Errorf("cannot decode %s event %s: %w", event.Resource, event.ID, err)
+	}
+	var errs []error
+	for _, counting := range countings {
+		err = resources.Recount(ctx, publisher.server.DB, counting.Resource, counting.Counter, common.CountedIDs(counting.Counter, object))
+		if err != nil {
+			common.GetLogger(ctx).Error("Error recounting from event", "event", event.ID, "type", event.Type, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close does nothing
+func (publisher counterPublisher) Close() error {
+	return nil
+}
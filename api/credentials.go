@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/jackc/pgx/v5"
+)
+
+// CREDENTIALS is the resource name of the permission required to rotate the credentials, e.g. credentials.write
+const CREDENTIALS = "credentials"
+
+// secretFiles are the variables that are read again when the credentials are rotated
+var secretFiles = []string{"DB_USER", "DB_PASSWORD", "AUTH_CLIENT_SECRET"}
+
+// dbCredentials are set on each new database connection
+type dbCredentials struct {
+	user     string
+	password string
+}
+
+// apply sets the credentials on the connection configuration, empty values keep the configured ones
+func (credentials dbCredentials) apply(config *pgx.ConnConfig) {
+	if credentials.user != "" {
+		config.User = credentials.user
+	}
+	if credentials.password != "" {
+		config.Password = credentials.password
+	}
+}
+
+// current checks if a connection was opened with the credentials
+func (credentials dbCredentials) current(config *pgx.ConnConfig) bool {
+	return (credentials.user == "" || credentials.user == config.User) &&
+		(credentials.password == "" || credentials.password == config.Password)
+}
+
+// rotateDBCredentials verifies that a connection can be opened with the new credentials and switches to them.
+// Connections in use are not interrupted, pooled ones are replaced when they are taken from the pool.
+func (server *Server) rotateDBCredentials(ctx context.Context, credentials dbCredentials) error {
+	if server.dbConnConfig == nil {
+		return errors.New("database connection is given to the server, credentials cannot be rotated")
+	}
+	config := server.dbConnConfig.Copy()
+	credentials.apply(config)
+	if server.dbFailover != nil {
+		server.dbFailover.apply(config)
+	}
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return fmt.Errorf("cannot connect to database with the rotated credentials: %w", err)
+	}
+	conn.Close(ctx)
+	server.dbCredentials.Store(credentials)
+	slog.Info("Database credentials rotated", "user", config.User)
+	return nil
+}
+
+// secretFilesConfigured checks if any of the rotated secrets is read from a file
+func secretFilesConfigured() bool {
+	for _, variable := range secretFiles {
+		if _, ok := os.LookupEnv(variable + "_FILE"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// watchSecrets rotates the secrets when their files change until the context is cancelled
+func (server *Server) watchSecrets(ctx context.Context) {
+	ticker := time.NewTicker(server.credentialsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := server.RotateSecrets(ctx)
+			if err != nil {
+				slog.Error("Error rotating secrets", "error", err)
+			}
+		}
+	}
+}
+
+// RotateCredentials reads the secret files again and rotates the changed credentials, its route requires the
+// credentials.write permission
+func (server *Server) RotateCredentials() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		err := server.RotateSecrets(ctx)
+		if err != nil {
+			logger.Error("Error rotating credentials", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// CSP_REPORT_PATH is the path of the collection of the reports of the violations of the policy
+	CSP_REPORT_PATH = "/csp-report"
+	// cspEndpoint is the name of the reporting endpoint of the report-to directive
+	cspEndpoint = "csp-endpoint"
+	// cspReportsLimit is the maximum of reports listed by the admin route
+	cspReportsLimit = 1000
+)
+
+// WithCSP sets the Content Security Policy of the static files and collects the reports of its violations
+func WithCSP(cspConfig cfg.CSP) Option {
+	return func(server *Server) {
+		server.CSPConfig = cspConfig
+	}
+}
+
+// cspHeader returns the header of the policy and its value, the policy reports to the collection when it is
+// enabled and the policy has no other report-uri
+func (server *Server) cspHeader() (string, string) {
+	name := "Content-Security-Policy"
+	if server.CSPConfig.ReportOnly {
+		name = "Content-Security-Policy-Report-Only"
+	}
+	policy := strings.TrimSuffix(strings.TrimSpace(server.CSPConfig.Policy), ";")
+	if server.CSPConfig.Reports && !strings.Contains(policy, "report-uri") {
+		policy += fmt.Sprintf("; report-uri %s; report-to %s", CSP_REPORT_PATH, cspEndpoint)
+	}
+	return name, policy
+}
+
+// withCSP sets the policy on the responses of the static files
+func (server *Server) withCSP(next http.Handler) http.Handler {
+	if server.CSPConfig.Policy == "" {
+		return next
+	}
+	name, policy := server.cspHeader()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(name, policy)
+		if server.CSPConfig.Reports {
+			w.Header().Set("Reporting-Endpoints", fmt.Sprintf(`%s="%s"`, cspEndpoint, CSP_REPORT_PATH))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cspViolation is the report of the report-uri directive, sent with the application/csp-report content type
+type cspViolation struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// cspReport is a report of the Reporting API of the report-to directive, sent in a list with the
+// application/reports+json content type
+type cspReport struct {
+	Type      string `json:"type"`
+	UserAgent string `json:"user_agent"`
+	Body      struct {
+		DocumentURL        string `json:"documentURL"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+// parseCSPReports reads the reports of both formats, the reports of the Reporting API that are not CSP
+// violations are skipped
+func parseCSPReports(body []byte, userAgent string) ([]domain.CSPReport, error) {
+	now := domain.Now()
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		var reports []cspReport
+		err := json.Unmarshal(body, &reports)
+		if err != nil {
+			return nil, err
+		}
+		var parsed []domain.CSPReport
+		for _, report := range reports {
+			if report.Type != "csp-violation" {
+				continue
+			}
+			parsed = append(parsed, domain.CSPReport{
+				ID:                 uuid.Must(uuid.NewV4()),
+				ReceivedAt:         now,
+				DocumentURL:        report.Body.DocumentURL,
+				BlockedURL:         report.Body.BlockedURL,
+				EffectiveDirective: report.Body.EffectiveDirective,
+				Disposition:        report.Body.Disposition,
+				SourceFile:         report.Body.SourceFile,
+				LineNumber:         report.Body.LineNumber,
+				ColumnNumber:       report.Body.ColumnNumber,
+				Sample:             report.Body.Sample,
+				UserAgent:          report.UserAgent,
+			})
+		}
+		return parsed, nil
+	}
+	var violation cspViolation
+	err := json.Unmarshal(body, &violation)
+	if err != nil {
+		return nil, err
+	}
+	report := violation.Report
+	directive := report.EffectiveDirective
+	if directive == "" {
+		directive = report.ViolatedDirective
+	}
+	return []domain.CSPReport{{
+		ID:                 uuid.Must(uuid.NewV4()),
+		ReceivedAt:         now,
+		DocumentURL:        report.DocumentURI,
+		BlockedURL:         report.BlockedURI,
+		EffectiveDirective: directive,
+		Disposition:        report.Disposition,
+		SourceFile:         report.SourceFile,
+		LineNumber:         report.LineNumber,
+		ColumnNumber:       report.ColumnNumber,
+		Sample:             report.ScriptSample,
+		UserAgent:          userAgent,
+	}}, nil
+}
+
+// CollectCSPReports receives the reports of the violations of the policy from the browsers without a token. The
+// reports are logged, stored with CSP_STORE and forwarded to CSP_FORWARD_URL. The browsers are limited per
+// client address by CACHE_PUBLIC_RATE_LIMIT.
+func (server *Server) CollectCSPReports() http.HandlerFunc {
+	maxSize := server.CSPConfig.MaxReportSize
+	if maxSize <= 0 {
+		maxSize = 64 << 10
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		limit := server.CacheConfig.PublicRateLimit
+		if server.Cache != nil && limit > 0 && server.limited(w, r, "ratelimit:csp:"+server.clientAddress(r), limit, 1, "X-RateLimit-Public") {
+			ERROR(w, http.StatusTooManyRequests, fmt.Errorf("rate limit of %d reports per %s exceeded", limit, server.CacheConfig.RateLimitWindow))
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSize))
+		if err != nil {
+			ERROR(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		reports, err := parseCSPReports(body, r.UserAgent())
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, fmt.Errorf("invalid CSP report: %w", err))
+			return
+		}
+		for _, report := range reports {
+			logger.Warn("Content Security Policy violation", "document", report.DocumentURL, "blocked", report.BlockedURL, "directive", report.EffectiveDirective, "disposition", report.Disposition)
+		}
+		if server.CSPConfig.Store && len(reports) > 0 {
+			err = server.DB.WithContext(ctx).Create(&reports).Error
+			if err != nil {
+				logger.Error("Error storing CSP reports", "error", err)
+			}
+		}
+		if server.CSPConfig.ForwardURL != "" && len(reports) > 0 {
+			err = server.forwardCSPReports(ctx, r.Header.Get("Content-Type"), body)
+			if err != nil {
+				logger.Error("Error forwarding CSP reports", "url", server.CSPConfig.ForwardURL, "error", err)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// forwardCSPReports sends the reports to CSP_FORWARD_URL as they were received
+func (server *Server) forwardCSPReports(ctx context.Context, contentType string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, server.CSPConfig.ForwardURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	response, err := server.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// ListCSPReports returns the latest stored reports, the newest first, received since the optional from parameter
+func (server *Server) ListCSPReports() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := server.DB.WithContext(ctx).Order("received_at DESC").Limit(cspReportsLimit)
+		if from := r.URL.Query().Get("from"); from != "" {
+			since, err := time.Parse(time.RFC3339, from)
+			if err != nil {
+				ERROR(w, http.StatusBadRequest, fmt.Errorf("from: %w", err))
+				return
+			}
+			query = query.Where("received_at >= ?", since)
+		}
+		reports := []domain.CSPReport{}
+		err := query.Find(&reports).Error
+		if err != nil {
+			common.GetLogger(ctx).Error("Error loading CSP reports", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, reports)
+	}
+}
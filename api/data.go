@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+)
+
+// DataUsage is the usage of the data commands, e.g. for flag.ErrHelp returned by Data
+const DataUsage = `Usage:
+  data list <resource> [--page n] [--page-size n]
+  data get <resource> <id>
+  data create <resource> [file] [--owner id]
+  data delete <resource> <id>
+
+The object of create is read from the file, or from the standard input without it or with -.
+`
+
+// DataUser is the service identity of the data commands, it owns the objects they create without --owner
+var DataUser = domain.User{
+	Base:             domain.Base{ID: uuid.NewV5(uuid.NamespaceURL, "https://github.com/dzahariev/respite/data")},
+	PreferedUserName: "respite-data",
+}
+
+// Data runs a data command on the resources without the API, e.g. `data list meal`, so that operators do one-off
+// fixes without hand-written SQL. The commands run as DataUser with the read, write and global permissions of
+// every resource, the validation, the quotas, the webhooks and the events apply as for the requests. The objects
+// and lists are written to out as JSON, flag.ErrHelp is returned for the unknown commands, see DataUsage.
+func (server *Server) Data(ctx context.Context, args []string, in io.Reader, out io.Writer) error {
+	flagSet := flag.NewFlagSet("data", flag.ContinueOnError)
+	flagSet.SetOutput(io.Discard)
+	page := flagSet.Int("page", 1, "page of the list")
+	pageSize := flagSet.Int("page-size", common.MaxPageSize, "size of the page of the list")
+	owner := flagSet.String("owner", "", "ID of the user owning the created object")
+	args, err := parseInterspersed(flagSet, args)
+	if err != nil || len(args) < 2 {
+		return flag.ErrHelp
+	}
+	command, resourceName := args[0], args[1]
+	resource, ok := server.Resources.Resources[resourceName]
+	if !ok {
+		return fmt.Errorf("unrecognized resource name: %s", resourceName)
+	}
+
+	dataUser := DataUser
+	user := &dataUser
+	store := fixturesStore{server: server}
+	err = store.SaveUser(ctx, user)
+	if err != nil {
+		return fmt.Errorf("cannot save the data user: %w", err)
+	}
+	if *owner != "" {
+		user, err = server.DBLoadUser(ctx, *owner)
+		if err != nil {
+			return fmt.Errorf("cannot load owner %s: %w", *owner, err)
+		}
+	}
+	var permissions []string
+	for name := range server.Resources.Resources {
+		permissions = append(permissions, name+"."+READ, name+"."+WRITE, name+"."+common.GLOBAL)
+	}
+	ctx = respitectx.WithCurrentUser(ctx, user)
+	ctx = respitectx.WithPermissions(ctx, common.Permissions(permissions))
+	*page, *pageSize = common.NormalizePage(*page, *pageSize)
+	requestContext := server.newRequestContextWithDetails(ctx, *pageSize, *page, (*page-1)**pageSize, user, resource, permissions)
+	ctx = respitectx.WithRequestContext(ctx, requestContext)
+
+	id := func() (uuid.UUID, error) {
+		if len(args) < 3 {
+			return uuid.Nil, fmt.Errorf("%s requires the ID of the object", command)
+		}
+		return uuid.FromString(args[2])
+	}
+	var result any
+	switch command {
+	case "list":
+		result, err = requestContext.GetAll(ctx)
+	case "get":
+		var uid uuid.UUID
+		uid, err = id()
+		if err == nil {
+			result, err = requestContext.Get(ctx, uid)
+		}
+	case "create":
+		var body []byte
+		body, err = readData(args[2:], in)
+		if err == nil {
+			result, err = requestContext.Create(ctx, body)
+		}
+	case "delete":
+		var uid uuid.UUID
+		uid, err = id()
+		if err == nil {
+			err = requestContext.Delete(ctx, uid)
+		}
+		if err == nil {
+			common.GetLogger(ctx).Info("Object deleted", "resource", resource.Name, "id", uid)
+			return nil
+		}
+	default:
+		return flag.ErrHelp
+	}
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// readData reads the object of create from the file of the arguments or from in
+func readData(args []string, in io.Reader) ([]byte, error) {
+	if len(args) == 0 || args[0] == "-" {
+		return io.ReadAll(in)
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the object: %w", err)
+	}
+	return data, nil
+}
+
+// parseInterspersed parses the flags given anywhere between the positional arguments and returns the positional ones
+func parseInterspersed(flagSet *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		args = flagSet.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+)
+
+// Deprecated announces the deprecation of the route with the Deprecation, Sunset and Link headers of its responses
+// and counts its requests in the metrics. Routes that are gone respond with 410 Gone after the sunset. Custom
+// routes are deprecated with it too, e.g.
+//
+//	server.Router.HandleFunc("/api/v1/report", server.Deprecated(deprecation, report)).Methods(http.MethodGet)
+func (server *Server) Deprecated(deprecation domain.Deprecation, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", deprecation.DeprecationHeader())
+		if !deprecation.Sunset.IsZero() {
+			w.Header().Set("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if deprecation.Link != "" {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, deprecation.Link))
+		}
+		if server.Metrics != nil {
+			server.Metrics.CountDeprecated(r)
+		}
+		if deprecation.IsGone(time.Now()) {
+			w.Header().Set("Content-Type", "application/json")
+			ERROR(w, http.StatusGone, fmt.Errorf("%s was removed on %s", r.URL.Path, deprecation.Sunset.UTC().Format(time.DateOnly)))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// deprecated deprecates the routes of the deprecated resources, the routes of the other resources are not changed
+func (server *Server) deprecated(resource common.Resource, next http.HandlerFunc) http.HandlerFunc {
+	if resource.Deprecation == nil {
+		return next
+	}
+	return server.Deprecated(*resource.Deprecation, next)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+// docsTemplate is the API console page, Swagger UI is loaded from SERVER_DOCS_ASSETS_URL
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" href="{{.Assets}}/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="{{.Assets}}/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: {{.Document}}, dom_id: "#swagger-ui", persistAuthorization: true});
+  </script>
+</body>
+</html>
+`))
+
+// docsAccess protects the API console and the OpenAPI document unless SERVER_DOCS_PUBLIC is enabled
+func (server *Server) docsAccess(next http.HandlerFunc) http.HandlerFunc {
+	if server.ServerConfig.DocsPublic {
+		return server.Public(next)
+	}
+	return server.Authenticated(next)
+}
+
+// Docs returns the API console showing the OpenAPI document, requests are sent with the token given in the console
+func (server *Server) Docs() http.HandlerFunc {
+	var page bytes.Buffer
+	err := docsTemplate.Execute(&page, struct {
+		Title    string
+		Assets   string
+		Document string
+	}{
+		Title:    server.ServerConfig.APITitle,
+		Assets:   server.ServerConfig.DocsAssetsURL,
+		Document: fmt.Sprintf("/%s/openapi.json", server.ServerConfig.APIPath),
+	})
+	if err != nil {
+		slog.Error("Error rendering API console", "error", err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(page.Bytes())
+	}
+}
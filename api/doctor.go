@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/schema"
+)
+
+// Statuses of the doctor checks
+const (
+	CHECK_OK      = "ok"
+	CHECK_WARNING = "warning"
+	CHECK_FAILED  = "failed"
+	CHECK_SKIPPED = "skipped"
+)
+
+// doctorTimeout limits each connectivity check
+const doctorTimeout = 10 * time.Second
+
+// DoctorFlag registers the --doctor flag that checks the deployment instead of starting the server
+func DoctorFlag(flagSet *flag.FlagSet) *bool {
+	return flagSet.Bool("doctor", false, "check the database, Keycloak, tables and permissions, print a report and exit")
+}
+
+// DoctorCheck is the result of a check
+type DoctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// DoctorReport lists the results of the checks
+type DoctorReport struct {
+	Checks []DoctorCheck `json:"checks"`
+}
+
+// add appends the result of a check
+func (report *DoctorReport) add(name, status, format string, args ...any) {
+	report.Checks = append(report.Checks, DoctorCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+// Failed reports if any check failed
+func (report *DoctorReport) Failed() bool {
+	return slices.ContainsFunc(report.Checks, func(check DoctorCheck) bool { return check.Status == CHECK_FAILED })
+}
+
+// Write prints the report with a line per check and a summary
+func (report *DoctorReport) Write(w io.Writer) error {
+	width := 0
+	counts := map[string]int{}
+	for _, check := range report.Checks {
+		width = max(width, len(check.Name))
+		counts[check.Status]++
+	}
+	for _, check := range report.Checks {
+		_, err := fmt.Fprintf(w, "[%-7s] %-*s  %s\n", check.Status, width, check.Name, check.Message)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d ok, %d warnings, %d failed, %d skipped\n", counts[CHECK_OK], counts[CHECK_WARNING], counts[CHECK_FAILED], counts[CHECK_SKIPPED])
+	return err
+}
+
+// Doctor checks the connectivity to the database and Keycloak, that the tables of the resources exist with
+// their columns, and that the roles grant permissions of registered resources. Misconfigured deployments are
+// reported instead of failing during requests.
+func (server *Server) Doctor(ctx context.Context) *DoctorReport {
+	report := &DoctorReport{}
+	databaseAvailable := server.doctorDatabase(ctx, report)
+	server.doctorKeycloak(ctx, report)
+	server.doctorTables(ctx, report, databaseAvailable)
+	server.doctorPermissions(report)
+	return report
+}
+
+// doctorDatabase pings the database and reports if it is available
+func (server *Server) doctorDatabase(ctx context.Context, report *DoctorReport) bool {
+	if server.DB == nil {
+		report.add("database", CHECK_SKIPPED, "resources are kept in the %T repository", server.Repository)
+		return false
+	}
+	sqlDB, err := server.DB.DB()
+	if err == nil {
+		pingCtx, cancel := context.WithTimeout(ctx, doctorTimeout)
+		defer cancel()
+		err = sqlDB.PingContext(pingCtx)
+	}
+	if err != nil {
+		report.add("database", CHECK_FAILED, "cannot connect to %s: %v", server.DB.Dialector.Name(), err)
+		return false
+	}
+	report.add("database", CHECK_OK, "connected to %s", server.DB.Dialector.Name())
+	return true
+}
+
+// doctorKeycloak checks the realm and the client credentials
+func (server *Server) doctorKeycloak(ctx context.Context, report *DoctorReport) {
+	switch {
+	case server.devMode:
+		report.add("keycloak", CHECK_SKIPPED, "requests are not authenticated in the development mode")
+	case server.keycloakClient == nil:
+		report.add("keycloak", CHECK_SKIPPED, "tokens are verified by %T", server.AuthClient)
+	default:
+		checkCtx, cancel := context.WithTimeout(ctx, doctorTimeout)
+		defer cancel()
+		err := server.keycloakClient.Check(checkCtx)
+		if err != nil {
+			report.add("keycloak", CHECK_FAILED, "%v", err)
+			return
+		}
+		report.add("keycloak", CHECK_OK, "realm %s at %s accepts client %s", server.keycloakClient.Realm, server.keycloakClient.URL, server.keycloakClient.ClientID)
+	}
+}
+
+// doctorTables checks that the table of every resource exists with the columns of its fields
+func (server *Server) doctorTables(ctx context.Context, report *DoctorReport, databaseAvailable bool) {
+	names := server.Resources.Names()
+	slices.Sort(names)
+	for _, name := range names {
+		checkName := "table " + name
+		if !databaseAvailable {
+			report.add(checkName, CHECK_SKIPPED, "database is not available")
+			continue
+		}
+		object := reflect.New(server.Resources.Resources[name].Type).Interface()
+		resourceSchema, err := schema.Parse(object, &sync.Map{}, server.DB.NamingStrategy)
+		if err != nil {
+			report.add(checkName, CHECK_FAILED, "cannot map to a table: %v", err)
+			continue
+		}
+		if server.Resources.Resources[name].SQLView != nil {
+			server.doctorSQLView(ctx, report, checkName, resourceSchema)
+			continue
+		}
+		migrator := server.DB.WithContext(ctx).Migrator()
+		if !migrator.HasTable(object) {
+			report.add(checkName, CHECK_FAILED, "table %s does not exist", resourceSchema.Table)
+			continue
+		}
+		var missing []string
+		for _, column := range resourceSchema.DBNames {
+			if !migrator.HasColumn(object, column) {
+				missing = append(missing, column)
+			}
+		}
+		if len(missing) > 0 {
+			report.add(checkName, CHECK_FAILED, "table %s has no columns %s", resourceSchema.Table, strings.Join(missing, ", "))
+			continue
+		}
+		report.add(checkName, CHECK_OK, "table %s has %d columns", resourceSchema.Table, len(resourceSchema.DBNames))
+	}
+}
+
+// doctorSQLView checks that the SQL view of the resource returns the columns of its fields, the migrators of the
+// databases do not see the views as tables
+func (server *Server) doctorSQLView(ctx context.Context, report *DoctorReport, checkName string, resourceSchema *schema.Schema) {
+	rows, err := server.DB.WithContext(ctx).Table(resourceSchema.Table).Limit(0).Rows()
+	if err != nil {
+		report.add(checkName, CHECK_FAILED, "view %s cannot be read: %v", resourceSchema.Table, err)
+		return
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		report.add(checkName, CHECK_FAILED, "view %s cannot be read: %v", resourceSchema.Table, err)
+		return
+	}
+	var missing []string
+	for _, column := range resourceSchema.DBNames {
+		if !slices.Contains(columns, column) {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		report.add(checkName, CHECK_FAILED, "view %s has no columns %s", resourceSchema.Table, strings.Join(missing, ", "))
+		return
+	}
+	report.add(checkName, CHECK_OK, "view %s has %d columns", resourceSchema.Table, len(resourceSchema.DBNames))
+}
+
+// doctorPermissions checks that the permissions of the roles are resource.permission of registered resources,
+// including the grants of the database
+func (server *Server) doctorPermissions(report *DoctorReport) {
+	roleToPermissions := server.RoleToPermissions
+	if server.Permissions != nil {
+		roleToPermissions = server.Permissions.Roles()
+	}
+	if len(roleToPermissions) == 0 {
+		report.add("permissions", CHECK_WARNING, "no roles are mapped to permissions, all requests are denied")
+		return
+	}
+	roles := make([]string, 0, len(roleToPermissions))
+	for role := range roleToPermissions {
+		roles = append(roles, role)
+	}
+	slices.Sort(roles)
+	for _, role := range roles {
+		checkName := "role " + role
+		var unknownResources, unknownPermissions []string
+		for _, rolePermission := range roleToPermissions[role] {
+			resourceName, permission, ok := strings.Cut(strings.ToLower(rolePermission), ".")
+			_, registered := server.Resources.Resources[resourceName]
+			if !ok || (!registered && resourceName != ADMIN && resourceName != CREDENTIALS && resourceName != QUERIES) {
+				unknownResources = append(unknownResources, rolePermission)
+				continue
+			}
+			if !slices.Contains(roleActions, permission) {
+				unknownPermissions = append(unknownPermissions, rolePermission)
+			}
+		}
+		switch {
+		case len(unknownResources) > 0:
+			report.add(checkName, CHECK_FAILED, "permissions of unknown resources %s", strings.Join(unknownResources, ", "))
+		case len(unknownPermissions) > 0:
+			report.add(checkName, CHECK_WARNING, "unknown permissions %s", strings.Join(unknownPermissions, ", "))
+		default:
+			report.add(checkName, CHECK_OK, "%d permissions", len(roleToPermissions[role]))
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// trackRequests counts the requests in flight and closes the connections of the responses while the server drains,
+// so that the clients reconnect to the other instances. The hijacked connections, e.g. the WebSockets of the
+// subscriptions, are not waited for by the shutdown, so they are counted apart from the requests until they close.
+func (server *Server) trackRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.inFlight.Add(1)
+		writer := &trackedWriter{ResponseWriter: w, server: server}
+		defer writer.done()
+		if server.draining.Load() {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// trackedWriter stops counting the request in flight once its connection is hijacked
+type trackedWriter struct {
+	http.ResponseWriter
+	server   *Server
+	hijacked bool
+}
+
+// done stops counting the request in flight unless its connection was hijacked
+func (writer *trackedWriter) done() {
+	if !writer.hijacked {
+		writer.server.inFlight.Add(-1)
+	}
+}
+
+// Hijack hijacks the connection and counts it as hijacked instead of in flight until it is closed
+func (writer *trackedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buffer, err := http.NewResponseController(writer.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	writer.hijacked = true
+	writer.server.inFlight.Add(-1)
+	writer.server.hijacked.Add(1)
+	return &trackedConn{Conn: conn, server: writer.server}, buffer, nil
+}
+
+// Flush sends the buffered response, e.g. of the event streams
+func (writer *trackedWriter) Flush() {
+	http.NewResponseController(writer.ResponseWriter).Flush()
+}
+
+// Unwrap returns the original writer for the response controllers
+func (writer *trackedWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+// trackedConn is a hijacked connection that stops being counted when it is closed
+type trackedConn struct {
+	net.Conn
+	server *Server
+	once   sync.Once
+}
+
+// Close closes the connection and stops counting it
+func (conn *trackedConn) Close() error {
+	conn.once.Do(func() {
+		conn.server.hijacked.Add(-1)
+	})
+	return conn.Conn.Close()
+}
+
+// startDraining fails the readiness and keeps serving for SERVER_SHUTDOWN_DELAY, so that the load balancers stop
+// routing requests to the instance before it stops accepting them
+func (server *Server) startDraining() {
+	server.draining.Store(true)
+	delay := server.ServerConfig.ShutdownDelay
+	if delay <= 0 {
+		return
+	}
+	slog.Info("Draining before shutdown", "delay", delay, "inFlight", server.inFlight.Load(), "hijacked", server.hijacked.Load())
+	time.Sleep(delay)
+}
+
+// stopServing stops accepting requests and waits for the requests in flight until the deadline of the context, the
+// requests that are still in flight then are dropped and counted
+func (server *Server) stopServing(ctx context.Context, srv *http.Server, grpcServer *grpc.Server) {
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		dropped := server.inFlight.Load()
+		slog.Warn("Shutdown deadline passed, dropping the requests in flight", "dropped", dropped)
+		if server.Metrics != nil {
+			server.Metrics.CountDropped(dropped)
+		}
+		srv.Close()
+	} else if err != nil {
+		slog.Error("Error shutting down", "error", err)
+	}
+	if grpcServer == nil {
+		return
+	}
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Warn("Shutdown deadline passed, dropping the gRPC calls in flight")
+		grpcServer.Stop()
+	}
+}
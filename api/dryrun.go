@@ -0,0 +1,36 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// dryRunKey marks the requests of the routes that support dry runs
+type dryRunKey struct{}
+
+// prefers checks that the Prefer header of the request has the preference, e.g. respond-async
+func prefers(r *http.Request, preference string) bool {
+	for _, value := range r.Header.Values("Prefer") {
+		for _, token := range strings.Split(value, ",") {
+			token, _, _ = strings.Cut(token, ";")
+			if strings.EqualFold(strings.TrimSpace(token), preference) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isDryRun checks that the request asks for a dry run with dry_run=true or the Prefer: dry-run header
+func isDryRun(r *http.Request) bool {
+	return r.URL.Query().Get("dry_run") == "true" || prefers(r, "dry-run")
+}
+
+// dryRunnable lets Protected run the mutations of the route as dry runs, the other routes reject dry runs as their
+// side effects, e.g. on the storage, are not rolled back
+func dryRunnable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), dryRunKey{}, true)))
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+)
+
+// UNLOCK is the permission to break the edit locks of the other users
+const UNLOCK = "unlock"
+
+// WithEditLocks requires the edit lock of the objects of EDIT_LOCK_RESOURCES to update them, so that two users
+// do not edit the same object at the same time
+func WithEditLocks(editLocksConfig cfg.EditLocks) Option {
+	return func(server *Server) {
+		server.EditLocksConfig = editLocksConfig
+	}
+}
+
+// initEditLocks keeps the edit locks in the database
+func (server *Server) initEditLocks() {
+	if len(server.EditLocksConfig.Resources) == 0 {
+		return
+	}
+	server.EditLocks = &common.EditLocks{DB: server.DB, TTL: server.EditLocksConfig.TTL, Resources: server.EditLocksConfig.Resources}
+}
+
+// validateEditLocks reports the resources of EDIT_LOCK_RESOURCES that are not registered
+func (server *Server) validateEditLocks() error {
+	var problems []error
+	for _, name := range server.EditLocksConfig.Resources {
+		if _, ok := server.Resources.Resources[name]; !ok {
+			problems = append(problems, fmt.Errorf("EDIT_LOCK_RESOURCES: unknown resource %s", name))
+		}
+	}
+	err := errors.Join(problems...)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+// initEditLockRoutes registers the routes of the edit locks of the objects of the resource
+func (server *Server) initEditLockRoutes(resource common.Resource) {
+	if !server.EditLocks.Locks(resource.Name) {
+		return
+	}
+	path := fmt.Sprintf("/%s/%s/{id}/lock", server.ServerConfig.APIPath, resource.Name)
+	server.Router.HandleFunc(path, server.deprecated(resource, server.Protected(READ, resource, ContentTypeJSON(server.GetEditLock())))).Methods(http.MethodGet)
+	server.Router.HandleFunc(path, server.deprecated(resource, server.Protected(WRITE, resource, ContentTypeJSON(server.AcquireEditLock())))).Methods(http.MethodPost)
+	server.Router.HandleFunc(path, server.deprecated(resource, server.Protected(WRITE, resource, ContentTypeJSON(server.ReleaseEditLock())))).Methods(http.MethodDelete)
+}
+
+// lockedObject returns the request context and the ID of the object of the lock routes, the caller must see the
+// object. With force the caller must have the unlock permission of the resource.
+func (server *Server) lockedObject(w http.ResponseWriter, r *http.Request) (*common.RequestContext, uuid.UUID, bool) {
+	ctx := r.Context()
+	repository := common.GetRequestContext(ctx)
+	if repository == nil {
+		common.GetLogger(ctx).Error("Error reading repository from context")
+		ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+		return nil, uuid.Nil, false
+	}
+	uid, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		ERROR(w, http.StatusBadRequest, err)
+		return nil, uuid.Nil, false
+	}
+	if forced(r) && !getPermissions(r).Can(repository.Resource.Name, UNLOCK) {
+		ERROR(w, http.StatusUnauthorized, WithCode(CODE_PERMISSION, fmt.Errorf("unauthorized, no permission for %s.%s", repository.Resource.Name, UNLOCK)))
+		return nil, uuid.Nil, false
+	}
+	_, err = repository.Get(ctx, uid)
+	if err != nil {
+		ERROR(w, repositoryStatus(err), err)
+		return nil, uuid.Nil, false
+	}
+	return repository, uid, true
+}
+
+// forced checks if the request breaks the lock of another user
+func forced(r *http.Request) bool {
+	return r.URL.Query().Get("force") == "true"
+}
+
+// GetEditLock returns the lock of the object, 404 when it is not locked
+func (server *Server) GetEditLock() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repository, uid, ok := server.lockedObject(w, r)
+		if !ok {
+			return
+		}
+		lock, err := server.EditLocks.Get(r.Context(), repository.Resource.Name, uid)
+		if err != nil {
+			common.GetLogger(r.Context()).Error("Error loading edit lock", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		if lock == nil {
+			ERROR(w, http.StatusNotFound, fmt.Errorf("%s %s is not locked", repository.Resource.Name, uid))
+			return
+		}
+		JSON(w, http.StatusOK, lock)
+	}
+}
+
+// AcquireEditLock locks the object for the caller for EDIT_LOCK_TTL or renews the lock of the caller. The lock of
+// another user is broken with force=true and the unlock permission of the resource.
+func (server *Server) AcquireEditLock() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repository, uid, ok := server.lockedObject(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		user, _ := respitectx.CurrentUser(ctx)
+		lock, err := server.EditLocks.Acquire(ctx, repository.Resource.Name, uid, user.ID, forced(r))
+		if err != nil {
+			common.GetLogger(ctx).Warn("Error acquiring edit lock", "resource", repository.Resource.Name, "id", uid, "error", err)
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		if forced(r) {
+			common.GetLogger(ctx).Info("Edit lock forced", "resource", repository.Resource.Name, "id", uid, "user", user.ID)
+		}
+		JSON(w, http.StatusOK, lock)
+	}
+}
+
+// ReleaseEditLock releases the lock of the caller on the object. The lock of another user is released with
+// force=true and the unlock permission of the resource.
+func (server *Server) ReleaseEditLock() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repository, uid, ok := server.lockedObject(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		user, _ := respitectx.CurrentUser(ctx)
+		err := server.EditLocks.Release(ctx, repository.Resource.Name, uid, user.ID, forced(r))
+		if err != nil {
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
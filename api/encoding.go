@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBuffer is the capacity above which the buffers of the responses are not reused, so that a large list does
+// not keep its memory
+const maxPooledBuffer = 1 << 20
+
+// Encoder encodes the values of the JSON responses, e.g. an adapter of a faster encoder than encoding/json:
+//
+//	type sonicEncoder struct{}
+//
+//	func (sonicEncoder) Encode(w io.Writer, value any) error {
+//		return sonic.ConfigStd.NewEncoder(w).Encode(value)
+//	}
+//
+// The encoders write the values with the HTML escaping and the newline of json.Encoder, as the clients may compare
+// the responses.
+type Encoder interface {
+	Encode(w io.Writer, value any) error
+}
+
+// StandardEncoder encodes the values with encoding/json
+type StandardEncoder struct{}
+
+// Encode writes the JSON encoding of the value
+func (StandardEncoder) Encode(w io.Writer, value any) error {
+	return json.NewEncoder(w).Encode(value)
+}
+
+// encoderHolder holds the encoder of the responses, so that the encoders of any type are stored atomically
+type encoderHolder struct {
+	encoder Encoder
+}
+
+// responseEncoder is the encoder of the responses written by JSON, encoding/json when it is not set
+var responseEncoder atomic.Pointer[encoderHolder]
+
+// responseBuffers are the reused buffers of the responses
+var responseBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// SetJSONEncoder encodes the JSON responses of the process with the encoder instead of encoding/json, e.g. for large
+// lists where the encoding dominates the CPU. JSON and ERROR are package functions, so the encoder is shared by all
+// servers of the process, it is set once before they are created and nil restores encoding/json.
+func SetJSONEncoder(encoder Encoder) {
+	if encoder == nil {
+		responseEncoder.Store(nil)
+		return
+	}
+	responseEncoder.Store(&encoderHolder{encoder: encoder})
+}
+
+// jsonEncoder returns the encoder of the responses
+func jsonEncoder() Encoder {
+	holder := responseEncoder.Load()
+	if holder == nil {
+		return StandardEncoder{}
+	}
+	return holder.encoder
+}
+
+// encodeResponse encodes the value into a reused buffer, the buffer is given back with releaseBuffer
+func encodeResponse(value any) (*bytes.Buffer, error) {
+	buffer := responseBuffers.Get().(*bytes.Buffer)
+	buffer.Reset()
+	err := jsonEncoder().Encode(buffer, value)
+	if err != nil {
+		releaseBuffer(buffer)
+		return nil, err
+	}
+	return buffer, nil
+}
+
+// releaseBuffer gives the buffer back for its reuse, unless it grew too large
+func releaseBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() <= maxPooledBuffer {
+		responseBuffers.Put(buffer)
+	}
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/dzahariev/respite/storage"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// personalUserFields are the fields of the users cleared by the erasure, the user is kept for the retained objects
+var personalUserFields = []string{"prefered_user_name", "given_name", "family_name", "email", "external_id"}
+
+// ErasureReport is the compliance record of an erasure of the data of a user
+type ErasureReport struct {
+	ID          uuid.UUID        `gorm:"primaryKey" json:"id"`
+	UserID      uuid.UUID        `json:"user_id"`
+	RequestedBy uuid.UUID        `json:"requested_by"`
+	Time        time.Time        `json:"time"`
+	Resources   []ErasedResource `gorm:"serializer:json;type:jsonb" json:"resources"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// TableName returns the erasure report table name
+func (report *ErasureReport) TableName() string {
+	return "erasure_report"
+}
+
+// ErasedResource is the result of the erasure in a resource
+type ErasedResource struct {
+	Resource string `json:"resource"`
+	Policy   string `json:"policy"`
+	Objects  int    `json:"objects"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// EraseUserData erases the data of the user of the path, the callers erase their own data or, with admin.write,
+// the data of other users. The erasure is confirmed with confirm=true and recorded in the erasure report, which
+// is returned.
+func (server *Server) EraseUserData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		uid, err := uuid.FromString(mux.Vars(r)["id"])
+		if err != nil {
+			logger.Error("Error parsing UUID from request", "error", err)
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		caller, ok := respitectx.CurrentUser(ctx)
+		if !ok || caller == nil {
+			ERROR(w, http.StatusUnauthorized, errors.New("unauthorized, no user"))
+			return
+		}
+		if caller.ID != uid && !common.GetPermissions(ctx).Can(ADMIN, WRITE) {
+			ERROR(w, http.StatusUnauthorized, WithCode(CODE_PERMISSION, fmt.Errorf("unauthorized, no permission for %s.%s", ADMIN, WRITE)))
+			return
+		}
+		if r.URL.Query().Get("confirm") != "true" {
+			ERROR(w, http.StatusBadRequest, WithCode(CODE_CONFIRMATION, errors.New("erasing the data of a user requires the confirm=true parameter")))
+			return
+		}
+		user, err := server.DBLoadUser(ctx, uid.String())
+		if err != nil {
+			logger.Error("Error loading user", "userID", uid, "error", err)
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		report := server.eraseUserData(ctx, user, caller)
+		err = server.DB.WithContext(ctx).Create(report).Error
+		if err != nil {
+			logger.Error("Error recording erasure report", "userID", uid, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		if report.Error != "" {
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("erasure %s failed: %s", report.ID, report.Error))
+			return
+		}
+		logger.Info("User data erased", "userID", uid, "report", report.ID, "requestedBy", caller.ID)
+		JSON(w, http.StatusOK, report)
+	}
+}
+
+// eraseUserData deletes, anonymizes or retains the objects of the user in all owned resources as they declare,
+// and clears the personal fields of the user. The mutations emit their events, so the search index, the cached
+// responses and the usage follow them. The first failure stops the erasure and is recorded in the report.
+func (server *Server) eraseUserData(ctx context.Context, user, caller *domain.User) *ErasureReport {
+	report := &ErasureReport{
+		ID:          uuid.Must(uuid.NewV4()),
+		UserID:      user.ID,
+		RequestedBy: caller.ID,
+		Time:        time.Now().UTC(),
+		Resources:   []ErasedResource{},
+	}
+	names := make([]string, 0, len(server.Resources.Resources))
+	for name, resource := range server.Resources.Resources {
+		// The SQL views show the objects of the other resources
+		if resource.IsOwned() && resource.SQLView == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		erased, err := server.eraseResource(ctx, server.Resources.Resources[name], user)
+		report.Resources = append(report.Resources, erased)
+		if err != nil {
+			report.Error = fmt.Sprintf("%s: %s", name, err)
+			return report
+		}
+	}
+	if server.Inbox != nil {
+		erased, err := server.Inbox.Erase(ctx, user.ID)
+		report.Resources = append(report.Resources, ErasedResource{Resource: "notifications", Policy: domain.ERASURE_DELETE, Objects: int(erased)})
+		if err != nil {
+			report.Error = fmt.Sprintf("notifications: %s", err)
+			return report
+		}
+	}
+	if server.ExportSchedulesConfig.Enabled {
+		var erased int64
+		err := domain.Transaction(server.DB.WithContext(ctx), func(tx *gorm.DB) error {
+			err := tx.Where("schedule_id IN (?)", tx.Model(&domain.ExportSchedule{}).Select("id").Where("user_id = ?", user.ID)).Delete(&domain.ExportRun{}).Error
+			if err != nil {
+				return err
+			}
+			result := tx.Delete(&domain.ExportSchedule{}, "user_id = ?", user.ID)
+			erased = result.RowsAffected
+			return result.Error
+		})
+		report.Resources = append(report.Resources, ErasedResource{Resource: "export_schedules", Policy: domain.ERASURE_DELETE, Objects: int(erased)})
+		if err != nil {
+			report.Error = fmt.Sprintf("export_schedules: %s", err)
+			return report
+		}
+	}
+	if server.ViewsConfig.Enabled {
+		result := server.DB.WithContext(ctx).Delete(&domain.View{}, "user_id = ?", user.ID)
+		report.Resources = append(report.Resources, ErasedResource{Resource: "views", Policy: domain.ERASURE_DELETE, Objects: int(result.RowsAffected)})
+		if result.Error != nil {
+			report.Error = fmt.Sprintf("views: %s", result.Error)
+			return report
+		}
+	}
+	err := server.DB.WithContext(ctx).Model(user).Select(personalUserFields).Updates(&domain.User{}).Error
+	if err != nil {
+		report.Error = fmt.Sprintf("user: %s", err)
+		return report
+	}
+	report.Resources = append(report.Resources, ErasedResource{Resource: "user", Policy: domain.ERASURE_ANONYMIZE, Objects: 1})
+	return report
+}
+
+// eraseResource erases the objects of the user in the resource, the erased objects are counted also on failures
+func (server *Server) eraseResource(ctx context.Context, resource common.Resource, user *domain.User) (ErasedResource, error) {
+	erasure := domain.Erasure{Policy: domain.ERASURE_DELETE}
+	if resource.Erasure != nil {
+		erasure = *resource.Erasure
+	}
+	erased := ErasedResource{Resource: resource.Name, Policy: erasure.Policy, Reason: erasure.Reason}
+	var ids []uuid.UUID
+	err := server.DB.WithContext(ctx).Model(reflect.New(resource.Type).Interface()).Where("user_id = ?", user.ID).Pluck("id", &ids).Error
+	if err != nil {
+		return erased, err
+	}
+	if erasure.Policy == domain.ERASURE_RETAIN {
+		erased.Objects = len(ids)
+		return erased, nil
+	}
+	requestContext := server.newRequestContextWithDetails(ctx, common.MinPageSize, 1, 0, user, resource, nil)
+	for _, id := range ids {
+		if erasure.Policy == domain.ERASURE_ANONYMIZE {
+			_, err = requestContext.Anonymize(ctx, id, erasure.Fields)
+		} else {
+			err = requestContext.DeleteIf(ctx, id, func(object domain.Object) error {
+				return server.deleteContent(ctx, object)
+			})
+		}
+		// Objects deleted meanwhile, e.g. by a concurrent erasure, are already erased
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return erased, err
+		}
+		erased.Objects++
+	}
+	return erased, nil
+}
+
+// deleteContent deletes the stored content of the files before their metadata, other objects have no content.
+// Files whose content was never uploaded have nothing to delete.
+func (server *Server) deleteContent(ctx context.Context, object domain.Object) error {
+	file, ok := object.(*domain.File)
+	if !ok || server.Storage == nil || file.StorageKey == "" {
+		return nil
+	}
+	err := server.Storage.Delete(ctx, file.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	return err
+}
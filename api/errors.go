@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dzahariev/respite/breaker"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"gorm.io/gorm"
+)
+
+// Error codes included in all error responses, clients branch on them instead of the messages that may change
+const (
+	CODE_BAD_REQUEST      = "RESPITE-400-BAD-REQUEST"
+	CODE_CONFIRMATION     = "RESPITE-400-CONFIRMATION"
+	CODE_UNAUTHORIZED     = "RESPITE-401-UNAUTHORIZED"
+	CODE_PERMISSION       = "RESPITE-401-PERMISSION"
+	CODE_STEP_UP          = "RESPITE-401-STEP-UP"
+	CODE_QUOTA            = "RESPITE-403-QUOTA"
+	CODE_CONSENT          = "RESPITE-403-CONSENT"
+	CODE_TENANT_SUSPENDED = "RESPITE-403-TENANT-SUSPENDED"
+	CODE_TENANT_DOMAIN    = "RESPITE-403-TENANT-DOMAIN"
+	CODE_DEACTIVATED      = "RESPITE-403-DEACTIVATED"
+	CODE_NOT_FOUND        = "RESPITE-404-RESOURCE"
+	CODE_READ_ONLY        = "RESPITE-405-READ-ONLY"
+	CODE_CONFLICT         = "RESPITE-409-CONFLICT"
+	CODE_GONE             = "RESPITE-410-GONE"
+	CODE_LENGTH_REQUIRED  = "RESPITE-411-LENGTH-REQUIRED"
+	CODE_PRECONDITION     = "RESPITE-412-PRECONDITION"
+	CODE_TOO_LARGE        = "RESPITE-413-TOO-LARGE"
+	CODE_VALIDATION       = "RESPITE-422-VALIDATION"
+	CODE_IDEMPOTENCY_KEY  = "RESPITE-422-IDEMPOTENCY-KEY"
+	CODE_INFECTED         = "RESPITE-422-INFECTED"
+	CODE_LOCKED           = "RESPITE-423-LOCKED"
+	CODE_RATE_LIMIT       = "RESPITE-429-RATE-LIMIT"
+	CODE_INTERNAL         = "RESPITE-500-INTERNAL"
+	CODE_NOT_IMPLEMENTED  = "RESPITE-501-NOT-IMPLEMENTED"
+	CODE_BAD_GATEWAY      = "RESPITE-502-BAD-GATEWAY"
+	CODE_UNAVAILABLE      = "RESPITE-503-UNAVAILABLE"
+	CODE_TIMEOUT          = "RESPITE-504-TIMEOUT"
+)
+
+// statusCodes are the default error codes of the response statuses
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CODE_BAD_REQUEST,
+	http.StatusUnauthorized:          CODE_UNAUTHORIZED,
+	http.StatusForbidden:             CODE_QUOTA,
+	http.StatusNotFound:              CODE_NOT_FOUND,
+	http.StatusMethodNotAllowed:      CODE_READ_ONLY,
+	http.StatusConflict:              CODE_CONFLICT,
+	http.StatusGone:                  CODE_GONE,
+	http.StatusLengthRequired:        CODE_LENGTH_REQUIRED,
+	http.StatusPreconditionFailed:    CODE_PRECONDITION,
+	http.StatusRequestEntityTooLarge: CODE_TOO_LARGE,
+	http.StatusUnprocessableEntity:   CODE_VALIDATION,
+	http.StatusLocked:                CODE_LOCKED,
+	http.StatusTooManyRequests:       CODE_RATE_LIMIT,
+	http.StatusInternalServerError:   CODE_INTERNAL,
+	http.StatusNotImplemented:        CODE_NOT_IMPLEMENTED,
+	http.StatusBadGateway:            CODE_BAD_GATEWAY,
+	http.StatusServiceUnavailable:    CODE_UNAVAILABLE,
+	http.StatusGatewayTimeout:        CODE_TIMEOUT,
+}
+
+// CodedError carries the error code that replaces the default code of the response status
+type CodedError struct {
+	Code string
+	Err  error
+}
+
+// Error returns the message of the wrapped error
+func (e *CodedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+// WithCode attaches the error code to the error
+func WithCode(code string, err error) error {
+	return &CodedError{Code: code, Err: err}
+}
+
+// errorCode resolves the code of the error response
+func errorCode(statusCode int, err error) string {
+	var codedError *CodedError
+	if errors.As(err, &codedError) {
+		return codedError.Code
+	}
+	if code, ok := statusCodes[statusCode]; ok {
+		return code
+	}
+	if statusCode >= http.StatusInternalServerError {
+		return CODE_INTERNAL
+	}
+	return CODE_BAD_REQUEST
+}
+
+// repositoryStatus maps repository errors to response statuses
+func repositoryStatus(err error) int {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, common.ErrQuotaExceeded):
+		return http.StatusForbidden
+	case errors.Is(err, errPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.As(err, &syntaxError), errors.As(err, &typeError):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, domain.ErrWebhook):
+		return http.StatusBadGateway
+	case errors.Is(err, breaker.ErrOpen), errors.Is(err, common.ErrAuthorizer):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, common.ErrMergeConflict):
+		return http.StatusConflict
+	case errors.Is(err, common.ErrEditLocked):
+		return http.StatusLocked
+	case errors.Is(err, errors.ErrUnsupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, common.ErrReadOnlyResource):
+		return http.StatusMethodNotAllowed
+	}
+	return http.StatusInternalServerError
+}
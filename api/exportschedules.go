@@ -0,0 +1,765 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/jobs"
+	"github.com/dzahariev/respite/notify"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/dzahariev/respite/scheduler"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+const (
+	SCHEDULED_EXPORT = "scheduled_export"
+	// EXPORT_SCHEDULES_TASK is the scheduled task starting the runs of the due export schedules
+	EXPORT_SCHEDULES_TASK = "export-schedules"
+
+	// Formats of the scheduled exports
+	EXPORT_NDJSON = "ndjson"
+	EXPORT_CSV    = "csv"
+
+	// Destinations of the scheduled exports
+	DESTINATION_EMAIL   = "email"
+	DESTINATION_STORAGE = "storage"
+)
+
+// exportFilterParameters are the list parameters that filter the scheduled exports, besides the filters of the
+// array fields
+var exportFilterParameters = []string{"near", "radius", "region", "label"}
+
+// ErrExportOwner is returned for the runs of the schedules whose owner cannot export anymore, the schedules are
+// disabled
+var ErrExportOwner = errors.New("the owner of the export schedule cannot export")
+
+// scheduledExportParameters are the parameters of the jobs of the scheduled exports
+type scheduledExportParameters struct {
+	Schedule uuid.UUID `json:"schedule"`
+	Run      uuid.UUID `json:"run"`
+}
+
+// WithExportSchedules lets the users save export templates of the resources that are delivered on their schedules
+// by email or to the storage. It requires WithJobs and WithStorage.
+func WithExportSchedules(exportSchedulesConfig cfg.ExportSchedules) Option {
+	return func(server *Server) {
+		server.ExportSchedulesConfig = exportSchedulesConfig
+	}
+}
+
+// WithNotifier sends the emails of the server with the notifier, e.g. of the scheduled exports
+func WithNotifier(notifier notify.Notifier) Option {
+	return func(server *Server) {
+		server.Notifier = notifier
+	}
+}
+
+// initExportSchedules registers the jobs of the scheduled exports and the task starting them
+func (server *Server) initExportSchedules() error {
+	if !server.ExportSchedulesConfig.Enabled {
+		return nil
+	}
+	if server.Jobs == nil || server.Storage == nil {
+		return errors.New("EXPORT_SCHEDULES_ENABLED: requires the jobs and the storage")
+	}
+	server.Jobs.Register(SCHEDULED_EXPORT, server.scheduledExportJob)
+	return server.Scheduler.Register(EXPORT_SCHEDULES_TASK, "@every "+server.ExportSchedulesConfig.CheckInterval.String(), server.startExportSchedules)
+}
+
+// initExportScheduleRoutes registers the routes of the export schedules of the resource, the callers need the read
+// permission of the resource. They are registered before the routes of the objects to take precedence.
+func (server *Server) initExportScheduleRoutes(resource common.Resource) {
+	if !server.ExportSchedulesConfig.Enabled || server.Jobs == nil || server.Storage == nil {
+		return
+	}
+	path := fmt.Sprintf("/%s/%s/export-schedules", server.ServerConfig.APIPath, resource.Name)
+	server.Router.HandleFunc(path, server.Protected(READ, resource, ContentTypeJSON(server.ExportSchedules()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(path, server.Protected(READ, resource, server.sensitive(resource, OPERATION_EXPORT, ContentTypeJSON(server.CreateExportSchedule())))).Methods(http.MethodPost)
+	server.Router.HandleFunc(path+"/{schedule_id}", server.Protected(READ, resource, ContentTypeJSON(server.GetExportSchedule()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(path+"/{schedule_id}", server.Protected(READ, resource, server.sensitive(resource, OPERATION_EXPORT, ContentTypeJSON(server.UpdateExportSchedule())))).Methods(http.MethodPut)
+	server.Router.HandleFunc(path+"/{schedule_id}", server.Protected(READ, resource, ContentTypeJSON(server.DeleteExportSchedule()))).Methods(http.MethodDelete)
+	server.Router.HandleFunc(path+"/{schedule_id}/runs", server.Protected(READ, resource, ContentTypeJSON(server.ExportRuns()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(path+"/{schedule_id}/runs", server.Protected(READ, resource, server.sensitive(resource, OPERATION_EXPORT, server.resourceRateLimit(resource, OPERATION_EXPORT, ContentTypeJSON(server.RunExportSchedule()))))).Methods(http.MethodPost)
+}
+
+// ExportSchedules lists the export schedules of the resource of the caller by name
+func (server *Server) ExportSchedules() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		repository := common.GetRequestContext(ctx)
+		if repository == nil {
+			common.GetLogger(ctx).Error("Error reading repository from context")
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+			return
+		}
+		user, _ := respitectx.CurrentUser(ctx)
+		schedules := []domain.ExportSchedule{}
+		err := server.DB.WithContext(ctx).Where("resource = ? AND user_id = ?", repository.Resource.Name, user.ID).Order("name, id").Find(&schedules).Error
+		if err != nil {
+			common.GetLogger(ctx).Error("Error loading export schedules", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, schedules)
+	}
+}
+
+// GetExportSchedule returns the export schedule of the path
+func (server *Server) GetExportSchedule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schedule, ok := server.loadExportSchedule(w, r)
+		if !ok {
+			return
+		}
+		JSON(w, http.StatusOK, schedule)
+	}
+}
+
+// CreateExportSchedule saves the export schedule of the body for the caller, e.g. {"name": "Weekly orders",
+// "format": "csv", "schedule": "0 6 * * 1", "destination": {"type": "email", "to": ["sales@example.com"]}}, up to
+// EXPORT_SCHEDULES_MAX_PER_USER schedules per resource. The exports run with the roles of the caller.
+func (server *Server) CreateExportSchedule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repository, schedule, ok := server.decodeExportSchedule(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		user, _ := respitectx.CurrentUser(ctx)
+		if limit := server.ExportSchedulesConfig.MaxPerUser; limit > 0 {
+			var count int64
+			err := server.DB.WithContext(ctx).Model(&domain.ExportSchedule{}).Where("resource = ? AND user_id = ?", repository.Resource.Name, user.ID).Count(&count).Error
+			if err != nil {
+				logger.Error("Error counting export schedules", "error", err)
+				ERROR(w, http.StatusInternalServerError, err)
+				return
+			}
+			if count >= limit {
+				ERROR(w, http.StatusForbidden, WithCode(CODE_QUOTA, fmt.Errorf("the limit of %d export schedules of %s is reached", limit, repository.Resource.Name)))
+				return
+			}
+		}
+		now := domain.Now()
+		schedule.ID = uuid.Must(uuid.NewV4())
+		schedule.Resource = repository.Resource.Name
+		schedule.UserID = user.ID
+		schedule.Roles, ok = server.callerRoles(w, r)
+		if !ok {
+			return
+		}
+		schedule.NextRunAt = nextExportRun(schedule, now)
+		schedule.CreatedAt = now
+		schedule.UpdatedAt = now
+		err := server.DB.WithContext(ctx).Create(schedule).Error
+		if err != nil {
+			logger.Error("Error saving export schedule", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusCreated, schedule)
+	}
+}
+
+// UpdateExportSchedule replaces the export schedule of the path, the exports run with the roles of the caller from
+// then on
+func (server *Server) UpdateExportSchedule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schedule, ok := server.loadExportSchedule(w, r)
+		if !ok {
+			return
+		}
+		_, changed, ok := server.decodeExportSchedule(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		now := domain.Now()
+		schedule.Name = changed.Name
+		schedule.Parameters = changed.Parameters
+		schedule.Columns = changed.Columns
+		schedule.Format = changed.Format
+		schedule.Schedule = changed.Schedule
+		schedule.Destination = changed.Destination
+		schedule.Enabled = changed.Enabled
+		schedule.Roles, ok = server.callerRoles(w, r)
+		if !ok {
+			return
+		}
+		schedule.NextRunAt = nextExportRun(schedule, now)
+		schedule.UpdatedAt = now
+		err := server.DB.WithContext(ctx).
+			Select("name", "parameters", "columns", "format", "schedule", "destination", "enabled", "roles", "next_run_at", "updated_at").
+			Updates(schedule).Error
+		if err != nil {
+			common.GetLogger(ctx).Error("Error saving export schedule", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, schedule)
+	}
+}
+
+// DeleteExportSchedule deletes the export schedule of the path and its runs, the delivered exports are kept
+func (server *Server) DeleteExportSchedule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schedule, ok := server.loadExportSchedule(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		err := domain.Transaction(server.DB.WithContext(ctx), func(tx *gorm.DB) error {
+			err := tx.Delete(&domain.ExportRun{}, "schedule_id = ?", schedule.ID).Error
+			if err != nil {
+				return err
+			}
+			return tx.Delete(schedule).Error
+		})
+		if err != nil {
+			common.GetLogger(ctx).Error("Error deleting export schedule", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ExportRuns lists the runs of the export schedule of the path, the newest first
+func (server *Server) ExportRuns() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schedule, ok := server.loadExportSchedule(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		runs := []domain.ExportRun{}
+		err := server.DB.WithContext(ctx).Where("schedule_id = ?", schedule.ID).Order("created_at DESC, id").Limit(common.MaxPageSize).Find(&runs).Error
+		if err != nil {
+			common.GetLogger(ctx).Error("Error loading export runs", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, runs)
+	}
+}
+
+// RunExportSchedule starts a run of the export schedule of the path at once, its schedule is not changed
+func (server *Server) RunExportSchedule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schedule, ok := server.loadExportSchedule(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		run, err := server.startExportRun(ctx, schedule, domain.Now(), false)
+		if errors.Is(err, ErrExportOwner) {
+			ERROR(w, http.StatusForbidden, err)
+			return
+		}
+		if err != nil {
+			common.GetLogger(ctx).Error("Error starting export run", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("%s/%s/jobs/%s", r.Host, server.ServerConfig.APIPath, run.JobID))
+		JSON(w, http.StatusAccepted, run)
+	}
+}
+
+// callerRoles returns the roles of the token of the caller, the runs of the schedules resolve their permissions
+func (server *Server) callerRoles(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	ctx := r.Context()
+	roles, err := server.AuthClient.GetRolesFromToken(ctx, callerToken(ctx))
+	if err != nil {
+		common.GetLogger(ctx).Error("Cannot get roles from token", "error", err)
+		ERROR(w, authenticationStatus(err), err)
+		return nil, false
+	}
+	return roles, true
+}
+
+// loadExportSchedule returns the export schedule of the path of the resource of the request, the schedules of
+// other users are not found
+func (server *Server) loadExportSchedule(w http.ResponseWriter, r *http.Request) (*domain.ExportSchedule, bool) {
+	ctx := r.Context()
+	repository := common.GetRequestContext(ctx)
+	if repository == nil {
+		common.GetLogger(ctx).Error("Error reading repository from context")
+		ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+		return nil, false
+	}
+	uid, err := uuid.FromString(mux.Vars(r)["schedule_id"])
+	if err != nil {
+		ERROR(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	user, _ := respitectx.CurrentUser(ctx)
+	schedule := &domain.ExportSchedule{}
+	err = server.DB.WithContext(ctx).First(schedule, "id = ? AND resource = ? AND user_id = ?", uid, repository.Resource.Name, user.ID).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			common.GetLogger(ctx).Error("Error loading export schedule", "error", err)
+		}
+		ERROR(w, repositoryStatus(err), err)
+		return nil, false
+	}
+	return schedule, true
+}
+
+// decodeExportSchedule reads and validates the export schedule of the body for the resource of the request, the
+// format is NDJSON unless it is set
+func (server *Server) decodeExportSchedule(w http.ResponseWriter, r *http.Request) (*common.RequestContext, *domain.ExportSchedule, bool) {
+	repository := common.GetRequestContext(r.Context())
+	if repository == nil {
+		common.GetLogger(r.Context()).Error("Error reading repository from context")
+		ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+		return nil, nil, false
+	}
+	schedule := &domain.ExportSchedule{}
+	err := json.NewDecoder(r.Body).Decode(schedule)
+	if err != nil {
+		ERROR(w, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	if schedule.Format == "" {
+		schedule.Format = EXPORT_NDJSON
+	}
+	err = server.validateExportSchedule(repository.Resource, schedule)
+	if err != nil {
+		ERROR(w, repositoryStatus(err), err)
+		return nil, nil, false
+	}
+	return repository, schedule, true
+}
+
+// validateExportSchedule checks the name, the format, the cron expression, the filters, the columns and the
+// destination of the export schedule
+func (server *Server) validateExportSchedule(resource common.Resource, schedule *domain.ExportSchedule) error {
+	var problems []error
+	if strings.TrimSpace(schedule.Name) == "" {
+		problems = append(problems, errors.New("name is required"))
+	}
+	if !slices.Contains([]string{EXPORT_NDJSON, EXPORT_CSV}, schedule.Format) {
+		problems = append(problems, fmt.Errorf("format must be %s or %s, got %q", EXPORT_NDJSON, EXPORT_CSV, schedule.Format))
+	}
+	_, err := cron.ParseStandard(schedule.Schedule)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("invalid schedule %q: %w", schedule.Schedule, err))
+	}
+	for key := range schedule.Parameters {
+		if !slices.Contains(exportFilterParameters, key) && !common.IsArrayFilter(resource, key) {
+			problems = append(problems, fmt.Errorf("%s is not a filter of the lists of %s", key, resource.Name))
+		}
+	}
+	_, err = server.exportFilter(resource, schedule.Parameters)
+	if err != nil {
+		problems = append(problems, err)
+	}
+	for _, column := range schedule.Columns {
+		if _, ok := domain.JSONField(resource.Type, column); !ok && !slices.Contains(viewColumns, column) {
+			problems = append(problems, fmt.Errorf("%s is not a field of %s", column, resource.Name))
+		}
+	}
+	destination := schedule.Destination
+	switch destination.Type {
+	case DESTINATION_EMAIL:
+		if server.Notifier == nil || server.ExportSchedulesConfig.PublicURL == "" || server.ServerConfig.SigningKey == "" {
+			problems = append(problems, errors.New("email destinations require the email, EXPORT_SCHEDULES_PUBLIC_URL and SERVER_SIGNING_KEY"))
+		}
+		if len(destination.To) == 0 {
+			problems = append(problems, errors.New("the recipients of the email are required"))
+		}
+		for _, address := range destination.To {
+			_, err := mail.ParseAddress(address)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("invalid recipient %q: %w", address, err))
+			}
+		}
+	case DESTINATION_STORAGE:
+		if destination.Path == "" || strings.HasPrefix(destination.Path, "/") || slices.Contains(strings.Split(destination.Path, "/"), "..") {
+			problems = append(problems, fmt.Errorf("the path of the storage must be relative, got %q", destination.Path))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("destination must be %s or %s, got %q", DESTINATION_EMAIL, DESTINATION_STORAGE, destination.Type))
+	}
+	err = errors.Join(problems...)
+	if err != nil {
+		return fmt.Errorf("%w: %w", domain.ErrValidation, err)
+	}
+	return nil
+}
+
+// exportFilter returns the filter of the request contexts of the exports of the parameters, as the filters of the
+// list requests
+func (server *Server) exportFilter(resource common.Resource, parameters map[string][]string) (func(*common.RequestContext), error) {
+	request := &http.Request{URL: &url.URL{RawQuery: url.Values(parameters).Encode()}}
+	near, err := common.ParseNear(request, resource)
+	if err != nil {
+		return nil, err
+	}
+	arrays, err := common.ParseArrayFilters(request, resource)
+	if err != nil {
+		return nil, err
+	}
+	origin := common.NewDBScopesFromRequest(request, false).Origin
+	err = server.checkFilters(originConditions(origin) + len(arrays))
+	if err != nil {
+		return nil, err
+	}
+	return func(requestContext *common.RequestContext) {
+		requestContext.FilterOrigin(origin)
+		requestContext.FilterNear(near)
+		requestContext.FilterArrays(arrays)
+	}, nil
+}
+
+// nextExportRun returns the time of the next run of the enabled schedule after the time, nil when it is disabled
+func nextExportRun(schedule *domain.ExportSchedule, after time.Time) *time.Time {
+	parsed, err := cron.ParseStandard(schedule.Schedule)
+	if err != nil || !schedule.Enabled {
+		return nil
+	}
+	next := parsed.Next(after)
+	return &next
+}
+
+// startExportSchedules starts the runs of the enabled export schedules that are due
+func (server *Server) startExportSchedules(ctx context.Context, tasks *scheduler.TaskContext) error {
+	now := domain.Now()
+	schedules := []domain.ExportSchedule{}
+	err := tasks.DB.WithContext(ctx).Where("enabled = ? AND next_run_at <= ?", true, now).Order("next_run_at").Find(&schedules).Error
+	if err != nil {
+		return fmt.Errorf("cannot load due export schedules: %w", err)
+	}
+	var problems []error
+	for i := range schedules {
+		_, err := server.startExportRun(ctx, &schedules[i], now, true)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("export schedule %s: %w", schedules[i].ID, err))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// startExportRun records a run of the schedule and creates the job of its export with the current permissions of
+// the owner of the schedule. With advance the next run of the schedule is moved after the time. The schedules of
+// the owners who cannot export anymore are disabled, and the failed run records why.
+func (server *Server) startExportRun(ctx context.Context, schedule *domain.ExportSchedule, now time.Time, advance bool) (*domain.ExportRun, error) {
+	run := &domain.ExportRun{
+		ID:         uuid.Must(uuid.NewV4()),
+		ScheduleID: schedule.ID,
+		Status:     jobs.PENDING,
+		CreatedAt:  now,
+	}
+	schedule.LastRunAt = &now
+	updates := map[string]any{"last_run_at": now}
+	if advance {
+		schedule.NextRunAt = nextExportRun(schedule, now)
+		updates["next_run_at"] = schedule.NextRunAt
+	}
+	permissions, ownerErr := server.ownerPermissions(ctx, schedule)
+	var job *jobs.Job
+	if ownerErr != nil {
+		run.Status = jobs.FAILED
+		run.Error = ownerErr.Error()
+		run.FinishedAt = &now
+		schedule.Enabled = false
+		updates["enabled"] = false
+	} else {
+		parameters, err := json.Marshal(scheduledExportParameters{Schedule: schedule.ID, Run: run.ID})
+		if err != nil {
+			return nil, err
+		}
+		job, err = jobs.NewJob(SCHEDULED_EXPORT, schedule.Resource, &schedule.UserID, permissions, string(parameters))
+		if err != nil {
+			return nil, err
+		}
+		run.JobID = job.ID
+	}
+	err := domain.Transaction(server.DB.WithContext(ctx), func(tx *gorm.DB) error {
+		err := tx.Create(run).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(schedule).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ownerErr != nil {
+		slog.Warn("Export schedule disabled", "schedule", schedule.ID, "userID", schedule.UserID, "error", ownerErr)
+		return run, ownerErr
+	}
+	err = server.Jobs.Enqueue(ctx, job)
+	if err != nil {
+		server.finishExportRun(ctx, run, 0, "", err)
+		return nil, err
+	}
+	return run, nil
+}
+
+// ownerPermissions resolves the current permissions of the owner of the schedule from the roles of the schedule.
+// The owners that are deactivated, erased or whose roles changed in Keycloak after the schedule was saved cannot
+// export until they save it again.
+func (server *Server) ownerPermissions(ctx context.Context, schedule *domain.ExportSchedule) (common.Permissions, error) {
+	owner, err := server.DBLoadUser(ctx, schedule.UserID.String())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: the owner is erased", ErrExportOwner)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if owner.Deactivated {
+		return nil, fmt.Errorf("%w: %w", ErrExportOwner, ErrUserDeactivated)
+	}
+	if server.revocations != nil {
+		revokedAt, ok, err := server.revokedAt(ctx, owner.ID)
+		if err != nil {
+			return nil, err
+		}
+		if ok && revokedAt.After(schedule.UpdatedAt) {
+			return nil, fmt.Errorf("%w: the owner changed in Keycloak after the schedule was saved", ErrExportOwner)
+		}
+	}
+	return server.userPermissions(ctx, owner, schedule.Roles)
+}
+
+// scheduledExportJob exports the objects of the schedule of the job and delivers the export to its destination,
+// the outcome is recorded in the run of the job
+func (server *Server) scheduledExportJob(ctx context.Context, job *jobs.Job, progress jobs.Progress) error {
+	parameters := scheduledExportParameters{}
+	err := json.Unmarshal([]byte(job.Parameters), &parameters)
+	if err != nil {
+		return fmt.Errorf("invalid scheduled export parameters: %w", err)
+	}
+	run := &domain.ExportRun{}
+	err = server.DB.WithContext(ctx).First(run, "id = ?", parameters.Run).Error
+	if err != nil {
+		return fmt.Errorf("cannot load export run: %w", err)
+	}
+	err = server.DB.WithContext(ctx).Model(run).Update("status", jobs.RUNNING).Error
+	if err != nil {
+		return fmt.Errorf("cannot start export run: %w", err)
+	}
+	objects, location, err := server.deliverExport(ctx, job, parameters.Schedule, progress)
+	server.finishExportRun(ctx, run, objects, location, err)
+	server.trimExportRuns(ctx, parameters.Schedule)
+	return err
+}
+
+// deliverExport writes the export of the schedule to the artifact of the job and delivers it, and returns the
+// count of the exported objects and the location of the delivery
+func (server *Server) deliverExport(ctx context.Context, job *jobs.Job, scheduleID uuid.UUID, progress jobs.Progress) (int64, string, error) {
+	schedule := &domain.ExportSchedule{}
+	err := server.DB.WithContext(ctx).First(schedule, "id = ?", scheduleID).Error
+	if err != nil {
+		return 0, "", fmt.Errorf("cannot load export schedule: %w", err)
+	}
+	user, resource, err := server.jobOwner(ctx, job)
+	if err != nil {
+		return 0, "", err
+	}
+	filter, err := server.exportFilter(resource, schedule.Parameters)
+	if err != nil {
+		return 0, "", err
+	}
+	file, err := os.CreateTemp("", "scheduled-export-*")
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	record := newExportRecorder(writer, schedule.Format, schedule.Columns)
+	objects, err := server.exportObjects(ctx, job, user, resource, filter, progress, record.write)
+	if err == nil {
+		err = record.flush()
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return objects, "", err
+	}
+	contentType := ndjsonContentType
+	if schedule.Format == EXPORT_CSV {
+		contentType = csvContentType
+	}
+	job.Artifact = fmt.Sprintf("jobs/%s/export.%s", job.ID, schedule.Format)
+	err = server.storeFile(ctx, job.Artifact, file, contentType)
+	if err == nil {
+		err = server.Jobs.Save(ctx, job)
+	}
+	if err != nil {
+		return objects, "", err
+	}
+	switch schedule.Destination.Type {
+	case DESTINATION_STORAGE:
+		key := path.Join(schedule.Destination.Path, fmt.Sprintf("%s.%s", domain.Now().Format("20060102T150405Z"), schedule.Format))
+		return objects, key, server.storeFile(ctx, key, file, contentType)
+	case DESTINATION_EMAIL:
+		return objects, strings.Join(schedule.Destination.To, ", "), server.emailExport(ctx, job, schedule, objects)
+	}
+	return objects, "", fmt.Errorf("unsupported destination: %s", schedule.Destination.Type)
+}
+
+// emailExport sends the signed download link of the artifact of the job to the recipients of the schedule
+func (server *Server) emailExport(ctx context.Context, job *jobs.Job, schedule *domain.ExportSchedule, objects int64) error {
+	if server.Notifier == nil || server.ServerConfig.SigningKey == "" {
+		return errors.New("email destinations are not configured")
+	}
+	expiresAt := time.Now().Add(server.ExportSchedulesConfig.LinkExpiry)
+	link := strings.TrimSuffix(server.ExportSchedulesConfig.PublicURL, "/") +
+		server.SignURL(fmt.Sprintf("/%s/jobs/%s/artifact", server.ServerConfig.APIPath, job.ID), url.Values{}, expiresAt)
+	return server.Notifier.Send(ctx, notify.Message{
+		To:      schedule.Destination.To,
+		Subject: fmt.Sprintf("Export %s", schedule.Name),
+		Text:    fmt.Sprintf("The export %s of %d %s objects is ready:\n\n%s\n\nThe link expires at %s.\n", schedule.Name, objects, schedule.Resource, link, expiresAt.UTC().Format(time.RFC1123)),
+	})
+}
+
+// finishExportRun records the outcome of the run, its error if any
+func (server *Server) finishExportRun(ctx context.Context, run *domain.ExportRun, objects int64, location string, runErr error) {
+	updates := map[string]any{"status": jobs.COMPLETED, "objects": objects, "location": location, "finished_at": domain.Now()}
+	if runErr != nil {
+		updates["status"] = jobs.FAILED
+		updates["error"] = runErr.Error()
+	}
+	// The outcome is stored even if the runner is stopping
+	err := server.DB.WithContext(context.WithoutCancel(ctx)).Model(run).Updates(updates).Error
+	if err != nil {
+		slog.Error("Error storing export run", "run", run.ID, "error", err)
+	}
+}
+
+// trimExportRuns removes the runs of the schedule beyond the newest EXPORT_SCHEDULES_HISTORY
+func (server *Server) trimExportRuns(ctx context.Context, scheduleID uuid.UUID) {
+	history := server.ExportSchedulesConfig.History
+	if history <= 0 {
+		return
+	}
+	var kept []uuid.UUID
+	err := server.DB.WithContext(ctx).Model(&domain.ExportRun{}).Where("schedule_id = ?", scheduleID).Order("created_at DESC, id").Limit(int(history)).Pluck("id", &kept).Error
+	if err == nil {
+		err = server.DB.WithContext(ctx).Where("schedule_id = ? AND id NOT IN ?", scheduleID, kept).Delete(&domain.ExportRun{}).Error
+	}
+	if err != nil {
+		slog.Error("Error removing old export runs", "schedule", scheduleID, "error", err)
+	}
+}
+
+// exportRecorder writes the exported objects as NDJSON lines or CSV rows, with the columns only if any
+type exportRecorder struct {
+	format  string
+	columns []string
+	encoder *json.Encoder
+	csv     *csv.Writer
+	header  bool
+}
+
+// newExportRecorder creates the recorder of the format writing to the writer
+func newExportRecorder(writer *bufio.Writer, format string, columns []string) *exportRecorder {
+	return &exportRecorder{format: format, columns: columns, encoder: json.NewEncoder(writer), csv: csv.NewWriter(writer)}
+}
+
+// write writes the fields of the columns of the object, the ID is always written
+func (recorder *exportRecorder) write(object domain.Object) error {
+	body, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	fields := map[string]any{}
+	err = json.Unmarshal(body, &fields)
+	if err != nil {
+		return err
+	}
+	if recorder.format != EXPORT_CSV {
+		if len(recorder.columns) == 0 {
+			return recorder.encoder.Encode(fields)
+		}
+		projected := map[string]any{"id": fields["id"]}
+		for _, column := range recorder.columns {
+			projected[column] = fields[column]
+		}
+		return recorder.encoder.Encode(projected)
+	}
+	if !recorder.header {
+		recorder.header = true
+		recorder.columns = csvColumns(recorder.columns, fields)
+		err = recorder.csv.Write(recorder.columns)
+		if err != nil {
+			return err
+		}
+	}
+	row := make([]string, len(recorder.columns))
+	for i, column := range recorder.columns {
+		row[i], err = csvValue(fields[column])
+		if err != nil {
+			return err
+		}
+	}
+	return recorder.csv.Write(row)
+}
+
+// flush writes the header of the CSV exports without objects and the buffered rows
+func (recorder *exportRecorder) flush() error {
+	if recorder.format != EXPORT_CSV {
+		return nil
+	}
+	if !recorder.header && len(recorder.columns) > 0 {
+		err := recorder.csv.Write(csvColumns(recorder.columns, nil))
+		if err != nil {
+			return err
+		}
+	}
+	recorder.csv.Flush()
+	return recorder.csv.Error()
+}
+
+// csvColumns returns the ID and the columns, or all fields of the object with the ID and the timestamps first
+func csvColumns(columns []string, fields map[string]any) []string {
+	if len(columns) > 0 {
+		return append([]string{"id"}, slices.DeleteFunc(slices.Clone(columns), func(column string) bool { return column == "id" })...)
+	}
+	var names []string
+	for name := range fields {
+		if !slices.Contains(viewColumns, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append(slices.Clone(viewColumns), names...)
+}
+
+// csvValue returns the text of the cell of the value, the texts as they are and the other values as JSON
+func csvValue(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	}
+	body, err := json.Marshal(value)
+	return string(body), err
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dzahariev/respite/alerts"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// dbHost is a host of the database failover
+type dbHost struct {
+	host string
+	port uint16
+}
+
+// String returns the address of the host
+func (host dbHost) String() string {
+	return fmt.Sprintf("%s:%d", host.host, host.port)
+}
+
+// dbFailover selects the host of the new database connections, the first healthy host in the order of DB_HOST and
+// DB_FAILOVER_HOSTS. Healthy hosts accept writes, so standbys are skipped until they are promoted.
+type dbFailover struct {
+	hosts  []dbHost
+	active atomic.Int32
+	// checking serializes the probes of the periodic checks and of the failed connections
+	checking sync.Mutex
+}
+
+// newDBFailover creates the failover of the configured hosts, the failover hosts without a port use DB_PORT
+func newDBFailover(dbConfig cfg.DataBase) (*dbFailover, error) {
+	failover := &dbFailover{}
+	for _, address := range append([]string{dbConfig.Host + ":" + dbConfig.Port}, dbConfig.FailoverHosts...) {
+		host, port, found := strings.Cut(address, ":")
+		if !found {
+			port = dbConfig.Port
+		}
+		number, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port of database host %s: %w", address, err)
+		}
+		failover.hosts = append(failover.hosts, dbHost{host: strings.TrimSpace(host), port: uint16(number)})
+	}
+	return failover, nil
+}
+
+// current returns the host of the new connections
+func (failover *dbFailover) current() dbHost {
+	return failover.hosts[failover.active.Load()]
+}
+
+// apply connects the configuration to the current host, the hosts are tried by the checks instead of the fallbacks
+func (failover *dbFailover) apply(config *pgx.ConnConfig) {
+	host := failover.current()
+	config.Host = host.host
+	config.Port = host.port
+	config.Fallbacks = nil
+}
+
+// serves checks if a connection was opened to the current host
+func (failover *dbFailover) serves(config *pgx.ConnConfig) bool {
+	host := failover.current()
+	return config.Host == host.host && config.Port == host.port
+}
+
+// check probes the hosts in the order of preference and switches to the first healthy one, so the connections fail
+// over when the current host fails and fail back when a preferred host recovers. It returns the previous host when
+// the host is switched.
+func (failover *dbFailover) check(ctx context.Context, config *pgx.ConnConfig, timeout time.Duration) (*dbHost, error) {
+	failover.checking.Lock()
+	defer failover.checking.Unlock()
+	var failures []error
+	for i, host := range failover.hosts {
+		err := probeDBHost(ctx, config, host, timeout)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", host, err))
+			continue
+		}
+		previous := failover.current()
+		if failover.active.Swap(int32(i)) == int32(i) {
+			return nil, nil
+		}
+		return &previous, nil
+	}
+	return nil, fmt.Errorf("no healthy database host: %w", errors.Join(failures...))
+}
+
+// probeDBHost connects to the host and checks that it accepts writes
+func probeDBHost(ctx context.Context, config *pgx.ConnConfig, host dbHost, timeout time.Duration) error {
+	config = config.Copy()
+	config.Host = host.host
+	config.Port = host.port
+	config.Fallbacks = nil
+	config.ConnectTimeout = timeout
+	config.ValidateConnect = pgconn.ValidateConnectTargetSessionAttrsReadWrite
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return err
+	}
+	return conn.Close(ctx)
+}
+
+// checkDBHosts probes the database hosts with the current credentials and reports the changes of the host
+func (server *Server) checkDBHosts(ctx context.Context) (bool, error) {
+	config := server.dbConnConfig.Copy()
+	server.dbCredentials.Load().(dbCredentials).apply(config)
+	previous, err := server.dbFailover.check(ctx, config, server.dbFailoverInterval)
+	if err != nil || previous == nil {
+		return false, err
+	}
+	current := server.dbFailover.current()
+	slog.Warn("Database host switched", "from", previous.String(), "to", current.String())
+	server.Alerts.Record(alerts.DATABASE_FAILOVER, "Database host switched", map[string]string{"from": previous.String(), "to": current.String()})
+	return true, nil
+}
+
+// watchDBHosts checks the database hosts at DB_FAILOVER_CHECK_INTERVAL until the context is cancelled
+func (server *Server) watchDBHosts(ctx context.Context) {
+	ticker := time.NewTicker(server.dbFailoverInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := server.checkDBHosts(ctx)
+			if err != nil {
+				slog.Error("Error checking database hosts", "error", err)
+			}
+		}
+	}
+}
+
+// failoverConnector checks the hosts when a connection cannot be opened, and opens it to the new host, so the
+// requests do not wait for the next periodic check
+type failoverConnector struct {
+	driver.Connector
+	server *Server
+}
+
+// Connect opens a connection to the current host, or to the next healthy host when it fails
+func (connector failoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := connector.Connector.Connect(ctx)
+	if err == nil || ctx.Err() != nil {
+		return conn, err
+	}
+	switched, checkErr := connector.server.checkDBHosts(ctx)
+	if checkErr != nil || !switched {
+		return nil, err
+	}
+	return connector.Connector.Connect(ctx)
+}
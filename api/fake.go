@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/fake"
+)
+
+// SeedFake creates count random instances of each registered resource through the request context, local resources
+// are owned by the owner or by a generated user if it is nil. Referenced resources are created first.
+func (server *Server) SeedFake(ctx context.Context, count int, owner *domain.User) error {
+	generator := fake.New(server.Resources, uint64(time.Now().UnixNano()))
+	if owner == nil {
+		owner = generator.User()
+	}
+	store := fixturesStore{server: server}
+	err := store.SaveUser(ctx, owner)
+	if err != nil {
+		return fmt.Errorf("cannot save owner of fake data: %w", err)
+	}
+	for _, resourceName := range generator.Order() {
+		if resourceName == (&domain.User{}).ResourceName() {
+			continue
+		}
+		requestContext, err := store.NewRequestContext(resourceName, owner)
+		if err != nil {
+			return err
+		}
+		for range count {
+			object, err := generator.Generate(ctx, resourceName)
+			if err != nil {
+				return err
+			}
+			body, err := json.Marshal(object)
+			if err != nil {
+				return err
+			}
+			_, err = requestContext.Create(ctx, body)
+			if err != nil {
+				return fmt.Errorf("cannot create fake %s: %w", resourceName, err)
+			}
+		}
+	}
+	common.GetLogger(ctx).Info("Fake data created", "count", count, "resources", len(server.Resources.Resources), "owner", owner.ID)
+	return nil
+}
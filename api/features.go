@@ -0,0 +1,211 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dzahariev/respite/cfg"
+)
+
+// FEATURES_HEADER is the header of the features that the requests opt into and that the responses have
+const FEATURES_HEADER = "X-Respite-Features"
+
+// FEATURES_OFFERED_HEADER is the header of the responses with the features that the server offers
+const FEATURES_OFFERED_HEADER = "X-Respite-Features-Offered"
+
+// Features of the responses that the clients opt into per request
+const (
+	// FEATURE_CURSOR_PAGINATION pages the lists by the cursor parameter, the cursor of the next page is in their meta
+	FEATURE_CURSOR_PAGINATION = "cursor-pagination"
+	// FEATURE_PROBLEM_JSON returns the errors as application/problem+json of RFC 9457
+	FEATURE_PROBLEM_JSON = "problem-json"
+	// FEATURE_JSON_API returns the objects of the resources and the errors as JSON:API documents
+	FEATURE_JSON_API = "json-api"
+)
+
+// CURSOR is the query parameter of the cursor of the page with the cursor pagination
+const CURSOR = "cursor"
+
+// JSON_API_CONTENT_TYPE is the media type of the JSON:API documents
+const JSON_API_CONTENT_TYPE = "application/vnd.api+json"
+
+// problem is an error response of RFC 9457 with the error code and its localized message as extensions
+type problem struct {
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	Status  int    `json:"status"`
+	Detail  string `json:"detail"`
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// jsonAPIError is an error object of the JSON:API documents
+type jsonAPIError struct {
+	Status string `json:"status"`
+	Code   string `json:"code"`
+	Title  string `json:"title,omitempty"`
+	Detail string `json:"detail"`
+}
+
+// WithFeatures configures the features that the clients can opt into with the X-Respite-Features header
+func WithFeatures(featuresConfig cfg.Features) Option {
+	return func(server *Server) {
+		server.FeaturesConfig = featuresConfig
+	}
+}
+
+// offeredFeatures returns the features that the server offers, the cursor pagination only with the database
+func (server *Server) offeredFeatures() []string {
+	var offered []string
+	for _, feature := range server.FeaturesConfig.Offered {
+		if feature == FEATURE_CURSOR_PAGINATION && server.Repository != nil {
+			continue
+		}
+		if !slices.Contains(offered, feature) {
+			offered = append(offered, feature)
+		}
+	}
+	return offered
+}
+
+// features negotiates the features of the response from the X-Respite-Features header of the request. The features
+// that are not offered are ignored, so that the clients ask for them before the servers offer them. The features of
+// the response are announced with the X-Respite-Features header that ERROR and the handlers read, the offered ones
+// with the X-Respite-Features-Offered header.
+func (server *Server) features(next http.Handler) http.Handler {
+	offered := server.offeredFeatures()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(FEATURES_OFFERED_HEADER, strings.Join(offered, ", "))
+		w.Header().Add("Vary", FEATURES_HEADER)
+		var enabled []string
+		for _, value := range r.Header.Values(FEATURES_HEADER) {
+			for _, feature := range strings.Split(value, ",") {
+				feature = strings.ToLower(strings.TrimSpace(feature))
+				if slices.Contains(offered, feature) && !slices.Contains(enabled, feature) {
+					enabled = append(enabled, feature)
+				}
+			}
+		}
+		if len(enabled) > 0 {
+			w.Header().Set(FEATURES_HEADER, strings.Join(enabled, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// featureEnabled checks that the feature was negotiated for the response
+func featureEnabled(w http.ResponseWriter, feature string) bool {
+	features := w.Header().Get(FEATURES_HEADER)
+	if features == "" {
+		return false
+	}
+	return slices.Contains(strings.Split(features, ", "), feature)
+}
+
+// negotiatedError writes the error in the format of the negotiated features, it returns false without such features
+func negotiatedError(w http.ResponseWriter, statusCode int, code string, err error) bool {
+	switch {
+	case featureEnabled(w, FEATURE_PROBLEM_JSON):
+		w.Header().Set("Content-Type", "application/problem+json")
+		JSON(w, statusCode, problem{
+			Type:    "about:blank",
+			Title:   http.StatusText(statusCode),
+			Status:  statusCode,
+			Detail:  err.Error(),
+			Code:    code,
+			Message: localizedMessage(w, code),
+		})
+		return true
+	case featureEnabled(w, FEATURE_JSON_API):
+		w.Header().Set("Content-Type", JSON_API_CONTENT_TYPE)
+		JSON(w, statusCode, map[string][]jsonAPIError{"errors": {{
+			Status: fmt.Sprint(statusCode),
+			Code:   code,
+			Title:  localizedMessage(w, code),
+			Detail: err.Error(),
+		}}})
+		return true
+	}
+	return false
+}
+
+// writeObject writes the object of the resource, as a JSON:API document when the feature is negotiated
+func writeObject(w http.ResponseWriter, resourceName string, statusCode int, object any) {
+	writeDocument(w, resourceName, statusCode, object, false)
+}
+
+// writeList writes the list of the objects of the resource, as a JSON:API document when the feature is negotiated
+func writeList(w http.ResponseWriter, resourceName string, statusCode int, list any) {
+	writeDocument(w, resourceName, statusCode, list, true)
+}
+
+// writeDocument writes the object or the list of the resource
+func writeDocument(w http.ResponseWriter, resourceName string, statusCode int, data any, list bool) {
+	if !featureEnabled(w, FEATURE_JSON_API) {
+		JSON(w, statusCode, data)
+		return
+	}
+	document, err := jsonAPIDocument(resourceName, data, list)
+	if err != nil {
+		ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", JSON_API_CONTENT_TYPE)
+	JSON(w, statusCode, document)
+}
+
+// jsonAPIDocument returns the JSON:API document of the object or the list of the resource. The objects are the
+// resource objects with their fields as attributes, the count, the page and the meta of the lists are the meta of
+// the document.
+func jsonAPIDocument(resourceName string, data any, list bool) (map[string]any, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var decoded map[string]any
+	err = decoder.Decode(&decoded)
+	if err != nil {
+		return nil, err
+	}
+	if !list {
+		return map[string]any{"data": jsonAPIResource(resourceName, decoded)}, nil
+	}
+	items, _ := decoded["data"].([]any)
+	resources := make([]any, 0, len(items))
+	for _, item := range items {
+		object, _ := item.(map[string]any)
+		resources = append(resources, jsonAPIResource(resourceName, object))
+	}
+	meta := map[string]any{}
+	for key, value := range decoded {
+		switch key {
+		case "data":
+		case "meta":
+			summaries, _ := value.(map[string]any)
+			for summary, value := range summaries {
+				meta[summary] = value
+			}
+		default:
+			meta[key] = value
+		}
+	}
+	return map[string]any{"data": resources, "meta": meta}, nil
+}
+
+// jsonAPIResource returns the resource object of the fields of an object
+func jsonAPIResource(resourceName string, object map[string]any) map[string]any {
+	id, _ := object["id"].(string)
+	attributes := map[string]any{}
+	for key, value := range object {
+		if key != "id" {
+			attributes[key] = value
+		}
+	}
+	return map[string]any{"type": resourceName, "id": id, "attributes": attributes}
+}
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/imaging"
+	"github.com/dzahariev/respite/storage"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+)
+
+// PresignedURL is returned for direct uploads to the storage backend
+type PresignedURL struct {
+	URL       string    `json:"url"`
+	Method    string    `json:"method"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// initFileRoutes registers the routes handling file content, the metadata is served by the generic resource routes
+func (server *Server) initFileRoutes() {
+	resource := server.Resources.Resources[(&domain.File{}).ResourceName()]
+	apiFileIDPath := fmt.Sprintf("/%s/%s/{id}", server.ServerConfig.APIPath, resource.Name)
+	server.Router.HandleFunc(apiFileIDPath, server.Protected(WRITE, resource, server.responseCache(resource, ContentTypeJSON(server.DeleteFile())))).Methods(http.MethodDelete)
+	server.Router.HandleFunc(apiFileIDPath+"/content", server.Protected(WRITE, resource, server.responseCache(resource, ContentTypeJSON(server.UploadFile())))).Methods(http.MethodPut)
+	server.Router.HandleFunc(apiFileIDPath+"/content", server.Protected(READ, resource, server.DownloadFile())).Methods(http.MethodGet)
+	server.Router.HandleFunc(apiFileIDPath+"/upload-url", server.Protected(WRITE, resource, ContentTypeJSON(server.FileUploadURL()))).Methods(http.MethodPost)
+	server.Router.HandleFunc(apiFileIDPath+"/complete", server.Protected(WRITE, resource, server.responseCache(resource, ContentTypeJSON(server.CompleteFileUpload())))).Methods(http.MethodPost)
+}
+
+// UploadFile stores the request body as file content
+func (server *Server) UploadFile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository, file, ok := loadFile(w, r)
+		if !ok {
+			return
+		}
+		if r.ContentLength < 0 {
+			ERROR(w, http.StatusLengthRequired, fmt.Errorf("content length is required"))
+			return
+		}
+		if r.ContentLength > server.StorageConfig.MaxUploadSize {
+			ERROR(w, http.StatusRequestEntityTooLarge, fmt.Errorf("file exceeds the maximum size of %d bytes", server.StorageConfig.MaxUploadSize))
+			return
+		}
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		body := http.MaxBytesReader(w, r.Body, server.StorageConfig.MaxUploadSize)
+		err := server.Storage.Put(ctx, file.StorageKey, body, r.ContentLength, contentType)
+		if err != nil {
+			logger.Error("Error storing file content", "id", file.ID, "error", err)
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				ERROR(w, http.StatusRequestEntityTooLarge, err)
+				return
+			}
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !server.scanFile(w, r, repository, file, r.ContentLength, contentType) {
+			return
+		}
+		size, err := server.processImage(ctx, file, r.ContentLength, contentType)
+		if err != nil {
+			logger.Error("Error processing image", "id", file.ID, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		updated, err := markContent(r, repository, file, size, contentType, domain.FILE_UPLOADED)
+		if err != nil {
+			logger.Error("Error updating file metadata", "id", file.ID, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		logger.Debug("File uploaded successfully", "id", file.ID, "size", size)
+		JSON(w, http.StatusOK, updated)
+	}
+}
+
+// DownloadFile redirects to a presigned URL if the backend supports it, otherwise streams the content. The variants
+// of the images are selected with the variant query parameter, e.g. ?variant=thumb.
+func (server *Server) DownloadFile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, file, ok := loadFile(w, r)
+		if !ok {
+			return
+		}
+		server.serveFile(w, r, file)
+	}
+}
+
+// serveFile serves the uploaded content of the file, or the image variant of the variant query parameter
+func (server *Server) serveFile(w http.ResponseWriter, r *http.Request, file *domain.File) {
+	if file.Status == domain.FILE_QUARANTINED {
+		ERROR(w, http.StatusUnprocessableEntity, WithCode(CODE_INFECTED, fmt.Errorf("file content is quarantined")))
+		return
+	}
+	if file.Status != domain.FILE_UPLOADED {
+		ERROR(w, http.StatusNotFound, fmt.Errorf("file content is not uploaded"))
+		return
+	}
+	if variant := r.URL.Query().Get("variant"); variant != "" {
+		if _, ok := server.StorageConfig.ImageVariants[variant]; !ok {
+			ERROR(w, http.StatusNotFound, fmt.Errorf("unrecognized image variant: %s", variant))
+			return
+		}
+		if !imaging.Supported(file.ContentType) {
+			ERROR(w, http.StatusNotFound, fmt.Errorf("file is not an image, it has no variants"))
+			return
+		}
+		server.serveObject(w, r, variantKey(file, variant), file.Name, imaging.VariantType(file.ContentType))
+		return
+	}
+
+	server.serveObject(w, r, file.StorageKey, file.Name, file.ContentType)
+}
+
+// serveObject redirects to a presigned URL if the backend supports it, otherwise streams the stored object
+func (server *Server) serveObject(w http.ResponseWriter, r *http.Request, key, name, contentType string) {
+	ctx := r.Context()
+	logger := common.GetLogger(ctx)
+	url, err := server.Storage.PresignGet(ctx, key, server.StorageConfig.PresignExpiry)
+	if err == nil {
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+		return
+	}
+	if !errors.Is(err, storage.ErrPresignNotSupported) {
+		logger.Error("Error presigning download", "key", key, "error", err)
+		ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	size, err := server.Storage.Stat(ctx, key)
+	if err != nil {
+		logger.Error("Error reading stored object", "key", key, "error", err)
+		ERROR(w, storageStatus(err), err)
+		return
+	}
+	content, err := server.Storage.Get(ctx, key)
+	if err != nil {
+		logger.Error("Error reading stored object", "key", key, "error", err)
+		ERROR(w, storageStatus(err), err)
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, content)
+	if err != nil {
+		logger.Error("Error streaming stored object", "key", key, "error", err)
+	}
+}
+
+// FileUploadURL returns a presigned URL the client uploads the content to, the upload is finished by CompleteFileUpload
+func (server *Server) FileUploadURL() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		_, file, ok := loadFile(w, r)
+		if !ok {
+			return
+		}
+		url, err := server.Storage.PresignPut(ctx, file.StorageKey, server.StorageConfig.PresignExpiry)
+		if errors.Is(err, storage.ErrPresignNotSupported) {
+			ERROR(w, http.StatusNotImplemented, err)
+			return
+		}
+		if err != nil {
+			logger.Error("Error presigning file upload", "id", file.ID, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, PresignedURL{
+			URL:       url,
+			Method:    http.MethodPut,
+			ExpiresAt: time.Now().Add(server.StorageConfig.PresignExpiry),
+		})
+	}
+}
+
+// CompleteFileUpload verifies the content uploaded with a presigned URL and marks the file as uploaded
+func (server *Server) CompleteFileUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository, file, ok := loadFile(w, r)
+		if !ok {
+			return
+		}
+		size, err := server.Storage.Stat(ctx, file.StorageKey)
+		if err != nil {
+			logger.Error("Error reading uploaded file", "id", file.ID, "error", err)
+			ERROR(w, storageStatus(err), err)
+			return
+		}
+		if size > server.StorageConfig.MaxUploadSize {
+			server.Storage.Delete(ctx, file.StorageKey)
+			ERROR(w, http.StatusRequestEntityTooLarge, fmt.Errorf("file exceeds the maximum size of %d bytes", server.StorageConfig.MaxUploadSize))
+			return
+		}
+		if !server.scanFile(w, r, repository, file, size, file.ContentType) {
+			return
+		}
+		size, err = server.processImage(ctx, file, size, file.ContentType)
+		if err != nil {
+			logger.Error("Error processing image", "id", file.ID, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		updated, err := markContent(r, repository, file, size, file.ContentType, domain.FILE_UPLOADED)
+		if err != nil {
+			logger.Error("Error updating file metadata", "id", file.ID, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, updated)
+	}
+}
+
+// DeleteFile removes the content and the metadata of a file
+func (server *Server) DeleteFile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository, file, ok := loadFile(w, r)
+		if !ok {
+			return
+		}
+		err := server.deleteVariants(ctx, file)
+		if err == nil {
+			err = server.Storage.Delete(ctx, file.StorageKey)
+		}
+		if err != nil {
+			logger.Error("Error deleting file content", "id", file.ID, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		err = repository.Delete(ctx, file.ID)
+		if err != nil {
+			logger.Error("Error deleting file", "id", file.ID, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusNoContent, "")
+	}
+}
+
+// loadFile loads the file from the request path, errors are written to the response
+func loadFile(w http.ResponseWriter, r *http.Request) (*common.RequestContext, *domain.File, bool) {
+	ctx := r.Context()
+	logger := common.GetLogger(ctx)
+	repository := common.GetRequestContext(ctx)
+	if repository == nil {
+		logger.Error("Error reading repository from context")
+		ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+		return nil, nil, false
+	}
+	uid, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		logger.Error("Error parsing UUID from request", "error", err)
+		ERROR(w, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	object, err := repository.Get(ctx, uid)
+	if err != nil {
+		logger.Error("Error getting file", "error", err)
+		ERROR(w, http.StatusNotFound, err)
+		return nil, nil, false
+	}
+	return repository, object.(*domain.File), true
+}
+
+// scanFile scans the stored content with the virus scanner before the file becomes downloadable. The infected
+// files are quarantined, the files that could not be scanned stay pending. Errors are written to the response.
+func (server *Server) scanFile(w http.ResponseWriter, r *http.Request, repository *common.RequestContext, file *domain.File, size int64, contentType string) bool {
+	if server.Scanner == nil {
+		return true
+	}
+	ctx := r.Context()
+	logger := common.GetLogger(ctx)
+	content, err := server.Storage.Get(ctx, file.StorageKey)
+	if err != nil {
+		logger.Error("Error reading uploaded file", "id", file.ID, "error", err)
+		ERROR(w, storageStatus(err), err)
+		return false
+	}
+	result, err := server.Scanner.Scan(ctx, content)
+	content.Close()
+	if err != nil {
+		logger.Error("Error scanning uploaded file", "id", file.ID, "error", err)
+		ERROR(w, http.StatusBadGateway, fmt.Errorf("cannot scan the file content: %w", err))
+		return false
+	}
+	if result.Clean {
+		return true
+	}
+	logger.Warn("Infected file quarantined", "id", file.ID, "threat", result.Threat)
+	_, err = markContent(r, repository, file, size, contentType, domain.FILE_QUARANTINED)
+	if err != nil {
+		logger.Error("Error updating file metadata", "id", file.ID, "error", err)
+		ERROR(w, http.StatusInternalServerError, err)
+		return false
+	}
+	ERROR(w, http.StatusUnprocessableEntity, WithCode(CODE_INFECTED, result.Err()))
+	return false
+}
+
+// processImage strips the metadata of the uploaded images and generates their variants, and returns the size of
+// the stored content. The content that cannot be decoded is kept as it is, without variants.
+func (server *Server) processImage(ctx context.Context, file *domain.File, size int64, contentType string) (int64, error) {
+	config := server.StorageConfig
+	if len(config.ImageVariants) == 0 && !config.ImageStripEXIF {
+		return size, nil
+	}
+	// The variants of the previous content are not served for the new one
+	err := server.deleteVariants(ctx, file)
+	if err != nil {
+		return size, err
+	}
+	if !imaging.Supported(contentType) {
+		return size, nil
+	}
+	logger := common.GetLogger(ctx)
+	content, err := server.Storage.Get(ctx, file.StorageKey)
+	if err != nil {
+		return size, err
+	}
+	data, err := io.ReadAll(content)
+	content.Close()
+	if err != nil {
+		return size, err
+	}
+	if config.ImageStripEXIF {
+		stripped, err := imaging.StripMetadata(data, contentType)
+		if err != nil {
+			logger.Warn("Cannot strip image metadata", "id", file.ID, "error", err)
+			return size, nil
+		}
+		if !bytes.Equal(stripped, data) {
+			err = server.Storage.Put(ctx, file.StorageKey, bytes.NewReader(stripped), int64(len(stripped)), contentType)
+			if err != nil {
+				return size, err
+			}
+			data, size = stripped, int64(len(stripped))
+		}
+	}
+	for name, value := range config.ImageVariants {
+		box, err := imaging.ParseSize(value)
+		if err != nil {
+			return size, err
+		}
+		variant, err := imaging.Variant(data, contentType, box)
+		if err != nil {
+			logger.Warn("Cannot generate image variant", "id", file.ID, "variant", name, "error", err)
+			return size, nil
+		}
+		err = server.Storage.Put(ctx, variantKey(file, name), bytes.NewReader(variant), int64(len(variant)), imaging.VariantType(contentType))
+		if err != nil {
+			return size, err
+		}
+	}
+	return size, nil
+}
+
+// deleteVariants removes the image variants of the file
+func (server *Server) deleteVariants(ctx context.Context, file *domain.File) error {
+	for name := range server.StorageConfig.ImageVariants {
+		err := server.Storage.Delete(ctx, variantKey(file, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// variantKey is the storage key of the image variant of the file
+func variantKey(file *domain.File, variant string) string {
+	return fmt.Sprintf("%s-%s", file.StorageKey, variant)
+}
+
+// markContent stores the size, the content type and the status of the uploaded content
+func markContent(r *http.Request, repository *common.RequestContext, file *domain.File, size int64, contentType, status string) (domain.Object, error) {
+	file.Size = size
+	file.ContentType = contentType
+	file.Status = status
+	body, err := json.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+	return repository.UpdateManaged(r.Context(), file.ID, body)
+}
+
+// storageStatus maps storage errors to response status codes
+func storageStatus(err error) int {
+	if errors.Is(err, storage.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/fixtures"
+)
+
+// LoadFixtures creates the users and objects of the YAML or JSON fixtures files through the request context,
+// so that the events are published as for API requests. Loading is idempotent, existing objects are not changed.
+func (server *Server) LoadFixtures(ctx context.Context, paths ...string) (fixtures.References, error) {
+	datasets := make([]*fixtures.Dataset, 0, len(paths))
+	for _, path := range paths {
+		dataset, err := fixtures.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		datasets = append(datasets, dataset)
+	}
+	references, err := fixtures.Load(ctx, fixturesStore{server: server}, nil, datasets...)
+	if err != nil {
+		return nil, err
+	}
+	common.GetLogger(ctx).Info("Fixtures loaded", "files", len(paths), "references", len(references))
+	return references, nil
+}
+
+// fixturesStore implements fixtures.Store with the server components
+type fixturesStore struct {
+	server *Server
+}
+
+// SaveUser stores the user unless it already exists
+func (store fixturesStore) SaveUser(ctx context.Context, user *domain.User) error {
+	_, err := store.server.DBLoadUser(ctx, user.ID.String())
+	if err == nil {
+		return nil
+	}
+	return store.server.DBSaveUser(ctx, user)
+}
+
+// NewRequestContext creates the request context of the resource owned by the user
+func (store fixturesStore) NewRequestContext(resourceName string, owner *domain.User) (*common.RequestContext, error) {
+	resource, ok := store.server.Resources.Resources[resourceName]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized resource name: %s", resourceName)
+	}
+	return store.server.newRequestContextWithDetails(context.Background(), common.MinPageSize, 1, 0, owner, resource, nil), nil
+}
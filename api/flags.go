@@ -0,0 +1,22 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/flags"
+)
+
+// FeatureFlags returns the feature flags evaluated for the caller
+func (server *Server) FeatureFlags() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		set := flags.FromContext(ctx)
+		if set == nil {
+			set = flags.Set{}
+		}
+		logger.Debug("Feature flags evaluated", "flags", set)
+		JSON(w, http.StatusOK, set)
+	}
+}
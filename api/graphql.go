@@ -0,0 +1,577 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	uuidType    = reflect.TypeOf(uuid.UUID{})
+	timeType    = reflect.TypeOf(time.Time{})
+	decimalType = reflect.TypeOf(domain.Decimal{})
+
+	// jsonScalar passes arbitrary JSON values through the schema
+	jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+		Name:         "JSON",
+		Description:  "Arbitrary JSON value",
+		Serialize:    func(value interface{}) interface{} { return value },
+		ParseValue:   func(value interface{}) interface{} { return value },
+		ParseLiteral: parseJSONLiteral,
+	})
+
+	// decimalScalar keeps decimals exact as strings, numbers are accepted as input
+	decimalScalar = graphql.NewScalar(graphql.ScalarConfig{
+		Name:         "Decimal",
+		Description:  "Exact decimal number serialized as string, e.g. \"12.30\"",
+		Serialize:    func(value interface{}) interface{} { return value },
+		ParseValue:   parseDecimal,
+		ParseLiteral: parseDecimalLiteral,
+	})
+)
+
+// decimalOperators are the comparisons of decimal fields in filters, e.g. cost_gte
+var decimalOperators = map[string]func(column clause.Column, value interface{}) clause.Expression{
+	"_gt": func(column clause.Column, value interface{}) clause.Expression {
+		return clause.Gt{Column: column, Value: value}
+	},
+	"_gte": func(column clause.Column, value interface{}) clause.Expression {
+		return clause.Gte{Column: column, Value: value}
+	},
+	"_lt": func(column clause.Column, value interface{}) clause.Expression {
+		return clause.Lt{Column: column, Value: value}
+	},
+	"_lte": func(column clause.Column, value interface{}) clause.Expression {
+		return clause.Lte{Column: column, Value: value}
+	},
+}
+
+// graphQLRequest is the body of a GraphQL request
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// graphQLField describes a JSON field of a resource type
+type graphQLField struct {
+	Name   string
+	GoName string
+	Type   reflect.Type
+}
+
+// graphQLBuilder generates the schema from the registered resources
+type graphQLBuilder struct {
+	server  *Server
+	objects map[reflect.Type]*graphql.Object
+}
+
+// GraphQL executes GraphQL queries and mutations against the registered resources
+func (server *Server) GraphQL() (http.HandlerFunc, error) {
+	builder := &graphQLBuilder{server: server, objects: map[reflect.Type]*graphql.Object{}}
+	graphQLSchema, err := builder.build()
+	if err != nil {
+		return nil, fmt.Errorf("cannot build graphql schema: %w", err)
+	}
+	resourceObjects := map[*graphql.Object]bool{}
+	for _, object := range builder.objects {
+		resourceObjects[object] = true
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+
+		tokenString, err := bearerToken(r)
+		if err != nil {
+			logger.Error("Unauthorized request, missing or invalid Authorization header", "error", err)
+			ERROR(w, http.StatusUnauthorized, err)
+			return
+		}
+		user, permissions, err := server.authenticate(ctx, tokenString)
+		if err != nil {
+			ERROR(w, authenticationStatus(err), err)
+			return
+		}
+		ctx = respitectx.WithCurrentUser(ctx, user)
+		ctx = respitectx.WithPermissions(ctx, permissions)
+		ctx = server.delegate(ctx, tokenString)
+		if tenant := server.tenantID(ctx, user); tenant != "" {
+			ctx = respitectx.WithTenant(ctx, tenant)
+		}
+
+		request := graphQLRequest{}
+		if r.Method == http.MethodGet {
+			request.Query = r.URL.Query().Get("query")
+			request.OperationName = r.URL.Query().Get("operationName")
+			if variables := r.URL.Query().Get("variables"); variables != "" {
+				err = json.Unmarshal([]byte(variables), &request.Variables)
+			}
+		} else {
+			err = json.NewDecoder(r.Body).Decode(&request)
+		}
+		if err != nil {
+			logger.Error("Error reading graphql request", "error", err)
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		logger.Debug("GraphQL request received", "operation", request.OperationName)
+
+		err = server.checkGraphQL(graphQLSchema, resourceObjects, request)
+		if err != nil {
+			logger.Error("GraphQL request is too complex", "error", err)
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         graphQLSchema,
+			RequestString:  request.Query,
+			VariableValues: request.Variables,
+			OperationName:  request.OperationName,
+			Context:        ctx,
+		})
+		if result.HasErrors() {
+			logger.Debug("GraphQL request completed with errors", "errors", result.Errors)
+		}
+		JSON(w, http.StatusOK, result)
+	}, nil
+}
+
+// build creates query and mutation types for all registered resources, the read-only servers and the SQL views have
+// no mutations
+func (builder *graphQLBuilder) build() (graphql.Schema, error) {
+	for _, resource := range builder.server.Resources.Resources {
+		builder.objects[resource.Type] = builder.objectType(resource)
+	}
+
+	queries := graphql.Fields{}
+	mutations := graphql.Fields{}
+	for _, resource := range builder.server.Resources.Resources {
+		builder.addQueries(queries, resource)
+		if !builder.server.ServerConfig.ReadOnly && resource.SQLView == nil {
+			builder.addMutations(mutations, resource)
+		}
+	}
+	config := graphql.SchemaConfig{Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: queries})}
+	if len(mutations) > 0 {
+		config.Mutation = graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutations})
+	}
+	return graphql.NewSchema(config)
+}
+
+// objectType creates the output type of the resource, fields are resolved lazily to allow references between resources
+func (builder *graphQLBuilder) objectType(resource common.Resource) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: typeName(resource.Name),
+		Fields: (graphql.FieldsThunk)(func() graphql.Fields {
+			fields := graphql.Fields{}
+			for _, field := range jsonFields(resource.Type) {
+				fields[field.Name] = &graphql.Field{Type: builder.outputType(field.Type)}
+			}
+			return fields
+		}),
+	})
+}
+
+// addQueries registers single object and list queries for the resource
+func (builder *graphQLBuilder) addQueries(queries graphql.Fields, resource common.Resource) {
+	object := builder.objects[resource.Type]
+	queries[resource.Name] = &graphql.Field{
+		Type: object,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			requestContext, err := builder.requestContext(p.Context, resource, READ, OPERATION_GET, 1, common.MinPageSize)
+			if err != nil {
+				return nil, err
+			}
+			uid, err := uuid.FromString(fmt.Sprint(p.Args["id"]))
+			if err != nil {
+				return nil, err
+			}
+			result, err := requestContext.Get(p.Context, uid)
+			if err != nil {
+				return nil, err
+			}
+			return toGraphQLValue(builder.server.present(p.Context, resource, result))
+		},
+	}
+
+	queries[resource.Name+"List"] = &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: typeName(resource.Name) + "List",
+			Fields: graphql.Fields{
+				"count":     &graphql.Field{Type: graphql.Int},
+				"page":      &graphql.Field{Type: graphql.Int},
+				"page_size": &graphql.Field{Type: graphql.Int},
+				"data":      &graphql.Field{Type: graphql.NewList(object)},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"page":      &graphql.ArgumentConfig{Type: graphql.Int},
+			"page_size": &graphql.ArgumentConfig{Type: graphql.Int},
+			"filter":    &graphql.ArgumentConfig{Type: builder.inputType(resource, "Filter", true)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			page, _ := p.Args["page"].(int)
+			pageSize, _ := p.Args["page_size"].(int)
+			requestContext, err := builder.requestContext(p.Context, resource, READ, OPERATION_LIST, page, resource.PageSize(pageSize))
+			if err != nil {
+				return nil, err
+			}
+			err = builder.server.checkOffset(requestContext.DBScopes.Page, requestContext.DBScopes.PageSize)
+			if err != nil {
+				return nil, err
+			}
+			if filter, ok := p.Args["filter"].(map[string]interface{}); ok && len(filter) > 0 {
+				conditions, err := builder.columns(resource, filter)
+				if err != nil {
+					return nil, err
+				}
+				requestContext.DB = requestContext.DB.Where(clause.And(conditions...))
+			}
+			list, err := requestContext.GetAll(p.Context)
+			if err != nil {
+				return nil, err
+			}
+			return toGraphQLValue(builder.server.presentList(p.Context, resource, list))
+		},
+	}
+}
+
+// addMutations registers create, update and delete mutations for the resource
+func (builder *graphQLBuilder) addMutations(mutations graphql.Fields, resource common.Resource) {
+	object := builder.objects[resource.Type]
+	input := builder.inputType(resource, "Input", false)
+	name := typeName(resource.Name)
+
+	mutations["create"+name] = &graphql.Field{
+		Type: object,
+		Args: graphql.FieldConfigArgument{
+			"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			requestContext, err := builder.requestContext(p.Context, resource, WRITE, OPERATION_CREATE, 1, common.MinPageSize)
+			if err != nil {
+				return nil, err
+			}
+			body, err := json.Marshal(p.Args["input"])
+			if err != nil {
+				return nil, err
+			}
+			result, err := requestContext.Create(p.Context, body)
+			if err != nil {
+				return nil, err
+			}
+			return toGraphQLValue(builder.server.present(p.Context, resource, result))
+		},
+	}
+
+	mutations["update"+name] = &graphql.Field{
+		Type: object,
+		Args: graphql.FieldConfigArgument{
+			"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+			"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			requestContext, err := builder.requestContext(p.Context, resource, WRITE, OPERATION_UPDATE, 1, common.MinPageSize)
+			if err != nil {
+				return nil, err
+			}
+			uid, err := uuid.FromString(fmt.Sprint(p.Args["id"]))
+			if err != nil {
+				return nil, err
+			}
+			body, err := json.Marshal(p.Args["input"])
+			if err != nil {
+				return nil, err
+			}
+			result, err := requestContext.Update(p.Context, uid, body)
+			if err != nil {
+				return nil, err
+			}
+			return toGraphQLValue(builder.server.present(p.Context, resource, result))
+		},
+	}
+
+	mutations["delete"+name] = &graphql.Field{
+		Type: graphql.Boolean,
+		Args: graphql.FieldConfigArgument{
+			"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+			"confirm": &graphql.ArgumentConfig{Type: graphql.Boolean},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			requestContext, err := builder.requestContext(p.Context, resource, WRITE, OPERATION_DELETE, 1, common.MinPageSize)
+			if err != nil {
+				return nil, err
+			}
+			uid, err := uuid.FromString(fmt.Sprint(p.Args["id"]))
+			if err != nil {
+				return nil, err
+			}
+			// Dangerous resources are deleted only when the deletion is confirmed
+			if confirm, _ := p.Args["confirm"].(bool); resource.ConfirmDelete && !confirm {
+				return nil, WithCode(CODE_CONFIRMATION, fmt.Errorf("deleting %s requires the confirm argument", resource.Name))
+			}
+			err = requestContext.Delete(p.Context, uid)
+			if err != nil {
+				return nil, err
+			}
+			return true, nil
+		},
+	}
+}
+
+// requestContext checks the permission of the current user and the sensitive operations and creates a request
+// context with ownership scoping
+func (builder *graphQLBuilder) requestContext(ctx context.Context, resource common.Resource, permission, operation string, page, pageSize int) (*common.RequestContext, error) {
+	user, _ := respitectx.CurrentUser(ctx)
+	permissions := common.GetPermissions(ctx)
+	if !permissions.Can(resource.Name, permission) {
+		common.GetLogger(ctx).Error("Unauthorized request, no permission for resource", "resource", resource.Name, "permission", permission)
+		return nil, fmt.Errorf("unauthorized, no permission for %s.%s", resource.Name, permission)
+	}
+	err := builder.server.checkSensitive(ctx, resource, operation)
+	if err != nil {
+		return nil, err
+	}
+	if permission == WRITE {
+		err := builder.server.checkConsent(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	page, pageSize = common.NormalizePageWithin(page, pageSize, builder.server.maxPageSize(ctx, user))
+	return builder.server.newRequestContextWithDetails(ctx, pageSize, page, (page-1)*pageSize, user, resource, permissions), nil
+}
+
+// inputType creates an input object with the scalar fields of the resource
+func (builder *graphQLBuilder) inputType(resource common.Resource, suffix string, withID bool) *graphql.InputObject {
+	fields := graphql.InputObjectConfigFieldMap{}
+	for _, field := range jsonFields(resource.Type) {
+		if !withID && (field.Name == "id" || field.Name == "created_at" || field.Name == "updated_at") {
+			continue
+		}
+		inputType := scalarType(field.Type)
+		if inputType == nil {
+			if suffix == "Filter" {
+				continue
+			}
+			inputType = jsonScalar
+		}
+		fields[field.Name] = &graphql.InputObjectFieldConfig{Type: inputType}
+		if suffix == "Filter" && inputType == decimalScalar {
+			for operator := range decimalOperators {
+				fields[field.Name+operator] = &graphql.InputObjectFieldConfig{Type: decimalScalar}
+			}
+		}
+	}
+	return graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:   typeName(resource.Name) + suffix,
+		Fields: fields,
+	})
+}
+
+// outputType maps a Go type to a GraphQL output type
+func (builder *graphQLBuilder) outputType(t reflect.Type) graphql.Output {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if scalar := scalarType(t); scalar != nil {
+		return scalar
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if object, ok := builder.objects[t]; ok {
+			return object
+		}
+	case reflect.Slice, reflect.Array:
+		return graphql.NewList(builder.outputType(t.Elem()))
+	}
+	return jsonScalar
+}
+
+// columns maps filter fields to database columns of the resource
+func (builder *graphQLBuilder) columns(resource common.Resource, filter map[string]interface{}) ([]clause.Expression, error) {
+	resourceSchema, err := schema.Parse(reflect.New(resource.Type).Interface(), &sync.Map{}, builder.server.DB.NamingStrategy)
+	if err != nil {
+		return nil, err
+	}
+	goNames := map[string]string{}
+	for _, field := range jsonFields(resource.Type) {
+		goNames[field.Name] = field.GoName
+	}
+	conditions := []clause.Expression{}
+	for name, value := range filter {
+		condition := func(column clause.Column, value interface{}) clause.Expression {
+			return clause.Eq{Column: column, Value: value}
+		}
+		if _, ok := goNames[name]; !ok {
+			for suffix, operator := range decimalOperators {
+				if fieldName, found := strings.CutSuffix(name, suffix); found {
+					name, condition = fieldName, operator
+					break
+				}
+			}
+		}
+		field := resourceSchema.LookUpField(goNames[name])
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("unsupported filter field: %s", name)
+		}
+		conditions = append(conditions, condition(clause.Column{Table: clause.CurrentTable, Name: field.DBName}, value))
+	}
+	return conditions, nil
+}
+
+// scalarType maps a Go type to a GraphQL scalar or returns nil if it is not a scalar
+func scalarType(t reflect.Type) *graphql.Scalar {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == uuidType:
+		return graphql.ID
+	case t == timeType:
+		return graphql.String
+	case t == decimalType:
+		return decimalScalar
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return graphql.String
+	case reflect.Bool:
+		return graphql.Boolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return graphql.Int
+	case reflect.Float32, reflect.Float64:
+		return graphql.Float
+	}
+	return nil
+}
+
+// jsonFields returns the fields of the type as seen by encoding/json, embedded structs are flattened
+func jsonFields(t reflect.Type) []graphQLField {
+	fields := []graphQLField{}
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		tag := structField.Tag.Get("json")
+		if tag == "-" || (!structField.IsExported() && !structField.Anonymous) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if structField.Anonymous && name == "" && structField.Type.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(structField.Type)...)
+			continue
+		}
+		if name == "" {
+			name = structField.Name
+		}
+		fields = append(fields, graphQLField{Name: name, GoName: structField.Name, Type: structField.Type})
+	}
+	return fields
+}
+
+// typeName converts a resource name to a GraphQL type name
+func typeName(resourceName string) string {
+	if resourceName == "" {
+		return resourceName
+	}
+	return strings.ToUpper(resourceName[:1]) + resourceName[1:]
+}
+
+// toGraphQLValue converts a value to its JSON representation, so that fields are resolved by JSON names
+func toGraphQLValue(value interface{}) (interface{}, error) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parseDecimal validates a decimal variable given as string or number, invalid values are nil
+func parseDecimal(value interface{}) interface{} {
+	var text string
+	switch typed := value.(type) {
+	case string:
+		text = typed
+	case json.Number:
+		text = typed.String()
+	case float64:
+		return decimal.NewFromFloat(typed).String()
+	case int:
+		return strconv.Itoa(typed)
+	default:
+		return nil
+	}
+	parsed, err := decimal.NewFromString(text)
+	if err != nil {
+		return nil
+	}
+	return parsed.String()
+}
+
+// parseDecimalLiteral validates an inline decimal given as string or number, the digits are kept exact
+func parseDecimalLiteral(valueAST ast.Value) interface{} {
+	switch value := valueAST.(type) {
+	case *ast.StringValue:
+		return parseDecimal(value.Value)
+	case *ast.IntValue:
+		return parseDecimal(value.Value)
+	case *ast.FloatValue:
+		return parseDecimal(value.Value)
+	}
+	return nil
+}
+
+// parseJSONLiteral converts an inline GraphQL value to a Go value
+func parseJSONLiteral(valueAST ast.Value) interface{} {
+	switch value := valueAST.(type) {
+	case *ast.StringValue:
+		return value.Value
+	case *ast.BooleanValue:
+		return value.Value
+	case *ast.IntValue:
+		result, _ := strconv.ParseInt(value.Value, 10, 64)
+		return result
+	case *ast.FloatValue:
+		result, _ := strconv.ParseFloat(value.Value, 64)
+		return result
+	case *ast.ListValue:
+		result := make([]interface{}, 0, len(value.Values))
+		for _, item := range value.Values {
+			result = append(result, parseJSONLiteral(item))
+		}
+		return result
+	case *ast.ObjectValue:
+		result := map[string]interface{}{}
+		for _, field := range value.Fields {
+			result[field.Name.Value] = parseJSONLiteral(field.Value)
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,373 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/dzahariev/respite/auth"
+	"github.com/dzahariev/respite/breaker"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/flags"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/dzahariev/respite/tracing"
+	"github.com/gofrs/uuid/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gorm.io/gorm"
+)
+
+// resourcesService is the gRPC counterpart of the REST handlers, described in proto/respite/v1/resources.proto
+type resourcesService interface {
+	Get(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error)
+	List(request *structpb.Struct, stream grpc.ServerStream) error
+	Create(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error)
+	Update(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error)
+	Delete(ctx context.Context, request *structpb.Struct) (*emptypb.Empty, error)
+}
+
+// grpcService implements resourcesService on top of the request context
+type grpcService struct {
+	server *Server
+}
+
+const resourcesServiceName = "respite.v1.Resources"
+
+var resourcesServiceDesc = grpc.ServiceDesc{
+	ServiceName: resourcesServiceName,
+	HandlerType: (*resourcesService)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Get", Handler: unaryHandler("Get", resourcesService.Get)},
+		{MethodName: "Create", Handler: unaryHandler("Create", resourcesService.Create)},
+		{MethodName: "Update", Handler: unaryHandler("Update", resourcesService.Update)},
+		{MethodName: "Delete", Handler: unaryHandler("Delete", resourcesService.Delete)},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "List",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				request := &structpb.Struct{}
+				err := stream.RecvMsg(request)
+				if err != nil {
+					return err
+				}
+				return srv.(resourcesService).List(request, stream)
+			},
+		},
+	},
+	Metadata: "respite/v1/resources.proto",
+}
+
+// unaryHandler adapts a service method to the generic gRPC method handler
+func unaryHandler[T any](name string, method func(resourcesService, context.Context, *structpb.Struct) (T, error)) grpc.MethodHandler {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		request := &structpb.Struct{}
+		err := dec(request)
+		if err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return method(srv.(resourcesService), ctx, req.(*structpb.Struct))
+		}
+		if interceptor == nil {
+			return handler(ctx, request)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fmt.Sprintf("/%s/%s", resourcesServiceName, name)}
+		return interceptor(ctx, request, info, handler)
+	}
+}
+
+// NewGRPCServer creates a gRPC server exposing the registered resources with token authentication
+func (server *Server) NewGRPCServer(options ...grpc.ServerOption) *grpc.Server {
+	options = append(options,
+		grpc.ChainUnaryInterceptor(server.grpcUnaryAuth),
+		grpc.ChainStreamInterceptor(server.grpcStreamAuth),
+	)
+	grpcServer := grpc.NewServer(options...)
+	grpcServer.RegisterService(&resourcesServiceDesc, &grpcService{server: server})
+	return grpcServer
+}
+
+// grpcUnaryAuth authenticates unary calls using the authorization metadata
+func (server *Server) grpcUnaryAuth(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := server.grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, request)
+}
+
+// grpcStreamAuth authenticates streaming calls using the authorization metadata
+func (server *Server) grpcStreamAuth(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := server.grpcAuthenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// grpcAuthenticate verifies the bearer token and returns a context with the current user and permissions
+func (server *Server) grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	requestID := uuid.Must(uuid.NewV4()).String()
+	ctx = respitectx.WithLogger(ctx, slog.Default().With("request_id", requestID).With(tracing.LogAttributes(ctx)...))
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	var token string
+	if len(values) == 0 || len(values[0]) < 7 || !strings.EqualFold(values[0][:6], "bearer") {
+		if !server.devMode {
+			return nil, status.Error(codes.Unauthenticated, "unauthorized, missing bearer authorization metadata")
+		}
+	} else {
+		token = strings.TrimSpace(values[0][7:])
+	}
+	user, permissions, err := server.authenticate(ctx, token)
+	if errors.Is(err, auth.ErrUnavailable) {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if errors.Is(err, ErrTenantSuspended) || errors.Is(err, ErrTenantDomain) || errors.Is(err, ErrUserDeactivated) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	ctx = respitectx.WithCurrentUser(ctx, user)
+	ctx = respitectx.WithPermissions(ctx, permissions)
+	if server.Flags != nil {
+		ctx = flags.NewContext(ctx, server.Flags.Evaluate(user))
+	}
+	return server.delegate(ctx, token), nil
+}
+
+// authenticatedStream replaces the stream context with the authenticated one
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the authenticated context
+func (stream *authenticatedStream) Context() context.Context {
+	return stream.ctx
+}
+
+// Get loads an object by given ID
+func (service *grpcService) Get(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+	requestContext, err := service.requestContext(ctx, request, READ, OPERATION_GET, 1, common.MinPageSize)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := grpcID(request)
+	if err != nil {
+		return nil, err
+	}
+	object, err := requestContext.Get(ctx, uid)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toStruct(service.server.present(ctx, requestContext.Resource, object))
+}
+
+// List streams all objects visible to the caller in batches of page_size
+func (service *grpcService) List(request *structpb.Struct, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	resource := service.server.Resources.Resources[request.GetFields()["resource"].GetStringValue()]
+	pageSize := resource.PageSize(int(request.GetFields()["page_size"].GetNumberValue()))
+	var after *common.Cursor
+	for page := 1; ; page++ {
+		requestContext, err := service.requestContext(ctx, request, READ, OPERATION_LIST, page, pageSize)
+		if err != nil {
+			return err
+		}
+		// The pages are ordered by created_at and id as the REST lists, and start after the last object of the
+		// previous page, so that the objects created or deleted during the stream do not shift them. The
+		// repositories page by offset.
+		if after != nil && requestContext.Repository == nil {
+			requestContext.PageAfter(after)
+		}
+		list, err := requestContext.GetAll(ctx)
+		if err != nil {
+			return grpcError(err)
+		}
+		for _, object := range list.Data {
+			message, err := toStruct(service.server.present(ctx, requestContext.Resource, object))
+			if err != nil {
+				return err
+			}
+			err = stream.SendMsg(message)
+			if err != nil {
+				return err
+			}
+		}
+		if len(list.Data) < requestContext.DBScopes.PageSize {
+			return nil
+		}
+		after = common.NextCursor(list.Data, requestContext.DBScopes.PageSize)
+	}
+}
+
+// Create is caled to create an object
+func (service *grpcService) Create(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+	requestContext, err := service.requestContext(ctx, request, WRITE, OPERATION_CREATE, 1, common.MinPageSize)
+	if err != nil {
+		return nil, err
+	}
+	body, err := grpcData(request)
+	if err != nil {
+		return nil, err
+	}
+	object, err := requestContext.Create(ctx, body)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toStruct(service.server.present(ctx, requestContext.Resource, object))
+}
+
+// Update updates existing object
+func (service *grpcService) Update(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+	requestContext, err := service.requestContext(ctx, request, WRITE, OPERATION_UPDATE, 1, common.MinPageSize)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := grpcID(request)
+	if err != nil {
+		return nil, err
+	}
+	body, err := grpcData(request)
+	if err != nil {
+		return nil, err
+	}
+	object, err := requestContext.Update(ctx, uid, body)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toStruct(service.server.present(ctx, requestContext.Resource, object))
+}
+
+// Delete deletes an object
+func (service *grpcService) Delete(ctx context.Context, request *structpb.Struct) (*emptypb.Empty, error) {
+	requestContext, err := service.requestContext(ctx, request, WRITE, OPERATION_DELETE, 1, common.MinPageSize)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := grpcID(request)
+	if err != nil {
+		return nil, err
+	}
+	// Dangerous resources are deleted only when the deletion is confirmed
+	if requestContext.Resource.ConfirmDelete && !request.GetFields()["confirm"].GetBoolValue() {
+		return nil, status.Errorf(codes.InvalidArgument, "deleting %s requires the confirm field", requestContext.Resource.Name)
+	}
+	err = requestContext.Delete(ctx, uid)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// requestContext resolves the requested resource, checks the permission and the sensitive operations and creates a
+// scoped request context
+func (service *grpcService) requestContext(ctx context.Context, request *structpb.Struct, permission, operation string, page, pageSize int) (*common.RequestContext, error) {
+	resourceName := request.GetFields()["resource"].GetStringValue()
+	resource, ok := service.server.Resources.Resources[resourceName]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unrecognized resource name: %s", resourceName)
+	}
+	user, _ := respitectx.CurrentUser(ctx)
+	permissions := grpcPermissions(ctx)
+	if !permissions.Can(resource.Name, permission) {
+		common.GetLogger(ctx).Error("Unauthorized request, no permission for resource", "resource", resource.Name, "permission", permission)
+		return nil, status.Errorf(codes.PermissionDenied, "unauthorized, no permission for %s.%s", resource.Name, permission)
+	}
+	err := service.server.checkSensitive(ctx, resource, operation)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if permission == WRITE {
+		if service.server.ServerConfig.ReadOnly {
+			return nil, status.Error(codes.Unimplemented, ErrReadOnly.Error())
+		}
+		if resource.SQLView != nil {
+			return nil, status.Errorf(codes.Unimplemented, "%s: %s is a SQL view", common.ErrReadOnlyResource, resource.Name)
+		}
+		err = service.server.checkConsent(ctx, user)
+		if errors.Is(err, ErrConsentRequired) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		if err != nil {
+			return nil, grpcError(err)
+		}
+	}
+	page, pageSize = common.NormalizePageWithin(page, pageSize, service.server.maxPageSize(ctx, user))
+	return service.server.newRequestContextWithDetails(ctx, pageSize, page, (page-1)*pageSize, user, resource, permissions), nil
+}
+
+// grpcPermissions returns the permissions of the authenticated caller
+func grpcPermissions(ctx context.Context) common.Permissions {
+	return common.GetPermissions(ctx)
+}
+
+// grpcID parses the id field of the request
+func grpcID(request *structpb.Struct) (uuid.UUID, error) {
+	uid, err := uuid.FromString(request.GetFields()["id"].GetStringValue())
+	if err != nil {
+		return uuid.Nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return uid, nil
+}
+
+// grpcData returns the data field of the request as JSON
+func grpcData(request *structpb.Struct) ([]byte, error) {
+	data := request.GetFields()["data"].GetStructValue()
+	if data == nil {
+		return nil, status.Error(codes.InvalidArgument, "missing data")
+	}
+	return data.MarshalJSON()
+}
+
+// grpcError maps repository errors to gRPC status codes
+func grpcError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, domain.ErrValidation) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, domain.ErrWebhook) || errors.Is(err, breaker.ErrOpen) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if errors.Is(err, common.ErrQuotaExceeded) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if errors.Is(err, common.ErrEditLocked) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &syntaxError) || errors.As(err, &typeError) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// toStruct converts an object to its JSON representation as a Struct
+func toStruct(object any) (*structpb.Struct, error) {
+	body, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	message := &structpb.Struct{}
+	err = message.UnmarshalJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
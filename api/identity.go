@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/dzahariev/respite/auth"
+	"github.com/dzahariev/respite/cache"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/inbound"
+	"github.com/dzahariev/respite/scheduler"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// IDENTITY_SYNC_TASK is the scheduled task polling the admin events of Keycloak
+	IDENTITY_SYNC_TASK = "identity-sync"
+	// IDENTITY_SYNC_WEBHOOK is the inbound webhook receiving the admin events of Keycloak
+	IDENTITY_SYNC_WEBHOOK = "keycloak"
+)
+
+// ErrTokenRevoked is returned for the tokens issued before a change of their user in Keycloak
+var ErrTokenRevoked = errors.New("token issued before a change of the user")
+
+// WithIdentitySync consumes the admin events of Keycloak, received with a webhook or polled from the admin API,
+// so that the local users follow the changes of their profiles and the tokens issued before the deletions and
+// the role changes of the users are rejected
+func WithIdentitySync(identitySyncConfig cfg.IdentitySync) Option {
+	return func(server *Server) {
+		server.IdentitySyncConfig = identitySyncConfig
+	}
+}
+
+// initIdentitySync registers the webhook and the polling task of the admin events, the development mode does not
+// authenticate and has no tokens to reject
+func (server *Server) initIdentitySync() error {
+	config := server.IdentitySyncConfig
+	if !config.Enabled() || server.devMode {
+		return nil
+	}
+	// The revocations are shared by the replicas in the cache, they are kept in process memory without it
+	server.revocations = server.Cache
+	if server.revocations == nil {
+		server.revocations = cache.NewMemoryCache("")
+	}
+	if config.WebhookSecret != "" {
+		server.Webhooks = append(server.Webhooks, InboundWebhook{
+			Name:     IDENTITY_SYNC_WEBHOOK,
+			Verifier: inbound.Keycloak{Secret: config.WebhookSecret},
+			Handler:  server.receiveAdminEvent,
+		})
+	}
+	if config.PollInterval > 0 {
+		if server.keycloakClient == nil {
+			return errors.New("invalid configuration:\nIDENTITY_SYNC_POLL_INTERVAL: the admin events are polled with the Keycloak client")
+		}
+		server.identityCursor = time.Now().Add(-config.Lookback)
+		err := server.Scheduler.Register(IDENTITY_SYNC_TASK, "@every "+config.PollInterval.String(), server.pollAdminEvents)
+		if err != nil {
+			return err
+		}
+	}
+	slog.Info("Identity sync initialized", "webhook", config.WebhookSecret != "", "pollInterval", config.PollInterval)
+	return nil
+}
+
+// receiveAdminEvent syncs the admin event of the webhook, the other events of the keycloak-events extension,
+// e.g. the logins, have no user path and are ignored
+func (server *Server) receiveAdminEvent(ctx context.Context, delivery *WebhookDelivery) error {
+	event := auth.AdminEvent{}
+	err := json.Unmarshal(delivery.Payload, &event)
+	if err != nil {
+		common.GetLogger(ctx).Warn("Ignoring invalid admin event", "delivery", delivery.ID, "error", err)
+		return nil
+	}
+	return server.SyncAdminEvent(ctx, event)
+}
+
+// pollAdminEvents syncs the admin events after the last synced one, a failed event is read again by the next poll
+func (server *Server) pollAdminEvents(ctx context.Context, _ *scheduler.TaskContext) error {
+	events, err := server.keycloakClient.AdminEvents(ctx, server.identityCursor)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		err = server.SyncAdminEvent(ctx, event)
+		if err != nil {
+			return fmt.Errorf("admin event %s %s of %s: %w", event.OperationType, event.ResourceType, event.ResourcePath, err)
+		}
+		server.identityCursor = event.Timestamp()
+	}
+	return nil
+}
+
+// SyncAdminEvent applies the admin event of Keycloak to the user of its path. The tokens of the user issued
+// before the event are rejected, except after the creations, so that the clients refresh them and get the current
+// roles. The updates copy the profile to the local user, the deletions erase the data of the user with
+// IDENTITY_SYNC_DELETED_USERS=erase. The events of other resources, e.g. of the clients, are ignored.
+func (server *Server) SyncAdminEvent(ctx context.Context, event auth.AdminEvent) error {
+	logger := common.GetLogger(ctx)
+	userID, ok := event.UserID()
+	if !ok {
+		return nil
+	}
+	if event.OperationType == auth.OPERATION_CREATE && event.ResourceType == auth.RESOURCE_USER {
+		return nil
+	}
+	err := server.revoke(ctx, userID, event.Timestamp())
+	if err != nil {
+		return err
+	}
+	logger.Info("User changed in Keycloak", "userID", userID, "resource", event.ResourceType, "operation", event.OperationType)
+	if event.ResourceType != auth.RESOURCE_USER {
+		return nil
+	}
+	switch event.OperationType {
+	case auth.OPERATION_UPDATE:
+		return server.syncUser(ctx, userID, event)
+	case auth.OPERATION_DELETE:
+		if server.IdentitySyncConfig.DeletedUsers == "erase" {
+			return server.eraseDeletedUser(ctx, userID, event)
+		}
+	}
+	return nil
+}
+
+// syncUser copies the profile of the representation of the event, or of the admin API without it, to the local
+// user. The users that never logged in are created at their first login.
+func (server *Server) syncUser(ctx context.Context, userID uuid.UUID, event auth.AdminEvent) error {
+	user, err := server.DBLoadUser(ctx, userID.String())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	representation, ok := event.User()
+	if !ok {
+		if server.keycloakClient == nil {
+			return nil
+		}
+		representation, err = server.keycloakClient.GetUser(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("cannot read user %s: %w", userID, err)
+		}
+	}
+	representation.Apply(user)
+	return server.DBUpdateUser(ctx, user)
+}
+
+// eraseDeletedUser erases the data of the local user and records the erasure, requested by the administrator
+// that deleted the user
+func (server *Server) eraseDeletedUser(ctx context.Context, userID uuid.UUID, event auth.AdminEvent) error {
+	user, err := server.DBLoadUser(ctx, userID.String())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	caller := &domain.User{Base: domain.Base{ID: userID}}
+	if adminID, err := uuid.FromString(event.AuthDetails.UserID); err == nil {
+		caller.ID = adminID
+	}
+	report := server.eraseUserData(ctx, user, caller)
+	err = server.DB.WithContext(ctx).Create(report).Error
+	if err != nil {
+		return err
+	}
+	if report.Error != "" {
+		return fmt.Errorf("erasure %s failed: %s", report.ID, report.Error)
+	}
+	common.GetLogger(ctx).Info("User data erased", "userID", userID, "report", report.ID, "requestedBy", caller.ID)
+	return nil
+}
+
+// revoke rejects the tokens of the user issued before the time, later revocations of the user take precedence
+func (server *Server) revoke(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	if server.revocations == nil {
+		return nil
+	}
+	revokedAt, ok, err := server.revokedAt(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if ok && !revokedAt.Before(at) {
+		return nil
+	}
+	return server.revocations.Set(ctx, revocationKey(userID), []byte(strconv.FormatInt(at.UnixMilli(), 10)), server.IdentitySyncConfig.RevocationTTL)
+}
+
+// revokedAt returns the time of the last revocation of the user
+func (server *Server) revokedAt(ctx context.Context, userID uuid.UUID) (time.Time, bool, error) {
+	value, ok, err := server.revocations.Get(ctx, revocationKey(userID))
+	if err != nil || !ok {
+		return time.Time{}, false, err
+	}
+	millis, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMilli(millis), true, nil
+}
+
+// checkRevocation rejects the token when it is issued before the last revocation of the user. The times of the
+// tokens have seconds, the tokens issued in the second of the revocation are accepted. The tokens are accepted
+// when the cache fails or the auth client does not know their times.
+func (server *Server) checkRevocation(ctx context.Context, accessToken string, user *domain.User) error {
+	if server.revocations == nil {
+		return nil
+	}
+	issuer, ok := server.AuthClient.(auth.TokenIssuer)
+	if !ok {
+		return nil
+	}
+	revokedAt, ok, err := server.revokedAt(ctx, user.ID)
+	if err != nil {
+		common.GetLogger(ctx).Error("Error reading token revocation", "userID", user.ID, "error", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	issuedAt, err := issuer.IssuedAt(ctx, accessToken)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if issuedAt.Before(revokedAt.Truncate(time.Second)) {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// revocationKey is the cache key of the revocation of the tokens of the user
+func revocationKey(userID uuid.UUID) string {
+	return "revoked:" + userID.String()
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/jobs"
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	IMPORT_NDJSON = "ndjson"
+	IMPORT_CSV    = "csv"
+
+	// csvContentType is the format of the imports of CSV exports, the first row names the fields
+	csvContentType = "text/csv"
+)
+
+// ImportMapping maps the fields of the imported records, e.g. of the exports of other systems, to the fields of
+// the resource. The fields of the records without mapping are kept as they are.
+type ImportMapping struct {
+	// Fields are the mappings of the fields of the resource by JSON name
+	Fields map[string]ImportField `json:"fields"`
+}
+
+// ImportField is the mapping of a field of the resource
+type ImportField struct {
+	// From is the field of the record, the field of the resource has the same name without it
+	From string `json:"from,omitempty"`
+	// Value is the constant value of the field, the record is not read
+	Value any `json:"value,omitempty"`
+	// Lookup replaces the value of the record with the ID of the object that has it
+	Lookup *ImportLookup `json:"lookup,omitempty"`
+	// Format is the layout of the times of the record, e.g. 02.01.2006, RFC 3339 without it
+	Format string `json:"format,omitempty"`
+}
+
+// ImportLookup finds the object of a resource by a unique field, e.g. the category of a book by its name
+type ImportLookup struct {
+	Resource string `json:"resource"`
+	Field    string `json:"field"`
+}
+
+// importParameters are the parameters of the import jobs
+type importParameters struct {
+	Format  string         `json:"format,omitempty"`
+	Mapping *ImportMapping `json:"mapping,omitempty"`
+}
+
+// Check validates the mapping of the fields of the resource, the resources of the lookups are registered
+func (mapping *ImportMapping) Check(resources *common.Resources, resource common.Resource) error {
+	for name, field := range mapping.Fields {
+		target, ok := domain.JSONField(resource.Type, name)
+		if !ok {
+			return fmt.Errorf("mapped field %s is not a field of %s", name, resource.Name)
+		}
+		if field.Value != nil && (field.From != "" || field.Lookup != nil) {
+			return fmt.Errorf("mapped field %s has a constant value, it cannot be read from the record", name)
+		}
+		if field.Format != "" && indirect(target.Type) != reflect.TypeFor[time.Time]() {
+			return fmt.Errorf("mapped field %s is not a time, it has no format", name)
+		}
+		if field.Lookup == nil {
+			continue
+		}
+		related, ok := resources.Resources[field.Lookup.Resource]
+		if !ok {
+			return fmt.Errorf("lookup of mapped field %s of unrecognized resource: %s", name, field.Lookup.Resource)
+		}
+		if _, ok := domain.JSONField(related.Type, field.Lookup.Field); !ok {
+			return fmt.Errorf("lookup field %s is not a field of %s", field.Lookup.Field, related.Name)
+		}
+	}
+	return nil
+}
+
+// importMapper maps the records of an import to objects of the resource, the IDs of the lookups are kept for the
+// other records of the import
+type importMapper struct {
+	server      *Server
+	user        *domain.User
+	permissions []string
+	resource    common.Resource
+	mapping     *ImportMapping
+	lookups     map[ImportLookup]map[string]uuid.UUID
+}
+
+// newImportMapper creates the mapper of the job, the records are only coerced to the types of the fields without
+// mapping
+func (server *Server) newImportMapper(job *jobs.Job, user *domain.User, resource common.Resource, mapping *ImportMapping) *importMapper {
+	if mapping == nil {
+		mapping = &ImportMapping{}
+	}
+	return &importMapper{
+		server:      server,
+		user:        user,
+		permissions: job.Permissions,
+		resource:    resource,
+		mapping:     mapping,
+		lookups:     map[ImportLookup]map[string]uuid.UUID{},
+	}
+}
+
+// apply maps the JSON record to the JSON object of the resource
+func (mapper *importMapper) apply(ctx context.Context, data []byte) ([]byte, error) {
+	record := map[string]any{}
+	// The numbers are kept as they are, e.g. the large integers and the decimals
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&record)
+	if err != nil {
+		return nil, err
+	}
+	object := maps.Clone(record)
+	for name, field := range mapper.mapping.Fields {
+		if field.From != "" && field.From != name {
+			delete(object, field.From)
+		}
+	}
+	for name, field := range mapper.mapping.Fields {
+		value := field.Value
+		if value == nil {
+			from := field.From
+			if from == "" {
+				from = name
+			}
+			value = record[from]
+		}
+		if field.Lookup != nil && value != nil && value != "" {
+			value, err = mapper.lookup(ctx, *field.Lookup, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		object[name] = value
+	}
+	for name, value := range object {
+		target, ok := domain.JSONField(mapper.resource.Type, name)
+		if !ok {
+			continue
+		}
+		object[name], err = coerce(value, target.Type, mapper.mapping.Fields[name].Format)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return json.Marshal(object)
+}
+
+// lookup returns the ID of the object of the lookup with the value
+func (mapper *importMapper) lookup(ctx context.Context, lookup ImportLookup, value any) (uuid.UUID, error) {
+	key := fmt.Sprint(value)
+	if id, ok := mapper.lookups[lookup][key]; ok {
+		return id, nil
+	}
+	resource := mapper.server.Resources.Resources[lookup.Resource]
+	requestContext := mapper.server.newRequestContextWithDetails(ctx, common.MinPageSize, 1, 0, mapper.user, resource, mapper.permissions)
+	related, ok := domain.JSONField(resource.Type, lookup.Field)
+	if !ok {
+		return uuid.Nil, fmt.Errorf("lookup field %s is not a field of %s", lookup.Field, resource.Name)
+	}
+	value, err := coerce(value, related.Type, "")
+	if err != nil {
+		return uuid.Nil, err
+	}
+	id, err := requestContext.Lookup(ctx, lookup.Field, value)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if mapper.lookups[lookup] == nil {
+		mapper.lookups[lookup] = map[string]uuid.UUID{}
+	}
+	mapper.lookups[lookup][key] = id
+	return id, nil
+}
+
+// coerce converts the text values of the records, e.g. the cells of CSV files, to the type of the field. Empty
+// texts of fields that are not texts are null, the times are parsed with the format and given in RFC 3339.
+func coerce(value any, fieldType reflect.Type, format string) (any, error) {
+	text, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	fieldType = indirect(fieldType)
+	if text == "" && fieldType.Kind() != reflect.String {
+		return nil, nil
+	}
+	if fieldType == reflect.TypeFor[time.Time]() {
+		if format == "" {
+			return text, nil
+		}
+		parsed, err := time.Parse(format, text)
+		if err != nil {
+			return nil, err
+		}
+		return parsed.UTC().Format(time.RFC3339Nano), nil
+	}
+	switch fieldType.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(text)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(text, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(text, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(text, 64)
+	}
+	return text, nil
+}
+
+// indirect returns the type of the pointers and the type itself otherwise
+func indirect(fieldType reflect.Type) reflect.Type {
+	if fieldType.Kind() == reflect.Pointer {
+		return fieldType.Elem()
+	}
+	return fieldType
+}
+
+// readCSV calls fn with the line and the JSON object of every row of the CSV content, the first row names the
+// fields and every value is a text
+func readCSV(content io.Reader, fn func(line int, data []byte) error) error {
+	reader := csv.NewReader(content)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid CSV header: %w", err)
+	}
+	header = append([]string(nil), header...)
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+		object := make(map[string]string, len(header))
+		for i, name := range header {
+			object[name] = row[i]
+		}
+		data, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		err = fn(line, data)
+		if err != nil {
+			return err
+		}
+	}
+}
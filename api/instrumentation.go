@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/respitectx"
+)
+
+// Outcomes of the instrumented requests
+const (
+	OUTCOME_SUCCESS      = "success"
+	OUTCOME_CLIENT_ERROR = "client_error"
+	OUTCOME_SERVER_ERROR = "server_error"
+)
+
+// RequestInfo is the request passed to the request hooks, the status, the outcome and the duration are set for
+// the hooks after the request
+type RequestInfo struct {
+	Resource  string
+	Action    string
+	User      *domain.User
+	Method    string
+	Path      string
+	StartedAt time.Time
+	Duration  time.Duration
+	Status    int
+	Outcome   string
+}
+
+// RequestHook receives the instrumented requests, e.g. for custom metrics, billing meters or anomaly detection.
+// The hooks run in the goroutine of the request, so slow work is handed off to another goroutine.
+type RequestHook func(ctx context.Context, request RequestInfo)
+
+// WithBeforeRequest runs the hook before the operations of the resource routes, after the authentication of the
+// caller. The hooks run in the order they are given.
+func WithBeforeRequest(hook RequestHook) Option {
+	return func(server *Server) {
+		server.beforeRequest = append(server.beforeRequest, hook)
+	}
+}
+
+// WithAfterRequest runs the hook after the operations of the resource routes, with their status, outcome and
+// duration. The hooks run in the order they are given.
+func WithAfterRequest(hook RequestHook) Option {
+	return func(server *Server) {
+		server.afterRequest = append(server.afterRequest, hook)
+	}
+}
+
+// Instrumented is a Wrapper that passes the requests of the action of the resource to the hooks of
+// WithBeforeRequest and WithAfterRequest, e.g. for the custom routes of an application. The resource routes are
+// instrumented with their operation as the action.
+func (server *Server) Instrumented(resource, action string, next http.HandlerFunc) http.HandlerFunc {
+	if len(server.beforeRequest) == 0 && len(server.afterRequest) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, _ := respitectx.CurrentUser(ctx)
+		request := RequestInfo{
+			Resource:  resource,
+			Action:    action,
+			User:      user,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			StartedAt: time.Now(),
+		}
+		for _, hook := range server.beforeRequest {
+			hook(ctx, request)
+		}
+		writer := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next(writer, r)
+		request.Duration = time.Since(request.StartedAt)
+		request.Status = writer.status
+		request.Outcome = outcome(writer.status)
+		for _, hook := range server.afterRequest {
+			hook(ctx, request)
+		}
+	}
+}
+
+// outcome classifies the status of a response
+func outcome(status int) string {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return OUTCOME_SERVER_ERROR
+	case status >= http.StatusBadRequest:
+		return OUTCOME_CLIENT_ERROR
+	}
+	return OUTCOME_SUCCESS
+}
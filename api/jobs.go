@@ -0,0 +1,469 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/jobs"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	EXPORT = "export"
+	IMPORT = "import"
+
+	// ndjsonContentType is the format of exports and imports, one JSON object per line
+	ndjsonContentType = "application/x-ndjson"
+	// maxImportLine limits the size of a single imported object
+	maxImportLine = 16 << 20
+	// importProgressStep is the number of imported lines between progress updates
+	importProgressStep = 100
+)
+
+// initJobRoutes registers the job and operation status routes, and the export and import routes of all resources
+// and the personal data export route if the storage is configured
+func (server *Server) initJobRoutes() {
+	apiJobIDPath := fmt.Sprintf("/%s/jobs/{id}", server.ServerConfig.APIPath)
+	server.Router.HandleFunc(apiJobIDPath, server.Authenticated(ContentTypeJSON(server.GetJob()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(fmt.Sprintf("/%s/operations/{id}", server.ServerConfig.APIPath), server.Authenticated(ContentTypeJSON(server.GetOperation()))).Methods(http.MethodGet)
+	if server.Storage == nil {
+		return
+	}
+	server.Router.HandleFunc(apiJobIDPath+"/artifact", server.Authenticated(server.GetJobArtifact())).Methods(http.MethodGet)
+	server.Router.HandleFunc(fmt.Sprintf("/%s/me/data/exports", server.ServerConfig.APIPath), server.Authenticated(ContentTypeJSON(server.CreateDataExport()))).Methods(http.MethodPost)
+	for _, resource := range server.Resources.Resources {
+		apiResPath := fmt.Sprintf("/%s/%s", server.ServerConfig.APIPath, resource.Name)
+		server.Router.HandleFunc(apiResPath+"/exports", server.Protected(READ, resource, server.sensitive(resource, OPERATION_EXPORT, server.resourceRateLimit(resource, OPERATION_EXPORT, ContentTypeJSON(server.CreateExport()))))).Methods(http.MethodPost)
+		if resource.SQLView != nil {
+			continue
+		}
+		server.Router.HandleFunc(apiResPath+"/imports", server.Protected(WRITE, resource, server.sensitive(resource, OPERATION_IMPORT, server.resourceRateLimit(resource, OPERATION_IMPORT, ContentTypeJSON(server.CreateImport()))))).Methods(http.MethodPost)
+	}
+}
+
+// CreateExport creates a job exporting all objects visible to the caller
+func (server *Server) CreateExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository := common.GetRequestContext(ctx)
+		if repository == nil {
+			logger.Error("Error reading repository from context")
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+			return
+		}
+		job, err := server.newJob(r, EXPORT, repository.Resource.Name)
+		if err != nil {
+			logger.Error("Error creating export job", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		err = server.Jobs.Enqueue(ctx, job)
+		if err != nil {
+			logger.Error("Error creating export job", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		server.writeJobCreated(w, r, job)
+	}
+}
+
+// CreateImport stores the uploaded objects, one JSON object per line, and creates a job importing them
+func (server *Server) CreateImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository := common.GetRequestContext(ctx)
+		if repository == nil {
+			logger.Error("Error reading repository from context")
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+			return
+		}
+		if r.ContentLength > server.StorageConfig.MaxUploadSize {
+			ERROR(w, http.StatusRequestEntityTooLarge, fmt.Errorf("import exceeds the maximum size of %d bytes", server.StorageConfig.MaxUploadSize))
+			return
+		}
+		parameters, err := server.importParameters(r, repository.Resource)
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		if parameters.Mapping != nil {
+			for name, field := range parameters.Mapping.Fields {
+				if field.Lookup != nil && !getPermissions(r).Can(field.Lookup.Resource, READ) {
+					ERROR(w, http.StatusUnauthorized, WithCode(CODE_PERMISSION, fmt.Errorf("unauthorized, no permission for %s.%s of the lookup of %s", field.Lookup.Resource, READ, name)))
+					return
+				}
+			}
+		}
+		job, err := server.newJob(r, IMPORT, repository.Resource.Name)
+		if err != nil {
+			logger.Error("Error creating import job", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		encoded, err := json.Marshal(parameters)
+		if err != nil {
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		job.Parameters = string(encoded)
+		contentType := ndjsonContentType
+		if parameters.Format == IMPORT_CSV {
+			contentType = csvContentType
+		}
+		body := http.MaxBytesReader(w, r.Body, server.StorageConfig.MaxUploadSize)
+		err = server.Storage.Put(ctx, importKey(job), body, r.ContentLength, contentType)
+		if err != nil {
+			logger.Error("Error storing import", "error", err)
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				ERROR(w, http.StatusRequestEntityTooLarge, err)
+				return
+			}
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		err = server.Jobs.Enqueue(ctx, job)
+		if err != nil {
+			logger.Error("Error creating import job", "error", err)
+			server.Storage.Delete(ctx, importKey(job))
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		server.writeJobCreated(w, r, job)
+	}
+}
+
+// GetJob reports the status and progress of a job of the caller
+func (server *Server) GetJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := server.loadJob(w, r)
+		if !ok {
+			return
+		}
+		JSON(w, http.StatusOK, job)
+	}
+}
+
+// GetJobArtifact downloads the result of a completed export
+func (server *Server) GetJobArtifact() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := server.loadJob(w, r)
+		if !ok {
+			return
+		}
+		server.serveArtifact(w, r, job)
+	}
+}
+
+// serveArtifact serves the artifact of the completed job
+func (server *Server) serveArtifact(w http.ResponseWriter, r *http.Request, job *jobs.Job) {
+	if job.Status != jobs.COMPLETED || job.Artifact == "" {
+		ERROR(w, http.StatusNotFound, fmt.Errorf("job %s has no artifact", job.ID))
+		return
+	}
+	switch job.Kind {
+	case DATA_EXPORT:
+		server.serveObject(w, r, job.Artifact, fmt.Sprintf("data-%s.zip", job.ID), zipContentType)
+		return
+	case BACKUP:
+		server.serveObject(w, r, job.Artifact, fmt.Sprintf("backup-%s.zip", job.ID), zipContentType)
+		return
+	case SCHEDULED_EXPORT:
+		if strings.HasSuffix(job.Artifact, "."+EXPORT_CSV) {
+			server.serveObject(w, r, job.Artifact, fmt.Sprintf("%s-%s.csv", job.Resource, job.ID), csvContentType)
+			return
+		}
+	}
+	server.serveObject(w, r, job.Artifact, fmt.Sprintf("%s-%s.ndjson", job.Resource, job.ID), ndjsonContentType)
+}
+
+// exportJob writes all objects visible to the job owner to an artifact, one JSON object per line
+func (server *Server) exportJob(ctx context.Context, job *jobs.Job, progress jobs.Progress) error {
+	user, resource, err := server.jobOwner(ctx, job)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp("", "export-*.ndjson")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	_, err = server.exportObjects(ctx, job, user, resource, nil, progress, func(object domain.Object) error {
+		return encoder.Encode(object)
+	})
+	if err != nil {
+		return err
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+	job.Artifact = fmt.Sprintf("jobs/%s/export.ndjson", job.ID)
+	err = server.storeFile(ctx, job.Artifact, file, ndjsonContentType)
+	if err != nil {
+		return err
+	}
+	return server.Jobs.Save(ctx, job)
+}
+
+// exportObjects passes all objects visible to the job owner to write, masked for the permissions of the job, and
+// reports the progress. The filter limits the request contexts of the pages, if any. It returns the count of the
+// exported objects.
+func (server *Server) exportObjects(ctx context.Context, job *jobs.Job, user *domain.User, resource common.Resource, filter func(*common.RequestContext), progress jobs.Progress, write func(domain.Object) error) (int64, error) {
+	var processed, total int64
+	for page := 1; ; page++ {
+		// Pages are ordered by created_at and ID, so that they do not overlap
+		requestContext := server.newRequestContextWithDetails(ctx, common.MaxPageSize, page, (page-1)*common.MaxPageSize, user, resource, job.Permissions)
+		if filter != nil {
+			filter(requestContext)
+		}
+		list, err := requestContext.GetAll(ctx)
+		if err != nil {
+			return processed, err
+		}
+		if page == 1 {
+			total = list.Count
+		}
+		for _, object := range list.Data {
+			err = write(resource.Mask(object, job.Permissions))
+			if err != nil {
+				return processed, err
+			}
+		}
+		processed += int64(len(list.Data))
+		err = progress(ctx, processed, max(total, processed), 0)
+		if err != nil {
+			return processed, err
+		}
+		if len(list.Data) < common.MaxPageSize {
+			return processed, nil
+		}
+	}
+}
+
+// storeFile puts the written temporary file to the storage under the key
+func (server *Server) storeFile(ctx context.Context, key string, file *os.File, contentType string) error {
+	// The storages may read the file at its offsets without moving the offset of the file, e.g. S3, so the size
+	// is taken from the file
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return server.Storage.Put(ctx, key, file, info.Size(), contentType)
+}
+
+// importJob creates an object for every line of the uploaded import, failed lines are reported in the job errors.
+// The records of CSV imports and of the imports with mapping are mapped to the objects first.
+func (server *Server) importJob(ctx context.Context, job *jobs.Job, progress jobs.Progress) error {
+	user, resource, err := server.jobOwner(ctx, job)
+	if err != nil {
+		return err
+	}
+	parameters := importParameters{}
+	err = json.Unmarshal([]byte(job.Parameters), &parameters)
+	if err != nil {
+		return fmt.Errorf("invalid import parameters: %w", err)
+	}
+	var mapper *importMapper
+	if parameters.Mapping != nil || parameters.Format == IMPORT_CSV {
+		mapper = server.newImportMapper(job, user, resource, parameters.Mapping)
+	}
+	total, err := server.countImportLines(ctx, job, parameters.Format)
+	if err != nil {
+		return err
+	}
+
+	// The retried imports resume after the objects of their last progress, the errors are saved with the progress.
+	// Up to importProgressStep objects after the last progress are created again after a crash of the worker.
+	processed, failed, resumed := job.Processed, job.Failed, job.Processed
+	err = server.readImport(ctx, job, parameters.Format, func(line int, data []byte) error {
+		if resumed > 0 {
+			resumed--
+			return nil
+		}
+		var err error
+		if mapper != nil {
+			data, err = mapper.apply(ctx, data)
+		}
+		if err == nil {
+			requestContext := server.newRequestContextWithDetails(ctx, common.MinPageSize, 1, 0, user, resource, job.Permissions)
+			_, err = requestContext.Create(ctx, data)
+		}
+		if err != nil {
+			failed++
+			job.AddError(fmt.Errorf("line %d: %w", line, err))
+		}
+		processed++
+		if processed%importProgressStep == 0 {
+			err = server.Jobs.Save(ctx, job)
+			if err != nil {
+				return err
+			}
+			return progress(ctx, processed, total, failed)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = progress(ctx, processed, total, failed)
+	if err != nil {
+		return err
+	}
+	err = server.Jobs.Save(ctx, job)
+	if err != nil {
+		return err
+	}
+	return server.Storage.Delete(ctx, importKey(job))
+}
+
+// countImportLines counts the objects of the import for the progress report
+func (server *Server) countImportLines(ctx context.Context, job *jobs.Job, format string) (int64, error) {
+	var count int64
+	err := server.readImport(ctx, job, format, func(int, []byte) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// readImport calls fn with the line and the JSON record of every object of the uploaded import, the rows of the
+// CSV imports are objects of texts
+func (server *Server) readImport(ctx context.Context, job *jobs.Job, format string, fn func(line int, data []byte) error) error {
+	content, err := server.Storage.Get(ctx, importKey(job))
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	if format == IMPORT_CSV {
+		return readCSV(content, fn)
+	}
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		err = fn(line, data)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// jobOwner loads the user that created the job and the resource of the job
+func (server *Server) jobOwner(ctx context.Context, job *jobs.Job) (*domain.User, common.Resource, error) {
+	resource, ok := server.Resources.Resources[job.Resource]
+	if !ok {
+		return nil, common.Resource{}, fmt.Errorf("unrecognized resource name: %s", job.Resource)
+	}
+	if job.UserID == nil {
+		return nil, resource, nil
+	}
+	user, err := server.DBLoadUser(ctx, job.UserID.String())
+	if err != nil {
+		return nil, resource, err
+	}
+	// The jobs do not run on behalf of the deactivated users
+	if user.Deactivated {
+		return nil, resource, ErrUserDeactivated
+	}
+	return user, resource, nil
+}
+
+// newJob creates a job of the caller, the permissions are kept to run the job on behalf of the caller
+func (server *Server) newJob(r *http.Request, kind, resourceName string) (*jobs.Job, error) {
+	var userID *uuid.UUID
+	user, ok := respitectx.CurrentUser(r.Context())
+	if ok && user != nil {
+		userID = &user.ID
+	}
+	return jobs.NewJob(kind, resourceName, userID, getPermissions(r), "")
+}
+
+// loadJob loads the job from the request path, jobs of other users are not found
+func (server *Server) loadJob(w http.ResponseWriter, r *http.Request) (*jobs.Job, bool) {
+	ctx := r.Context()
+	logger := common.GetLogger(ctx)
+	uid, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		logger.Error("Error parsing UUID from request", "error", err)
+		ERROR(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	job, err := jobs.Load(ctx, server.DB, uid)
+	if err == nil && (job.UserID == nil || job.UserID.String() != callerID(r)) {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		logger.Error("Error getting job", "error", err)
+		ERROR(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	return job, true
+}
+
+// writeJobCreated responds with the created job and its status location
+func (server *Server) writeJobCreated(w http.ResponseWriter, r *http.Request, job *jobs.Job) {
+	w.Header().Set("Location", fmt.Sprintf("%s/%s/jobs/%s", r.Host, server.ServerConfig.APIPath, job.ID))
+	JSON(w, http.StatusAccepted, job)
+}
+
+// importParameters reads the format of the import from its content type and its mapping from the mapping
+// parameter, the mapping is checked against the resource
+func (server *Server) importParameters(r *http.Request, resource common.Resource) (importParameters, error) {
+	parameters := importParameters{Format: IMPORT_NDJSON}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == csvContentType {
+		parameters.Format = IMPORT_CSV
+	}
+	text := r.URL.Query().Get("mapping")
+	if text == "" {
+		return parameters, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.DisallowUnknownFields()
+	mapping := &ImportMapping{}
+	err := decoder.Decode(mapping)
+	if err != nil {
+		return parameters, fmt.Errorf("invalid mapping: %w", err)
+	}
+	err = mapping.Check(server.Resources, resource)
+	if err != nil {
+		return parameters, fmt.Errorf("invalid mapping: %w", err)
+	}
+	parameters.Mapping = mapping
+	return parameters, nil
+}
+
+// importKey is the storage key of the uploaded import
+func importKey(job *jobs.Job) string {
+	return fmt.Sprintf("jobs/%s/import.ndjson", job.ID)
+}
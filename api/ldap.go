@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/dzahariev/respite/auth"
+	"github.com/dzahariev/respite/cache"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/ldap"
+)
+
+// WithLDAP resolves the groups of the users from an LDAP directory at their login and adds the roles of the
+// groups to the roles of their tokens, for the organizations with the group memberships in Active Directory
+func WithLDAP(ldapConfig cfg.LDAP) Option {
+	return func(server *Server) {
+		server.LDAPConfig = ldapConfig
+	}
+}
+
+// initLDAP creates the LDAP client, the development mode does not authenticate and has no groups to resolve
+func (server *Server) initLDAP() {
+	if server.LDAPConfig.URL == "" || server.devMode {
+		return
+	}
+	server.LDAP = ldap.New(server.LDAPConfig)
+	// The groups are shared by the replicas in the cache, they are kept in process memory without it
+	server.ldapGroups = server.Cache
+	if server.ldapGroups == nil {
+		server.ldapGroups = cache.NewMemoryCache("")
+	}
+	slog.Info("LDAP groups initialized", "url", server.LDAPConfig.URL, "cacheTTL", server.LDAPConfig.CacheTTL)
+}
+
+// ldapRoles returns the roles of the LDAP groups of the user, the groups are mapped with LDAP_GROUP_ROLES and the
+// groups without a mapping are roles with their names. The users that are not in the directory have no groups.
+func (server *Server) ldapRoles(ctx context.Context, user *domain.User) ([]string, error) {
+	if server.LDAP == nil {
+		return nil, nil
+	}
+	groups, err := server.ldapUserGroups(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]string, 0, len(groups))
+	for _, group := range groups {
+		if role, ok := server.LDAPConfig.GroupRoles[group]; ok {
+			group = role
+		}
+		roles = append(roles, group)
+	}
+	return roles, nil
+}
+
+// ldapUserGroups returns the cached groups of the user, or reads them from the directory and caches them for
+// LDAP_CACHE_TTL. The directory errors fail the authentication as unavailable rather than unauthorized.
+func (server *Server) ldapUserGroups(ctx context.Context, user *domain.User) ([]string, error) {
+	logger := common.GetLogger(ctx)
+	key := ldapGroupsKey(user)
+	if value, ok, err := server.ldapGroups.Get(ctx, key); err != nil {
+		logger.Error("Error reading cached LDAP groups", "userID", user.ID, "error", err)
+	} else if ok {
+		var groups []string
+		if json.Unmarshal(value, &groups) == nil {
+			return groups, nil
+		}
+	}
+	groups, err := server.LDAP.Groups(ctx, user.PreferedUserName, user.Email)
+	if errors.Is(err, ldap.ErrUserNotFound) {
+		logger.Debug("User not found in LDAP", "userID", user.ID)
+		groups, err = []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", auth.ErrUnavailable, err)
+	}
+	value, err := json.Marshal(groups)
+	if err != nil {
+		return nil, err
+	}
+	err = server.ldapGroups.Set(ctx, key, value, server.LDAPConfig.CacheTTL)
+	if err != nil {
+		logger.Error("Error caching LDAP groups", "userID", user.ID, "error", err)
+	}
+	return groups, nil
+}
+
+// ldapGroupsKey is the cache key of the LDAP groups of the user
+func ldapGroupsKey(user *domain.User) string {
+	return "ldap:groups:" + user.ID.String()
+}
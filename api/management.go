@@ -0,0 +1,427 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/tenants"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Kinds of the webhooks of the management API
+const (
+	WEBHOOK_KIND_RESOURCE = "resource"
+	WEBHOOK_KIND_INBOUND  = "inbound"
+)
+
+// ManagedResource is a resource registered by the application, the resources are declared in code and are read-only
+type ManagedResource struct {
+	Name          string   `json:"name"`
+	Global        bool     `json:"global"`
+	Owned         bool     `json:"owned"`
+	Shared        bool     `json:"shared"`
+	ConfirmDelete bool     `json:"confirm_delete"`
+	Deprecated    bool     `json:"deprecated"`
+	Permissions   []string `json:"permissions"`
+}
+
+// ManagedWebhook is a webhook declared by the application, the webhook of a resource validating its objects or an
+// inbound webhook. Their ID is the kind and the name, e.g. resource.order or inbound.github.
+type ManagedWebhook struct {
+	ID            string `json:"id"`
+	Kind          string `json:"kind"`
+	Name          string `json:"name"`
+	URL           string `json:"url,omitempty"`
+	Path          string `json:"path,omitempty"`
+	Timeout       string `json:"timeout,omitempty"`
+	FailurePolicy string `json:"failure_policy,omitempty"`
+	Job           string `json:"job,omitempty"`
+}
+
+// ManagedRole is a role with the permissions granted to it in the database, which are managed, with the ones
+// mapped by the application, which are read-only, and the resulting permissions
+type ManagedRole struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	Configured  []string `json:"configured"`
+	Effective   []string `json:"effective"`
+}
+
+// initManagementRoutes registers the routes of the management API, e.g. for a Terraform provider. The objects have
+// stable IDs, the PUT routes create or replace them and can be repeated, and the reads return the same fields as the
+// PUTs with an ETag, so that the drift is detected by comparing them.
+func (server *Server) initManagementRoutes() {
+	apiManagePath := fmt.Sprintf("/%s/admin/manage", server.ServerConfig.APIPath)
+	server.Router.HandleFunc(apiManagePath+"/resources", server.Permitted(ADMIN, READ, ContentTypeJSON(server.ListManagedResources()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(apiManagePath+"/resources/{name}", server.Permitted(ADMIN, READ, ContentTypeJSON(server.GetManagedResource()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(apiManagePath+"/webhooks", server.Permitted(ADMIN, READ, ContentTypeJSON(server.ListManagedWebhooks()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(apiManagePath+"/webhooks/{id}", server.Permitted(ADMIN, READ, ContentTypeJSON(server.GetManagedWebhook()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(apiManagePath+"/roles", server.Permitted(ADMIN, READ, ContentTypeJSON(server.ListManagedRoles()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(apiManagePath+"/roles/{role}", server.Permitted(ADMIN, READ, ContentTypeJSON(server.GetManagedRole()))).Methods(http.MethodGet)
+	if server.Permissions != nil {
+		server.Router.HandleFunc(apiManagePath+"/roles/{role}", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.PutManagedRole()))).Methods(http.MethodPut)
+		server.Router.HandleFunc(apiManagePath+"/roles/{role}", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.DeleteManagedRole()))).Methods(http.MethodDelete)
+	}
+	if server.Tenants != nil {
+		server.Router.HandleFunc(apiManagePath+"/tenants", server.Permitted(ADMIN, READ, ContentTypeJSON(server.ListTenants()))).Methods(http.MethodGet)
+		server.Router.HandleFunc(apiManagePath+"/tenants/{id}", server.Permitted(ADMIN, READ, ContentTypeJSON(server.GetManagedTenant()))).Methods(http.MethodGet)
+		server.Router.HandleFunc(apiManagePath+"/tenants/{id}", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.PutManagedTenant()))).Methods(http.MethodPut)
+		server.Router.HandleFunc(apiManagePath+"/tenants/{id}", server.Permitted(ADMIN, WRITE, ContentTypeJSON(server.DeleteTenant()))).Methods(http.MethodDelete)
+	}
+}
+
+// versionTag returns the entity tag of the managed object, it changes with any of its fields
+func versionTag(value any) string {
+	data, _ := json.Marshal(value)
+	hash := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// managedResource returns the resource of the management API
+func managedResource(resource common.Resource) ManagedResource {
+	managed := ManagedResource{
+		Name:          resource.Name,
+		Global:        resource.IsGlobal,
+		Owned:         resource.IsOwned(),
+		Shared:        resource.Shared,
+		ConfirmDelete: resource.ConfirmDelete,
+		Deprecated:    resource.Deprecation != nil,
+		Permissions:   make([]string, 0, len(roleActions)),
+	}
+	for _, action := range roleActions {
+		managed.Permissions = append(managed.Permissions, resource.Name+"."+action)
+	}
+	return managed
+}
+
+// managedWebhooks returns the webhooks of the resources and the inbound webhooks ordered by ID
+func (server *Server) managedWebhooks() []ManagedWebhook {
+	webhooks := []ManagedWebhook{}
+	for _, resource := range server.Resources.Resources {
+		if resource.Webhook == nil {
+			continue
+		}
+		webhook := ManagedWebhook{
+			ID:            WEBHOOK_KIND_RESOURCE + "." + resource.Name,
+			Kind:          WEBHOOK_KIND_RESOURCE,
+			Name:          resource.Name,
+			URL:           resource.Webhook.URL,
+			FailurePolicy: resource.Webhook.FailurePolicy,
+		}
+		if resource.Webhook.Timeout > 0 {
+			webhook.Timeout = resource.Webhook.Timeout.String()
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	for _, inbound := range server.Webhooks {
+		webhooks = append(webhooks, ManagedWebhook{
+			ID:   WEBHOOK_KIND_INBOUND + "." + inbound.Name,
+			Kind: WEBHOOK_KIND_INBOUND,
+			Name: inbound.Name,
+			Path: fmt.Sprintf("/%s/webhooks/%s", server.ServerConfig.APIPath, inbound.Name),
+			Job:  inbound.Job,
+		})
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks
+}
+
+// managedRoles returns the roles mapped by the application or granted permissions in the database, ordered by name
+func (server *Server) managedRoles(r *http.Request) ([]ManagedRole, error) {
+	granted := map[string][]string{}
+	if server.Permissions != nil {
+		grants, err := server.Permissions.Grants(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		for _, grant := range grants {
+			granted[grant.Role] = append(granted[grant.Role], grant.Permission)
+		}
+	}
+	names := slices.Collect(maps.Keys(server.RoleToPermissions))
+	for role := range granted {
+		if _, ok := server.RoleToPermissions[role]; !ok {
+			names = append(names, role)
+		}
+	}
+	sort.Strings(names)
+	roles := make([]ManagedRole, 0, len(names))
+	for _, name := range names {
+		roles = append(roles, ManagedRole{
+			Name:        name,
+			Permissions: sortedPermissions(granted[name]),
+			Configured:  sortedPermissions(server.RoleToPermissions[name]),
+			Effective:   sortedPermissions(server.rolePermissions(name)),
+		})
+	}
+	return roles, nil
+}
+
+// managedRole returns the role, gorm.ErrRecordNotFound if it is neither mapped nor granted permissions
+func (server *Server) managedRole(r *http.Request, name string) (*ManagedRole, error) {
+	roles, err := server.managedRoles(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			return &role, nil
+		}
+	}
+	return nil, fmt.Errorf("role %s: %w", name, gorm.ErrRecordNotFound)
+}
+
+// sortedPermissions returns a sorted copy of the permissions without duplicates, never nil
+func sortedPermissions(permissions []string) []string {
+	sorted := slices.Clone(permissions)
+	slices.Sort(sorted)
+	return append([]string{}, slices.Compact(sorted)...)
+}
+
+// ListManagedResources returns the registered resources ordered by name
+func (server *Server) ListManagedResources() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		names := server.Resources.Names()
+		sort.Strings(names)
+		resources := make([]ManagedResource, 0, len(names))
+		for _, name := range names {
+			resources = append(resources, managedResource(server.Resources.Resources[name]))
+		}
+		JSON(w, http.StatusOK, resources)
+	}
+}
+
+// GetManagedResource returns the registered resource
+func (server *Server) GetManagedResource() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		resource, ok := server.Resources.Resources[name]
+		if !ok {
+			ERROR(w, http.StatusNotFound, fmt.Errorf("resource %s is not registered", name))
+			return
+		}
+		managed := managedResource(resource)
+		w.Header().Set("ETag", versionTag(managed))
+		JSON(w, http.StatusOK, managed)
+	}
+}
+
+// ListManagedWebhooks returns the webhooks declared by the application
+func (server *Server) ListManagedWebhooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		JSON(w, http.StatusOK, server.managedWebhooks())
+	}
+}
+
+// GetManagedWebhook returns the webhook of the ID, e.g. resource.order
+func (server *Server) GetManagedWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		for _, webhook := range server.managedWebhooks() {
+			if webhook.ID == id {
+				w.Header().Set("ETag", versionTag(webhook))
+				JSON(w, http.StatusOK, webhook)
+				return
+			}
+		}
+		ERROR(w, http.StatusNotFound, fmt.Errorf("webhook %s is not declared", id))
+	}
+}
+
+// ListManagedRoles returns the roles with their permissions
+func (server *Server) ListManagedRoles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := common.GetLogger(r.Context())
+		roles, err := server.managedRoles(r)
+		if err != nil {
+			logger.Error("Error loading roles", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, roles)
+	}
+}
+
+// GetManagedRole returns the role with its permissions
+func (server *Server) GetManagedRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, err := server.managedRole(r, mux.Vars(r)["role"])
+		if err != nil {
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		w.Header().Set("ETag", versionTag(role))
+		JSON(w, http.StatusOK, role)
+	}
+}
+
+// PutManagedRole replaces the permissions granted to the role in the database with the permissions of the body,
+// e.g. {"permissions": ["meal.read", "meal.write"]}. The permissions mapped by the application are kept.
+func (server *Server) PutManagedRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		name := strings.TrimSpace(mux.Vars(r)["role"])
+		var body struct {
+			Permissions []string `json:"permissions"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, fmt.Errorf("invalid role: %w", err))
+			return
+		}
+		if name == "" {
+			ERROR(w, http.StatusUnprocessableEntity, errors.New("role is required"))
+			return
+		}
+		var problems []error
+		for i, permission := range body.Permissions {
+			body.Permissions[i] = strings.ToLower(strings.TrimSpace(permission))
+			problems = append(problems, server.checkRolePermission(body.Permissions[i]))
+		}
+		err = errors.Join(problems...)
+		if err != nil {
+			ERROR(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		current, err := server.managedRole(r, name)
+		if err == nil {
+			err = matchTag(r, versionTag(current))
+		} else if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = nil
+		}
+		if err != nil {
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		changed, err := server.Permissions.Replace(ctx, name, sortedPermissions(body.Permissions))
+		if err != nil {
+			logger.Error("Error replacing role permissions", "role", name, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		// A role without permissions is not listed, it is the empty role that was put
+		role, err := server.managedRole(r, name)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			role, err = &ManagedRole{Name: name, Permissions: []string{}, Configured: []string{}, Effective: []string{}}, nil
+		}
+		if err != nil {
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		if changed {
+			logger.Info("Role permissions replaced", "role", name, "permissions", role.Permissions)
+		}
+		w.Header().Set("ETag", versionTag(role))
+		JSON(w, http.StatusOK, role)
+	}
+}
+
+// DeleteManagedRole revokes all the permissions granted to the role in the database, the permissions mapped by
+// the application cannot be revoked
+func (server *Server) DeleteManagedRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		name := mux.Vars(r)["role"]
+		revoked, err := server.Permissions.Replace(ctx, name, nil)
+		if err != nil {
+			logger.Error("Error revoking role permissions", "role", name, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !revoked {
+			ERROR(w, http.StatusNotFound, fmt.Errorf("no permissions are granted to %s", name))
+			return
+		}
+		logger.Info("Role permissions revoked", "role", name)
+		JSON(w, http.StatusNoContent, "")
+	}
+}
+
+// GetManagedTenant returns the tenant with its ETag
+func (server *Server) GetManagedTenant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := server.Tenants.Find(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			ERROR(w, tenantStatus(err), err)
+			return
+		}
+		w.Header().Set("ETag", versionTag(tenant))
+		JSON(w, http.StatusOK, tenant)
+	}
+}
+
+// PutManagedTenant creates the tenant of the path with the body and provisions it, or replaces the name, the
+// domains, the overrides and the suspension of the existing tenant
+func (server *Server) PutManagedTenant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		update, ok := server.decodeTenant(w, r)
+		if !ok {
+			return
+		}
+		update.ID = mux.Vars(r)["id"]
+		if !server.checkDomains(w, update) {
+			return
+		}
+		tenant, err := server.Tenants.Find(ctx, update.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			server.createManagedTenant(w, r, update)
+			return
+		}
+		if err == nil {
+			err = matchTag(r, versionTag(tenant))
+		}
+		if err != nil {
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		replaceTenant(tenant, update)
+		tenant.Suspended = update.Suspended
+		err = server.Tenants.Save(ctx, tenant)
+		if err != nil {
+			logger.Error("Error saving tenant", "tenant", tenant.ID, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		logger.Info("Tenant replaced", "tenant", tenant.ID)
+		// The tenant is read again with the timestamps of the database, so that its ETag is the one of the reads
+		tenant, err = server.Tenants.Find(ctx, tenant.ID)
+		if err != nil {
+			ERROR(w, tenantStatus(err), err)
+			return
+		}
+		w.Header().Set("ETag", versionTag(tenant))
+		JSON(w, http.StatusOK, tenant)
+	}
+}
+
+// createManagedTenant creates the tenant of a PUT and provisions it
+func (server *Server) createManagedTenant(w http.ResponseWriter, r *http.Request, tenant *tenants.Tenant) {
+	ctx := r.Context()
+	logger := common.GetLogger(ctx)
+	tenant.ProvisionedAt = nil
+	tenant.ProvisionError = ""
+	err := server.Tenants.Create(ctx, tenant)
+	if err != nil {
+		logger.Error("Error creating tenant", "tenant", tenant.ID, "error", err)
+		ERROR(w, tenantStatus(err), err)
+		return
+	}
+	logger.Info("Tenant created", "tenant", tenant.ID)
+	server.respondProvisioning(w, r, tenant.ID, http.StatusCreated)
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/manifest"
+)
+
+// ManifestStatus is the outcome of the reconciliation of the manifest, e.g. copied by an operator into the status
+// of its custom resource. The instance is reconciled when the observed generation is the declared one without error.
+type ManifestStatus struct {
+	ObservedGeneration int64      `json:"observed_generation"`
+	Digest             string     `json:"digest,omitempty"`
+	AppliedAt          *time.Time `json:"applied_at,omitempty"`
+	Resources          []string   `json:"resources"`
+	// Error is the error of the latest manifest, the previous one stays applied
+	Error      string `json:"error,omitempty"`
+	Finalizing bool   `json:"finalizing"`
+}
+
+// manifestState is the state of the reconciliation of the manifest
+type manifestState struct {
+	mutex  sync.Mutex
+	status ManifestStatus
+	// failed is the digest of the latest manifest that was not applied, it is not applied again until it changes
+	failed string
+	// roles are the roles mapped by the application, the roles of the manifest replace some of them
+	roles map[string][]string
+	// catalog are the resources that the manifest registers and unregisters
+	catalog []domain.Object
+}
+
+// WithManifest reconciles the resources, the role permissions and the webhooks with the manifest of MANIFEST_PATH,
+// e.g. for an operator managing the instance declaratively
+func WithManifest(manifestConfig cfg.Manifest) Option {
+	return func(server *Server) {
+		server.ManifestConfig = manifestConfig
+	}
+}
+
+// WithManifestCatalog adds the resources that the manifest registers when they are listed in it and unregisters
+// when they are not
+func WithManifestCatalog(objects ...domain.Object) Option {
+	return func(server *Server) {
+		server.manifest.catalog = append(server.manifest.catalog, objects...)
+	}
+}
+
+// initManifest applies the manifest if configured, an instance with a manifest that cannot be applied does not
+// start
+func (server *Server) initManifest() error {
+	if server.ManifestConfig.Path == "" {
+		return nil
+	}
+	server.manifest.roles = server.RoleToPermissions
+	err := server.reconcileManifest(context.Background())
+	if err != nil {
+		return err
+	}
+	slog.Info("Manifest initialized", "path", server.ManifestConfig.Path, "catalog", len(server.manifest.catalog))
+	return nil
+}
+
+// watchManifest applies the manifest when its file changes until the context is cancelled
+func (server *Server) watchManifest(ctx context.Context) {
+	ticker := time.NewTicker(server.ManifestConfig.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := server.reconcileManifest(ctx)
+			if err != nil {
+				slog.Error("Error applying manifest", "path", server.ManifestConfig.Path, "error", err)
+			}
+		}
+	}
+}
+
+// reconcileManifest applies the manifest if it changed since it was applied or failed, and records the outcome
+func (server *Server) reconcileManifest(ctx context.Context) error {
+	state := &server.manifest
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.status.Finalizing {
+		return nil
+	}
+	declared, digest, err := manifest.Load(server.ManifestConfig.Path)
+	if digest != "" && (digest == state.status.Digest || digest == state.failed) {
+		return nil
+	}
+	if err == nil {
+		err = server.applyManifest(ctx, declared)
+	}
+	if err != nil {
+		state.failed = digest
+		state.status.Error = err.Error()
+		return err
+	}
+	now := domain.Now()
+	names := server.Resources.Names()
+	sort.Strings(names)
+	state.failed = ""
+	state.status = ManifestStatus{ObservedGeneration: declared.Generation, Digest: digest, AppliedAt: &now, Resources: names}
+	slog.Info("Manifest applied", "generation", declared.Generation, "digest", digest, "resources", names)
+	return nil
+}
+
+// applyManifest registers the resources of the catalog that are listed and unregisters the others, replaces the
+// webhooks and the role permissions, and rebuilds the routes. The previous state is kept if any of them is invalid.
+func (server *Server) applyManifest(ctx context.Context, declared *manifest.Manifest) error {
+	server.registryMutex.Lock()
+	defer server.registryMutex.Unlock()
+	resources := server.Resources.Clone()
+	catalog := map[string]bool{}
+	for _, object := range server.manifest.catalog {
+		name := object.ResourceName()
+		catalog[name] = true
+		_, registered := resources.Resources[name]
+		listed := slices.Contains(declared.Resources, name)
+		var err error
+		switch {
+		case listed && !registered:
+			err = resources.Register(object)
+		case !listed && registered:
+			err = resources.Unregister(name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var problems []error
+	for _, name := range declared.Resources {
+		if !catalog[name] {
+			problems = append(problems, fmt.Errorf("resource %s is not in the catalog", name))
+		}
+	}
+	// The webhooks that are no longer in the manifest are the ones of the resources again
+	for name, resource := range resources.Resources {
+		resource.Webhook = nil
+		if hooked, ok := reflect.New(resource.Type).Interface().(domain.WebhookObject); ok {
+			webhook := hooked.Webhook()
+			resource.Webhook = &webhook
+		}
+		if declaredWebhook, ok := declared.Webhooks[name]; ok {
+			webhook := declaredWebhook.Domain()
+			resource.Webhook = &webhook
+		}
+		resources.Resources[name] = resource
+	}
+	for name := range declared.Webhooks {
+		if _, ok := resources.Resources[name]; !ok {
+			problems = append(problems, fmt.Errorf("webhook of resource %s that is not registered", name))
+		}
+	}
+	roles := maps.Clone(server.manifest.roles)
+	for role, permissions := range declared.Roles {
+		for _, permission := range permissions {
+			err := checkPermissionOf(resources, permission)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("role %s: %w", role, err))
+			}
+		}
+		roles[role] = permissions
+	}
+	err := errors.Join(problems...)
+	if err != nil {
+		return err
+	}
+	previousRoles := server.RoleToPermissions
+	server.RoleToPermissions = roles
+	err = server.replaceResources(ctx, resources)
+	if err != nil {
+		server.RoleToPermissions = previousRoles
+		return err
+	}
+	return nil
+}
+
+// finalizeManifest stops the reconciliation and takes the instance out of the rotation, /readyz fails from now on
+func (server *Server) finalizeManifest() ManifestStatus {
+	state := &server.manifest
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if !state.status.Finalizing {
+		state.status.Finalizing = true
+		slog.Info("Manifest finalizing, the instance is no longer ready", "generation", state.status.ObservedGeneration)
+	}
+	return state.status
+}
+
+// finalizing checks if the instance is finalized
+func (server *Server) finalizing() bool {
+	state := &server.manifest
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.status.Finalizing
+}
+
+// GetManifestStatus returns the outcome of the reconciliation of the manifest
+func (server *Server) GetManifestStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := &server.manifest
+		state.mutex.Lock()
+		status := state.status
+		state.mutex.Unlock()
+		JSON(w, http.StatusOK, status)
+	}
+}
+
+// FinalizeManifest stops the reconciliation before the instance is removed, e.g. by the finalizer of an operator,
+// so that the deletion of its manifest does not unregister the resources while the requests are drained
+func (server *Server) FinalizeManifest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		common.GetLogger(r.Context()).Info("Manifest finalization requested")
+		JSON(w, http.StatusOK, server.finalizeManifest())
+	}
+}
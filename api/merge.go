@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+)
+
+// MergeRequest merges the duplicate of SourceID into the object of the path, Fields replace the merge rules of
+// the resource for the request
+type MergeRequest struct {
+	SourceID uuid.UUID         `json:"source_id"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// Merge merges a duplicate into the object of the path and deletes the duplicate, the related objects of the
+// duplicate are moved to the object and require the write permission of their resources
+func (server *Server) Merge() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository := common.GetRequestContext(ctx)
+		if repository == nil {
+			logger.Error("Error reading repository from context")
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+			return
+		}
+		uid, err := uuid.FromString(mux.Vars(r)["id"])
+		if err != nil {
+			logger.Error("Error parsing UUID from request", "error", err)
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		// The duplicates of dangerous resources are deleted only when the merge is confirmed
+		if repository.Resource.ConfirmDelete && r.URL.Query().Get("confirm") != "true" {
+			ERROR(w, http.StatusBadRequest, WithCode(CODE_CONFIRMATION, fmt.Errorf("merging %s requires the confirm=true parameter", repository.Resource.Name)))
+			return
+		}
+		request := MergeRequest{}
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err == nil && request.SourceID == uuid.Nil {
+			err = errors.New("source_id is required")
+		}
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		permissions := getPermissions(r)
+		for _, relation := range repository.Resource.Merge.Relations {
+			if !permissions.Can(relation.Resource, WRITE) {
+				logger.Error("Unauthorized merge, no permission for related resource", "resource", relation.Resource)
+				ERROR(w, http.StatusUnauthorized, WithCode(CODE_PERMISSION, fmt.Errorf("unauthorized, no permission for %s.%s", relation.Resource, WRITE)))
+				return
+			}
+		}
+		object, err := repository.Merge(ctx, uid, request.SourceID, request.Fields)
+		if err != nil {
+			logger.Error("Error merging objects", "error", err)
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		logger.Debug("Objects merged successfully", "resource", repository.Resource.Name, "id", uid, "source", request.SourceID)
+		w.Header().Set("ETag", ETag(object))
+		JSON(w, http.StatusOK, server.present(ctx, repository.Resource, object))
+	}
+}
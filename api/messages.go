@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/dzahariev/respite/i18n"
+)
+
+// Translations are the localized messages of the error codes included in error responses. Applications add
+// translations of their own codes attached with WithCode, or replace the built-in messages:
+//
+//	api.Translations.Add("de", map[string]string{"MEALS-422-CALORIES": "Die Kalorien dürfen nicht negativ sein"})
+var Translations = i18n.NewCatalog("en")
+
+func init() {
+	Translations.Add("en", map[string]string{
+		CODE_BAD_REQUEST:     "The request is not valid",
+		CODE_CONFIRMATION:    "The deletion must be confirmed",
+		CODE_UNAUTHORIZED:    "Authentication is required",
+		CODE_PERMISSION:      "You do not have permission for this operation",
+		CODE_QUOTA:           "The quota of the resource is used up",
+		CODE_CONSENT:         "The current terms must be accepted first",
+		CODE_NOT_FOUND:       "The resource was not found",
+		CODE_CONFLICT:        "The resource was changed by another request",
+		CODE_GONE:            "The resource is no longer available",
+		CODE_LENGTH_REQUIRED: "The request must declare its content length",
+		CODE_PRECONDITION:    "The resource was changed since it was loaded",
+		CODE_TOO_LARGE:       "The request is too large",
+		CODE_VALIDATION:      "The data is not valid",
+		CODE_IDEMPOTENCY_KEY: "The idempotency key was used for another request",
+		CODE_LOCKED:          "The resource is locked by another user",
+		CODE_RATE_LIMIT:      "Too many requests, please try again later",
+		CODE_INTERNAL:        "An unexpected error occurred",
+		CODE_NOT_IMPLEMENTED: "The operation is not supported",
+		CODE_UNAVAILABLE:     "The service is temporarily unavailable",
+	})
+	Translations.Add("de", map[string]string{
+		CODE_BAD_REQUEST:     "Die Anfrage ist ungültig",
+		CODE_CONFIRMATION:    "Das Löschen muss bestätigt werden",
+		CODE_UNAUTHORIZED:    "Eine Anmeldung ist erforderlich",
+		CODE_PERMISSION:      "Sie haben keine Berechtigung für diesen Vorgang",
+		CODE_QUOTA:           "Das Kontingent der Ressource ist aufgebraucht",
+		CODE_CONSENT:         "Die aktuellen Bedingungen müssen zuerst akzeptiert werden",
+		CODE_NOT_FOUND:       "Die Ressource wurde nicht gefunden",
+		CODE_CONFLICT:        "Die Ressource wurde von einer anderen Anfrage geändert",
+		CODE_GONE:            "Die Ressource ist nicht mehr verfügbar",
+		CODE_LENGTH_REQUIRED: "Die Anfrage muss ihre Länge angeben",
+		CODE_PRECONDITION:    "Die Ressource wurde seit dem Laden geändert",
+		CODE_TOO_LARGE:       "Die Anfrage ist zu groß",
+		CODE_VALIDATION:      "Die Daten sind ungültig",
+		CODE_IDEMPOTENCY_KEY: "Der Idempotenzschlüssel wurde für eine andere Anfrage verwendet",
+		CODE_LOCKED:          "Die Ressource ist von einem anderen Benutzer gesperrt",
+		CODE_RATE_LIMIT:      "Zu viele Anfragen, bitte versuchen Sie es später erneut",
+		CODE_INTERNAL:        "Ein unerwarteter Fehler ist aufgetreten",
+		CODE_NOT_IMPLEMENTED: "Der Vorgang wird nicht unterstützt",
+		CODE_UNAVAILABLE:     "Der Dienst ist vorübergehend nicht verfügbar",
+	})
+	Translations.Add("fr", map[string]string{
+		CODE_BAD_REQUEST:     "La requête n'est pas valide",
+		CODE_CONFIRMATION:    "La suppression doit être confirmée",
+		CODE_UNAUTHORIZED:    "Une authentification est requise",
+		CODE_PERMISSION:      "Vous n'avez pas l'autorisation pour cette opération",
+		CODE_QUOTA:           "Le quota de la ressource est épuisé",
+		CODE_CONSENT:         "Les conditions actuelles doivent d'abord être acceptées",
+		CODE_NOT_FOUND:       "La ressource est introuvable",
+		CODE_CONFLICT:        "La ressource a été modifiée par une autre requête",
+		CODE_GONE:            "La ressource n'est plus disponible",
+		CODE_LENGTH_REQUIRED: "La requête doit indiquer sa longueur",
+		CODE_PRECONDITION:    "La ressource a été modifiée depuis son chargement",
+		CODE_TOO_LARGE:       "La requête est trop volumineuse",
+		CODE_VALIDATION:      "Les données ne sont pas valides",
+		CODE_IDEMPOTENCY_KEY: "La clé d'idempotence a été utilisée pour une autre requête",
+		CODE_LOCKED:          "La ressource est verrouillée par un autre utilisateur",
+		CODE_RATE_LIMIT:      "Trop de requêtes, veuillez réessayer plus tard",
+		CODE_INTERNAL:        "Une erreur inattendue s'est produite",
+		CODE_NOT_IMPLEMENTED: "L'opération n'est pas prise en charge",
+		CODE_UNAVAILABLE:     "Le service est temporairement indisponible",
+	})
+	Translations.Add("bg", map[string]string{
+		CODE_BAD_REQUEST:     "Заявката е невалидна",
+		CODE_CONFIRMATION:    "Изтриването трябва да бъде потвърдено",
+		CODE_UNAUTHORIZED:    "Необходимо е удостоверяване",
+		CODE_PERMISSION:      "Нямате права за тази операция",
+		CODE_QUOTA:           "Квотата на ресурса е изчерпана",
+		CODE_CONSENT:         "Първо трябва да приемете актуалните условия",
+		CODE_NOT_FOUND:       "Ресурсът не е намерен",
+		CODE_CONFLICT:        "Ресурсът е променен от друга заявка",
+		CODE_GONE:            "Ресурсът вече не е наличен",
+		CODE_LENGTH_REQUIRED: "Заявката трябва да посочва дължината си",
+		CODE_PRECONDITION:    "Ресурсът е променен след зареждането му",
+		CODE_TOO_LARGE:       "Заявката е твърде голяма",
+		CODE_VALIDATION:      "Данните са невалидни",
+		CODE_IDEMPOTENCY_KEY: "Ключът за идемпотентност е използван за друга заявка",
+		CODE_LOCKED:          "Ресурсът е заключен от друг потребител",
+		CODE_RATE_LIMIT:      "Твърде много заявки, опитайте отново по-късно",
+		CODE_INTERNAL:        "Възникна неочаквана грешка",
+		CODE_NOT_IMPLEMENTED: "Операцията не се поддържа",
+		CODE_UNAVAILABLE:     "Услугата е временно недостъпна",
+	})
+}
+
+// localeMiddleware negotiates the locale of the messages from the Accept-Language header, it is announced
+// with the Content-Language header that ERROR uses to localize the messages
+func localeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := Translations.Negotiate(r.Header.Get("Accept-Language"))
+		w.Header().Set("Content-Language", locale)
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(i18n.WithLocale(r.Context(), locale)))
+	})
+}
+
+// localizedMessage returns the message of the code in the locale of the response
+func localizedMessage(w http.ResponseWriter, code string) string {
+	message, _ := Translations.Message(w.Header().Get("Content-Language"), code)
+	return message
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/metering"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+)
+
+// statusWriter records the status code of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code
+func (writer *statusWriter) WriteHeader(status int) {
+	writer.status = status
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the original writer for http.ResponseController
+func (writer *statusWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+// metered counts the request of the user with the status of its response when METERING_REQUESTS is enabled,
+// anonymous callers have no user
+func (server *Server) metered(user *domain.User, next http.HandlerFunc) http.HandlerFunc {
+	if server.RequestMeter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		writer := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next(writer, r)
+		userID := uuid.Nil
+		if user != nil {
+			userID = user.ID
+		}
+		server.RequestMeter.Record(userID, server.requestResource(r), r.Method, writer.status)
+	}
+}
+
+// requestResource returns the first segment of the route template after the API path, the resource of the
+// resource routes, e.g. meal for /api/meal/{id}, and the group of the other routes, e.g. admin or me
+func (server *Server) requestResource(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	template = strings.TrimPrefix(template, "/"+server.ServerConfig.APIPath+"/")
+	resource, _, _ := strings.Cut(template, "/")
+	return resource
+}
+
+// measureUsage counts the objects of the user in the resource, and the bytes of the uploaded content of the files
+func (server *Server) measureUsage(ctx context.Context, resourceName string, userID uuid.UUID) (int64, int64, error) {
+	resource, ok := server.Resources.Resources[resourceName]
+	if !ok || !resource.IsOwned() {
+		return 0, 0, metering.ErrNotMetered
+	}
+	db := server.DB.WithContext(ctx).Model(reflect.New(resource.Type).Interface()).Where("user_id = ?", userID)
+	var rows int64
+	err := db.Count(&rows).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	if resource.Type != reflect.TypeFor[domain.File]() {
+		return rows, 0, nil
+	}
+	var bytes int64
+	err = server.DB.WithContext(ctx).Model(&domain.File{}).Where("user_id = ? AND status = ?", userID, domain.FILE_UPLOADED).
+		Select("COALESCE(SUM(size), 0)").Scan(&bytes).Error
+	return rows, bytes, err
+}
+
+// usageTenant returns the tenant of the user of WithTenant, empty without tenants
+func (server *Server) usageTenant(ctx context.Context, userID uuid.UUID) (string, error) {
+	if server.Tenant == nil {
+		return "", nil
+	}
+	user := &domain.User{}
+	err := server.DB.WithContext(ctx).First(user, "id = ?", userID).Error
+	if err != nil {
+		return "", err
+	}
+	return server.Tenant(user), nil
+}
+
+// sizedResources returns the resources whose content is metered in bytes, the files
+func (server *Server) sizedResources() map[string]bool {
+	return map[string]bool{(&domain.File{}).ResourceName(): true}
+}
+
+// refreshUsage measures the usage of all users in all owned resources, including the objects created before the
+// metering was enabled, and returns the number of measurements
+func (server *Server) refreshUsage(ctx context.Context) (int, error) {
+	refreshed := 0
+	for _, resource := range server.Resources.Resources {
+		if !resource.IsOwned() {
+			continue
+		}
+		var owners, metered []uuid.UUID
+		err := server.DB.WithContext(ctx).Model(reflect.New(resource.Type).Interface()).Distinct().Pluck("user_id", &owners).Error
+		if err != nil {
+			return refreshed, err
+		}
+		// Users without objects left are measured too, their usage drops to zero
+		err = server.DB.WithContext(ctx).Model(&metering.Usage{}).Where("resource = ?", resource.Name).Pluck("user_id", &metered).Error
+		if err != nil {
+			return refreshed, err
+		}
+		measured := map[uuid.UUID]bool{}
+		for _, userID := range append(owners, metered...) {
+			if measured[userID] {
+				continue
+			}
+			measured[userID] = true
+			err = server.Meter.Refresh(ctx, resource.Name, userID)
+			if err != nil {
+				return refreshed, err
+			}
+			refreshed++
+		}
+	}
+	return refreshed, nil
+}
+
+// usageReport reads the grouping and the resource of the report from the query, user or tenant
+func (server *Server) usageReport(w http.ResponseWriter, r *http.Request) ([]metering.Total, bool) {
+	ctx := r.Context()
+	groupBy := r.URL.Query().Get("group_by")
+	switch groupBy {
+	case "":
+		groupBy = metering.USER
+	case metering.USER, metering.TENANT:
+	default:
+		ERROR(w, http.StatusBadRequest, fmt.Errorf("group_by must be %s or %s, got %q", metering.USER, metering.TENANT, groupBy))
+		return nil, false
+	}
+	totals, err := server.Meter.Report(ctx, groupBy, r.URL.Query().Get("resource"))
+	if err != nil {
+		common.GetLogger(ctx).Error("Error reporting usage", "error", err)
+		ERROR(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return totals, true
+}
+
+// Usage returns the metered usage per user or tenant and resource
+func (server *Server) Usage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		totals, ok := server.usageReport(w, r)
+		if !ok {
+			return
+		}
+		JSON(w, http.StatusOK, totals)
+	}
+}
+
+// ExportUsage returns the metered usage as CSV, e.g. for the billing system
+func (server *Server) ExportUsage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		totals, ok := server.usageReport(w, r)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+		w.WriteHeader(http.StatusOK)
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"owner", "resource", "rows", "bytes"})
+		for _, total := range totals {
+			writer.Write([]string{total.Owner, total.Resource, strconv.FormatInt(total.Rows, 10), strconv.FormatInt(total.Bytes, 10)})
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			common.GetLogger(r.Context()).Error("Error writing usage export", "error", err)
+		}
+	}
+}
+
+// RequestUsage returns the requests per user, resource and method with their error rates. The report is filtered
+// by user_id and resource, and by the period from and to in RFC 3339, e.g. from=2024-05-01T00:00:00Z.
+func (server *Server) RequestUsage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := r.URL.Query()
+		filter := metering.RequestFilter{Resource: query.Get("resource")}
+		var err error
+		if userID := query.Get("user_id"); userID != "" {
+			filter.UserID, err = uuid.FromString(userID)
+			if err != nil {
+				ERROR(w, http.StatusBadRequest, fmt.Errorf("user_id: %w", err))
+				return
+			}
+		}
+		for name, value := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+			if query.Get(name) == "" {
+				continue
+			}
+			*value, err = time.Parse(time.RFC3339, query.Get(name))
+			if err != nil {
+				ERROR(w, http.StatusBadRequest, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+		}
+		totals, err := server.RequestMeter.Report(ctx, filter)
+		if err != nil {
+			common.GetLogger(ctx).Error("Error reporting requests", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, totals)
+	}
+}
+
+// RefreshUsage measures the usage of all users again
+func (server *Server) RefreshUsage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		refreshed, err := server.refreshUsage(ctx)
+		if err != nil {
+			common.GetLogger(ctx).Error("Error refreshing usage", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, map[string]int{"refreshed": refreshed})
+	}
+}
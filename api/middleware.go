@@ -2,30 +2,72 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/dzahariev/respite/alerts"
+	"github.com/dzahariev/respite/auth"
 	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/flags"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/dzahariev/respite/tracing"
 
 	"github.com/gofrs/uuid/v5"
 )
 
-// Static is a Wrapper for static resources
+// Static is a Wrapper for static resources, with the Content Security Policy of CSP_POLICY
 func (server *Server) Static() http.Handler {
-	return http.FileServer(http.Dir("./public"))
+	return server.withCSP(http.FileServer(http.Dir("./public")))
 }
 
-// Health is a Wrapper for static resources
+// Health is the liveness check, it fails when the database is unavailable longer than the liveness threshold
 func (server *Server) Health() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, "OK")
+		writeCheck(w, server.HealthChecker.Live())
 	}
 }
 
+// Ready is the readiness check, it fails when the database is unavailable longer than the readiness threshold
+func (server *Server) Ready() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if server.starting.Load() {
+			writeCheck(w, errors.New("startup tasks are running"))
+			return
+		}
+		if server.warming.Load() {
+			writeCheck(w, errors.New("warm-up tasks are running"))
+			return
+		}
+		if server.draining.Load() {
+			writeCheck(w, errors.New("the instance is shutting down"))
+			return
+		}
+		if server.finalizing() {
+			writeCheck(w, errors.New("the instance is finalizing"))
+			return
+		}
+		writeCheck(w, server.HealthChecker.Ready())
+	}
+}
+
+// writeCheck writes the outcome of a health check as plain text
+func writeCheck(w http.ResponseWriter, err error) {
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "OK")
+}
+
 // Public is a Wrapper for public resources
 func (server *Server) Public(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -33,104 +75,325 @@ func (server *Server) Public(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// Protected is a Wrapper for protected and Global resources
+// Protected is a Wrapper for protected and Global resources, writes require the consents of CONSENT_DOCUMENTS and
+// run as dry runs on request on the routes that are dryRunnable
 func (server *Server) Protected(permission string, resource common.Resource, next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+	return server.Authenticated(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		logger := common.GetLogger(ctx)
+		permissions := getPermissions(r)
 
-		// Parse token
-		authHeader := r.Header.Get("Authorization")
-		if len(authHeader) < 7 {
-			logger.Error("Unauthorized request, missing or invalid Authorization header")
-			ERROR(w, http.StatusUnauthorized, fmt.Errorf("unauthorized, missing bearer authorization header"))
+		requestContext := server.newRequestContext(r, resource)
+		ctxWithRC := respitectx.WithRequestContext(ctx, requestContext)
+
+		// Replace request context
+		rWithRC := r.WithContext(ctxWithRC)
+
+		// Check permissions
+		if permissions.Can(resource.Name, permission) {
+			if permission == WRITE && server.consentRequired(w, r) {
+				return
+			}
+			if permission == WRITE && isDryRun(r) {
+				if r.Context().Value(dryRunKey{}) == nil {
+					ERROR(w, http.StatusBadRequest, fmt.Errorf("dry runs are not supported by %s %s", r.Method, r.URL.Path))
+					return
+				}
+				requestContext.DryRun = true
+				w.Header().Set("X-Dry-Run", "true")
+			}
+			next(w, rWithRC)
+		} else {
+			// lack of permissions
+			logger.Error("Unauthorized request, no permission for resource", "resource", resource.Name, "permission", permission)
+			ERROR(w, http.StatusUnauthorized, WithCode(CODE_PERMISSION, fmt.Errorf("unauthorized, no permission for %s.%s", resource.Name, permission)))
+			return
+		}
+	})
+}
+
+// readable is a Wrapper for the read routes of resources. The public resources of SERVER_PUBLIC_RESOURCES are
+// read without a token, the anonymous callers are limited per client address by CACHE_PUBLIC_RATE_LIMIT.
+// Requests with an Authorization header are authenticated as for the other resources.
+func (server *Server) readable(resource common.Resource, next http.HandlerFunc) http.HandlerFunc {
+	protected := server.Protected(READ, resource, next)
+	if !slices.Contains(server.ServerConfig.PublicResources, resource.Name) {
+		return protected
+	}
+	limit := server.CacheConfig.PublicRateLimit
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			protected(w, r)
 			return
 		}
-		authType := strings.ToLower(authHeader[:6])
-		if authType != "bearer" {
-			logger.Error("Unauthorized request, invalid Authorization header type", "type", authType)
-			ERROR(w, http.StatusUnauthorized, fmt.Errorf("unauthorized, invalid bearer authorization header"))
+		key := fmt.Sprintf("ratelimit:public:%s", server.clientAddress(r))
+		if limit > 0 && server.limited(w, r, key, limit, 1, "X-RateLimit-Public") {
+			ERROR(w, http.StatusTooManyRequests, fmt.Errorf("rate limit of %d anonymous requests per %s exceeded", limit, server.CacheConfig.RateLimitWindow))
 			return
 		}
-		// Verify token is valid
-		tokenString := authHeader[7:]
-		tokenString = strings.TrimSpace(tokenString)
-		err := server.AuthClient.RetrospectToken(ctx, tokenString)
-		if err != nil {
-			logger.Error("Unauthorized request, invalid token", "error", err)
-			ERROR(w, http.StatusUnauthorized, err)
+		requestContext := server.newRequestContext(r, resource)
+		server.metered(nil, next)(w, r.WithContext(respitectx.WithRequestContext(r.Context(), requestContext)))
+	}
+}
+
+// Permitted is a Wrapper for routes that are not bound to a registered resource but require a permission, e.g. admin.read
+func (server *Server) Permitted(resourceName, permission string, next http.HandlerFunc) http.HandlerFunc {
+	return server.Authenticated(func(w http.ResponseWriter, r *http.Request) {
+		if !getPermissions(r).Can(resourceName, permission) {
+			common.GetLogger(r.Context()).Error("Unauthorized request, no permission for resource", "resource", resourceName, "permission", permission)
+			ERROR(w, http.StatusUnauthorized, WithCode(CODE_PERMISSION, fmt.Errorf("unauthorized, no permission for %s.%s", resourceName, permission)))
 			return
 		}
-		// Create user if not exists
-		userFromInfo, err := server.AuthClient.GetUserFromToken(ctx, tokenString)
-		if err != nil {
-			logger.Error("Unauthorized request, cannot get user from token", "error", err)
+		next(w, r)
+	})
+}
+
+// Authenticated is a Wrapper for routes that require a valid token but are not bound to a resource
+func (server *Server) Authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+
+		// Parse token
+		tokenString, err := bearerToken(r)
+		if err != nil && !server.devMode {
+			logger.Error("Unauthorized request, missing or invalid Authorization header", "error", err)
 			ERROR(w, http.StatusUnauthorized, err)
 			return
 		}
-		loadedUser, _ := server.DBLoadUser(ctx, string(userFromInfo.ID.String())) // we ignore the error as it is expected if user do not exists
-		if loadedUser == nil {
-			err := server.DBSaveUser(ctx, userFromInfo)
-			if err != nil {
-				logger.Error("Error saving user from token", "error", err)
-				ERROR(w, http.StatusUnauthorized, err)
-				return
-			}
-		}
-		loadedUser, err = server.DBLoadUser(ctx, string(userFromInfo.ID.String()))
+		// Verify token, load user and resolve permissions
+		loadedUser, permissions, err := server.authenticate(ctx, tokenString)
 		if err != nil {
-			logger.Error("Error loading user from token", "error", err)
-			ERROR(w, http.StatusUnauthorized, err)
+			ERROR(w, authenticationStatus(err), err)
 			return
 		}
 
 		// Create new context with current user
-		ctxWithUser := context.WithValue(ctx, common.CurrentUserKey, loadedUser)
-		// Get roles from token
-		roles, err := server.AuthClient.GetRolesFromToken(ctxWithUser, tokenString)
-		if err != nil {
-			logger.Error("Unauthorized request, cannot get roles from token", "error", err)
-			ERROR(w, http.StatusUnauthorized, err)
-			return
+		ctxWithUser := respitectx.WithCurrentUser(ctx, loadedUser)
+		// Create new context with current user permissions
+		ctxWithUserPerm := respitectx.WithPermissions(ctxWithUser, permissions)
+		// Evaluate feature flags for current user
+		if server.Flags != nil {
+			ctxWithUserPerm = flags.NewContext(ctxWithUserPerm, server.evaluateFlags(ctx, loadedUser))
 		}
-		var permissions []string
-		for _, role := range roles {
-			permissions = append(permissions, server.RoleToPermissions[role]...)
+		ctxWithUserPerm = server.delegate(ctxWithUserPerm, tokenString)
+		if tenant := server.tenantID(ctx, loadedUser); tenant != "" {
+			ctxWithUserPerm = respitectx.WithTenant(ctxWithUserPerm, tenant)
 		}
-		// Create new context with current user permissions
-		ctxWithUserPerm := context.WithValue(ctxWithUser, common.CurrentUserPermissionsKey, permissions)
 
 		// Replace request context
-		rWithUserPerm := r.WithContext(ctxWithUserPerm)
+		server.metered(loadedUser, next)(w, r.WithContext(ctxWithUserPerm))
+	}
+}
 
-		requestContext := common.NewRequestContext(rWithUserPerm, server.DB, resource, server.Resources)
-		ctxWithUserPermRC := context.WithValue(ctxWithUserPerm, common.RequestContextKey, requestContext)
+// delegate keeps the token of the caller in the context for the step-up checks, and so that handlers and hooks
+// call downstream APIs on behalf of the caller with auth.DelegatedToken
+func (server *Server) delegate(ctx context.Context, tokenString string) context.Context {
+	ctx = context.WithValue(ctx, callerTokenKey{}, tokenString)
+	if server.Exchanger == nil {
+		return ctx
+	}
+	return auth.NewDelegationContext(ctx, server.Exchanger, tokenString)
+}
 
-		// Replace request context
-		rWithUserPermRC := r.WithContext(ctxWithUserPermRC)
+// getPermissions returns the permissions of the authenticated caller
+func getPermissions(r *http.Request) common.Permissions {
+	return common.GetPermissions(r.Context())
+}
 
-		// Check permissions
-		if havePermission(resource.Name, permission, permissions) {
-			next(w, rWithUserPermRC)
-		} else {
-			// lack of permissions
-			logger.Error("Unauthorized request, no permission for resource", "resource", resource.Name, "permission", permission)
-			ERROR(w, http.StatusUnauthorized, fmt.Errorf("unauthorized, no permission for %s.%s", resource.Name, permission))
-			return
-		}
+// bearerToken extracts the bearer token from the Authorization header
+func bearerToken(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) < 7 {
+		return "", fmt.Errorf("unauthorized, missing bearer authorization header")
+	}
+	authType := strings.ToLower(authHeader[:6])
+	if authType != "bearer" {
+		return "", fmt.Errorf("unauthorized, invalid bearer authorization header")
 	}
+	return strings.TrimSpace(authHeader[7:]), nil
 }
 
-// Middleware to add request_id logger into context
+// authenticationStatus is 503 when the identity provider is unavailable, so that valid tokens are not rejected
+// as unauthorized during its outages, and 401 otherwise
+func authenticationStatus(err error) int {
+	if errors.Is(err, auth.ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, ErrTenantSuspended) || errors.Is(err, ErrTenantDomain) || errors.Is(err, ErrUserDeactivated) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// authenticate verifies the token, creates the user if not exists and resolves the permissions from token roles
+func (server *Server) authenticate(ctx context.Context, tokenString string) (*domain.User, common.Permissions, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "authenticate")
+	defer span.End()
+	logger := common.GetLogger(ctx)
+	// Verify token is valid
+	err := server.AuthClient.RetrospectToken(ctx, tokenString)
+	if err != nil {
+		logger.Error("Unauthorized request, invalid token", "error", err)
+		server.Alerts.Record(alerts.AUTH_FAILURE, "Repeated authentication failures", map[string]string{"error": err.Error()})
+		return nil, nil, err
+	}
+	// Create user if not exists
+	userFromInfo, err := server.AuthClient.GetUserFromToken(ctx, tokenString)
+	if err != nil {
+		logger.Error("Unauthorized request, cannot get user from token", "error", err)
+		return nil, nil, err
+	}
+	err = server.checkRevocation(ctx, tokenString, userFromInfo)
+	if err != nil {
+		logger.Warn("Unauthorized request, revoked token", "userID", userFromInfo.ID, "error", err)
+		return nil, nil, err
+	}
+	loadedUser, err := server.DBProvisionUser(ctx, userFromInfo)
+	if err != nil {
+		logger.Error("Error provisioning user from token", "error", err)
+		return nil, nil, err
+	}
+	if loadedUser.Deactivated {
+		logger.Warn("Forbidden request of a deactivated user", "userID", loadedUser.ID)
+		return nil, nil, WithCode(CODE_DEACTIVATED, ErrUserDeactivated)
+	}
+	err = server.checkTenant(ctx, loadedUser)
+	if err != nil {
+		logger.Warn("Forbidden request of the tenant", "error", err)
+		return nil, nil, err
+	}
+
+	// Get roles from token
+	roles, err := server.AuthClient.GetRolesFromToken(ctx, tokenString)
+	if err != nil {
+		logger.Error("Unauthorized request, cannot get roles from token", "error", err)
+		return nil, nil, err
+	}
+	permissions, err := server.userPermissions(ctx, loadedUser, roles)
+	if err != nil {
+		return nil, nil, err
+	}
+	return loadedUser, permissions, nil
+}
+
+// userPermissions resolves the permissions of the roles of the token of the user, of its LDAP groups and of the
+// bootstrap admin
+func (server *Server) userPermissions(ctx context.Context, user *domain.User, roles []string) (common.Permissions, error) {
+	groupRoles, err := server.ldapRoles(ctx, user)
+	if err != nil {
+		common.GetLogger(ctx).Error("Cannot resolve LDAP groups", "error", err)
+		return nil, err
+	}
+	roles = append(slices.Clone(roles), groupRoles...)
+	if server.isBootstrapAdmin(user) {
+		roles = append(roles, server.BootstrapConfig.AdminRole)
+	}
+	var permissions common.Permissions
+	for _, role := range roles {
+		permissions = append(permissions, server.rolePermissions(role)...)
+	}
+	return permissions, nil
+}
+
+// newRequestContext creates the request context for the resource with all configured server components
+func (server *Server) newRequestContext(r *http.Request, resource common.Resource) *common.RequestContext {
+	requestContext := common.NewRequestContext(r, server.DB, resource, server.Resources)
+	server.limitPage(r, requestContext)
+	return server.withComponents(r.Context(), requestContext)
+}
+
+// newRequestContextWithDetails creates the request context for callers that are not plain REST requests
+func (server *Server) newRequestContextWithDetails(ctx context.Context, pageSize, page, offset int, user *domain.User, resource common.Resource, permissions []string) *common.RequestContext {
+	requestContext := common.NewRequestContextWithDetails(pageSize, page, offset, user, resource, server.DB, server.Resources, permissions)
+	return server.withComponents(ctx, requestContext)
+}
+
+// withComponents attaches the configured server components to the request context, the tenant of the request is
+// resolved from the context
+func (server *Server) withComponents(ctx context.Context, requestContext *common.RequestContext) *common.RequestContext {
+	requestContext.Publisher = server.Publisher
+	requestContext.Outbox = server.Outbox
+	requestContext.Origin = server.Origin
+	requestContext.Repository = server.Repository
+	requestContext.HTTPClient = server.HTTPClient
+	requestContext.Authorizer = server.Authorizer
+	requestContext.DBScopes.Session = server.session(ctx, requestContext)
+	requestContext.Quota = server.quota(ctx, requestContext)
+	requestContext.EditLocks = server.EditLocks
+	requestContext.Inbox = server.Inbox
+	if server.Flags != nil {
+		requestContext.Flags = server.evaluateFlags(ctx, requestContext.DBScopes.User)
+	}
+	return requestContext
+}
+
+// session returns the PostgreSQL parameters of the queries of the request, nil without a PostgreSQL database,
+// e.g. with SQLite of the development mode or with the memory backend
+func (server *Server) session(ctx context.Context, requestContext *common.RequestContext) *common.Session {
+	if server.DB == nil || server.DB.Dialector.Name() != "postgres" {
+		return nil
+	}
+	config := server.dbConfig
+	session := common.NewSession(config.StatementTimeout, config.SessionRole, config.ApplicationName, requestContext.RequestID.String())
+	if !common.RowLevelSecurity {
+		return session
+	}
+	if session == nil {
+		session = &common.Session{}
+	}
+	session.Settings = server.rowLevelSettings(ctx, requestContext.DBScopes)
+	return session
+}
+
+// rowLevelSettings returns the parameters read by the row-level security policies: the ID of the user, whether
+// the user sees the objects of all users, e.g. with the global permission, and the tenant of the request.
+// Requests without a user have an empty app.user_id, which matches no objects.
+func (server *Server) rowLevelSettings(ctx context.Context, scopes common.DBScopes) map[string]string {
+	settings := map[string]string{"app.user_id": "", "app.global": strconv.FormatBool(!scopes.OwnedOnly)}
+	if scopes.User != nil {
+		settings["app.user_id"] = scopes.User.ID.String()
+	}
+	if tenant := server.tenantID(ctx, scopes.User); tenant != "" {
+		settings["app.tenant_id"] = tenant
+	}
+	return settings
+}
+
+// Middleware to add request_id logger into context, with trace_id and span_id when the request is traced
 func loggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		reqID := uuid.Must(uuid.NewV4()).String()
-		logger := slog.Default().With("request_id", reqID)
-		ctx := context.WithValue(r.Context(), common.LoggerKey, logger)
+		reqID := uuid.Must(uuid.NewV4())
+		logger := slog.Default().With("request_id", reqID.String()).With(tracing.LogAttributes(r.Context())...)
+		ctx := respitectx.WithLogger(r.Context(), logger)
+		ctx = respitectx.WithRequestID(ctx, reqID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// recoverMiddleware turns panics of handlers into internal server errors
+func (server *Server) recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// Aborted responses are handled by the http server
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			common.GetLogger(r.Context()).Error("Handler panicked", "panic", recovered, "method", r.Method, "path", r.URL.Path, "stack", string(debug.Stack()))
+			server.Alerts.Record(alerts.PANIC, "Handler panicked", map[string]string{
+				"panic": fmt.Sprint(recovered),
+				"route": fmt.Sprintf("%s %s", r.Method, r.URL.Path),
+			})
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("internal server error"))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
 // ContentTypeJSON set the content type to JSON
 func ContentTypeJSON(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -139,35 +402,36 @@ func ContentTypeJSON(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// JSON returns data as JSON stream
+// JSON returns data as JSON, encoded by the encoder of the server into a reused buffer
 func JSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	buffer, err := encodeResponse(data)
 	w.WriteHeader(statusCode)
-	err := json.NewEncoder(w).Encode(data)
 	if err != nil {
 		fmt.Fprintf(w, "%s", err.Error())
+		return
 	}
+	w.Write(buffer.Bytes())
+	releaseBuffer(buffer)
 }
 
-// ERROR returns error as JSON representation
+// ERROR returns error as JSON representation together with its error code and the message of the code
+// in the negotiated locale
 func ERROR(w http.ResponseWriter, statusCode int, err error) {
 	if err != nil {
+		code := errorCode(statusCode, err)
+		if negotiatedError(w, statusCode, code, err) {
+			return
+		}
 		JSON(w, statusCode, struct {
-			Error string `json:"error"`
+			Error   string `json:"error"`
+			Code    string `json:"code"`
+			Message string `json:"message,omitempty"`
 		}{
-			Error: err.Error(),
+			Error:   err.Error(),
+			Code:    code,
+			Message: localizedMessage(w, code),
 		})
 		return
 	}
 	JSON(w, http.StatusBadRequest, nil)
 }
-
-// havePermission is to check if the permission for the resource is present in the list of permissions
-func havePermission(resource, permission string, permissions []string) bool {
-	for _, currentPermission := range permissions {
-		resourcePermission := fmt.Sprintf("%s.%s", resource, permission)
-		if strings.EqualFold(currentPermission, resourcePermission) {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dzahariev/respite/cache"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/events"
+	"github.com/dzahariev/respite/notify"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// NOTIFICATIONS_CHANNEL is the channel of the cache on which the replicas share the delivered notifications
+	NOTIFICATIONS_CHANNEL = "notifications"
+	// notificationStreamBuffer is the number of notifications buffered per stream, a stream that does not keep up
+	// loses the notifications, which stay in the inbox
+	notificationStreamBuffer = 16
+)
+
+// NotificationRule delivers a notification for every event of the type, e.g. order.updated, to the users of
+// Recipients. The subject of the template is the title of the notification and its text the body, both rendered
+// with notify.TemplateData.
+type NotificationRule struct {
+	Type       string
+	Template   *notify.Template
+	Recipients func(ctx context.Context, event events.Event) ([]uuid.UUID, error)
+}
+
+// WithNotifications keeps the notifications of the users in their inbox, delivered by the hooks with
+// common.Notify, by the shares of the objects and by the rules of WithNotificationRules
+func WithNotifications(notificationsConfig cfg.Notifications) Option {
+	return func(server *Server) {
+		server.NotificationsConfig = notificationsConfig
+	}
+}
+
+// WithNotificationRules delivers the notifications of the rules for the mutation events, e.g. the state changes of
+// the objects
+func WithNotificationRules(rules ...NotificationRule) Option {
+	return func(server *Server) {
+		server.notificationRules = append(server.notificationRules, rules...)
+	}
+}
+
+// OwnerRecipients returns the owner of the object of the event, from the user_id of its data
+func OwnerRecipients(ctx context.Context, event events.Event) ([]uuid.UUID, error) {
+	var object struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	if len(event.Data) == 0 {
+		return nil, nil
+	}
+	err := json.Unmarshal(event.Data, &object)
+	if err != nil {
+		return nil, err
+	}
+	if object.UserID == uuid.Nil {
+		return nil, nil
+	}
+	return []uuid.UUID{object.UserID}, nil
+}
+
+// initNotifications keeps the inbox in the database, shared with the replicas when the cache broadcasts, and
+// delivers the notifications of the rules for the events
+func (server *Server) initNotifications() {
+	if !server.NotificationsConfig.Enabled {
+		return
+	}
+	server.Inbox = common.NewInbox(server.DB, server.NotificationsConfig.Retention)
+	if broadcaster, ok := server.Cache.(cache.Broadcaster); ok {
+		server.Inbox.Share(broadcaster, NOTIFICATIONS_CHANNEL)
+	}
+	if len(server.notificationRules) > 0 {
+		server.Publisher = events.MultiPublisher{server.Publisher, notificationPublisher{server: server}}
+	}
+}
+
+// initNotificationRoutes registers the routes of the inbox of the caller
+func (server *Server) initNotificationRoutes() {
+	if server.Inbox == nil {
+		return
+	}
+	path := fmt.Sprintf("/%s/me/notifications", server.ServerConfig.APIPath)
+	server.Router.HandleFunc(path, server.Authenticated(ContentTypeJSON(server.MyNotifications()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(path+"/unread", server.Authenticated(ContentTypeJSON(server.UnreadNotifications()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(path+"/read", server.Authenticated(ContentTypeJSON(server.ReadNotifications()))).Methods(http.MethodPost)
+	if server.NotificationsConfig.Stream {
+		server.Router.HandleFunc(path+"/stream", queryToken(server.Authenticated(server.NotificationStream()))).Methods(http.MethodGet)
+	}
+	server.Router.HandleFunc(path+"/{id}", server.Authenticated(ContentTypeJSON(server.DismissNotification()))).Methods(http.MethodDelete)
+}
+
+// notificationPublisher delivers the notifications of the rules for the mutation events
+type notificationPublisher struct {
+	server *Server
+}
+
+// Publish delivers the notifications of the rules of the type of the event to their recipients, except to the user
+// of the mutation. The notifications of an event have stable IDs, so that its retried deliveries are not repeated.
+func (publisher notificationPublisher) Publish(ctx context.Context, event events.Event) error {
+	var errs []error
+	for i, rule := range publisher.server.notificationRules {
+		if rule.Type != event.Type {
+			continue
+		}
+		notifications, err := ruleNotifications(ctx, i, rule, event)
+		if err == nil {
+			err = publisher.server.Inbox.Deliver(ctx, notifications...)
+		}
+		if err != nil {
+			common.GetLogger(ctx).Error("Error delivering event notifications", "event", event.ID, "type", event.Type, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close does nothing
+func (publisher notificationPublisher) Close() error {
+	return nil
+}
+
+// ruleNotifications renders the notifications of the rule for the event
+func ruleNotifications(ctx context.Context, index int, rule NotificationRule, event events.Event) ([]domain.Notification, error) {
+	recipients, err := rule.Recipients(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	data := notify.TemplateData{Event: event}
+	if len(event.Data) > 0 {
+		err = json.Unmarshal(event.Data, &data.Object)
+		if err != nil {
+			return nil, err
+		}
+	}
+	message, err := rule.Template.Render(nil, data)
+	if err != nil {
+		return nil, err
+	}
+	var notifications []domain.Notification
+	for _, recipient := range recipients {
+		if event.UserID != nil && *event.UserID == recipient {
+			continue
+		}
+		objectID := event.ObjectID
+		notifications = append(notifications, domain.Notification{
+			ID:       uuid.NewV5(event.ID, strconv.Itoa(index)+"/"+recipient.String()),
+			UserID:   recipient,
+			Type:     event.Type,
+			Resource: event.Resource,
+			ObjectID: &objectID,
+			ActorID:  event.UserID,
+			Title:    message.Subject,
+			Body:     message.Text,
+		})
+	}
+	return notifications, nil
+}
+
+// notificationList is a page of the notifications of the caller with the count of the unread ones
+type notificationList struct {
+	PageSize int                   `json:"page_size,omitempty"`
+	Page     int                   `json:"page,omitempty"`
+	Count    int64                 `json:"count"`
+	Unread   int64                 `json:"unread"`
+	Data     []domain.Notification `json:"data"`
+}
+
+// MyNotifications lists the notifications of the caller, the newest first, only the unread ones with unread=true
+func (server *Server) MyNotifications() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, ok := respitectx.CurrentUser(ctx)
+		if !ok || user == nil {
+			ERROR(w, http.StatusUnauthorized, errors.New("unauthorized, no user"))
+			return
+		}
+		scopes := common.NewDBScopesFromRequest(r, false)
+		notifications, count, err := server.Inbox.List(ctx, user.ID, r.URL.Query().Get("unread") == "true", scopes.Offset, scopes.PageSize)
+		if err != nil {
+			common.GetLogger(ctx).Error("Error loading notifications", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		unread, err := server.Inbox.Unread(ctx, user.ID)
+		if err != nil {
+			common.GetLogger(ctx).Error("Error counting notifications", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, notificationList{PageSize: scopes.PageSize, Page: scopes.Page, Count: count, Unread: unread, Data: notifications})
+	}
+}
+
+// UnreadNotifications returns the count of the unread notifications of the caller, e.g. for a badge
+func (server *Server) UnreadNotifications() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, ok := respitectx.CurrentUser(ctx)
+		if !ok || user == nil {
+			ERROR(w, http.StatusUnauthorized, errors.New("unauthorized, no user"))
+			return
+		}
+		unread, err := server.Inbox.Unread(ctx, user.ID)
+		if err != nil {
+			common.GetLogger(ctx).Error("Error counting notifications", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, map[string]int64{"unread": unread})
+	}
+}
+
+// ReadNotifications marks the notifications of the caller of the body as read, e.g. {"ids": ["..."]}, all of them
+// without IDs, and returns how many were marked
+func (server *Server) ReadNotifications() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, ok := respitectx.CurrentUser(ctx)
+		if !ok || user == nil {
+			ERROR(w, http.StatusUnauthorized, errors.New("unauthorized, no user"))
+			return
+		}
+		var request struct {
+			IDs []uuid.UUID `json:"ids"`
+		}
+		if r.ContentLength != 0 {
+			err := json.NewDecoder(r.Body).Decode(&request)
+			if err != nil {
+				ERROR(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		read, err := server.Inbox.MarkRead(ctx, user.ID, request.IDs...)
+		if err != nil {
+			common.GetLogger(ctx).Error("Error marking notifications as read", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, map[string]int64{"read": read})
+	}
+}
+
+// DismissNotification deletes the notification of the path from the inbox of the caller
+func (server *Server) DismissNotification() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, ok := respitectx.CurrentUser(ctx)
+		if !ok || user == nil {
+			ERROR(w, http.StatusUnauthorized, errors.New("unauthorized, no user"))
+			return
+		}
+		uid, err := uuid.FromString(mux.Vars(r)["id"])
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		err = server.Inbox.Dismiss(ctx, user.ID, uid)
+		if err != nil {
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// NotificationStream streams the notifications delivered to the caller as server-sent events, starting with the
+// count of the unread ones. A comment is sent every NOTIFICATIONS_STREAM_PING_INTERVAL, so that the proxies keep
+// the stream open.
+func (server *Server) NotificationStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		user, ok := respitectx.CurrentUser(ctx)
+		if !ok || user == nil {
+			ERROR(w, http.StatusUnauthorized, errors.New("unauthorized, no user"))
+			return
+		}
+		unread, err := server.Inbox.Unread(ctx, user.ID)
+		if err != nil {
+			logger.Error("Error counting notifications", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		notifications := make(chan domain.Notification, notificationStreamBuffer)
+		stop := server.Inbox.Watch(user.ID, func(notification domain.Notification) {
+			select {
+			case notifications <- notification:
+			default:
+			}
+		})
+		defer stop()
+
+		controller := http.NewResponseController(w)
+		// The stream outlives the write timeout of the server
+		_ = controller.SetWriteDeadline(time.Time{})
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		err = controller.Flush()
+		if errors.Is(err, http.ErrNotSupported) {
+			logger.Error("Error streaming notifications, the response cannot be flushed")
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err == nil {
+			err = writeEvent(w, controller, "", "unread", map[string]int64{"unread": unread})
+		}
+		ticker := time.NewTicker(server.NotificationsConfig.StreamPingInterval)
+		defer ticker.Stop()
+		for err == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case notification := <-notifications:
+				err = writeEvent(w, controller, notification.ID.String(), "notification", notification)
+			case <-ticker.C:
+				_, err = fmt.Fprint(w, ": ping\n\n")
+				if err == nil {
+					err = controller.Flush()
+				}
+			}
+		}
+		logger.Debug("Notification stream closed", "userID", user.ID, "reason", err)
+	}
+}
+
+// writeEvent writes a server-sent event with the JSON of the data and flushes it to the client
+func writeEvent(w http.ResponseWriter, controller *http.ResponseController, id, event string, data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body)
+	if err != nil {
+		return err
+	}
+	return controller.Flush()
+}
+
+// queryToken passes the access_token query parameter as the bearer token, as browsers cannot set the headers of
+// the requests of EventSource
+func queryToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
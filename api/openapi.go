@@ -0,0 +1,399 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+)
+
+var (
+	pointType   = reflect.TypeFor[domain.Point]()
+	stringsType = reflect.TypeFor[domain.Strings]()
+	rawType     = reflect.TypeFor[json.RawMessage]()
+	marshaler   = reflect.TypeFor[json.Marshaler]()
+)
+
+// readOnlyFields are the fields of domain.Base that are set by the server
+var readOnlyFields = []string{"id", "created_at", "updated_at"}
+
+// OpenAPI is the OpenAPI 3 document of the REST API generated from the registered resources
+type OpenAPI struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+	Security   []map[string][]string                   `json:"security"`
+}
+
+// OpenAPIInfo describes the API
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIOperation is an operation of a path, e.g. the list of a resource
+type OpenAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Tags        []string                   `json:"tags"`
+	Summary     string                     `json:"summary"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+}
+
+// OpenAPIParameter is a path or query parameter of an operation
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIRequestBody is the JSON body of an operation
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse is a response of an operation, responses without content have no body
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIMediaType is the schema of a body
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIComponents are the schemas of the resources and the bearer authentication
+type OpenAPIComponents struct {
+	Schemas         map[string]*OpenAPISchema        `json:"schemas"`
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes"`
+}
+
+// OpenAPISecurityScheme is the authentication of the operations
+type OpenAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// OpenAPISchema is the schema of a JSON value, the empty schema accepts any value
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	ReadOnly             bool                      `json:"readOnly,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+}
+
+// schemaRef returns the reference to the schema of the components
+func schemaRef(name string) *OpenAPISchema {
+	return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+// jsonContent returns the JSON content with the schema
+func jsonContent(schema *OpenAPISchema) map[string]OpenAPIMediaType {
+	return map[string]OpenAPIMediaType{"application/json": {Schema: schema}}
+}
+
+// OpenAPI generates the document of the resource routes from the registered resources
+func (server *Server) OpenAPI() *OpenAPI {
+	document := &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info:    OpenAPIInfo{Title: server.ServerConfig.APITitle, Version: server.ServerConfig.APIVersion},
+		Paths:   map[string]map[string]*OpenAPIOperation{},
+		Components: OpenAPIComponents{
+			Schemas: map[string]*OpenAPISchema{
+				"Error": {Type: "object", Properties: map[string]*OpenAPISchema{
+					"error":   {Type: "string"},
+					"code":    {Type: "string"},
+					"message": {Type: "string"},
+				}},
+			},
+			SecuritySchemes: map[string]OpenAPISecurityScheme{"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"}},
+		},
+		Security: []map[string][]string{{"bearer": {}}},
+	}
+	names := map[reflect.Type]string{}
+	for _, resource := range server.Resources.Resources {
+		names[resource.Type] = typeName(resource.Name)
+	}
+	for _, resource := range server.Resources.Resources {
+		schema := objectSchema(resource.Type, names)
+		for _, name := range readOnlyFields {
+			if property, ok := schema.Properties[name]; ok {
+				property.ReadOnly = true
+			}
+		}
+		document.Components.Schemas[names[resource.Type]] = schema
+		server.addOpenAPIPaths(document, resource, names[resource.Type])
+	}
+	return document
+}
+
+// addOpenAPIPaths adds the operations of the resource routes
+func (server *Server) addOpenAPIPaths(document *OpenAPI, resource common.Resource, name string) {
+	errorResponse := OpenAPIResponse{Description: "Error", Content: jsonContent(schemaRef("Error"))}
+	objectResponse := func(description string) OpenAPIResponse {
+		return OpenAPIResponse{Description: description, Content: jsonContent(schemaRef(name))}
+	}
+	body := &OpenAPIRequestBody{Required: true, Content: jsonContent(schemaRef(name))}
+	id := OpenAPIParameter{Name: "id", In: "path", Required: true, Schema: &OpenAPISchema{Type: "string", Format: "uuid"}}
+	list := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{
+		"page_size": {Type: "integer"},
+		"page":      {Type: "integer"},
+		"count":     {Type: "integer"},
+		"data":      {Type: "array", Items: schemaRef(name)},
+	}}
+	if len(resource.Aggregates) > 0 {
+		aggregates := map[string]*OpenAPISchema{}
+		for _, aggregate := range resource.Aggregates {
+			// The minimums and the maximums have the type of their field
+			value := &OpenAPISchema{}
+			switch aggregate.Function {
+			case domain.AGGREGATE_COUNT:
+				value.Type = "integer"
+			case domain.AGGREGATE_SUM, domain.AGGREGATE_AVG:
+				value.Type = "number"
+			}
+			if aggregate.GroupBy != "" {
+				value = &OpenAPISchema{Type: "object", AdditionalProperties: value}
+			}
+			aggregates[aggregate.Name] = value
+		}
+		list.Properties["meta"] = &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{
+			"aggregates": {Type: "object", Properties: aggregates},
+		}}
+	}
+	tags := []string{resource.Name}
+	// The reads of the public resources do not require the token of the document
+	var readSecurity []map[string][]string
+	if slices.Contains(server.ServerConfig.PublicResources, resource.Name) {
+		readSecurity = []map[string][]string{{}, {"bearer": {}}}
+	}
+	document.Paths[fmt.Sprintf("/%s/%s", server.ServerConfig.APIPath, resource.Name)] = map[string]*OpenAPIOperation{
+		"get": {
+			OperationID: "list" + name,
+			Tags:        tags,
+			Summary:     "List the " + resource.Name + " objects",
+			Parameters: []OpenAPIParameter{
+				{Name: "page", In: "query", Schema: &OpenAPISchema{Type: "integer"}},
+				{Name: "page_size", In: "query", Schema: &OpenAPISchema{Type: "integer"}},
+			},
+			Responses: map[string]OpenAPIResponse{
+				"200":     {Description: "The page of objects", Content: jsonContent(list)},
+				"default": errorResponse,
+			},
+			Security: readSecurity,
+		},
+		"post": {
+			OperationID: "create" + name,
+			Tags:        tags,
+			Summary:     "Create a " + resource.Name + " object",
+			RequestBody: body,
+			Responses:   map[string]OpenAPIResponse{"201": objectResponse("The created object"), "default": errorResponse},
+		},
+	}
+	document.Paths[fmt.Sprintf("/%s/%s/{id}", server.ServerConfig.APIPath, resource.Name)] = map[string]*OpenAPIOperation{
+		"get": {
+			OperationID: "get" + name,
+			Tags:        tags,
+			Summary:     "Get a " + resource.Name + " object",
+			Parameters:  []OpenAPIParameter{id},
+			Responses:   map[string]OpenAPIResponse{"200": objectResponse("The object"), "default": errorResponse},
+			Security:    readSecurity,
+		},
+		"put": {
+			OperationID: "update" + name,
+			Tags:        tags,
+			Summary:     "Update a " + resource.Name + " object",
+			Parameters:  []OpenAPIParameter{id},
+			RequestBody: body,
+			Responses:   map[string]OpenAPIResponse{"200": objectResponse("The updated object"), "default": errorResponse},
+		},
+		"delete": {
+			OperationID: "delete" + name,
+			Tags:        tags,
+			Summary:     "Delete a " + resource.Name + " object",
+			Parameters:  []OpenAPIParameter{id},
+			Responses:   map[string]OpenAPIResponse{"204": {Description: "The object is deleted"}, "default": errorResponse},
+		},
+	}
+	// The SQL views are read-only, the materialized ones are refreshed
+	if resource.SQLView != nil {
+		delete(document.Paths[fmt.Sprintf("/%s/%s", server.ServerConfig.APIPath, resource.Name)], "post")
+		delete(document.Paths[fmt.Sprintf("/%s/%s/{id}", server.ServerConfig.APIPath, resource.Name)], "put")
+		delete(document.Paths[fmt.Sprintf("/%s/%s/{id}", server.ServerConfig.APIPath, resource.Name)], "delete")
+		if resource.SQLView.Materialized {
+			document.Paths[fmt.Sprintf("/%s/%s/refresh", server.ServerConfig.APIPath, resource.Name)] = map[string]*OpenAPIOperation{
+				"post": {
+					OperationID: "refresh" + name,
+					Tags:        tags,
+					Summary:     "Refresh the " + resource.Name + " view",
+					Responses:   map[string]OpenAPIResponse{"204": {Description: "The view is refreshed"}, "default": errorResponse},
+				},
+			}
+		}
+	}
+	if resource.Merge != nil {
+		merge := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{
+			"source_id": {Type: "string", Format: "uuid"},
+			"fields":    {Type: "object", AdditionalProperties: &OpenAPISchema{Type: "string"}},
+		}}
+		document.Paths[fmt.Sprintf("/%s/%s/{id}/merge", server.ServerConfig.APIPath, resource.Name)] = map[string]*OpenAPIOperation{
+			"post": {
+				OperationID: "merge" + name,
+				Tags:        tags,
+				Summary:     "Merge a duplicate into a " + resource.Name + " object",
+				Parameters:  []OpenAPIParameter{id},
+				RequestBody: &OpenAPIRequestBody{Required: true, Content: jsonContent(merge)},
+				Responses:   map[string]OpenAPIResponse{"200": objectResponse("The merged object"), "default": errorResponse},
+			},
+		}
+	}
+	if resource.Shared && server.Authorizer != nil {
+		share := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{
+			"user_id":  {Type: "string", Format: "uuid"},
+			"relation": {Type: "string", Enum: common.Relations},
+		}}
+		shareResponse := OpenAPIResponse{Description: "The granted relation", Content: jsonContent(share)}
+		document.Paths[fmt.Sprintf("/%s/%s/{id}/shares", server.ServerConfig.APIPath, resource.Name)] = map[string]*OpenAPIOperation{
+			"get": {
+				OperationID: "listShares" + name,
+				Tags:        tags,
+				Summary:     "List the relations of the users to a " + resource.Name + " object",
+				Parameters:  []OpenAPIParameter{id},
+				Responses:   map[string]OpenAPIResponse{"200": {Description: "The relations", Content: jsonContent(&OpenAPISchema{Type: "array", Items: share})}, "default": errorResponse},
+			},
+			"post": {
+				OperationID: "share" + name,
+				Tags:        tags,
+				Summary:     "Grant a relation to a " + resource.Name + " object to a user",
+				Parameters:  []OpenAPIParameter{id},
+				RequestBody: &OpenAPIRequestBody{Required: true, Content: jsonContent(share)},
+				Responses:   map[string]OpenAPIResponse{"201": shareResponse, "default": errorResponse},
+			},
+		}
+		document.Paths[fmt.Sprintf("/%s/%s/{id}/shares/{relation}/{user_id}", server.ServerConfig.APIPath, resource.Name)] = map[string]*OpenAPIOperation{
+			"delete": {
+				OperationID: "unshare" + name,
+				Tags:        tags,
+				Summary:     "Revoke a relation to a " + resource.Name + " object from a user",
+				Parameters: []OpenAPIParameter{id,
+					{Name: "relation", In: "path", Required: true, Schema: &OpenAPISchema{Type: "string", Enum: common.Relations}},
+					{Name: "user_id", In: "path", Required: true, Schema: &OpenAPISchema{Type: "string", Format: "uuid"}},
+				},
+				Responses: map[string]OpenAPIResponse{"204": {Description: "The relation is revoked"}, "default": errorResponse},
+			},
+		}
+	}
+	if resource.Deprecation != nil {
+		for _, path := range []string{fmt.Sprintf("/%s/%s", server.ServerConfig.APIPath, resource.Name), fmt.Sprintf("/%s/%s/{id}", server.ServerConfig.APIPath, resource.Name), fmt.Sprintf("/%s/%s/{id}/merge", server.ServerConfig.APIPath, resource.Name)} {
+			for _, operation := range document.Paths[path] {
+				operation.Deprecated = true
+			}
+		}
+	}
+}
+
+// objectSchema returns the schema of the JSON fields of the struct
+func objectSchema(t reflect.Type, names map[reflect.Type]string) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+	for _, field := range jsonFields(t) {
+		schema.Properties[field.Name] = typeSchema(field.Type, names)
+	}
+	return schema
+}
+
+// typeSchema returns the schema of the values of the type, the registered resources are referenced by their
+// names and types with their own JSON encoding that is not known accept any value
+func typeSchema(t reflect.Type, names map[reflect.Type]string) *OpenAPISchema {
+	if t.Kind() == reflect.Pointer {
+		schema := typeSchema(t.Elem(), names)
+		if schema.Ref != "" {
+			return schema
+		}
+		schema.Nullable = true
+		return schema
+	}
+	switch t {
+	case uuidType:
+		return &OpenAPISchema{Type: "string", Format: "uuid"}
+	case timeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case decimalType:
+		return &OpenAPISchema{Type: "string", Format: "decimal"}
+	case pointType:
+		return &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{
+			"type":        {Type: "string"},
+			"coordinates": {Type: "array", Items: &OpenAPISchema{Type: "number"}},
+		}}
+	case stringsType:
+		return &OpenAPISchema{Type: "array", Items: &OpenAPISchema{Type: "string"}}
+	case rawType:
+		return &OpenAPISchema{}
+	}
+	if name, ok := names[t]; ok {
+		return schemaRef(name)
+	}
+	if t.Implements(marshaler) || reflect.PointerTo(t).Implements(marshaler) {
+		return &OpenAPISchema{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &OpenAPISchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+		// Slices are encoded as null when they are nil
+		return &OpenAPISchema{Type: "array", Items: typeSchema(t.Elem(), names), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), names), Nullable: true}
+	case reflect.Struct:
+		return objectSchema(t, names)
+	}
+	return &OpenAPISchema{}
+}
+
+// OpenAPIDocument returns the OpenAPI document of the resource routes
+func (server *Server) OpenAPIDocument(document *OpenAPI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		JSON(w, http.StatusOK, document)
+	}
+}
+
+// operation returns the operation of the method of the path template, nil if it is not documented
+func (document *OpenAPI) operation(method, path string) *OpenAPIOperation {
+	return document.Paths[path][strings.ToLower(method)]
+}
+
+// resolve returns the schema of the reference
+func (document *OpenAPI) resolve(schema *OpenAPISchema) *OpenAPISchema {
+	for schema != nil && schema.Ref != "" {
+		schema = document.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/jobs"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/dzahariev/respite/saga"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// MUTATION is the kind of the long-running creations, updates and deletions of the resource routes
+const MUTATION = "mutation"
+
+// Operation is the status of a long-running operation, a view of its job
+type Operation struct {
+	ID         uuid.UUID       `json:"id"`
+	Kind       string          `json:"kind"`
+	Resource   string          `json:"resource,omitempty"`
+	Status     string          `json:"status"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// newOperation returns the operation of the job
+func newOperation(job *jobs.Job) Operation {
+	return Operation{
+		ID:         job.ID,
+		Kind:       job.Kind,
+		Resource:   job.Resource,
+		Status:     job.Status,
+		CreatedAt:  job.CreatedAt,
+		FinishedAt: job.FinishedAt,
+		Result:     job.Result,
+		Error:      job.Error,
+	}
+}
+
+// OperationFunc computes the result of a long-running operation, the result is kept as JSON also when an error
+// fails the operation
+type OperationFunc func(ctx context.Context, job *jobs.Job) (any, error)
+
+// RegisterOperation registers a kind of long-running operations started with StartOperation, e.g. by custom
+// handlers of slow actions. It requires WithJobs.
+func (server *Server) RegisterOperation(kind string, run OperationFunc) {
+	server.Jobs.Register(kind, func(ctx context.Context, job *jobs.Job, progress jobs.Progress) error {
+		result, runErr := run(ctx, job)
+		if result == nil {
+			return runErr
+		}
+		data, err := json.Marshal(result)
+		if err == nil {
+			job.Result = data
+			err = server.Jobs.Save(ctx, job)
+		}
+		return errors.Join(runErr, err)
+	})
+}
+
+// StartOperation starts a long-running operation of the kind on behalf of the caller, with the parameters kept as
+// JSON, and responds 202 Accepted with the operation and its location
+func (server *Server) StartOperation(w http.ResponseWriter, r *http.Request, kind, resourceName string, parameters any) {
+	ctx := r.Context()
+	logger := common.GetLogger(ctx)
+	data, err := json.Marshal(parameters)
+	if err != nil {
+		logger.Error("Error encoding operation parameters", "kind", kind, "error", err)
+		ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	var userID *uuid.UUID
+	user, ok := respitectx.CurrentUser(ctx)
+	if ok && user != nil {
+		userID = &user.ID
+	}
+	job, err := jobs.NewJob(kind, resourceName, userID, getPermissions(r), string(data))
+	if err == nil {
+		err = server.Jobs.Enqueue(ctx, job)
+	}
+	if err != nil {
+		logger.Error("Error creating operation", "kind", kind, "error", err)
+		ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/%s/operations/%s", r.Host, server.ServerConfig.APIPath, job.ID))
+	if prefers(r, "respond-async") {
+		w.Header().Set("Preference-Applied", "respond-async")
+	}
+	JSON(w, http.StatusAccepted, newOperation(job))
+}
+
+// RegisterSaga registers a kind of long-running operations running the saga, started with StartOperation. The
+// parameters of the operation are the Input of the saga state, its steps run on behalf of the caller with the
+// permissions of the request. The state is kept as the result of the operation after each step, so that the
+// saga of a crashed worker resumes after its completed steps. It requires WithJobs.
+func (server *Server) RegisterSaga(kind string, run *saga.Saga) {
+	server.RegisterOperation(kind, func(ctx context.Context, job *jobs.Job) (any, error) {
+		state := &saga.State{}
+		if len(job.Result) > 0 {
+			err := json.Unmarshal(job.Result, state)
+			if err != nil {
+				return nil, fmt.Errorf("cannot decode the state of saga %s: %w", run.Name, err)
+			}
+		}
+		state.Input = json.RawMessage(job.Parameters)
+		if job.UserID != nil {
+			user, err := server.DBLoadUser(ctx, job.UserID.String())
+			if err != nil {
+				return nil, err
+			}
+			ctx = respitectx.WithCurrentUser(ctx, user)
+		}
+		ctx = respitectx.WithPermissions(ctx, common.Permissions(job.Permissions))
+		err := run.Run(ctx, state, func(ctx context.Context, state *saga.State) error {
+			data, err := json.Marshal(state)
+			if err != nil {
+				return err
+			}
+			job.Result = data
+			return server.Jobs.Save(ctx, job)
+		})
+		return state, err
+	})
+}
+
+// GetOperation reports the status and the result of a long-running operation of the caller
+func (server *Server) GetOperation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := server.loadJob(w, r)
+		if !ok {
+			return
+		}
+		JSON(w, http.StatusOK, newOperation(job))
+	}
+}
+
+// respondsAsync checks that the mutation of the request runs as a long-running operation, for callers with the
+// Prefer: respond-async header. Dry runs and conditional requests are executed at once, as without jobs.
+func (server *Server) respondsAsync(r *http.Request) bool {
+	return server.Jobs != nil && prefers(r, "respond-async") && !isDryRun(r) && r.Header.Get("If-Match") == ""
+}
+
+// startMutation starts the mutation of the request as a long-running operation
+func (server *Server) startMutation(w http.ResponseWriter, r *http.Request, operation BatchOperation) {
+	if len(operation.Body) > 0 && !json.Valid(operation.Body) {
+		ERROR(w, http.StatusBadRequest, errors.New("request body is not valid JSON"))
+		return
+	}
+	server.StartOperation(w, r, MUTATION, operation.Resource, operation)
+}
+
+// mutationOperation executes the mutation of the job as an operation of a batch, with the permissions of the caller
+// that started it. The result has the status, the object or the error of the mutation.
+func (server *Server) mutationOperation(ctx context.Context, job *jobs.Job) (any, error) {
+	operation := BatchOperation{}
+	err := json.Unmarshal([]byte(job.Parameters), &operation)
+	if err != nil {
+		return nil, err
+	}
+	user, _, err := server.jobOwner(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	ctx = respitectx.WithCurrentUser(ctx, user)
+	ctx = respitectx.WithPermissions(ctx, common.Permissions(job.Permissions))
+	publisher := &batchPublisher{}
+	var result BatchResult
+	err = domain.Transaction(server.DB.WithContext(ctx), func(tx *gorm.DB) error {
+		publisher.events = nil
+		var err error
+		result, err = server.batchOperation(ctx, tx, operation, publisher)
+		return err
+	})
+	if err != nil {
+		// The commit failed after the operation
+		if result.Status < http.StatusBadRequest {
+			status := repositoryStatus(err)
+			result = BatchResult{Status: status, Error: err.Error(), Code: errorCode(status, err)}
+		}
+		return result, err
+	}
+	server.publishBatch(ctx, []BatchOperation{operation}, publisher.events)
+	return result, nil
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/gorilla/mux"
+)
+
+// methodPermissions are the permissions of the resources required by the methods of their routes
+var methodPermissions = map[string]string{
+	http.MethodGet:    READ,
+	http.MethodPost:   WRITE,
+	http.MethodPut:    WRITE,
+	http.MethodPatch:  WRITE,
+	http.MethodDelete: WRITE,
+}
+
+// Options returns the methods of the route that the caller is allowed to use in the Allow header, the methods
+// are the ones registered for the path of the route, e.g. GET, POST and OPTIONS for /api/book
+func (server *Server) Options(resource common.Resource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, err := mux.CurrentRoute(r).GetPathTemplate()
+		if err != nil {
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		permissions := getPermissions(r)
+		allowed := []string{}
+		for _, method := range server.routeMethods(template) {
+			permission, ok := methodPermissions[method]
+			if !ok || permissions.Can(resource.Name, permission) {
+				allowed = append(allowed, method)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// routeMethods returns the sorted methods of the routes with the path template
+func (server *Server) routeMethods(template string) []string {
+	var methods []string
+	server.router.Load().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		routeTemplate, err := route.GetPathTemplate()
+		if err != nil || routeTemplate != template {
+			return nil
+		}
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range routeMethods {
+			if !slices.Contains(methods, method) {
+				methods = append(methods, method)
+			}
+		}
+		return nil
+	})
+	slices.Sort(methods)
+	return methods
+}
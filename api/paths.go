@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// overrideMethods are the methods that POST requests may be sent as with the X-HTTP-Method-Override header
+var overrideMethods = map[string]bool{http.MethodPut: true, http.MethodPatch: true, http.MethodDelete: true}
+
+// Handler returns the router with the normalization of the API paths, it is the handler of the HTTP server.
+// With SERVER_TRAILING_SLASH the trailing slashes are removed, e.g. /api/book/ is routed as /api/book, and with
+// SERVER_CASE_INSENSITIVE_PATHS the API path and the resource name are matched regardless of case.
+// With SERVER_METHOD_OVERRIDE authenticated POST requests are routed with the method of X-HTTP-Method-Override.
+// The requests are routed by the current router, which is rebuilt when resources are registered at runtime.
+func (server *Server) Handler() http.Handler {
+	config := server.ServerConfig
+	if !config.TrailingSlash && !config.CaseInsensitivePaths && !config.MethodOverride {
+		return server.gateStartup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server.router.Load().ServeHTTP(w, r)
+		}))
+	}
+	return server.gateStartup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, err := server.overrideMethod(r)
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		path := server.normalizePath(r.URL.Path)
+		if path != r.URL.Path || method != r.Method {
+			r = r.Clone(r.Context())
+			r.URL.Path = path
+			r.URL.RawPath = ""
+			r.Method = method
+		}
+		server.router.Load().ServeHTTP(w, r)
+	}))
+}
+
+// overrideMethod returns the method of the X-HTTP-Method-Override header of POST requests with a bearer token,
+// the token is verified by the routes as for other requests. Other requests keep their method.
+func (server *Server) overrideMethod(r *http.Request) (string, error) {
+	override := strings.ToUpper(strings.TrimSpace(r.Header.Get("X-HTTP-Method-Override")))
+	if !server.ServerConfig.MethodOverride || override == "" || r.Method != http.MethodPost {
+		return r.Method, nil
+	}
+	if _, err := bearerToken(r); err != nil {
+		return r.Method, nil
+	}
+	if !overrideMethods[override] {
+		return "", fmt.Errorf("method %s cannot be overridden, expected PUT, PATCH or DELETE", override)
+	}
+	return override, nil
+}
+
+// normalizePath rewrites the API paths, other paths like the static files are routed as they are.
+// The home route keeps its trailing slash.
+func (server *Server) normalizePath(path string) string {
+	apiPath := server.ServerConfig.APIPath
+	// Segments are "", the API path, the resource and the rest of the path
+	segments := strings.SplitN(path, "/", 4)
+	if len(segments) < 3 || segments[0] != "" {
+		return path
+	}
+	if server.ServerConfig.CaseInsensitivePaths && strings.EqualFold(segments[1], apiPath) {
+		segments[1] = apiPath
+		segments[2] = server.routeName(segments[2])
+	}
+	if segments[1] != apiPath {
+		return path
+	}
+	path = strings.Join(segments, "/")
+	if server.ServerConfig.TrailingSlash && path != "/"+apiPath+"/" {
+		path = strings.TrimRight(path, "/")
+		if path == "/"+apiPath {
+			path += "/"
+		}
+	}
+	return path
+}
+
+// routeName returns the name of the registered resource that matches the segment regardless of case,
+// other segments like graphql or admin are lower case
+func (server *Server) routeName(segment string) string {
+	if _, ok := server.Resources.Resources[segment]; ok {
+		return segment
+	}
+	for name := range server.Resources.Resources {
+		if strings.EqualFold(name, segment) {
+			return name
+		}
+	}
+	return strings.ToLower(segment)
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/rbac"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// maxPermissionChecks limits the entries of a permission check request
+const maxPermissionChecks = 100
+
+// checkActions are the permissions of the resources that can be checked
+var checkActions = []string{READ, WRITE, common.GLOBAL, EXPORT, IMPORT, SUBSCRIBE, UNLOCK, common.READ_PERSONAL, common.READ_SENSITIVE}
+
+// roleActions are the permissions of the resources that can be mapped to roles
+var roleActions = []string{READ, WRITE, common.GLOBAL, EXPORT, IMPORT, SUBSCRIBE, UNSUBSCRIBE, UNLOCK, common.READ_PERSONAL, common.READ_SENSITIVE}
+
+// PermissionCheck is an entry of a permission check, the ID checks that the object is visible to the caller
+type PermissionCheck struct {
+	Resource string     `json:"resource"`
+	Action   string     `json:"action"`
+	ID       *uuid.UUID `json:"id,omitempty"`
+	Allowed  bool       `json:"allowed"`
+	Reason   string     `json:"reason,omitempty"`
+}
+
+// EffectivePermissions are the permissions resolved from the roles of the caller, grouped by resource
+type EffectivePermissions struct {
+	UserID      uuid.UUID           `json:"user_id"`
+	Permissions map[string][]string `json:"permissions"`
+}
+
+// RoleGrant is a permission granted to a role with the admin routes, e.g. {"role": "editor", "permission": "meal.write"}
+type RoleGrant struct {
+	Role       string `json:"role"`
+	Permission string `json:"permission"`
+}
+
+// RolePermissions are the grants of the database and the resulting permissions of all roles
+type RolePermissions struct {
+	Roles  map[string][]string `json:"roles"`
+	Grants []rbac.Grant        `json:"grants"`
+}
+
+// rolePermissions returns the permissions mapped to the role, including the grants of the database if enabled
+func (server *Server) rolePermissions(role string) []string {
+	if server.Permissions != nil {
+		return server.Permissions.Permissions(role)
+	}
+	return server.RoleToPermissions[role]
+}
+
+// checkRolePermission validates that the permission is resource.permission of a registered resource or of the
+// admin, credentials and queries routes
+func (server *Server) checkRolePermission(rolePermission string) error {
+	return checkPermissionOf(server.Resources, rolePermission)
+}
+
+// checkPermissionOf validates that the permission is resource.permission of one of the resources or of the admin,
+// credentials and queries routes
+func checkPermissionOf(resources *common.Resources, rolePermission string) error {
+	resourceName, permission, ok := strings.Cut(rolePermission, ".")
+	_, registered := resources.Resources[resourceName]
+	if !ok || (!registered && resourceName != ADMIN && resourceName != CREDENTIALS && resourceName != QUERIES) {
+		return fmt.Errorf("permission %q is not of a registered resource", rolePermission)
+	}
+	if !slices.Contains(roleActions, permission) {
+		return fmt.Errorf("unknown permission %q, expected one of %s", permission, strings.Join(roleActions, ", "))
+	}
+	return nil
+}
+
+// ListRolePermissions returns the grants of the database and the permissions of all roles
+func (server *Server) ListRolePermissions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		grants, err := server.Permissions.Grants(ctx)
+		if err != nil {
+			logger.Error("Error loading role permissions", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, RolePermissions{Roles: server.Permissions.Roles(), Grants: grants})
+	}
+}
+
+// GrantRolePermission grants the permission to the role, the grant applies to the tokens with the role
+// without a restart and is loaded by the other replicas on their next refresh
+func (server *Server) GrantRolePermission() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		var roleGrant RoleGrant
+		err := json.NewDecoder(r.Body).Decode(&roleGrant)
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, fmt.Errorf("invalid role permission: %w", err))
+			return
+		}
+		roleGrant.Role = strings.TrimSpace(roleGrant.Role)
+		roleGrant.Permission = strings.ToLower(strings.TrimSpace(roleGrant.Permission))
+		if roleGrant.Role == "" {
+			ERROR(w, http.StatusUnprocessableEntity, errors.New("role is required"))
+			return
+		}
+		err = server.checkRolePermission(roleGrant.Permission)
+		if err != nil {
+			ERROR(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		grant, err := server.Permissions.Grant(ctx, roleGrant.Role, roleGrant.Permission)
+		if err != nil {
+			logger.Error("Error granting role permission", "role", roleGrant.Role, "permission", roleGrant.Permission, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		logger.Info("Role permission granted", "role", grant.Role, "permission", grant.Permission)
+		JSON(w, http.StatusCreated, grant)
+	}
+}
+
+// RevokeRolePermission revokes the permission granted to the role in the database, the permissions mapped by
+// the application cannot be revoked
+func (server *Server) RevokeRolePermission() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		vars := mux.Vars(r)
+		role, permission := vars["role"], strings.ToLower(vars["permission"])
+		revoked, err := server.Permissions.Revoke(ctx, role, permission)
+		if err != nil {
+			logger.Error("Error revoking role permission", "role", role, "permission", permission, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !revoked {
+			ERROR(w, http.StatusNotFound, fmt.Errorf("permission %s is not granted to %s", permission, role))
+			return
+		}
+		logger.Info("Role permission revoked", "role", role, "permission", permission)
+		JSON(w, http.StatusNoContent, "")
+	}
+}
+
+// MyPermissions returns the permissions of the caller after the mapping of the roles, including the bootstrap
+// role, e.g. {"meal": ["read", "write"]}, so that clients and support engineers see what a token can do
+func (server *Server) MyPermissions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		effective := EffectivePermissions{Permissions: map[string][]string{}}
+		if user, ok := respitectx.CurrentUser(r.Context()); ok && user != nil {
+			effective.UserID = user.ID
+		}
+		effective.Permissions = getPermissions(r).ByResource()
+		JSON(w, http.StatusOK, effective)
+	}
+}
+
+// CheckPermissions returns for each resource, action and optional ID whether the caller is allowed to perform it,
+// with the same permissions and ownership scopes as the requests, so that clients hide the actions they cannot use
+func (server *Server) CheckPermissions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		var checks []PermissionCheck
+		err := json.NewDecoder(r.Body).Decode(&checks)
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, fmt.Errorf("invalid permission checks: %w", err))
+			return
+		}
+		if len(checks) > maxPermissionChecks {
+			ERROR(w, http.StatusBadRequest, fmt.Errorf("%d permission checks exceed the maximum of %d", len(checks), maxPermissionChecks))
+			return
+		}
+		user, _ := respitectx.CurrentUser(ctx)
+		permissions := getPermissions(r)
+		for i := range checks {
+			check := &checks[i]
+			check.Allowed, check.Reason = false, ""
+			resource, ok := server.Resources.Resources[check.Resource]
+			switch {
+			case !ok:
+				check.Reason = "unknown resource"
+				continue
+			case !slices.Contains(checkActions, check.Action):
+				check.Reason = "unknown action"
+				continue
+			case !permissions.Can(resource.Name, check.Action):
+				check.Reason = "no permission"
+				continue
+			}
+			if check.ID != nil {
+				// The object is loaded with the ownership scope of the caller, objects of others are not found
+				requestContext := server.newRequestContextWithDetails(ctx, 1, 1, 0, user, resource, permissions)
+				_, err = requestContext.Get(ctx, *check.ID)
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					check.Reason = "not found"
+					continue
+				}
+				if err != nil {
+					logger.Error("Error loading object of permission check", "resource", resource.Name, "id", check.ID, "error", err)
+					ERROR(w, http.StatusInternalServerError, err)
+					return
+				}
+			}
+			check.Allowed = true
+		}
+		JSON(w, http.StatusOK, checks)
+	}
+}
+
+// redactPII masks all PII fields of the object data of the resource, whatever the permissions
+func (server *Server) redactPII(resource string, data json.RawMessage) json.RawMessage {
+	return server.Resources.Resources[resource].Redact(data, nil)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/events"
+	"github.com/gorilla/mux"
+)
+
+// Plugin packages a feature that hooks into the server, e.g. webhooks, so that it is enabled with WithPlugins.
+// Plugins implement the hooks they need: ConfigPlugin, ResourcePlugin, MiddlewarePlugin, RoutePlugin,
+// SubscriberPlugin and ShutdownPlugin.
+type Plugin interface {
+	// Name identifies the plugin, e.g. in the logs
+	Name() string
+}
+
+// ConfigPlugin configures the server after the options are applied and before the components are initialized,
+// e.g. to enable the components the plugin depends on
+type ConfigPlugin interface {
+	Plugin
+	Configure(server *Server) error
+}
+
+// ResourcePlugin registers the resources of the plugin with the resources of the application
+type ResourcePlugin interface {
+	Plugin
+	Resources() []domain.Object
+}
+
+// MiddlewarePlugin wraps all routes, after the logging, locale and recovery middlewares
+type MiddlewarePlugin interface {
+	Plugin
+	Middleware(next http.Handler) http.Handler
+}
+
+// RoutePlugin registers the routes of the plugin before the routes of the resources, they are registered
+// again when the router is rebuilt
+type RoutePlugin interface {
+	Plugin
+	Routes(server *Server, router *mux.Router)
+}
+
+// SubscriberPlugin receives the mutation events of all APIs, through the outbox if it is enabled
+type SubscriberPlugin interface {
+	Plugin
+	Subscriber() events.Publisher
+}
+
+// ShutdownPlugin releases the resources of the plugin when the server is shut down
+type ShutdownPlugin interface {
+	Plugin
+	Shutdown(ctx context.Context) error
+}
+
+// WithPlugins enables the plugins, their hooks are called in the given order
+func WithPlugins(plugins ...Plugin) Option {
+	return func(server *Server) {
+		server.Plugins = append(server.Plugins, plugins...)
+	}
+}
+
+// configurePlugins calls the configuration hooks of the plugins, the names of the plugins must be unique
+func (server *Server) configurePlugins() error {
+	names := map[string]bool{}
+	for _, plugin := range server.Plugins {
+		if names[plugin.Name()] {
+			return fmt.Errorf("plugin %s is enabled more than once", plugin.Name())
+		}
+		names[plugin.Name()] = true
+		if configurable, ok := plugin.(ConfigPlugin); ok {
+			err := configurable.Configure(server)
+			if err != nil {
+				return fmt.Errorf("cannot configure plugin %s: %w", plugin.Name(), err)
+			}
+		}
+		slog.Info("Plugin enabled", "plugin", plugin.Name())
+	}
+	return nil
+}
+
+// pluginResources returns the resources of the plugins
+func (server *Server) pluginResources() []domain.Object {
+	var objects []domain.Object
+	for _, plugin := range server.Plugins {
+		if resourcePlugin, ok := plugin.(ResourcePlugin); ok {
+			objects = append(objects, resourcePlugin.Resources()...)
+		}
+	}
+	return objects
+}
+
+// subscribePlugins adds the subscribers of the plugins to the publisher
+func (server *Server) subscribePlugins() {
+	for _, plugin := range server.Plugins {
+		if subscriberPlugin, ok := plugin.(SubscriberPlugin); ok {
+			server.Publisher = events.MultiPublisher{server.Publisher, subscriberPlugin.Subscriber()}
+		}
+	}
+}
+
+// initPluginMiddleware adds the middlewares of the plugins to the router
+func (server *Server) initPluginMiddleware() {
+	for _, plugin := range server.Plugins {
+		if middlewarePlugin, ok := plugin.(MiddlewarePlugin); ok {
+			server.Router.Use(middlewarePlugin.Middleware)
+		}
+	}
+}
+
+// initPluginRoutes registers the routes of the plugins
+func (server *Server) initPluginRoutes() {
+	for _, plugin := range server.Plugins {
+		if routePlugin, ok := plugin.(RoutePlugin); ok {
+			routePlugin.Routes(server, server.Router)
+		}
+	}
+}
+
+// shutdownPlugins calls the shutdown hooks of the plugins in the reverse order
+func (server *Server) shutdownPlugins(ctx context.Context) {
+	for i := len(server.Plugins) - 1; i >= 0; i-- {
+		if shutdownPlugin, ok := server.Plugins[i].(ShutdownPlugin); ok {
+			err := shutdownPlugin.Shutdown(ctx)
+			if err != nil {
+				slog.Error("Error shutting down plugin", "plugin", server.Plugins[i].Name(), "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/jobs"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	DATA_EXPORT = "data_export"
+
+	// zipContentType is the format of the personal data exports
+	zipContentType = "application/zip"
+)
+
+// DataExportManifest describes the content of a personal data export
+type DataExportManifest struct {
+	UserID    uuid.UUID        `json:"user_id"`
+	CreatedAt time.Time        `json:"created_at"`
+	Resources map[string]int64 `json:"resources"`
+	Files     int64            `json:"files"`
+}
+
+// CreateDataExport creates a job packaging all data of the caller into a ZIP archive
+func (server *Server) CreateDataExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		user, ok := respitectx.CurrentUser(ctx)
+		if !ok || user == nil {
+			ERROR(w, http.StatusUnauthorized, errors.New("unauthorized, no user"))
+			return
+		}
+		job, err := server.newJob(r, DATA_EXPORT, (&domain.User{}).ResourceName())
+		if err != nil {
+			logger.Error("Error creating data export job", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		err = server.Jobs.Enqueue(ctx, job)
+		if err != nil {
+			logger.Error("Error creating data export job", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		server.writeJobCreated(w, r, job)
+	}
+}
+
+// dataExportJob writes the user, the objects owned by the user in every resource and the content of the uploaded
+// files to a ZIP archive. Objects of other users are not exported, also with the global permission.
+func (server *Server) dataExportJob(ctx context.Context, job *jobs.Job, progress jobs.Progress) error {
+	user, _, err := server.jobOwner(ctx, job)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("data exports require the user of the job")
+	}
+	file, err := os.CreateTemp("", "data-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	manifest := DataExportManifest{UserID: user.ID, CreatedAt: time.Now().UTC(), Resources: map[string]int64{}}
+	err = writeArchiveJSON(archive, "user.json", user)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(server.Resources.Resources))
+	for name, resource := range server.Resources.Resources {
+		if resource.IsOwned() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var files []*domain.File
+	for i, name := range names {
+		resourceFiles, count, err := server.exportOwnedObjects(ctx, archive, server.Resources.Resources[name], user)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		manifest.Resources[name] = count
+		files = append(files, resourceFiles...)
+		err = progress(ctx, int64(i+1), int64(len(names)), 0)
+		if err != nil {
+			return err
+		}
+	}
+	for _, uploaded := range files {
+		err = server.exportFileContent(ctx, archive, uploaded)
+		if err != nil {
+			return fmt.Errorf("file %s: %w", uploaded.ID, err)
+		}
+		manifest.Files++
+	}
+	err = writeArchiveJSON(archive, "manifest.json", manifest)
+	if err != nil {
+		return err
+	}
+	err = archive.Close()
+	if err != nil {
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	job.Artifact = fmt.Sprintf("jobs/%s/data.zip", job.ID)
+	err = server.Storage.Put(ctx, job.Artifact, file, size, zipContentType)
+	if err != nil {
+		return err
+	}
+	return server.Jobs.Save(ctx, job)
+}
+
+// exportOwnedObjects writes the objects of the user in the resource as a JSON array and returns the uploaded files
+// among them and the number of objects
+func (server *Server) exportOwnedObjects(ctx context.Context, archive *zip.Writer, resource common.Resource, user *domain.User) ([]*domain.File, int64, error) {
+	writer, err := archive.Create(resource.Name + ".json")
+	if err != nil {
+		return nil, 0, err
+	}
+	_, err = io.WriteString(writer, "[")
+	if err != nil {
+		return nil, 0, err
+	}
+	var files []*domain.File
+	var count int64
+	for page := 1; ; page++ {
+		// Without permissions the objects of the user are listed, pages are ordered by created_at and ID
+		requestContext := server.newRequestContextWithDetails(ctx, common.MaxPageSize, page, (page-1)*common.MaxPageSize, user, resource, nil)
+		list, err := requestContext.GetAll(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, object := range list.Data {
+			data, err := json.Marshal(object)
+			if err != nil {
+				return nil, 0, err
+			}
+			separator := ",\n"
+			if count == 0 {
+				separator = "\n"
+			}
+			_, err = io.WriteString(writer, separator+string(data))
+			if err != nil {
+				return nil, 0, err
+			}
+			count++
+			if uploaded, ok := object.(*domain.File); ok && uploaded.Status == domain.FILE_UPLOADED {
+				files = append(files, uploaded)
+			}
+		}
+		if len(list.Data) < common.MaxPageSize {
+			break
+		}
+	}
+	_, err = io.WriteString(writer, "\n]\n")
+	return files, count, err
+}
+
+// exportFileContent copies the stored content of the file to files/<id>/<name> of the archive
+func (server *Server) exportFileContent(ctx context.Context, archive *zip.Writer, file *domain.File) error {
+	content, err := server.Storage.Get(ctx, file.StorageKey)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	name := path.Base(strings.ReplaceAll(file.Name, "\\", "/"))
+	if name == "." || name == ".." || name == "/" {
+		name = "content"
+	}
+	writer, err := archive.Create(fmt.Sprintf("files/%s/%s", file.ID, name))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, content)
+	return err
+}
+
+// writeArchiveJSON writes the value as an indented JSON file of the archive
+func writeArchiveJSON(archive *zip.Writer, name string, value any) error {
+	writer, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/dzahariev/respite/cache"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/presence"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+)
+
+// PRESENCE_CHANNEL is the channel of the cache on which the replicas share the presence
+const PRESENCE_CHANNEL = "presence"
+
+// initPresence tracks the presence of SUBSCRIPTIONS_PRESENCE, shared with the replicas when the cache broadcasts
+func (server *Server) initPresence() {
+	if !server.SubscriptionsConfig.Presence {
+		return
+	}
+	server.Presence = presence.NewTracker(server.SubscriptionsConfig.PresenceTTL)
+	if broadcaster, ok := server.Cache.(cache.Broadcaster); ok {
+		server.Presence.Share(broadcaster, PRESENCE_CHANNEL)
+	}
+}
+
+// presenceWatcher sends the presence of the objects the session joined, the replies are dropped when the socket
+// does not keep up
+func (session *subscriptionSession) presenceWatcher(replies chan<- subscriptionReply) func(presence.Key, []presence.Entry) {
+	return func(key presence.Key, entries []presence.Entry) {
+		session.mutex.RLock()
+		joined := session.joined[subscriptionKey{resource: key.Resource, id: key.ID}]
+		session.mutex.RUnlock()
+		if !joined {
+			return
+		}
+		select {
+		case replies <- subscriptionReply{Type: "presence", Resource: key.Resource, ID: &key.ID, Presence: entries}:
+		default:
+		}
+	}
+}
+
+// handlePresenceMessage joins or leaves the presence of an object, the caller must see the object to join it
+func (server *Server) handlePresenceMessage(ctx context.Context, tokenString string, session *subscriptionSession, message subscriptionMessage) subscriptionReply {
+	errorReply := func(err error) subscriptionReply {
+		return subscriptionReply{Type: "error", Resource: message.Resource, ID: message.ID, Error: err.Error()}
+	}
+	if message.ID == nil {
+		return errorReply(fmt.Errorf("the id of the object is required to %s", message.Action))
+	}
+	key := presence.Key{Resource: message.Resource, ID: *message.ID}
+	subscription := subscriptionKey{resource: message.Resource, id: *message.ID}
+	if message.Action == LEAVE {
+		session.mutex.Lock()
+		delete(session.joined, subscription)
+		session.mutex.Unlock()
+		server.Presence.Leave(ctx, key, session.id)
+		return subscriptionReply{Type: "left", Resource: message.Resource, ID: message.ID}
+	}
+	state := message.State
+	if state == "" {
+		state = presence.VIEWING
+	}
+	if state != presence.VIEWING && state != presence.EDITING {
+		return errorReply(fmt.Errorf("state must be %s or %s, got %s", presence.VIEWING, presence.EDITING, state))
+	}
+	err := server.checkPresenceAccess(ctx, tokenString, message.Resource, *message.ID)
+	if err != nil {
+		return errorReply(err)
+	}
+	session.mutex.Lock()
+	session.joined[subscription] = true
+	session.mutex.Unlock()
+	server.Presence.Join(ctx, key, session.id, presence.Entry{UserID: session.user.ID, Name: session.user.PreferedUserName, State: state})
+	return subscriptionReply{Type: "joined", Resource: message.Resource, ID: message.ID, Presence: server.Presence.Present(key)}
+}
+
+// checkPresenceAccess checks that the caller may read the object, with the permissions checked again on every join
+func (server *Server) checkPresenceAccess(ctx context.Context, tokenString, resourceName string, id uuid.UUID) error {
+	resource, ok := server.Resources.Resources[resourceName]
+	if !ok {
+		return fmt.Errorf("unrecognized resource name: %s", resourceName)
+	}
+	user, permissions, err := server.authenticate(ctx, tokenString)
+	if err != nil {
+		return err
+	}
+	if !permissions.Can(resource.Name, READ) {
+		common.GetLogger(ctx).Error("Unauthorized presence, no permission for resource", "resource", resource.Name, "permission", READ)
+		return fmt.Errorf("unauthorized, no permission for %s.%s", resource.Name, READ)
+	}
+	ctx = respitectx.WithPermissions(respitectx.WithCurrentUser(ctx, user), permissions)
+	requestContext := server.withComponents(ctx, common.NewRequestContextWithDetails(common.MinPageSize, 1, 0, user, resource, server.DB, server.Resources, permissions))
+	_, err = requestContext.Get(ctx, id)
+	return err
+}
+
+// GetPresence returns the users on the object, for the clients without the subscriptions socket
+func (server *Server) GetPresence() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository := common.GetRequestContext(ctx)
+		if repository == nil {
+			logger.Error("Error reading repository from context")
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+			return
+		}
+		uid, err := uuid.FromString(mux.Vars(r)["id"])
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		// The presence is returned for the objects the caller sees
+		_, err = repository.Get(ctx, uid)
+		if err != nil {
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		JSON(w, http.StatusOK, server.Presence.Present(presence.Key{Resource: repository.Resource.Name, ID: uid}))
+	}
+}
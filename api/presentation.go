@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+)
+
+// ResponseHook post-processes the objects of the responses of a resource before they are serialized, e.g. to
+// filter fields, to watermark or to enrich them. The hooks read the current user and the permissions from the
+// context with the respitectx package, they return the object to serialize, a copy when they change it, as the
+// object may be cached, or a type embedding the object with further fields.
+type ResponseHook func(ctx context.Context, resource string, object domain.Object) domain.Object
+
+// WithResponseHook post-processes the objects of the responses of all resources of the REST, GraphQL and gRPC
+// APIs after their PII fields are masked. The hooks run in the order they are given.
+func WithResponseHook(hook ResponseHook) Option {
+	return func(server *Server) {
+		server.responseHooks = append(server.responseHooks, hook)
+	}
+}
+
+// present masks the object for the permissions of the context and passes it to the response hooks
+func (server *Server) present(ctx context.Context, resource common.Resource, object domain.Object) domain.Object {
+	object = resource.Mask(object, common.GetPermissions(ctx))
+	for _, hook := range server.responseHooks {
+		object = hook(ctx, resource.Name, object)
+	}
+	return object
+}
+
+// presentList returns a copy of the list with the objects presented by present
+func (server *Server) presentList(ctx context.Context, resource common.Resource, list *domain.List) *domain.List {
+	if len(server.responseHooks) == 0 {
+		return resource.MaskList(list, common.GetPermissions(ctx))
+	}
+	presented := *list
+	presented.Data = make([]domain.Object, len(list.Data))
+	for i, object := range list.Data {
+		presented.Data[i] = server.present(ctx, resource, object)
+	}
+	return &presented
+}
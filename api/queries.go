@@ -0,0 +1,344 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// QUERIES is the permission resource of the named queries, e.g. queries.read
+const QUERIES = "queries"
+
+// Types of the parameters of the named queries
+const (
+	QUERY_STRING = "string"
+	QUERY_INT    = "int"
+	QUERY_FLOAT  = "float"
+	QUERY_BOOL   = "bool"
+	QUERY_TIME   = "time"
+	QUERY_UUID   = "uuid"
+)
+
+// queryParameterTypes are the types of the parameters of the named queries
+var queryParameterTypes = []string{QUERY_STRING, QUERY_INT, QUERY_FLOAT, QUERY_BOOL, QUERY_TIME, QUERY_UUID}
+
+// queryReserved are the named parameters set by the server, the ID of the caller and the page of the rows
+var queryReserved = []string{"user_id", "limit", "offset", "page", "page_size"}
+
+// queryNamePattern matches the names of the queries and of their parameters
+var queryNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// queryOrderPattern matches the ORDER BY that ends the outer SELECT
+var queryOrderPattern = regexp.MustCompile(`(?is)\border\s+by\b.*$`)
+
+// queryPagePattern matches the LIMIT and OFFSET, which the server adds for the pages
+var queryPagePattern = regexp.MustCompile(`(?i)\b(limit|offset)\b`)
+
+// queryParameterPattern finds the named parameters of the SQL, not the casts like ::date
+var queryParameterPattern = regexp.MustCompile(`(^|[^@:\w])@([a-zA-Z_]\w*)`)
+
+// Query is a named SQL query of the application served at GET /api/queries/{name}, for the reads the resources
+// do not cover, e.g. reports joining several tables. The handlers never get the database, the callers give only
+// the declared parameters and the rows are mapped to the result struct.
+type Query struct {
+	// Name is the name of the route, e.g. sales_by_month
+	Name string
+	// SQL is the SELECT with the named parameters, e.g. WHERE status = @status AND user_id = @user_id, @user_id
+	// is the ID of the caller. It ends with an ORDER BY that orders the rows uniquely, so that the pages neither
+	// repeat nor skip rows, and the server adds the LIMIT and the OFFSET of the page.
+	SQL string
+	// Parameters are the parameters that the callers give, the other query parameters are rejected
+	Parameters []QueryParameter
+	// Result is the struct of the rows, e.g. SalesRow{}, its columns are the gorm columns of the fields
+	Result any
+	// Permission is the permission of the callers as resource.permission, queries.read when empty. With a global
+	// permission the row-level security policies see the rows of all users.
+	Permission string
+}
+
+// QueryParameter is a parameter of a named query
+type QueryParameter struct {
+	Name string `json:"name"`
+	// Type is one of QUERY_STRING, QUERY_INT, QUERY_FLOAT, QUERY_BOOL, QUERY_TIME (RFC 3339) and QUERY_UUID
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	// Default is the value of the optional parameters that are not given, no value is NULL
+	Default string `json:"default,omitempty"`
+}
+
+// queryList is a page of the rows of a named query
+type queryList struct {
+	PageSize int   `json:"page_size"`
+	Page     int   `json:"page"`
+	Count    int64 `json:"count"`
+	Data     any   `json:"data"`
+}
+
+// queryInfo describes a named query to the callers that can run it
+type queryInfo struct {
+	Name       string           `json:"name"`
+	Parameters []QueryParameter `json:"parameters"`
+}
+
+// WithQueries serves the named SQL queries of the application, each at GET /api/queries/{name}
+func WithQueries(queries ...Query) Option {
+	return func(server *Server) {
+		server.queries = append(server.queries, queries...)
+	}
+}
+
+// permission returns the permission of the callers of the query
+func (query Query) permission() string {
+	if query.Permission == "" {
+		return QUERIES + "." + READ
+	}
+	return query.Permission
+}
+
+// validateQueries reports the named queries with invalid names, parameters, results or permissions, and the SQL
+// parameters that are not declared
+func (server *Server) validateQueries() error {
+	var problems []error
+	names := map[string]bool{}
+	for _, query := range server.queries {
+		if !queryNamePattern.MatchString(query.Name) {
+			problems = append(problems, fmt.Errorf("query %q: the name must be lowercase letters, digits and underscores", query.Name))
+			continue
+		}
+		if names[query.Name] {
+			problems = append(problems, fmt.Errorf("query %s: the name is used twice", query.Name))
+		}
+		names[query.Name] = true
+		sql := query.sql()
+		if sql == "" {
+			problems = append(problems, fmt.Errorf("query %s: the SQL is empty", query.Name))
+		} else if order := queryOrderPattern.FindString(outerSQL(sql)); order == "" {
+			problems = append(problems, fmt.Errorf("query %s: the SQL must end with an ORDER BY, so that the pages are stable", query.Name))
+		} else if queryPagePattern.MatchString(order) {
+			problems = append(problems, fmt.Errorf("query %s: the SQL must not have a LIMIT or an OFFSET, the pages add them", query.Name))
+		}
+		resultType := reflect.TypeOf(query.Result)
+		if resultType == nil || resultType.Kind() != reflect.Struct {
+			problems = append(problems, fmt.Errorf("query %s: the result must be a struct, got %T", query.Name, query.Result))
+		}
+		err := checkPermissionOf(server.Resources, query.permission())
+		if err != nil {
+			problems = append(problems, fmt.Errorf("query %s: %w", query.Name, err))
+		}
+		declared := map[string]bool{}
+		for _, parameter := range query.Parameters {
+			if !queryNamePattern.MatchString(parameter.Name) || slices.Contains(queryReserved, parameter.Name) {
+				problems = append(problems, fmt.Errorf("query %s: invalid parameter name %q", query.Name, parameter.Name))
+				continue
+			}
+			declared[parameter.Name] = true
+			if !slices.Contains(queryParameterTypes, parameter.Type) {
+				problems = append(problems, fmt.Errorf("query %s: parameter %s type must be one of %s, got %q", query.Name, parameter.Name, strings.Join(queryParameterTypes, ", "), parameter.Type))
+				continue
+			}
+			if parameter.Default != "" {
+				_, err := parameter.parse(parameter.Default)
+				if err != nil {
+					problems = append(problems, fmt.Errorf("query %s: parameter %s default: %w", query.Name, parameter.Name, err))
+				}
+			}
+		}
+		for _, match := range queryParameterPattern.FindAllStringSubmatch(query.SQL, -1) {
+			if !declared[match[2]] && match[2] != "user_id" {
+				problems = append(problems, fmt.Errorf("query %s: the SQL parameter @%s is not declared", query.Name, match[2]))
+			}
+		}
+	}
+	if len(server.queries) > 0 {
+		if _, ok := server.Resources.Resources[QUERIES]; ok {
+			problems = append(problems, fmt.Errorf("resource %s: the name is used by the routes of the queries", QUERIES))
+		}
+		if server.Repository != nil {
+			problems = append(problems, errors.New("queries require the database, the objects are kept in the repository"))
+		}
+	}
+	err := errors.Join(problems...)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+// sql returns the SQL of the query without the trailing semicolon
+func (query Query) sql() string {
+	return strings.TrimRight(strings.TrimSpace(query.SQL), "; \t\n")
+}
+
+// outerSQL returns the SQL without the parts in parentheses, e.g. the subqueries and the arguments of functions
+func outerSQL(sql string) string {
+	var outer strings.Builder
+	depth := 0
+	for _, char := range sql {
+		switch {
+		case char == '(':
+			depth++
+		case char == ')':
+			depth--
+		case depth == 0:
+			outer.WriteRune(char)
+		}
+	}
+	return outer.String()
+}
+
+// parse converts the value of the parameter to its type
+func (parameter QueryParameter) parse(value string) (any, error) {
+	var parsed any
+	var err error
+	switch parameter.Type {
+	case QUERY_INT:
+		parsed, err = strconv.ParseInt(value, 10, 64)
+	case QUERY_FLOAT:
+		parsed, err = strconv.ParseFloat(value, 64)
+	case QUERY_BOOL:
+		parsed, err = strconv.ParseBool(value)
+	case QUERY_TIME:
+		parsed, err = time.Parse(time.RFC3339, value)
+	case QUERY_UUID:
+		parsed, err = uuid.FromString(value)
+	default:
+		parsed = value
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s must be of type %s, got %q", parameter.Name, parameter.Type, value)
+	}
+	return parsed, nil
+}
+
+// initQueryRoutes registers the routes of the named queries, before the generic routes to take precedence
+func (server *Server) initQueryRoutes() {
+	if len(server.queries) == 0 {
+		return
+	}
+	path := fmt.Sprintf("/%s/%s", server.ServerConfig.APIPath, QUERIES)
+	server.Router.HandleFunc(path, server.Authenticated(ContentTypeJSON(server.Queries()))).Methods(http.MethodGet)
+	for _, query := range server.queries {
+		resourceName, permission, _ := strings.Cut(query.permission(), ".")
+		server.Router.HandleFunc(path+"/"+query.Name, server.Permitted(resourceName, permission, ContentTypeJSON(server.RunQuery(query)))).Methods(http.MethodGet)
+	}
+}
+
+// Queries lists the named queries that the caller can run with their parameters
+func (server *Server) Queries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		permissions := getPermissions(r)
+		infos := []queryInfo{}
+		for _, query := range server.queries {
+			resourceName, permission, _ := strings.Cut(query.permission(), ".")
+			if permissions.Can(resourceName, permission) {
+				infos = append(infos, queryInfo{Name: query.Name, Parameters: query.Parameters})
+			}
+		}
+		JSON(w, http.StatusOK, infos)
+	}
+}
+
+// RunQuery returns the page of the rows of the named query for the parameters of the request
+func (server *Server) RunQuery(query Query) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		user, ok := respitectx.CurrentUser(ctx)
+		if !ok || user == nil {
+			ERROR(w, http.StatusUnauthorized, errors.New("unauthorized, no user"))
+			return
+		}
+		vars, err := queryVars(r, query)
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		vars["user_id"] = user.ID.String()
+		scopes := common.NewDBScopesFromRequest(r, false)
+		page, pageSize := common.NormalizePageWithin(scopes.Page, scopes.PageSize, server.maxPageSize(ctx, user))
+		vars["limit"], vars["offset"] = pageSize, (page-1)*pageSize
+
+		rows := reflect.New(reflect.SliceOf(reflect.TypeOf(query.Result)))
+		rows.Elem().Set(reflect.MakeSlice(rows.Elem().Type(), 0, 0))
+		var count int64
+		requestID, _ := respitectx.RequestID(ctx)
+		owned := !strings.HasSuffix(query.permission(), "."+common.GLOBAL)
+		session := server.session(ctx, &common.RequestContext{RequestID: requestID, DBScopes: common.DBScopes{User: user, OwnedOnly: owned}})
+		err = domain.Transaction(server.DB.WithContext(ctx), func(tx *gorm.DB) error {
+			// The named queries only read, the mode is set before the first query of the transaction
+			if tx.Dialector.Name() == "postgres" {
+				err := tx.Exec("SET TRANSACTION READ ONLY").Error
+				if err != nil {
+					return err
+				}
+			}
+			err := session.Apply(tx)
+			if err != nil {
+				return err
+			}
+			sql := query.sql()
+			// The values are named parameters only for the SQL with parameters
+			var countVars []any
+			if strings.Contains(sql, "@") {
+				countVars = append(countVars, vars)
+			}
+			err = tx.Raw("SELECT COUNT(*) FROM ("+sql+") AS query", countVars...).Scan(&count).Error
+			if err != nil {
+				return err
+			}
+			return tx.Raw(sql+" LIMIT @limit OFFSET @offset", vars).Scan(rows.Interface()).Error
+		})
+		if err != nil {
+			logger.Error("Error running query", "query", query.Name, "error", err)
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		JSON(w, http.StatusOK, queryList{PageSize: pageSize, Page: page, Count: count, Data: rows.Elem().Interface()})
+	}
+}
+
+// queryVars returns the values of the parameters of the query from the request, the parameters that are not
+// declared are rejected
+func queryVars(r *http.Request, query Query) (map[string]any, error) {
+	values := r.URL.Query()
+	for name := range values {
+		if name == "page" || name == "page_size" {
+			continue
+		}
+		if !slices.ContainsFunc(query.Parameters, func(parameter QueryParameter) bool { return parameter.Name == name }) {
+			return nil, fmt.Errorf("unknown parameter %s of query %s", name, query.Name)
+		}
+	}
+	vars := map[string]any{}
+	for _, parameter := range query.Parameters {
+		value := values.Get(parameter.Name)
+		if !values.Has(parameter.Name) {
+			if parameter.Required {
+				return nil, fmt.Errorf("parameter %s of query %s is required", parameter.Name, query.Name)
+			}
+			value = parameter.Default
+		}
+		if value == "" && !values.Has(parameter.Name) {
+			vars[parameter.Name] = nil
+			continue
+		}
+		parsed, err := parameter.parse(value)
+		if err != nil {
+			return nil, err
+		}
+		vars[parameter.Name] = parsed
+	}
+	return vars, nil
+}
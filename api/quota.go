@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	QUOTA_USER   = "user"
+	QUOTA_TENANT = "tenant"
+)
+
+// quotaLimit returns the maximum of objects per owner of the resource, 0 without quota, the quota of the tenant of
+// the user replaces the configured one. Global resources and the users have no owners and no quota.
+func (server *Server) quotaLimit(ctx context.Context, resource common.Resource, user *domain.User) int64 {
+	if !resource.IsOwned() {
+		return 0
+	}
+	if tenant := server.tenantOf(ctx, user); tenant != nil {
+		if limit, ok := tenant.Quota(resource.Name); ok {
+			return limit
+		}
+	}
+	limit, ok := server.QuotasConfig.Limits[resource.Name]
+	if !ok {
+		limit = server.QuotasConfig.Limits["*"]
+	}
+	return limit
+}
+
+// quota returns the quota of the resource for the user of the request context, nil without limit or user
+func (server *Server) quota(ctx context.Context, requestContext *common.RequestContext) *common.Quota {
+	user := requestContext.DBScopes.User
+	limit := server.quotaLimit(ctx, requestContext.Resource, user)
+	if limit <= 0 || user == nil {
+		return nil
+	}
+	resource := requestContext.Resource
+	return &common.Quota{
+		Limit: limit,
+		Count: func(ctx context.Context) (int64, error) {
+			return server.quotaCount(ctx, resource, user)
+		},
+	}
+}
+
+// quotaCount returns the number of objects of the resource owned by the user, or by all users of the tenant of the
+// user with QUOTA_SCOPE=tenant
+func (server *Server) quotaCount(ctx context.Context, resource common.Resource, user *domain.User) (int64, error) {
+	owners, err := server.quotaOwners(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+	object := reflect.New(resource.Type).Interface().(domain.Object)
+	if server.Repository != nil {
+		var count int64
+		for _, owner := range owners {
+			ownerCount, err := server.Repository.Count(ctx, common.DBScopes{User: &domain.User{Base: domain.Base{ID: owner}}, OwnedOnly: true}, object)
+			if err != nil {
+				return 0, err
+			}
+			count += ownerCount
+		}
+		return count, nil
+	}
+	var count int64
+	err = server.DB.WithContext(ctx).Model(object).Where("user_id IN ?", owners).Count(&count).Error
+	return count, err
+}
+
+// quotaOwners returns the IDs of the users whose objects count against the quota of the user
+func (server *Server) quotaOwners(ctx context.Context, user *domain.User) ([]uuid.UUID, error) {
+	if server.QuotasConfig.Scope != QUOTA_TENANT {
+		return []uuid.UUID{user.ID}, nil
+	}
+	tenant := server.Tenant(user)
+	var users []domain.User
+	err := server.DB.WithContext(ctx).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	owners := []uuid.UUID{user.ID}
+	for _, member := range users {
+		if member.ID != user.ID && server.Tenant(&member) == tenant {
+			owners = append(owners, member.ID)
+		}
+	}
+	return owners, nil
+}
+
+// setQuotaHeaders sets the usage of the quota on the response
+func setQuotaHeaders(w http.ResponseWriter, usage *common.Usage) {
+	if usage == nil {
+		return
+	}
+	w.Header().Set("X-Quota-Limit", strconv.FormatInt(usage.Limit, 10))
+	w.Header().Set("X-Quota-Used", strconv.FormatInt(usage.Used, 10))
+	w.Header().Set("X-Quota-Remaining", strconv.FormatInt(usage.Remaining(), 10))
+}
+
+// MyQuotas returns the usage of the quotas of the caller for the resources that have a quota
+func (server *Server) MyQuotas() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, ok := respitectx.CurrentUser(ctx)
+		if !ok || user == nil {
+			ERROR(w, http.StatusUnauthorized, errors.New("unauthorized, no user"))
+			return
+		}
+		usages := []common.Usage{}
+		for _, resource := range server.Resources.Resources {
+			limit := server.quotaLimit(ctx, resource, user)
+			if limit <= 0 {
+				continue
+			}
+			count, err := server.quotaCount(ctx, resource, user)
+			if err != nil {
+				common.GetLogger(ctx).Error("Error counting objects for quota", "resource", resource.Name, "error", err)
+				ERROR(w, repositoryStatus(err), err)
+				return
+			}
+			usages = append(usages, common.Usage{Resource: resource.Name, Limit: limit, Used: count})
+		}
+		sort.Slice(usages, func(i, j int) bool {
+			return usages[i].Resource < usages[j].Resource
+		})
+		JSON(w, http.StatusOK, usages)
+	}
+}
+
+// validateQuotas reports the quotas of resources that are not registered or global, and the tenant scope without
+// the tenants of WithTenant or with a repository, whose users cannot be listed
+func (server *Server) validateQuotas() error {
+	var problems []error
+	for name := range server.QuotasConfig.Limits {
+		resource, ok := server.Resources.Resources[name]
+		switch {
+		case name == "*":
+		case !ok:
+			problems = append(problems, fmt.Errorf("QUOTA_LIMITS: unknown resource %s", name))
+		case !resource.IsOwned():
+			problems = append(problems, fmt.Errorf("QUOTA_LIMITS: the objects of resource %s have no owners", name))
+		}
+	}
+	if server.QuotasConfig.Scope == QUOTA_TENANT && len(server.QuotasConfig.Limits) > 0 {
+		if server.Tenant == nil {
+			problems = append(problems, errors.New("QUOTA_SCOPE: the tenant scope requires the tenants of WithTenant"))
+		}
+		if server.Repository != nil {
+			problems = append(problems, errors.New("QUOTA_SCOPE: the tenant scope requires the database"))
+		}
+	}
+	err := errors.Join(problems...)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
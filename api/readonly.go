@@ -0,0 +1,35 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrReadOnly is returned for the writes of the read-only servers
+var ErrReadOnly = errors.New("the server is read-only")
+
+// readOnly answers the writes with 405 so that read replicas serve only the reads. The POST requests that only
+// read, i.e. the permission checks, the GraphQL queries and the signing of the download URLs, pass, the GraphQL
+// schema has no mutations.
+func (server *Server) readOnly(next http.Handler) http.Handler {
+	reads := map[string]bool{
+		fmt.Sprintf("/%s/permissions/check", server.ServerConfig.APIPath): true,
+		fmt.Sprintf("/%s/graphql", server.ServerConfig.APIPath):           true,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			path := strings.TrimSuffix(r.URL.Path, "/")
+			signing := strings.HasSuffix(path, "/download-url") || strings.HasSuffix(path, "/artifact-url")
+			if !reads[path] && !signing {
+				w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+				ERROR(w, http.StatusMethodNotAllowed, WithCode(CODE_READ_ONLY, ErrReadOnly))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
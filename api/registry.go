@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+)
+
+// RegisterResource registers the resource on the running server and rebuilds the routes, so that applications
+// add resources without recreating the server. Requests in flight are served by the previous routes.
+// The table of the resource is not migrated, it must exist before the resource is registered.
+func (server *Server) RegisterResource(ctx context.Context, object domain.Object) error {
+	server.registryMutex.Lock()
+	defer server.registryMutex.Unlock()
+	resources := server.Resources.Clone()
+	err := resources.Register(object)
+	if err != nil {
+		return err
+	}
+	err = server.replaceResources(ctx, resources)
+	if err != nil {
+		return err
+	}
+	slog.Info("Resource registered", "resource", object.ResourceName())
+	return nil
+}
+
+// UnregisterResource removes the resource from the running server and rebuilds the routes, its objects are
+// kept in the database. The users resource cannot be unregistered.
+func (server *Server) UnregisterResource(ctx context.Context, name string) error {
+	server.registryMutex.Lock()
+	defer server.registryMutex.Unlock()
+	if name == (&domain.User{}).ResourceName() {
+		return fmt.Errorf("resource %s cannot be unregistered", name)
+	}
+	resources := server.Resources.Clone()
+	err := resources.Unregister(name)
+	if err != nil {
+		return err
+	}
+	err = server.replaceResources(ctx, resources)
+	if err != nil {
+		return err
+	}
+	slog.Info("Resource unregistered", "resource", name)
+	return nil
+}
+
+// replaceResources replaces the registered resources and rebuilds the routes and the permissions of the
+// bootstrap role, the previous resources and routes are kept if the routes cannot be built
+func (server *Server) replaceResources(ctx context.Context, resources *common.Resources) error {
+	previousResources, previousRouter, previousRoles := server.Resources.Resources, server.Router, server.RoleToPermissions
+	restore := func() {
+		server.Resources.Resources, server.Router, server.RoleToPermissions = previousResources, previousRouter, previousRoles
+	}
+	// The map is replaced instead of changed, the components keep the pointer to the resources
+	server.Resources.Resources = resources.Resources
+	err := server.validateResourceRateLimits()
+	if err == nil {
+		err = server.validateSensitiveOperations()
+	}
+	if err == nil {
+		err = server.validatePublicResources()
+	}
+	if err == nil {
+		err = server.validateQuotas()
+	}
+	if err == nil {
+		err = server.validateEditLocks()
+	}
+	if err == nil {
+		err = server.validateCounters()
+	}
+	if err == nil {
+		err = server.validateSQLViews()
+	}
+	if err == nil {
+		err = server.validateQueries()
+	}
+	if err != nil {
+		restore()
+		return err
+	}
+	server.initBootstrap()
+	err = server.initRouter()
+	if err != nil {
+		restore()
+		return fmt.Errorf("cannot rebuild routes: %w", err)
+	}
+	if server.Permissions != nil {
+		err = server.Permissions.Configure(ctx, server.RoleToPermissions)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/cache"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+)
+
+// Headers of the signed requests of the operations of REPLAY_OPERATIONS
+const (
+	HEADER_REQUEST_TIMESTAMP = "X-Request-Timestamp"
+	HEADER_REQUEST_NONCE     = "X-Request-Nonce"
+	HEADER_REQUEST_SIGNATURE = "X-Request-Signature"
+)
+
+// ErrReplayedRequest is returned for the signed requests whose signature does not match, whose timestamp is out of
+// the window or whose nonce was already used
+var ErrReplayedRequest = errors.New("invalid or replayed signed request")
+
+// WithReplayProtection requires signed requests with a timestamp and a single-use nonce for the operations of
+// REPLAY_OPERATIONS, e.g. the deletes
+func WithReplayProtection(replayConfig cfg.Replay) Option {
+	return func(server *Server) {
+		server.ReplayConfig = replayConfig
+	}
+}
+
+// initReplay keeps the used nonces in the cache, so that they are shared by the replicas, or in process memory
+// without it
+func (server *Server) initReplay() {
+	if len(server.ReplayConfig.Operations) == 0 {
+		return
+	}
+	server.nonces = server.Cache
+	if server.nonces == nil {
+		server.nonces = cache.NewMemoryCache(server.CacheConfig.Prefix)
+	}
+}
+
+// ReplayProtected is a Wrapper for the routes whose requests are signed with REPLAY_SIGNING_KEY, e.g. the custom
+// routes of destructive operations. The requests with an invalid signature, with a timestamp out of REPLAY_WINDOW
+// or with a nonce that was already used are answered with 401, the bodies above REPLAY_MAX_BODY_SIZE with 413.
+func (server *Server) ReplayProtected(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, server.ReplayConfig.MaxBodySize))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				ERROR(w, http.StatusRequestEntityTooLarge, err)
+				return
+			}
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		err = server.checkRequestSignature(r, body)
+		if err != nil {
+			logger.Warn("Unauthorized request, invalid signed request", "path", r.URL.Path, "error", err)
+			ERROR(w, http.StatusUnauthorized, fmt.Errorf("%w: %w", ErrReplayedRequest, err))
+			return
+		}
+		nonce := r.Header.Get(HEADER_REQUEST_NONCE)
+		// The nonces are kept as long as their timestamp is accepted on either side of the clock of the server
+		unused, err := server.nonces.SetNX(ctx, "nonce:"+nonce, []byte{1}, 2*server.ReplayConfig.Window)
+		if err != nil {
+			logger.Error("Error storing request nonce", "error", err)
+			ERROR(w, http.StatusServiceUnavailable, fmt.Errorf("cannot check the nonce of the request: %w", err))
+			return
+		}
+		if !unused {
+			logger.Warn("Unauthorized request, replayed nonce", "path", r.URL.Path, "nonce", nonce)
+			ERROR(w, http.StatusUnauthorized, fmt.Errorf("%w: nonce already used", ErrReplayedRequest))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkRequestSignature checks the timestamp against the window and the signature of the request
+func (server *Server) checkRequestSignature(r *http.Request, body []byte) error {
+	timestamp := r.Header.Get(HEADER_REQUEST_TIMESTAMP)
+	nonce := r.Header.Get(HEADER_REQUEST_NONCE)
+	signature := r.Header.Get(HEADER_REQUEST_SIGNATURE)
+	if timestamp == "" || nonce == "" || signature == "" {
+		return fmt.Errorf("the %s, %s and %s headers are required", HEADER_REQUEST_TIMESTAMP, HEADER_REQUEST_NONCE, HEADER_REQUEST_SIGNATURE)
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	skew := time.Since(time.Unix(seconds, 0)).Abs()
+	if skew > server.ReplayConfig.Window {
+		return fmt.Errorf("timestamp is %s away from the server clock", skew.Truncate(time.Second))
+	}
+	expected := RequestSignature(server.ReplayConfig.SigningKey, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// RequestSignature is the signature of a request for the X-Request-Signature header, the base64url HMAC-SHA256 of
+// the method, of the path with the query, of the timestamp, of the nonce and of the hex SHA-256 of the body, one
+// per line
+func RequestSignature(key, method, uri, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.Join([]string{method, uri, timestamp, nonce, hex.EncodeToString(bodyHash[:])}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
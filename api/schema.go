@@ -0,0 +1,256 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+// maxSchemaProblems limits the problems reported for a body
+const maxSchemaProblems = 10
+
+// schemaValidator collects the problems of a value, requests are validated with the inputs accepted by the
+// decoding, e.g. timestamps as Unix seconds, decimals as numbers and null for any field
+type schemaValidator struct {
+	document *OpenAPI
+	request  bool
+	// open accepts the properties that are not in the schema, e.g. of the payloads of the inbound webhooks
+	open     bool
+	problems []string
+}
+
+// validateSchema validates the JSON bodies of the requests and of the successful responses of the route against
+// the OpenAPI document when SERVER_SCHEMA_VALIDATION is enabled. Invalid requests are rejected with 400 Bad
+// Request and invalid responses are replaced with 500 Internal Server Error, so that the drift between the
+// models and the documented contract is found before production.
+func (server *Server) validateSchema(document *OpenAPI, method, path string, next http.HandlerFunc) http.HandlerFunc {
+	operation := document.operation(method, path)
+	if !server.ServerConfig.SchemaValidation || operation == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := common.GetLogger(r.Context())
+		if operation.RequestBody != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				ERROR(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			// Invalid JSON is left to the handler
+			var value any
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			decoder.UseNumber()
+			if decoder.Decode(&value) == nil {
+				problems := document.validate(operation.RequestBody.Content["application/json"].Schema, value, true)
+				if len(problems) > 0 {
+					logger.Error("Request does not match the schema", "problems", problems)
+					ERROR(w, http.StatusBadRequest, fmt.Errorf("request does not match the schema: %s", strings.Join(problems, "; ")))
+					return
+				}
+			}
+		}
+		buffer := &bufferedResponse{ResponseWriter: w}
+		next(buffer, r)
+		response, ok := operation.Responses[strconv.Itoa(buffer.status)]
+		if ok && response.Content != nil && buffer.body.Len() > 0 {
+			var value any
+			decoder := json.NewDecoder(bytes.NewReader(buffer.body.Bytes()))
+			decoder.UseNumber()
+			err := decoder.Decode(&value)
+			var problems []string
+			if err != nil {
+				problems = []string{fmt.Sprintf("invalid JSON: %v", err)}
+			} else {
+				problems = document.validate(response.Content["application/json"].Schema, value, false)
+			}
+			if len(problems) > 0 {
+				logger.Error("Response does not match the schema", "status", buffer.status, "problems", problems)
+				for _, name := range cachedHeaders {
+					w.Header().Del(name)
+				}
+				ERROR(w, http.StatusInternalServerError, fmt.Errorf("response does not match the schema: %s", strings.Join(problems, "; ")))
+				return
+			}
+		}
+		buffer.flush()
+	}
+}
+
+// validate returns the problems of the value, at most maxSchemaProblems
+func (document *OpenAPI) validate(schema *OpenAPISchema, value any, request bool) []string {
+	validator := &schemaValidator{document: document, request: request}
+	validator.validate("body", schema, value)
+	return validator.problems
+}
+
+// problem records a problem of the value at the path
+func (validator *schemaValidator) problem(path, format string, args ...any) {
+	if len(validator.problems) < maxSchemaProblems {
+		validator.problems = append(validator.problems, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// validate checks the value at the path against the schema
+func (validator *schemaValidator) validate(path string, schema *OpenAPISchema, value any) {
+	schema = validator.document.resolve(schema)
+	if schema == nil || schema.Type == "" {
+		return
+	}
+	if value == nil {
+		if !schema.Nullable && !validator.request {
+			validator.problem(path, "null is not a %s", schema.Type)
+		}
+		return
+	}
+	switch schema.Type {
+	case "string":
+		validator.validateString(path, schema, value)
+	case "integer":
+		number, ok := value.(json.Number)
+		if _, err := number.Int64(); !ok || err != nil {
+			validator.problem(path, "%s is not an integer", describe(value))
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			validator.problem(path, "%s is not a number", describe(value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			validator.problem(path, "%s is not a boolean", describe(value))
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			validator.problem(path, "%s is not an array", describe(value))
+			return
+		}
+		for i, item := range items {
+			validator.validate(fmt.Sprintf("%s[%d]", path, i), schema.Items, item)
+		}
+	case "object":
+		validator.validateObject(path, schema, value)
+	}
+}
+
+// validateString checks the strings and their formats
+func (validator *schemaValidator) validateString(path string, schema *OpenAPISchema, value any) {
+	number, isNumber := value.(json.Number)
+	text, isString := value.(string)
+	switch {
+	case validator.request && isNumber && (schema.Format == "date-time" || schema.Format == "decimal"):
+		// Timestamps are accepted as Unix seconds and decimals as numbers
+		_, err := number.Float64()
+		if err != nil {
+			validator.problem(path, "%s is not a number", number)
+		}
+		return
+	case !isString:
+		validator.problem(path, "%s is not a string", describe(value))
+		return
+	}
+	var err error
+	switch schema.Format {
+	case "uuid":
+		_, err = uuid.FromString(text)
+	case "date-time":
+		if validator.request {
+			_, err = domain.ParseTimestamp(text)
+		} else {
+			_, err = time.Parse(time.RFC3339Nano, text)
+		}
+	case "decimal":
+		_, err = domain.NewDecimal(text)
+	}
+	if err != nil {
+		validator.problem(path, "%q is not a %s", text, schema.Format)
+	}
+}
+
+// validateObject checks the properties of the object, properties that are not in the schema are problems
+func (validator *schemaValidator) validateObject(path string, schema *OpenAPISchema, value any) {
+	object, ok := value.(map[string]any)
+	if !ok {
+		validator.problem(path, "%s is not an object", describe(value))
+		return
+	}
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		property, ok := schema.Properties[name]
+		switch {
+		case ok:
+			validator.validate(path+"."+name, property, object[name])
+		case schema.AdditionalProperties != nil:
+			validator.validate(path+"."+name, schema.AdditionalProperties, object[name])
+		case !validator.open:
+			validator.problem(path+"."+name, "unknown field")
+		}
+	}
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			validator.problem(path+"."+name, "required field is missing")
+		}
+	}
+}
+
+// describe names the JSON type of the value for the problems
+func describe(value any) string {
+	switch typed := value.(type) {
+	case string:
+		return strconv.Quote(typed)
+	case json.Number:
+		return typed.String()
+	case bool:
+		return strconv.FormatBool(typed)
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "null"
+}
+
+// bufferedResponse keeps the response until it is validated, the headers are set on the response writer
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader keeps the status code
+func (buffer *bufferedResponse) WriteHeader(status int) {
+	if buffer.status == 0 {
+		buffer.status = status
+	}
+}
+
+// Write keeps the body
+func (buffer *bufferedResponse) Write(data []byte) (int, error) {
+	if buffer.status == 0 {
+		buffer.status = http.StatusOK
+	}
+	return buffer.body.Write(data)
+}
+
+// flush writes the kept response
+func (buffer *bufferedResponse) flush() {
+	if buffer.status == 0 {
+		return
+	}
+	buffer.ResponseWriter.WriteHeader(buffer.status)
+	buffer.ResponseWriter.Write(buffer.body.Bytes())
+}
@@ -0,0 +1,682 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/auth"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	SCIM_USER_SCHEMA   = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIM_LIST_SCHEMA   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIM_PATCH_SCHEMA  = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIM_ERROR_SCHEMA  = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SCIM_CONFIG_SCHEMA = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SCIM_TYPE_SCHEMA   = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+
+	// scimContentType is the media type of the SCIM requests and responses
+	scimContentType = "application/scim+json"
+)
+
+// ErrUserDeactivated is returned for the requests of the deactivated users
+var ErrUserDeactivated = errors.New("user deactivated")
+
+// scimFilter is a comparison of a filter of the lists, the comparisons are joined with and
+var scimFilter = regexp.MustCompile(`(?i)^\s*([a-z]+(?:\.[a-z]+)?)\s+eq\s+("(?:[^"\\]|\\.)*"|true|false)\s*(?:and\s+|$)`)
+
+// scimColumns are the columns of the filterable attributes of the users
+var scimColumns = map[string]string{
+	"username":     "prefered_user_name",
+	"externalid":   "external_id",
+	"emails":       "email",
+	"emails.value": "email",
+	"active":       "deactivated",
+}
+
+// scimUser is the SCIM representation of a user
+type scimUser struct {
+	Schemas    []string    `json:"schemas"`
+	ID         string      `json:"id,omitempty"`
+	ExternalID string      `json:"externalId,omitempty"`
+	UserName   string      `json:"userName"`
+	Name       *scimName   `json:"name,omitempty"`
+	Emails     []scimEmail `json:"emails,omitempty"`
+	Active     *bool       `json:"active,omitempty"`
+	Meta       *scimMeta   `json:"meta,omitempty"`
+}
+
+type scimName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location"`
+}
+
+// scimList is a page of a list of users
+type scimList struct {
+	Schemas      []string   `json:"schemas"`
+	TotalResults int64      `json:"totalResults"`
+	StartIndex   int        `json:"startIndex"`
+	ItemsPerPage int        `json:"itemsPerPage"`
+	Resources    []scimUser `json:"Resources"`
+}
+
+// scimPatch is a patch of a user, the operations are applied in order
+type scimPatch struct {
+	Schemas    []string `json:"schemas"`
+	Operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	} `json:"Operations"`
+}
+
+// scimError is an error of RFC 7644, the type is given for the invalid requests
+type scimError struct {
+	status   int
+	scimType string
+	err      error
+}
+
+func (err *scimError) Error() string {
+	return err.err.Error()
+}
+
+// WithSCIM provisions the users with SCIM 2.0 at /api/scim/v2, the identity provider authenticates with SCIM_TOKEN
+func WithSCIM(scimConfig cfg.SCIM) Option {
+	return func(server *Server) {
+		server.SCIMConfig = scimConfig
+	}
+}
+
+// initSCIMRoutes registers the SCIM routes, they are authenticated with the token of SCIM_TOKEN instead of Keycloak
+func (server *Server) initSCIMRoutes() {
+	if server.SCIMConfig.Token == "" {
+		return
+	}
+	path := fmt.Sprintf("/%s/scim/v2", server.ServerConfig.APIPath)
+	server.Router.HandleFunc(path+"/ServiceProviderConfig", server.scimAuthenticated(server.SCIMServiceProviderConfig())).Methods(http.MethodGet)
+	server.Router.HandleFunc(path+"/ResourceTypes", server.scimAuthenticated(server.SCIMResourceTypes())).Methods(http.MethodGet)
+	server.Router.HandleFunc(path+"/Users", server.scimAuthenticated(server.SCIMListUsers())).Methods(http.MethodGet)
+	server.Router.HandleFunc(path+"/Users", server.scimAuthenticated(server.SCIMCreateUser())).Methods(http.MethodPost)
+	server.Router.HandleFunc(path+"/Users/{id}", server.scimAuthenticated(server.SCIMGetUser())).Methods(http.MethodGet)
+	server.Router.HandleFunc(path+"/Users/{id}", server.scimAuthenticated(server.SCIMReplaceUser())).Methods(http.MethodPut)
+	server.Router.HandleFunc(path+"/Users/{id}", server.scimAuthenticated(server.SCIMPatchUser())).Methods(http.MethodPatch)
+	server.Router.HandleFunc(path+"/Users/{id}", server.scimAuthenticated(server.SCIMDeactivateUser())).Methods(http.MethodDelete)
+}
+
+// scimAuthenticated verifies the bearer token of the identity provider and sets the SCIM content type
+func (server *Server) scimAuthenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", scimContentType)
+		token, err := bearerToken(r)
+		if err != nil || subtle.ConstantTimeCompare([]byte(token), []byte(server.SCIMConfig.Token)) != 1 {
+			SCIMERROR(w, &scimError{status: http.StatusUnauthorized, err: errors.New("unauthorized, invalid SCIM token")})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// SCIMServiceProviderConfig returns the features of the SCIM server, the users are patched and filtered
+func (server *Server) SCIMServiceProviderConfig() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		supported := func(value bool) map[string]bool {
+			return map[string]bool{"supported": value}
+		}
+		JSON(w, http.StatusOK, map[string]any{
+			"schemas":        []string{SCIM_CONFIG_SCHEMA},
+			"patch":          supported(true),
+			"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+			"filter":         map[string]any{"supported": true, "maxResults": server.SCIMConfig.MaxResults},
+			"changePassword": supported(false),
+			"sort":           supported(false),
+			"etag":           supported(false),
+			"authenticationSchemes": []map[string]any{{
+				"type":        "oauthbearertoken",
+				"name":        "OAuth Bearer Token",
+				"description": "Authentication with the bearer token of SCIM_TOKEN",
+				"primary":     true,
+			}},
+		})
+	}
+}
+
+// SCIMResourceTypes returns the resource types of the SCIM server, only the users are provisioned
+func (server *Server) SCIMResourceTypes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		JSON(w, http.StatusOK, map[string]any{
+			"schemas":      []string{SCIM_LIST_SCHEMA},
+			"totalResults": 1,
+			"Resources": []map[string]any{{
+				"schemas":  []string{SCIM_TYPE_SCHEMA},
+				"id":       "User",
+				"name":     "User",
+				"endpoint": "/Users",
+				"schema":   SCIM_USER_SCHEMA,
+			}},
+		})
+	}
+}
+
+// SCIMListUsers returns a page of the users matching the filter, startIndex is 1-based and count is limited by
+// SCIM_MAX_RESULTS
+func (server *Server) SCIMListUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		query := r.URL.Query()
+		startIndex, err := scimInteger(query.Get("startIndex"), 1)
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		count, err := scimInteger(query.Get("count"), server.SCIMConfig.MaxResults)
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		startIndex, count = max(startIndex, 1), min(max(count, 0), server.SCIMConfig.MaxResults)
+		users := server.DB.WithContext(ctx).Model(&domain.User{})
+		users, err = scimWhere(users, query.Get("filter"))
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		list := scimList{Schemas: []string{SCIM_LIST_SCHEMA}, StartIndex: startIndex, Resources: []scimUser{}}
+		err = users.Count(&list.TotalResults).Error
+		if err != nil {
+			logger.Error("Error counting SCIM users", "error", err)
+			SCIMERROR(w, err)
+			return
+		}
+		var found []domain.User
+		if count > 0 {
+			err = users.Order("created_at, id").Offset(startIndex - 1).Limit(count).Find(&found).Error
+			if err != nil {
+				logger.Error("Error listing SCIM users", "error", err)
+				SCIMERROR(w, err)
+				return
+			}
+		}
+		for i := range found {
+			list.Resources = append(list.Resources, server.toSCIM(r, &found[i]))
+		}
+		list.ItemsPerPage = len(list.Resources)
+		JSON(w, http.StatusOK, list)
+	}
+}
+
+// SCIMGetUser returns the user of the path
+func (server *Server) SCIMGetUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := server.scimLoadUser(r)
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		JSON(w, http.StatusOK, server.toSCIM(r, user))
+	}
+}
+
+// SCIMCreateUser creates the user, it is pending until its first login. The externalId is the Keycloak subject of
+// the user and its ID, so that the first login finds it, users without externalId are not linked.
+func (server *Server) SCIMCreateUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		representation := scimUser{}
+		err := json.NewDecoder(r.Body).Decode(&representation)
+		if err != nil {
+			SCIMERROR(w, &scimError{status: http.StatusBadRequest, scimType: "invalidSyntax", err: err})
+			return
+		}
+		user := &domain.User{Base: domain.Base{ID: uuid.Must(uuid.NewV4())}, Pending: true}
+		user.SCIMID = user.ID.String()
+		err = representation.apply(user)
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		// The users are found at their login by the Keycloak subject, the users that cannot be found could not be
+		// deprovisioned
+		user.ID, err = uuid.FromString(user.ExternalID)
+		if err != nil {
+			SCIMERROR(w, &scimError{status: http.StatusBadRequest, scimType: "invalidValue", err: fmt.Errorf("externalId must be the Keycloak subject of the user, got %q", user.ExternalID)})
+			return
+		}
+		err = server.scimSubject(ctx, user.ID)
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		existing := &domain.User{}
+		err = server.DB.WithContext(ctx).Where("id = ?", user.ID).Limit(1).Find(existing).Error
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		if existing.SCIMID != "" {
+			SCIMERROR(w, &scimError{status: http.StatusConflict, scimType: "uniqueness", err: fmt.Errorf("externalId %s is already used", user.ExternalID)})
+			return
+		}
+		err = server.scimUnique(r, user)
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		if existing.ID != uuid.Nil {
+			// The users that logged in before their provisioning are taken over by the identity provider
+			user.Pending = false
+			user.CreatedAt = existing.CreatedAt
+			err = server.DB.WithContext(ctx).Model(user).Select(append(personalUserFields, "deactivated", "pending", "scim_id")).Updates(user).Error
+		} else {
+			err = server.insertUser(ctx, user)
+		}
+		if err != nil {
+			logger.Error("Error creating SCIM user", "error", err)
+			SCIMERROR(w, err)
+			return
+		}
+		logger.Info("User provisioned with SCIM", "userID", user.ID, "userName", user.PreferedUserName)
+		created := server.toSCIM(r, user)
+		w.Header().Set("Location", created.Meta.Location)
+		JSON(w, http.StatusCreated, created)
+	}
+}
+
+// SCIMReplaceUser replaces the attributes of the user with the ones of the representation
+func (server *Server) SCIMReplaceUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := server.scimLoadUser(r)
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		externalID := user.ExternalID
+		representation := scimUser{}
+		err = json.NewDecoder(r.Body).Decode(&representation)
+		if err != nil {
+			SCIMERROR(w, &scimError{status: http.StatusBadRequest, scimType: "invalidSyntax", err: err})
+			return
+		}
+		err = representation.apply(user)
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		server.scimSave(w, r, user, externalID)
+	}
+}
+
+// SCIMPatchUser applies the operations of the patch to the user, e.g. active set to false to deprovision it.
+// The paths are attributes of the user, emails[type eq "work"].value sets the email, and the operations
+// without path give the attributes in the value.
+func (server *Server) SCIMPatchUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := server.scimLoadUser(r)
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		externalID := user.ExternalID
+		patch := scimPatch{}
+		err = json.NewDecoder(r.Body).Decode(&patch)
+		if err != nil {
+			SCIMERROR(w, &scimError{status: http.StatusBadRequest, scimType: "invalidSyntax", err: err})
+			return
+		}
+		for _, operation := range patch.Operations {
+			err = scimPatchUser(user, strings.ToLower(operation.Op), operation.Path, operation.Value)
+			if err != nil {
+				SCIMERROR(w, err)
+				return
+			}
+		}
+		server.scimSave(w, r, user, externalID)
+	}
+}
+
+// SCIMDeactivateUser deactivates the user, it is kept for its objects and its data is erased with the erasure
+// of the user data
+func (server *Server) SCIMDeactivateUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, err := server.scimLoadUser(r)
+		if err != nil {
+			SCIMERROR(w, err)
+			return
+		}
+		err = server.DB.WithContext(ctx).Model(user).Update("deactivated", true).Error
+		if err != nil {
+			common.GetLogger(ctx).Error("Error deactivating SCIM user", "userID", user.ID, "error", err)
+			SCIMERROR(w, err)
+			return
+		}
+		common.GetLogger(ctx).Info("User deprovisioned with SCIM", "userID", user.ID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// scimLoadUser loads the user of the path by the ID given at the provisioning, or by the ID of the users that
+// are not provisioned with SCIM
+func (server *Server) scimLoadUser(r *http.Request) (*domain.User, error) {
+	uid, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, &scimError{status: http.StatusNotFound, err: fmt.Errorf("no user %s", mux.Vars(r)["id"])}
+	}
+	user := &domain.User{}
+	err = server.DB.WithContext(r.Context()).Where("scim_id = ?", uid.String()).Or("id = ?", uid).First(user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &scimError{status: http.StatusNotFound, err: fmt.Errorf("no user %s", uid)}
+	}
+	return user, err
+}
+
+// scimSave validates and stores the changed user and responds with it, the externalId that is the ID of the user
+// does not change
+func (server *Server) scimSave(w http.ResponseWriter, r *http.Request, user *domain.User, externalID string) {
+	ctx := r.Context()
+	if externalID == user.ID.String() && user.ExternalID != externalID {
+		SCIMERROR(w, &scimError{status: http.StatusBadRequest, scimType: "mutability", err: fmt.Errorf("externalId %s is the Keycloak subject of the user and cannot change", externalID)})
+		return
+	}
+	err := server.scimUnique(r, user)
+	if err != nil {
+		SCIMERROR(w, err)
+		return
+	}
+	err = server.DB.WithContext(ctx).Model(user).Select(append(personalUserFields, "deactivated")).Updates(user).Error
+	if err != nil {
+		common.GetLogger(ctx).Error("Error updating SCIM user", "userID", user.ID, "error", err)
+		SCIMERROR(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, server.toSCIM(r, user))
+}
+
+// scimSubject checks that the subject is a user of Keycloak when the Keycloak client is configured
+func (server *Server) scimSubject(ctx context.Context, subject uuid.UUID) error {
+	if server.keycloakClient == nil {
+		return nil
+	}
+	_, err := server.keycloakClient.GetUser(ctx, subject)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidValue", err: fmt.Errorf("externalId %s is not a Keycloak user", subject)}
+	}
+	if err != nil {
+		return &scimError{status: authenticationStatus(err), err: fmt.Errorf("cannot read Keycloak user %s: %w", subject, err)}
+	}
+	return nil
+}
+
+// scimUnique checks that no other user has the username, the usernames are compared case-insensitively
+func (server *Server) scimUnique(r *http.Request, user *domain.User) error {
+	var count int64
+	err := server.DB.WithContext(r.Context()).Model(&domain.User{}).
+		Where("lower(prefered_user_name) = lower(?) AND id <> ?", user.PreferedUserName, user.ID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return &scimError{status: http.StatusConflict, scimType: "uniqueness", err: fmt.Errorf("userName %s is already used", user.PreferedUserName)}
+	}
+	return nil
+}
+
+// toSCIM returns the SCIM representation of the user, located on the host of the request
+func (server *Server) toSCIM(r *http.Request, user *domain.User) scimUser {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	active := !user.Deactivated
+	id := user.SCIMID
+	if id == "" {
+		id = user.ID.String()
+	}
+	representation := scimUser{
+		Schemas:    []string{SCIM_USER_SCHEMA},
+		ID:         id,
+		ExternalID: user.ExternalID,
+		UserName:   user.PreferedUserName,
+		Active:     &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     fmt.Sprintf("%s://%s/%s/scim/v2/Users/%s", scheme, r.Host, server.ServerConfig.APIPath, id),
+		},
+	}
+	if user.GivenName != "" || user.FamilyName != "" {
+		representation.Name = &scimName{GivenName: user.GivenName, FamilyName: user.FamilyName}
+	}
+	if user.Email != "" {
+		representation.Emails = []scimEmail{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	return representation
+}
+
+// apply replaces the attributes of the user with the ones of the representation, the users are active unless
+// active is false
+func (representation *scimUser) apply(user *domain.User) error {
+	if strings.TrimSpace(representation.UserName) == "" {
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidValue", err: errors.New("userName is required")}
+	}
+	user.PreferedUserName = representation.UserName
+	user.ExternalID = representation.ExternalID
+	user.GivenName, user.FamilyName = "", ""
+	if representation.Name != nil {
+		user.GivenName, user.FamilyName = representation.Name.GivenName, representation.Name.FamilyName
+	}
+	user.Email = ""
+	for i, email := range representation.Emails {
+		if i == 0 || email.Primary {
+			user.Email = email.Value
+		}
+	}
+	user.Deactivated = representation.Active != nil && !*representation.Active
+	return nil
+}
+
+// scimPatchUser applies the operation of a patch to the user
+func scimPatchUser(user *domain.User, op, path string, value json.RawMessage) error {
+	if op != "add" && op != "replace" && op != "remove" {
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidSyntax", err: fmt.Errorf("unsupported patch operation %q", op)}
+	}
+	if path == "" {
+		if op == "remove" {
+			return &scimError{status: http.StatusBadRequest, scimType: "noTarget", err: errors.New("remove requires a path")}
+		}
+		attributes := map[string]json.RawMessage{}
+		err := json.Unmarshal(value, &attributes)
+		if err != nil {
+			return &scimError{status: http.StatusBadRequest, scimType: "invalidValue", err: err}
+		}
+		for name, attribute := range attributes {
+			if name == "name" {
+				names := map[string]json.RawMessage{}
+				err = json.Unmarshal(attribute, &names)
+				for subName, subAttribute := range names {
+					if err == nil {
+						err = scimPatchUser(user, op, "name."+subName, subAttribute)
+					}
+				}
+			} else {
+				err = scimPatchUser(user, op, name, attribute)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var text string
+	if op != "remove" {
+		err := scimValue(path, value, &text)
+		if err != nil {
+			return err
+		}
+	}
+	switch attribute := strings.ToLower(path); {
+	case attribute == "active":
+		active := op == "remove"
+		if !active {
+			var err error
+			active, err = strconv.ParseBool(text)
+			if err != nil {
+				return &scimError{status: http.StatusBadRequest, scimType: "invalidValue", err: fmt.Errorf("active: %w", err)}
+			}
+		}
+		user.Deactivated = !active
+	case attribute == "username":
+		if text == "" {
+			return &scimError{status: http.StatusBadRequest, scimType: "mutability", err: errors.New("userName is required")}
+		}
+		user.PreferedUserName = text
+	case attribute == "externalid":
+		user.ExternalID = text
+	case attribute == "name.givenname":
+		user.GivenName = text
+	case attribute == "name.familyname":
+		user.FamilyName = text
+	case attribute == "emails" || strings.HasPrefix(attribute, "emails[") || attribute == "emails.value":
+		user.Email = text
+	default:
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidPath", err: fmt.Errorf("unsupported patch path %q", path)}
+	}
+	return nil
+}
+
+// scimValue reads the value of a patch as text, the booleans are formatted and the emails give their primary or
+// first value
+func scimValue(path string, value json.RawMessage, text *string) error {
+	var decoded any
+	err := json.Unmarshal(value, &decoded)
+	if err != nil {
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidValue", err: fmt.Errorf("%s: %w", path, err)}
+	}
+	switch decoded := decoded.(type) {
+	case string:
+		*text = decoded
+	case bool:
+		*text = strconv.FormatBool(decoded)
+	case []any:
+		for i, item := range decoded {
+			email, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			if address, ok := email["value"].(string); ok && (i == 0 || email["primary"] == true) {
+				*text = address
+			}
+		}
+	case nil:
+		*text = ""
+	default:
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidValue", err: fmt.Errorf("%s: unsupported value %s", path, value)}
+	}
+	return nil
+}
+
+// scimWhere adds the filter of a list to the query, the comparisons with eq of the filterable attributes are
+// supported, joined with and
+func scimWhere(query *gorm.DB, filter string) (*gorm.DB, error) {
+	for rest := filter; strings.TrimSpace(rest) != ""; {
+		match := scimFilter.FindStringSubmatch(rest)
+		if match == nil {
+			return nil, &scimError{status: http.StatusBadRequest, scimType: "invalidFilter", err: fmt.Errorf("unsupported filter %q", filter)}
+		}
+		rest = rest[len(match[0]):]
+		attribute, value := strings.ToLower(match[1]), match[2]
+		if attribute == "id" {
+			text, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, &scimError{status: http.StatusBadRequest, scimType: "invalidFilter", err: errors.New("value of id must be a string")}
+			}
+			query = query.Where("scim_id = ? OR id = ?", text, text)
+			continue
+		}
+		column, ok := scimColumns[attribute]
+		if !ok {
+			return nil, &scimError{status: http.StatusBadRequest, scimType: "invalidFilter", err: fmt.Errorf("unsupported filter attribute %s", match[1])}
+		}
+		if column == "deactivated" {
+			active, err := strconv.ParseBool(strings.Trim(value, `"`))
+			if err != nil {
+				return nil, &scimError{status: http.StatusBadRequest, scimType: "invalidFilter", err: err}
+			}
+			query = query.Where("deactivated = ?", !active)
+			continue
+		}
+		text, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, &scimError{status: http.StatusBadRequest, scimType: "invalidFilter", err: fmt.Errorf("value of %s must be a string", match[1])}
+		}
+		// The usernames and the emails are case-insensitive
+		if column == "prefered_user_name" || column == "email" {
+			query = query.Where(fmt.Sprintf("lower(%s) = lower(?)", column), text)
+			continue
+		}
+		query = query.Where(fmt.Sprintf("%s = ?", column), text)
+	}
+	return query, nil
+}
+
+// scimInteger parses the integer parameter of a list, the default is returned without it
+func scimInteger(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &scimError{status: http.StatusBadRequest, scimType: "invalidValue", err: fmt.Errorf("invalid integer %q", value)}
+	}
+	return number, nil
+}
+
+// SCIMERROR responds with the error of RFC 7644, the errors other than the SCIM ones are internal
+func SCIMERROR(w http.ResponseWriter, err error) {
+	var scimErr *scimError
+	if !errors.As(err, &scimErr) {
+		scimErr = &scimError{status: http.StatusInternalServerError, err: err}
+	}
+	JSON(w, scimErr.status, struct {
+		Schemas  []string `json:"schemas"`
+		Status   string   `json:"status"`
+		ScimType string   `json:"scimType,omitempty"`
+		Detail   string   `json:"detail"`
+	}{
+		Schemas:  []string{SCIM_ERROR_SCHEMA},
+		Status:   strconv.Itoa(scimErr.status),
+		ScimType: scimErr.scimType,
+		Detail:   scimErr.Error(),
+	})
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/search"
+)
+
+// Search queries the search index across the searchable resources the caller can read.
+// Objects of owned resources are limited to the caller's own unless the caller has the global permission.
+func (server *Server) Search() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		query := r.URL.Query()
+		text := strings.TrimSpace(query.Get("q"))
+		if text == "" {
+			ERROR(w, http.StatusBadRequest, fmt.Errorf("missing search query parameter q"))
+			return
+		}
+		dbScopes := common.NewDBScopesFromRequest(r, false)
+		err := server.checkOffset(dbScopes.Page, dbScopes.PageSize)
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		permissions := getPermissions(r)
+
+		names := server.SearchConfig.Resources
+		if query.Get("resource") != "" {
+			names = strings.Split(query.Get("resource"), ",")
+		}
+		var scopes []search.Scope
+		for _, name := range names {
+			resource, ok := server.Resources.Resources[name]
+			if !ok || !server.SearchIndexer.Searchable(name) {
+				ERROR(w, http.StatusBadRequest, fmt.Errorf("resource %s is not searchable", name))
+				return
+			}
+			if !permissions.Can(resource.Name, READ) {
+				continue
+			}
+			scope := search.Scope{Resource: resource.Name}
+			if !resource.IsGlobal && !permissions.CanGlobal(resource.Name) {
+				scope.Owner = &dbScopes.User.ID
+			}
+			scopes = append(scopes, scope)
+		}
+
+		result, err := server.SearchProvider.Query(ctx, text, scopes, dbScopes.Page, dbScopes.PageSize)
+		if err != nil {
+			logger.Error("Error querying search index", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		for i, hit := range result.Data {
+			result.Data[i].Object = server.Resources.Resources[hit.Resource].Redact(hit.Object, permissions)
+		}
+		logger.Debug("Search completed", "query", text, "count", result.Count)
+		JSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dzahariev/respite/common"
+)
+
+// sensitive is a Wrapper for the operations of the resource routes, the operations of REPLAY_OPERATIONS are signed
+// and the ones of STEPUP_OPERATIONS require a stronger authentication. The operations are passed to the request
+// hooks, also when they are rejected.
+func (server *Server) sensitive(resource common.Resource, operation string, next http.HandlerFunc) http.HandlerFunc {
+	if designated(server.StepUpConfig.Operations, resource, operation) {
+		next = server.StepUp(next)
+	}
+	if designated(server.ReplayConfig.Operations, resource, operation) {
+		next = server.ReplayProtected(next)
+	}
+	return server.Instrumented(resource.Name, operation, next)
+}
+
+// checkSensitive checks the operations of REPLAY_OPERATIONS and STEPUP_OPERATIONS outside the resource routes,
+// e.g. in the batches, GraphQL and gRPC. Their requests are not signed per operation, so the signed operations are
+// refused and only served by the resource routes.
+func (server *Server) checkSensitive(ctx context.Context, resource common.Resource, operation string) error {
+	if designated(server.ReplayConfig.Operations, resource, operation) {
+		common.GetLogger(ctx).Warn("Unauthorized request, signed operation outside its route", "resource", resource.Name, "operation", operation)
+		return fmt.Errorf("%w: %s of %s must be signed and is only served by its route", ErrReplayedRequest, operation, resource.Name)
+	}
+	return server.checkStepUp(ctx, resource, operation)
+}
+
+// designated checks if the operation of the resource is one of the operations, as the operation of all resources
+// or of the resource
+func designated(operations []string, resource common.Resource, operation string) bool {
+	return slices.Contains(operations, operation) || slices.Contains(operations, resource.Name+"."+operation)
+}
+
+// validateSensitiveOperations reports the operations of REPLAY_OPERATIONS and STEPUP_OPERATIONS of resources that
+// are not registered
+func (server *Server) validateSensitiveOperations() error {
+	var problems []error
+	check := func(variable string, operations []string) {
+		for _, name := range operations {
+			resourceName, _, ok := strings.Cut(name, ".")
+			if _, registered := server.Resources.Resources[resourceName]; ok && !registered {
+				problems = append(problems, fmt.Errorf("%s: unknown resource %s", variable, resourceName))
+			}
+		}
+	}
+	check("REPLAY_OPERATIONS", server.ReplayConfig.Operations)
+	check("STEPUP_OPERATIONS", server.StepUpConfig.Operations)
+	err := errors.Join(problems...)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
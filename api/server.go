@@ -2,18 +2,56 @@ package api
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/dzahariev/respite/alerts"
+	"github.com/dzahariev/respite/audit"
 	"github.com/dzahariev/respite/auth"
+	"github.com/dzahariev/respite/breaker"
+	"github.com/dzahariev/respite/cache"
 	"github.com/dzahariev/respite/cfg"
 	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/coordination"
 	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/events"
+	"github.com/dzahariev/respite/flags"
+	"github.com/dzahariev/respite/health"
+	"github.com/dzahariev/respite/jobs"
+	"github.com/dzahariev/respite/ldap"
+	"github.com/dzahariev/respite/memory"
+	"github.com/dzahariev/respite/metering"
+	"github.com/dzahariev/respite/metrics"
+	"github.com/dzahariev/respite/notify"
+	"github.com/dzahariev/respite/outbound"
+	"github.com/dzahariev/respite/presence"
+	"github.com/dzahariev/respite/rbac"
+	"github.com/dzahariev/respite/scan"
+	"github.com/dzahariev/respite/scheduler"
+	"github.com/dzahariev/respite/search"
+	"github.com/dzahariev/respite/storage"
+	"github.com/dzahariev/respite/tenants"
+	"github.com/dzahariev/respite/tracing"
+	"github.com/dzahariev/respite/vault"
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"google.golang.org/grpc"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -25,40 +63,1066 @@ const (
 
 // Server represent current API server
 type Server struct {
-	ServerConfig      cfg.Server
-	DB                *gorm.DB
-	Router            *mux.Router
-	AuthClient        auth.Client
-	Resources         *common.Resources
-	RoleToPermissions map[string][]string
+	ServerConfig          cfg.Server
+	DB                    *gorm.DB
+	Router                *mux.Router
+	AuthClient            auth.Client
+	Exchanger             auth.TokenExchanger
+	Resources             *common.Resources
+	RoleToPermissions     map[string][]string
+	Publisher             events.Publisher
+	OutboxConfig          cfg.Outbox
+	Outbox                *events.Outbox
+	SubscriptionsConfig   cfg.Subscriptions
+	Broker                *events.Broker
+	Presence              *presence.Tracker
+	SchedulerConfig       cfg.Scheduler
+	CoordinationConfig    cfg.Coordination
+	Instances             *coordination.Registry
+	Scheduler             *scheduler.Scheduler
+	StorageConfig         cfg.Storage
+	Storage               storage.Storage
+	Scanner               scan.Scanner
+	CacheConfig           cfg.Cache
+	Cache                 cache.Cache
+	ResponseCache         cache.Cache
+	SearchConfig          cfg.Search
+	SearchProvider        search.Provider
+	SearchIndexer         *search.Indexer
+	FlagsConfig           cfg.Flags
+	Flags                 *flags.Flags
+	PermissionsConfig     cfg.Permissions
+	Permissions           *rbac.Store
+	IdentitySyncConfig    cfg.IdentitySync
+	SCIMConfig            cfg.SCIM
+	LDAPConfig            cfg.LDAP
+	LDAP                  *ldap.Client
+	FGAConfig             cfg.FGA
+	ManifestConfig        cfg.Manifest
+	Authorizer            common.Authorizer
+	TenantsConfig         cfg.Tenants
+	Tenants               *tenants.Store
+	AuditConfig           cfg.Audit
+	Auditor               *audit.Auditor
+	AlertsConfig          cfg.Alerts
+	Alerts                *alerts.Monitor
+	Repository            common.Repository
+	HealthConfig          cfg.Health
+	HealthChecker         *health.Checker
+	OutboundConfig        cfg.Outbound
+	QuotasConfig          cfg.Quotas
+	MeteringConfig        cfg.Metering
+	ConsentConfig         cfg.Consent
+	ReplayConfig          cfg.Replay
+	StepUpConfig          cfg.StepUp
+	CSPConfig             cfg.CSP
+	EditLocksConfig       cfg.EditLocks
+	EditLocks             *common.EditLocks
+	NotificationsConfig   cfg.Notifications
+	Inbox                 *common.Inbox
+	ViewsConfig           cfg.Views
+	ExportSchedulesConfig cfg.ExportSchedules
+	CountersConfig        cfg.Counters
+	FeaturesConfig        cfg.Features
+	Notifier              notify.Notifier
+	nonces                cache.Cache
+	Meter                 *metering.Meter
+	RequestMeter          *metering.RequestMeter
+	HTTPClient            *http.Client
+	Breakers              *breaker.Registry
+	JobsConfig            cfg.Jobs
+	Jobs                  *jobs.Runner
+	Origin                domain.Origin
+	MetricsConfig         cfg.Metrics
+	Metrics               *metrics.Metrics
+	TracingConfig         cfg.Tracing
+	Tracing               *tracing.Tracing
+	VaultConfig           cfg.Vault
+	Vault                 *vault.Client
+	Plugins               []Plugin
+	Webhooks              []InboundWebhook
+	Tenant                func(user *domain.User) string
+	keycloakClient        *auth.KeycloakClient
+	revocations           cache.Cache
+	ldapGroups            cache.Cache
+	identityCursor        time.Time
+	tenantProvisioners    []tenantProvisioner
+	logConfig             cfg.Logger
+	dbConfig              cfg.DataBase
+	dbCredentials         atomic.Value
+	dbConnConfig          *pgx.ConnConfig
+	dbFailover            *dbFailover
+	dbFailoverInterval    time.Duration
+	credentialsInterval   time.Duration
+	vaultCredentials      *vault.Credentials
+	BootstrapConfig       cfg.Bootstrap
+	bootstrapMapped       bool
+	router                atomic.Pointer[mux.Router]
+	registryMutex         sync.Mutex
+	fakeData              int
+	devMode               bool
+	startupTasks          []startupTask
+	warmUpTasks           []startupTask
+	beforeRequest         []RequestHook
+	afterRequest          []RequestHook
+	responseHooks         []ResponseHook
+	notificationRules     []NotificationRule
+	queries               []Query
+	manifest              manifestState
+	// starting is set while the startup tasks of SERVER_GATED_STARTUP run
+	starting atomic.Bool
+	// warming is set until the warm-up tasks complete
+	warming atomic.Bool
+	// draining is set from the termination signal, the readiness fails while the requests in flight are served
+	draining atomic.Bool
+	// inFlight is the number of the requests being served
+	inFlight atomic.Int64
+	// hijacked is the number of the open hijacked connections, e.g. the WebSockets of the subscriptions
+	hijacked atomic.Int64
+	// startupReport is the timing of the stages of the startup
+	startupReport StartupReport
+	startupMutex  sync.Mutex
 }
 
-func NewServer(serverConfig cfg.Server, logConfig cfg.Logger, dbConfig cfg.DataBase, modelObjects []domain.Object, authClient auth.Client, roleToPermissions map[string][]string) (*Server, error) {
+// Option is used to configure optional server components
+type Option func(*Server)
+
+// WithPublisher sets the backend used to publish mutation events
+func WithPublisher(publisher events.Publisher) Option {
+	return func(server *Server) {
+		server.Publisher = publisher
+	}
+}
+
+// WithOutbox enables the transactional outbox, events are relayed to the configured publisher
+func WithOutbox(outboxConfig cfg.Outbox) Option {
+	return func(server *Server) {
+		server.OutboxConfig = outboxConfig
+	}
+}
+
+// WithSubscriptions enables the WebSocket endpoint streaming change notifications
+func WithSubscriptions(subscriptionsConfig cfg.Subscriptions) Option {
+	return func(server *Server) {
+		server.SubscriptionsConfig = subscriptionsConfig
+	}
+}
+
+// WithScheduler configures the scheduler running tasks registered on Server.Scheduler
+func WithScheduler(schedulerConfig cfg.Scheduler) Option {
+	return func(server *Server) {
+		server.SchedulerConfig = schedulerConfig
+	}
+}
+
+// WithStorage enables file attachments kept in the configured storage backend
+func WithStorage(storageConfig cfg.Storage) Option {
+	return func(server *Server) {
+		server.StorageConfig = storageConfig
+	}
+}
+
+// WithCache enables the token introspection cache, response cache, rate limiter and idempotency keys
+func WithCache(cacheConfig cfg.Cache) Option {
+	return func(server *Server) {
+		server.CacheConfig = cacheConfig
+	}
+}
+
+// WithSearch indexes the configured resources with the search provider and enables the search endpoint
+func WithSearch(searchConfig cfg.Search) Option {
+	return func(server *Server) {
+		server.SearchConfig = searchConfig
+	}
+}
+
+// WithFlags enables feature flags defined in the configuration and optionally in the database
+func WithFlags(flagsConfig cfg.Flags) Option {
+	return func(server *Server) {
+		server.FlagsConfig = flagsConfig
+	}
+}
+
+// WithPermissions keeps grants of permissions to roles in the database, they are added to the role mapping
+// of the application and are managed with the admin routes
+func WithPermissions(permissionsConfig cfg.Permissions) Option {
+	return func(server *Server) {
+		server.PermissionsConfig = permissionsConfig
+	}
+}
+
+// WithTenants keeps overrides of the limits and the feature flags per tenant in the database, they apply to the
+// users of the tenants of WithTenant and are managed with the admin routes
+func WithTenants(tenantsConfig cfg.Tenants) Option {
+	return func(server *Server) {
+		server.TenantsConfig = tenantsConfig
+	}
+}
+
+// WithAudit records all mutations as audit entries in the configured sinks
+func WithAudit(auditConfig cfg.Audit) Option {
+	return func(server *Server) {
+		server.AuditConfig = auditConfig
+	}
+}
+
+// WithAlerts posts operational alerts to the configured Slack or generic webhook
+func WithAlerts(alertsConfig cfg.Alerts) Option {
+	return func(server *Server) {
+		server.AlertsConfig = alertsConfig
+	}
+}
+
+// WithDB uses the given database connection instead of connecting to PostgreSQL with the database configuration,
+// e.g. for tests. Database credentials are not rotated.
+func WithDB(db *gorm.DB) Option {
+	return func(server *Server) {
+		server.DB = db
+	}
+}
+
+// WithRepository keeps the resources in the given repository instead of the database
+func WithRepository(repository common.Repository) Option {
+	return func(server *Server) {
+		server.Repository = repository
+	}
+}
+
+// WithTenant sets the tenant of the users, it is set as app.tenant_id for the row-level security policies
+func WithTenant(tenant func(user *domain.User) string) Option {
+	return func(server *Server) {
+		server.Tenant = tenant
+	}
+}
+
+// WithFakeData creates count random instances of each resource when the server is started, e.g. from the --seed-fake
+// flag registered by fake.SeedFlag
+func WithFakeData(count int) Option {
+	return func(server *Server) {
+		server.fakeData = count
+	}
+}
+
+// WithDevMode authenticates all requests, also without token, as the development user with all roles, and runs the
+// scheduled tasks without leader election. It must not be used in production.
+func WithDevMode() Option {
+	return func(server *Server) {
+		server.devMode = true
+	}
+}
+
+// WithBootstrap grants the configured admin user global permissions on all resources
+func WithBootstrap(bootstrapConfig cfg.Bootstrap) Option {
+	return func(server *Server) {
+		server.BootstrapConfig = bootstrapConfig
+	}
+}
+
+// WithHealth configures when the database unavailability fails the readiness and the liveness checks
+func WithHealth(healthConfig cfg.Health) Option {
+	return func(server *Server) {
+		server.HealthConfig = healthConfig
+	}
+}
+
+// WithOutbound configures the HTTP client of the integrations, its timeout, retries and service token
+func WithOutbound(outboundConfig cfg.Outbound) Option {
+	return func(server *Server) {
+		server.OutboundConfig = outboundConfig
+	}
+}
+
+// WithQuotas limits the objects that every user or tenant may create per resource
+func WithQuotas(quotasConfig cfg.Quotas) Option {
+	return func(server *Server) {
+		server.QuotasConfig = quotasConfig
+	}
+}
+
+// WithMetering keeps the storage usage of the users per resource and with METERING_REQUESTS their requests,
+// reported with the admin routes
+func WithMetering(meteringConfig cfg.Metering) Option {
+	return func(server *Server) {
+		server.MeteringConfig = meteringConfig
+	}
+}
+
+// WithConsent requires the users to accept the current versions of the documents before mutations
+func WithConsent(consentConfig cfg.Consent) Option {
+	return func(server *Server) {
+		server.ConsentConfig = consentConfig
+	}
+}
+
+// WithJobs enables background jobs, including the asynchronous exports and imports of resources
+func WithJobs(jobsConfig cfg.Jobs) Option {
+	return func(server *Server) {
+		server.JobsConfig = jobsConfig
+	}
+}
+
+// WithOrigin stamps created objects and emitted events with the region and labels of the deployment
+func WithOrigin(originConfig cfg.Origin) Option {
+	return func(server *Server) {
+		server.Origin = domain.Origin{Region: originConfig.Region, Labels: originConfig.Labels}
+	}
+}
+
+// WithMetrics exports Prometheus metrics, including the database pool statistics and query metrics per resource
+func WithMetrics(metricsConfig cfg.Metrics) Option {
+	return func(server *Server) {
+		server.MetricsConfig = metricsConfig
+	}
+}
+
+// WithTracing exports OpenTelemetry traces of the requests, including the database and Keycloak calls
+func WithTracing(tracingConfig cfg.Tracing) Option {
+	return func(server *Server) {
+		server.TracingConfig = tracingConfig
+	}
+}
+
+// WithVault reads the database credentials, including dynamic ones with lease renewal, and the Keycloak
+// client secret from Vault at startup
+func WithVault(vaultConfig cfg.Vault) Option {
+	return func(server *Server) {
+		server.VaultConfig = vaultConfig
+	}
+}
+
+// NewServerFromConfig creates the server with the Keycloak client and all components of the configuration.
+// Components that are active with their defaults, storage, cache, audit and jobs, are enabled only when listed
+// in the configuration components. Given options are applied after the configured ones.
+func NewServerFromConfig(config *cfg.Config, modelObjects []domain.Object, roleToPermissions map[string][]string, options ...Option) (*Server, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	configured := []Option{
+		WithOutbox(config.Outbox),
+		WithSubscriptions(config.Subscriptions),
+		WithScheduler(config.Scheduler),
+		WithCoordination(config.Coordination),
+		WithSearch(config.Search),
+		WithFlags(config.Flags),
+		WithPermissions(config.Permissions),
+		WithIdentitySync(config.IdentitySync),
+		WithSCIM(config.SCIM),
+		WithLDAP(config.LDAP),
+		WithFGA(config.FGA),
+		WithManifest(config.Manifest),
+		WithTenants(config.Tenants),
+		WithAlerts(config.Alerts),
+		WithHealth(config.Health),
+		WithOutbound(config.Outbound),
+		WithQuotas(config.Quotas),
+		WithMetering(config.Metering),
+		WithConsent(config.Consent),
+		WithReplayProtection(config.Replay),
+		WithStepUp(config.StepUp),
+		WithCSP(config.CSP),
+		WithEditLocks(config.EditLocks),
+		WithNotifications(config.Notifications),
+		WithViews(config.Views),
+		WithExportSchedules(config.ExportSchedules),
+		WithCounters(config.Counters),
+		WithFeatures(config.Features),
+		WithMetrics(config.Metrics),
+		WithTracing(config.Tracing),
+		WithOrigin(config.Origin),
+		WithBootstrap(config.Bootstrap),
+		WithVault(config.Vault),
+	}
+	for _, component := range config.Components {
+		switch strings.ToLower(strings.TrimSpace(component)) {
+		case "storage":
+			configured = append(configured, WithStorage(config.Storage))
+		case "cache":
+			configured = append(configured, WithCache(config.Cache))
+		case "audit":
+			configured = append(configured, WithAudit(config.Audit))
+		case "jobs":
+			configured = append(configured, WithJobs(config.Jobs))
+		default:
+			return nil, fmt.Errorf("unknown component %q", component)
+		}
+	}
+	if config.Email.From != "" {
+		notifier, err := notify.NewNotifier(config.Email)
+		if err != nil {
+			return nil, err
+		}
+		configured = append(configured, WithNotifier(notifier))
+	}
+	if config.AMQP.URL != "" {
+		publisher, err := events.NewAMQPPublisher(config.AMQP)
+		if err != nil {
+			return nil, err
+		}
+		publisher.Encoder = events.NewEncoder(config.Events)
+		configured = append(configured, WithPublisher(publisher))
+	}
+	return NewServer(config.Server, config.Logger, config.DataBase, modelObjects, auth.NewClient(config.Keycloak), roleToPermissions, append(configured, options...)...)
+}
+
+func NewServer(serverConfig cfg.Server, logConfig cfg.Logger, dbConfig cfg.DataBase, modelObjects []domain.Object, authClient auth.Client, roleToPermissions map[string][]string, options ...Option) (*Server, error) {
 	// Initialise server instance
-	server := &Server{}
+	server := &Server{
+		Publisher: events.NoopPublisher{},
+	}
 	// Keep configuration
 	server.ServerConfig = serverConfig
 	// Initialise logger
 	server.initLogger(logConfig)
+	// Initialise the components in stages, every stage needs the components of the previous ones
+	err := server.runStages(
+		startupStage{name: STAGE_CONFIG, run: func() error {
+			return server.configure(logConfig, &dbConfig, authClient, roleToPermissions, options)
+		}},
+		startupStage{name: STAGE_DATABASE, needs: []string{STAGE_CONFIG}, run: func() error {
+			return server.initDatabase(dbConfig)
+		}},
+		startupStage{name: STAGE_OBSERVABILITY, needs: []string{STAGE_DATABASE}, run: func() error {
+			return server.initObservability(dbConfig)
+		}},
+		startupStage{name: STAGE_AUTH, needs: []string{STAGE_DATABASE, STAGE_OBSERVABILITY}, run: server.initAuth},
+		startupStage{name: STAGE_EVENTS, needs: []string{STAGE_DATABASE, STAGE_AUTH}, run: server.initEvents},
+		startupStage{name: STAGE_RESOURCES, needs: []string{STAGE_DATABASE, STAGE_EVENTS}, run: func() error {
+			return server.initResources(modelObjects)
+		}},
+		startupStage{name: STAGE_WORKERS, needs: []string{STAGE_RESOURCES}, run: server.initWorkers},
+		startupStage{name: STAGE_IDENTITY, needs: []string{STAGE_AUTH, STAGE_RESOURCES}, run: server.initIdentity},
+		startupStage{name: STAGE_ROUTES, needs: []string{STAGE_RESOURCES, STAGE_WORKERS, STAGE_IDENTITY}, run: server.initRouter},
+		startupStage{name: STAGE_MANIFEST, needs: []string{STAGE_ROUTES}, run: server.initManifest},
+	)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Server initialized", "port", server.ServerConfig.Port, "db", dbConfig.DatabaseName)
+	return server, nil
+}
+
+// configure applies the options and the plugins, replaces the auth client in the development mode, reads the
+// credentials from Vault and validates the configuration
+func (server *Server) configure(logConfig cfg.Logger, dbConfig *cfg.DataBase, authClient auth.Client, roleToPermissions map[string][]string, options []Option) error {
+	serverConfig := server.ServerConfig
 	// Initialise global configurations
 	common.MaxPageSize = serverConfig.MaxPageSize
 	common.MinPageSize = serverConfig.MinPageSize
-	// Store Auth Client
+	common.KeysetOffset = serverConfig.KeysetOffset
+	common.ParallelCount = serverConfig.ParallelCount
+	Translations.SetFallback(serverConfig.DefaultLocale)
+	domain.TimestampPrecision = serverConfig.TimestampPrecision
+	// Store Auth Client, the Keycloak client is kept to rotate its secret
 	server.AuthClient = authClient
+	server.keycloakClient, _ = authClient.(*auth.KeycloakClient)
 	// Initlaise roles to permissions mapping
 	server.RoleToPermissions = roleToPermissions
-	// Initialise DB connection
-	err := server.initDB(dbConfig)
+	// Apply optional components
+	for _, option := range options {
+		option(server)
+	}
+	// Configure the server with the plugins
+	err := server.configurePlugins()
 	if err != nil {
-		slog.Error("Failed to initialize database", "error", err)
-		return nil, err
+		slog.Error("Failed to configure plugins", "error", err)
+		return err
 	}
-	// Register all resources
-	server.initResourceFactory(modelObjects)
-	// Initialise router and register all routes
-	server.initRouter()
-	slog.Info("Server initialized", "port", server.ServerConfig.Port, "db", dbConfig.DatabaseName)
-	return server, nil
+	// Replace the auth client in the development mode
+	if server.devMode {
+		roles := make([]string, 0, len(roleToPermissions))
+		for role := range roleToPermissions {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		server.AuthClient = auth.NewDevClient(roles...)
+		server.keycloakClient = nil
+		slog.Warn("Development mode, requests are not authenticated", "user", auth.DevUserID, "roles", roles)
+	}
+	// Read credentials from Vault if configured
+	err = server.initVault(dbConfig)
+	if err != nil {
+		slog.Error("Failed to read credentials from Vault", "error", err)
+		return err
+	}
+	server.logConfig = logConfig
+	server.dbConfig = *dbConfig
+	// Keep resources in process memory if configured
+	if dbConfig.Backend == "memory" && server.Repository == nil {
+		server.Repository = memory.New()
+		slog.Warn("Resources are kept in memory and are lost on restart")
+	}
+	// Validate configuration of the server and the enabled components
+	err = server.validateConfig(logConfig, *dbConfig)
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		return err
+	}
+	return nil
+}
+
+// initDatabase connects to the database and registers its circuit breaker, query timeout and window count
+func (server *Server) initDatabase(dbConfig cfg.DataBase) error {
+	// Initialise DB connection unless it is given or the resources are kept in a repository
+	if server.DB == nil && server.Repository == nil {
+		err := server.initDB(dbConfig)
+		if err != nil {
+			slog.Error("Failed to initialize database", "error", err)
+			return err
+		}
+	}
+	// Lock the objects with advisory locks, CockroachDB relies on its serializable transactions instead
+	common.AdvisoryLocks = dbConfig.Backend != "cockroachdb"
+	// Enforce the ownership with row-level security if configured, the policies exist only in PostgreSQL
+	common.RowLevelSecurity = dbConfig.RowLevelSecurity && server.DB != nil && server.DB.Dialector.Name() == "postgres"
+	if dbConfig.RowLevelSecurity && !common.RowLevelSecurity {
+		slog.Warn("Row-level security requires PostgreSQL, the ownership is enforced by the queries")
+	}
+	// Initialise the circuit breakers of the dependencies, the breakers of the outbound hosts are created on use
+	server.Breakers = breaker.NewRegistry(server.OutboundConfig.BreakerFailures, server.OutboundConfig.BreakerTimeout)
+	if server.keycloakClient != nil && server.keycloakClient.Breaker != nil {
+		server.Breakers.Add(server.keycloakClient.Breaker)
+	}
+	if dbConfig.BreakerFailures > 0 && server.DB != nil {
+		databaseBreaker := breaker.New("database", dbConfig.BreakerFailures, dbConfig.BreakerTimeout)
+		err := common.RegisterBreaker(server.DB, databaseBreaker)
+		if err != nil {
+			slog.Error("Failed to register the database circuit breaker", "error", err)
+			return err
+		}
+		server.Breakers.Add(databaseBreaker)
+	}
+	// Run the transactions aborted by serialization failures again, e.g. the conflicting ones of CockroachDB
+	domain.TransactionRetries = dbConfig.TransactionRetries
+	// Bound the statements by the query timeout if configured
+	if dbConfig.QueryTimeout > 0 && server.DB != nil {
+		err := common.RegisterQueryTimeout(server.DB, dbConfig.QueryTimeout)
+		if err != nil {
+			slog.Error("Failed to register the query timeout", "error", err)
+			return err
+		}
+	}
+	// Count the lists in the query of their page if configured
+	common.WindowCount = false
+	if server.ServerConfig.WindowCount && server.DB != nil && server.DB.Dialector.Name() == "postgres" {
+		err := common.RegisterWindowCount(server.DB)
+		if err != nil {
+			slog.Error("Failed to register the window count", "error", err)
+			return err
+		}
+		common.WindowCount = true
+	}
+	return nil
+}
+
+// initObservability initialises the tracing, the metrics, the health checks and the operational alerts
+func (server *Server) initObservability(dbConfig cfg.DataBase) error {
+	var err error
+	// Initialise tracing if enabled, before the auth client is wrapped by the cache
+	if server.TracingConfig.Enabled {
+		server.Tracing, err = tracing.New(context.Background(), server.TracingConfig)
+		if err != nil {
+			slog.Error("Failed to initialize tracing", "error", err)
+			return err
+		}
+		if server.DB != nil {
+			err = tracing.InstrumentDB(server.DB, server.TracingConfig.DBStatements)
+			if err != nil {
+				slog.Error("Failed to initialize database tracing", "error", err)
+				return err
+			}
+		}
+		if server.keycloakClient != nil {
+			server.keycloakClient.WrapTransport(tracing.Transport)
+		}
+		slog.Info("Tracing initialized", "service", server.TracingConfig.ServiceName, "sampleRatio", server.TracingConfig.SampleRatio)
+	}
+	// Initialise metrics if enabled
+	if server.MetricsConfig.Enabled {
+		server.Metrics = metrics.New(server.MetricsConfig)
+		if server.DB != nil {
+			err = server.Metrics.InstrumentDB(server.DB, dbConfig.DatabaseName)
+			if err != nil {
+				slog.Error("Failed to initialize database metrics", "error", err)
+				return err
+			}
+		}
+		err = server.Metrics.RegisterBreakers(server.Breakers)
+		if err != nil {
+			slog.Error("Failed to initialize circuit breaker metrics", "error", err)
+			return err
+		}
+		err = server.Metrics.RegisterInFlight(server.inFlight.Load, server.hijacked.Load)
+		if err != nil {
+			slog.Error("Failed to initialize in-flight request metrics", "error", err)
+			return err
+		}
+	}
+	// Initialise database health checks, a repository is always available
+	ping := func(ctx context.Context) error { return nil }
+	if server.DB != nil {
+		sqlDB, err := server.DB.DB()
+		if err != nil {
+			return err
+		}
+		ping = sqlDB.PingContext
+	}
+	server.HealthChecker = health.New(server.HealthConfig, ping)
+	// Initialise operational alerts if configured
+	if server.AlertsConfig.WebhookURL != "" {
+		server.Alerts = alerts.NewMonitor(server.AlertsConfig)
+	}
+	return nil
+}
+
+// initAuth initialises the authorizer of the shared resources, the cache with the tokens of the auth client, the
+// exchange of the tokens, the HTTP client of the integrations and the nonces of the signed requests
+func (server *Server) initAuth() error {
+	// Initialise the authorizer of the shared resources if configured
+	err := server.initAuthorizer()
+	if err != nil {
+		slog.Error("Failed to initialize authorizer", "error", err)
+		return err
+	}
+	// Initialise cache if configured
+	if server.CacheConfig.Backend != "" {
+		server.Cache, err = cache.New(server.CacheConfig)
+		if err != nil {
+			slog.Error("Failed to initialize cache", "error", err)
+			return err
+		}
+		if server.CacheConfig.TokenTTL > 0 {
+			server.AuthClient = auth.NewCachedClient(server.AuthClient, server.Cache, server.CacheConfig.TokenTTL)
+		}
+		// Responses are kept in process memory on request, invalidations are then broadcasted to the other replicas
+		server.ResponseCache = server.Cache
+		if server.CacheConfig.ResponseLocal && server.CacheConfig.Backend != cache.MEMORY {
+			server.ResponseCache = cache.NewMemoryCache(server.CacheConfig.Prefix)
+		}
+		slog.Info("Cache initialized", "backend", server.CacheConfig.Backend, "localResponses", server.ResponseCache != server.Cache)
+	}
+	server.initExchanger()
+	server.initHTTPClient()
+	server.initReplay()
+	return nil
+}
+
+// initEvents initialises the consumers of the mutation events, the subscriptions, the search index, the audit log,
+// the metering and the plugins, then the feature flags and the outbox relaying the events
+func (server *Server) initEvents() error {
+	var err error
+	// Initialise subscriptions broker if enabled
+	if server.SubscriptionsConfig.Enabled {
+		server.Broker = events.NewBroker(server.SubscriptionsConfig.BufferSize)
+		server.Publisher = events.MultiPublisher{server.Publisher, server.Broker}
+		server.initPresence()
+	}
+	// Invalidate cached responses on mutations from all APIs, resources may set their own TTL
+	if server.ResponseCache != nil {
+		server.Publisher = events.MultiPublisher{server.Publisher, responseInvalidator{server: server}}
+	}
+	// Initialise search indexer if configured, the index follows all mutation events
+	if len(server.SearchConfig.Resources) > 0 {
+		server.SearchProvider, err = search.NewProvider(context.Background(), server.SearchConfig, server.DB)
+		if err != nil {
+			slog.Error("Failed to initialize search", "error", err)
+			return err
+		}
+		server.SearchIndexer = search.NewIndexer(server.SearchProvider, server.SearchConfig.Resources)
+		server.Publisher = events.MultiPublisher{server.Publisher, server.SearchIndexer}
+	}
+	// Initialise audit log if configured
+	if len(server.AuditConfig.Sinks) > 0 {
+		sinks, err := audit.NewSinks(server.AuditConfig, server.DB)
+		if err != nil {
+			slog.Error("Failed to initialize audit sinks", "error", err)
+			return err
+		}
+		server.Auditor = audit.NewAuditor(server.AuditConfig, sinks, server.redactPII)
+		server.Publisher = events.MultiPublisher{server.Publisher, server.Auditor}
+	}
+	// Initialise usage metering if enabled, the usage follows all mutation events
+	if server.MeteringConfig.Enabled {
+		server.Meter = metering.NewMeter(server.DB, server.measureUsage, server.usageTenant, server.sizedResources())
+		server.Publisher = events.MultiPublisher{server.Publisher, server.Meter}
+	}
+	if server.MeteringConfig.Requests {
+		server.RequestMeter = metering.NewRequestMeter(server.DB, server.MeteringConfig.RequestsBucket)
+	}
+	// Initialise the notification inbox if enabled, its rules follow the mutation events
+	server.initNotifications()
+	// Recount the counters updated from the mutation events, the resources are checked on the events
+	if server.Repository == nil {
+		server.Publisher = events.MultiPublisher{server.Publisher, counterPublisher{server: server}}
+	}
+	// Deliver the mutation events to the subscribers of the plugins
+	server.subscribePlugins()
+	// Initialise feature flags if configured
+	if server.FlagsConfig.Definitions != "" || server.FlagsConfig.Database {
+		server.Flags, err = flags.New(context.Background(), server.FlagsConfig, server.DB)
+		if err != nil {
+			slog.Error("Failed to initialize feature flags", "error", err)
+			return err
+		}
+	}
+	// Initialise outbox if enabled
+	if server.OutboxConfig.Enabled {
+		server.Outbox = events.NewOutbox(server.DB, server.Publisher, server.OutboxConfig)
+		server.Outbox.OnFailure = server.alertDeliveryFailure
+		server.Outbox.Elector = coordination.NewElector(server.DB, OUTBOX_LOCK, server.CoordinationConfig.LeaderRetry)
+		server.configureElector(server.Outbox.Elector)
+	}
+	return nil
+}
+
+// initResources initialises the file storage, registers the resources of the application, of the storage and of
+// the plugins, and loads the role permissions and the tenants
+func (server *Server) initResources(modelObjects []domain.Object) error {
+	var err error
+	// Initialise file storage if configured
+	if server.StorageConfig.Backend != "" {
+		server.Storage, err = storage.New(server.StorageConfig)
+		if err != nil {
+			slog.Error("Failed to initialize storage", "error", err)
+			return err
+		}
+		modelObjects = append(modelObjects, &domain.File{})
+		slog.Info("Storage initialized", "backend", server.StorageConfig.Backend)
+		server.Scanner, err = scan.New(server.StorageConfig)
+		if err != nil {
+			slog.Error("Failed to initialize virus scanner", "error", err)
+			return err
+		}
+		if server.Scanner != nil {
+			slog.Info("Virus scanner initialized", "backend", server.StorageConfig.ScanBackend)
+		}
+	}
+	// Register all resources, including the ones of the plugins
+	err = server.initResourceFactory(append(modelObjects, server.pluginResources()...))
+	if err != nil {
+		slog.Error("Failed to register resources", "error", err)
+		return err
+	}
+	err = server.validateResourceRateLimits()
+	if err != nil {
+		return err
+	}
+	err = server.validateSensitiveOperations()
+	if err != nil {
+		return err
+	}
+	err = server.validatePublicResources()
+	if err != nil {
+		return err
+	}
+	err = server.validateQuotas()
+	if err != nil {
+		return err
+	}
+	err = server.validateEditLocks()
+	if err != nil {
+		return err
+	}
+	err = server.validateCounters()
+	if err != nil {
+		return err
+	}
+	err = server.validateSQLViews()
+	if err != nil {
+		return err
+	}
+	err = server.validateQueries()
+	if err != nil {
+		return err
+	}
+	server.initEditLocks()
+	// Grant the bootstrap admin role all permissions if configured
+	server.initBootstrap()
+	// Initialise role permissions stored in the database if configured
+	if server.PermissionsConfig.Database {
+		server.Permissions, err = rbac.New(context.Background(), server.PermissionsConfig, server.DB, server.RoleToPermissions)
+		if err != nil {
+			slog.Error("Failed to initialize role permissions", "error", err)
+			return err
+		}
+	}
+	// Initialise the overrides of the tenants if configured
+	if server.TenantsConfig.Database {
+		if server.Tenant == nil {
+			return errors.New("invalid configuration:\nTENANTS_DATABASE: the overrides require the tenants of WithTenant")
+		}
+		server.Tenants, err = tenants.New(context.Background(), server.TenantsConfig, server.DB)
+		if err != nil {
+			slog.Error("Failed to initialize tenants", "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// initWorkers initialises the job runner, the scheduler and the registry of the instance
+func (server *Server) initWorkers() error {
+	// Initialise job runner if configured, exports and imports keep their data in the storage
+	if server.JobsConfig.Workers > 0 {
+		server.Jobs = jobs.NewRunner(server.DB, server.JobsConfig)
+		server.RegisterOperation(MUTATION, server.mutationOperation)
+		if server.Tenants != nil {
+			server.RegisterOperation(TENANT_PROVISIONING, server.tenantProvisioningOperation)
+		}
+		if server.Storage != nil {
+			server.Jobs.Register(EXPORT, server.exportJob)
+			server.Jobs.Register(IMPORT, server.importJob)
+			server.Jobs.Register(DATA_EXPORT, server.dataExportJob)
+			if server.Repository == nil {
+				server.Jobs.Register(BACKUP, server.backupJob)
+				server.Jobs.Register(RESTORE, server.restoreJob)
+			}
+		}
+	}
+	// Initialise scheduler, tasks are registered by the application
+	server.Scheduler = scheduler.New(server.SchedulerConfig, &scheduler.TaskContext{
+		DB:                server.DB,
+		Resources:         server.Resources,
+		NewRequestContext: server.taskRequestContext,
+	})
+	server.configureElector(server.Scheduler.Elector)
+	// Initialise the scheduled exports if configured
+	err := server.initExportSchedules()
+	if err != nil {
+		slog.Error("Failed to initialize export schedules", "error", err)
+		return err
+	}
+	// Schedule the reconciliation of the counters of the resources
+	err = server.initCounters()
+	if err != nil {
+		slog.Error("Failed to initialize counters", "error", err)
+		return err
+	}
+	// Schedule the refreshes of the materialized views
+	err = server.initSQLViews()
+	if err != nil {
+		slog.Error("Failed to initialize SQL views", "error", err)
+		return err
+	}
+	// Register the instance if configured
+	server.initInstances()
+	return nil
+}
+
+// initIdentity initialises the sync of the admin events of Keycloak and the LDAP groups of the users
+func (server *Server) initIdentity() error {
+	// Initialise the sync of the admin events of Keycloak if configured
+	err := server.initIdentitySync()
+	if err != nil {
+		slog.Error("Failed to initialize identity sync", "error", err)
+		return err
+	}
+	// Initialise the LDAP groups of the users if configured
+	server.initLDAP()
+	return nil
+}
+
+// initVault reads the database credentials and the Keycloak client secret from Vault
+func (server *Server) initVault(dbConfig *cfg.DataBase) error {
+	if server.VaultConfig.Address == "" {
+		return nil
+	}
+	err := server.VaultConfig.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	ctx := context.Background()
+	server.Vault, err = vault.New(ctx, server.VaultConfig)
+	if err != nil {
+		return err
+	}
+	if server.VaultConfig.ProvidesDatabase() {
+		server.vaultCredentials, err = server.Vault.DatabaseCredentials(ctx)
+		if err != nil {
+			return fmt.Errorf("cannot read database credentials: %w", err)
+		}
+		dbConfig.User = server.vaultCredentials.Username
+		dbConfig.Password = server.vaultCredentials.Password
+	}
+	if server.VaultConfig.KeycloakSecret != "" && server.keycloakClient != nil {
+		clientSecret, err := server.Vault.KVString(ctx, server.VaultConfig.KeycloakSecret, "client_secret")
+		if err != nil {
+			return fmt.Errorf("cannot read keycloak client secret: %w", err)
+		}
+		server.keycloakClient.SetClientSecret(clientSecret)
+	}
+	return nil
+}
+
+// validateConfig checks the configuration of the server and of the enabled components, all problems are reported at once
+func (server *Server) validateConfig(logConfig cfg.Logger, dbConfig cfg.DataBase) error {
+	validations := []error{
+		server.ServerConfig.Validate(),
+		logConfig.Validate(),
+		server.OutboxConfig.Validate(),
+		server.SubscriptionsConfig.Validate(),
+		server.SchedulerConfig.Validate(),
+		server.CoordinationConfig.Validate(),
+		server.SearchConfig.Validate(),
+		server.FlagsConfig.Validate(),
+		server.PermissionsConfig.Validate(),
+		server.IdentitySyncConfig.Validate(),
+		server.SCIMConfig.Validate(),
+		server.LDAPConfig.Validate(),
+		server.FGAConfig.Validate(),
+		server.ManifestConfig.Validate(),
+		server.TenantsConfig.Validate(),
+		server.AlertsConfig.Validate(),
+		server.HealthConfig.Validate(),
+		server.OutboundConfig.Validate(),
+		server.QuotasConfig.Validate(),
+		server.ConsentConfig.Validate(),
+		server.ReplayConfig.Validate(),
+		server.StepUpConfig.Validate(),
+		server.CSPConfig.Validate(),
+		server.EditLocksConfig.Validate(),
+		server.NotificationsConfig.Validate(),
+		server.ViewsConfig.Validate(),
+		server.ExportSchedulesConfig.Validate(),
+		server.CountersConfig.Validate(),
+		server.FeaturesConfig.Validate(),
+		server.MeteringConfig.Validate(),
+		server.BootstrapConfig.Validate(),
+		server.MetricsConfig.Validate(),
+		server.TracingConfig.Validate(),
+	}
+	if server.DB == nil && server.Repository == nil {
+		validations = append(validations, dbConfig.Validate())
+	}
+	if server.Repository != nil {
+		validations = append(validations, server.validateRepository())
+	}
+	if server.StorageConfig.Backend != "" {
+		validations = append(validations, server.StorageConfig.Validate())
+	}
+	if server.CacheConfig.Backend != "" {
+		validations = append(validations, server.CacheConfig.Validate())
+	}
+	if len(server.AuditConfig.Sinks) > 0 {
+		validations = append(validations, server.AuditConfig.Validate())
+	}
+	if server.JobsConfig.Workers > 0 {
+		validations = append(validations, server.JobsConfig.Validate())
+	}
+	err := errors.Join(validations...)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+// validateRepository reports the enabled components that keep their data in the database
+func (server *Server) validateRepository() error {
+	var problems []error
+	requiresDatabase := func(enabled bool, variable string) {
+		if enabled {
+			problems = append(problems, fmt.Errorf("%s: requires the postgres database backend", variable))
+		}
+	}
+	requiresDatabase(server.OutboxConfig.Enabled, "OUTBOX_ENABLED")
+	requiresDatabase(server.JobsConfig.Workers > 0, "JOBS_WORKERS")
+	requiresDatabase(slices.Contains(server.AuditConfig.Sinks, "database"), "AUDIT_SINKS")
+	requiresDatabase(server.FlagsConfig.Database, "FEATURE_FLAGS_DATABASE")
+	requiresDatabase(server.PermissionsConfig.Database, "PERMISSIONS_DATABASE")
+	requiresDatabase(server.TenantsConfig.Database, "TENANTS_DATABASE")
+	requiresDatabase(server.SCIMConfig.Token != "", "SCIM_TOKEN")
+	requiresDatabase(server.MeteringConfig.Enabled, "METERING_ENABLED")
+	requiresDatabase(server.MeteringConfig.Requests, "METERING_REQUESTS")
+	requiresDatabase(len(server.ConsentConfig.Documents) > 0, "CONSENT_DOCUMENTS")
+	requiresDatabase(server.CSPConfig.Store, "CSP_STORE")
+	requiresDatabase(len(server.EditLocksConfig.Resources) > 0, "EDIT_LOCK_RESOURCES")
+	requiresDatabase(server.NotificationsConfig.Enabled, "NOTIFICATIONS_ENABLED")
+	requiresDatabase(server.ViewsConfig.Enabled, "VIEWS_ENABLED")
+	requiresDatabase(server.ExportSchedulesConfig.Enabled, "EXPORT_SCHEDULES_ENABLED")
+	requiresDatabase(len(server.SearchConfig.Resources) > 0 && server.SearchConfig.Provider == "postgres", "SEARCH_PROVIDER")
+	requiresDatabase(server.ServerConfig.GraphQLEnabled, "SERVER_GRAPHQL_ENABLED")
+	return errors.Join(problems...)
+}
+
+// validateResourceRateLimits reports the rate limits and costs of resources that are not registered
+func (server *Server) validateResourceRateLimits() error {
+	var problems []error
+	for name := range server.CacheConfig.ResourceRateLimits {
+		if _, ok := server.Resources.Resources[name]; !ok && name != "*" {
+			problems = append(problems, fmt.Errorf("CACHE_RESOURCE_RATE_LIMITS: unknown resource %s", name))
+		}
+	}
+	for name := range server.CacheConfig.RequestCosts {
+		resourceName, _, ok := strings.Cut(name, ".")
+		if _, registered := server.Resources.Resources[resourceName]; ok && !registered {
+			problems = append(problems, fmt.Errorf("CACHE_REQUEST_COSTS: unknown resource %s", resourceName))
+		}
+	}
+	err := errors.Join(problems...)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+// initExchanger sets up the exchange of the tokens of the callers for the downstream APIs with the Keycloak
+// client, or with the development client in the development mode. The exchanged tokens are kept in the cache,
+// or in process memory without it.
+func (server *Server) initExchanger() {
+	authClient := server.AuthClient
+	if cached, ok := authClient.(*auth.CachedClient); ok {
+		authClient = cached.Client
+	}
+	var exchanger auth.TokenExchanger
+	if server.keycloakClient != nil {
+		exchanger = server.keycloakClient
+	} else if authExchanger, ok := authClient.(auth.TokenExchanger); ok {
+		exchanger = authExchanger
+	}
+	if exchanger == nil {
+		return
+	}
+	var tokenCache cache.Cache = server.Cache
+	if tokenCache == nil {
+		tokenCache = cache.NewMemoryCache(server.CacheConfig.Prefix)
+	}
+	server.Exchanger = auth.NewCachedExchanger(exchanger, tokenCache)
+}
+
+// initHTTPClient creates the HTTP client of the integrations, the requests carry the service token of the Keycloak
+// client when OUTBOUND_SERVICE_TOKEN is set
+func (server *Server) initHTTPClient() {
+	var tokens outbound.TokenSource
+	if server.OutboundConfig.ServiceToken && server.keycloakClient != nil {
+		tokens = outbound.TokenFunc(server.keycloakClient.ServiceToken)
+	}
+	server.HTTPClient = outbound.New(server.OutboundConfig, tokens)
+	if server.OutboundConfig.BreakerFailures > 0 {
+		server.HTTPClient.Transport.(*outbound.Transport).Breakers = server.Breakers
+	}
+}
+
+// validatePublicResources reports the public resources that are not registered or not global, the objects of
+// the users are never public. The anonymous reads are limited with the cache, which is then required.
+func (server *Server) validatePublicResources() error {
+	var problems []error
+	for _, name := range server.ServerConfig.PublicResources {
+		resource, ok := server.Resources.Resources[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Errorf("SERVER_PUBLIC_RESOURCES: unknown resource %s", name))
+		case !resource.IsGlobal:
+			problems = append(problems, fmt.Errorf("SERVER_PUBLIC_RESOURCES: resource %s is not global", name))
+		}
+	}
+	if len(server.ServerConfig.PublicResources) > 0 && server.Cache == nil {
+		problems = append(problems, errors.New("SERVER_PUBLIC_RESOURCES: the cache is required to limit the anonymous reads"))
+	}
+	err := errors.Join(problems...)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
 }
 
 func (server *Server) initLogger(logConfig cfg.Logger) {
@@ -68,6 +1132,8 @@ func (server *Server) initLogger(logConfig cfg.Logger) {
 		logLevel = slog.LevelDebug
 	case "info":
 		logLevel = slog.LevelInfo
+	case "warn":
+		logLevel = slog.LevelWarn
 	case "error":
 		logLevel = slog.LevelError
 	default:
@@ -83,10 +1149,58 @@ func (server *Server) initLogger(logConfig cfg.Logger) {
 	slog.Info("Logger initialized", "level", logConfig.Level, "format", logConfig.Format)
 }
 
+// initDB connects to the database, the credentials and the host of the failover are set on each new connection so
+// that they can be rotated. Pooled connections opened with previous credentials or to previous hosts are closed
+// when they are taken from the pool.
 func (server *Server) initDB(dbConfig cfg.DataBase) error {
-	DBURL := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.DatabaseName, dbConfig.Password)
-	var err error
-	server.DB, err = gorm.Open(postgres.Open(DBURL), &gorm.Config{})
+	DBURL := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable", dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.DatabaseName)
+	connConfig, err := pgx.ParseConfig(DBURL)
+	if err != nil {
+		return fmt.Errorf("invalid database configuration: %w", err)
+	}
+	// Sessions are in UTC, so that NOW() and timestamps without time zone do not depend on the server time zone
+	connConfig.RuntimeParams["timezone"] = "UTC"
+	// The statements prepared on a connection of the pooler are not there in the next transaction
+	if dbConfig.TransactionPooling {
+		connConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
+		connConfig.StatementCacheCapacity = 0
+		connConfig.DescriptionCacheCapacity = 0
+	}
+	server.dbConnConfig = connConfig
+	server.credentialsInterval = dbConfig.CredentialsCheckInterval
+	server.dbCredentials.Store(dbCredentials{user: dbConfig.User, password: dbConfig.Password})
+	if len(dbConfig.FailoverHosts) > 0 {
+		server.dbFailover, err = newDBFailover(dbConfig)
+		if err != nil {
+			return fmt.Errorf("invalid database configuration: %w", err)
+		}
+		server.dbFailoverInterval = dbConfig.FailoverCheckInterval
+		// Only the primary accepts the connections, a demoted host is left for the next one
+		connConfig.ValidateConnect = pgconn.ValidateConnectTargetSessionAttrsReadWrite
+	}
+	var connector driver.Connector = stdlib.GetConnector(*connConfig,
+		stdlib.OptionBeforeConnect(func(ctx context.Context, config *pgx.ConnConfig) error {
+			server.dbCredentials.Load().(dbCredentials).apply(config)
+			if server.dbFailover != nil {
+				server.dbFailover.apply(config)
+			}
+			return nil
+		}),
+		stdlib.OptionResetSession(func(ctx context.Context, conn *pgx.Conn) error {
+			if !server.dbCredentials.Load().(dbCredentials).current(conn.Config()) {
+				return driver.ErrBadConn
+			}
+			if server.dbFailover != nil && !server.dbFailover.serves(conn.Config()) {
+				return driver.ErrBadConn
+			}
+			return nil
+		}),
+	)
+	if server.dbFailover != nil {
+		connector = failoverConnector{Connector: connector, server: server}
+	}
+	sqlDB := sql.OpenDB(connector)
+	server.DB, err = gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{NowFunc: domain.Now})
 	if err != nil {
 		slog.Error("Failed to connect to database", "error", err)
 		return fmt.Errorf("cannot connect to database: %w", err)
@@ -96,38 +1210,238 @@ func (server *Server) initDB(dbConfig cfg.DataBase) error {
 }
 
 // initResourceFactory is used to register all resources
-func (server *Server) initResourceFactory(modelObjects []domain.Object) {
+func (server *Server) initResourceFactory(modelObjects []domain.Object) error {
 	server.Resources = &common.Resources{Resources: map[string]common.Resource{}}
 	// Register user resource
-	server.Resources.Register(&domain.User{})
+	err := server.Resources.Register(&domain.User{})
+	if err != nil {
+		return err
+	}
 	// Register all other provided resources
 	for _, modelObject := range modelObjects {
-		server.Resources.Register(modelObject)
+		err = server.Resources.Register(modelObject)
+		if err != nil {
+			return err
+		}
 	}
 	slog.Info("Resource factory initialized", "resources", server.Resources.Names())
+	return nil
+}
+
+// alertDeliveryFailure alerts when an outbox entry reaches the configured number of failed delivery attempts
+func (server *Server) alertDeliveryFailure(event events.Event, attempts int, err error) {
+	if attempts != server.AlertsConfig.DeliveryAttempts {
+		return
+	}
+	server.Alerts.Record(alerts.DELIVERY_EXHAUSTED, "Event delivery keeps failing", map[string]string{
+		"event":    event.ID.String(),
+		"type":     event.Type,
+		"attempts": fmt.Sprint(attempts),
+		"error":    err.Error(),
+	})
+}
+
+// RotateSecrets reads the database credentials and the Keycloak client secret again from the files given in
+// DB_USER_FILE, DB_PASSWORD_FILE and AUTH_CLIENT_SECRET_FILE and applies the ones that have changed
+func (server *Server) RotateSecrets(ctx context.Context) error {
+	if server.dbConnConfig != nil {
+		err := server.rotateDBSecretFiles(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	clientSecret, ok, err := cfg.SecretFile("AUTH_CLIENT_SECRET")
+	if err != nil {
+		return err
+	}
+	if ok && server.keycloakClient != nil && server.keycloakClient.SetClientSecret(clientSecret) {
+		slog.Info("Keycloak client secret rotated")
+	}
+	return nil
+}
+
+// rotateDBSecretFiles rotates the database credentials if their files have changed
+func (server *Server) rotateDBSecretFiles(ctx context.Context) error {
+	credentials := server.dbCredentials.Load().(dbCredentials)
+	rotated := credentials
+	user, ok, err := cfg.SecretFile("DB_USER")
+	if err != nil {
+		return err
+	}
+	if ok {
+		rotated.user = user
+	}
+	password, ok, err := cfg.SecretFile("DB_PASSWORD")
+	if err != nil {
+		return err
+	}
+	if ok {
+		rotated.password = password
+	}
+	if rotated == credentials {
+		return nil
+	}
+	return server.rotateDBCredentials(ctx, rotated)
+}
+
+// taskRequestContext creates a request context for scheduled tasks, it is not scoped to an owner
+func (server *Server) taskRequestContext(resourceName string, pageSize, page int) (*common.RequestContext, error) {
+	resource, ok := server.Resources.Resources[resourceName]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized resource name: %s", resourceName)
+	}
+	page, pageSize = common.NormalizePage(page, pageSize)
+	permissions := []string{fmt.Sprintf("%s.%s", resource.Name, common.GLOBAL)}
+	return server.newRequestContextWithDetails(context.Background(), pageSize, page, (page-1)*pageSize, nil, resource, permissions), nil
 }
 
 // initRouter is used to register routes
-func (server *Server) initRouter() {
+func (server *Server) initRouter() error {
 	server.Router = mux.NewRouter()
+	if server.Tracing != nil {
+		server.Router.Use(tracing.Middleware)
+	}
+	if server.Metrics != nil {
+		server.Router.Use(server.Metrics.Middleware)
+	}
 	server.Router.Use(loggerMiddleware)
+	server.Router.Use(localeMiddleware)
+	if len(server.offeredFeatures()) > 0 {
+		server.Router.Use(server.features)
+	}
+	server.Router.Use(server.recoverMiddleware)
+	if server.ServerConfig.ReadOnly {
+		server.Router.Use(server.readOnly)
+	}
+	if server.Tenants != nil {
+		server.Router.Use(server.tenantDomain)
+	}
+	if server.ServerConfig.MaxTransformLength > 0 {
+		server.Router.Use(server.transform)
+	}
+	if server.Cache != nil && server.CacheConfig.RateLimit > 0 {
+		server.Router.Use(server.rateLimit)
+	}
+	server.initPluginMiddleware()
 
 	// Unsecured Home Route
 	server.Router.HandleFunc(fmt.Sprintf("/%s/", server.ServerConfig.APIPath), server.Public(ContentTypeJSON(server.Home))).Methods(http.MethodGet)
+	// OpenAPI Routes, the document is generated from the registered resources
+	document := server.OpenAPI()
+	server.Router.HandleFunc(fmt.Sprintf("/%s/openapi.json", server.ServerConfig.APIPath), server.docsAccess(ContentTypeJSON(server.OpenAPIDocument(document)))).Methods(http.MethodGet)
+	if server.ServerConfig.DocsEnabled {
+		server.Router.HandleFunc(fmt.Sprintf("/%s/docs", server.ServerConfig.APIPath), server.docsAccess(server.Docs())).Methods(http.MethodGet)
+	}
+	// Subscriptions Route
+	if server.Broker != nil {
+		server.Router.HandleFunc(fmt.Sprintf("/%s/subscriptions", server.ServerConfig.APIPath), server.Subscriptions()).Methods(http.MethodGet)
+	}
+	// Credential rotation Route
+	server.Router.HandleFunc(fmt.Sprintf("/%s/credentials/rotate", server.ServerConfig.APIPath), server.Permitted(CREDENTIALS, WRITE, server.RotateCredentials())).Methods(http.MethodPost)
+	// Permission Routes
+	server.Router.HandleFunc(fmt.Sprintf("/%s/me/permissions", server.ServerConfig.APIPath), server.Authenticated(ContentTypeJSON(server.MyPermissions()))).Methods(http.MethodGet)
+	server.Router.HandleFunc(fmt.Sprintf("/%s/me/quotas", server.ServerConfig.APIPath), server.Authenticated(ContentTypeJSON(server.MyQuotas()))).Methods(http.MethodGet)
+	// Batches run in a transaction of the database, repositories have none
+	if server.Repository == nil {
+		server.Router.HandleFunc(fmt.Sprintf("/%s/$batch", server.ServerConfig.APIPath), server.Authenticated(ContentTypeJSON(server.Batch()))).Methods(http.MethodPost)
+	}
+	if len(server.ConsentConfig.Documents) > 0 {
+		server.Router.HandleFunc(fmt.Sprintf("/%s/me/consents", server.ServerConfig.APIPath), server.Authenticated(ContentTypeJSON(server.MyConsents()))).Methods(http.MethodGet)
+		server.Router.HandleFunc(fmt.Sprintf("/%s/me/consents", server.ServerConfig.APIPath), server.Authenticated(ContentTypeJSON(server.AcceptConsent()))).Methods(http.MethodPost)
+	}
+	server.initNotificationRoutes()
+	// The erasure anonymizes the objects and records its report in the database
+	if server.Repository == nil {
+		server.Router.HandleFunc(fmt.Sprintf("/%s/users/{id}/data", server.ServerConfig.APIPath), server.Authenticated(ContentTypeJSON(server.EraseUserData()))).Methods(http.MethodDelete)
+	}
+	server.Router.HandleFunc(fmt.Sprintf("/%s/permissions/check", server.ServerConfig.APIPath), server.Authenticated(ContentTypeJSON(server.CheckPermissions()))).Methods(http.MethodPost)
+	if server.Flags != nil {
+		server.Router.HandleFunc(fmt.Sprintf("/%s/flags", server.ServerConfig.APIPath), server.Authenticated(ContentTypeJSON(server.FeatureFlags()))).Methods(http.MethodGet)
+	}
+	// Search Route
+	if server.SearchIndexer != nil {
+		server.Router.HandleFunc(fmt.Sprintf("/%s/search", server.ServerConfig.APIPath), server.Authenticated(ContentTypeJSON(server.Search()))).Methods(http.MethodGet)
+	}
+	// GraphQL Route
+	if server.ServerConfig.GraphQLEnabled {
+		graphQLHandler, err := server.GraphQL()
+		if err != nil {
+			return err
+		}
+		server.Router.HandleFunc(fmt.Sprintf("/%s/graphql", server.ServerConfig.APIPath), ContentTypeJSON(graphQLHandler)).Methods(http.MethodGet, http.MethodPost)
+	}
+	// Signed download Routes, registered before the file and job routes to take precedence
+	server.initSignedRoutes()
+	// File content Routes, registered before the generic routes to take precedence
+	if server.Storage != nil {
+		server.initFileRoutes()
+	}
+	// Job Routes
+	if server.Jobs != nil {
+		server.initJobRoutes()
+	}
+	// Change Routes, registered before the generic routes to take precedence
+	if server.Outbox != nil {
+		for _, resource := range server.Resources.Resources {
+			server.Router.HandleFunc(fmt.Sprintf("/%s/%s/changes", server.ServerConfig.APIPath, resource.Name), server.deprecated(resource, server.Protected(READ, resource, server.sensitive(resource, OPERATION_CHANGES, server.resourceRateLimit(resource, OPERATION_CHANGES, ContentTypeJSON(server.Changes())))))).Methods(http.MethodGet)
+		}
+	}
+	// View and export schedule Routes, registered before the generic routes to take precedence
+	for _, resource := range server.Resources.Resources {
+		server.initViewRoutes(resource)
+		server.initExportScheduleRoutes(resource)
+	}
+	// Named query Routes
+	server.initQueryRoutes()
+	// Admin Routes
+	server.initAdminRoutes()
+	server.initManagementRoutes()
+	// SCIM Routes
+	server.initSCIMRoutes()
+	// Inbound webhook Routes
+	err := server.initWebhookRoutes()
+	if err != nil {
+		return err
+	}
+	// Plugin Routes, registered before the generic routes to take precedence
+	server.initPluginRoutes()
 	// Register all resource routes
 	for _, resource := range server.Resources.Resources {
 		apiResPath := fmt.Sprintf("/%s/%s", server.ServerConfig.APIPath, resource.Name)
 		apiResIDPath := fmt.Sprintf("/%s/%s/{id}", server.ServerConfig.APIPath, resource.Name)
-		server.Router.HandleFunc(apiResPath, server.Protected(WRITE, resource, ContentTypeJSON(server.Create()))).Methods(http.MethodPost)
-		server.Router.HandleFunc(apiResPath, server.Protected(READ, resource, ContentTypeJSON(server.GetAll()))).Methods(http.MethodGet)
-		server.Router.HandleFunc(apiResIDPath, server.Protected(READ, resource, ContentTypeJSON(server.Get()))).Methods(http.MethodGet)
-		server.Router.HandleFunc(apiResIDPath, server.Protected(WRITE, resource, ContentTypeJSON(server.Update()))).Methods(http.MethodPut)
-		server.Router.HandleFunc(apiResIDPath, server.Protected(WRITE, resource, ContentTypeJSON(server.Delete()))).Methods(http.MethodDelete)
+		server.initSQLViewRoutes(resource)
+		server.Router.HandleFunc(apiResPath, server.deprecated(resource, server.viewed(resource, server.readable(resource, server.sensitive(resource, OPERATION_LIST, server.resourceRateLimit(resource, OPERATION_LIST, server.cacheControl(resource, server.responseCache(resource, server.validateSchema(document, http.MethodGet, apiResPath, ContentTypeJSON(server.GetAll())))))))))).Methods(http.MethodGet)
+		server.Router.HandleFunc(apiResIDPath, server.deprecated(resource, server.readable(resource, server.sensitive(resource, OPERATION_GET, server.resourceRateLimit(resource, OPERATION_GET, server.cacheControl(resource, server.responseCache(resource, server.validateSchema(document, http.MethodGet, apiResIDPath, ContentTypeJSON(server.Get()))))))))).Methods(http.MethodGet)
+		server.Router.HandleFunc(apiResPath, server.deprecated(resource, server.Authenticated(server.Options(resource)))).Methods(http.MethodOptions)
+		server.Router.HandleFunc(apiResIDPath, server.deprecated(resource, server.Authenticated(server.Options(resource)))).Methods(http.MethodOptions)
+		// The SQL views are read-only
+		if resource.SQLView != nil {
+			continue
+		}
+		server.Router.HandleFunc(apiResPath, server.deprecated(resource, dryRunnable(server.Protected(WRITE, resource, server.sensitive(resource, OPERATION_CREATE, server.resourceRateLimit(resource, OPERATION_CREATE, server.idempotent(resource, server.responseCache(resource, server.validateSchema(document, http.MethodPost, apiResPath, ContentTypeJSON(server.Create())))))))))).Methods(http.MethodPost)
+		server.Router.HandleFunc(apiResIDPath, server.deprecated(resource, dryRunnable(server.Protected(WRITE, resource, server.sensitive(resource, OPERATION_UPDATE, server.resourceRateLimit(resource, OPERATION_UPDATE, server.responseCache(resource, server.validateSchema(document, http.MethodPut, apiResIDPath, ContentTypeJSON(server.Update()))))))))).Methods(http.MethodPut)
+		server.Router.HandleFunc(apiResIDPath, server.deprecated(resource, dryRunnable(server.Protected(WRITE, resource, server.sensitive(resource, OPERATION_DELETE, server.resourceRateLimit(resource, OPERATION_DELETE, server.responseCache(resource, ContentTypeJSON(server.Delete())))))))).Methods(http.MethodDelete)
+		server.initShareRoutes(resource)
+		server.initEditLockRoutes(resource)
+		if server.Presence != nil {
+			server.Router.HandleFunc(apiResIDPath+"/presence", server.deprecated(resource, server.Protected(READ, resource, ContentTypeJSON(server.GetPresence())))).Methods(http.MethodGet)
+		}
+		if resource.Merge != nil {
+			server.Router.HandleFunc(apiResIDPath+"/merge", server.deprecated(resource, dryRunnable(server.Protected(WRITE, resource, server.sensitive(resource, OPERATION_UPDATE, server.resourceRateLimit(resource, OPERATION_UPDATE, server.responseCache(resource, ContentTypeJSON(server.Merge())))))))).Methods(http.MethodPost)
+		}
+	}
+	// Metrics Route
+	if server.Metrics != nil {
+		server.Router.Handle(server.MetricsConfig.Path, server.Metrics.Handler()).Methods(http.MethodGet)
+	}
+	// Healthcheck Routes, registered before the static route to take precedence
+	server.Router.HandleFunc("/healthz", server.Health()).Methods(http.MethodGet)
+	server.Router.HandleFunc("/readyz", server.Ready()).Methods(http.MethodGet)
+	if server.CSPConfig.Reports {
+		server.Router.HandleFunc(CSP_REPORT_PATH, server.CollectCSPReports()).Methods(http.MethodPost)
 	}
 	// Static Route
 	server.Router.PathPrefix("/").Handler(server.Static())
-	// Healthcheck Route
-	server.Router.HandleFunc("/healthz", server.Health()).Methods(http.MethodGet)
 	slog.Info("Router initialized", "routes", server.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
 		path, err := route.GetPathTemplate()
 		if err != nil {
@@ -140,6 +1454,9 @@ func (server *Server) initRouter() {
 		slog.Info("Registered route", "path", path, "methods", methods)
 		return nil
 	}))
+	// The routes are served from now on, requests in flight keep the previous router
+	server.router.Store(server.Router)
+	return nil
 }
 
 // Run starts the http server
@@ -150,24 +1467,169 @@ func (server *Server) Run() {
 		WriteTimeout: server.ServerConfig.WriteTimeout,
 		ReadTimeout:  server.ServerConfig.ReadTimeout,
 		IdleTimeout:  server.ServerConfig.IdleTimeout,
-		Handler:      server.Router,
+		Handler:      server.trackRequests(server.Handler()),
 	}
 
-	go func() {
+	// The instance is not ready before it is warmed up
+	server.warming.Store(true)
+	listen := func() {
 		slog.Info("Listening on port", "port", server.ServerConfig.Port)
 		err := srv.ListenAndServe()
 		if err != nil {
 			slog.Info("Error while serving", "error", err)
 		}
+	}
+	// The health checks are served during the startup tasks, the API and the workers wait for them
+	if server.ServerConfig.GatedStartup {
+		server.starting.Store(true)
+		go listen()
+	}
+	err := server.startup(context.Background())
+	if err != nil {
+		slog.Error("Failed to start", "error", err)
+		os.Exit(1)
+	}
+	if server.starting.Swap(false) {
+		slog.Info("Startup tasks completed, serving the API")
+	}
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	// The outbox, the jobs and the scheduled tasks write, the writers run them for the read-only servers
+	readOnly := server.ServerConfig.ReadOnly
+	if server.Outbox != nil && !readOnly {
+		go server.Outbox.Run(workersCtx)
+	}
+	if server.Jobs != nil && !readOnly {
+		go server.Jobs.Run(workersCtx)
+	}
+	if server.dbFailover != nil {
+		go server.watchDBHosts(workersCtx)
+	}
+	if server.RequestMeter != nil {
+		go server.RequestMeter.Run(workersCtx, server.MeteringConfig.RequestsFlushInterval)
+	}
+	if server.Scheduler.HasTasks() && !readOnly {
+		go server.Scheduler.Run(workersCtx)
+	}
+	go server.HealthChecker.Run(workersCtx)
+	if server.Alerts != nil && server.DB != nil {
+		sqlDB, err := server.DB.DB()
+		if err == nil {
+			go server.Alerts.WatchDatabase(workersCtx, sqlDB.PingContext)
+		}
+	}
+	if server.Flags != nil && server.FlagsConfig.Database {
+		go server.Flags.Run(workersCtx)
+	}
+	if server.Permissions != nil {
+		go server.Permissions.Run(workersCtx)
+	}
+	if server.Tenants != nil {
+		go server.Tenants.Run(workersCtx)
+	}
+	if server.Instances != nil {
+		go server.Instances.Run(workersCtx)
+	}
+	if server.ManifestConfig.Path != "" {
+		go server.watchManifest(workersCtx)
+	}
+	if server.Vault != nil {
+		go server.Vault.KeepToken(workersCtx)
+		if server.vaultCredentials != nil {
+			go server.Vault.KeepDatabaseCredentials(workersCtx, server.vaultCredentials, func(credentials *vault.Credentials) {
+				err := server.rotateDBCredentials(workersCtx, dbCredentials{user: credentials.Username, password: credentials.Password})
+				if err != nil {
+					slog.Error("Error rotating database credentials from vault", "error", err)
+				}
+			})
+		}
+	}
+	if server.credentialsInterval > 0 && secretFilesConfigured() {
+		go server.watchSecrets(workersCtx)
+	}
+	if broadcaster, ok := server.Cache.(cache.Broadcaster); ok && server.ResponseCache != server.Cache {
+		go broadcaster.Listen(workersCtx, server.CacheConfig.Invalidations, server.invalidateLocalResponses)
+	}
+	if server.Presence != nil {
+		go server.Presence.Run(workersCtx)
+	}
+	if server.Inbox != nil {
+		go server.Inbox.Run(workersCtx)
+	}
+
+	var grpcServer *grpc.Server
+	if server.ServerConfig.GRPCPort != "" {
+		grpcServer = server.NewGRPCServer()
+		listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%s", server.ServerConfig.GRPCPort))
+		if err != nil {
+			slog.Error("Error listening for gRPC", "port", server.ServerConfig.GRPCPort, "error", err)
+			os.Exit(1)
+		}
+		go func() {
+			slog.Info("Listening for gRPC on port", "port", server.ServerConfig.GRPCPort)
+			err := grpcServer.Serve(listener)
+			if err != nil {
+				slog.Info("Error while serving gRPC", "error", err)
+			}
+		}()
+	}
+
+	if !server.ServerConfig.GatedStartup {
+		go listen()
+	}
+	go server.warmUp(workersCtx)
+	// Rotate secrets on hangup signal
+	rotate := make(chan os.Signal, 1)
+	signal.Notify(rotate, syscall.SIGHUP)
+	go func() {
+		for range rotate {
+			err := server.RotateSecrets(workersCtx)
+			if err != nil {
+				slog.Error("Error rotating secrets", "error", err)
+			}
+		}
 	}()
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	// Block until we receive termination signal.
 	<-c
+	server.startDraining()
 	// Wait for a deadline for termination.
 	ctx, cancel := context.WithTimeout(context.Background(), server.ServerConfig.DeadlineOnInterrupt)
 	defer cancel()
-	slog.Info("Shutting down")
-	srv.Shutdown(ctx)
+	slog.Info("Shutting down", "inFlight", server.inFlight.Load(), "hijacked", server.hijacked.Load())
+	server.stopServing(ctx, srv, grpcServer)
+	stopWorkers()
+	if server.RequestMeter != nil {
+		// The request counts of the last interval are kept too
+		err := server.RequestMeter.Flush(ctx)
+		if err != nil {
+			slog.Error("Error storing request counts", "error", err)
+		}
+	}
+	server.shutdownPlugins(ctx)
+	err = server.Publisher.Close()
+	if err != nil {
+		slog.Error("Error closing event publisher", "error", err)
+	}
+	if server.ResponseCache != nil && server.ResponseCache != server.Cache {
+		server.ResponseCache.Close()
+	}
+	if cached, ok := server.Exchanger.(*auth.CachedExchanger); ok && cached.Cache != server.Cache {
+		cached.Cache.Close()
+	}
+	if server.Cache != nil {
+		err = server.Cache.Close()
+		if err != nil {
+			slog.Error("Error closing cache", "error", err)
+		}
+	}
+	if server.Tracing != nil {
+		err = server.Tracing.Shutdown(ctx)
+		if err != nil {
+			slog.Error("Error shutting down tracing", "error", err)
+		}
+	}
 	os.Exit(0)
 }
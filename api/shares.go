@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/fga"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+)
+
+// WithFGA decides the access to the objects of the shared resources with the relationship tuples of an OpenFGA
+// store, the users see the objects they own and the ones they are viewers, editors or owners of
+func WithFGA(fgaConfig cfg.FGA) Option {
+	return func(server *Server) {
+		server.FGAConfig = fgaConfig
+	}
+}
+
+// initAuthorizer creates the OpenFGA authorizer when it is configured and no authorizer is set by the application.
+// The row-level security policies scope the objects by their owners and cannot see the relations.
+func (server *Server) initAuthorizer() error {
+	if server.FGAConfig.URL != "" && server.Authorizer == nil {
+		server.Authorizer = fga.New(server.FGAConfig)
+	}
+	if server.Authorizer != nil && common.RowLevelSecurity {
+		return fmt.Errorf("invalid configuration:\nDB_ROW_LEVEL_SECURITY: the shared resources require the ownership enforced by the queries")
+	}
+	return nil
+}
+
+// initShareRoutes registers the routes of the relations of the objects of the shared resource
+func (server *Server) initShareRoutes(resource common.Resource) {
+	if !resource.Shared || server.Authorizer == nil {
+		return
+	}
+	apiSharesPath := fmt.Sprintf("/%s/%s/{id}/shares", server.ServerConfig.APIPath, resource.Name)
+	server.Router.HandleFunc(apiSharesPath, server.deprecated(resource, server.Protected(READ, resource, ContentTypeJSON(server.Shares())))).Methods(http.MethodGet)
+	server.Router.HandleFunc(apiSharesPath, server.deprecated(resource, server.Protected(WRITE, resource, ContentTypeJSON(server.Share())))).Methods(http.MethodPost)
+	server.Router.HandleFunc(apiSharesPath+"/{relation}/{user_id}", server.deprecated(resource, server.Protected(WRITE, resource, ContentTypeJSON(server.Unshare())))).Methods(http.MethodDelete)
+}
+
+// Shares lists the relations of the users granted on the object of the path, to its owners
+func (server *Server) Shares() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository := common.GetRequestContext(ctx)
+		if repository == nil {
+			logger.Error("Error reading repository from context")
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+			return
+		}
+		uid, err := uuid.FromString(mux.Vars(r)["id"])
+		if err != nil {
+			logger.Error("Error parsing UUID from request", "error", err)
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		tuples, err := repository.Shares(ctx, uid)
+		if err != nil {
+			logger.Error("Error reading shares", "error", err)
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		if tuples == nil {
+			tuples = []common.Tuple{}
+		}
+		JSON(w, http.StatusOK, tuples)
+	}
+}
+
+// Share grants the relation of the body, e.g. {"user_id": "...", "relation": "viewer"}, on the object of the path
+// to the user, the caller must own the object
+func (server *Server) Share() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository := common.GetRequestContext(ctx)
+		if repository == nil {
+			logger.Error("Error reading repository from context")
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+			return
+		}
+		uid, err := uuid.FromString(mux.Vars(r)["id"])
+		if err != nil {
+			logger.Error("Error parsing UUID from request", "error", err)
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		tuple := common.Tuple{}
+		err = json.NewDecoder(r.Body).Decode(&tuple)
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		err = repository.Share(ctx, uid, tuple)
+		if err != nil {
+			logger.Error("Error sharing object", "error", err)
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		logger.Debug("Object shared successfully", "resource", repository.Resource.Name, "id", uid, "userID", tuple.UserID, "relation", tuple.Relation)
+		server.notifyShare(ctx, repository, uid, tuple)
+		JSON(w, http.StatusCreated, tuple)
+	}
+}
+
+// notifyShare delivers the share notification to the user the object was shared with, the failures are logged as
+// the object is shared already
+func (server *Server) notifyShare(ctx context.Context, repository *common.RequestContext, uid uuid.UUID, tuple common.Tuple) {
+	if user := repository.DBScopes.User; user != nil && user.ID == tuple.UserID {
+		return
+	}
+	err := common.Notify(ctx, domain.Notification{
+		UserID:   tuple.UserID,
+		Type:     domain.NOTIFICATION_SHARE,
+		Resource: repository.Resource.Name,
+		ObjectID: &uid,
+		Title:    fmt.Sprintf("A %s was shared with you as %s", repository.Resource.Name, tuple.Relation),
+	})
+	if err != nil {
+		common.GetLogger(ctx).Error("Error delivering share notification", "resource", repository.Resource.Name, "id", uid, "error", err)
+	}
+}
+
+// Unshare revokes the relation of the path on the object of the path from the user of the path, the caller must
+// own the object
+func (server *Server) Unshare() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository := common.GetRequestContext(ctx)
+		if repository == nil {
+			logger.Error("Error reading repository from context")
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+			return
+		}
+		vars := mux.Vars(r)
+		uid, err := uuid.FromString(vars["id"])
+		if err != nil {
+			logger.Error("Error parsing UUID from request", "error", err)
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		userID, err := uuid.FromString(vars["user_id"])
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		err = repository.Unshare(ctx, uid, common.Tuple{UserID: userID, Relation: vars["relation"]})
+		if err != nil {
+			logger.Error("Error unsharing object", "error", err)
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		logger.Debug("Object unshared successfully", "resource", repository.Resource.Name, "id", uid, "userID", userID, "relation", vars["relation"])
+		JSON(w, http.StatusNoContent, "")
+	}
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/jobs"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// signatureParameter and expiresParameter are the query parameters of the signed URLs
+	signatureParameter = "signature"
+	expiresParameter   = "expires"
+)
+
+// ErrInvalidSignature is returned for the signed URLs whose signature does not match or has expired
+var ErrInvalidSignature = errors.New("invalid or expired signature")
+
+// initSignedRoutes registers the routes issuing the signed download URLs of the files and of the job artifacts,
+// and the downloads with them, if SERVER_SIGNING_KEY is configured. The downloads with a signature are routed
+// before the ones with a token.
+func (server *Server) initSignedRoutes() {
+	if server.ServerConfig.SigningKey == "" || server.Storage == nil {
+		return
+	}
+	if server.Jobs != nil {
+		apiJobIDPath := fmt.Sprintf("/%s/jobs/{id}", server.ServerConfig.APIPath)
+		server.Router.HandleFunc(apiJobIDPath+"/artifact", server.signed(server.GetSignedJobArtifact())).Methods(http.MethodGet).Queries(signatureParameter, "")
+		server.Router.HandleFunc(apiJobIDPath+"/artifact-url", server.Authenticated(ContentTypeJSON(server.JobArtifactURL()))).Methods(http.MethodPost)
+	}
+	resource := server.Resources.Resources[(&domain.File{}).ResourceName()]
+	apiFileIDPath := fmt.Sprintf("/%s/%s/{id}", server.ServerConfig.APIPath, resource.Name)
+	server.Router.HandleFunc(apiFileIDPath+"/content", server.signed(server.DownloadSignedFile())).Methods(http.MethodGet).Queries(signatureParameter, "")
+	server.Router.HandleFunc(apiFileIDPath+"/download-url", server.Protected(READ, resource, ContentTypeJSON(server.FileDownloadURL()))).Methods(http.MethodPost)
+}
+
+// FileDownloadURL returns a signed URL downloading the file content without a token, e.g. for browsers or third
+// parties. The variant query parameter selects an image variant as for the downloads.
+func (server *Server) FileDownloadURL() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, file, ok := loadFile(w, r)
+		if !ok {
+			return
+		}
+		query := url.Values{}
+		if variant := r.URL.Query().Get("variant"); variant != "" {
+			if _, ok := server.StorageConfig.ImageVariants[variant]; !ok {
+				ERROR(w, http.StatusNotFound, fmt.Errorf("unrecognized image variant: %s", variant))
+				return
+			}
+			query.Set("variant", variant)
+		}
+		path := fmt.Sprintf("/%s/%s/%s/content", server.ServerConfig.APIPath, file.ResourceName(), file.ID)
+		server.writeSignedURL(w, r, path, query)
+	}
+}
+
+// JobArtifactURL returns a signed URL downloading the artifact of the job of the caller without a token
+func (server *Server) JobArtifactURL() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := server.loadJob(w, r)
+		if !ok {
+			return
+		}
+		if job.Status != jobs.COMPLETED || job.Artifact == "" {
+			ERROR(w, http.StatusNotFound, fmt.Errorf("job %s has no artifact", job.ID))
+			return
+		}
+		path := fmt.Sprintf("/%s/jobs/%s/artifact", server.ServerConfig.APIPath, job.ID)
+		server.writeSignedURL(w, r, path, url.Values{})
+	}
+}
+
+// DownloadSignedFile serves the file content of a signed URL, the caller was authorized when it was signed
+func (server *Server) DownloadSignedFile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		uid, err := uuid.FromString(mux.Vars(r)["id"])
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		file := &domain.File{}
+		err = server.DB.WithContext(ctx).First(file, "id = ?", uid).Error
+		if err != nil {
+			common.GetLogger(ctx).Error("Error getting file", "error", err)
+			ERROR(w, http.StatusNotFound, err)
+			return
+		}
+		server.serveFile(w, r, file)
+	}
+}
+
+// GetSignedJobArtifact serves the job artifact of a signed URL, the owner of the job signed it
+func (server *Server) GetSignedJobArtifact() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		uid, err := uuid.FromString(mux.Vars(r)["id"])
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		job, err := jobs.Load(ctx, server.DB, uid)
+		if err != nil {
+			common.GetLogger(ctx).Error("Error getting job", "error", err)
+			ERROR(w, http.StatusNotFound, err)
+			return
+		}
+		server.serveArtifact(w, r, job)
+	}
+}
+
+// SignURL signs the path and the query until the expiry, the signature covers both, so that neither can be changed
+func (server *Server) SignURL(path string, query url.Values, expiresAt time.Time) string {
+	signed := url.Values{}
+	for name, values := range query {
+		signed[name] = values
+	}
+	signed.Set(expiresParameter, strconv.FormatInt(expiresAt.Unix(), 10))
+	signed.Set(signatureParameter, server.signature(path, signed))
+	return path + "?" + signed.Encode()
+}
+
+// signed is a Wrapper for the routes of the signed URLs, the requests with an invalid or expired signature are
+// answered with 401
+func (server *Server) signed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		expires, err := strconv.ParseInt(query.Get(expiresParameter), 10, 64)
+		valid := err == nil && time.Now().Unix() <= expires &&
+			hmac.Equal([]byte(query.Get(signatureParameter)), []byte(server.signature(r.URL.Path, query)))
+		if !valid {
+			common.GetLogger(r.Context()).Warn("Unauthorized request, invalid signed URL", "path", r.URL.Path)
+			ERROR(w, http.StatusUnauthorized, ErrInvalidSignature)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// signature is the HMAC-SHA256 of the path and of the query without the signature, the parameters are sorted
+func (server *Server) signature(path string, query url.Values) string {
+	signed := url.Values{}
+	for name, values := range query {
+		if name != signatureParameter {
+			signed[name] = values
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(server.ServerConfig.SigningKey))
+	mac.Write([]byte(path + "?" + signed.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// writeSignedURL responds with the signed URL of the path on the host of the request
+func (server *Server) writeSignedURL(w http.ResponseWriter, r *http.Request, path string, query url.Values) {
+	expiresAt := time.Now().Add(server.ServerConfig.SignedURLExpiry)
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	JSON(w, http.StatusOK, PresignedURL{
+		URL:       fmt.Sprintf("%s://%s%s", scheme, r.Host, server.SignURL(path, query, expiresAt)),
+		Method:    http.MethodGet,
+		ExpiresAt: expiresAt,
+	})
+}
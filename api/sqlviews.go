@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/scheduler"
+)
+
+// validateSQLViews reports the resources backed by SQL views of the servers with a repository, as the views are
+// read from the database
+func (server *Server) validateSQLViews() error {
+	if server.Repository == nil {
+		return nil
+	}
+	var problems []error
+	for _, resource := range server.Resources.Resources {
+		if resource.SQLView != nil {
+			problems = append(problems, fmt.Errorf("resource %s: SQL views require the database, the objects are kept in the repository", resource.Name))
+		}
+	}
+	err := errors.Join(problems...)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+// initSQLViews schedules the refreshes of the materialized views with a refresh schedule
+func (server *Server) initSQLViews() error {
+	for _, resource := range server.Resources.Resources {
+		if resource.SQLView == nil || !resource.SQLView.Materialized || resource.SQLView.Refresh == "" {
+			continue
+		}
+		name := resource.Name
+		err := server.Scheduler.Register("refresh-"+name, resource.SQLView.Refresh, func(ctx context.Context, taskContext *scheduler.TaskContext) error {
+			resource, ok := server.Resources.Resources[name]
+			if !ok {
+				return nil
+			}
+			return server.refreshView(ctx, resource)
+		})
+		if err != nil {
+			return fmt.Errorf("resource %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// initSQLViewRoutes registers the refresh route of the materialized view of the resource, the callers need the write
+// permission of the resource and the refreshes are limited as updates
+func (server *Server) initSQLViewRoutes(resource common.Resource) {
+	if resource.SQLView == nil || !resource.SQLView.Materialized {
+		return
+	}
+	path := fmt.Sprintf("/%s/%s/refresh", server.ServerConfig.APIPath, resource.Name)
+	server.Router.HandleFunc(path, server.deprecated(resource, server.Protected(WRITE, resource, server.resourceRateLimit(resource, OPERATION_UPDATE, ContentTypeJSON(server.RefreshView()))))).Methods(http.MethodPost)
+}
+
+// RefreshView refreshes the materialized view of the resource
+func (server *Server) RefreshView() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		repository := common.GetRequestContext(ctx)
+		if repository == nil {
+			logger.Error("Error reading repository from context")
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+			return
+		}
+		err := server.refreshView(ctx, repository.Resource)
+		if err != nil {
+			logger.Error("Error refreshing view", "resource", repository.Resource.Name, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// refreshView refreshes the materialized view and invalidates the cached responses of the resource
+func (server *Server) refreshView(ctx context.Context, resource common.Resource) error {
+	err := common.RefreshView(ctx, server.DB, resource)
+	if err != nil {
+		return err
+	}
+	if server.ResponseCache != nil {
+		server.invalidateResponses(ctx, resource.Name)
+	}
+	common.GetLogger(ctx).Info("View refreshed", "resource", resource.Name)
+	return nil
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dzahariev/respite/auth"
+	"github.com/dzahariev/respite/domain"
+)
+
+// Stages of the initialisation of the server, in the order they run
+const (
+	STAGE_CONFIG        = "config"
+	STAGE_DATABASE      = "database"
+	STAGE_OBSERVABILITY = "observability"
+	STAGE_AUTH          = "auth"
+	STAGE_EVENTS        = "events"
+	STAGE_RESOURCES     = "resources"
+	STAGE_WORKERS       = "workers"
+	STAGE_IDENTITY      = "identity"
+	STAGE_ROUTES        = "routes"
+	STAGE_MANIFEST      = "manifest"
+	// STAGE_STARTUP_TASKS and STAGE_WARM_UP run when the server is started
+	STAGE_STARTUP_TASKS = "startup tasks"
+	STAGE_WARM_UP       = "warm-up"
+)
+
+// StartupStage is the outcome of a stage of the startup, or of a task of the startup tasks and the warm-up
+type StartupStage struct {
+	Name string `json:"name"`
+	// Needs are the stages whose components the stage uses
+	Needs      []string       `json:"needs,omitempty"`
+	StartedAt  time.Time      `json:"started_at"`
+	DurationMS float64        `json:"duration_ms"`
+	Error      string         `json:"error,omitempty"`
+	Tasks      []StartupStage `json:"tasks,omitempty"`
+}
+
+// StartupReport is the timing of the stages of the startup, e.g. to find the stage that makes a startup slow or
+// fail. The server is ready when the warm-up completes.
+type StartupReport struct {
+	StartedAt time.Time      `json:"started_at"`
+	ReadyAt   *time.Time     `json:"ready_at,omitempty"`
+	Stages    []StartupStage `json:"stages"`
+}
+
+// startupStage is a stage of the initialisation of the server
+type startupStage struct {
+	name  string
+	needs []string
+	run   func() error
+}
+
+// startupTask is a task that completes before the API is served, e.g. the migrations
+type startupTask struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// runStages runs the stages in their order and records their timing, the first failing stage stops the startup
+// and its error is wrapped with its name
+func (server *Server) runStages(stages ...startupStage) error {
+	server.startupMutex.Lock()
+	server.startupReport = StartupReport{StartedAt: time.Now().UTC()}
+	server.startupMutex.Unlock()
+	completed := map[string]bool{}
+	for _, stage := range stages {
+		for _, need := range stage.needs {
+			if !completed[need] {
+				return fmt.Errorf("startup stage %s needs stage %s that did not run before", stage.name, need)
+			}
+		}
+		started := time.Now()
+		err := stage.run()
+		record := newStartupStage(stage.name, started, err)
+		record.Needs = stage.needs
+		server.recordStage(record)
+		if err != nil {
+			slog.Error("Startup stage failed", "stage", stage.name, "duration", time.Since(started), "error", err)
+			server.logStartupReport()
+			return fmt.Errorf("startup stage %s failed: %w", stage.name, err)
+		}
+		slog.Info("Startup stage completed", "stage", stage.name, "duration", time.Since(started))
+		completed[stage.name] = true
+	}
+	return nil
+}
+
+// newStartupStage records the outcome of a stage or of a task that started at the time
+func newStartupStage(name string, started time.Time, err error) StartupStage {
+	stage := StartupStage{
+		Name:       name,
+		StartedAt:  started.UTC(),
+		DurationMS: float64(time.Since(started).Microseconds()) / 1000,
+	}
+	if err != nil {
+		stage.Error = err.Error()
+	}
+	return stage
+}
+
+// recordStage adds the stage to the startup report
+func (server *Server) recordStage(stage StartupStage) {
+	server.startupMutex.Lock()
+	defer server.startupMutex.Unlock()
+	server.startupReport.Stages = append(server.startupReport.Stages, stage)
+}
+
+// StartupReport returns the timing of the stages of the startup so far
+func (server *Server) StartupReport() StartupReport {
+	server.startupMutex.Lock()
+	defer server.startupMutex.Unlock()
+	report := server.startupReport
+	report.Stages = slices.Clone(report.Stages)
+	return report
+}
+
+// logStartupReport logs the startup report as one record, for the log pipelines
+func (server *Server) logStartupReport() {
+	report := server.StartupReport()
+	stages := make([]any, 0, len(report.Stages))
+	for _, stage := range report.Stages {
+		stages = append(stages, slog.Group(stage.Name, "duration_ms", stage.DurationMS, "error", stage.Error, "tasks", len(stage.Tasks)))
+	}
+	slog.Info("Startup report", slog.Group("stages", stages...), "ready", report.ReadyAt != nil)
+}
+
+// GetStartupReport returns the timing of the stages of the startup
+func (server *Server) GetStartupReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		JSON(w, http.StatusOK, server.StartupReport())
+	}
+}
+
+// WithStartupTask runs the task when the server is started, before the bootstrap of the admin user and the fake
+// data. The tasks run in the order they are given and a failing task stops the server, e.g. for the migrations
+// and the seeders of the application.
+func WithStartupTask(name string, run func(ctx context.Context) error) Option {
+	return func(server *Server) {
+		server.startupTasks = append(server.startupTasks, startupTask{name: name, run: run})
+	}
+}
+
+// startup runs the startup tasks, then bootstraps the admin user and creates the fake data, whose errors are
+// logged without stopping the server
+func (server *Server) startup(ctx context.Context) error {
+	stage := newStartupStage(STAGE_STARTUP_TASKS, time.Now(), nil)
+	for _, task := range server.startupTasks {
+		started := time.Now()
+		slog.Info("Startup task started", "task", task.name)
+		err := task.run(ctx)
+		stage.Tasks = append(stage.Tasks, newStartupStage(task.name, started, err))
+		if err != nil {
+			err = fmt.Errorf("startup task %s failed: %w", task.name, err)
+			stage.complete(err)
+			server.recordStage(stage)
+			server.logStartupReport()
+			return err
+		}
+		slog.Info("Startup task completed", "task", task.name, "duration", time.Since(started))
+	}
+	stage.complete(nil)
+	server.recordStage(stage)
+	if server.BootstrapConfig.Enabled() {
+		err := server.BootstrapAdmin(ctx)
+		if err != nil {
+			slog.Error("Failed to bootstrap admin user", "error", err)
+		}
+	}
+	if server.fakeData > 0 {
+		// In the development mode the data is owned by the development user
+		var owner *domain.User
+		if devClient, ok := server.AuthClient.(*auth.DevClient); ok {
+			owner = &devClient.User
+		}
+		err := server.SeedFake(ctx, server.fakeData, owner)
+		if err != nil {
+			slog.Error("Failed to create fake data", "error", err)
+		}
+	}
+	return nil
+}
+
+// WithWarmUp runs the task after the startup tasks, before /readyz reports ready, e.g. to prime the caches, so
+// that the first requests routed to the instance do not pay for it. The warm-up tasks run in the order they are
+// given after the built-in ones, within SERVER_WARM_UP_TIMEOUT, and their errors are logged without keeping the
+// instance unready.
+func WithWarmUp(name string, run func(ctx context.Context) error) Option {
+	return func(server *Server) {
+		server.warmUpTasks = append(server.warmUpTasks, startupTask{name: name, run: run})
+	}
+}
+
+// builtinWarmUps fetch the signing keys of Keycloak and query the tables of the resources, which prepares the
+// statements of the connection and the schemas of the models
+func (server *Server) builtinWarmUps() []startupTask {
+	var tasks []startupTask
+	if server.keycloakClient != nil {
+		tasks = append(tasks, startupTask{name: "keycloak keys", run: server.keycloakClient.FetchKeys})
+	}
+	if server.DB != nil {
+		tasks = append(tasks, startupTask{name: "resource tables", run: func(ctx context.Context) error {
+			var failures []error
+			for _, name := range server.Resources.Names() {
+				objects := reflect.New(reflect.SliceOf(server.Resources.Resources[name].Type)).Interface()
+				err := server.DB.WithContext(ctx).Limit(1).Find(objects).Error
+				if err != nil {
+					failures = append(failures, fmt.Errorf("%s: %w", name, err))
+				}
+			}
+			return errors.Join(failures...)
+		}})
+	}
+	return tasks
+}
+
+// warmUp runs the warm-up tasks and reports the instance ready when they complete
+func (server *Server) warmUp(ctx context.Context) {
+	defer server.warming.Store(false)
+	ctx, cancel := context.WithTimeout(ctx, server.ServerConfig.WarmUpTimeout)
+	defer cancel()
+	started := time.Now()
+	stage := newStartupStage(STAGE_WARM_UP, started, nil)
+	for _, task := range append(server.builtinWarmUps(), server.warmUpTasks...) {
+		taskStarted := time.Now()
+		err := task.run(ctx)
+		stage.Tasks = append(stage.Tasks, newStartupStage(task.name, taskStarted, err))
+		if err != nil {
+			slog.Warn("Warm-up task failed", "task", task.name, "error", err)
+		}
+	}
+	stage.complete(nil)
+	server.recordStage(stage)
+	readyAt := time.Now().UTC()
+	server.startupMutex.Lock()
+	server.startupReport.ReadyAt = &readyAt
+	server.startupMutex.Unlock()
+	slog.Info("Warm-up completed", "duration", time.Since(started))
+	server.logStartupReport()
+}
+
+// complete records the duration of the stage since it started and its error
+func (stage *StartupStage) complete(err error) {
+	stage.DurationMS = float64(time.Since(stage.StartedAt).Microseconds()) / 1000
+	if err != nil {
+		stage.Error = err.Error()
+	}
+}
+
+// gateStartup answers the requests with 503 and Retry-After while the startup tasks of SERVER_GATED_STARTUP run,
+// except the health checks and the metrics
+func (server *Server) gateStartup(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !server.starting.Load() || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || (server.Metrics != nil && r.URL.Path == server.MetricsConfig.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(server.ServerConfig.StartupRetryAfter.Seconds()))))
+		ERROR(w, http.StatusServiceUnavailable, errors.New("the server is starting"))
+	})
+}
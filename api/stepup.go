@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/auth"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+)
+
+// ErrInsufficientAuthentication is returned for the requests of the operations of STEPUP_OPERATIONS whose token
+// does not carry the required authentication, the client authenticates the user again, e.g. with a second factor
+var ErrInsufficientAuthentication = errors.New("insufficient_authentication")
+
+// callerTokenKey is the context key of the token of the caller
+type callerTokenKey struct{}
+
+// callerToken returns the token of the caller kept in the context by the authentication
+func callerToken(ctx context.Context) string {
+	tokenString, _ := ctx.Value(callerTokenKey{}).(string)
+	return tokenString
+}
+
+// WithStepUp requires a stronger authentication for the operations of STEPUP_OPERATIONS, e.g. the deletes
+func WithStepUp(stepUpConfig cfg.StepUp) Option {
+	return func(server *Server) {
+		server.StepUpConfig = stepUpConfig
+	}
+}
+
+// StepUp is a Wrapper for the authenticated routes that require the authentication of STEPUP_ACR, STEPUP_AMR and
+// STEPUP_MAX_AGE, e.g. the custom routes of sensitive operations. The requests of tokens without it are answered
+// with 401 and a WWW-Authenticate challenge with the insufficient_user_authentication error of RFC 9470, so that
+// the client asks Keycloak for a token with the acr_values and the max_age of the challenge.
+func (server *Server) StepUp(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The development mode has no tokens to step up
+		if server.devMode {
+			next(w, r)
+			return
+		}
+		logger := common.GetLogger(r.Context())
+		tokenString, err := bearerToken(r)
+		if err == nil {
+			err = server.checkAuthentication(r.Context(), tokenString)
+		} else {
+			err = fmt.Errorf("%w: %w", ErrInsufficientAuthentication, err)
+		}
+		if err != nil {
+			logger.Warn("Unauthorized request, insufficient authentication", "path", r.URL.Path, "error", err)
+			w.Header().Set("WWW-Authenticate", server.stepUpChallenge(err))
+			ERROR(w, http.StatusUnauthorized, WithCode(CODE_STEP_UP, err))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkStepUp checks the authentication of the caller for the operations of STEPUP_OPERATIONS outside the resource
+// routes, e.g. in the batches, GraphQL and gRPC, the errors carry CODE_STEP_UP
+func (server *Server) checkStepUp(ctx context.Context, resource common.Resource, operation string) error {
+	if server.devMode || !designated(server.StepUpConfig.Operations, resource, operation) {
+		return nil
+	}
+	err := server.checkAuthentication(ctx, callerToken(ctx))
+	if err != nil {
+		common.GetLogger(ctx).Warn("Unauthorized request, insufficient authentication", "resource", resource.Name, "operation", operation, "error", err)
+		return WithCode(CODE_STEP_UP, err)
+	}
+	return nil
+}
+
+// checkAuthentication returns ErrInsufficientAuthentication when the authentication of the token is not one of
+// STEPUP_ACR, has none of the methods of STEPUP_AMR or is older than STEPUP_MAX_AGE. The tokens are rejected when
+// the auth client does not know their authentication.
+func (server *Server) checkAuthentication(ctx context.Context, tokenString string) error {
+	config := server.StepUpConfig
+	reader, ok := server.AuthClient.(auth.AuthenticationReader)
+	if !ok {
+		return fmt.Errorf("%w: the authentication of the token is not known", ErrInsufficientAuthentication)
+	}
+	if tokenString == "" {
+		return fmt.Errorf("%w: the token of the caller is not known", ErrInsufficientAuthentication)
+	}
+	authentication, err := reader.Authentication(ctx, tokenString)
+	if err != nil {
+		return fmt.Errorf("%w: the authentication of the token is not known: %w", ErrInsufficientAuthentication, err)
+	}
+	if len(config.ACR) > 0 && !slices.Contains(config.ACR, authentication.ACR) {
+		return fmt.Errorf("%w: authentication context %q is not one of %s", ErrInsufficientAuthentication, authentication.ACR, strings.Join(config.ACR, ", "))
+	}
+	if len(config.AMR) > 0 && !slices.ContainsFunc(authentication.AMR, func(method string) bool { return slices.Contains(config.AMR, method) }) {
+		return fmt.Errorf("%w: authentication with one of %s is required", ErrInsufficientAuthentication, strings.Join(config.AMR, ", "))
+	}
+	if config.MaxAge > 0 && (authentication.AuthTime.IsZero() || time.Since(authentication.AuthTime) > config.MaxAge) {
+		return fmt.Errorf("%w: authentication within %s is required", ErrInsufficientAuthentication, config.MaxAge)
+	}
+	return nil
+}
+
+// stepUpChallenge is the WWW-Authenticate header of the requests with an insufficient authentication, with the
+// accepted authentication contexts and the maximum age of the authentication
+func (server *Server) stepUpChallenge(err error) string {
+	description := strings.ReplaceAll(err.Error(), `"`, "'")
+	challenge := fmt.Sprintf(`Bearer error="insufficient_user_authentication", error_description="%s"`, description)
+	if len(server.StepUpConfig.ACR) > 0 {
+		challenge += fmt.Sprintf(`, acr_values="%s"`, strings.Join(server.StepUpConfig.ACR, " "))
+	}
+	if server.StepUpConfig.MaxAge > 0 {
+		challenge += ", max_age=" + strconv.Itoa(int(server.StepUpConfig.MaxAge.Seconds()))
+	}
+	return challenge
+}
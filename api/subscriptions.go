@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/events"
+	"github.com/dzahariev/respite/presence"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	SUBSCRIBE   = "subscribe"
+	UNSUBSCRIBE = "unsubscribe"
+	JOIN        = "join"
+	LEAVE       = "leave"
+)
+
+// subscriptionMessage is a client request received on the subscriptions socket
+type subscriptionMessage struct {
+	Action   string     `json:"action"`
+	Resource string     `json:"resource"`
+	ID       *uuid.UUID `json:"id,omitempty"`
+	// State is the presence of the join, viewing or editing
+	State string `json:"state,omitempty"`
+}
+
+// subscriptionReply is a message sent to the client on the subscriptions socket
+type subscriptionReply struct {
+	Type     string        `json:"type"`
+	Resource string        `json:"resource,omitempty"`
+	ID       *uuid.UUID    `json:"id,omitempty"`
+	Event    *events.Event `json:"event,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Presence is the users on the object for the presence replies, it is omitted when nobody is present
+	Presence []presence.Entry `json:"presence,omitempty"`
+}
+
+// subscriptionKey identifies a subscription, the nil ID stands for all objects of the resource
+type subscriptionKey struct {
+	resource string
+	id       uuid.UUID
+}
+
+// subscriptionSession holds the subscriptions of a single socket
+type subscriptionSession struct {
+	mutex sync.RWMutex
+	id    uuid.UUID
+	user  *domain.User
+	// permissions are the permissions of the last subscribe, they reveal the PII fields of the events
+	permissions common.Permissions
+	// keys maps subscriptions to a flag telling if only the events of the objects owned by the user are visible
+	keys map[subscriptionKey]bool
+	// joined are the objects whose presence the session joined
+	joined map[subscriptionKey]bool
+}
+
+// accepts checks if the event matches a subscription of the session
+func (session *subscriptionSession) accepts(event events.Event) bool {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	for _, key := range []subscriptionKey{{event.Resource, event.ObjectID}, {event.Resource, uuid.Nil}} {
+		ownedOnly, ok := session.keys[key]
+		if !ok {
+			continue
+		}
+		if !ownedOnly || (event.OwnerID != nil && *event.OwnerID == session.user.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentPermissions returns the permissions of the session
+func (session *subscriptionSession) currentPermissions() common.Permissions {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return session.permissions
+}
+
+// Subscriptions upgrades the request to a WebSocket that streams change notifications.
+// Browsers cannot set headers on WebSocket requests, so the token can also be passed as access_token query parameter.
+func (server *Server) Subscriptions() http.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		// Authentication is token based, so cross origin sockets are not a risk for ambient credentials
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+
+		tokenString, err := bearerToken(r)
+		if err != nil {
+			tokenString = r.URL.Query().Get("access_token")
+		}
+		if tokenString == "" {
+			logger.Error("Unauthorized subscription request, missing token")
+			ERROR(w, http.StatusUnauthorized, fmt.Errorf("unauthorized, missing bearer authorization header"))
+			return
+		}
+		user, permissions, err := server.authenticate(ctx, tokenString)
+		if err != nil {
+			ERROR(w, authenticationStatus(err), err)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logger.Error("Error upgrading subscription request", "error", err)
+			return
+		}
+		defer conn.Close()
+		logger.Debug("Subscription socket opened", "userID", user.ID)
+
+		session := &subscriptionSession{
+			id:          uuid.Must(uuid.NewV4()),
+			user:        user,
+			permissions: permissions,
+			keys:        map[subscriptionKey]bool{},
+			joined:      map[subscriptionKey]bool{},
+		}
+		subscription := server.Broker.Subscribe(session.accepts)
+		defer subscription.Close()
+
+		pingInterval := server.SubscriptionsConfig.PingInterval
+		replies := make(chan subscriptionReply, server.SubscriptionsConfig.BufferSize)
+		done := make(chan struct{})
+		writerDone := make(chan struct{})
+		if server.Presence != nil {
+			stop := server.Presence.Watch(session.presenceWatcher(replies))
+			defer server.Presence.LeaveAll(context.WithoutCancel(ctx), session.id)
+			defer stop()
+		}
+
+		// Writer is the only goroutine writing to the socket
+		go func() {
+			defer close(writerDone)
+			ticker := time.NewTicker(pingInterval)
+			defer ticker.Stop()
+			for {
+				var err error
+				select {
+				case <-done:
+					return
+				case reply := <-replies:
+					err = conn.WriteJSON(reply)
+				case event, ok := <-subscription.Events:
+					if !ok {
+						conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown"), time.Now().Add(time.Second))
+						conn.Close()
+						return
+					}
+					event.Data = server.Resources.Resources[event.Resource].Redact(event.Data, session.currentPermissions())
+					err = conn.WriteJSON(subscriptionReply{Type: "event", Resource: event.Resource, ID: &event.ObjectID, Event: &event})
+				case <-ticker.C:
+					err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingInterval))
+				}
+				if err != nil {
+					logger.Debug("Error writing to subscription socket", "error", err)
+					conn.Close()
+					return
+				}
+			}
+		}()
+
+		conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+		conn.SetPongHandler(func(string) error {
+			if server.Presence != nil {
+				server.Presence.Refresh(session.id)
+			}
+			return conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+		})
+		for {
+			var message subscriptionMessage
+			err := conn.ReadJSON(&message)
+			if err != nil {
+				logger.Debug("Subscription socket closed", "userID", user.ID, "reason", err)
+				break
+			}
+			reply := server.handleSubscriptionMessage(r, tokenString, session, message)
+			select {
+			case replies <- reply:
+			case <-writerDone:
+			}
+		}
+		close(done)
+		<-writerDone
+	}
+}
+
+// handleSubscriptionMessage applies a client request to the session, permissions are checked again on every subscribe
+func (server *Server) handleSubscriptionMessage(r *http.Request, tokenString string, session *subscriptionSession, message subscriptionMessage) subscriptionReply {
+	ctx := r.Context()
+	logger := common.GetLogger(ctx)
+	key := subscriptionKey{resource: message.Resource}
+	if message.ID != nil {
+		key.id = *message.ID
+	}
+	errorReply := func(err error) subscriptionReply {
+		return subscriptionReply{Type: "error", Resource: message.Resource, ID: message.ID, Error: err.Error()}
+	}
+
+	switch message.Action {
+	case SUBSCRIBE:
+		resource, ok := server.Resources.Resources[message.Resource]
+		if !ok {
+			return errorReply(fmt.Errorf("unrecognized resource name: %s", message.Resource))
+		}
+		_, permissions, err := server.authenticate(ctx, tokenString)
+		if err != nil {
+			return errorReply(err)
+		}
+		if !permissions.Can(resource.Name, READ) {
+			logger.Error("Unauthorized subscription, no permission for resource", "resource", resource.Name, "permission", READ)
+			return errorReply(fmt.Errorf("unauthorized, no permission for %s.%s", resource.Name, READ))
+		}
+		ownedOnly := !resource.IsGlobal && !permissions.CanGlobal(resource.Name)
+		session.mutex.Lock()
+		session.keys[key] = ownedOnly
+		session.permissions = permissions
+		session.mutex.Unlock()
+		logger.Debug("Subscribed to resource", "resource", resource.Name, "id", key.id, "owned", ownedOnly)
+		return subscriptionReply{Type: "subscribed", Resource: message.Resource, ID: message.ID}
+	case UNSUBSCRIBE:
+		session.mutex.Lock()
+		delete(session.keys, key)
+		session.mutex.Unlock()
+		return subscriptionReply{Type: "unsubscribed", Resource: message.Resource, ID: message.ID}
+	case JOIN, LEAVE:
+		if server.Presence == nil {
+			return errorReply(fmt.Errorf("presence is not enabled"))
+		}
+		return server.handlePresenceMessage(ctx, tokenString, session, message)
+	default:
+		return errorReply(fmt.Errorf("unsupported action: %s", message.Action))
+	}
+}
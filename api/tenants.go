@@ -0,0 +1,424 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/flags"
+	"github.com/dzahariev/respite/jobs"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/dzahariev/respite/tenants"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// TENANT_PROVISIONING is the kind of the long-running provisioning of the tenants
+const TENANT_PROVISIONING = "tenant_provisioning"
+
+var (
+	// ErrTenantSuspended is returned for the requests of the users of suspended tenants
+	ErrTenantSuspended = errors.New("tenant suspended")
+	// ErrTenantDomain is returned for the requests of users to the custom domain of another tenant
+	ErrTenantDomain = errors.New("domain of another tenant")
+)
+
+// tenantProvisioner is a step of the provisioning of new tenants, e.g. the creation of their schema
+type tenantProvisioner struct {
+	name string
+	run  func(ctx context.Context, tenant *tenants.Tenant) error
+}
+
+// WithTenantProvisioner runs the step when a tenant is created with the admin routes, e.g. to create its schema or
+// to seed its data. The steps run in the order they are given and a failing step stops the provisioning, which
+// may be run again. The steps must tolerate the objects of a previous, failed run.
+func WithTenantProvisioner(name string, run func(ctx context.Context, tenant *tenants.Tenant) error) Option {
+	return func(server *Server) {
+		server.tenantProvisioners = append(server.tenantProvisioners, tenantProvisioner{name: name, run: run})
+	}
+}
+
+// GetTenant returns the tenant of the request, the tenant of the user of WithTenant or of the custom domain of the
+// request, empty without tenant
+func GetTenant(ctx context.Context) string {
+	return respitectx.Tenant(ctx)
+}
+
+// tenantID returns the tenant of the user of WithTenant, or the tenant of the custom domain of the request for the
+// users without tenant and the anonymous callers
+func (server *Server) tenantID(ctx context.Context, user *domain.User) string {
+	if server.Tenant != nil && user != nil {
+		if tenant := server.Tenant(user); tenant != "" {
+			return tenant
+		}
+	}
+	return GetTenant(ctx)
+}
+
+// tenantOf returns the tenant of the request, nil if the tenant does not exist
+func (server *Server) tenantOf(ctx context.Context, user *domain.User) *tenants.Tenant {
+	if server.Tenants == nil {
+		return nil
+	}
+	id := server.tenantID(ctx, user)
+	if id == "" {
+		return nil
+	}
+	tenant, ok := server.Tenants.Get(id)
+	if !ok {
+		return nil
+	}
+	return tenant
+}
+
+// tenantDomain resolves the tenant of the custom domain of the request from the Host header, the domains that
+// are not of a tenant are served without tenant
+func (server *Server) tenantDomain(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := server.Tenants.ForDomain(r.Host); ok {
+			r = r.WithContext(respitectx.WithTenant(r.Context(), id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxPageSize returns the maximum page size of the request, the one of the tenant or SERVER_MAX_PAGE_SIZE
+func (server *Server) maxPageSize(ctx context.Context, user *domain.User) int {
+	if tenant := server.tenantOf(ctx, user); tenant != nil && tenant.MaxPageSize > 0 {
+		return tenant.MaxPageSize
+	}
+	return common.MaxPageSize
+}
+
+// limitPage applies the maximum page size of the tenant to the page of the request
+func (server *Server) limitPage(r *http.Request, requestContext *common.RequestContext) {
+	scopes := &requestContext.DBScopes
+	maxPageSize := server.maxPageSize(r.Context(), scopes.User)
+	if maxPageSize == common.MaxPageSize {
+		return
+	}
+	pageSize := scopes.PageSize
+	if requested, _ := strconv.Atoi(r.URL.Query().Get("page_size")); requested > 0 {
+		pageSize = requested
+	}
+	scopes.Page, scopes.PageSize = common.NormalizePageWithin(scopes.Page, pageSize, maxPageSize)
+	scopes.Offset = (scopes.Page - 1) * scopes.PageSize
+}
+
+// checkTenant rejects the users of suspended tenants and the users of other tenants on the custom domain of a tenant
+func (server *Server) checkTenant(ctx context.Context, user *domain.User) error {
+	if domainTenant := GetTenant(ctx); domainTenant != "" {
+		if tenant := server.tenantID(ctx, user); tenant != domainTenant {
+			return WithCode(CODE_TENANT_DOMAIN, fmt.Errorf("%w: the user of %s requested the domain of %s", ErrTenantDomain, tenant, domainTenant))
+		}
+	}
+	if tenant := server.tenantOf(ctx, user); tenant != nil && tenant.Suspended {
+		return WithCode(CODE_TENANT_SUSPENDED, fmt.Errorf("%w: %s", ErrTenantSuspended, tenant.ID))
+	}
+	return nil
+}
+
+// evaluateFlags returns the feature flags evaluated for the user, with the flags of the tenant turned on or off
+func (server *Server) evaluateFlags(ctx context.Context, user *domain.User) flags.Set {
+	set := server.Flags.Evaluate(user)
+	if tenant := server.tenantOf(ctx, user); tenant != nil {
+		for name, enabled := range tenant.Flags {
+			set[name] = enabled
+		}
+	}
+	return set
+}
+
+// validateTenant reports the overrides of resources that are not registered and the negative limits
+func (server *Server) validateTenant(tenant *tenants.Tenant) error {
+	var problems []error
+	if tenant.MaxPageSize < 0 {
+		problems = append(problems, fmt.Errorf("max_page_size must not be negative, got %d", tenant.MaxPageSize))
+	}
+	check := func(field string, limits map[string]int64, owned bool) {
+		for name, limit := range limits {
+			resource, ok := server.Resources.Resources[name]
+			switch {
+			case limit < 0:
+				problems = append(problems, fmt.Errorf("%s of %s must not be negative, got %d", field, name, limit))
+			case name == "*":
+			case !ok:
+				problems = append(problems, fmt.Errorf("%s: unknown resource %s", field, name))
+			case owned && !resource.IsOwned():
+				problems = append(problems, fmt.Errorf("%s: the objects of resource %s have no owners", field, name))
+			}
+		}
+	}
+	check("quotas", tenant.Quotas, true)
+	check("rate_limits", tenant.RateLimits, false)
+	return errors.Join(problems...)
+}
+
+// checkDomains normalizes the custom domains of the tenant and reports the domains of other tenants
+func (server *Server) checkDomains(w http.ResponseWriter, tenant *tenants.Tenant) bool {
+	for i, domain := range tenant.Domains {
+		domain = tenants.NormalizeDomain(domain)
+		if domain == "" {
+			ERROR(w, http.StatusUnprocessableEntity, errors.New("domains must not be empty"))
+			return false
+		}
+		if owner, ok := server.Tenants.ForDomain(domain); ok && owner != tenant.ID {
+			ERROR(w, http.StatusConflict, fmt.Errorf("domain %s is of tenant %s", domain, owner))
+			return false
+		}
+		tenant.Domains[i] = domain
+	}
+	return true
+}
+
+// tenantStatus maps the errors of the tenants to response statuses
+func tenantStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, tenants.ErrExists):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// decodeTenant reads the tenant of the request body and validates its overrides
+func (server *Server) decodeTenant(w http.ResponseWriter, r *http.Request) (*tenants.Tenant, bool) {
+	var tenant tenants.Tenant
+	err := json.NewDecoder(r.Body).Decode(&tenant)
+	if err != nil {
+		ERROR(w, http.StatusBadRequest, fmt.Errorf("invalid tenant: %w", err))
+		return nil, false
+	}
+	err = server.validateTenant(&tenant)
+	if err != nil {
+		ERROR(w, http.StatusUnprocessableEntity, err)
+		return nil, false
+	}
+	return &tenant, true
+}
+
+// provisionTenant runs the provisioning steps for the tenant and records their outcome on the tenant
+func (server *Server) provisionTenant(ctx context.Context, id string) (*tenants.Tenant, error) {
+	tenant, err := server.Tenants.Find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	logger := common.GetLogger(ctx).With("tenant", id)
+	started := time.Now()
+	var provisionErr error
+	for _, provisioner := range server.tenantProvisioners {
+		logger.Info("Tenant provisioning step started", "step", provisioner.name)
+		err = provisioner.run(ctx, tenant)
+		if err != nil {
+			provisionErr = fmt.Errorf("provisioning step %s failed: %w", provisioner.name, err)
+			break
+		}
+	}
+	tenant.ProvisionError = ""
+	if provisionErr != nil {
+		tenant.ProvisionError = provisionErr.Error()
+		logger.Error("Tenant provisioning failed", "error", provisionErr)
+	} else {
+		now := domain.Now()
+		tenant.ProvisionedAt = &now
+		logger.Info("Tenant provisioned", "duration", time.Since(started))
+	}
+	return tenant, errors.Join(provisionErr, server.Tenants.Save(ctx, tenant))
+}
+
+// tenantProvisioningOperation provisions the tenant of the job, the result is the provisioned tenant
+func (server *Server) tenantProvisioningOperation(ctx context.Context, job *jobs.Job) (any, error) {
+	var parameters struct {
+		Tenant string `json:"tenant"`
+	}
+	err := json.Unmarshal([]byte(job.Parameters), &parameters)
+	if err != nil {
+		return nil, err
+	}
+	tenant, err := server.provisionTenant(ctx, parameters.Tenant)
+	if tenant == nil {
+		return nil, err
+	}
+	return tenant, err
+}
+
+// respondProvisioning provisions the tenant and responds with the status, with the jobs the provisioning runs as
+// a long-running operation and the response is 202 Accepted with the operation
+func (server *Server) respondProvisioning(w http.ResponseWriter, r *http.Request, id string, status int) {
+	if server.Jobs != nil && len(server.tenantProvisioners) > 0 {
+		server.StartOperation(w, r, TENANT_PROVISIONING, "", map[string]string{"tenant": id})
+		return
+	}
+	tenant, err := server.provisionTenant(r.Context(), id)
+	if err != nil {
+		ERROR(w, tenantStatus(err), err)
+		return
+	}
+	JSON(w, status, tenant)
+}
+
+// ListTenants returns all tenants
+func (server *Server) ListTenants() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		list, err := server.Tenants.List(ctx)
+		if err != nil {
+			logger.Error("Error loading tenants", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, list)
+	}
+}
+
+// GetTenant returns the tenant
+func (server *Server) GetTenant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := server.Tenants.Find(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			ERROR(w, tenantStatus(err), err)
+			return
+		}
+		JSON(w, http.StatusOK, tenant)
+	}
+}
+
+// CreateTenant creates the tenant with its overrides and provisions it, so that onboarding a customer is a single
+// call. The tenant keeps the error of a failed provisioning, which is run again with ProvisionTenant.
+func (server *Server) CreateTenant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		tenant, ok := server.decodeTenant(w, r)
+		if !ok {
+			return
+		}
+		tenant.ID = strings.TrimSpace(tenant.ID)
+		if tenant.ID == "" {
+			ERROR(w, http.StatusUnprocessableEntity, errors.New("id is required"))
+			return
+		}
+		if !server.checkDomains(w, tenant) {
+			return
+		}
+		tenant.Suspended = false
+		tenant.ProvisionedAt = nil
+		tenant.ProvisionError = ""
+		err := server.Tenants.Create(ctx, tenant)
+		if err != nil {
+			logger.Error("Error creating tenant", "tenant", tenant.ID, "error", err)
+			ERROR(w, tenantStatus(err), err)
+			return
+		}
+		logger.Info("Tenant created", "tenant", tenant.ID)
+		server.respondProvisioning(w, r, tenant.ID, http.StatusCreated)
+	}
+}
+
+// UpdateTenant replaces the name, the domains and the overrides of the tenant, they apply to its users without a restart and
+// are loaded by the other replicas on their next refresh
+func (server *Server) UpdateTenant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		update, ok := server.decodeTenant(w, r)
+		if !ok {
+			return
+		}
+		tenant, err := server.Tenants.Find(ctx, mux.Vars(r)["id"])
+		if err != nil {
+			ERROR(w, tenantStatus(err), err)
+			return
+		}
+		update.ID = tenant.ID
+		if !server.checkDomains(w, update) {
+			return
+		}
+		replaceTenant(tenant, update)
+		err = server.Tenants.Save(ctx, tenant)
+		if err != nil {
+			logger.Error("Error saving tenant", "tenant", tenant.ID, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		logger.Info("Tenant updated", "tenant", tenant.ID)
+		JSON(w, http.StatusOK, tenant)
+	}
+}
+
+// replaceTenant replaces the name, the domains and the overrides of the tenant with the ones of the update
+func replaceTenant(tenant, update *tenants.Tenant) {
+	tenant.Name = update.Name
+	tenant.Domains = update.Domains
+	tenant.MaxPageSize = update.MaxPageSize
+	tenant.Quotas = update.Quotas
+	tenant.RateLimits = update.RateLimits
+	tenant.Flags = update.Flags
+}
+
+// SuspendTenant suspends the tenant or resumes it, the requests of the users of a suspended tenant are rejected
+// with 403 while its data is kept
+func (server *Server) SuspendTenant(suspended bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		tenant, err := server.Tenants.Find(ctx, mux.Vars(r)["id"])
+		if err != nil {
+			ERROR(w, tenantStatus(err), err)
+			return
+		}
+		tenant.Suspended = suspended
+		err = server.Tenants.Save(ctx, tenant)
+		if err != nil {
+			logger.Error("Error saving tenant", "tenant", tenant.ID, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		logger.Info("Tenant suspension changed", "tenant", tenant.ID, "suspended", suspended)
+		JSON(w, http.StatusOK, tenant)
+	}
+}
+
+// ProvisionTenant runs the provisioning of the tenant again, e.g. after a failed step was fixed
+func (server *Server) ProvisionTenant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		_, err := server.Tenants.Find(r.Context(), id)
+		if err != nil {
+			ERROR(w, tenantStatus(err), err)
+			return
+		}
+		server.respondProvisioning(w, r, id, http.StatusOK)
+	}
+}
+
+// DeleteTenant removes the tenant, its users get the limits of the configuration again. The data of the tenant
+// is kept.
+func (server *Server) DeleteTenant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		id := mux.Vars(r)["id"]
+		deleted, err := server.Tenants.Delete(ctx, id)
+		if err != nil {
+			logger.Error("Error deleting tenant", "tenant", id, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !deleted {
+			ERROR(w, http.StatusNotFound, fmt.Errorf("tenant %s does not exist", id))
+			return
+		}
+		logger.Info("Tenant deleted", "tenant", id)
+		JSON(w, http.StatusNoContent, "")
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/gorilla/websocket"
+	"github.com/jmespath/go-jmespath"
+)
+
+// TRANSFORM is the query parameter of the JMESPath expressions reshaping the JSON responses
+const TRANSFORM = "transform"
+
+// transformWriter holds the response back until the transform is applied to it
+type transformWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status code
+func (writer *transformWriter) WriteHeader(status int) {
+	writer.status = status
+}
+
+// Write buffers the body
+func (writer *transformWriter) Write(data []byte) (int, error) {
+	return writer.body.Write(data)
+}
+
+// transform evaluates the JMESPath expression of the transform parameter over the successful JSON responses, e.g.
+// transform=data[].{id: id, name: name} keeps the IDs and names of the objects of a list. The expressions only read
+// the response, they are limited to SERVER_MAX_TRANSFORM_LENGTH characters and compiled before the request is
+// handled. Error responses and other content types are not transformed.
+func (server *Server) transform(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expression := r.URL.Query().Get(TRANSFORM)
+		if expression == "" || websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if utf8.RuneCountInString(expression) > server.ServerConfig.MaxTransformLength {
+			w.Header().Set("Content-Type", "application/json")
+			ERROR(w, http.StatusBadRequest, fmt.Errorf("transform is longer than %d characters", server.ServerConfig.MaxTransformLength))
+			return
+		}
+		compiled, err := jmespath.Compile(expression)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			ERROR(w, http.StatusBadRequest, fmt.Errorf("invalid transform: %w", err))
+			return
+		}
+		writer := &transformWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(writer, r)
+		body := writer.body.Bytes()
+		if writer.status < 200 || writer.status >= 300 || len(body) == 0 || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			w.WriteHeader(writer.status)
+			w.Write(body)
+			return
+		}
+		var data any
+		err = json.Unmarshal(body, &data)
+		if err != nil {
+			w.WriteHeader(writer.status)
+			w.Write(body)
+			return
+		}
+		// The entity tags identify the versions of the objects, not of the transformed responses
+		w.Header().Del("ETag")
+		w.Header().Del("Content-Length")
+		result, err := compiled.Search(data)
+		if err != nil {
+			// The errors of the evaluation quote the values of the response
+			common.GetLogger(r.Context()).Debug("Error evaluating transform", "transform", expression, "error", err)
+			ERROR(w, http.StatusBadRequest, errors.New("transform cannot be evaluated on the response"))
+			return
+		}
+		JSON(w, writer.status, result)
+	})
+}
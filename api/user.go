@@ -2,10 +2,14 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/dzahariev/respite/common"
 	"github.com/dzahariev/respite/domain"
 	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // DBLoadUser loads an user by given ID
@@ -18,7 +22,11 @@ func (server *Server) DBLoadUser(ctx context.Context, userID string) (*domain.Us
 	}
 
 	user := &domain.User{}
-	err = user.FindByID(ctx, server.DB, user, uid)
+	if server.Repository != nil {
+		err = server.Repository.FindByID(ctx, common.DBScopes{Global: true}, user, uid)
+	} else {
+		err = user.FindByID(ctx, server.DB, user, uid)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +38,12 @@ func (server *Server) DBLoadUser(ctx context.Context, userID string) (*domain.Us
 func (server *Server) DBSaveUser(ctx context.Context, user *domain.User) error {
 	logger := common.GetLogger(ctx)
 	logger.Debug("DBSaveUser request received", "user", user)
-	err := user.Save(ctx, server.DB, user)
+	var err error
+	if server.Repository != nil {
+		err = server.Repository.Save(ctx, user)
+	} else {
+		err = user.Save(ctx, server.DB, user)
+	}
 
 	if err != nil {
 		return err
@@ -38,3 +51,83 @@ func (server *Server) DBSaveUser(ctx context.Context, user *domain.User) error {
 	logger.Debug("User saved successfully", "userID", user.ID, "user", user)
 	return nil
 }
+
+// DBUpdateUser updates the profile of the existing user, the empty fields are cleared
+func (server *Server) DBUpdateUser(ctx context.Context, user *domain.User) error {
+	logger := common.GetLogger(ctx)
+	logger.Debug("DBUpdateUser request received", "user", user)
+	err := user.Validate(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", domain.ErrValidation, err)
+	}
+	if server.Repository != nil {
+		err = server.Repository.Update(ctx, user)
+	} else {
+		err = server.DB.WithContext(ctx).Model(user).Select(personalUserFields).Updates(user).Error
+	}
+	if err != nil {
+		return err
+	}
+	logger.Debug("User updated successfully", "userID", user.ID, "user", user)
+	return nil
+}
+
+// DBProvisionUser creates the user unless it exists and returns the stored user. Concurrent first requests of a
+// new user all try to create it, the database ignores the duplicates with ON CONFLICT DO NOTHING and every
+// request loads the same stored user. The read-only servers do not store the new users, the writers provision them.
+func (server *Server) DBProvisionUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	logger := common.GetLogger(ctx)
+	loadedUser, err := server.DBLoadUser(ctx, user.ID.String())
+	if err == nil {
+		if loadedUser.Pending && !server.ServerConfig.ReadOnly {
+			return server.linkPendingUser(ctx, loadedUser)
+		}
+		return loadedUser, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if server.ServerConfig.ReadOnly {
+		return user, nil
+	}
+	if server.Repository != nil {
+		err = server.Repository.Save(ctx, user)
+	} else {
+		err = server.insertUser(ctx, user)
+	}
+	if err != nil {
+		// The repository rejects the duplicates, the user created by a concurrent request is loaded instead
+		loadedUser, loadErr := server.DBLoadUser(ctx, user.ID.String())
+		if loadErr == nil {
+			logger.Debug("User created by a concurrent request", "userID", user.ID)
+			return loadedUser, nil
+		}
+		return nil, err
+	}
+	logger.Debug("User provisioned", "userID", user.ID)
+	return server.DBLoadUser(ctx, user.ID.String())
+}
+
+// linkPendingUser completes the first login of the user provisioned with SCIM, its ID is the Keycloak subject
+// given as externalId, so that the user keeps its ID and the rows that reference it
+func (server *Server) linkPendingUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	err := server.DB.WithContext(ctx).Model(user).Update("pending", false).Error
+	if err != nil {
+		return nil, err
+	}
+	common.GetLogger(ctx).Info("User provisioned with SCIM linked", "userID", user.ID, "scimID", user.SCIMID)
+	return user, nil
+}
+
+// insertUser creates the user in the database, an existing user with the same ID is kept
+func (server *Server) insertUser(ctx context.Context, user *domain.User) error {
+	err := user.Prepare(ctx)
+	if err != nil {
+		return err
+	}
+	err = user.Validate(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", domain.ErrValidation, err)
+	}
+	return server.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(user).Error
+}
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// VIEW is the parameter of the list requests with the ID of a saved view
+const VIEW = "view"
+
+// viewParameters are the parameters of the lists that the views keep, besides the filters of the array fields
+var viewParameters = []string{"page_size", "aggregates", "near", "radius", "region", "label"}
+
+// viewColumns are the columns of domain.Base, which every object has
+var viewColumns = []string{"id", "created_at", "updated_at"}
+
+// viewKey carries the saved view of the list request
+type viewKey struct{}
+
+// WithViews lets the users save named views of the lists of the resources, with their filters and columns, and
+// request the lists with the view parameter
+func WithViews(viewsConfig cfg.Views) Option {
+	return func(server *Server) {
+		server.ViewsConfig = viewsConfig
+	}
+}
+
+// initViewRoutes registers the routes of the views of the resource, the callers need the read permission of the
+// resource. They are registered before the routes of the objects to take precedence.
+func (server *Server) initViewRoutes(resource common.Resource) {
+	if !server.ViewsConfig.Enabled {
+		return
+	}
+	path := fmt.Sprintf("/%s/%s/views", server.ServerConfig.APIPath, resource.Name)
+	server.Router.HandleFunc(path, server.deprecated(resource, server.Protected(READ, resource, ContentTypeJSON(server.Views())))).Methods(http.MethodGet)
+	server.Router.HandleFunc(path, server.deprecated(resource, server.Protected(READ, resource, ContentTypeJSON(server.CreateView())))).Methods(http.MethodPost)
+	server.Router.HandleFunc(path+"/{view_id}", server.deprecated(resource, server.Protected(READ, resource, ContentTypeJSON(server.GetView())))).Methods(http.MethodGet)
+	server.Router.HandleFunc(path+"/{view_id}", server.deprecated(resource, server.Protected(READ, resource, ContentTypeJSON(server.UpdateView())))).Methods(http.MethodPut)
+	server.Router.HandleFunc(path+"/{view_id}", server.deprecated(resource, server.Protected(READ, resource, ContentTypeJSON(server.DeleteView())))).Methods(http.MethodDelete)
+}
+
+// viewed applies the saved view of the view parameter to the list request of the resource, the parameters of the
+// request take precedence over the parameters of the view
+func (server *Server) viewed(resource common.Resource, next http.HandlerFunc) http.HandlerFunc {
+	if !server.ViewsConfig.Enabled {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !query.Has(VIEW) {
+			next(w, r)
+			return
+		}
+		ctx := r.Context()
+		uid, err := uuid.FromString(query.Get(VIEW))
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, fmt.Errorf("invalid view: %w", err))
+			return
+		}
+		view := &domain.View{}
+		err = server.DB.WithContext(ctx).First(view, "id = ? AND resource = ?", uid, resource.Name).Error
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				common.GetLogger(ctx).Error("Error loading view", "error", err)
+			}
+			ERROR(w, repositoryStatus(err), err)
+			return
+		}
+		for key, values := range view.Parameters {
+			if !query.Has(key) {
+				query[key] = values
+			}
+		}
+		viewURL := *r.URL
+		viewURL.RawQuery = query.Encode()
+		request := r.WithContext(context.WithValue(ctx, viewKey{}, view))
+		request.URL = &viewURL
+		next(w, request)
+	}
+}
+
+// listView returns the view of the list request, nil without view. The views of other users are found only when
+// they are shared in the tenant of the caller.
+func listView(ctx context.Context) (*domain.View, error) {
+	view, ok := ctx.Value(viewKey{}).(*domain.View)
+	if !ok {
+		return nil, nil
+	}
+	if !visibleView(ctx, view) {
+		return nil, fmt.Errorf("view %s: %w", view.ID, gorm.ErrRecordNotFound)
+	}
+	return view, nil
+}
+
+// visibleView checks that the view is owned by the caller or shared in the tenant of the caller
+func visibleView(ctx context.Context, view *domain.View) bool {
+	if user, ok := respitectx.CurrentUser(ctx); ok && user != nil && view.UserID == user.ID {
+		return true
+	}
+	return view.Shared && view.Tenant == respitectx.Tenant(ctx)
+}
+
+// projectColumns returns the list with the fields of the columns of its objects and their IDs
+func projectColumns(list *domain.List, columns []string) (map[string]any, error) {
+	body, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	projected := map[string]any{}
+	err = json.Unmarshal(body, &projected)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := projected["data"].([]any)
+	for i, item := range data {
+		object, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		fields := map[string]any{"id": object["id"]}
+		for _, column := range columns {
+			if value, ok := object[column]; ok {
+				fields[column] = value
+			}
+		}
+		data[i] = fields
+	}
+	return projected, nil
+}
+
+// validateView checks the name of the view, its parameters against the parameters of the lists of the resource
+// and its columns against the fields of the resource
+func validateView(resource common.Resource, view *domain.View) error {
+	var problems []error
+	if strings.TrimSpace(view.Name) == "" {
+		problems = append(problems, errors.New("name is required"))
+	}
+	for key := range view.Parameters {
+		if !slices.Contains(viewParameters, key) && !common.IsArrayFilter(resource, key) {
+			problems = append(problems, fmt.Errorf("%s is not a parameter of the lists of %s", key, resource.Name))
+		}
+	}
+	for _, column := range view.Columns {
+		if _, ok := domain.JSONField(resource.Type, column); !ok && !slices.Contains(viewColumns, column) {
+			problems = append(problems, fmt.Errorf("%s is not a field of %s", column, resource.Name))
+		}
+	}
+	err := errors.Join(problems...)
+	if err != nil {
+		return fmt.Errorf("%w: %w", domain.ErrValidation, err)
+	}
+	return nil
+}
+
+// decodeView reads and validates the view of the body for the resource of the request
+func decodeView(w http.ResponseWriter, r *http.Request) (*common.RequestContext, *domain.View, bool) {
+	repository := common.GetRequestContext(r.Context())
+	if repository == nil {
+		common.GetLogger(r.Context()).Error("Error reading repository from context")
+		ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+		return nil, nil, false
+	}
+	view := &domain.View{}
+	err := json.NewDecoder(r.Body).Decode(view)
+	if err != nil {
+		ERROR(w, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	err = validateView(repository.Resource, view)
+	if err != nil {
+		ERROR(w, repositoryStatus(err), err)
+		return nil, nil, false
+	}
+	return repository, view, true
+}
+
+// loadView returns the view of the path of the resource of the request when the caller sees it, with owned only
+// when the caller owns it
+func (server *Server) loadView(w http.ResponseWriter, r *http.Request, owned bool) (*domain.View, bool) {
+	ctx := r.Context()
+	repository := common.GetRequestContext(ctx)
+	if repository == nil {
+		common.GetLogger(ctx).Error("Error reading repository from context")
+		ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+		return nil, false
+	}
+	uid, err := uuid.FromString(mux.Vars(r)["view_id"])
+	if err != nil {
+		ERROR(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	view := &domain.View{}
+	err = server.DB.WithContext(ctx).First(view, "id = ? AND resource = ?", uid, repository.Resource.Name).Error
+	if err == nil && !visibleView(ctx, view) {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			common.GetLogger(ctx).Error("Error loading view", "error", err)
+		}
+		ERROR(w, repositoryStatus(err), err)
+		return nil, false
+	}
+	if user, _ := respitectx.CurrentUser(ctx); owned && view.UserID != user.ID {
+		ERROR(w, http.StatusUnauthorized, WithCode(CODE_PERMISSION, fmt.Errorf("unauthorized, view %s is owned by another user", view.ID)))
+		return nil, false
+	}
+	return view, true
+}
+
+// Views lists the views of the resource of the caller and the ones shared in the tenant of the caller, by name
+func (server *Server) Views() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		repository := common.GetRequestContext(ctx)
+		if repository == nil {
+			common.GetLogger(ctx).Error("Error reading repository from context")
+			ERROR(w, http.StatusInternalServerError, fmt.Errorf("error reading repository from context"))
+			return
+		}
+		user, _ := respitectx.CurrentUser(ctx)
+		views := []domain.View{}
+		err := server.DB.WithContext(ctx).
+			Where("resource = ? AND (user_id = ? OR (shared = ? AND tenant = ?))", repository.Resource.Name, user.ID, true, respitectx.Tenant(ctx)).
+			Order("name, id").Find(&views).Error
+		if err != nil {
+			common.GetLogger(ctx).Error("Error loading views", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, views)
+	}
+}
+
+// GetView returns the view of the path
+func (server *Server) GetView() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		view, ok := server.loadView(w, r, false)
+		if !ok {
+			return
+		}
+		JSON(w, http.StatusOK, view)
+	}
+}
+
+// CreateView saves the view of the body for the caller, e.g. {"name": "Urgent", "parameters": {"tags__contains":
+// ["urgent"]}, "columns": ["title", "due"]}, up to VIEWS_MAX_PER_USER views per resource
+func (server *Server) CreateView() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repository, view, ok := decodeView(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		logger := common.GetLogger(ctx)
+		user, _ := respitectx.CurrentUser(ctx)
+		if limit := server.ViewsConfig.MaxPerUser; limit > 0 {
+			var count int64
+			err := server.DB.WithContext(ctx).Model(&domain.View{}).Where("resource = ? AND user_id = ?", repository.Resource.Name, user.ID).Count(&count).Error
+			if err != nil {
+				logger.Error("Error counting views", "error", err)
+				ERROR(w, http.StatusInternalServerError, err)
+				return
+			}
+			if count >= limit {
+				ERROR(w, http.StatusForbidden, WithCode(CODE_QUOTA, fmt.Errorf("the limit of %d views of %s is reached", limit, repository.Resource.Name)))
+				return
+			}
+		}
+		now := domain.Now()
+		view.ID = uuid.Must(uuid.NewV4())
+		view.Resource = repository.Resource.Name
+		view.UserID = user.ID
+		view.Tenant = respitectx.Tenant(ctx)
+		view.CreatedAt = now
+		view.UpdatedAt = now
+		err := server.DB.WithContext(ctx).Create(view).Error
+		if err != nil {
+			logger.Error("Error saving view", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusCreated, view)
+	}
+}
+
+// UpdateView replaces the name, the parameters, the columns, the settings and the sharing of the view of the path,
+// the caller must own the view
+func (server *Server) UpdateView() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		view, ok := server.loadView(w, r, true)
+		if !ok {
+			return
+		}
+		_, changed, ok := decodeView(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		view.Name = changed.Name
+		view.Parameters = changed.Parameters
+		view.Columns = changed.Columns
+		view.Settings = changed.Settings
+		view.Shared = changed.Shared
+		view.UpdatedAt = domain.Now()
+		err := server.DB.WithContext(ctx).Select("name", "parameters", "columns", "settings", "shared", "updated_at").Updates(view).Error
+		if err != nil {
+			common.GetLogger(ctx).Error("Error saving view", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		JSON(w, http.StatusOK, view)
+	}
+}
+
+// DeleteView deletes the view of the path, the caller must own the view
+func (server *Server) DeleteView() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		view, ok := server.loadView(w, r, true)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		err := server.DB.WithContext(ctx).Delete(view).Error
+		if err != nil {
+			common.GetLogger(ctx).Error("Error deleting view", "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/inbound"
+	"github.com/dzahariev/respite/jobs"
+	"github.com/gofrs/uuid/v5"
+)
+
+// maxWebhookBody is the default size limit of the inbound webhook deliveries
+const maxWebhookBody = 1 << 20
+
+// webhookName are the names of the inbound webhooks, the last segment of their path
+var webhookName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// InboundWebhook is an endpoint receiving the webhooks of another system at POST /api/webhooks/{name}, e.g. the
+// GitHub pushes or the Stripe payments. The deliveries are verified by the Verifier instead of a token, and their
+// JSON payload is validated against the Schema, if any. Verified deliveries are passed to the Handler, or queued
+// as a job of the Job kind, whose parameters are the WebhookDelivery.
+type InboundWebhook struct {
+	Name     string
+	Verifier inbound.Verifier
+	// Schema is the schema of the payload, the properties that are not in it are accepted
+	Schema *OpenAPISchema
+	// Handler processes the delivery during the request, its errors are answered with 500 so that the provider
+	// sends the delivery again
+	Handler func(ctx context.Context, delivery *WebhookDelivery) error
+	// Job is the kind of the job processing the delivery in the background, the handler of the kind is registered
+	// with server.Jobs
+	Job string
+	// MaxBodySize limits the size of the deliveries, 1 MiB without it
+	MaxBodySize int64
+}
+
+// WebhookDelivery is a verified delivery of an inbound webhook
+type WebhookDelivery struct {
+	ID         uuid.UUID       `json:"id"`
+	Webhook    string          `json:"webhook"`
+	ReceivedAt time.Time       `json:"received_at"`
+	Header     http.Header     `json:"header"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// WithWebhooks registers the inbound webhooks, the webhooks with a Job need the jobs of JOBS_WORKERS
+func WithWebhooks(webhooks ...InboundWebhook) Option {
+	return func(server *Server) {
+		server.Webhooks = append(server.Webhooks, webhooks...)
+	}
+}
+
+// initWebhookRoutes registers the routes of the inbound webhooks, without authentication
+func (server *Server) initWebhookRoutes() error {
+	names := map[string]bool{}
+	for _, webhook := range server.Webhooks {
+		switch {
+		case !webhookName.MatchString(webhook.Name):
+			return fmt.Errorf("invalid webhook name %q, expected lower case letters, digits, - and _", webhook.Name)
+		case names[webhook.Name]:
+			return fmt.Errorf("duplicate webhook name: %s", webhook.Name)
+		case webhook.Verifier == nil:
+			return fmt.Errorf("webhook %s has no verifier", webhook.Name)
+		case (webhook.Handler == nil) == (webhook.Job == ""):
+			return fmt.Errorf("webhook %s must have either a handler or a job", webhook.Name)
+		case webhook.Job != "" && server.Jobs == nil:
+			return fmt.Errorf("webhook %s queues jobs, but the jobs are not enabled", webhook.Name)
+		}
+		names[webhook.Name] = true
+		path := fmt.Sprintf("/%s/webhooks/%s", server.ServerConfig.APIPath, webhook.Name)
+		server.Router.HandleFunc(path, server.ReceiveWebhook(webhook)).Methods(http.MethodPost)
+	}
+	return nil
+}
+
+// ReceiveWebhook verifies and validates the deliveries of the inbound webhook, and processes them with its
+// handler or queues them. Processed deliveries are answered with 204 and queued ones with 202.
+func (server *Server) ReceiveWebhook(webhook InboundWebhook) http.HandlerFunc {
+	maxBodySize := webhook.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = maxWebhookBody
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := common.GetLogger(ctx).With("webhook", webhook.Name)
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				ERROR(w, http.StatusRequestEntityTooLarge, err)
+				return
+			}
+			ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		err = webhook.Verifier.Verify(r.Header, body)
+		if err != nil {
+			logger.Warn("Webhook delivery rejected", "error", err)
+			ERROR(w, http.StatusUnauthorized, err)
+			return
+		}
+		var payload any
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		err = decoder.Decode(&payload)
+		if err != nil {
+			ERROR(w, http.StatusBadRequest, fmt.Errorf("invalid webhook payload: %w", err))
+			return
+		}
+		if webhook.Schema != nil {
+			validator := &schemaValidator{document: &OpenAPI{}, open: true}
+			validator.validate("payload", webhook.Schema, payload)
+			if len(validator.problems) > 0 {
+				logger.Warn("Webhook payload does not match the schema", "problems", validator.problems)
+				ERROR(w, http.StatusUnprocessableEntity, fmt.Errorf("payload does not match the schema: %s", strings.Join(validator.problems, "; ")))
+				return
+			}
+		}
+
+		id, err := uuid.NewV4()
+		if err != nil {
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		delivery := &WebhookDelivery{ID: id, Webhook: webhook.Name, ReceivedAt: time.Now().UTC(), Header: r.Header.Clone(), Payload: body}
+		// The credentials of the deliveries are not kept
+		delivery.Header.Del("Authorization")
+		if secretHeaders, ok := webhook.Verifier.(inbound.SecretHeaders); ok {
+			for _, header := range secretHeaders.Headers() {
+				delivery.Header.Del(header)
+			}
+		}
+		if webhook.Handler != nil {
+			err = webhook.Handler(ctx, delivery)
+			if err != nil {
+				logger.Error("Error processing webhook delivery", "delivery", delivery.ID, "error", err)
+				ERROR(w, http.StatusInternalServerError, err)
+				return
+			}
+			logger.Debug("Webhook delivery processed", "delivery", delivery.ID)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		parameters, err := json.Marshal(delivery)
+		if err != nil {
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		job, err := jobs.NewJob(webhook.Job, "", nil, nil, string(parameters))
+		if err == nil {
+			err = server.Jobs.Enqueue(ctx, job)
+		}
+		if err != nil {
+			logger.Error("Error queueing webhook delivery", "delivery", delivery.ID, "error", err)
+			ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		logger.Debug("Webhook delivery queued", "delivery", delivery.ID, "job", job.ID)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/events"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	DATABASE = "database"
+	SYSLOG   = "syslog"
+	HTTP     = "http"
+	KAFKA    = "kafka"
+)
+
+// Entry is an audit record of a mutation, the ID is the ID of the mutation event
+type Entry struct {
+	ID       uuid.UUID       `json:"id"`
+	Time     time.Time       `json:"time"`
+	Action   string          `json:"action"`
+	Resource string          `json:"resource"`
+	ObjectID uuid.UUID       `json:"object_id"`
+	UserID   *uuid.UUID      `json:"user_id,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// Sink is a destination of audit entries
+type Sink interface {
+	Write(ctx context.Context, entry Entry) error
+	Close() error
+}
+
+// NewSinks creates the configured sinks
+func NewSinks(config cfg.Audit, db *gorm.DB) ([]Sink, error) {
+	var sinks []Sink
+	for _, name := range config.Sinks {
+		var sink Sink
+		var err error
+		switch name {
+		case DATABASE:
+			sink = NewDatabaseSink(db)
+		case SYSLOG:
+			sink, err = NewSyslogSink(config.SyslogNetwork, config.SyslogAddress, config.SyslogTag)
+		case HTTP:
+			sink = NewHTTPSink(config.HTTPURL, config.HTTPToken)
+		case KAFKA:
+			sink = NewKafkaSink(config.KafkaBrokers, config.KafkaTopic)
+		default:
+			err = fmt.Errorf("unsupported audit sink: %s", name)
+		}
+		if err != nil {
+			Sinks(sinks).Close()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// Sinks writes every entry to all contained sinks
+type Sinks []Sink
+
+// Write writes the entry to all sinks and returns the joined errors
+func (sinks Sinks) Write(ctx context.Context, entry Entry) error {
+	var errs []error
+	for _, sink := range sinks {
+		err := sink.Write(ctx, entry)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes all sinks and returns the joined errors
+func (sinks Sinks) Close() error {
+	var errs []error
+	for _, sink := range sinks {
+		err := sink.Close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RedactFunc masks the personal data in the JSON of an object of the resource
+type RedactFunc func(resource string, data json.RawMessage) json.RawMessage
+
+// Auditor records the mutation events as audit entries. It is an events.Publisher, when the outbox is
+// enabled failed writes are retried, so sinks may receive an entry more than once.
+type Auditor struct {
+	Sinks       Sinks
+	IncludeData bool
+	Redact      RedactFunc
+}
+
+// NewAuditor creates an auditor forwarding entries to the sinks, the included data is redacted when redact is set
+func NewAuditor(config cfg.Audit, sinks []Sink, redact RedactFunc) *Auditor {
+	slog.Info("Audit log initialized", "sinks", config.Sinks, "data", config.IncludeData)
+	return &Auditor{
+		Sinks:       sinks,
+		IncludeData: config.IncludeData,
+		Redact:      redact,
+	}
+}
+
+// Publish writes the audit entry of the event to all sinks
+func (auditor *Auditor) Publish(ctx context.Context, event events.Event) error {
+	entry := Entry{
+		ID:       event.ID,
+		Time:     event.Time,
+		Action:   event.Action,
+		Resource: event.Resource,
+		ObjectID: event.ObjectID,
+		UserID:   event.UserID,
+	}
+	if auditor.IncludeData {
+		entry.Data = event.Data
+		if auditor.Redact != nil {
+			entry.Data = auditor.Redact(event.Resource, event.Data)
+		}
+	}
+	return auditor.Sinks.Write(ctx, entry)
+}
+
+// Close closes all sinks
+func (auditor *Auditor) Close() error {
+	return auditor.Sinks.Close()
+}
@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LogEntry is a persisted audit entry
+type LogEntry struct {
+	ID       uuid.UUID `gorm:"primaryKey"`
+	Time     time.Time
+	Action   string
+	Resource string
+	ObjectID uuid.UUID
+	UserID   *uuid.UUID
+	Data     *string `gorm:"type:jsonb"`
+}
+
+// TableName returns the audit log table name
+func (e *LogEntry) TableName() string {
+	return "audit_log"
+}
+
+// DatabaseSink stores the entries in the audit_log table
+type DatabaseSink struct {
+	DB *gorm.DB
+}
+
+// NewDatabaseSink creates a sink for the database
+func NewDatabaseSink(db *gorm.DB) *DatabaseSink {
+	return &DatabaseSink{DB: db}
+}
+
+// Write stores the entry, entries already stored are skipped
+func (sink *DatabaseSink) Write(ctx context.Context, entry Entry) error {
+	logEntry := &LogEntry{
+		ID:       entry.ID,
+		Time:     entry.Time,
+		Action:   entry.Action,
+		Resource: entry.Resource,
+		ObjectID: entry.ObjectID,
+		UserID:   entry.UserID,
+	}
+	if len(entry.Data) > 0 {
+		data := string(entry.Data)
+		logEntry.Data = &data
+	}
+	return sink.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(logEntry).Error
+}
+
+// Close does nothing, the database is owned by the server
+func (sink *DatabaseSink) Close() error {
+	return nil
+}
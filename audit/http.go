@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPSink posts the entries as JSON to an HTTP endpoint, e.g. a SIEM collector
+type HTTPSink struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+// NewHTTPSink creates a sink for the endpoint, the token is sent as bearer authorization if set
+func NewHTTPSink(url, token string) *HTTPSink {
+	return &HTTPSink{
+		URL:    url,
+		Token:  token,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Write posts the entry
+func (sink *HTTPSink) Write(ctx context.Context, entry Entry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if sink.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+sink.Token)
+	}
+	response, err := sink.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusMultipleChoices {
+		details, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("audit endpoint rejected entry with status %d: %s", response.StatusCode, details)
+	}
+	return nil
+}
+
+// Close does nothing, the HTTP client keeps no state to release
+func (sink *HTTPSink) Close() error {
+	return nil
+}
@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaSink produces the entries as JSON messages to a Kafka topic, keyed by object ID so that
+// the entries of an object keep their order
+type KafkaSink struct {
+	Writer *kafka.Writer
+}
+
+// NewKafkaSink creates a producer for the topic
+func NewKafkaSink(brokers []string, topic string) *KafkaSink {
+	return &KafkaSink{
+		Writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}
+}
+
+// Write produces the entry and waits for the acknowledgement of all in-sync replicas
+func (sink *KafkaSink) Write(ctx context.Context, entry Entry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return sink.Writer.WriteMessages(ctx, kafka.Message{
+		Key:   entry.ObjectID.Bytes(),
+		Value: value,
+	})
+}
+
+// Close flushes and closes the producer
+func (sink *KafkaSink) Close() error {
+	return sink.Writer.Close()
+}
@@ -0,0 +1,37 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogSink sends the entries as JSON messages to syslog
+type SyslogSink struct {
+	Writer *syslog.Writer
+}
+
+// NewSyslogSink connects to the syslog server, empty network and address use the local syslog daemon
+func NewSyslogSink(network, address, tag string) (Sink, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{Writer: writer}, nil
+}
+
+// Write sends the entry
+func (sink *SyslogSink) Write(ctx context.Context, entry Entry) error {
+	message, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return sink.Writer.Info(string(message))
+}
+
+// Close closes the connection
+func (sink *SyslogSink) Close() error {
+	return sink.Writer.Close()
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Nerzal/gocloak/v14/pkg/jwx"
+)
+
+// Authentication is how and when the user of a token authenticated, for the step-up authentication
+type Authentication struct {
+	// ACR is the authentication context class, e.g. the level of authentication of Keycloak
+	ACR string
+	// AMR are the authentication methods, e.g. pwd and otp
+	AMR []string
+	// AuthTime is when the user authenticated, zero when it is not known
+	AuthTime time.Time
+}
+
+// AuthenticationReader returns the authentication of the tokens, from their acr, amr and auth_time claims
+type AuthenticationReader interface {
+	Authentication(ctx context.Context, accessToken string) (*Authentication, error)
+}
+
+// authenticationClaims are the claims of the token with the authentication methods, that Keycloak adds with the
+// authentication method reference mapper
+type authenticationClaims struct {
+	jwx.Claims
+	AMR []string `json:"amr,omitempty"`
+}
+
+// Authentication returns the acr, amr and auth_time claims of the token
+func (authClient *KeycloakClient) Authentication(ctx context.Context, accessToken string) (*Authentication, error) {
+	claims := &authenticationClaims{}
+	err := authClient.call(ctx, func() error {
+		_, err := authClient.Client.DecodeAccessTokenCustomClaims(ctx, accessToken, authClient.Realm, claims)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	authentication := &Authentication{ACR: claims.Acr, AMR: claims.AMR}
+	if claims.AuthTime > 0 {
+		authentication.AuthTime = time.Unix(int64(claims.AuthTime), 0)
+	}
+	return authentication, nil
+}
+
+// Authentication delegates to the wrapped client, errors.ErrUnsupported is returned when it does not know the
+// authentication of the tokens
+func (authClient *CachedClient) Authentication(ctx context.Context, accessToken string) (*Authentication, error) {
+	reader, ok := authClient.Client.(AuthenticationReader)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return reader.Authentication(ctx, accessToken)
+}
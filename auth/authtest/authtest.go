@@ -0,0 +1,193 @@
+package authtest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/auth"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	RETROSPECT = "RetrospectToken"
+	ROLES      = "GetRolesFromToken"
+	USER       = "GetUserFromToken"
+	EXCHANGE   = "ExchangeToken"
+	ISSUED_AT  = "IssuedAt"
+	// AUTHENTICATION is the method returning how the user of the token authenticated
+	AUTHENTICATION = "Authentication"
+)
+
+// ErrInvalidToken is returned for tokens that are not known to the client
+var ErrInvalidToken = errors.New("invalid token")
+
+var (
+	_ auth.Client               = (*Client)(nil)
+	_ auth.TokenExchanger       = (*Client)(nil)
+	_ auth.TokenIssuer          = (*Client)(nil)
+	_ auth.AuthenticationReader = (*Client)(nil)
+)
+
+// Identity is the user and the roles of a token
+type Identity struct {
+	User  domain.User
+	Roles []string
+	// IssuedAt is the time the token is set
+	IssuedAt time.Time
+	// Authentication is how the user authenticated, with a password when the token is set unless it is changed
+	Authentication auth.Authentication
+}
+
+// Client is a fake auth.Client with static tokens, it is safe for concurrent use
+type Client struct {
+	mutex    sync.RWMutex
+	tokens   map[string]Identity
+	failures map[string]error
+	calls    map[string]int
+}
+
+// New creates a client without tokens
+func New() *Client {
+	return &Client{
+		tokens:   map[string]Identity{},
+		failures: map[string]error{},
+		calls:    map[string]int{},
+	}
+}
+
+// NewUser creates a user with a random ID and the username, and returns it together with its token
+func (client *Client) NewUser(username string, roles ...string) (*domain.User, string) {
+	user := &domain.User{
+		Base:             domain.Base{ID: uuid.Must(uuid.NewV4())},
+		PreferedUserName: username,
+		GivenName:        username,
+		Email:            username + "@example.com",
+	}
+	return user, client.AddUser(user, roles...)
+}
+
+// AddUser registers a random token for the user with the roles and returns it
+func (client *Client) AddUser(user *domain.User, roles ...string) string {
+	token := uuid.Must(uuid.NewV4()).String()
+	client.SetToken(token, user, roles...)
+	return token
+}
+
+// SetToken maps the token to the user with the roles
+func (client *Client) SetToken(token string, user *domain.User, roles ...string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	now := time.Now()
+	client.tokens[token] = Identity{User: *user, Roles: append([]string(nil), roles...), IssuedAt: now, Authentication: auth.Authentication{AMR: []string{"pwd"}, AuthTime: now}}
+}
+
+// SetAuthentication sets how the user of the token authenticated, e.g. auth.Authentication{ACR: "2", AMR:
+// []string{"pwd", "otp"}, AuthTime: time.Now()} after a step-up
+func (client *Client) SetAuthentication(token string, authentication auth.Authentication) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	identity, ok := client.tokens[token]
+	if !ok {
+		return
+	}
+	identity.Authentication = authentication
+	client.tokens[token] = identity
+}
+
+// Revoke removes the token, it is rejected afterwards
+func (client *Client) Revoke(token string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	delete(client.tokens, token)
+}
+
+// Fail makes the method, RETROSPECT, ROLES, USER or EXCHANGE, return the error, nil restores the normal behavior
+func (client *Client) Fail(method string, err error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if err == nil {
+		delete(client.failures, method)
+		return
+	}
+	client.failures[method] = err
+}
+
+// Calls returns how many times the method was called
+func (client *Client) Calls(method string) int {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.calls[method]
+}
+
+// RetrospectToken accepts the registered tokens
+func (client *Client) RetrospectToken(ctx context.Context, accessToken string) error {
+	_, err := client.identity(RETROSPECT, accessToken)
+	return err
+}
+
+// GetRolesFromToken returns the roles of the token
+func (client *Client) GetRolesFromToken(ctx context.Context, accessToken string) ([]string, error) {
+	identity, err := client.identity(ROLES, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return identity.Roles, nil
+}
+
+// GetUserFromToken returns a copy of the user of the token
+func (client *Client) GetUserFromToken(ctx context.Context, accessToken string) (*domain.User, error) {
+	identity, err := client.identity(USER, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	user := identity.User
+	return &user, nil
+}
+
+// IssuedAt returns the time the token is set
+func (client *Client) IssuedAt(ctx context.Context, accessToken string) (time.Time, error) {
+	identity, err := client.identity(ISSUED_AT, accessToken)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return identity.IssuedAt, nil
+}
+
+// Authentication returns how the user of the token authenticated
+func (client *Client) Authentication(ctx context.Context, accessToken string) (*auth.Authentication, error) {
+	identity, err := client.identity(AUTHENTICATION, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	authentication := identity.Authentication
+	return &authentication, nil
+}
+
+// ExchangeToken returns the token of the audience for the identity of the token, e.g. orders:<token>,
+// the exchanged tokens expire in 5 minutes
+func (client *Client) ExchangeToken(ctx context.Context, subjectToken, audience string) (*auth.Token, error) {
+	_, err := client.identity(EXCHANGE, subjectToken)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Token{AccessToken: audience + ":" + subjectToken, ExpiresAt: time.Now().Add(5 * time.Minute)}, nil
+}
+
+// identity counts the call and resolves the token unless a failure is injected for the method
+func (client *Client) identity(method, accessToken string) (Identity, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.calls[method]++
+	if err, ok := client.failures[method]; ok {
+		return Identity{}, err
+	}
+	identity, ok := client.tokens[accessToken]
+	if !ok {
+		return Identity{}, ErrInvalidToken
+	}
+	identity.Roles = append([]string(nil), identity.Roles...)
+	return identity, nil
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/dzahariev/respite/cache"
+	"github.com/dzahariev/respite/domain"
+)
+
+// CachedClient remembers successful token introspections, so that the identity provider is not called on every request
+type CachedClient struct {
+	Client
+	Cache cache.Cache
+	TTL   time.Duration
+}
+
+// NewCachedClient wraps the client with an introspection cache
+func NewCachedClient(client Client, tokenCache cache.Cache, ttl time.Duration) Client {
+	return &CachedClient{
+		Client: client,
+		Cache:  tokenCache,
+		TTL:    ttl,
+	}
+}
+
+// RetrospectToken verifies the token with the wrapped client unless it is verified recently, inactive tokens are not cached
+func (authClient *CachedClient) RetrospectToken(ctx context.Context, accessToken string) error {
+	key := tokenKey(accessToken)
+	_, ok, err := authClient.Cache.Get(ctx, key)
+	if err != nil {
+		slog.Error("Error reading token cache", "error", err)
+	}
+	if ok {
+		return nil
+	}
+	err = authClient.Client.RetrospectToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	err = authClient.Cache.Set(ctx, key, []byte{1}, authClient.TTL)
+	if err != nil {
+		slog.Error("Error writing token cache", "error", err)
+	}
+	return nil
+}
+
+// GetRolesFromToken delegates to the wrapped client
+func (authClient *CachedClient) GetRolesFromToken(ctx context.Context, accessToken string) ([]string, error) {
+	return authClient.Client.GetRolesFromToken(ctx, accessToken)
+}
+
+// GetUserFromToken delegates to the wrapped client
+func (authClient *CachedClient) GetUserFromToken(ctx context.Context, accessToken string) (*domain.User, error) {
+	return authClient.Client.GetUserFromToken(ctx, accessToken)
+}
+
+// tokenKey derives the cache key from the token hash, tokens are never stored
+func tokenKey(accessToken string) string {
+	hash := sha256.Sum256([]byte(accessToken))
+	return "token:" + hex.EncodeToString(hash[:])
+}
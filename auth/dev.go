@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+// DevUserID is the ID of the user of the DevClient
+var DevUserID = uuid.Must(uuid.FromString("00000000-0000-4000-8000-000000000001"))
+
+// DevClient accepts any token as the development user with all given roles, it must not be used in production
+type DevClient struct {
+	User  domain.User
+	Roles []string
+}
+
+// NewDevClient creates the client for the development user with the roles
+func NewDevClient(roles ...string) *DevClient {
+	return &DevClient{
+		User: domain.User{
+			Base:             domain.Base{ID: DevUserID},
+			PreferedUserName: "developer",
+			GivenName:        "Local",
+			FamilyName:       "Developer",
+			Email:            "developer@localhost",
+		},
+		Roles: roles,
+	}
+}
+
+// RetrospectToken accepts every token
+func (authClient *DevClient) RetrospectToken(ctx context.Context, accessToken string) error {
+	return nil
+}
+
+// GetRolesFromToken returns all roles
+func (authClient *DevClient) GetRolesFromToken(ctx context.Context, accessToken string) ([]string, error) {
+	return authClient.Roles, nil
+}
+
+// GetUserFromToken returns the development user
+func (authClient *DevClient) GetUserFromToken(ctx context.Context, accessToken string) (*domain.User, error) {
+	user := authClient.User
+	return &user, nil
+}
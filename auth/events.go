@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Nerzal/gocloak/v14"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// Resource types of the admin events
+	RESOURCE_USER                = "USER"
+	RESOURCE_REALM_ROLE_MAPPING  = "REALM_ROLE_MAPPING"
+	RESOURCE_CLIENT_ROLE_MAPPING = "CLIENT_ROLE_MAPPING"
+	RESOURCE_GROUP_MEMBERSHIP    = "GROUP_MEMBERSHIP"
+
+	// Operation types of the admin events
+	OPERATION_CREATE = "CREATE"
+	OPERATION_UPDATE = "UPDATE"
+	OPERATION_DELETE = "DELETE"
+	OPERATION_ACTION = "ACTION"
+
+	// adminEventsPage is the number of admin events read at once
+	adminEventsPage = 100
+)
+
+// ErrUserNotFound is returned by GetUser for the IDs that are not users of the realm
+var ErrUserNotFound = errors.New("user not found")
+
+// AdminEvent is a change made in the administration of the realm, as returned by the admin API and as sent by
+// the webhooks of the keycloak-events extension
+type AdminEvent struct {
+	// Time is the time of the change in milliseconds since the epoch
+	Time          int64  `json:"time"`
+	RealmID       string `json:"realmId,omitempty"`
+	ResourceType  string `json:"resourceType"`
+	OperationType string `json:"operationType"`
+	// ResourcePath is the path of the changed resource, e.g. users/<id>/role-mappings/realm
+	ResourcePath string `json:"resourcePath"`
+	// Representation is the JSON of the changed resource, when the realm includes it in the admin events
+	Representation string         `json:"representation,omitempty"`
+	AuthDetails    AdminEventAuth `json:"authDetails"`
+}
+
+// AdminEventAuth is the administrator that made the change
+type AdminEventAuth struct {
+	RealmID string `json:"realmId,omitempty"`
+	UserID  string `json:"userId,omitempty"`
+}
+
+// Timestamp returns the time of the change
+func (event AdminEvent) Timestamp() time.Time {
+	return time.UnixMilli(event.Time)
+}
+
+// UserID returns the ID of the user of the events of the users, their role mappings and group memberships
+func (event AdminEvent) UserID() (uuid.UUID, bool) {
+	parts := strings.Split(event.ResourcePath, "/")
+	if len(parts) < 2 || parts[0] != "users" {
+		return uuid.Nil, false
+	}
+	id, err := uuid.FromString(parts[1])
+	return id, err == nil
+}
+
+// UserRepresentation is the user of the representation of the events of the users
+type UserRepresentation struct {
+	Username  string `json:"username"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Enabled   *bool  `json:"enabled,omitempty"`
+}
+
+// User decodes the representation of the events of the users, false is returned without a representation
+func (event AdminEvent) User() (*UserRepresentation, bool) {
+	if event.Representation == "" {
+		return nil, false
+	}
+	representation := &UserRepresentation{}
+	err := json.Unmarshal([]byte(event.Representation), representation)
+	return representation, err == nil
+}
+
+// Disabled checks if the user cannot log in anymore
+func (representation *UserRepresentation) Disabled() bool {
+	return representation.Enabled != nil && !*representation.Enabled
+}
+
+// Apply copies the profile of the representation to the user
+func (representation *UserRepresentation) Apply(user *domain.User) {
+	user.PreferedUserName = representation.Username
+	user.GivenName = representation.FirstName
+	user.FamilyName = representation.LastName
+	user.Email = representation.Email
+}
+
+// AdminEventSource reads the admin events of the realm and the users they change, e.g. from the admin API
+type AdminEventSource interface {
+	AdminEvents(ctx context.Context, since time.Time) ([]AdminEvent, error)
+	GetUser(ctx context.Context, id uuid.UUID) (*UserRepresentation, error)
+}
+
+// TokenIssuer returns the time the tokens are issued at, the tokens issued before a revocation are rejected
+type TokenIssuer interface {
+	IssuedAt(ctx context.Context, accessToken string) (time.Time, error)
+}
+
+// AdminEvents returns the admin events of the users, their role mappings and group memberships after since, the
+// oldest first. The client needs the view-events role of realm-management and the realm must save admin events.
+func (authClient *KeycloakClient) AdminEvents(ctx context.Context, since time.Time) ([]AdminEvent, error) {
+	token, err := authClient.ServiceToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The admin API filters by the day, the earlier events of the day are skipped here
+	params := gocloak.GetAdminEventsParams{
+		DateFrom:      gocloak.StringP(since.UTC().Format(time.DateOnly)),
+		Max:           gocloak.Int32P(adminEventsPage),
+		ResourceTypes: []string{RESOURCE_USER, RESOURCE_REALM_ROLE_MAPPING, RESOURCE_CLIENT_ROLE_MAPPING, RESOURCE_GROUP_MEMBERSHIP},
+	}
+	var events []AdminEvent
+	for first := int32(0); ; first += adminEventsPage {
+		params.First = gocloak.Int32P(first)
+		var page []*gocloak.AdminEventRepresentation
+		err = authClient.call(ctx, func() (err error) {
+			page, err = authClient.Client.GetAdminEvents(ctx, token, authClient.Realm, params)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("admin events of realm %s are not available: %w", authClient.Realm, err)
+		}
+		// The newest events come first, the pages stop at the events already read
+		older := false
+		for _, representation := range page {
+			if representation.Time <= since.UnixMilli() {
+				older = true
+				continue
+			}
+			event := AdminEvent{
+				Time:           representation.Time,
+				RealmID:        gocloak.PString(representation.RealmID),
+				ResourceType:   gocloak.PString(representation.ResourceType),
+				OperationType:  gocloak.PString(representation.OperationType),
+				ResourcePath:   gocloak.PString(representation.ResourcePath),
+				Representation: gocloak.PString(representation.Representation),
+			}
+			if representation.AuthDetails != nil {
+				event.AuthDetails = AdminEventAuth{
+					RealmID: gocloak.PString(representation.AuthDetails.RealmID),
+					UserID:  gocloak.PString(representation.AuthDetails.UserID),
+				}
+			}
+			events = append(events, event)
+		}
+		if older || len(page) < adminEventsPage {
+			break
+		}
+	}
+	slices.Reverse(events)
+	return events, nil
+}
+
+// GetUser reads the user with the admin API, the client needs the view-users role of realm-management.
+// ErrUserNotFound is returned for the IDs of no user.
+func (authClient *KeycloakClient) GetUser(ctx context.Context, id uuid.UUID) (*UserRepresentation, error) {
+	token, err := authClient.ServiceToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var user *gocloak.User
+	err = authClient.call(ctx, func() (err error) {
+		user, err = authClient.Client.GetUserByID(ctx, token, authClient.Realm, id.String())
+		return err
+	})
+	var apiError *gocloak.APIError
+	if errors.As(err, &apiError) && apiError.Code == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &UserRepresentation{
+		Username:  gocloak.PString(user.Username),
+		FirstName: gocloak.PString(user.FirstName),
+		LastName:  gocloak.PString(user.LastName),
+		Email:     gocloak.PString(user.Email),
+		Enabled:   user.Enabled,
+	}, nil
+}
+
+// IssuedAt returns the iat claim of the token
+func (authClient *KeycloakClient) IssuedAt(ctx context.Context, accessToken string) (time.Time, error) {
+	jwxClaims, err := authClient.decode(ctx, accessToken)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if jwxClaims.IssuedAt == nil {
+		return time.Time{}, errors.New("token has no issue time")
+	}
+	return jwxClaims.IssuedAt.Time, nil
+}
+
+// IssuedAt delegates to the wrapped client, errors.ErrUnsupported is returned when it does not know the times
+func (authClient *CachedClient) IssuedAt(ctx context.Context, accessToken string) (time.Time, error) {
+	issuer, ok := authClient.Client.(TokenIssuer)
+	if !ok {
+		return time.Time{}, errors.ErrUnsupported
+	}
+	return issuer.IssuedAt(ctx, accessToken)
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Nerzal/gocloak/v14"
+	"github.com/dzahariev/respite/cache"
+)
+
+const (
+	// tokenExchangeGrant is the grant type of RFC 8693 token exchange
+	tokenExchangeGrant = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// accessTokenType is the type of the requested tokens, Keycloak expects access tokens as subject tokens
+	accessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+	// exchangeMargin is the time before the expiry at which exchanged and service tokens are not used anymore
+	exchangeMargin = 30 * time.Second
+)
+
+type contextKey string
+
+const delegationKey contextKey = "DelegationKey"
+
+// ErrNoDelegation is returned when the context has no token of an authenticated caller to exchange
+var ErrNoDelegation = errors.New("no token of the caller to exchange")
+
+// Token is an access token with its expiry
+type Token struct {
+	AccessToken string
+	ExpiresAt   time.Time
+}
+
+// TokenExchanger exchanges the token of the caller for a token of the audience, so that downstream APIs are
+// called on behalf of the caller
+type TokenExchanger interface {
+	ExchangeToken(ctx context.Context, subjectToken, audience string) (*Token, error)
+}
+
+// ExchangeToken exchanges the token for an access token of the audience with RFC 8693 token exchange, the
+// client must be allowed to exchange tokens for the audience in Keycloak
+func (authClient *KeycloakClient) ExchangeToken(ctx context.Context, subjectToken, audience string) (*Token, error) {
+	authClient.mutex.RLock()
+	clientSecret := authClient.ClientSecret
+	authClient.mutex.RUnlock()
+	var jwt *gocloak.JWT
+	err := authClient.call(ctx, func() (err error) {
+		jwt, err = authClient.Client.GetToken(ctx, authClient.Realm, gocloak.TokenOptions{
+			ClientID:           gocloak.StringP(authClient.ClientID),
+			ClientSecret:       gocloak.StringP(clientSecret),
+			GrantType:          gocloak.StringP(tokenExchangeGrant),
+			SubjectToken:       gocloak.StringP(subjectToken),
+			RequestedTokenType: gocloak.StringP(accessTokenType),
+			Audience:           gocloak.StringP(audience),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot exchange token for %s: %w", audience, err)
+	}
+	return &Token{AccessToken: jwt.AccessToken, ExpiresAt: time.Now().Add(time.Duration(jwt.ExpiresIn) * time.Second)}, nil
+}
+
+// ExchangeToken returns the token itself, the development user calls the downstream APIs with any token
+func (authClient *DevClient) ExchangeToken(ctx context.Context, subjectToken, audience string) (*Token, error) {
+	return &Token{AccessToken: subjectToken, ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+// CachedExchanger remembers the exchanged tokens per caller and audience until shortly before they or the token of
+// the caller expire. The tokens are encrypted with a key derived from the token of the caller, so that the readers
+// of a shared cache cannot use them.
+type CachedExchanger struct {
+	TokenExchanger
+	Cache cache.Cache
+}
+
+// NewCachedExchanger wraps the exchanger with a cache of the exchanged tokens
+func NewCachedExchanger(exchanger TokenExchanger, tokenCache cache.Cache) *CachedExchanger {
+	return &CachedExchanger{
+		TokenExchanger: exchanger,
+		Cache:          tokenCache,
+	}
+}
+
+// ExchangeToken returns the cached token of the audience or exchanges the token with the wrapped exchanger,
+// tokens that expire within the margin are not cached
+func (exchanger *CachedExchanger) ExchangeToken(ctx context.Context, subjectToken, audience string) (*Token, error) {
+	key := exchangeKey(subjectToken, audience)
+	value, ok, err := exchanger.Cache.Get(ctx, key)
+	if err != nil {
+		slog.Error("Error reading exchanged token cache", "error", err)
+	}
+	if ok {
+		var token Token
+		plaintext, err := openExchanged(subjectToken, value)
+		if err == nil && json.Unmarshal(plaintext, &token) == nil {
+			return &token, nil
+		}
+	}
+	token, err := exchanger.TokenExchanger.ExchangeToken(ctx, subjectToken, audience)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := token.ExpiresAt
+	if subjectExpiry, ok := tokenExpiry(subjectToken); ok && subjectExpiry.Before(expiresAt) {
+		expiresAt = subjectExpiry
+	}
+	ttl := time.Until(expiresAt) - exchangeMargin
+	if ttl > 0 {
+		plaintext, _ := json.Marshal(token)
+		value, err = sealExchanged(subjectToken, plaintext)
+		if err == nil {
+			err = exchanger.Cache.Set(ctx, key, value, ttl)
+		}
+		if err != nil {
+			slog.Error("Error writing exchanged token cache", "error", err)
+		}
+	}
+	return token, nil
+}
+
+// exchangeKey derives the cache key from the HMAC of the audience keyed by the token of the caller
+func exchangeKey(subjectToken, audience string) string {
+	return "exchange:" + hex.EncodeToString(exchangeHMAC(subjectToken, "key:"+audience))
+}
+
+// exchangeHMAC derives the values of the cache of the exchanged tokens from the token of the caller, the cache
+// keys and the encryption key are derived with different labels
+func exchangeHMAC(subjectToken, label string) []byte {
+	mac := hmac.New(sha256.New, []byte(subjectToken))
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// exchangeCipher is the AES-GCM cipher of the exchanged tokens of the caller
+func exchangeCipher(subjectToken string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(exchangeHMAC(subjectToken, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealExchanged encrypts the exchanged token, the nonce is prepended to the ciphertext
+func sealExchanged(subjectToken string, plaintext []byte) ([]byte, error) {
+	aead, err := exchangeCipher(subjectToken)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openExchanged decrypts the exchanged token sealed by sealExchanged
+func openExchanged(subjectToken string, ciphertext []byte) ([]byte, error) {
+	aead, err := exchangeCipher(subjectToken)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("invalid exchanged token ciphertext")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// tokenExpiry reads the exp claim of the JWT without verifying it, the token of the caller is verified by the
+// authentication already
+func tokenExpiry(accessToken string) (time.Time, bool) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// delegation is the token of the authenticated caller and the exchanger of the server
+type delegation struct {
+	exchanger TokenExchanger
+	token     string
+}
+
+// NewDelegationContext returns a context carrying the token of the caller, which is exchanged by DelegatedToken
+func NewDelegationContext(ctx context.Context, exchanger TokenExchanger, accessToken string) context.Context {
+	return context.WithValue(ctx, delegationKey, delegation{exchanger: exchanger, token: accessToken})
+}
+
+// DelegatedToken returns an access token of the audience on behalf of the caller of the context, e.g. in handlers
+// and hooks calling downstream APIs. ErrNoDelegation is returned outside of authenticated requests.
+func DelegatedToken(ctx context.Context, audience string) (string, error) {
+	value, ok := ctx.Value(delegationKey).(delegation)
+	if !ok || value.exchanger == nil || value.token == "" {
+		return "", ErrNoDelegation
+	}
+	token, err := value.exchanger.ExchangeToken(ctx, value.token, audience)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// DelegatedTransport sets the authorization header of the requests to the delegated token of the audience for the
+// caller of the request context, the requests fail if the token cannot be exchanged
+func DelegatedTransport(audience string, transport http.RoundTripper) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return roundTripper(func(r *http.Request) (*http.Response, error) {
+		token, err := DelegatedToken(r.Context(), audience)
+		if err != nil {
+			return nil, err
+		}
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+token)
+		return transport.RoundTrip(r)
+	})
+}
+
+// roundTripper is a function that implements http.RoundTripper
+type roundTripper func(r *http.Request) (*http.Response, error)
+
+// RoundTrip calls the function
+func (fn roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
@@ -3,9 +3,14 @@ package auth
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/Nerzal/gocloak/v14"
 	"github.com/Nerzal/gocloak/v14/pkg/jwx"
+	"github.com/dzahariev/respite/breaker"
 	"github.com/dzahariev/respite/cfg"
 	"github.com/dzahariev/respite/domain"
 	"github.com/gofrs/uuid/v5"
@@ -17,25 +22,70 @@ type KeycloakClient struct {
 	Realm        string
 	ClientID     string
 	ClientSecret string
+	// Retries is how many times the calls failing transiently are repeated, after Backoff doubled for each retry
+	Retries int
+	Backoff time.Duration
+	// Breaker stops the calls while Keycloak is down, nil disables it
+	Breaker      *breaker.Breaker
+	mutex        sync.RWMutex
+	tokenMutex   sync.Mutex
+	serviceToken *Token
 }
 
 // NewClient is used to init a client for Keycloak authentication
 func NewClient(cfg cfg.Keycloak) Client {
-	return &KeycloakClient{
+	client := &KeycloakClient{
 		Client:       gocloak.NewClient(cfg.AuthURL),
 		URL:          cfg.AuthURL,
 		Realm:        cfg.AuthRealm,
 		ClientID:     cfg.AuthClientID,
 		ClientSecret: cfg.AuthClientSecret,
+		Retries:      cfg.AuthRetries,
+		Backoff:      cfg.AuthRetryBackoff,
 	}
+	if cfg.AuthBreakerFailures > 0 {
+		client.Breaker = breaker.New("keycloak", cfg.AuthBreakerFailures, cfg.AuthBreakerTimeout)
+	}
+	return client
+}
+
+// WrapTransport replaces the transport used for the calls to Keycloak with the wrapped one
+func (authClient *KeycloakClient) WrapTransport(wrap func(http.RoundTripper) http.RoundTripper) {
+	restyClient := authClient.Client.RestyClient()
+	transport := restyClient.GetClient().Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	restyClient.SetTransport(wrap(transport))
+}
+
+// SetClientSecret replaces the client secret, it is used when the secret is rotated and reports if it has changed
+func (authClient *KeycloakClient) SetClientSecret(clientSecret string) bool {
+	authClient.mutex.Lock()
+	defer authClient.mutex.Unlock()
+	changed := authClient.ClientSecret != clientSecret
+	authClient.ClientSecret = clientSecret
+	if changed {
+		authClient.tokenMutex.Lock()
+		authClient.serviceToken = nil
+		authClient.tokenMutex.Unlock()
+	}
+	return changed
 }
 
 func (authClient *KeycloakClient) RetrospectToken(ctx context.Context, accessToken string) error {
-	rptResult, err := authClient.Client.RetrospectToken(ctx, accessToken, authClient.ClientID, authClient.ClientSecret, authClient.Realm)
+	authClient.mutex.RLock()
+	clientSecret := authClient.ClientSecret
+	authClient.mutex.RUnlock()
+	var rptResult *gocloak.IntroSpectTokenResult
+	err := authClient.call(ctx, func() (err error) {
+		rptResult, err = authClient.Client.RetrospectToken(ctx, accessToken, authClient.ClientID, clientSecret, authClient.Realm)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	if !*rptResult.Active {
+	if rptResult.Active == nil || !*rptResult.Active {
 		return errors.New("token is not active")
 	}
 
@@ -43,8 +93,7 @@ func (authClient *KeycloakClient) RetrospectToken(ctx context.Context, accessTok
 }
 
 func (authClient *KeycloakClient) GetRolesFromToken(ctx context.Context, accessToken string) ([]string, error) {
-	jwxClaims := &jwx.Claims{}
-	_, err := authClient.Client.DecodeAccessTokenCustomClaims(ctx, accessToken, authClient.Realm, jwxClaims)
+	jwxClaims, err := authClient.decode(ctx, accessToken)
 	if err != nil {
 		result := make([]string, 0)
 		return result, err
@@ -54,8 +103,7 @@ func (authClient *KeycloakClient) GetRolesFromToken(ctx context.Context, accessT
 
 // GetUserFromToken creates user entity from user info in token
 func (authClient *KeycloakClient) GetUserFromToken(ctx context.Context, accessToken string) (*domain.User, error) {
-	jwxClaims := &jwx.Claims{}
-	_, err := authClient.Client.DecodeAccessTokenCustomClaims(ctx, accessToken, authClient.Realm, jwxClaims)
+	jwxClaims, err := authClient.decode(ctx, accessToken)
 	if err != nil {
 		return nil, err
 	}
@@ -77,3 +125,63 @@ func (authClient *KeycloakClient) GetUserFromToken(ctx context.Context, accessTo
 
 	return user, nil
 }
+
+// decode verifies the signature of the token and returns its claims, the signing keys are fetched when they are
+// not known yet
+func (authClient *KeycloakClient) decode(ctx context.Context, accessToken string) (*jwx.Claims, error) {
+	jwxClaims := &jwx.Claims{}
+	err := authClient.call(ctx, func() error {
+		_, err := authClient.Client.DecodeAccessTokenCustomClaims(ctx, accessToken, authClient.Realm, jwxClaims)
+		return err
+	})
+	return jwxClaims, err
+}
+
+// ServiceToken returns the access token of the client itself, obtained with the client credentials grant, for
+// the calls of the service to other services. The token is kept until shortly before it expires.
+func (authClient *KeycloakClient) ServiceToken(ctx context.Context) (string, error) {
+	authClient.tokenMutex.Lock()
+	defer authClient.tokenMutex.Unlock()
+	if authClient.serviceToken != nil && time.Until(authClient.serviceToken.ExpiresAt) > exchangeMargin {
+		return authClient.serviceToken.AccessToken, nil
+	}
+	authClient.mutex.RLock()
+	clientSecret := authClient.ClientSecret
+	authClient.mutex.RUnlock()
+	var jwt *gocloak.JWT
+	err := authClient.call(ctx, func() (err error) {
+		jwt, err = authClient.Client.LoginClient(ctx, authClient.ClientID, clientSecret, authClient.Realm)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("client %s cannot log in: %w", authClient.ClientID, err)
+	}
+	authClient.serviceToken = &Token{AccessToken: jwt.AccessToken, ExpiresAt: time.Now().Add(time.Duration(jwt.ExpiresIn) * time.Second)}
+	return jwt.AccessToken, nil
+}
+
+// FetchKeys loads the signing keys of the realm, which are kept for the verification of the tokens, so that the
+// first request does not wait for them
+func (authClient *KeycloakClient) FetchKeys(ctx context.Context) error {
+	_, err := authClient.Client.GetCerts(ctx, authClient.Realm)
+	if err != nil {
+		return fmt.Errorf("keys of realm %s are not available: %w", authClient.Realm, err)
+	}
+	return nil
+}
+
+// Check verifies that the realm is reachable and that the client credentials are accepted
+func (authClient *KeycloakClient) Check(ctx context.Context) error {
+	_, err := authClient.Client.GetIssuer(ctx, authClient.Realm)
+	if err != nil {
+		return fmt.Errorf("realm %s is not available: %w", authClient.Realm, err)
+	}
+	authClient.mutex.RLock()
+	clientSecret := authClient.ClientSecret
+	authClient.mutex.RUnlock()
+	_, err = authClient.Client.LoginClient(ctx, authClient.ClientID, clientSecret, authClient.Realm)
+	if err != nil {
+		return fmt.Errorf("client %s cannot log in: %w", authClient.ClientID, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/Nerzal/gocloak/v14"
+)
+
+// ErrUnavailable is returned when the identity provider cannot be reached, the callers are not rejected as
+// unauthorized for it
+var ErrUnavailable = errors.New("identity provider unavailable")
+
+// Transient checks if the call failed because the identity provider was not reachable or overloaded, i.e. with a
+// network error or with 429 or 5xx, rather than because of the token
+func Transient(err error) bool {
+	var apiError *gocloak.APIError
+	if !errors.As(err, &apiError) {
+		return false
+	}
+	return apiError.Code == 0 || apiError.Code == http.StatusTooManyRequests || apiError.Code >= http.StatusInternalServerError
+}
+
+// call runs the call to Keycloak, repeating it with a jittered exponential backoff when it fails transiently.
+// The calls fail with ErrUnavailable when the retries are exhausted or the breaker is open.
+func (authClient *KeycloakClient) call(ctx context.Context, call func() error) error {
+	if authClient.Breaker != nil && !authClient.Breaker.Allow() {
+		return fmt.Errorf("%w: %w", ErrUnavailable, authClient.Breaker.Err())
+	}
+	for attempt := 0; ; attempt++ {
+		err := call()
+		transient := Transient(err)
+		if !transient || attempt >= authClient.Retries || ctx.Err() != nil {
+			if authClient.Breaker != nil {
+				authClient.Breaker.Record(transient)
+			}
+			if transient {
+				return fmt.Errorf("%w: %w", ErrUnavailable, err)
+			}
+			return err
+		}
+		// The jitter spreads the retries of the concurrent requests
+		delay := authClient.Backoff << attempt
+		delay = delay/2 + rand.N(delay+1)
+		slog.Debug("Repeating identity provider call", "attempt", attempt+1, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if authClient.Breaker != nil {
+				authClient.Breaker.Record(true)
+			}
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		case <-timer.C:
+		}
+	}
+}
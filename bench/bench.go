@@ -0,0 +1,451 @@
+// Package bench benchmarks the hot paths of the REST API against a seeded database, so that the changes affecting
+// the performance, e.g. of the resources, the middleware or the queries, are compared with a baseline report.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/dzahariev/respite/api"
+	"github.com/dzahariev/respite/auth/authtest"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/fake"
+	"github.com/gofrs/uuid/v5"
+	"github.com/sethvargo/go-envconfig"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// categories is the number of the seeded categories that the items refer to
+const categories = 10
+
+// Config is the configuration of a benchmark run
+type Config struct {
+	// Objects is the number of the seeded items
+	Objects int
+	// Seed makes the seeded data the same in every run
+	Seed uint64
+	// Database is the PostgreSQL database of the run, its benchmark tables are emptied and seeded. An empty host is
+	// an in-memory SQLite database.
+	Database cfg.DataBase
+}
+
+// Report holds the results of a run with its environment, the results of other environments are not comparable
+type Report struct {
+	GoVersion string   `json:"go_version"`
+	OS        string   `json:"os"`
+	Arch      string   `json:"arch"`
+	CPUs      int      `json:"cpus"`
+	Database  string   `json:"database"`
+	Objects   int      `json:"objects"`
+	Results   []Result `json:"results"`
+}
+
+// Result is the result of a benchmark
+type Result struct {
+	Name        string `json:"name"`
+	Iterations  int    `json:"iterations"`
+	NsPerOp     int64  `json:"ns_per_op"`
+	AllocsPerOp int64  `json:"allocs_per_op"`
+	BytesPerOp  int64  `json:"bytes_per_op"`
+}
+
+// Regression is a metric of a benchmark that is worse than in the baseline by more than the threshold
+type Regression struct {
+	Name     string
+	Metric   string
+	Baseline int64
+	Current  int64
+}
+
+// String describes the regression with its change
+func (regression Regression) String() string {
+	return fmt.Sprintf("%s %s: %d -> %d (%+.1f%%)", regression.Name, regression.Metric, regression.Baseline, regression.Current, 100*(float64(regression.Current)/float64(regression.Baseline)-1))
+}
+
+// Category is the global resource of the benchmarks
+type Category struct {
+	domain.Base
+	Name string `json:"name"`
+}
+
+// ResourceName returns the name of the resource
+func (c *Category) ResourceName() string {
+	return "category"
+}
+
+// NewObject creates the instances of the resource without reflection
+func (c *Category) NewObject() domain.Object {
+	return &Category{}
+}
+
+// IsGlobal returns the global flag
+func (c *Category) IsGlobal() bool {
+	return true
+}
+
+// Prepare initialises the technical fields
+func (c *Category) Prepare(ctx context.Context) error {
+	return c.BasePrepare(ctx)
+}
+
+// Item is the owned resource of the benchmarks, with a relation that is preloaded
+type Item struct {
+	domain.Base
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Price       domain.Decimal `json:"price" gorm:"type:numeric(12,2)" fake:"min=1,max=500"`
+	Status      string         `json:"status" fake:"oneof=draft|active|archived"`
+	Quantity    int            `json:"quantity" fake:"min=0,max=1000"`
+	CategoryID  uuid.UUID      `json:"category_id"`
+	Category    Category       `json:"category"`
+	UserID      uuid.UUID      `json:"user_id"`
+}
+
+// ResourceName returns the name of the resource
+func (i *Item) ResourceName() string {
+	return "item"
+}
+
+// NewObject creates the instances of the resource without reflection
+func (i *Item) NewObject() domain.Object {
+	return &Item{}
+}
+
+// SetUserID sets the owner of the item
+func (i *Item) SetUserID(uid uuid.UUID) {
+	i.UserID = uid
+}
+
+// Preloads returns the preloaded relations
+func (i *Item) Preloads() []string {
+	return []string{"Category"}
+}
+
+// Prepare initialises the technical fields
+func (i *Item) Prepare(ctx context.Context) error {
+	return i.BasePrepare(ctx)
+}
+
+// Validate checks the required fields
+func (i *Item) Validate(ctx context.Context) error {
+	if i.Name == "" {
+		return fmt.Errorf("required Name")
+	}
+	return nil
+}
+
+// fixture is the seeded server with the token of the owner of the items
+type fixture struct {
+	server *api.Server
+	token  string
+	items  []uuid.UUID
+	seeded []domain.Object
+}
+
+// Run seeds the database and runs the benchmarks of the hot paths one after another: the instantiation and the reuse
+// of the objects of the resources, and the get, list, update and create requests through the handler of the server
+func Run(ctx context.Context, config Config) (*Report, error) {
+	fixture, err := newFixture(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer fixture.close()
+
+	report := &Report{
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		Database:  fixture.server.DB.Dialector.Name(),
+		Objects:   config.Objects,
+	}
+	benchmarks := []struct {
+		name      string
+		benchmark func(b *testing.B)
+	}{
+		{"resources-new", fixture.resourcesNew},
+		{"resources-borrow", fixture.resourcesBorrow},
+		{"get", fixture.get},
+		{"list", fixture.list},
+		{"update", fixture.update},
+		{"create", fixture.create},
+	}
+	for _, benchmark := range benchmarks {
+		var failure error
+		result := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					failure = fmt.Errorf("%v", recovered)
+					b.SkipNow()
+				}
+			}()
+			benchmark.benchmark(b)
+		})
+		if failure != nil {
+			return nil, fmt.Errorf("benchmark %s: %w", benchmark.name, failure)
+		}
+		report.Results = append(report.Results, Result{
+			Name:        benchmark.name,
+			Iterations:  result.N,
+			NsPerOp:     result.NsPerOp(),
+			AllocsPerOp: result.AllocsPerOp(),
+			BytesPerOp:  result.AllocedBytesPerOp(),
+		})
+	}
+	return report, nil
+}
+
+// newFixture creates the server with the default configuration and the fake auth client, and seeds the items of
+// one user with the fake data of the seed
+func newFixture(ctx context.Context, config Config) (*fixture, error) {
+	var serverConfig cfg.Server
+	var logConfig cfg.Logger
+	for _, target := range []any{&serverConfig, &logConfig} {
+		err := envconfig.ProcessWith(ctx, &envconfig.Config{Target: target, Lookuper: envconfig.MapLookuper(map[string]string{"LOG_LEVEL": "error"})})
+		if err != nil {
+			return nil, fmt.Errorf("cannot create default configuration: %w", err)
+		}
+	}
+	var options []api.Option
+	if config.Database.Host == "" {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent), NowFunc: domain.Now})
+		if err != nil {
+			return nil, fmt.Errorf("cannot open sqlite database: %w", err)
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		// Every connection opens its own in-memory database, so only one is kept
+		sqlDB.SetMaxOpenConns(1)
+		options = append(options, api.WithDB(db))
+	}
+
+	authClient := authtest.New()
+	roles := map[string][]string{"bench": {"category.read", "item.read", "item.write"}}
+	server, err := api.NewServer(serverConfig, logConfig, config.Database, []domain.Object{&Category{}, &Item{}}, authClient, roles, options...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create server: %w", err)
+	}
+	fixture := &fixture{server: server}
+	err = fixture.seed(ctx, authClient, config)
+	if err != nil {
+		fixture.close()
+		return nil, err
+	}
+	return fixture, nil
+}
+
+// seed empties the benchmark tables and creates the categories and the items
+func (fixture *fixture) seed(ctx context.Context, authClient *authtest.Client, config Config) error {
+	db := fixture.server.DB.WithContext(ctx)
+	err := common.Migrate(db, fixture.server.Resources)
+	if err != nil {
+		return fmt.Errorf("cannot migrate resources: %w", err)
+	}
+	err = db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&Item{}).Error
+	if err == nil {
+		err = db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&Category{}).Error
+	}
+	if err != nil {
+		return fmt.Errorf("cannot empty benchmark tables: %w", err)
+	}
+
+	generator := fake.New(fixture.server.Resources, config.Seed)
+	user := generator.User()
+	fixture.token = authClient.AddUser(user, "bench")
+	return db.Transaction(func(tx *gorm.DB) error {
+		for range categories {
+			category, err := generator.Generate(ctx, "category")
+			if err != nil {
+				return err
+			}
+			err = tx.Create(category).Error
+			if err != nil {
+				return fmt.Errorf("cannot seed category: %w", err)
+			}
+		}
+		for range config.Objects {
+			item, err := generator.Generate(ctx, "item")
+			if err != nil {
+				return err
+			}
+			item.(*Item).SetUserID(user.ID)
+			err = tx.Omit("Category").Create(item).Error
+			if err != nil {
+				return fmt.Errorf("cannot seed item: %w", err)
+			}
+			fixture.items = append(fixture.items, item.GetID())
+			fixture.seeded = append(fixture.seeded, item)
+		}
+		return nil
+	})
+}
+
+// close closes the connection of the database
+func (fixture *fixture) close() {
+	sqlDB, err := fixture.server.DB.DB()
+	if err == nil {
+		sqlDB.Close()
+	}
+}
+
+// do serves the request with the token of the owner, the benchmark fails when it does not have the status
+func (fixture *fixture) do(method, path string, body []byte, status int) {
+	request := httptest.NewRequest(method, fmt.Sprintf("/%s/%s", fixture.server.ServerConfig.APIPath, path), bytes.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+fixture.token)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	recorder := httptest.NewRecorder()
+	fixture.server.Handler().ServeHTTP(recorder, request)
+	if recorder.Code != status {
+		panic(fmt.Sprintf("%s %s: expected status %d, got %d: %s", method, path, status, recorder.Code, strings.TrimSpace(recorder.Body.String())))
+	}
+}
+
+// resourcesNew instantiates the objects of a resource, as the handlers do for every request
+func (fixture *fixture) resourcesNew(b *testing.B) {
+	for b.Loop() {
+		_, err := fixture.server.Resources.New("item")
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// resourcesBorrow borrows and returns the objects of a resource, as the handlers do for the objects used only within a
+// request, the items have a factory and are created with it
+func (fixture *fixture) resourcesBorrow(b *testing.B) {
+	for b.Loop() {
+		object, err := fixture.server.Resources.Borrow("item")
+		if err != nil {
+			panic(err)
+		}
+		fixture.server.Resources.Return(object)
+	}
+}
+
+// get loads the seeded items one after another
+func (fixture *fixture) get(b *testing.B) {
+	for i := 0; b.Loop(); i++ {
+		fixture.do(http.MethodGet, "item/"+fixture.items[i%len(fixture.items)].String(), nil, http.StatusOK)
+	}
+}
+
+// list loads the first pages of the items with their count
+func (fixture *fixture) list(b *testing.B) {
+	pages := max(len(fixture.items)/20, 1)
+	for i := 0; b.Loop(); i++ {
+		fixture.do(http.MethodGet, fmt.Sprintf("item?page_size=20&page=%d", i%min(pages, 10)+1), nil, http.StatusOK)
+	}
+}
+
+// update writes the seeded items one after another
+func (fixture *fixture) update(b *testing.B) {
+	bodies := make([][]byte, len(fixture.seeded))
+	for i, item := range fixture.seeded {
+		fields := map[string]any{}
+		data, err := json.Marshal(item)
+		if err == nil {
+			err = json.Unmarshal(data, &fields)
+		}
+		if err != nil {
+			panic(err)
+		}
+		// The relation is given by its ID
+		delete(fields, "category")
+		bodies[i], err = json.Marshal(fields)
+		if err != nil {
+			panic(err)
+		}
+	}
+	for i := 0; b.Loop(); i++ {
+		fixture.do(http.MethodPut, "item/"+fixture.items[i%len(fixture.items)].String(), bodies[i%len(bodies)], http.StatusOK)
+	}
+}
+
+// create adds new items to the seeded ones
+func (fixture *fixture) create(b *testing.B) {
+	body, err := json.Marshal(map[string]any{"name": "Benchmark", "description": "Created by the benchmark", "price": "9.99", "status": "draft", "quantity": 1, "category_id": fixture.seeded[0].(*Item).CategoryID})
+	if err != nil {
+		panic(err)
+	}
+	for b.Loop() {
+		fixture.do(http.MethodPost, "item", body, http.StatusCreated)
+	}
+}
+
+// Compare returns the metrics of the current report that are worse than in the baseline by more than the threshold,
+// e.g. 0.2 for 20%. The time and the allocations per operation are compared, the benchmarks missing in the baseline
+// are not.
+func Compare(baseline, current *Report, threshold float64) []Regression {
+	baselines := map[string]Result{}
+	for _, result := range baseline.Results {
+		baselines[result.Name] = result
+	}
+	var regressions []Regression
+	for _, result := range current.Results {
+		previous, ok := baselines[result.Name]
+		if !ok {
+			continue
+		}
+		if worse(previous.NsPerOp, result.NsPerOp, threshold) {
+			regressions = append(regressions, Regression{Name: result.Name, Metric: "ns/op", Baseline: previous.NsPerOp, Current: result.NsPerOp})
+		}
+		if worse(previous.AllocsPerOp, result.AllocsPerOp, threshold) {
+			regressions = append(regressions, Regression{Name: result.Name, Metric: "allocs/op", Baseline: previous.AllocsPerOp, Current: result.AllocsPerOp})
+		}
+	}
+	return regressions
+}
+
+// worse checks that the value is above the baseline by more than the threshold
+func worse(baseline, value int64, threshold float64) bool {
+	return baseline > 0 && float64(value) > float64(baseline)*(1+threshold)
+}
+
+// Comparable checks that the reports are of the same environment, so that their results are compared
+func Comparable(baseline, current *Report) error {
+	if baseline.Database != current.Database || baseline.Objects != current.Objects || baseline.OS != current.OS || baseline.Arch != current.Arch {
+		return fmt.Errorf("the baseline is of %s with %d objects on %s/%s, the run of %s with %d objects on %s/%s",
+			baseline.Database, baseline.Objects, baseline.OS, baseline.Arch, current.Database, current.Objects, current.OS, current.Arch)
+	}
+	return nil
+}
+
+// ReadReport reads the report of a previous run, e.g. the baseline
+func ReadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read report: %w", err)
+	}
+	var report Report
+	err = json.Unmarshal(data, &report)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode report %s: %w", path, err)
+	}
+	return &report, nil
+}
+
+// Write writes the report as JSON
+func (report *Report) Write(path string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,176 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	CLOSED    = "closed"
+	OPEN      = "open"
+	HALF_OPEN = "half_open"
+)
+
+// ErrOpen is returned for the calls that are not made while the breaker of the dependency is open
+var ErrOpen = errors.New("circuit breaker open")
+
+// Status is the state of a breaker reported by the metrics and the diagnostics
+type Status struct {
+	Name                string     `json:"name"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Opened              int64      `json:"opened"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+}
+
+// Breaker stops the calls to a dependency after consecutive failures, so that the callers fail fast while it is
+// down instead of piling up on it. After the timeout a single trial call is let through, its success closes the
+// breaker and its failure opens it again.
+type Breaker struct {
+	Name     string
+	Failures int
+	Timeout  time.Duration
+	// IsFailure decides which errors of Call count as failures of the dependency, all errors without it
+	IsFailure   func(err error) bool
+	mutex       sync.Mutex
+	consecutive int
+	opened      int64
+	openedAt    time.Time
+	trial       bool
+}
+
+// New creates a breaker opening after the consecutive failures, 0 failures never open it
+func New(name string, failures int, timeout time.Duration) *Breaker {
+	return &Breaker{Name: name, Failures: failures, Timeout: timeout}
+}
+
+// Allow checks if a call may be made, only one trial call is let through when the timeout of the open breaker passed
+func (breaker *Breaker) Allow() bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	if breaker.Failures <= 0 || breaker.consecutive < breaker.Failures {
+		return true
+	}
+	if breaker.trial || time.Since(breaker.openedAt) < breaker.Timeout {
+		return false
+	}
+	breaker.trial = true
+	return true
+}
+
+// Record counts the outcome of an allowed call
+func (breaker *Breaker) Record(failed bool) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.trial = false
+	if breaker.Failures <= 0 {
+		return
+	}
+	if !failed {
+		if breaker.consecutive >= breaker.Failures {
+			slog.Info("Circuit breaker closed", "breaker", breaker.Name)
+		}
+		breaker.consecutive = 0
+		return
+	}
+	breaker.consecutive++
+	if breaker.consecutive >= breaker.Failures {
+		if breaker.consecutive == breaker.Failures {
+			breaker.opened++
+			slog.Warn("Circuit breaker opened", "breaker", breaker.Name, "failures", breaker.consecutive, "timeout", breaker.Timeout)
+		}
+		breaker.openedAt = time.Now()
+	}
+}
+
+// Err is the error of the calls that are not allowed
+func (breaker *Breaker) Err() error {
+	return fmt.Errorf("%w: %s is unavailable, retrying after %s", ErrOpen, breaker.Name, breaker.Timeout)
+}
+
+// Call makes the call when it is allowed and records its outcome. The fallback, when given, handles the error of
+// the failed call or ErrOpen of the call that is not made, e.g. it returns a default or a stale value.
+func (breaker *Breaker) Call(call func() error, fallback func(err error) error) error {
+	var err error
+	if breaker.Allow() {
+		err = call()
+		breaker.Record(err != nil && (breaker.IsFailure == nil || breaker.IsFailure(err)))
+	} else {
+		err = breaker.Err()
+	}
+	if err != nil && fallback != nil {
+		return fallback(err)
+	}
+	return err
+}
+
+// Status returns the state of the breaker
+func (breaker *Breaker) Status() Status {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	status := Status{Name: breaker.Name, State: CLOSED, ConsecutiveFailures: breaker.consecutive, Opened: breaker.opened}
+	if breaker.Failures > 0 && breaker.consecutive >= breaker.Failures {
+		status.State = OPEN
+		if breaker.trial || time.Since(breaker.openedAt) >= breaker.Timeout {
+			status.State = HALF_OPEN
+		}
+		openedAt := breaker.openedAt.UTC()
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// Registry keeps the breakers of the dependencies by name, e.g. for the metrics and the diagnostics
+type Registry struct {
+	// Failures and Timeout configure the breakers created by Get
+	Failures int
+	Timeout  time.Duration
+	mutex    sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewRegistry creates a registry whose new breakers open after the consecutive failures
+func NewRegistry(failures int, timeout time.Duration) *Registry {
+	return &Registry{Failures: failures, Timeout: timeout, breakers: map[string]*Breaker{}}
+}
+
+// Get returns the breaker of the name, it is created with the defaults of the registry when it is missing
+func (registry *Registry) Get(name string) *Breaker {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	breaker, ok := registry.breakers[name]
+	if !ok {
+		breaker = New(name, registry.Failures, registry.Timeout)
+		registry.breakers[name] = breaker
+	}
+	return breaker
+}
+
+// Add registers a breaker created with its own configuration, it replaces the breaker of the same name
+func (registry *Registry) Add(breaker *Breaker) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.breakers[breaker.Name] = breaker
+}
+
+// Statuses returns the states of the breakers ordered by name
+func (registry *Registry) Statuses() []Status {
+	registry.mutex.Lock()
+	breakers := make([]*Breaker, 0, len(registry.breakers))
+	for _, breaker := range registry.breakers {
+		breakers = append(breakers, breaker)
+	}
+	registry.mutex.Unlock()
+	statuses := make([]Status, 0, len(breakers))
+	for _, breaker := range breakers {
+		statuses = append(statuses, breaker.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+)
+
+const (
+	MEMORY = "memory"
+	REDIS  = "redis"
+)
+
+// Cache is an abstraction of the key value stores used for state shared between requests
+type Cache interface {
+	// Get returns the value and false if the key does not exist
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores the value, zero ttl keeps it forever
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetNX stores the value only if the key does not exist and reports if it was stored
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Increment increments the counter, the ttl is set when the counter is created
+	Increment(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// IncrementBy adds the delta to the counter, the ttl is set when the counter is created
+	IncrementBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	Delete(ctx context.Context, keys ...string) error
+	Close() error
+}
+
+// New creates the cache for the configured backend
+func New(config cfg.Cache) (Cache, error) {
+	switch config.Backend {
+	case MEMORY:
+		return NewMemoryCache(config.Prefix), nil
+	case REDIS:
+		return NewRedisCache(config.RedisURL, config.Prefix)
+	default:
+		return nil, fmt.Errorf("unsupported cache backend: %s", config.Backend)
+	}
+}
+
+// Sizer reports the number of entries, it is used by the diagnostics
+type Sizer interface {
+	Size(ctx context.Context) (int64, error)
+}
+
+// Broadcaster delivers messages to all replicas listening on the channel
+type Broadcaster interface {
+	Broadcast(ctx context.Context, channel string, message []byte) error
+	// Listen calls the handler for every message until the context is cancelled
+	Listen(ctx context.Context, channel string, handler func(message []byte))
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// MemoryCache keeps the entries in the process memory, it is not shared between replicas
+type MemoryCache struct {
+	Prefix  string
+	mutex   sync.Mutex
+	entries map[string]memoryEntry
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewMemoryCache creates the cache and starts the removal of expired entries
+func NewMemoryCache(prefix string) *MemoryCache {
+	cache := &MemoryCache{
+		Prefix:  prefix,
+		entries: map[string]memoryEntry{},
+		done:    make(chan struct{}),
+	}
+	go cache.evict(time.Minute)
+	return cache
+}
+
+// Size returns the number of entries, including expired ones not removed yet
+func (cache *MemoryCache) Size(ctx context.Context) (int64, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return int64(len(cache.entries)), nil
+}
+
+// Get returns the value and false if the key does not exist
+func (cache *MemoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	entry, ok := cache.lookup(cache.Prefix+key, time.Now())
+	if !ok {
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+// Set stores the value, zero ttl keeps it forever
+func (cache *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.entries[cache.Prefix+key] = newMemoryEntry(value, ttl)
+	return nil
+}
+
+// SetNX stores the value only if the key does not exist and reports if it was stored
+func (cache *MemoryCache) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	_, ok := cache.lookup(cache.Prefix+key, time.Now())
+	if ok {
+		return false, nil
+	}
+	cache.entries[cache.Prefix+key] = newMemoryEntry(value, ttl)
+	return true, nil
+}
+
+// Increment increments the counter, the ttl is set when the counter is created
+func (cache *MemoryCache) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return cache.IncrementBy(ctx, key, 1, ttl)
+}
+
+// IncrementBy adds the delta to the counter, the ttl is set when the counter is created
+func (cache *MemoryCache) IncrementBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	entry, ok := cache.lookup(cache.Prefix+key, time.Now())
+	if !ok {
+		entry = newMemoryEntry([]byte("0"), ttl)
+	}
+	counter, err := strconv.ParseInt(string(entry.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	counter += delta
+	entry.value = []byte(strconv.FormatInt(counter, 10))
+	cache.entries[cache.Prefix+key] = entry
+	return counter, nil
+}
+
+// Delete removes the keys
+func (cache *MemoryCache) Delete(ctx context.Context, keys ...string) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	for _, key := range keys {
+		delete(cache.entries, cache.Prefix+key)
+	}
+	return nil
+}
+
+// Close stops the removal of expired entries
+func (cache *MemoryCache) Close() error {
+	cache.once.Do(func() {
+		close(cache.done)
+	})
+	return nil
+}
+
+// lookup returns the entry if it is not expired, the caller holds the mutex
+func (cache *MemoryCache) lookup(key string, now time.Time) (memoryEntry, bool) {
+	entry, ok := cache.entries[key]
+	if !ok || (!entry.expires.IsZero() && now.After(entry.expires)) {
+		return memoryEntry{}, false
+	}
+	return entry, true
+}
+
+// evict periodically removes expired entries
+func (cache *MemoryCache) evict(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cache.done:
+			return
+		case now := <-ticker.C:
+			cache.mutex.Lock()
+			for key, entry := range cache.entries {
+				if !entry.expires.IsZero() && now.After(entry.expires) {
+					delete(cache.entries, key)
+				}
+			}
+			cache.mutex.Unlock()
+		}
+	}
+}
+
+func newMemoryEntry(value []byte, ttl time.Duration) memoryEntry {
+	entry := memoryEntry{value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	return entry
+}
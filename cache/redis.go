@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// incrementScript adds the delta to the counter and sets the expiration when it is created
+var incrementScript = redis.NewScript(`
+local counter = redis.call("INCRBY", KEYS[1], ARGV[2])
+if counter == tonumber(ARGV[2]) and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return counter
+`)
+
+// RedisCache keeps the entries in Redis, so that they are shared between replicas
+type RedisCache struct {
+	Client *redis.Client
+	Prefix string
+}
+
+// NewRedisCache connects to the Redis server given as redis:// URL
+func NewRedisCache(url, prefix string) (*RedisCache, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = client.Ping(ctx).Err()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("cannot connect to redis: %w", err)
+	}
+	return &RedisCache{Client: client, Prefix: prefix}, nil
+}
+
+// Size returns the number of keys in the Redis database, keys of other prefixes are included
+func (cache *RedisCache) Size(ctx context.Context) (int64, error) {
+	return cache.Client.DBSize(ctx).Result()
+}
+
+// Get returns the value and false if the key does not exist
+func (cache *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := cache.Client.Get(ctx, cache.Prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Set stores the value, zero ttl keeps it forever
+func (cache *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return cache.Client.Set(ctx, cache.Prefix+key, value, ttl).Err()
+}
+
+// SetNX stores the value only if the key does not exist and reports if it was stored
+func (cache *RedisCache) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	err := cache.Client.SetArgs(ctx, cache.Prefix+key, value, redis.SetArgs{Mode: "NX", TTL: ttl}).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Increment increments the counter, the ttl is set when the counter is created
+func (cache *RedisCache) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return cache.IncrementBy(ctx, key, 1, ttl)
+}
+
+// IncrementBy adds the delta to the counter, the ttl is set when the counter is created
+func (cache *RedisCache) IncrementBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return incrementScript.Run(ctx, cache.Client, []string{cache.Prefix + key}, ttl.Milliseconds(), delta).Int64()
+}
+
+// Delete removes the keys
+func (cache *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = cache.Prefix + key
+	}
+	return cache.Client.Del(ctx, prefixed...).Err()
+}
+
+// Close closes the connection pool
+func (cache *RedisCache) Close() error {
+	return cache.Client.Close()
+}
+
+// Broadcast publishes the message on the Redis channel
+func (cache *RedisCache) Broadcast(ctx context.Context, channel string, message []byte) error {
+	return cache.Client.Publish(ctx, cache.Prefix+channel, message).Err()
+}
+
+// Listen subscribes to the Redis channel, the subscription is restored by the client after connection loss
+func (cache *RedisCache) Listen(ctx context.Context, channel string, handler func(message []byte)) {
+	subscription := cache.Client.Subscribe(ctx, cache.Prefix+channel)
+	defer subscription.Close()
+	messages := subscription.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			handler([]byte(message.Payload))
+		}
+	}
+}
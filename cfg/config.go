@@ -1,6 +1,10 @@
 package cfg
 
-import "time"
+import (
+	"net/netip"
+	"strings"
+	"time"
+)
 
 type Logger struct {
 	Level  string `env:"LOG_LEVEL, default=debug"`
@@ -8,11 +12,64 @@ type Logger struct {
 }
 
 type DataBase struct {
+	// Backend is postgres, cockroachdb, or memory to keep the resources in process memory without a database
+	Backend      string `env:"DB_BACKEND, default=postgres"`
 	User         string `env:"DB_USER"`
 	Password     string `env:"DB_PASSWORD"`
 	Port         string `env:"DB_PORT, default=5432"`
 	Host         string `env:"DB_HOST"`
 	DatabaseName string `env:"DB_NAME"`
+	// FailoverHosts are the hosts taking over when DB_HOST fails, host or host:port in the order of preference
+	FailoverHosts []string `env:"DB_FAILOVER_HOSTS"`
+	// FailoverCheckInterval is how often the hosts are probed, so the connections fail back to the preferred host
+	FailoverCheckInterval time.Duration `env:"DB_FAILOVER_CHECK_INTERVAL, default=5s"`
+	// CredentialsCheckInterval is how often the DB_USER_FILE, DB_PASSWORD_FILE and AUTH_CLIENT_SECRET_FILE are checked for changes
+	CredentialsCheckInterval time.Duration `env:"DB_CREDENTIALS_CHECK_INTERVAL, default=30s"`
+	// QueryTimeout bounds each statement on the client, canceled requests cancel their statements regardless of it
+	QueryTimeout time.Duration `env:"DB_QUERY_TIMEOUT, default=0"`
+	// StatementTimeout bounds the queries of each request, 0 keeps the timeout of the database role
+	StatementTimeout time.Duration `env:"DB_STATEMENT_TIMEOUT, default=0"`
+	// SessionRole is the role the queries of the requests run as, e.g. one that row-level security policies apply to
+	SessionRole string `env:"DB_SESSION_ROLE"`
+	// ApplicationName is reported in pg_stat_activity followed by the request ID, e.g. respite/<request_id>
+	ApplicationName string `env:"DB_APPLICATION_NAME"`
+	// TransactionPooling makes the connections work behind poolers in transaction mode, e.g. PgBouncer, without
+	// prepared statements and session-level locks
+	TransactionPooling bool `env:"DB_TRANSACTION_POOLING, default=false"`
+	// TransactionRetries is how many times the transactions aborted by serialization failures are run again,
+	// CockroachDB aborts the conflicting transactions instead of making them wait
+	TransactionRetries int `env:"DB_TRANSACTION_RETRIES, default=3"`
+	// RowLevelSecurity enforces the ownership with the row-level security policies of the tables instead of the queries
+	RowLevelSecurity bool `env:"DB_ROW_LEVEL_SECURITY, default=false"`
+	// BreakerFailures is how many consecutive connection failures open the circuit breaker of the database, the
+	// statements then fail fast with 503 until BreakerTimeout passes, 0 disables the breaker
+	BreakerFailures int `env:"DB_BREAKER_FAILURES, default=5"`
+	// BreakerTimeout is how long the open breaker fails the statements before a trial statement is let through
+	BreakerTimeout time.Duration `env:"DB_BREAKER_TIMEOUT, default=5s"`
+}
+
+// Dev is the all-in-one local development mode with SQLite, without authentication and with fake data
+type Dev struct {
+	Enabled bool `env:"DEV_MODE, default=false"`
+	// Database is the SQLite file, :memory: keeps the data only while the server runs
+	Database string `env:"DEV_DATABASE, default=respite-dev.db"`
+	// FakeData is the number of fake instances created for each resource on start
+	FakeData int `env:"DEV_FAKE_DATA, default=10"`
+}
+
+// Bootstrap grants an initial admin user global permissions on all resources, so that fresh deployments are usable
+type Bootstrap struct {
+	// AdminSubject is the Keycloak subject of the admin user, the user is created on start if missing
+	AdminSubject string `env:"BOOTSTRAP_ADMIN_SUBJECT"`
+	// AdminEmail matches the admin user by the email in the token
+	AdminEmail string `env:"BOOTSTRAP_ADMIN_EMAIL"`
+	// AdminRole is granted to the admin user, unless the application defines it all permissions are mapped to it
+	AdminRole string `env:"BOOTSTRAP_ADMIN_ROLE, default=bootstrap-admin"`
+}
+
+// Enabled checks if an admin user is configured
+func (config Bootstrap) Enabled() bool {
+	return config.AdminSubject != "" || config.AdminEmail != ""
 }
 
 type Keycloak struct {
@@ -20,6 +77,84 @@ type Keycloak struct {
 	AuthRealm        string `env:"AUTH_REALM"`
 	AuthClientID     string `env:"AUTH_CLIENT_ID"`
 	AuthClientSecret string `env:"AUTH_CLIENT_SECRET"`
+	// AuthRetries is how many times the calls to Keycloak failing with network errors, 429 or 5xx are repeated
+	AuthRetries int `env:"AUTH_RETRIES, default=2"`
+	// AuthRetryBackoff is the delay of the first retry, it is doubled for every further retry and jittered
+	AuthRetryBackoff time.Duration `env:"AUTH_RETRY_BACKOFF, default=100ms"`
+	// AuthBreakerFailures is how many consecutive failed calls stop the calls to Keycloak, 0 disables the breaker
+	AuthBreakerFailures int `env:"AUTH_BREAKER_FAILURES, default=5"`
+	// AuthBreakerTimeout is how long the calls are stopped before one is tried again
+	AuthBreakerTimeout time.Duration `env:"AUTH_BREAKER_TIMEOUT, default=30s"`
+}
+
+// IdentitySync consumes the admin events of Keycloak, so that the deletions, the profile changes and the role
+// changes of the users apply before their next login
+type IdentitySync struct {
+	// WebhookSecret enables POST /api/webhooks/keycloak for the admin events of the keycloak-events extension
+	WebhookSecret string `env:"IDENTITY_SYNC_WEBHOOK_SECRET"`
+	// PollInterval is how often the admin events are read from the admin API, 0 disables the polling
+	PollInterval time.Duration `env:"IDENTITY_SYNC_POLL_INTERVAL, default=0s"`
+	// Lookback is how far back the first poll after a start reads the admin events
+	Lookback time.Duration `env:"IDENTITY_SYNC_LOOKBACK, default=5m"`
+	// RevocationTTL is how long the tokens issued before a change are rejected, at least the access token lifespan
+	RevocationTTL time.Duration `env:"IDENTITY_SYNC_REVOCATION_TTL, default=1h"`
+	// DeletedUsers is keep to keep the data of the users deleted in Keycloak, or erase to erase it
+	DeletedUsers string `env:"IDENTITY_SYNC_DELETED_USERS, default=keep"`
+}
+
+// SCIM provisions the users with SCIM 2.0, e.g. from the enterprise identity providers
+type SCIM struct {
+	// Token is the bearer token of the identity provider, it enables the SCIM endpoints
+	Token string `env:"SCIM_TOKEN"`
+	// MaxResults limits the users of the pages of the lists
+	MaxResults int `env:"SCIM_MAX_RESULTS, default=100"`
+}
+
+// LDAP resolves the groups of the users from a directory, e.g. Active Directory, at their login and maps them to
+// roles
+type LDAP struct {
+	// URL is ldap://host:389 or ldaps://host:636, it enables the group resolution
+	URL          string `env:"LDAP_URL"`
+	BindDN       string `env:"LDAP_BIND_DN"`
+	BindPassword string `env:"LDAP_BIND_PASSWORD"`
+	BaseDN       string `env:"LDAP_BASE_DN"`
+	// UserFilter finds the user, {username} and {email} are replaced with the values of the token
+	UserFilter string `env:"LDAP_USER_FILTER, default=(uid={username})"`
+	// GroupAttribute is the attribute of the user with the DNs of its groups
+	GroupAttribute string `env:"LDAP_GROUP_ATTRIBUTE, default=memberOf"`
+	// GroupFilter finds the groups by the DN of the user in {dn} instead of the attribute, e.g. for nested groups
+	GroupFilter string `env:"LDAP_GROUP_FILTER"`
+	// GroupRoles maps the names of the groups to roles, the groups without a role are roles with their names
+	GroupRoles map[string]string `env:"LDAP_GROUP_ROLES"`
+	// CacheTTL is how long the groups of a user are kept before they are read again
+	CacheTTL time.Duration `env:"LDAP_CACHE_TTL, default=5m"`
+	Timeout  time.Duration `env:"LDAP_TIMEOUT, default=5s"`
+}
+
+// FGA decides the access to the objects of the shared resources with the relationship tuples of an OpenFGA store
+type FGA struct {
+	// URL is the HTTP API of OpenFGA, it enables the authorizer
+	URL     string `env:"FGA_URL"`
+	StoreID string `env:"FGA_STORE_ID"`
+	// ModelID pins the authorization model, the latest model of the store is used without it
+	ModelID  string `env:"FGA_MODEL_ID"`
+	APIToken string `env:"FGA_API_TOKEN"`
+	// UserType is the type of the users of the tuples
+	UserType string        `env:"FGA_USER_TYPE, default=user"`
+	Timeout  time.Duration `env:"FGA_TIMEOUT, default=5s"`
+}
+
+// Manifest reconciles the instance with a declared manifest file, e.g. mounted from a ConfigMap by an operator
+type Manifest struct {
+	// Path is the YAML or JSON file of the manifest, it enables the reconciliation
+	Path string `env:"MANIFEST_PATH"`
+	// Interval is how often the file is checked for changes
+	Interval time.Duration `env:"MANIFEST_INTERVAL, default=10s"`
+}
+
+// Enabled checks if the admin events are received or polled
+func (config IdentitySync) Enabled() bool {
+	return config.WebhookSecret != "" || config.PollInterval > 0
 }
 
 type Server struct {
@@ -31,4 +166,491 @@ type Server struct {
 	DeadlineOnInterrupt time.Duration `env:"SERVER_DEADLINE_ON_INTERRUPT, default=15s"`
 	MinPageSize         int           `env:"SERVER_MIN_PAGE_SIZE, default=10"`
 	MaxPageSize         int           `env:"SERVER_MAX_PAGE_SIZE, default=500"`
+	// ShutdownDelay is the time between the termination signal and the shutdown, /readyz fails and the requests are
+	// served, so that the load balancers stop routing requests to the instance first
+	ShutdownDelay time.Duration `env:"SERVER_SHUTDOWN_DELAY, default=0s"`
+	// MaxOffset limits page * page_size of list requests to protect the database from deep pagination, 0 disables it
+	MaxOffset int `env:"SERVER_MAX_OFFSET, default=0"`
+	// KeysetOffset is the offset above which list pages start at the key of their first row, 0 disables it
+	KeysetOffset int `env:"SERVER_KEYSET_OFFSET, default=10000"`
+	// WindowCount counts the lists in the query of their page with count(*) OVER() on PostgreSQL
+	WindowCount bool `env:"SERVER_WINDOW_COUNT, default=false"`
+	// ParallelCount counts the lists concurrently with the query of their page when it does not count them
+	ParallelCount bool `env:"SERVER_PARALLEL_COUNT, default=false"`
+	// PublicResources are the global resources that anonymous callers read without a token
+	PublicResources []string `env:"SERVER_PUBLIC_RESOURCES"`
+	// MaxFilterConditions limits the filter conditions of list requests and GraphQL queries, 0 disables it
+	MaxFilterConditions int `env:"SERVER_MAX_FILTER_CONDITIONS, default=20"`
+	// MaxExpandDepth limits the nesting of GraphQL selections, 0 disables it
+	MaxExpandDepth int `env:"SERVER_MAX_EXPAND_DEPTH, default=10"`
+	// MaxRelations limits the related objects selected by a GraphQL query, 0 disables it
+	MaxRelations int `env:"SERVER_MAX_RELATIONS, default=20"`
+	// MaxBatchOperations limits the operations of a batch request, 0 disables it
+	MaxBatchOperations int `env:"SERVER_MAX_BATCH_OPERATIONS, default=100"`
+	// MaxTransformLength limits the length of the JMESPath expressions of the transform parameter, 0 disables the
+	// transforms
+	MaxTransformLength int `env:"SERVER_MAX_TRANSFORM_LENGTH, default=256"`
+	// TimestampPrecision is the precision of the stored and serialized timestamps
+	TimestampPrecision time.Duration `env:"SERVER_TIMESTAMP_PRECISION, default=1us"`
+	// DefaultLocale is the locale of the error messages when Accept-Language is missing or not matched
+	DefaultLocale string `env:"SERVER_DEFAULT_LOCALE, default=en"`
+	// TrailingSlash routes API paths with trailing slashes like the paths without them
+	TrailingSlash bool `env:"SERVER_TRAILING_SLASH, default=true"`
+	// CaseInsensitivePaths matches the API path and the resource names of the paths regardless of case
+	CaseInsensitivePaths bool `env:"SERVER_CASE_INSENSITIVE_PATHS, default=false"`
+	// MethodOverride routes authenticated POST requests with the method of the X-HTTP-Method-Override header
+	MethodOverride bool `env:"SERVER_METHOD_OVERRIDE, default=false"`
+	// APITitle and APIVersion describe the API in the OpenAPI document
+	APITitle   string `env:"SERVER_API_TITLE, default=respite"`
+	APIVersion string `env:"SERVER_API_VERSION, default=1.0.0"`
+	// SchemaValidation validates the bodies of the resource routes against the OpenAPI document, for non-production modes
+	SchemaValidation bool `env:"SERVER_SCHEMA_VALIDATION, default=false"`
+	// DocsEnabled serves the API console at /api/docs, DocsPublic serves it and the OpenAPI document without a token
+	DocsEnabled    bool   `env:"SERVER_DOCS_ENABLED, default=false"`
+	DocsPublic     bool   `env:"SERVER_DOCS_PUBLIC, default=false"`
+	DocsAssetsURL  string `env:"SERVER_DOCS_ASSETS_URL, default=https://unpkg.com/swagger-ui-dist@5"`
+	GraphQLEnabled bool   `env:"SERVER_GRAPHQL_ENABLED, default=false"`
+	GRPCPort       string `env:"SERVER_GRPC_PORT"`
+	// GatedStartup serves /healthz and /readyz while the startup tasks run, e.g. the migrations and the seeders,
+	// and answers the other requests with 503 until they complete
+	GatedStartup bool `env:"SERVER_GATED_STARTUP, default=false"`
+	// StartupRetryAfter is the Retry-After of the requests answered while the startup tasks run
+	StartupRetryAfter time.Duration `env:"SERVER_STARTUP_RETRY_AFTER, default=5s"`
+	// WarmUpTimeout bounds the warm-up tasks that run before /readyz reports ready, e.g. the cache priming
+	WarmUpTimeout time.Duration `env:"SERVER_WARM_UP_TIMEOUT, default=30s"`
+	// ReadOnly serves only the reads and answers the writes with 405, for read replicas scaled apart from the writers
+	ReadOnly bool `env:"SERVER_READ_ONLY, default=false"`
+	// SigningKey signs the expiring download URLs of the files and of the job artifacts, empty disables them
+	SigningKey string `env:"SERVER_SIGNING_KEY"`
+	// SignedURLExpiry is the validity of the signed download URLs
+	SignedURLExpiry time.Duration `env:"SERVER_SIGNED_URL_EXPIRY, default=15m"`
+}
+
+type AMQP struct {
+	URL            string        `env:"AMQP_URL"`
+	Exchange       string        `env:"AMQP_EXCHANGE, default=respite"`
+	ExchangeType   string        `env:"AMQP_EXCHANGE_TYPE, default=topic"`
+	RoutingKey     string        `env:"AMQP_ROUTING_KEY, default={resource}.{action}"`
+	Confirms       bool          `env:"AMQP_CONFIRMS, default=true"`
+	ConfirmTimeout time.Duration `env:"AMQP_CONFIRM_TIMEOUT, default=5s"`
+	ReconnectDelay time.Duration `env:"AMQP_RECONNECT_DELAY, default=5s"`
+}
+
+type Outbox struct {
+	Enabled      bool          `env:"OUTBOX_ENABLED, default=false"`
+	PollInterval time.Duration `env:"OUTBOX_POLL_INTERVAL, default=1s"`
+	BatchSize    int           `env:"OUTBOX_BATCH_SIZE, default=100"`
+	Retention    time.Duration `env:"OUTBOX_RETENTION, default=168h"`
+	ChangesDelay time.Duration `env:"OUTBOX_CHANGES_DELAY, default=5s"`
+}
+
+type Subscriptions struct {
+	Enabled      bool          `env:"SUBSCRIPTIONS_ENABLED, default=false"`
+	BufferSize   int           `env:"SUBSCRIPTIONS_BUFFER_SIZE, default=64"`
+	PingInterval time.Duration `env:"SUBSCRIPTIONS_PING_INTERVAL, default=30s"`
+	// Presence tracks who is viewing or editing the objects on the subscriptions sockets
+	Presence    bool          `env:"SUBSCRIPTIONS_PRESENCE, default=false"`
+	PresenceTTL time.Duration `env:"SUBSCRIPTIONS_PRESENCE_TTL, default=60s"`
+}
+
+type Events struct {
+	Format     string `env:"EVENTS_FORMAT, default=json"`
+	Source     string `env:"EVENTS_SOURCE, default=respite"`
+	TypePrefix string `env:"EVENTS_TYPE_PREFIX"`
+}
+
+type Email struct {
+	Provider       string `env:"EMAIL_PROVIDER, default=smtp"`
+	From           string `env:"EMAIL_FROM"`
+	SMTPHost       string `env:"EMAIL_SMTP_HOST"`
+	SMTPPort       string `env:"EMAIL_SMTP_PORT, default=587"`
+	SMTPUser       string `env:"EMAIL_SMTP_USER"`
+	SMTPPassword   string `env:"EMAIL_SMTP_PASSWORD"`
+	SendGridAPIKey string `env:"EMAIL_SENDGRID_API_KEY"`
+	SendGridURL    string `env:"EMAIL_SENDGRID_URL, default=https://api.sendgrid.com/v3/mail/send"`
+}
+
+type Scheduler struct {
+	LockName      string        `env:"SCHEDULER_LOCK_NAME, default=respite.scheduler"`
+	CheckInterval time.Duration `env:"SCHEDULER_CHECK_INTERVAL, default=1s"`
+	LeaderRetry   time.Duration `env:"SCHEDULER_LEADER_RETRY, default=10s"`
+}
+
+// Coordination coordinates the work of the replicas of a deployment
+type Coordination struct {
+	// LeaderRetry is how often the followers of the outbox relay try to become its leader
+	LeaderRetry time.Duration `env:"COORDINATION_LEADER_RETRY, default=10s"`
+	// Instances registers the instances in the instances table and refreshes their heartbeats
+	Instances         bool          `env:"COORDINATION_INSTANCES, default=false"`
+	HeartbeatInterval time.Duration `env:"COORDINATION_HEARTBEAT_INTERVAL, default=10s"`
+}
+
+type Storage struct {
+	Backend       string        `env:"STORAGE_BACKEND, default=local"`
+	LocalPath     string        `env:"STORAGE_LOCAL_PATH, default=./data/files"`
+	S3Endpoint    string        `env:"STORAGE_S3_ENDPOINT, default=s3.amazonaws.com"`
+	S3Region      string        `env:"STORAGE_S3_REGION"`
+	S3Bucket      string        `env:"STORAGE_S3_BUCKET"`
+	S3Prefix      string        `env:"STORAGE_S3_PREFIX"`
+	S3AccessKey   string        `env:"STORAGE_S3_ACCESS_KEY"`
+	S3SecretKey   string        `env:"STORAGE_S3_SECRET_KEY"`
+	S3UseSSL      bool          `env:"STORAGE_S3_USE_SSL, default=true"`
+	S3Encryption  string        `env:"STORAGE_S3_ENCRYPTION, default=none"`
+	S3KMSKeyID    string        `env:"STORAGE_S3_KMS_KEY_ID"`
+	PresignExpiry time.Duration `env:"STORAGE_PRESIGN_EXPIRY, default=15m"`
+	MaxUploadSize int64         `env:"STORAGE_MAX_UPLOAD_SIZE, default=33554432"`
+	// ScanBackend is the virus scanner of the uploaded files, clamav or icap, empty disables scanning
+	ScanBackend     string        `env:"STORAGE_SCAN_BACKEND"`
+	ScanAddress     string        `env:"STORAGE_SCAN_ADDRESS"`
+	ScanICAPService string        `env:"STORAGE_SCAN_ICAP_SERVICE, default=avscan"`
+	ScanTimeout     time.Duration `env:"STORAGE_SCAN_TIMEOUT, default=30s"`
+	// ImageVariants are the sizes of the variants generated for the uploaded images by name, e.g. thumb:200x200
+	ImageVariants map[string]string `env:"STORAGE_IMAGE_VARIANTS"`
+	// ImageStripEXIF removes the EXIF and the other metadata from the uploaded images
+	ImageStripEXIF bool `env:"STORAGE_IMAGE_STRIP_EXIF, default=false"`
+}
+
+type Cache struct {
+	Backend         string        `env:"CACHE_BACKEND, default=memory"`
+	RedisURL        string        `env:"CACHE_REDIS_URL, default=redis://localhost:6379/0"`
+	Prefix          string        `env:"CACHE_PREFIX, default=respite:"`
+	TokenTTL        time.Duration `env:"CACHE_TOKEN_TTL, default=30s"`
+	ResponseTTL     time.Duration `env:"CACHE_RESPONSE_TTL, default=0s"`
+	ResponseLocal   bool          `env:"CACHE_RESPONSE_LOCAL, default=false"`
+	Invalidations   string        `env:"CACHE_INVALIDATIONS, default=invalidations"`
+	RateLimit       int64         `env:"CACHE_RATE_LIMIT, default=0"`
+	RateLimitWindow time.Duration `env:"CACHE_RATE_LIMIT_WINDOW, default=1m"`
+	IdempotencyTTL  time.Duration `env:"CACHE_IDEMPOTENCY_TTL, default=24h"`
+	// PublicRateLimit is the number of anonymous reads of the public resources allowed per client address and window
+	PublicRateLimit int64 `env:"CACHE_PUBLIC_RATE_LIMIT, default=60"`
+	// ResourceRateLimits is the cost allowed per caller and window for each resource, e.g. meal:100, * applies to the others
+	ResourceRateLimits map[string]int64 `env:"CACHE_RESOURCE_RATE_LIMITS"`
+	// RequestCosts weights the operations against the resource rate limits, e.g. export:20 or meal.list:5, the default is 1
+	RequestCosts map[string]int64 `env:"CACHE_REQUEST_COSTS"`
+	// TrustedProxies are the addresses and CIDR ranges of the proxies whose X-Forwarded-For is the client address
+	TrustedProxies []string `env:"CACHE_TRUSTED_PROXIES"`
+}
+
+// Proxies parses the trusted proxies, the addresses are ranges of a single address
+func (config Cache) Proxies() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(config.TrustedProxies))
+	for _, value := range config.TrustedProxies {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+type Search struct {
+	Provider              string   `env:"SEARCH_PROVIDER, default=postgres"`
+	Resources             []string `env:"SEARCH_RESOURCES"`
+	PostgresLanguage      string   `env:"SEARCH_POSTGRES_LANGUAGE, default=simple"`
+	IndexPrefix           string   `env:"SEARCH_INDEX_PREFIX, default=respite-"`
+	ElasticsearchURL      string   `env:"SEARCH_ELASTICSEARCH_URL, default=http://localhost:9200"`
+	ElasticsearchUsername string   `env:"SEARCH_ELASTICSEARCH_USERNAME"`
+	ElasticsearchPassword string   `env:"SEARCH_ELASTICSEARCH_PASSWORD"`
+	ElasticsearchAPIKey   string   `env:"SEARCH_ELASTICSEARCH_API_KEY"`
+}
+
+type Flags struct {
+	Definitions     string        `env:"FEATURE_FLAGS"`
+	Database        bool          `env:"FEATURE_FLAGS_DATABASE, default=false"`
+	RefreshInterval time.Duration `env:"FEATURE_FLAGS_REFRESH_INTERVAL, default=30s"`
+}
+
+type Permissions struct {
+	Database        bool          `env:"PERMISSIONS_DATABASE, default=false"`
+	RefreshInterval time.Duration `env:"PERMISSIONS_REFRESH_INTERVAL, default=30s"`
+}
+
+// Tenants keeps the overrides of the limits and the feature flags per tenant, e.g. for the plans of a SaaS
+type Tenants struct {
+	// Database loads the overrides from the tenants table
+	Database        bool          `env:"TENANTS_DATABASE, default=false"`
+	RefreshInterval time.Duration `env:"TENANTS_REFRESH_INTERVAL, default=30s"`
+}
+
+type Audit struct {
+	Sinks         []string `env:"AUDIT_SINKS, default=database"`
+	IncludeData   bool     `env:"AUDIT_INCLUDE_DATA, default=false"`
+	SyslogNetwork string   `env:"AUDIT_SYSLOG_NETWORK"`
+	SyslogAddress string   `env:"AUDIT_SYSLOG_ADDRESS"`
+	SyslogTag     string   `env:"AUDIT_SYSLOG_TAG, default=respite"`
+	HTTPURL       string   `env:"AUDIT_HTTP_URL"`
+	HTTPToken     string   `env:"AUDIT_HTTP_TOKEN"`
+	KafkaBrokers  []string `env:"AUDIT_KAFKA_BROKERS"`
+	KafkaTopic    string   `env:"AUDIT_KAFKA_TOPIC, default=respite.audit"`
+}
+
+type Health struct {
+	CheckInterval time.Duration `env:"HEALTH_CHECK_INTERVAL, default=5s"`
+	CheckTimeout  time.Duration `env:"HEALTH_CHECK_TIMEOUT, default=2s"`
+	// ReadinessThreshold is how long the database is unavailable before /readyz fails
+	ReadinessThreshold time.Duration `env:"HEALTH_READINESS_THRESHOLD, default=0s"`
+	// LivenessThreshold is how long the database is unavailable before /healthz fails, 0 keeps it passing
+	LivenessThreshold time.Duration `env:"HEALTH_LIVENESS_THRESHOLD, default=0s"`
+}
+
+// Quotas limits the objects that the users or the tenants own
+type Quotas struct {
+	// Limits is the maximum of objects per owner for each local resource, e.g. note:100, * applies to the others
+	Limits map[string]int64 `env:"QUOTA_LIMITS"`
+	// Scope counts the objects of the user or of all users of the tenant
+	Scope string `env:"QUOTA_SCOPE, default=user"`
+}
+
+// Consent requires the users to accept the current versions of the documents, e.g. the terms of service
+type Consent struct {
+	// Documents are the current versions of the documents accepted before mutations, e.g. terms:2024-05,privacy:3
+	Documents map[string]string `env:"CONSENT_DOCUMENTS"`
+}
+
+// Replay requires signed requests for the high-risk operations, a request is rejected when it is replayed
+type Replay struct {
+	// Operations are the operations whose requests are signed, e.g. delete or order.delete, empty disables the protection
+	Operations []string `env:"REPLAY_OPERATIONS"`
+	// SigningKey is the secret of the HMAC signing the requests, shared with the clients
+	SigningKey string `env:"REPLAY_SIGNING_KEY"`
+	// Window is how far the timestamp of a request may be from the clock of the server, the nonces are kept twice as long
+	Window time.Duration `env:"REPLAY_WINDOW, default=5m"`
+	// MaxBodySize limits the bodies of the signed requests, which are read in memory to check their signature
+	MaxBodySize int64 `env:"REPLAY_MAX_BODY_SIZE, default=33554432"`
+}
+
+// StepUp requires a stronger authentication for the sensitive operations, e.g. with a second factor
+type StepUp struct {
+	// Operations are the operations that require the step-up, e.g. delete or order.delete, empty disables it
+	Operations []string `env:"STEPUP_OPERATIONS"`
+	// ACR are the accepted authentication context classes, e.g. 2 for the second level of authentication of Keycloak
+	ACR []string `env:"STEPUP_ACR"`
+	// AMR are the authentication methods of which one is required, e.g. otp,hwk
+	AMR []string `env:"STEPUP_AMR"`
+	// MaxAge is how long ago the user may have authenticated, zero does not check it
+	MaxAge time.Duration `env:"STEPUP_MAX_AGE, default=0s"`
+}
+
+// CSP is the Content Security Policy of the static files and the collection of the reports of its violations
+type CSP struct {
+	// Policy is the Content-Security-Policy header of the static files, empty does not set it
+	Policy string `env:"CSP_POLICY"`
+	// ReportOnly reports the violations of the policy without enforcing it
+	ReportOnly bool `env:"CSP_REPORT_ONLY, default=false"`
+	// Reports collects the reports of the violations on /csp-report, the policy reports to it
+	Reports bool `env:"CSP_REPORTS, default=false"`
+	// Store keeps the reports in the csp_reports table
+	Store bool `env:"CSP_STORE, default=false"`
+	// ForwardURL receives the reports as they are sent by the browsers, e.g. a security endpoint of an error tracker
+	ForwardURL string `env:"CSP_FORWARD_URL"`
+	// MaxReportSize is the maximum size in bytes of the reports
+	MaxReportSize int64 `env:"CSP_MAX_REPORT_SIZE, default=65536"`
+}
+
+// EditLocks are the pessimistic edit locks of the objects, the updates of the objects require holding their lock
+type EditLocks struct {
+	// Resources are the resources whose objects are locked to edit them, empty disables the locks
+	Resources []string `env:"EDIT_LOCK_RESOURCES"`
+	// TTL is how long a lock is held unless it is renewed
+	TTL time.Duration `env:"EDIT_LOCK_TTL, default=5m"`
+}
+
+// Notifications is the inbox of the notifications of the users, e.g. of the mentions and the shares
+type Notifications struct {
+	Enabled bool `env:"NOTIFICATIONS_ENABLED, default=false"`
+	// Retention is how long the notifications are kept, 0 keeps them until they are dismissed
+	Retention time.Duration `env:"NOTIFICATIONS_RETENTION, default=720h"`
+	// Stream delivers the new notifications to the clients with server-sent events
+	Stream bool `env:"NOTIFICATIONS_STREAM, default=false"`
+	// StreamPingInterval is the interval of the comments keeping the streams open through the proxies
+	StreamPingInterval time.Duration `env:"NOTIFICATIONS_STREAM_PING_INTERVAL, default=30s"`
+}
+
+// Views are the saved list views of the users, their named filters and columns of the resources
+type Views struct {
+	Enabled bool `env:"VIEWS_ENABLED, default=false"`
+	// MaxPerUser is the maximum of views of a user per resource, 0 is unlimited
+	MaxPerUser int64 `env:"VIEWS_MAX_PER_USER, default=100"`
+}
+
+// Counters are the counter fields of the resources, updated on the mutations of the counted objects
+type Counters struct {
+	// ReconcileInterval is how often the counters that differ from the counts are corrected, 0 disables it
+	ReconcileInterval time.Duration `env:"COUNTERS_RECONCILE_INTERVAL, default=1h"`
+}
+
+// Features are the optional capabilities of the responses that the clients opt into per request with the
+// X-Respite-Features header, e.g. during the migration periods of their clients
+type Features struct {
+	// Offered are the features that the clients can opt into: cursor-pagination, problem-json and json-api
+	Offered []string `env:"FEATURES_OFFERED"`
+}
+
+// ExportSchedules are the export templates of the users, delivered on their schedules by email or to the storage
+type ExportSchedules struct {
+	Enabled bool `env:"EXPORT_SCHEDULES_ENABLED, default=false"`
+	// CheckInterval is how often the due schedules are started
+	CheckInterval time.Duration `env:"EXPORT_SCHEDULES_CHECK_INTERVAL, default=1m"`
+	// MaxPerUser is the maximum of schedules of a user per resource, 0 is unlimited
+	MaxPerUser int64 `env:"EXPORT_SCHEDULES_MAX_PER_USER, default=20"`
+	// History is the number of runs kept per schedule, 0 keeps all
+	History int64 `env:"EXPORT_SCHEDULES_HISTORY, default=50"`
+	// PublicURL is the address of the server in the download links of the emails, empty disables the emails
+	PublicURL string `env:"EXPORT_SCHEDULES_PUBLIC_URL"`
+	// LinkExpiry is the validity of the download links of the emails
+	LinkExpiry time.Duration `env:"EXPORT_SCHEDULES_LINK_EXPIRY, default=168h"`
+}
+
+// Metering keeps the storage usage of the users, their objects and attachment bytes, for billing and capacity planning
+type Metering struct {
+	Enabled bool `env:"METERING_ENABLED, default=false"`
+	// Requests counts the requests of every user per resource and method in the request_usage table
+	Requests bool `env:"METERING_REQUESTS, default=false"`
+	// RequestsBucket is the period of the rows of the request counts, e.g. 1h or 24h
+	RequestsBucket time.Duration `env:"METERING_REQUESTS_BUCKET, default=1h"`
+	// RequestsFlushInterval is how often the request counts kept in memory are added to the table
+	RequestsFlushInterval time.Duration `env:"METERING_REQUESTS_FLUSH_INTERVAL, default=1m"`
+}
+
+// Outbound configures the HTTP client of the integrations calling other services
+type Outbound struct {
+	// Timeout limits a request including its retries
+	Timeout time.Duration `env:"OUTBOUND_TIMEOUT, default=10s"`
+	// Retries is how many times failed idempotent requests are repeated, 0 disables the retries
+	Retries int `env:"OUTBOUND_RETRIES, default=2"`
+	// RetryBackoff is the delay of the first retry, it is doubled for every further retry
+	RetryBackoff time.Duration `env:"OUTBOUND_RETRY_BACKOFF, default=200ms"`
+	// ServiceToken sends the token of the client credentials of AUTH_CLIENT_ID with the requests
+	ServiceToken bool `env:"OUTBOUND_SERVICE_TOKEN, default=true"`
+	// BreakerFailures is how many consecutive failed requests open the circuit breaker of a host, 0 disables the
+	// breakers
+	BreakerFailures int `env:"OUTBOUND_BREAKER_FAILURES, default=5"`
+	// BreakerTimeout is how long the open breaker of a host fails the requests before a trial request is let through
+	BreakerTimeout time.Duration `env:"OUTBOUND_BREAKER_TIMEOUT, default=30s"`
+}
+
+type Alerts struct {
+	WebhookURL       string        `env:"ALERTS_WEBHOOK_URL"`
+	Format           string        `env:"ALERTS_FORMAT, default=slack"`
+	Window           time.Duration `env:"ALERTS_WINDOW, default=5m"`
+	AuthFailures     int           `env:"ALERTS_AUTH_FAILURES, default=20"`
+	Panics           int           `env:"ALERTS_PANICS, default=1"`
+	DeliveryAttempts int           `env:"ALERTS_DELIVERY_ATTEMPTS, default=10"`
+	DatabaseInterval time.Duration `env:"ALERTS_DATABASE_INTERVAL, default=10s"`
+}
+
+type Metrics struct {
+	Enabled   bool   `env:"METRICS_ENABLED, default=false"`
+	Path      string `env:"METRICS_PATH, default=/metrics"`
+	Namespace string `env:"METRICS_NAMESPACE, default=respite"`
+	// HTTPBuckets are the upper bounds in seconds of the request duration buckets, the Prometheus defaults are used when empty
+	HTTPBuckets []float64 `env:"METRICS_HTTP_BUCKETS"`
+	// SLOGroups maps the service level objective names to the route template prefix of their requests, e.g. api:/api/
+	SLOGroups map[string]string `env:"METRICS_SLO_GROUPS"`
+	// SLOAvailability is the percentage of requests per objective that do not fail with a server error, e.g. api:99.9
+	SLOAvailability map[string]float64 `env:"METRICS_SLO_AVAILABILITY"`
+	// SLOLatency is the duration per objective that requests should not exceed, e.g. api:300ms
+	SLOLatency map[string]time.Duration `env:"METRICS_SLO_LATENCY"`
+	// SLOLatencyTarget is the percentage of requests per objective that are faster than the latency, e.g. api:99
+	SLOLatencyTarget map[string]float64 `env:"METRICS_SLO_LATENCY_TARGET"`
+	// SLOWindows are the windows of the exported burn rates
+	SLOWindows []time.Duration `env:"METRICS_SLO_WINDOWS, default=5m,30m,1h,6h"`
+	// SLOPeriod is the period of the error budget
+	SLOPeriod time.Duration `env:"METRICS_SLO_PERIOD, default=720h"`
+}
+
+type Tracing struct {
+	Enabled      bool    `env:"TRACING_ENABLED, default=false"`
+	Endpoint     string  `env:"TRACING_ENDPOINT"`
+	ServiceName  string  `env:"TRACING_SERVICE_NAME, default=respite"`
+	SampleRatio  float64 `env:"TRACING_SAMPLE_RATIO, default=1"`
+	DBStatements bool    `env:"TRACING_DB_STATEMENTS, default=true"`
+}
+
+type Origin struct {
+	Region string            `env:"ORIGIN_REGION"`
+	Labels map[string]string `env:"ORIGIN_LABELS"`
+}
+
+type Jobs struct {
+	Workers      int           `env:"JOBS_WORKERS, default=2"`
+	PollInterval time.Duration `env:"JOBS_POLL_INTERVAL, default=1s"`
+	StaleAfter   time.Duration `env:"JOBS_STALE_AFTER, default=10m"`
+}
+
+type Vault struct {
+	Address             string `env:"VAULT_ADDR"`
+	Namespace           string `env:"VAULT_NAMESPACE"`
+	AuthMethod          string `env:"VAULT_AUTH_METHOD, default=token"`
+	Token               string `env:"VAULT_TOKEN"`
+	Role                string `env:"VAULT_ROLE"`
+	KubernetesMount     string `env:"VAULT_KUBERNETES_MOUNT, default=kubernetes"`
+	KubernetesTokenPath string `env:"VAULT_KUBERNETES_TOKEN_PATH, default=/var/run/secrets/kubernetes.io/serviceaccount/token"`
+	AppRoleMount        string `env:"VAULT_APPROLE_MOUNT, default=approle"`
+	AppRoleID           string `env:"VAULT_APPROLE_ROLE_ID"`
+	AppRoleSecretID     string `env:"VAULT_APPROLE_SECRET_ID"`
+	KVMount             string `env:"VAULT_KV_MOUNT, default=secret"`
+	DatabaseMount       string `env:"VAULT_DATABASE_MOUNT, default=database"`
+	DatabaseRole        string `env:"VAULT_DATABASE_ROLE"`
+	DatabaseSecret      string `env:"VAULT_DATABASE_SECRET"`
+	KeycloakSecret      string `env:"VAULT_KEYCLOAK_SECRET"`
+}
+
+type Config struct {
+	Components      []string `env:"COMPONENTS"`
+	Logger          Logger
+	DataBase        DataBase
+	Keycloak        Keycloak
+	IdentitySync    IdentitySync
+	SCIM            SCIM
+	LDAP            LDAP
+	FGA             FGA
+	Manifest        Manifest
+	Server          Server
+	AMQP            AMQP
+	Outbox          Outbox
+	Subscriptions   Subscriptions
+	Events          Events
+	Email           Email
+	Scheduler       Scheduler
+	Coordination    Coordination
+	Storage         Storage
+	Cache           Cache
+	Search          Search
+	Flags           Flags
+	Permissions     Permissions
+	Tenants         Tenants
+	Audit           Audit
+	Alerts          Alerts
+	Health          Health
+	Outbound        Outbound
+	Quotas          Quotas
+	Metering        Metering
+	Consent         Consent
+	Replay          Replay
+	StepUp          StepUp
+	CSP             CSP
+	EditLocks       EditLocks
+	Notifications   Notifications
+	Views           Views
+	ExportSchedules ExportSchedules
+	Counters        Counters
+	Features        Features
+	Jobs            Jobs
+	Metrics         Metrics
+	Tracing         Tracing
+	Origin          Origin
+	Vault           Vault
+	Dev             Dev
+	Bootstrap       Bootstrap
 }
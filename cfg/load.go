@@ -0,0 +1,122 @@
+package cfg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sethvargo/go-envconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// profilesKey is the section of the configuration file holding the profiles
+const profilesKey = "profiles"
+
+// Load reads the configuration file given in CONFIG_FILE with the profile given in CONFIG_PROFILE,
+// see LoadFile
+func Load(ctx context.Context) (*Config, error) {
+	return LoadFile(ctx, os.Getenv("CONFIG_FILE"), os.Getenv("CONFIG_PROFILE"))
+}
+
+// LoadFile reads the configuration from the YAML or JSON file and applies the environment variables, including
+// the _FILE variants of secrets, over it. The file uses the environment variable names as keys, values of the
+// selected profile in the profiles section override the top level ones. Without a file the configuration is
+// only read from the environment.
+func LoadFile(ctx context.Context, path, profile string) (*Config, error) {
+	values := map[string]string{}
+	var err error
+	if path != "" {
+		values, err = readFile(path, profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	lookuper, err := Lookuper()
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	err = envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   config,
+		Lookuper: envconfig.MultiLookuper(lookuper, envconfig.MapLookuper(values)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot process configuration: %w", err)
+	}
+	return config, nil
+}
+
+// readFile returns the values of the configuration file with the profile applied
+func readFile(path, profile string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read configuration file: %w", err)
+	}
+	document := map[string]yaml.Node{}
+	err = yaml.Unmarshal(content, &document)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse configuration file %s: %w", path, err)
+	}
+	values := map[string]string{}
+	err = addValues(values, document)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	if profile == "" {
+		return values, nil
+	}
+	profilesNode, ok := document[profilesKey]
+	if !ok {
+		return nil, fmt.Errorf("configuration file %s has no profiles", path)
+	}
+	profiles := map[string]map[string]yaml.Node{}
+	err = profilesNode.Decode(&profiles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profiles in configuration file %s: %w", path, err)
+	}
+	profileDocument, ok := profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("configuration file %s has no profile %s", path, profile)
+	}
+	err = addValues(values, profileDocument)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile %s in configuration file %s: %w", profile, path, err)
+	}
+	return values, nil
+}
+
+// addValues converts the nodes to the environment variable representation, lists are joined by commas
+// and maps are given as comma separated key:value pairs
+func addValues(values map[string]string, document map[string]yaml.Node) error {
+	for key, node := range document {
+		if key == profilesKey {
+			continue
+		}
+		switch node.Kind {
+		case yaml.ScalarNode:
+			values[key] = node.Value
+		case yaml.SequenceNode:
+			items := make([]string, 0, len(node.Content))
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("list %s can contain only values", key)
+				}
+				items = append(items, item.Value)
+			}
+			values[key] = strings.Join(items, ",")
+		case yaml.MappingNode:
+			pairs := make([]string, 0, len(node.Content)/2)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i+1].Kind != yaml.ScalarNode {
+					return fmt.Errorf("map %s can contain only values", key)
+				}
+				pairs = append(pairs, node.Content[i].Value+":"+node.Content[i+1].Value)
+			}
+			values[key] = strings.Join(pairs, ",")
+		default:
+			return fmt.Errorf("unsupported value of %s", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package cfg
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// REDACTED replaces the values of secrets
+const REDACTED = "REDACTED"
+
+// secretNames are parts of the variable names holding secrets
+var secretNames = []string{"PASSWORD", "SECRET", "TOKEN", "_KEY"}
+
+// Redacted returns the values of the configuration sections keyed by variable name, secrets and passwords of
+// URLs are replaced, so that the configuration can be shown to operators
+func Redacted(sections ...any) map[string]any {
+	values := map[string]any{}
+	for _, section := range sections {
+		redact(reflect.Indirect(reflect.ValueOf(section)), values)
+	}
+	return values
+}
+
+// redact adds the fields of the struct with env tags to the values, nested structs without tag are included
+func redact(value reflect.Value, values map[string]any) {
+	if value.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, ok := field.Tag.Lookup("env")
+		if !ok {
+			redact(value.Field(i), values)
+			continue
+		}
+		name := strings.TrimSpace(strings.SplitN(tag, ",", 2)[0])
+		values[name] = redactValue(name, value.Field(i))
+	}
+}
+
+// redactValue replaces secrets and the password of URLs
+func redactValue(name string, value reflect.Value) any {
+	if value.IsZero() {
+		if duration, ok := value.Interface().(time.Duration); ok {
+			return duration.String()
+		}
+		return value.Interface()
+	}
+	for _, secret := range secretNames {
+		if strings.Contains(name, secret) {
+			return REDACTED
+		}
+	}
+	switch typed := value.Interface().(type) {
+	case string:
+		return redactURL(typed)
+	case time.Duration:
+		return typed.String()
+	case []time.Duration:
+		texts := make([]string, len(typed))
+		for i, duration := range typed {
+			texts[i] = duration.String()
+		}
+		return texts
+	case map[string]time.Duration:
+		texts := make(map[string]string, len(typed))
+		for key, duration := range typed {
+			texts[key] = duration.String()
+		}
+		return texts
+	}
+	return value.Interface()
+}
+
+// redactURL replaces the password of a URL, other values are returned as they are
+func redactURL(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.User == nil {
+		return value
+	}
+	if _, ok := parsed.User.Password(); !ok {
+		return value
+	}
+	parsed.User = url.UserPassword(parsed.User.Username(), REDACTED)
+	return parsed.String()
+}
@@ -0,0 +1,47 @@
+package cfg
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
+// secretFileSuffix marks variables that point to a file holding the value, as mounted Docker and Kubernetes secrets
+const secretFileSuffix = "_FILE"
+
+// SecretFile reads the value of the variable from the file given in the variable with the _FILE suffix,
+// surrounding whitespace is removed. It reports false when the _FILE variable is not set.
+func SecretFile(variable string) (string, bool, error) {
+	path, ok := os.LookupEnv(variable + secretFileSuffix)
+	if !ok || path == "" {
+		return "", false, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("cannot read %s%s: %w", variable, secretFileSuffix, err)
+	}
+	return strings.TrimSpace(string(content)), true, nil
+}
+
+// Lookuper resolves the environment variables, variables that are not set are read from the files of their
+// _FILE variants. It can be used to process single configuration structs with envconfig.ProcessWith.
+func Lookuper() (envconfig.Lookuper, error) {
+	secrets := map[string]string{}
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		variable, ok := strings.CutSuffix(name, secretFileSuffix)
+		if !ok || variable == "" {
+			continue
+		}
+		value, ok, err := SecretFile(variable)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			secrets[variable] = value
+		}
+	}
+	return envconfig.MultiLookuper(envconfig.OsLookuper(), envconfig.MapLookuper(secrets)), nil
+}
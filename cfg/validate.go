@@ -0,0 +1,936 @@
+package cfg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// problems collects the problems of a configuration, each one names the environment variable to fix
+type problems []error
+
+// add records a problem of the variable
+func (p *problems) add(variable, format string, args ...any) {
+	*p = append(*p, fmt.Errorf("%s: %s", variable, fmt.Sprintf(format, args...)))
+}
+
+// required checks that the value is set
+func (p *problems) required(variable, value string) {
+	if strings.TrimSpace(value) == "" {
+		p.add(variable, "is required")
+	}
+}
+
+// oneOf checks that the value is one of the allowed ones
+func (p *problems) oneOf(variable, value string, allowed ...string) {
+	if !slices.Contains(allowed, value) {
+		p.add(variable, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+	}
+}
+
+// port checks that the value is a port number
+func (p *problems) port(variable, value string) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		p.add(variable, "must be a port number between 1 and 65535, got %q", value)
+	}
+}
+
+// positive checks that the duration is greater than zero
+func (p *problems) positive(variable string, value time.Duration) {
+	if value <= 0 {
+		p.add(variable, "must be greater than 0, got %s", value)
+	}
+}
+
+// notNegative checks that the number or duration is not negative
+func (p *problems) notNegative(variable string, value int64) {
+	if value < 0 {
+		p.add(variable, "must not be negative, got %d", value)
+	}
+}
+
+// percentage checks that the value is a percentage between 0 and 100, both excluded
+func (p *problems) percentage(variable string, value float64) {
+	if value <= 0 || value >= 100 {
+		p.add(variable, "must be a percentage between 0 and 100, got %v", value)
+	}
+}
+
+// url checks that the value is an absolute URL with one of the schemes
+func (p *problems) url(variable, value string, schemes ...string) {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" || !slices.Contains(schemes, parsed.Scheme) {
+		p.add(variable, "must be a URL with scheme %s, got %q", strings.Join(schemes, " or "), value)
+	}
+}
+
+// err returns the problems as one error
+func (p problems) err() error {
+	return errors.Join(p...)
+}
+
+// Validate checks the logger configuration
+func (config Logger) Validate() error {
+	var p problems
+	p.oneOf("LOG_LEVEL", config.Level, "", "debug", "info", "warn", "error")
+	p.oneOf("LOG_FORMAT", config.Format, "", "text", "json")
+	return p.err()
+}
+
+// Validate checks the database configuration
+func (config DataBase) Validate() error {
+	return config.validate(true)
+}
+
+// validate checks the database configuration, the user is not required when the credentials are read from Vault
+func (config DataBase) validate(credentials bool) error {
+	var p problems
+	p.oneOf("DB_BACKEND", config.Backend, "", "postgres", "cockroachdb", "memory")
+	if config.Backend == "memory" {
+		if config.RowLevelSecurity {
+			p.add("DB_ROW_LEVEL_SECURITY", "requires DB_BACKEND postgres")
+		}
+		return p.err()
+	}
+	p.required("DB_HOST", config.Host)
+	if credentials {
+		p.required("DB_USER", config.User)
+	}
+	p.required("DB_NAME", config.DatabaseName)
+	p.port("DB_PORT", config.Port)
+	for _, host := range config.FailoverHosts {
+		name, port, found := strings.Cut(host, ":")
+		if strings.TrimSpace(name) == "" {
+			p.add("DB_FAILOVER_HOSTS", "hosts must not be empty, got %q", host)
+		}
+		if found {
+			p.port("DB_FAILOVER_HOSTS", port)
+		}
+	}
+	if len(config.FailoverHosts) > 0 {
+		p.positive("DB_FAILOVER_CHECK_INTERVAL", config.FailoverCheckInterval)
+	}
+	p.notNegative("DB_CREDENTIALS_CHECK_INTERVAL", int64(config.CredentialsCheckInterval))
+	p.notNegative("DB_QUERY_TIMEOUT", int64(config.QueryTimeout))
+	p.notNegative("DB_STATEMENT_TIMEOUT", int64(config.StatementTimeout))
+	p.notNegative("DB_TRANSACTION_RETRIES", int64(config.TransactionRetries))
+	p.notNegative("DB_BREAKER_FAILURES", int64(config.BreakerFailures))
+	if config.BreakerFailures > 0 {
+		p.positive("DB_BREAKER_TIMEOUT", config.BreakerTimeout)
+	}
+	if config.Backend == "cockroachdb" && config.RowLevelSecurity {
+		p.add("DB_ROW_LEVEL_SECURITY", "requires DB_BACKEND postgres")
+	}
+	return p.err()
+}
+
+// Validate checks the identity sync configuration
+func (config IdentitySync) Validate() error {
+	var p problems
+	p.notNegative("IDENTITY_SYNC_POLL_INTERVAL", int64(config.PollInterval))
+	p.notNegative("IDENTITY_SYNC_LOOKBACK", int64(config.Lookback))
+	if config.Enabled() {
+		p.positive("IDENTITY_SYNC_REVOCATION_TTL", config.RevocationTTL)
+		p.oneOf("IDENTITY_SYNC_DELETED_USERS", config.DeletedUsers, "keep", "erase")
+	}
+	return p.err()
+}
+
+// Validate checks the SCIM configuration
+func (config SCIM) Validate() error {
+	if config.Token == "" {
+		return nil
+	}
+	var p problems
+	if len(config.Token) < 32 {
+		p.add("SCIM_TOKEN", "must have at least 32 characters, got %d", len(config.Token))
+	}
+	if config.MaxResults <= 0 {
+		p.add("SCIM_MAX_RESULTS", "must be greater than 0, got %d", config.MaxResults)
+	}
+	return p.err()
+}
+
+// Validate checks the LDAP configuration
+func (config LDAP) Validate() error {
+	if config.URL == "" {
+		return nil
+	}
+	var p problems
+	p.url("LDAP_URL", config.URL, "ldap", "ldaps")
+	p.required("LDAP_BASE_DN", config.BaseDN)
+	p.required("LDAP_USER_FILTER", config.UserFilter)
+	if config.GroupFilter == "" {
+		p.required("LDAP_GROUP_ATTRIBUTE", config.GroupAttribute)
+	}
+	p.positive("LDAP_CACHE_TTL", config.CacheTTL)
+	p.positive("LDAP_TIMEOUT", config.Timeout)
+	return p.err()
+}
+
+// Validate checks the OpenFGA configuration
+func (config FGA) Validate() error {
+	if config.URL == "" {
+		return nil
+	}
+	var p problems
+	p.url("FGA_URL", config.URL, "http", "https")
+	p.required("FGA_STORE_ID", config.StoreID)
+	p.required("FGA_USER_TYPE", config.UserType)
+	p.positive("FGA_TIMEOUT", config.Timeout)
+	return p.err()
+}
+
+// Validate checks the manifest configuration
+func (config Manifest) Validate() error {
+	if config.Path == "" {
+		return nil
+	}
+	var p problems
+	p.positive("MANIFEST_INTERVAL", config.Interval)
+	return p.err()
+}
+
+// Validate checks the Keycloak configuration
+func (config Keycloak) Validate() error {
+	var p problems
+	p.url("AUTH_URL", config.AuthURL, "http", "https")
+	p.required("AUTH_REALM", config.AuthRealm)
+	p.required("AUTH_CLIENT_ID", config.AuthClientID)
+	p.notNegative("AUTH_RETRIES", int64(config.AuthRetries))
+	p.notNegative("AUTH_RETRY_BACKOFF", int64(config.AuthRetryBackoff))
+	p.notNegative("AUTH_BREAKER_FAILURES", int64(config.AuthBreakerFailures))
+	if config.AuthBreakerFailures > 0 {
+		p.positive("AUTH_BREAKER_TIMEOUT", config.AuthBreakerTimeout)
+	}
+	return p.err()
+}
+
+// Validate checks the server configuration
+func (config Server) Validate() error {
+	var p problems
+	if strings.Trim(config.APIPath, "/") == "" || strings.Contains(config.APIPath, "/") {
+		p.add("SERVER_API_PATH", "must be a single path segment, got %q", config.APIPath)
+	}
+	p.port("SERVER_PORT", config.Port)
+	if config.GRPCPort != "" {
+		p.port("SERVER_GRPC_PORT", config.GRPCPort)
+		if config.GRPCPort == config.Port {
+			p.add("SERVER_GRPC_PORT", "must differ from SERVER_PORT")
+		}
+	}
+	p.notNegative("SERVER_WRITE_TIMEOUT", int64(config.WriteTimeout))
+	p.notNegative("SERVER_READ_TIMEOUT", int64(config.ReadTimeout))
+	p.notNegative("SERVER_IDLE_TIMEOUT", int64(config.IdleTimeout))
+	p.notNegative("SERVER_DEADLINE_ON_INTERRUPT", int64(config.DeadlineOnInterrupt))
+	p.notNegative("SERVER_SHUTDOWN_DELAY", int64(config.ShutdownDelay))
+	if config.GatedStartup {
+		p.positive("SERVER_STARTUP_RETRY_AFTER", config.StartupRetryAfter)
+	}
+	p.positive("SERVER_WARM_UP_TIMEOUT", config.WarmUpTimeout)
+	if config.MinPageSize < 1 {
+		p.add("SERVER_MIN_PAGE_SIZE", "must be greater than 0, got %d", config.MinPageSize)
+	}
+	if config.MaxPageSize < config.MinPageSize {
+		p.add("SERVER_MAX_PAGE_SIZE", "must not be less than SERVER_MIN_PAGE_SIZE %d, got %d", config.MinPageSize, config.MaxPageSize)
+	}
+	p.notNegative("SERVER_MAX_OFFSET", int64(config.MaxOffset))
+	p.notNegative("SERVER_KEYSET_OFFSET", int64(config.KeysetOffset))
+	p.positive("SERVER_TIMESTAMP_PRECISION", config.TimestampPrecision)
+	p.required("SERVER_DEFAULT_LOCALE", config.DefaultLocale)
+	p.notNegative("SERVER_MAX_FILTER_CONDITIONS", int64(config.MaxFilterConditions))
+	p.notNegative("SERVER_MAX_EXPAND_DEPTH", int64(config.MaxExpandDepth))
+	p.notNegative("SERVER_MAX_RELATIONS", int64(config.MaxRelations))
+	p.notNegative("SERVER_MAX_BATCH_OPERATIONS", int64(config.MaxBatchOperations))
+	p.notNegative("SERVER_MAX_TRANSFORM_LENGTH", int64(config.MaxTransformLength))
+	// The assets are served by another server or by the static route of the application
+	if config.DocsEnabled && !strings.HasPrefix(config.DocsAssetsURL, "/") {
+		p.url("SERVER_DOCS_ASSETS_URL", config.DocsAssetsURL, "http", "https")
+	}
+	if config.SigningKey != "" {
+		if len(config.SigningKey) < 32 {
+			p.add("SERVER_SIGNING_KEY", "must have at least 32 characters, got %d", len(config.SigningKey))
+		}
+		p.positive("SERVER_SIGNED_URL_EXPIRY", config.SignedURLExpiry)
+	}
+	return p.err()
+}
+
+// Validate checks the AMQP configuration, without URL the publisher is not used
+func (config AMQP) Validate() error {
+	var p problems
+	if config.URL == "" {
+		return nil
+	}
+	p.url("AMQP_URL", config.URL, "amqp", "amqps")
+	p.required("AMQP_EXCHANGE", config.Exchange)
+	p.oneOf("AMQP_EXCHANGE_TYPE", config.ExchangeType, "direct", "fanout", "topic", "headers")
+	if config.Confirms {
+		p.positive("AMQP_CONFIRM_TIMEOUT", config.ConfirmTimeout)
+	}
+	p.positive("AMQP_RECONNECT_DELAY", config.ReconnectDelay)
+	return p.err()
+}
+
+// Validate checks the outbox configuration
+func (config Outbox) Validate() error {
+	var p problems
+	if !config.Enabled {
+		return nil
+	}
+	p.positive("OUTBOX_POLL_INTERVAL", config.PollInterval)
+	if config.BatchSize < 1 {
+		p.add("OUTBOX_BATCH_SIZE", "must be greater than 0, got %d", config.BatchSize)
+	}
+	p.notNegative("OUTBOX_RETENTION", int64(config.Retention))
+	p.notNegative("OUTBOX_CHANGES_DELAY", int64(config.ChangesDelay))
+	return p.err()
+}
+
+// Validate checks the subscriptions configuration
+func (config Subscriptions) Validate() error {
+	var p problems
+	if !config.Enabled {
+		return nil
+	}
+	if config.BufferSize < 1 {
+		p.add("SUBSCRIPTIONS_BUFFER_SIZE", "must be greater than 0, got %d", config.BufferSize)
+	}
+	p.positive("SUBSCRIPTIONS_PING_INTERVAL", config.PingInterval)
+	if config.Presence {
+		p.positive("SUBSCRIPTIONS_PRESENCE_TTL", config.PresenceTTL)
+		// The presence is refreshed by the answers to the pings
+		if config.PresenceTTL > 0 && config.PresenceTTL <= config.PingInterval {
+			p.add("SUBSCRIPTIONS_PRESENCE_TTL", "must be longer than SUBSCRIPTIONS_PING_INTERVAL %s, got %s", config.PingInterval, config.PresenceTTL)
+		}
+	}
+	return p.err()
+}
+
+// Validate checks the events configuration
+func (config Events) Validate() error {
+	var p problems
+	p.oneOf("EVENTS_FORMAT", config.Format, "json", "cloudevents")
+	if config.Format == "cloudevents" {
+		p.required("EVENTS_SOURCE", config.Source)
+	}
+	return p.err()
+}
+
+// Validate checks the email configuration, without sender and provider settings email is not used
+func (config Email) Validate() error {
+	var p problems
+	if config.From == "" && config.SMTPHost == "" && config.SendGridAPIKey == "" {
+		return nil
+	}
+	p.required("EMAIL_FROM", config.From)
+	p.oneOf("EMAIL_PROVIDER", config.Provider, "smtp", "sendgrid")
+	switch config.Provider {
+	case "smtp":
+		p.required("EMAIL_SMTP_HOST", config.SMTPHost)
+		p.port("EMAIL_SMTP_PORT", config.SMTPPort)
+		if config.SMTPPassword != "" && config.SMTPUser == "" {
+			p.add("EMAIL_SMTP_USER", "is required with EMAIL_SMTP_PASSWORD")
+		}
+	case "sendgrid":
+		p.required("EMAIL_SENDGRID_API_KEY", config.SendGridAPIKey)
+		p.url("EMAIL_SENDGRID_URL", config.SendGridURL, "http", "https")
+	}
+	return p.err()
+}
+
+// Validate checks the scheduler configuration, zero values are replaced by defaults
+func (config Scheduler) Validate() error {
+	var p problems
+	p.notNegative("SCHEDULER_CHECK_INTERVAL", int64(config.CheckInterval))
+	p.notNegative("SCHEDULER_LEADER_RETRY", int64(config.LeaderRetry))
+	return p.err()
+}
+
+// Validate checks the coordination configuration
+func (config Coordination) Validate() error {
+	var p problems
+	p.notNegative("COORDINATION_LEADER_RETRY", int64(config.LeaderRetry))
+	p.notNegative("COORDINATION_HEARTBEAT_INTERVAL", int64(config.HeartbeatInterval))
+	return p.err()
+}
+
+// Validate checks the storage configuration
+func (config Storage) Validate() error {
+	var p problems
+	p.oneOf("STORAGE_BACKEND", config.Backend, "local", "s3")
+	switch config.Backend {
+	case "local":
+		p.required("STORAGE_LOCAL_PATH", config.LocalPath)
+	case "s3":
+		p.required("STORAGE_S3_ENDPOINT", config.S3Endpoint)
+		p.required("STORAGE_S3_BUCKET", config.S3Bucket)
+		if (config.S3AccessKey == "") != (config.S3SecretKey == "") {
+			p.add("STORAGE_S3_ACCESS_KEY", "must be set together with STORAGE_S3_SECRET_KEY")
+		}
+		p.oneOf("STORAGE_S3_ENCRYPTION", config.S3Encryption, "none", "s3", "kms")
+		if config.S3Encryption == "kms" {
+			p.required("STORAGE_S3_KMS_KEY_ID", config.S3KMSKeyID)
+		} else if config.S3KMSKeyID != "" {
+			p.add("STORAGE_S3_KMS_KEY_ID", "is used only with STORAGE_S3_ENCRYPTION=kms")
+		}
+		if config.PresignExpiry < time.Second || config.PresignExpiry > 7*24*time.Hour {
+			p.add("STORAGE_PRESIGN_EXPIRY", "must be between 1s and 168h, got %s", config.PresignExpiry)
+		}
+	}
+	if config.MaxUploadSize < 1 {
+		p.add("STORAGE_MAX_UPLOAD_SIZE", "must be greater than 0, got %d", config.MaxUploadSize)
+	}
+	p.oneOf("STORAGE_SCAN_BACKEND", config.ScanBackend, "", "clamav", "icap")
+	if config.ScanBackend != "" {
+		p.required("STORAGE_SCAN_ADDRESS", config.ScanAddress)
+		p.positive("STORAGE_SCAN_TIMEOUT", config.ScanTimeout)
+	}
+	if config.ScanBackend == "icap" {
+		p.required("STORAGE_SCAN_ICAP_SERVICE", config.ScanICAPService)
+	}
+	for name, size := range config.ImageVariants {
+		if name == "" || strings.IndexFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' }) >= 0 {
+			p.add("STORAGE_IMAGE_VARIANTS", "variant name must have only letters, digits, - and _, got %q", name)
+		}
+		var width, height int
+		_, err := fmt.Sscanf(size, "%dx%d", &width, &height)
+		if err != nil || width < 1 || height < 1 || fmt.Sprintf("%dx%d", width, height) != size {
+			p.add("STORAGE_IMAGE_VARIANTS", "size of variant %s must be <width>x<height>, e.g. 200x200, got %q", name, size)
+		}
+	}
+	return p.err()
+}
+
+// Validate checks the cache configuration
+func (config Cache) Validate() error {
+	var p problems
+	p.oneOf("CACHE_BACKEND", config.Backend, "memory", "redis")
+	if config.Backend == "redis" {
+		p.url("CACHE_REDIS_URL", config.RedisURL, "redis", "rediss")
+	}
+	if config.ResponseLocal && config.Backend == "redis" {
+		p.required("CACHE_INVALIDATIONS", config.Invalidations)
+	}
+	p.notNegative("CACHE_TOKEN_TTL", int64(config.TokenTTL))
+	p.notNegative("CACHE_RESPONSE_TTL", int64(config.ResponseTTL))
+	p.notNegative("CACHE_RATE_LIMIT", config.RateLimit)
+	p.notNegative("CACHE_PUBLIC_RATE_LIMIT", config.PublicRateLimit)
+	if config.RateLimit > 0 || len(config.ResourceRateLimits) > 0 || config.PublicRateLimit > 0 {
+		p.positive("CACHE_RATE_LIMIT_WINDOW", config.RateLimitWindow)
+	}
+	p.positive("CACHE_IDEMPOTENCY_TTL", config.IdempotencyTTL)
+	for name, limit := range config.ResourceRateLimits {
+		if limit < 1 {
+			p.add("CACHE_RESOURCE_RATE_LIMITS", "limit of %s must be greater than 0, got %d", name, limit)
+		}
+	}
+	_, err := config.Proxies()
+	if err != nil {
+		p.add("CACHE_TRUSTED_PROXIES", "must be addresses or CIDR ranges: %v", err)
+	}
+	for name, cost := range config.RequestCosts {
+		lastDot := strings.LastIndex(name, ".")
+		p.oneOf("CACHE_REQUEST_COSTS", name[lastDot+1:], "get", "list", "create", "update", "delete", "export", "import", "changes")
+		if cost < 1 {
+			p.add("CACHE_REQUEST_COSTS", "cost of %s must be greater than 0, got %d", name, cost)
+		}
+	}
+	return p.err()
+}
+
+// Validate checks the search configuration, without resources search is not used
+func (config Search) Validate() error {
+	var p problems
+	if len(config.Resources) == 0 {
+		return nil
+	}
+	p.oneOf("SEARCH_PROVIDER", config.Provider, "postgres", "elasticsearch")
+	switch config.Provider {
+	case "postgres":
+		p.required("SEARCH_POSTGRES_LANGUAGE", config.PostgresLanguage)
+	case "elasticsearch":
+		p.url("SEARCH_ELASTICSEARCH_URL", config.ElasticsearchURL, "http", "https")
+		if config.ElasticsearchAPIKey != "" && config.ElasticsearchUsername != "" {
+			p.add("SEARCH_ELASTICSEARCH_API_KEY", "cannot be combined with SEARCH_ELASTICSEARCH_USERNAME")
+		}
+		if config.ElasticsearchPassword != "" && config.ElasticsearchUsername == "" {
+			p.add("SEARCH_ELASTICSEARCH_USERNAME", "is required with SEARCH_ELASTICSEARCH_PASSWORD")
+		}
+	}
+	return p.err()
+}
+
+// Validate checks the feature flags configuration
+func (config Dev) Validate() error {
+	var p problems
+	p.required("DEV_DATABASE", config.Database)
+	p.notNegative("DEV_FAKE_DATA", int64(config.FakeData))
+	return p.err()
+}
+
+func (config Bootstrap) Validate() error {
+	var p problems
+	if config.AdminSubject != "" {
+		_, err := uuid.FromString(config.AdminSubject)
+		if err != nil {
+			p.add("BOOTSTRAP_ADMIN_SUBJECT", "must be a UUID")
+		}
+	}
+	if config.AdminEmail != "" && !strings.Contains(config.AdminEmail, "@") {
+		p.add("BOOTSTRAP_ADMIN_EMAIL", "must be an email address")
+	}
+	if config.Enabled() {
+		p.required("BOOTSTRAP_ADMIN_ROLE", config.AdminRole)
+	}
+	return p.err()
+}
+
+func (config Flags) Validate() error {
+	var p problems
+	if config.Definitions != "" && !json.Valid([]byte(config.Definitions)) {
+		p.add("FEATURE_FLAGS", "must be a JSON list of flags")
+	}
+	if config.Database {
+		p.positive("FEATURE_FLAGS_REFRESH_INTERVAL", config.RefreshInterval)
+	}
+	return p.err()
+}
+
+// Validate checks the role permissions configuration
+func (config Permissions) Validate() error {
+	var p problems
+	if config.Database {
+		p.positive("PERMISSIONS_REFRESH_INTERVAL", config.RefreshInterval)
+	}
+	return p.err()
+}
+
+// Validate checks the tenants configuration
+func (config Tenants) Validate() error {
+	var p problems
+	if config.Database {
+		p.positive("TENANTS_REFRESH_INTERVAL", config.RefreshInterval)
+	}
+	return p.err()
+}
+
+// Validate checks the audit configuration
+func (config Audit) Validate() error {
+	var p problems
+	for _, sink := range config.Sinks {
+		switch sink {
+		case "database":
+		case "syslog":
+			p.oneOf("AUDIT_SYSLOG_NETWORK", config.SyslogNetwork, "", "udp", "tcp")
+			if config.SyslogNetwork != "" {
+				p.required("AUDIT_SYSLOG_ADDRESS", config.SyslogAddress)
+			}
+		case "http":
+			p.url("AUDIT_HTTP_URL", config.HTTPURL, "http", "https")
+		case "kafka":
+			if len(config.KafkaBrokers) == 0 {
+				p.add("AUDIT_KAFKA_BROKERS", "is required with the kafka sink")
+			}
+			p.required("AUDIT_KAFKA_TOPIC", config.KafkaTopic)
+		default:
+			p.add("AUDIT_SINKS", "must contain only database, syslog, http or kafka, got %q", sink)
+		}
+	}
+	return p.err()
+}
+
+// Validate checks the alerts configuration, without webhook alerts are not sent
+func (config Alerts) Validate() error {
+	var p problems
+	if config.WebhookURL == "" {
+		return nil
+	}
+	p.url("ALERTS_WEBHOOK_URL", config.WebhookURL, "http", "https")
+	p.oneOf("ALERTS_FORMAT", config.Format, "slack", "json")
+	p.positive("ALERTS_WINDOW", config.Window)
+	p.notNegative("ALERTS_AUTH_FAILURES", int64(config.AuthFailures))
+	p.notNegative("ALERTS_PANICS", int64(config.Panics))
+	p.notNegative("ALERTS_DELIVERY_ATTEMPTS", int64(config.DeliveryAttempts))
+	p.positive("ALERTS_DATABASE_INTERVAL", config.DatabaseInterval)
+	return p.err()
+}
+
+// Validate checks the health check configuration, zero interval and timeout use the defaults
+func (config Health) Validate() error {
+	var p problems
+	p.notNegative("HEALTH_CHECK_INTERVAL", int64(config.CheckInterval))
+	p.notNegative("HEALTH_CHECK_TIMEOUT", int64(config.CheckTimeout))
+	p.notNegative("HEALTH_READINESS_THRESHOLD", int64(config.ReadinessThreshold))
+	p.notNegative("HEALTH_LIVENESS_THRESHOLD", int64(config.LivenessThreshold))
+	return p.err()
+}
+
+// Validate checks the quotas configuration
+func (config Quotas) Validate() error {
+	var p problems
+	p.oneOf("QUOTA_SCOPE", config.Scope, "", "user", "tenant")
+	for name, limit := range config.Limits {
+		if limit < 1 {
+			p.add("QUOTA_LIMITS", "limit of %s must be greater than 0, got %d", name, limit)
+		}
+	}
+	return p.err()
+}
+
+// Validate checks the consent configuration, every document has a version
+func (config Consent) Validate() error {
+	var p problems
+	for document, version := range config.Documents {
+		if strings.TrimSpace(document) == "" || strings.TrimSpace(version) == "" {
+			p.add("CONSENT_DOCUMENTS", "documents must have a name and a version, got %q:%q", document, version)
+		}
+	}
+	return p.err()
+}
+
+// Validate checks the replay protection configuration, the requests of the operations are signed with the key
+func (config Replay) Validate() error {
+	if len(config.Operations) == 0 {
+		return nil
+	}
+	var p problems
+	p.required("REPLAY_SIGNING_KEY", config.SigningKey)
+	p.positive("REPLAY_WINDOW", config.Window)
+	if config.MaxBodySize <= 0 {
+		p.add("REPLAY_MAX_BODY_SIZE", "must be greater than 0, got %d", config.MaxBodySize)
+	}
+	for _, operation := range config.Operations {
+		if strings.TrimSpace(operation) == "" {
+			p.add("REPLAY_OPERATIONS", "operations must not be empty")
+		}
+	}
+	return p.err()
+}
+
+// Validate checks the step-up authentication configuration, the operations require an authentication context, an
+// authentication method or a recent authentication
+func (config StepUp) Validate() error {
+	if len(config.Operations) == 0 {
+		return nil
+	}
+	var p problems
+	p.notNegative("STEPUP_MAX_AGE", int64(config.MaxAge))
+	if len(config.ACR) == 0 && len(config.AMR) == 0 && config.MaxAge == 0 {
+		p.add("STEPUP_OPERATIONS", "require STEPUP_ACR, STEPUP_AMR or STEPUP_MAX_AGE")
+	}
+	for _, operation := range config.Operations {
+		if strings.TrimSpace(operation) == "" {
+			p.add("STEPUP_OPERATIONS", "operations must not be empty")
+		}
+	}
+	return p.err()
+}
+
+// Validate checks the Content Security Policy configuration, the reports are collected for a policy
+func (config CSP) Validate() error {
+	var p problems
+	if config.Policy == "" {
+		if config.ReportOnly {
+			p.add("CSP_REPORT_ONLY", "requires CSP_POLICY")
+		}
+		if config.Reports {
+			p.add("CSP_REPORTS", "requires CSP_POLICY")
+		}
+	}
+	if config.Reports {
+		p.notNegative("CSP_MAX_REPORT_SIZE", config.MaxReportSize)
+		if config.ForwardURL != "" {
+			p.url("CSP_FORWARD_URL", config.ForwardURL, "http", "https")
+		}
+	}
+	return p.err()
+}
+
+// Validate checks the edit locks configuration, the resources are checked when they are registered
+func (config EditLocks) Validate() error {
+	var p problems
+	if len(config.Resources) == 0 {
+		return nil
+	}
+	p.positive("EDIT_LOCK_TTL", config.TTL)
+	return p.err()
+}
+
+// Validate checks the notifications configuration when the inbox is enabled
+func (config Notifications) Validate() error {
+	var p problems
+	if !config.Enabled {
+		if config.Stream {
+			p.add("NOTIFICATIONS_STREAM", "requires NOTIFICATIONS_ENABLED")
+		}
+		return p.err()
+	}
+	p.notNegative("NOTIFICATIONS_RETENTION", int64(config.Retention))
+	if config.Stream {
+		p.positive("NOTIFICATIONS_STREAM_PING_INTERVAL", config.StreamPingInterval)
+	}
+	return p.err()
+}
+
+// Validate checks the views configuration when the views are enabled
+func (config Views) Validate() error {
+	var p problems
+	if !config.Enabled {
+		return nil
+	}
+	p.notNegative("VIEWS_MAX_PER_USER", config.MaxPerUser)
+	return p.err()
+}
+
+// Validate checks the export schedules configuration when the schedules are enabled
+func (config ExportSchedules) Validate() error {
+	var p problems
+	if !config.Enabled {
+		return nil
+	}
+	p.positive("EXPORT_SCHEDULES_CHECK_INTERVAL", config.CheckInterval)
+	p.notNegative("EXPORT_SCHEDULES_MAX_PER_USER", config.MaxPerUser)
+	p.notNegative("EXPORT_SCHEDULES_HISTORY", config.History)
+	if config.PublicURL != "" {
+		p.url("EXPORT_SCHEDULES_PUBLIC_URL", config.PublicURL, "http", "https")
+	}
+	p.positive("EXPORT_SCHEDULES_LINK_EXPIRY", config.LinkExpiry)
+	return p.err()
+}
+
+// Validate checks the counters configuration
+func (config Counters) Validate() error {
+	var p problems
+	p.notNegative("COUNTERS_RECONCILE_INTERVAL", int64(config.ReconcileInterval))
+	return p.err()
+}
+
+// Validate checks that the offered features are known
+func (config Features) Validate() error {
+	var p problems
+	for _, feature := range config.Offered {
+		p.oneOf("FEATURES_OFFERED", feature, "cursor-pagination", "problem-json", "json-api")
+	}
+	return p.err()
+}
+
+// Validate checks the metering configuration, the request counts are added up in buckets of whole seconds
+func (config Metering) Validate() error {
+	var p problems
+	if config.Requests {
+		p.positive("METERING_REQUESTS_BUCKET", config.RequestsBucket)
+		p.positive("METERING_REQUESTS_FLUSH_INTERVAL", config.RequestsFlushInterval)
+		if config.RequestsBucket%time.Second != 0 {
+			p.add("METERING_REQUESTS_BUCKET", "must be whole seconds, got %s", config.RequestsBucket)
+		}
+	}
+	return p.err()
+}
+
+// Validate checks the outbound HTTP client configuration, zero timeout and backoff use the defaults
+func (config Outbound) Validate() error {
+	var p problems
+	p.notNegative("OUTBOUND_TIMEOUT", int64(config.Timeout))
+	p.notNegative("OUTBOUND_RETRIES", int64(config.Retries))
+	p.notNegative("OUTBOUND_RETRY_BACKOFF", int64(config.RetryBackoff))
+	p.notNegative("OUTBOUND_BREAKER_FAILURES", int64(config.BreakerFailures))
+	if config.BreakerFailures > 0 {
+		p.positive("OUTBOUND_BREAKER_TIMEOUT", config.BreakerTimeout)
+	}
+	return p.err()
+}
+
+// Validate checks the jobs configuration
+func (config Jobs) Validate() error {
+	var p problems
+	p.notNegative("JOBS_WORKERS", int64(config.Workers))
+	p.positive("JOBS_POLL_INTERVAL", config.PollInterval)
+	p.positive("JOBS_STALE_AFTER", config.StaleAfter)
+	return p.err()
+}
+
+// Validate checks the metrics configuration
+func (config Metrics) Validate() error {
+	var p problems
+	if !config.Enabled {
+		return nil
+	}
+	if !strings.HasPrefix(config.Path, "/") {
+		p.add("METRICS_PATH", "must start with /, got %q", config.Path)
+	}
+	for i, bucket := range config.HTTPBuckets {
+		if bucket <= 0 || (i > 0 && bucket <= config.HTTPBuckets[i-1]) {
+			p.add("METRICS_HTTP_BUCKETS", "must be positive and increasing, got %v", config.HTTPBuckets)
+			break
+		}
+	}
+	config.validateSLOs(&p)
+	return p.err()
+}
+
+// validateSLOs checks that each objective has a target and that the targets belong to configured groups
+func (config Metrics) validateSLOs(p *problems) {
+	if len(config.SLOGroups) == 0 {
+		return
+	}
+	for name := range config.SLOGroups {
+		_, availability := config.SLOAvailability[name]
+		_, latency := config.SLOLatency[name]
+		if !availability && !latency {
+			p.add("METRICS_SLO_GROUPS", "objective %s needs an availability or latency target", name)
+		}
+		if _, ok := config.SLOLatencyTarget[name]; latency && !ok {
+			p.add("METRICS_SLO_LATENCY_TARGET", "is required for objective %s", name)
+		}
+	}
+	for name, target := range config.SLOAvailability {
+		if _, ok := config.SLOGroups[name]; !ok {
+			p.add("METRICS_SLO_AVAILABILITY", "unknown objective %s", name)
+		}
+		p.percentage("METRICS_SLO_AVAILABILITY", target)
+	}
+	for name, latency := range config.SLOLatency {
+		if _, ok := config.SLOGroups[name]; !ok {
+			p.add("METRICS_SLO_LATENCY", "unknown objective %s", name)
+		}
+		p.positive("METRICS_SLO_LATENCY", latency)
+	}
+	for name, target := range config.SLOLatencyTarget {
+		if _, ok := config.SLOGroups[name]; !ok {
+			p.add("METRICS_SLO_LATENCY_TARGET", "unknown objective %s", name)
+		}
+		p.percentage("METRICS_SLO_LATENCY_TARGET", target)
+	}
+	for _, window := range config.SLOWindows {
+		if window < time.Minute || window > config.SLOPeriod {
+			p.add("METRICS_SLO_WINDOWS", "must be between 1m and METRICS_SLO_PERIOD, got %s", window)
+		}
+	}
+	p.positive("METRICS_SLO_PERIOD", config.SLOPeriod)
+}
+
+// Validate checks the tracing configuration
+func (config Tracing) Validate() error {
+	var p problems
+	if !config.Enabled {
+		return nil
+	}
+	if config.Endpoint != "" {
+		p.url("TRACING_ENDPOINT", config.Endpoint, "http", "https")
+	}
+	p.required("TRACING_SERVICE_NAME", config.ServiceName)
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		p.add("TRACING_SAMPLE_RATIO", "must be between 0 and 1, got %g", config.SampleRatio)
+	}
+	return p.err()
+}
+
+// Validate checks the origin configuration
+func (config Origin) Validate() error {
+	var p problems
+	for key := range config.Labels {
+		if strings.TrimSpace(key) == "" {
+			p.add("ORIGIN_LABELS", "must not contain labels without key")
+		}
+	}
+	return p.err()
+}
+
+// ProvidesDatabase checks if the database credentials are read from Vault
+func (config Vault) ProvidesDatabase() bool {
+	return config.Address != "" && (config.DatabaseRole != "" || config.DatabaseSecret != "")
+}
+
+// Validate checks the Vault configuration, without address Vault is not used
+func (config Vault) Validate() error {
+	var p problems
+	if config.Address == "" {
+		return nil
+	}
+	p.url("VAULT_ADDR", config.Address, "http", "https")
+	p.oneOf("VAULT_AUTH_METHOD", config.AuthMethod, "token", "kubernetes", "approle")
+	switch config.AuthMethod {
+	case "token":
+		p.required("VAULT_TOKEN", config.Token)
+	case "kubernetes":
+		p.required("VAULT_ROLE", config.Role)
+		p.required("VAULT_KUBERNETES_TOKEN_PATH", config.KubernetesTokenPath)
+	case "approle":
+		p.required("VAULT_APPROLE_ROLE_ID", config.AppRoleID)
+		p.required("VAULT_APPROLE_SECRET_ID", config.AppRoleSecretID)
+	}
+	if config.DatabaseRole != "" && config.DatabaseSecret != "" {
+		p.add("VAULT_DATABASE_ROLE", "cannot be combined with VAULT_DATABASE_SECRET")
+	}
+	return p.err()
+}
+
+// Validate checks all sections, sections of components enabled by listing them in COMPONENTS are checked only then
+func (config *Config) Validate() error {
+	var p problems
+	validations := []error{
+		config.Logger.Validate(),
+		config.Server.Validate(),
+		config.AMQP.Validate(),
+		config.Outbox.Validate(),
+		config.Subscriptions.Validate(),
+		config.Events.Validate(),
+		config.Email.Validate(),
+		config.Scheduler.Validate(),
+		config.Coordination.Validate(),
+		config.Search.Validate(),
+		config.Flags.Validate(),
+		config.Permissions.Validate(),
+		config.IdentitySync.Validate(),
+		config.SCIM.Validate(),
+		config.LDAP.Validate(),
+		config.FGA.Validate(),
+		config.Manifest.Validate(),
+		config.Tenants.Validate(),
+		config.Alerts.Validate(),
+		config.Health.Validate(),
+		config.Outbound.Validate(),
+		config.Quotas.Validate(),
+		config.Consent.Validate(),
+		config.Replay.Validate(),
+		config.StepUp.Validate(),
+		config.CSP.Validate(),
+		config.Metering.Validate(),
+		config.Metrics.Validate(),
+		config.Tracing.Validate(),
+		config.Origin.Validate(),
+		config.Vault.Validate(),
+		config.Bootstrap.Validate(),
+	}
+	// The development mode uses SQLite and no authentication
+	if config.Dev.Enabled {
+		validations = append(validations, config.Dev.Validate())
+	} else {
+		validations = append(validations, config.DataBase.validate(!config.Vault.ProvidesDatabase()), config.Keycloak.Validate())
+	}
+	for _, component := range config.Components {
+		switch strings.ToLower(strings.TrimSpace(component)) {
+		case "storage":
+			validations = append(validations, config.Storage.Validate())
+		case "cache":
+			validations = append(validations, config.Cache.Validate())
+		case "audit":
+			validations = append(validations, config.Audit.Validate())
+		case "jobs":
+			validations = append(validations, config.Jobs.Validate())
+		default:
+			p.add("COMPONENTS", "must contain only storage, cache, audit or jobs, got %q", component)
+		}
+	}
+	return errors.Join(append(validations, p.err())...)
+}
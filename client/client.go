@@ -0,0 +1,175 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Errors matched by the codes of the error responses, e.g. errors.Is(err, client.ErrNotFound)
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrPermission   = errors.New("no permission")
+	ErrQuota        = errors.New("quota exceeded")
+	ErrConsent      = errors.New("consent required")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrGone         = errors.New("gone")
+	ErrPrecondition = errors.New("precondition failed")
+	ErrLocked       = errors.New("locked")
+	ErrValidation   = errors.New("validation failed")
+	ErrRateLimit    = errors.New("rate limit exceeded")
+	ErrUnavailable  = errors.New("service unavailable")
+	ErrBadGateway   = errors.New("bad gateway")
+	ErrInternal     = errors.New("internal server error")
+)
+
+// codeErrors maps the error codes of the server to the errors
+var codeErrors = map[string]error{
+	"RESPITE-400-BAD-REQUEST":     ErrBadRequest,
+	"RESPITE-401-UNAUTHORIZED":    ErrUnauthorized,
+	"RESPITE-401-PERMISSION":      ErrPermission,
+	"RESPITE-403-QUOTA":           ErrQuota,
+	"RESPITE-403-CONSENT":         ErrConsent,
+	"RESPITE-404-RESOURCE":        ErrNotFound,
+	"RESPITE-409-CONFLICT":        ErrConflict,
+	"RESPITE-410-GONE":            ErrGone,
+	"RESPITE-412-PRECONDITION":    ErrPrecondition,
+	"RESPITE-422-VALIDATION":      ErrValidation,
+	"RESPITE-422-IDEMPOTENCY-KEY": ErrValidation,
+	"RESPITE-423-LOCKED":          ErrLocked,
+	"RESPITE-429-RATE-LIMIT":      ErrRateLimit,
+	"RESPITE-500-INTERNAL":        ErrInternal,
+	"RESPITE-502-BAD-GATEWAY":     ErrBadGateway,
+	"RESPITE-503-UNAVAILABLE":     ErrUnavailable,
+}
+
+// Error is an error response of the server
+type Error struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"error"`
+}
+
+// Error returns the message with the code
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the error matching the code
+func (e *Error) Unwrap() error {
+	return codeErrors[e.Code]
+}
+
+// TokenSource provides the bearer token of the requests, e.g. refreshed by an OAuth2 client
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a token source returning always the same token
+type StaticToken string
+
+// Token returns the token
+func (token StaticToken) Token(ctx context.Context) (string, error) {
+	return string(token), nil
+}
+
+// TokenFunc adapts a function to a token source
+type TokenFunc func(ctx context.Context) (string, error)
+
+// Token calls the function
+func (tokenFunc TokenFunc) Token(ctx context.Context) (string, error) {
+	return tokenFunc(ctx)
+}
+
+// idempotencyKey is the context key of the idempotency key of the requests
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a context sending the key in the Idempotency-Key header, so that a repeated create
+// returns the stored response instead of creating the object again
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// Client calls the REST API of a respite server
+type Client struct {
+	BaseURL    string
+	APIPath    string
+	HTTPClient *http.Client
+	Tokens     TokenSource
+}
+
+// New creates a client of the server at the base URL, e.g. http://localhost:8080, with the default API path
+func New(baseURL string, tokens TokenSource) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		APIPath:    "api",
+		HTTPClient: http.DefaultClient,
+		Tokens:     tokens,
+	}
+}
+
+// Do sends the request to the path on the API path, the body is encoded as JSON unless it is nil and the response
+// is decoded into the result unless it is nil. Error responses are returned as *Error.
+func (client *Client) Do(ctx context.Context, method, path string, query url.Values, body, result any) error {
+	target := fmt.Sprintf("%s/%s/%s", client.BaseURL, client.APIPath, strings.TrimPrefix(path, "/"))
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	request.Header.Set("Accept", "application/json")
+	if key, ok := ctx.Value(idempotencyKey{}).(string); ok && key != "" {
+		request.Header.Set("Idempotency-Key", key)
+	}
+	if client.Tokens != nil {
+		token, err := client.Tokens.Token(ctx)
+		if err != nil {
+			return fmt.Errorf("cannot get token: %w", err)
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := client.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		responseError := &Error{StatusCode: response.StatusCode}
+		err = json.Unmarshal(data, responseError)
+		if err != nil || responseError.Code == "" {
+			responseError.Message = strings.TrimSpace(string(data))
+			responseError.Code = fmt.Sprintf("HTTP-%d", response.StatusCode)
+		}
+		return responseError
+	}
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
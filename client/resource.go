@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+// Page is a page of objects of a resource
+type Page[T any] struct {
+	PageSize int   `json:"page_size"`
+	Page     int   `json:"page"`
+	Count    int64 `json:"count"`
+	Data     []*T  `json:"data"`
+}
+
+// Resource gives typed access to a registered resource, e.g. client.NewResource[model.Book](c)
+type Resource[T any, P interface {
+	*T
+	domain.Object
+}] struct {
+	Client *Client
+	Name   string
+}
+
+// NewResource creates the typed access to the resource of the model type
+func NewResource[T any, P interface {
+	*T
+	domain.Object
+}](client *Client) *Resource[T, P] {
+	return &Resource[T, P]{
+		Client: client,
+		Name:   P(new(T)).ResourceName(),
+	}
+}
+
+// Get loads the object by ID
+func (resource *Resource[T, P]) Get(ctx context.Context, id uuid.UUID) (*T, error) {
+	object := new(T)
+	err := resource.Client.Do(ctx, http.MethodGet, resource.Name+"/"+id.String(), nil, nil, object)
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// List loads a page of objects, the page numbers start from 1 and a zero page size uses the server default
+func (resource *Resource[T, P]) List(ctx context.Context, page, pageSize int) (*Page[T], error) {
+	query := url.Values{}
+	if page > 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if pageSize > 0 {
+		query.Set("page_size", strconv.Itoa(pageSize))
+	}
+	result := &Page[T]{}
+	err := resource.Client.Do(ctx, http.MethodGet, resource.Name, query, nil, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// All iterates over the objects of all pages until a page is not full, the iteration stops at the first error
+func (resource *Resource[T, P]) All(ctx context.Context, pageSize int) iter.Seq2[*T, error] {
+	return func(yield func(*T, error) bool) {
+		for page := 1; ; page++ {
+			result, err := resource.List(ctx, page, pageSize)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, object := range result.Data {
+				if !yield(object, nil) {
+					return
+				}
+			}
+			if len(result.Data) == 0 || len(result.Data) < result.PageSize {
+				return
+			}
+		}
+	}
+}
+
+// Create creates the object and returns the created one
+func (resource *Resource[T, P]) Create(ctx context.Context, object *T) (*T, error) {
+	created := new(T)
+	err := resource.Client.Do(ctx, http.MethodPost, resource.Name, nil, object, created)
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// Update updates the object with the ID and returns the updated one
+func (resource *Resource[T, P]) Update(ctx context.Context, id uuid.UUID, object *T) (*T, error) {
+	updated := new(T)
+	err := resource.Client.Do(ctx, http.MethodPut, resource.Name+"/"+id.String(), nil, object, updated)
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Delete deletes the object with the ID
+func (resource *Resource[T, P]) Delete(ctx context.Context, id uuid.UUID) error {
+	return resource.Client.Do(ctx, http.MethodDelete, resource.Name+"/"+id.String(), nil, nil, nil)
+}
@@ -0,0 +1,82 @@
+// Command respite-bench runs the benchmarks of the bench package and compares them with a baseline report
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dzahariev/respite/bench"
+	"github.com/sethvargo/go-envconfig"
+)
+
+func main() {
+	err := run(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "respite-bench: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the benchmarks, writes the report and fails on the regressions against the baseline
+func run(args []string) error {
+	flagSet := flag.NewFlagSet("respite-bench", flag.ContinueOnError)
+	objects := flagSet.Int("objects", 1000, "number of the seeded items")
+	seed := flagSet.Uint64("seed", 1, "seed of the fake data")
+	postgres := flagSet.Bool("postgres", false, "run against the PostgreSQL database of the DB_* variables instead of SQLite")
+	baseline := flagSet.String("baseline", "", "report to compare the results with")
+	threshold := flagSet.Float64("threshold", 0.2, "allowed slowdown against the baseline, e.g. 0.2 for 20%")
+	output := flagSet.String("output", "", "file to write the report to, e.g. the new baseline")
+	err := flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	config := bench.Config{Objects: *objects, Seed: *seed}
+	if *postgres {
+		err = envconfig.Process(ctx, &config.Database)
+		if err != nil {
+			return fmt.Errorf("cannot read database configuration: %w", err)
+		}
+	}
+	report, err := bench.Run(ctx, config)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(writer, "%s %s/%s, %d CPUs, %s with %d objects\n", report.GoVersion, report.OS, report.Arch, report.CPUs, report.Database, report.Objects)
+	fmt.Fprintln(writer, "benchmark\titerations\tns/op\tallocs/op\tB/op\t")
+	for _, result := range report.Results {
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%d\t\n", result.Name, result.Iterations, result.NsPerOp, result.AllocsPerOp, result.BytesPerOp)
+	}
+	writer.Flush()
+	if *output != "" {
+		err = report.Write(*output)
+		if err != nil {
+			return err
+		}
+	}
+	if *baseline == "" {
+		return nil
+	}
+	previous, err := bench.ReadReport(*baseline)
+	if err != nil {
+		return err
+	}
+	err = bench.Comparable(previous, report)
+	if err != nil {
+		return err
+	}
+	regressions := bench.Compare(previous, report, *threshold)
+	for _, regression := range regressions {
+		fmt.Fprintln(os.Stderr, "regression:", regression)
+	}
+	if len(regressions) > 0 {
+		return fmt.Errorf("%d regressions against %s", len(regressions), *baseline)
+	}
+	fmt.Println("no regressions against", *baseline)
+	return nil
+}
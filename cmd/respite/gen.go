@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm/schema"
+)
+
+//go:embed templates
+var templates embed.FS
+
+// initialisms are kept upper case in the Go field names, as golint expects
+var initialisms = map[string]bool{"id": true, "url": true, "uri": true, "api": true, "http": true, "html": true, "json": true, "sql": true, "ip": true, "uuid": true, "isbn": true}
+
+// naming is the gorm naming strategy, so the generated tables and columns match the ones gorm expects
+var naming = schema.NamingStrategy{}
+
+// fieldTypes maps the field types of the command to Go types, SQL types and example values for the test
+var fieldTypes = map[string]struct{ goType, sqlType, example string }{
+	"string":  {"string", "VARCHAR(1024) NOT NULL", `"Example %s"`},
+	"text":    {"string", "TEXT NOT NULL", `"Example %s"`},
+	"int":     {"int", "INTEGER NOT NULL", "1"},
+	"int64":   {"int64", "BIGINT NOT NULL", "1"},
+	"float":   {"float64", "DOUBLE PRECISION NOT NULL", "1.5"},
+	"float32": {"float32", "REAL NOT NULL", "1.5"},
+	"decimal": {"domain.Decimal", "NUMERIC(12,2) NOT NULL", `"1.50"`},
+	"bool":    {"bool", "BOOLEAN NOT NULL", "true"},
+	"time":    {"time.Time", "TIMESTAMP NOT NULL", `"2024-01-01T00:00:00Z"`},
+	"uuid":    {"uuid.UUID", "UUID NOT NULL", `"00000000-0000-0000-0000-000000000001"`},
+}
+
+// resourceSpec describes the generated resource
+type resourceSpec struct {
+	Package      string
+	Name         string
+	ResourceName string
+	Table        string
+	Owned        bool
+	Fields       []fieldSpec
+}
+
+// fieldSpec describes a field of the generated resource
+type fieldSpec struct {
+	Name     string
+	GoType   string
+	Column   string
+	SQLType  string
+	Example  string
+	Text     bool
+	Relation string
+	// RelationColumn and RelationTable are the JSON name and the table of the referenced resource
+	RelationColumn string
+	RelationTable  string
+}
+
+// genResource implements respite gen resource
+func genResource(args []string) error {
+	flagSet := flag.NewFlagSet("gen resource", flag.ContinueOnError)
+	owned := flagSet.Bool("owned", false, "objects are owned by the users instead of global")
+	dir := flagSet.String("dir", "model", "directory of the domain model package")
+	pkg := flagSet.String("package", "", "package name, defaults to the directory name")
+	migrations := flagSet.String("migrations", "migrations", "directory of the SQL migrations")
+	force := flagSet.Bool("force", false, "overwrite existing files")
+	positional, err := parse(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return flag.ErrHelp
+	}
+	if *pkg == "" {
+		*pkg = filepath.Base(*dir)
+	}
+	spec, err := newResourceSpec(*pkg, positional[0], positional[1:], *owned)
+	if err != nil {
+		return err
+	}
+
+	version := time.Now().UTC().Format("20060102150405")
+	return write(resourceOutputs(spec, *dir, *migrations, version), spec, *force)
+}
+
+// output is a file rendered from a template
+type output struct {
+	template string
+	path     string
+}
+
+// resourceOutputs returns the model, test and migration files of the resource
+func resourceOutputs(spec *resourceSpec, dir, migrations, version string) []output {
+	file := naming.ColumnName("", spec.Name)
+	return []output{
+		{"templates/resource/model.go.tmpl", filepath.Join(dir, file+".go")},
+		{"templates/resource/model_test.go.tmpl", filepath.Join(dir, file+"_test.go")},
+		{"templates/resource/up.sql.tmpl", filepath.Join(migrations, fmt.Sprintf("%s_create_%s.up.sql", version, spec.Table))},
+		{"templates/resource/down.sql.tmpl", filepath.Join(migrations, fmt.Sprintf("%s_create_%s.down.sql", version, spec.Table))},
+	}
+}
+
+// write renders the outputs with the data and reports the created files
+func write(outputs []output, data any, force bool) error {
+	for _, output := range outputs {
+		err := render(output.template, output.path, data, force)
+		if err != nil {
+			return err
+		}
+		fmt.Println("created", output.path)
+	}
+	return nil
+}
+
+// newResourceSpec parses the name and the field:type arguments
+func newResourceSpec(pkg, name string, fields []string, owned bool) (*resourceSpec, error) {
+	name = goName(name)
+	if name == "" || !unicode.IsLetter(rune(name[0])) {
+		return nil, fmt.Errorf("invalid resource name %q", name)
+	}
+	spec := &resourceSpec{
+		Package:      pkg,
+		Name:         name,
+		ResourceName: strings.ReplaceAll(naming.ColumnName("", name), "_", ""),
+		Table:        naming.TableName(name),
+		Owned:        owned,
+	}
+	seen := map[string]bool{}
+	for _, argument := range fields {
+		fieldName, fieldType, ok := strings.Cut(argument, ":")
+		if !ok || fieldName == "" || fieldType == "" {
+			return nil, fmt.Errorf("invalid field %q, expected name:type", argument)
+		}
+		field := fieldSpec{Name: goName(fieldName)}
+		if unicode.IsUpper(rune(fieldType[0])) {
+			field.Relation = goName(fieldType)
+			field.RelationColumn = naming.ColumnName("", field.Relation)
+			field.RelationTable = naming.TableName(field.Relation)
+			field.Name += "ID"
+			field.GoType = "uuid.UUID"
+			field.SQLType = "UUID NOT NULL"
+			field.Example = fieldTypes["uuid"].example
+		} else {
+			known, ok := fieldTypes[fieldType]
+			if !ok {
+				return nil, fmt.Errorf("unknown type %q of field %s", fieldType, fieldName)
+			}
+			field.GoType = known.goType
+			field.SQLType = known.sqlType
+			field.Example = known.example
+			field.Text = known.goType == "string"
+			if field.Text {
+				field.Example = fmt.Sprintf(known.example, strings.ReplaceAll(naming.ColumnName("", field.Name), "_", " "))
+			}
+		}
+		field.Column = naming.ColumnName("", field.Name)
+		switch field.Name {
+		case "ID", "CreatedAt", "UpdatedAt", "UserID":
+			return nil, fmt.Errorf("field %s is provided by the library", field.Name)
+		}
+		if seen[field.Name] {
+			return nil, fmt.Errorf("duplicate field %s", field.Name)
+		}
+		seen[field.Name] = true
+		spec.Fields = append(spec.Fields, field)
+	}
+	return spec, nil
+}
+
+// render executes the template and writes the result, Go sources are formatted
+func render(name, path string, data any, force bool) error {
+	if !force {
+		_, err := os.Stat(path)
+		if err == nil {
+			return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+		}
+	}
+	tmpl, err := template.New(filepath.Base(name)).Funcs(template.FuncMap{
+		"imports":   imports,
+		"relations": relations,
+		"hasText": func(spec *resourceSpec) bool {
+			for _, field := range spec.Fields {
+				if field.Text {
+					return true
+				}
+			}
+			return false
+		},
+	}).ParseFS(templates, name)
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, data)
+	if err != nil {
+		return err
+	}
+	content := buffer.Bytes()
+	if strings.HasSuffix(path, ".go") {
+		content, err = format.Source(content)
+		if err != nil {
+			return fmt.Errorf("cannot format %s: %w", path, err)
+		}
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0o644)
+	if strings.HasSuffix(path, ".sh") {
+		mode = 0o755
+	}
+	return os.WriteFile(path, content, mode)
+}
+
+// imports returns the standard and third party imports of the model
+func imports(spec *resourceSpec) []string {
+	var standard []string
+	standard = append(standard, "context")
+	text, uuid, timestamp, decimal := false, spec.Owned, false, false
+	for _, field := range spec.Fields {
+		text = text || field.Text
+		uuid = uuid || field.GoType == "uuid.UUID"
+		timestamp = timestamp || field.GoType == "time.Time"
+		decimal = decimal || field.GoType == "domain.Decimal"
+	}
+	if text || decimal {
+		standard = append(standard, "fmt")
+	}
+	if text {
+		standard = append(standard, "html", "strings")
+	}
+	if timestamp {
+		standard = append(standard, "time")
+	}
+	standard = append(standard, "")
+	standard = append(standard, "github.com/dzahariev/respite/domain")
+	if uuid {
+		standard = append(standard, "github.com/gofrs/uuid/v5")
+	}
+	return standard
+}
+
+// relations returns the referenced resources
+func relations(spec *resourceSpec) []string {
+	var names []string
+	for _, field := range spec.Fields {
+		if field.Relation != "" {
+			names = append(names, field.Relation)
+		}
+	}
+	return names
+}
+
+// goName converts e.g. cover_url or coverUrl to CoverURL
+func goName(name string) string {
+	words := strings.FieldsFunc(naming.ColumnName("", name), func(r rune) bool { return r == '_' || r == '-' })
+	var result strings.Builder
+	for _, word := range words {
+		if initialisms[word] {
+			result.WriteString(strings.ToUpper(word))
+			continue
+		}
+		result.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return result.String()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `Usage:
+  respite new <module> [--dir directory] [--force]
+  respite gen resource <Name> [field:type ...] [--owned] [--dir model] [--migrations migrations] [--force]
+
+Field types are string, text, int, int64, float, float32, decimal, bool, time and uuid, a capitalized type,
+e.g. category:Category, is a belongs-to relation to that resource.
+`
+
+func main() {
+	err := run(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "respite: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run dispatches the command
+func run(args []string) error {
+	if len(args) == 0 {
+		return flag.ErrHelp
+	}
+	switch args[0] {
+	case "gen":
+		if len(args) < 2 {
+			return flag.ErrHelp
+		}
+		switch args[1] {
+		case "resource":
+			return genResource(args[2:])
+		}
+		return fmt.Errorf("unknown generator %q", args[1])
+	case "new":
+		return newProject(args[1:])
+	case "help", "-h", "--help":
+		return flag.ErrHelp
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// parse parses the flags given anywhere between the positional arguments and returns the positional ones
+func parse(flagSet *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		args = flagSet.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
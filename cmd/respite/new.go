@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// modulePattern accepts module paths like example.com/team/app
+var modulePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._~-]*(/[A-Za-z0-9._~-]+)*$`)
+
+// projectSpec describes the generated application
+type projectSpec struct {
+	Module       string
+	Name         string
+	ClientSecret string
+	Resource     *resourceSpec
+}
+
+// newProject implements respite new
+func newProject(args []string) error {
+	flagSet := flag.NewFlagSet("new", flag.ContinueOnError)
+	dir := flagSet.String("dir", "", "directory of the application, defaults to the last element of the module path")
+	force := flagSet.Bool("force", false, "overwrite existing files")
+	positional, err := parse(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return flag.ErrHelp
+	}
+	module := positional[0]
+	if !modulePattern.MatchString(module) {
+		return fmt.Errorf("invalid module path %q", module)
+	}
+	if *dir == "" {
+		*dir = path.Base(module)
+	}
+	secret := make([]byte, 16)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return err
+	}
+	// The example resource is owned by the users, so the permissions of the roles differ
+	resource, err := newResourceSpec("model", "Note", []string{"title:string", "body:text", "done:bool"}, true)
+	if err != nil {
+		return err
+	}
+	spec := &projectSpec{
+		Module:       module,
+		Name:         strings.ToLower(regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(path.Base(module), "-")),
+		ClientSecret: hex.EncodeToString(secret),
+		Resource:     resource,
+	}
+
+	join := func(name string) string { return filepath.Join(*dir, name) }
+	outputs := []output{
+		{"templates/project/go.mod.tmpl", join("go.mod")},
+		{"templates/project/main.go.tmpl", join("main.go")},
+		{"templates/project/config.yaml.tmpl", join("config.yaml")},
+		{"templates/project/docker-compose.yml.tmpl", join("docker-compose.yml")},
+		{"templates/project/initdb.sh.tmpl", join(filepath.Join("docker", "initdb.sh"))},
+		{"templates/project/realm.json.tmpl", join(filepath.Join("docker", "realm.json"))},
+		{"templates/project/init.up.sql.tmpl", join(filepath.Join("migrations", "00000000000000_init.up.sql"))},
+		{"templates/project/init.down.sql.tmpl", join(filepath.Join("migrations", "00000000000000_init.down.sql"))},
+		{"templates/project/README.md.tmpl", join("README.md")},
+		{"templates/project/gitignore.tmpl", join(".gitignore")},
+	}
+	err = write(outputs, spec, *force)
+	if err != nil {
+		return err
+	}
+	version := time.Now().UTC().Format("20060102150405")
+	err = write(resourceOutputs(resource, join("model"), join("migrations"), version), resource, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nNext steps:\n  cd %s\n  go mod tidy\n  docker compose up -d\n  go run .\n", *dir)
+	return nil
+}
@@ -0,0 +1,127 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxAggregateGroups limits the groups of the aggregates with GroupBy to the ones with the highest values, e.g. for
+// fields with many distinct values
+var MaxAggregateGroups = 100
+
+// Aggregates computes the aggregates of the resource for the filters of the list, over all its pages. The
+// aggregates with GroupBy are objects with the value of each group, the groups without value are "null". The lists
+// of repositories have no aggregates.
+func (requestContext *RequestContext) Aggregates(ctx context.Context) (map[string]any, error) {
+	aggregates := requestContext.Resource.Aggregates
+	if len(aggregates) == 0 || requestContext.Repository != nil || requestContext.DB == nil {
+		return nil, nil
+	}
+	object, err := requestContext.Resources.New(requestContext.Resource.Name)
+	if err != nil {
+		return nil, err
+	}
+	results := map[string]any{}
+	err = requestContext.inTransaction(ctx, false, func(db *gorm.DB) error {
+		// The filters of the list are applied without its pagination
+		query := func() *gorm.DB {
+			return db.Session(&gorm.Session{NewDB: true}).Model(object).Scopes(requestContext.DBScopes.filters()...)
+		}
+		var selects []string
+		var args []any
+		var names []string
+		for _, aggregate := range aggregates {
+			if aggregate.GroupBy != "" {
+				groups, err := aggregateGroups(query(), aggregate)
+				if err != nil {
+					return err
+				}
+				results[aggregate.Name] = groups
+				continue
+			}
+			expression, expressionArgs := aggregateExpression(aggregate)
+			selects = append(selects, expression+" AS ?")
+			args = append(args, append(expressionArgs, clause.Column{Name: fmt.Sprintf("a%d", len(names))})...)
+			names = append(names, aggregate.Name)
+		}
+		if len(selects) == 0 {
+			return nil
+		}
+		row := map[string]any{}
+		err := query().Select(strings.Join(selects, ", "), args...).Scan(&row).Error
+		if err != nil {
+			return fmt.Errorf("cannot aggregate %s: %w", requestContext.Resource.Name, err)
+		}
+		for i, name := range names {
+			results[name] = aggregateValue(row[fmt.Sprintf("a%d", i)])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// aggregateExpression returns the SQL of the function of the aggregate and its arguments
+func aggregateExpression(aggregate domain.Aggregate) (string, []any) {
+	if aggregate.Field == "" {
+		return "COUNT(*)", nil
+	}
+	return strings.ToUpper(aggregate.Function) + "(?)", []any{clause.Column{Table: clause.CurrentTable, Name: aggregate.Field}}
+}
+
+// aggregateGroups computes the aggregate for the groups of its GroupBy field, the groups with the highest values first
+func aggregateGroups(db *gorm.DB, aggregate domain.Aggregate) (map[string]any, error) {
+	group := clause.Column{Table: clause.CurrentTable, Name: aggregate.GroupBy}
+	expression, args := aggregateExpression(aggregate)
+	var rows []map[string]any
+	err := db.Select("? AS ?, "+expression+" AS ?", append(append([]any{group, clause.Column{Name: "k"}}, args...), clause.Column{Name: "v"})...).
+		Clauses(clause.GroupBy{Columns: []clause.Column{group}}).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "v"}, Desc: true}).
+		Limit(MaxAggregateGroups).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("cannot aggregate %s: %w", aggregate.Name, err)
+	}
+	groups := make(map[string]any, len(rows))
+	for _, row := range rows {
+		groups[aggregateKey(row["k"])] = aggregateValue(row["v"])
+	}
+	return groups, nil
+}
+
+// aggregateKey returns the JSON key of the value of a group
+func aggregateKey(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case []byte:
+		return string(value)
+	case time.Time:
+		return value.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+// aggregateValue returns the value of an aggregate for JSON, the numeric values that the drivers return as text,
+// e.g. the sums of PostgreSQL, are numbers
+func aggregateValue(value any) any {
+	if data, ok := value.([]byte); ok {
+		value = string(data)
+	}
+	if text, ok := value.(string); ok {
+		if _, err := strconv.ParseFloat(text, 64); err == nil {
+			return json.Number(text)
+		}
+	}
+	return value
+}
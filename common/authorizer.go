@@ -0,0 +1,177 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+// Relations of the users to the objects of the shared resources, the authorization model makes the owners editors
+// and the editors viewers
+const (
+	RELATION_OWNER  = "owner"
+	RELATION_EDITOR = "editor"
+	RELATION_VIEWER = "viewer"
+)
+
+// Relations are the relations that are granted to the users
+var Relations = []string{RELATION_OWNER, RELATION_EDITOR, RELATION_VIEWER}
+
+// ErrAuthorizer is returned when the authorizer cannot decide the access, e.g. while it is unavailable
+var ErrAuthorizer = errors.New("authorizer failed")
+
+// Tuple is the relation of a user to an object of a resource, e.g. user alice is viewer of document 42
+type Tuple struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Relation string    `json:"relation"`
+	Resource string    `json:"-"`
+	ObjectID uuid.UUID `json:"-"`
+}
+
+// Authorizer decides the access to the objects of the shared resources by the relations of the users to them,
+// e.g. with OpenFGA, see domain.SharedObject
+type Authorizer interface {
+	// Check checks that the user has the relation to the object, directly or through the authorization model
+	Check(ctx context.Context, tuple Tuple) (bool, error)
+	// ListObjects returns the IDs of the objects of the resource that the user has the relation to
+	ListObjects(ctx context.Context, userID uuid.UUID, relation, resource string) ([]uuid.UUID, error)
+	// Read returns the relations that are granted on the object
+	Read(ctx context.Context, resource string, objectID uuid.UUID) ([]Tuple, error)
+	// Write grants the relations of the writes and revokes the ones of the deletes
+	Write(ctx context.Context, writes, deletes []Tuple) error
+}
+
+// shared checks if the access of the request to the objects is decided by their relations, the users with the
+// global permission of the resource access all the objects
+func (requestContext *RequestContext) shared() bool {
+	return requestContext.Authorizer != nil && requestContext.Resource.Shared && requestContext.DBScopes.OwnedOnly && requestContext.DBScopes.User != nil
+}
+
+// authorize extends the owned objects of the request to the object when the user has the relation to it, the other
+// objects of other users are not found as without the relations
+func (requestContext *RequestContext) authorize(ctx context.Context, relation string, uid uuid.UUID) error {
+	requestContext.DBScopes.Shared = nil
+	if !requestContext.shared() {
+		return nil
+	}
+	allowed, err := requestContext.Authorizer.Check(ctx, Tuple{UserID: requestContext.DBScopes.User.ID, Relation: relation, Resource: requestContext.Resource.Name, ObjectID: uid})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuthorizer, err)
+	}
+	if allowed {
+		requestContext.DBScopes.Shared = []uuid.UUID{uid}
+	}
+	return nil
+}
+
+// authorizeList extends the owned objects of the lists to the objects that the user views
+func (requestContext *RequestContext) authorizeList(ctx context.Context) error {
+	requestContext.DBScopes.Shared = nil
+	if !requestContext.shared() {
+		return nil
+	}
+	ids, err := requestContext.Authorizer.ListObjects(ctx, requestContext.DBScopes.User.ID, RELATION_VIEWER, requestContext.Resource.Name)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuthorizer, err)
+	}
+	requestContext.DBScopes.Shared = ids
+	return nil
+}
+
+// grantOwner makes the creator of the object its owner
+func (requestContext *RequestContext) grantOwner(ctx context.Context, object domain.Object) error {
+	if requestContext.Authorizer == nil || !requestContext.Resource.Shared || requestContext.DryRun {
+		return nil
+	}
+	owner := requestContext.DBScopes.User
+	if owner == nil {
+		return nil
+	}
+	err := requestContext.Authorizer.Write(ctx, []Tuple{{UserID: owner.ID, Relation: RELATION_OWNER, Resource: requestContext.Resource.Name, ObjectID: object.GetID()}}, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuthorizer, err)
+	}
+	return nil
+}
+
+// forget revokes the relations granted on the deleted object, the relations that are left are logged as they
+// give no access to the deleted object
+func (requestContext *RequestContext) forget(ctx context.Context, uid uuid.UUID) {
+	if requestContext.Authorizer == nil || !requestContext.Resource.Shared || requestContext.DryRun {
+		return
+	}
+	tuples, err := requestContext.Authorizer.Read(ctx, requestContext.Resource.Name, uid)
+	if err == nil && len(tuples) > 0 {
+		err = requestContext.Authorizer.Write(ctx, nil, tuples)
+	}
+	if err != nil {
+		GetLogger(ctx).Warn("Cannot revoke the relations of the deleted object", "resource", requestContext.Resource.Name, "id", uid, "error", err)
+	}
+}
+
+// Share grants the relation to the object to the user, the caller must own the object
+func (requestContext *RequestContext) Share(ctx context.Context, uid uuid.UUID, tuple Tuple) error {
+	return requestContext.share(ctx, uid, tuple, true)
+}
+
+// Unshare revokes the relation to the object of the user, the caller must own the object
+func (requestContext *RequestContext) Unshare(ctx context.Context, uid uuid.UUID, tuple Tuple) error {
+	return requestContext.share(ctx, uid, tuple, false)
+}
+
+// Shares returns the relations granted on the object, the caller must own the object
+func (requestContext *RequestContext) Shares(ctx context.Context, uid uuid.UUID) ([]Tuple, error) {
+	err := requestContext.owned(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	tuples, err := requestContext.Authorizer.Read(ctx, requestContext.Resource.Name, uid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuthorizer, err)
+	}
+	return tuples, nil
+}
+
+// share writes or deletes the relation of the user to the object
+func (requestContext *RequestContext) share(ctx context.Context, uid uuid.UUID, tuple Tuple, grant bool) error {
+	if !slices.Contains(Relations, tuple.Relation) {
+		return fmt.Errorf("%w: relation must be one of %v, got %q", domain.ErrValidation, Relations, tuple.Relation)
+	}
+	if tuple.UserID.IsNil() {
+		return fmt.Errorf("%w: user_id is required", domain.ErrValidation)
+	}
+	err := requestContext.owned(ctx, uid)
+	if err != nil {
+		return err
+	}
+	tuple.Resource, tuple.ObjectID = requestContext.Resource.Name, uid
+	writes, deletes := []Tuple{tuple}, []Tuple(nil)
+	if !grant {
+		writes, deletes = nil, writes
+	}
+	err = requestContext.Authorizer.Write(ctx, writes, deletes)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuthorizer, err)
+	}
+	return nil
+}
+
+// owned checks that the resource is shared and that the object is found with the owner relation of the user
+func (requestContext *RequestContext) owned(ctx context.Context, uid uuid.UUID) error {
+	if requestContext.Authorizer == nil || !requestContext.Resource.Shared {
+		return fmt.Errorf("%w: %s is not shared", errors.ErrUnsupported, requestContext.Resource.Name)
+	}
+	err := requestContext.authorize(ctx, RELATION_OWNER, uid)
+	if err != nil {
+		return err
+	}
+	object, err := requestContext.Resources.New(requestContext.Resource.Name)
+	if err != nil {
+		return err
+	}
+	return requestContext.findByID(ctx, object, uid)
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/dzahariev/respite/breaker"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// breakerKey marks the statements that were allowed by the breaker of the database
+const breakerKey = "respite:breaker"
+
+// unavailableStates are the SQLSTATEs of databases that shut down, restart or have no free connections
+var unavailableStates = map[string]bool{
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// RegisterBreaker fails the statements with breaker.ErrOpen while the breaker of the database is open, so that
+// the requests fail fast instead of waiting for the connections of a database that is down. Only the connection
+// errors count as failures, the errors of the statements themselves, e.g. constraint violations, do not.
+func RegisterBreaker(db *gorm.DB, databaseBreaker *breaker.Breaker) error {
+	allow := func(db *gorm.DB) {
+		if db.Error != nil {
+			return
+		}
+		if !databaseBreaker.Allow() {
+			db.AddError(databaseBreaker.Err())
+			return
+		}
+		db.InstanceSet(breakerKey, true)
+	}
+	record := func(db *gorm.DB) {
+		if _, ok := db.InstanceGet(breakerKey); ok {
+			databaseBreaker.Record(DatabaseUnavailable(db.Error))
+		}
+	}
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("breaker:before_create", allow),
+		callbacks.Create().After("gorm:create").Register("breaker:after_create", record),
+		callbacks.Query().Before("gorm:query").Register("breaker:before_query", allow),
+		callbacks.Query().After("gorm:query").Register("breaker:after_query", record),
+		callbacks.Update().Before("gorm:update").Register("breaker:before_update", allow),
+		callbacks.Update().After("gorm:update").Register("breaker:after_update", record),
+		callbacks.Delete().Before("gorm:delete").Register("breaker:before_delete", allow),
+		callbacks.Delete().After("gorm:delete").Register("breaker:after_delete", record),
+		callbacks.Raw().Before("gorm:raw").Register("breaker:before_raw", allow),
+		callbacks.Raw().After("gorm:raw").Register("breaker:after_raw", record),
+		callbacks.Row().Before("gorm:row").Register("breaker:before_row", allow),
+		callbacks.Row().After("gorm:row").Register("breaker:after_row", record),
+	)
+}
+
+// DatabaseUnavailable checks if the statement failed because the database could not be reached, rather than
+// because of the statement
+func DatabaseUnavailable(err error) bool {
+	// The statements of canceled requests and the slow statements do not show that the database is down
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var connectError *pgconn.ConnectError
+	var netError net.Error
+	var pgError *pgconn.PgError
+	switch {
+	case errors.Is(err, driver.ErrBadConn), errors.As(err, &connectError), errors.As(err, &netError):
+		return true
+	case errors.As(err, &pgError):
+		return unavailableStates[pgError.Code] || pgError.Code[:2] == "08"
+	}
+	return false
+}
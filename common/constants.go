@@ -6,8 +6,11 @@ type contextKey string
 const (
 	GLOBAL = "global"
 
+	// Deprecated: the context keys are read and set with the respitectx package, the keys and their type may change
 	LoggerKey                 contextKey = "LoggerKey"
+	RequestIDKey              contextKey = "RequestIDKey"
 	RequestContextKey         contextKey = "RequestContextKey"
 	CurrentUserKey            contextKey = "CurrentUserKey"
 	CurrentUserPermissionsKey contextKey = "CurrentUserPermissionsKey"
+	TenantKey                 contextKey = "TenantKey"
 )
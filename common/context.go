@@ -3,14 +3,17 @@ package common
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"reflect"
-	"strings"
 
 	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/events"
+	"github.com/dzahariev/respite/flags"
 	"github.com/gofrs/uuid/v5"
+	"golang.org/x/sync/errgroup"
 	"gorm.io/gorm"
 )
 
@@ -20,8 +23,29 @@ type RequestContext struct {
 	Resource  Resource
 	Resources *Resources
 	RequestID uuid.UUID
+	Publisher events.Publisher
+	Outbox    *events.Outbox
+	Flags     flags.Set
+	Origin    domain.Origin
+	// Repository persists the objects instead of the database when it is set
+	Repository Repository
+	// HTTPClient calls other services with the service token, the request ID and the trace context
+	HTTPClient *http.Client
+	// Quota limits the objects that the owner of the request creates
+	Quota *Quota
+	// DryRun validates and checks the mutations and rolls them back, without events
+	DryRun bool
+	// Authorizer decides the access to the objects of the shared resources
+	Authorizer Authorizer
+	// EditLocks requires the lock of the objects to update them
+	EditLocks *EditLocks
+	// Inbox delivers the notifications of the users
+	Inbox *Inbox
 }
 
+// errDryRun rolls back the transactions of the dry runs
+var errDryRun = errors.New("dry run")
+
 // GetLogger is a helper to get logger from context or fallback
 func GetLogger(ctx context.Context) *slog.Logger {
 	if logger, ok := ctx.Value(LoggerKey).(*slog.Logger); ok {
@@ -42,47 +66,115 @@ func GetRequestContext(ctx context.Context) *RequestContext {
 func NewRequestContextWithDetails(pageSize, pageNumber, offset int, user *domain.User, resource Resource, dataBase *gorm.DB, resources *Resources, currentUserPermissions []string) *RequestContext {
 	isGlobal := resources.IsGlobal(resource.Name)
 	dbScopes := NewDBScopes(pageSize, pageNumber, offset, user, isGlobal)
-	requestDatabase := dataBase.Scopes(dbScopes.Paginate())
 	// If resource is not global and user do not have global permissions,
 	// we scope the database to only owned resources
-	if !isGlobal && !haveGlobalPermission(resource.Name, currentUserPermissions) {
-		requestDatabase = dataBase.Scopes(dbScopes.Owned(), dbScopes.Paginate())
-	}
-
-	return &RequestContext{
-		DB:        requestDatabase,
+	dbScopes.OwnedOnly = !isGlobal && !Permissions(currentUserPermissions).CanGlobal(resource.Name)
+	requestContext := &RequestContext{
 		DBScopes:  dbScopes,
 		Resource:  resource,
 		Resources: resources,
 		RequestID: uuid.Must(uuid.NewV4()),
 	}
+	// The scopes of the request context are used, so that the pagination sees the origin filter set later
+	if dataBase != nil {
+		requestContext.DB = dataBase.Scopes(requestContext.DBScopes.Paginate())
+		if dbScopes.OwnedOnly && !RowLevelSecurity {
+			requestContext.DB = dataBase.Scopes(requestContext.DBScopes.Owned(), requestContext.DBScopes.Paginate())
+		}
+	}
+	return requestContext
 }
 
 func NewRequestContext(request *http.Request, dataBase *gorm.DB, resource Resource, resources *Resources) *RequestContext {
 	isGlobal := resources.IsGlobal(resource.Name)
 	dbScopes := NewDBScopesFromRequest(request, isGlobal)
-	currentUserPermissions := getCurrentUserPermissions(request)
+	if request.URL.Query().Get("page_size") == "" && resource.DefaultPageSize > 0 {
+		dbScopes.PageSize = resource.PageSize(0)
+		dbScopes.Offset = (dbScopes.Page - 1) * dbScopes.PageSize
+	}
+	currentUserPermissions := GetPermissions(request.Context())
 	logger := GetLogger(request.Context())
 	logger.Debug("Creating new request context", "resource", resource.Name, "dbScopes", dbScopes, "userID", dbScopes.User, "global", isGlobal, "permissions", currentUserPermissions)
-	return NewRequestContextWithDetails(dbScopes.PageSize, dbScopes.Page, dbScopes.Offset, dbScopes.User, resource, dataBase, resources, currentUserPermissions)
+	requestContext := NewRequestContextWithDetails(dbScopes.PageSize, dbScopes.Page, dbScopes.Offset, dbScopes.User, resource, dataBase, resources, currentUserPermissions)
+	// The request ID of the logs is kept, so that the queries are attributed to the logged request
+	if requestID, ok := request.Context().Value(RequestIDKey).(uuid.UUID); ok {
+		requestContext.RequestID = requestID
+	}
+	requestContext.FilterOrigin(dbScopes.Origin)
+	return requestContext
+}
+
+// FilterOrigin limits the lists to the objects of the region and the labels of the origin, only of the resources
+// that are stamped with it
+func (requestContext *RequestContext) FilterOrigin(origin domain.Origin) {
+	if origin.IsEmpty() || !requestContext.Resources.HasOrigin(requestContext.Resource.Name) {
+		return
+	}
+	requestContext.DBScopes.Origin = origin
+	if requestContext.DB != nil {
+		requestContext.DB = requestContext.DB.Scopes(requestContext.DBScopes.FromOrigin())
+	}
+}
+
+// FilterNear limits the lists to the objects within the radius of the proximity filter
+func (requestContext *RequestContext) FilterNear(near *Near) {
+	requestContext.DBScopes.Near = near
+	if near != nil && requestContext.DB != nil {
+		requestContext.DB = requestContext.DB.Scopes(requestContext.DBScopes.Nearby())
+	}
+}
+
+// FilterArrays limits the lists to the objects with the elements of the array filters
+func (requestContext *RequestContext) FilterArrays(filters []ArrayFilter) {
+	requestContext.DBScopes.Arrays = filters
+	if len(filters) > 0 && requestContext.DB != nil {
+		requestContext.DB = requestContext.DB.Scopes(requestContext.DBScopes.WithElements())
+	}
+}
+
+// PageAfter pages the lists by the cursor instead of the page number, the nil cursor is the first page
+func (requestContext *RequestContext) PageAfter(cursor *Cursor) {
+	requestContext.DBScopes.After = cursor
+	requestContext.DBScopes.Page = 0
+	requestContext.DBScopes.Offset = 0
 }
 
 // GetAll retrieves all objects
 func (requestContext *RequestContext) GetAll(ctx context.Context) (*domain.List, error) {
 	var err error
-	object, err := requestContext.Resources.New(requestContext.Resource.Name)
+	// The object is the model of the queries, the loaded objects are new ones
+	object, err := requestContext.Resources.Borrow(requestContext.Resource.Name)
 	if err != nil {
 		return nil, err
 	}
+	defer requestContext.Resources.Return(object)
 
-	count, err := object.Count(ctx, requestContext.DB, object)
+	err = requestContext.authorizeList(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := object.FindAll(ctx, requestContext.DB, object)
-	if err != nil {
-		return nil, err
+	var count int64
+	var data *[]domain.Object
+	if requestContext.Repository == nil && requestContext.DBScopes.countsInQuery() {
+		data, count, err = requestContext.findAllCounted(ctx, object)
+		if err != nil {
+			return nil, err
+		}
+	} else if ParallelCount && requestContext.Repository == nil && requestContext.DBScopes.Session.IsEmpty() {
+		data, count, err = requestContext.findAllParallel(ctx, object)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		count, err = requestContext.count(ctx, object)
+		if err != nil {
+			return nil, err
+		}
+		data, err = requestContext.findAll(ctx, object)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	list := &domain.List{
@@ -102,7 +194,11 @@ func (requestContext *RequestContext) Get(ctx context.Context, uid uuid.UUID) (d
 		return nil, err
 	}
 
-	err = object.FindByID(ctx, requestContext.DB, object, uid)
+	err = requestContext.authorize(ctx, RELATION_VIEWER, uid)
+	if err != nil {
+		return nil, err
+	}
+	err = requestContext.findByID(ctx, object, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -116,12 +212,26 @@ func (requestContext *RequestContext) Create(ctx context.Context, jsonObject []b
 		return nil, err
 	}
 
+	jsonObject, err = domain.NormalizeTimestamps(jsonObject, object)
+	if err != nil {
+		return nil, err
+	}
 	err = json.Unmarshal(jsonObject, object)
 	if err != nil {
 		return nil, err
 	}
 
 	err = object.Validate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrValidation, err)
+	}
+
+	err = requestContext.Quota.Check(ctx, requestContext.Resource.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requestContext.callWebhook(ctx, "create", nil, object)
 	if err != nil {
 		return nil, err
 	}
@@ -129,13 +239,25 @@ func (requestContext *RequestContext) Create(ctx context.Context, jsonObject []b
 	if !requestContext.DBScopes.Global {
 		ownerUser := requestContext.DBScopes.User
 		if ownerUser == nil {
-			return nil, err
+			return nil, fmt.Errorf("cannot create %s without owner user", requestContext.Resource.Name)
 		}
 		objectAsLocalObject := object.(domain.LocalObject)
 		objectAsLocalObject.SetUserID(ownerUser.ID)
 	}
 
-	err = object.Save(ctx, requestContext.DB, object)
+	if originObject, ok := object.(domain.OriginObject); ok {
+		originObject.SetOrigin(requestContext.Origin)
+	}
+	requestContext.Resource.KeepCounters(object, nil)
+
+	// The owner relation is written before the commit, so that the objects are not created without it
+	err = requestContext.mutate(ctx, events.CREATED, object, func(db *gorm.DB) error {
+		err := requestContext.save(ctx, db, object)
+		if err != nil {
+			return err
+		}
+		return requestContext.grantOwner(ctx, object)
+	})
 
 	if err != nil {
 		return nil, err
@@ -144,32 +266,82 @@ func (requestContext *RequestContext) Create(ctx context.Context, jsonObject []b
 	return object, nil
 }
 
-// Update updates existing object
+// Update updates existing object, the fields managed by the server are kept from the previous version
 func (requestContext *RequestContext) Update(ctx context.Context, uid uuid.UUID, jsonObject []byte) (domain.Object, error) {
+	return requestContext.updateObject(ctx, uid, jsonObject, false)
+}
+
+// UpdateManaged updates existing object including the fields managed by the server, e.g. the status of the uploaded
+// files
+func (requestContext *RequestContext) UpdateManaged(ctx context.Context, uid uuid.UUID, jsonObject []byte) (domain.Object, error) {
+	return requestContext.updateObject(ctx, uid, jsonObject, true)
+}
+
+// updateObject updates existing object, the managed fields are written only when managed is set
+func (requestContext *RequestContext) updateObject(ctx context.Context, uid uuid.UUID, jsonObject []byte, managed bool) (domain.Object, error) {
 	object, err := requestContext.Resources.New(requestContext.Resource.Name)
 	if err != nil {
 		return nil, err
 	}
 
+	jsonObject, err = domain.NormalizeTimestamps(jsonObject, object)
+	if err != nil {
+		return nil, err
+	}
 	err = json.Unmarshal(jsonObject, &object)
 	if err != nil {
 		return nil, err
 	}
 
 	err = object.Validate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrValidation, err)
+	}
+
+	// The previous version is used only within the update
+	recordExisting, err := requestContext.Resources.Borrow(requestContext.Resource.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer requestContext.Resources.Return(recordExisting)
+	err = requestContext.authorize(ctx, RELATION_EDITOR, uid)
+	if err != nil {
+		return nil, err
+	}
+	err = requestContext.findByID(ctx, recordExisting, uid)
+	if err != nil {
+		return nil, err
+	}
+	requestContext.Resource.Unmask(object, recordExisting)
+	err = requestContext.EditLocks.Check(ctx, requestContext.Resource.Name, uid, requestContext.DBScopes.User)
 	if err != nil {
 		return nil, err
 	}
 
-	recordExisting := reflect.New(reflect.TypeOf(object).Elem()).Interface().(domain.Object)
-	err = recordExisting.FindByID(ctx, requestContext.DB, recordExisting, uid)
+	err = requestContext.callWebhook(ctx, "update", &uid, object)
 	if err != nil {
 		return nil, err
 	}
 
 	object.SetID(uid)
+	// Origin is kept from the creation of the object
+	if originObject, ok := object.(domain.OriginObject); ok {
+		originObject.SetOrigin(recordExisting.(domain.OriginObject).GetOrigin())
+	}
+	requestContext.Resource.KeepCounters(object, recordExisting)
+	if managedObject, ok := object.(domain.ManagedObject); ok && !managed {
+		managedObject.KeepManaged(recordExisting)
+	}
 
-	err = object.Update(ctx, requestContext.DB, object)
+	changes := []change{{action: events.UPDATED, object: object, previous: recordExisting}}
+	err = requestContext.mutateAll(ctx, changes, func(db *gorm.DB) error {
+		err := requestContext.update(ctx, db, object)
+		if err != nil {
+			return err
+		}
+		// The update merges the sent fields into the stored object, its event carries the merged object
+		return requestContext.reload(ctx, db, object)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -178,41 +350,340 @@ func (requestContext *RequestContext) Update(ctx context.Context, uid uuid.UUID,
 
 // Delete deletes an object
 func (requestContext *RequestContext) Delete(ctx context.Context, uid uuid.UUID) error {
+	return requestContext.DeleteIf(ctx, uid, nil)
+}
+
+// DeleteIf deletes the object when the check of the loaded object passes, e.g. of its version
+func (requestContext *RequestContext) DeleteIf(ctx context.Context, uid uuid.UUID, check func(domain.Object) error) error {
 	object, err := requestContext.Resources.New(requestContext.Resource.Name)
 	if err != nil {
 		return err
 	}
 
-	err = object.FindByID(ctx, requestContext.DB, object, uid)
+	err = requestContext.authorize(ctx, RELATION_OWNER, uid)
+	if err != nil {
+		return err
+	}
+	err = requestContext.findByID(ctx, object, uid)
 	if err != nil {
 		return err
 	}
+	if check != nil {
+		err = check(object)
+		if err != nil {
+			return err
+		}
+	}
 
-	err = object.Delete(ctx, requestContext.DB, object)
+	err = requestContext.mutate(ctx, events.DELETED, object, func(db *gorm.DB) error {
+		return requestContext.delete(ctx, db, object)
+	})
 	if err != nil {
 		return err
 	}
+	requestContext.forget(ctx, uid)
 	return nil
 }
 
-// getCurrentUserPermissions returns the current request user ID
-func getCurrentUserPermissions(request *http.Request) []string {
-	if request.Context().Value(CurrentUserPermissionsKey) == nil {
+// Anonymize clears the fields of the object, given by their JSON names, and keeps the other fields. The object is
+// not validated, as the cleared personal fields may be required. Repositories keep the fields that are cleared,
+// so the objects are anonymized only in the database.
+func (requestContext *RequestContext) Anonymize(ctx context.Context, uid uuid.UUID, fields []string) (domain.Object, error) {
+	if requestContext.Repository != nil {
+		return nil, fmt.Errorf("%w: objects are anonymized only in the database", errors.ErrUnsupported)
+	}
+	object, err := requestContext.Resources.New(requestContext.Resource.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requestContext.findByID(ctx, object, uid)
+	if err != nil {
+		return nil, err
+	}
+	value := reflect.ValueOf(object).Elem()
+	columns := make([]string, 0, len(fields))
+	for _, name := range fields {
+		field, ok := domain.JSONField(value.Type(), name)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a field of %s", name, requestContext.Resource.Name)
+		}
+		value.FieldByIndex(field.Index).SetZero()
+		// The JSON names of the fields are their columns
+		columns = append(columns, name)
+	}
+
+	err = requestContext.mutate(ctx, events.UPDATED, object, func(db *gorm.DB) error {
+		return db.Model(object).Select(columns).Updates(object).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// inTransaction executes the operation in a transaction that has the session parameters of the request set.
+// Requests without them run the operation in a transaction only when it is required, e.g. to write the outbox.
+// The database has the context of the request also for models with their own operations, so that the statements
+// of canceled requests are canceled.
+func (requestContext *RequestContext) inTransaction(ctx context.Context, required bool, operation func(db *gorm.DB) error) error {
+	session := requestContext.DBScopes.Session
+	if requestContext.Repository != nil {
+		return operation(requestContext.DB)
+	}
+	if !required && session.IsEmpty() {
+		return operation(requestContext.DB.WithContext(ctx))
+	}
+	return domain.Transaction(requestContext.DB.WithContext(ctx), func(tx *gorm.DB) error {
+		err := session.Apply(tx)
+		if err != nil {
+			return err
+		}
+		return operation(tx)
+	})
+}
+
+// change is an object of a mutation with the action of its event, and the object before the update
+type change struct {
+	action   string
+	object   domain.Object
+	previous domain.Object
+}
+
+// mutate executes the mutation and emits its event. When the outbox is configured the event is
+// stored in the same transaction as the mutation, otherwise it is published after the mutation.
+func (requestContext *RequestContext) mutate(ctx context.Context, action string, object domain.Object, mutation func(db *gorm.DB) error) error {
+	return requestContext.mutateAll(ctx, []change{{action: action, object: object}}, mutation)
+}
+
+// mutateAll executes the mutation of several objects and emits their events in order, the mutation runs in a
+// transaction when it changes several objects or the objects are counted in the transaction
+func (requestContext *RequestContext) mutateAll(ctx context.Context, changes []change, mutation func(db *gorm.DB) error) error {
+	if requestContext.Resource.SQLView != nil {
+		return fmt.Errorf("%w: %s is a SQL view", ErrReadOnlyResource, requestContext.Resource.Name)
+	}
+	counted := false
+	if countings := requestContext.transactionCountings(); len(countings) > 0 {
+		mutation = requestContext.counted(ctx, countings, changes, mutation)
+		counted = true
+	}
+	if requestContext.DryRun {
+		return requestContext.dryRun(ctx, mutation)
+	}
+	if requestContext.Outbox == nil || requestContext.Repository != nil {
+		err := requestContext.inTransaction(ctx, len(changes) > 1 || counted, mutation)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			requestContext.publish(ctx, change)
+		}
+		return nil
+	}
+
+	return requestContext.inTransaction(ctx, true, func(tx *gorm.DB) error {
+		err := mutation(tx)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			event, err := requestContext.newEvent(change)
+			if err != nil {
+				return err
+			}
+			err = requestContext.Outbox.Write(tx, event)
+			if err != nil {
+				return err
+			}
+			GetLogger(ctx).Debug("Event stored in outbox", "event", event.ID, "type", event.Type)
+		}
+		return nil
+	})
+}
+
+// dryRun executes the mutation in a transaction that is always rolled back, so that the constraints of the
+// database are checked. Repositories have no transactions, the mutations of dry runs are not passed to them.
+func (requestContext *RequestContext) dryRun(ctx context.Context, mutation func(db *gorm.DB) error) error {
+	if requestContext.Repository != nil {
 		return nil
 	}
-	if permissions, ok := request.Context().Value(CurrentUserPermissionsKey).([]string); ok {
-		return permissions
+	err := requestContext.inTransaction(ctx, true, func(tx *gorm.DB) error {
+		err := mutation(tx)
+		if err != nil {
+			return err
+		}
+		return errDryRun
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	return err
+}
+
+// count returns the number of objects from the repository or the database
+func (requestContext *RequestContext) count(ctx context.Context, object domain.Object) (int64, error) {
+	if requestContext.Repository != nil {
+		return requestContext.Repository.Count(ctx, requestContext.DBScopes, object)
 	}
-	return []string{}
+	var count int64
+	err := requestContext.inTransaction(ctx, false, func(db *gorm.DB) error {
+		var err error
+		count, err = object.Count(ctx, db, object)
+		return err
+	})
+	return count, err
+}
+
+// findAll loads the page of objects from the repository or the database
+func (requestContext *RequestContext) findAll(ctx context.Context, object domain.Object) (*[]domain.Object, error) {
+	if requestContext.Repository != nil {
+		return requestContext.Repository.FindAll(ctx, requestContext.DBScopes, object)
+	}
+	var objects *[]domain.Object
+	err := requestContext.inTransaction(ctx, false, func(db *gorm.DB) error {
+		var err error
+		objects, err = object.FindAll(ctx, db, object)
+		return err
+	})
+	return objects, err
 }
 
-// haveGlobalPermission is to check if the global permission for the resource is present in the list of permissions
-func haveGlobalPermission(resource string, permissions []string) bool {
-	for _, currentPermission := range permissions {
-		resourcePermission := fmt.Sprintf("%s.%s", resource, GLOBAL)
-		if strings.EqualFold(currentPermission, resourcePermission) {
-			return true
+// findAllCounted loads the page of objects with the total of the list in the same query. The total is counted
+// separately when the query does not return it, e.g. for pages after the last one or models with their own select.
+func (requestContext *RequestContext) findAllCounted(ctx context.Context, object domain.Object) (*[]domain.Object, int64, error) {
+	var objects *[]domain.Object
+	total := int64(-1)
+	err := requestContext.inTransaction(ctx, false, func(db *gorm.DB) error {
+		var err error
+		objects, err = object.FindAll(ctx, withTotal(db, &total), object)
+		if err != nil || total >= 0 {
+			return err
 		}
+		if len(*objects) == 0 && requestContext.DBScopes.Offset == 0 {
+			total = 0
+			return nil
+		}
+		total, err = object.Count(ctx, db, object)
+		return err
+	})
+	return objects, total, err
+}
+
+// findAllParallel counts the list and loads its page concurrently on separate connections, the first error
+// cancels the other query. Requests with session parameters keep their queries in a transaction instead.
+func (requestContext *RequestContext) findAllParallel(ctx context.Context, object domain.Object) (*[]domain.Object, int64, error) {
+	countObject, err := requestContext.Resources.Borrow(requestContext.Resource.Name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer requestContext.Resources.Return(countObject)
+	var count int64
+	var objects *[]domain.Object
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		var err error
+		count, err = countObject.Count(groupCtx, requestContext.DB.WithContext(groupCtx), countObject)
+		return err
+	})
+	group.Go(func() error {
+		var err error
+		objects, err = object.FindAll(groupCtx, requestContext.DB.WithContext(groupCtx), object)
+		return err
+	})
+	err = group.Wait()
+	if err != nil {
+		return nil, 0, err
+	}
+	return objects, count, nil
+}
+
+// findByID loads the object from the repository or the database
+func (requestContext *RequestContext) findByID(ctx context.Context, object domain.Object, uid uuid.UUID) error {
+	if requestContext.Repository != nil {
+		return requestContext.Repository.FindByID(ctx, requestContext.DBScopes, object, uid)
+	}
+	return requestContext.inTransaction(ctx, false, func(db *gorm.DB) error {
+		return object.FindByID(ctx, db, object, uid)
+	})
+}
+
+// save stores a new object in the repository or the database
+func (requestContext *RequestContext) save(ctx context.Context, db *gorm.DB, object domain.Object) error {
+	if requestContext.Repository != nil {
+		return requestContext.Repository.Save(ctx, object)
+	}
+	return object.Save(ctx, db, object)
+}
+
+// update stores the changes of the object in the repository or the database
+func (requestContext *RequestContext) update(ctx context.Context, db *gorm.DB, object domain.Object) error {
+	if requestContext.Repository != nil {
+		return requestContext.Repository.Update(ctx, object)
+	}
+	return object.Update(ctx, db, object)
+}
+
+// reload reads the stored version of the object from the repository or the database
+func (requestContext *RequestContext) reload(ctx context.Context, db *gorm.DB, object domain.Object) error {
+	if requestContext.Repository != nil {
+		return requestContext.Repository.FindByID(ctx, requestContext.DBScopes, object, object.GetID())
+	}
+	return object.FindByID(ctx, db, object, object.GetID())
+}
+
+// delete removes the object from the repository or the database
+func (requestContext *RequestContext) delete(ctx context.Context, db *gorm.DB, object domain.Object) error {
+	if requestContext.Repository != nil {
+		return requestContext.Repository.Delete(ctx, object)
+	}
+	return object.Delete(ctx, db, object)
+}
+
+// newEvent creates the mutation event for the object of the change
+func (requestContext *RequestContext) newEvent(change change) (events.Event, error) {
+	var userID *uuid.UUID
+	if requestContext.DBScopes.User != nil {
+		userID = &requestContext.DBScopes.User.ID
+	}
+	event, err := events.NewEvent(requestContext.Resource.Name, change.action, change.object.GetID(), userID, change.object)
+	if err != nil {
+		return events.Event{}, err
+	}
+	event.Region = requestContext.Origin.Region
+	event.Labels = requestContext.Origin.Labels
+	if requestContext.Resource.IsOwned() {
+		event.OwnerID = ownerOf(change.object)
+	}
+	return event, nil
+}
+
+// ownerOf returns the owner of the owned object, the JSON name of the owner column is user_id as of all columns
+func ownerOf(object domain.Object) *uuid.UUID {
+	var owned struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	data, err := json.Marshal(object)
+	if err != nil || json.Unmarshal(data, &owned) != nil || owned.UserID == uuid.Nil {
+		return nil
+	}
+	return &owned.UserID
+}
+
+// publish emits a mutation event, failures are only logged as the mutation is already persisted
+func (requestContext *RequestContext) publish(ctx context.Context, change change) {
+	if requestContext.Publisher == nil {
+		return
+	}
+	logger := GetLogger(ctx)
+	event, err := requestContext.newEvent(change)
+	if err != nil {
+		logger.Error("Error creating event", "resource", requestContext.Resource.Name, "action", change.action, "error", err)
+		return
+	}
+	err = requestContext.Publisher.Publish(ctx, event)
+	if err != nil {
+		logger.Error("Error publishing event", "event", event.ID, "type", event.Type, "error", err)
+		return
 	}
-	return false
+	logger.Debug("Event published", "event", event.ID, "type", event.Type)
 }
@@ -0,0 +1,189 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Counting is a counter of a resource that counts the objects of another resource
+type Counting struct {
+	Resource Resource
+	Counter  domain.Counter
+}
+
+// Counting returns the counters of the resources that count the objects of the resource
+func (resources *Resources) Counting(name string) []Counting {
+	var countings []Counting
+	for _, resource := range resources.Resources {
+		for _, counter := range resource.Counters {
+			if counter.Counted.Resource == name {
+				countings = append(countings, Counting{Resource: resource, Counter: counter})
+			}
+		}
+	}
+	return countings
+}
+
+// KeepCounters sets the counters of the object to the ones of the previous object, or to zero without it, as the
+// counters are not written by the clients
+func (resource Resource) KeepCounters(object, previous domain.Object) {
+	value := reflect.ValueOf(object).Elem()
+	for _, counter := range resource.Counters {
+		field, ok := domain.JSONField(value.Type(), counter.Field)
+		if !ok {
+			continue
+		}
+		if previous == nil {
+			value.FieldByIndex(field.Index).SetZero()
+			continue
+		}
+		value.FieldByIndex(field.Index).Set(reflect.ValueOf(previous).Elem().FieldByIndex(field.Index))
+	}
+}
+
+// CountedIDs returns the IDs of the objects of the counter that the objects refer to, without duplicates
+func CountedIDs(counter domain.Counter, objects ...domain.Object) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, object := range objects {
+		if object == nil {
+			continue
+		}
+		value := reflect.ValueOf(object).Elem()
+		field, ok := domain.JSONField(value.Type(), counter.Counted.Field)
+		if !ok {
+			continue
+		}
+		var id uuid.UUID
+		switch related := value.FieldByIndex(field.Index).Interface().(type) {
+		case uuid.UUID:
+			id = related
+		case *uuid.UUID:
+			if related != nil {
+				id = *related
+			}
+		}
+		if id != uuid.Nil && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Recount sets the counter of the objects of the resource with the IDs to the number of the objects that refer to
+// them. The counters are set without hooks and events, e.g. in the transaction of the mutation of a counted object.
+func (resources *Resources) Recount(ctx context.Context, db *gorm.DB, resource Resource, counter domain.Counter, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	query, count, err := resources.counting(ctx, db, resource, counter)
+	if err != nil {
+		return err
+	}
+	err = query.Where("id IN ?", ids).UpdateColumn(counter.Field, count).Error
+	if err != nil {
+		return fmt.Errorf("cannot recount %s of %s: %w", counter.Field, resource.Name, err)
+	}
+	return nil
+}
+
+// Reconcile sets the counters of all resources that differ from the number of the objects that refer to them, e.g.
+// after the events of the counted objects were lost, and returns how many objects were corrected
+func (resources *Resources) Reconcile(ctx context.Context, db *gorm.DB) (int64, error) {
+	var corrected int64
+	for _, resource := range resources.Resources {
+		for _, counter := range resource.Counters {
+			query, count, err := resources.counting(ctx, db, resource, counter)
+			if err != nil {
+				return corrected, err
+			}
+			_, differing, err := resources.counting(ctx, db, resource, counter)
+			if err != nil {
+				return corrected, err
+			}
+			result := query.Where("? <> (?)", clause.Column{Name: counter.Field}, differing).UpdateColumn(counter.Field, count)
+			if result.Error != nil {
+				return corrected, fmt.Errorf("cannot reconcile %s of %s: %w", counter.Field, resource.Name, result.Error)
+			}
+			corrected += result.RowsAffected
+		}
+	}
+	return corrected, nil
+}
+
+// counting returns the update of the objects of the resource and the count of the objects that refer to them. The
+// counted objects are aliased, so that the objects of a resource may count the objects of the same resource.
+func (resources *Resources) counting(ctx context.Context, db *gorm.DB, resource Resource, counter domain.Counter) (*gorm.DB, *gorm.DB, error) {
+	counted, ok := resources.Resources[counter.Counted.Resource]
+	if !ok {
+		return nil, nil, fmt.Errorf("counter %s of %s counts unrecognized resource: %s", counter.Field, resource.Name, counter.Counted.Resource)
+	}
+	object, err := resources.New(resource.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	table, err := tableName(db, resource)
+	if err != nil {
+		return nil, nil, err
+	}
+	countedTable, err := tableName(db, counted)
+	if err != nil {
+		return nil, nil, err
+	}
+	session := db.Session(&gorm.Session{NewDB: true}).WithContext(ctx)
+	count := session.Table("? AS counted", clause.Table{Name: countedTable}).Select("COUNT(*)").
+		Where(clause.Eq{Column: clause.Column{Table: "counted", Name: counter.Counted.Field}, Value: clause.Column{Table: table, Name: "id"}})
+	return session.Model(object), count, nil
+}
+
+// tableName returns the table of the resource
+func tableName(db *gorm.DB, resource Resource) (string, error) {
+	statement := &gorm.Statement{DB: db}
+	err := statement.Parse(reflect.New(resource.Type).Interface())
+	if err != nil {
+		return "", fmt.Errorf("cannot map %s to a table: %w", resource.Name, err)
+	}
+	return statement.Table, nil
+}
+
+// transactionCountings returns the counters of the objects of the resource that are updated in the transactions of
+// their mutations, repositories have no transactions and their objects are not counted
+func (requestContext *RequestContext) transactionCountings() []Counting {
+	if requestContext.Repository != nil || requestContext.Resources == nil {
+		return nil
+	}
+	var countings []Counting
+	for _, counting := range requestContext.Resources.Counting(requestContext.Resource.Name) {
+		if counting.Counter.InTransaction() {
+			countings = append(countings, counting)
+		}
+	}
+	return countings
+}
+
+// counted returns the mutation that recounts the objects that the changed objects refer to before and after it
+func (requestContext *RequestContext) counted(ctx context.Context, countings []Counting, changes []change, mutation func(db *gorm.DB) error) func(db *gorm.DB) error {
+	return func(db *gorm.DB) error {
+		err := mutation(db)
+		if err != nil {
+			return err
+		}
+		objects := make([]domain.Object, 0, 2*len(changes))
+		for _, change := range changes {
+			objects = append(objects, change.object, change.previous)
+		}
+		for _, counting := range countings {
+			err = requestContext.Resources.Recount(ctx, db, counting.Resource, counting.Counter, CountedIDs(counting.Counter, objects...))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+// ErrInvalidCursor is returned for cursors not issued by the lists
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the key of the last object of a page of the cursor pagination, the next page starts after it
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// NextCursor returns the cursor of the page after the objects, or nil when the page is not full and is the last one
+func NextCursor(objects []domain.Object, pageSize int) *Cursor {
+	if len(objects) == 0 || len(objects) < pageSize {
+		return nil
+	}
+	last := objects[len(objects)-1]
+	if last.GetCreatedAt() == nil {
+		return nil
+	}
+	return &Cursor{CreatedAt: *last.GetCreatedAt(), ID: last.GetID()}
+}
+
+// String encodes the cursor for the clients
+func (cursor Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%s|%s", cursor.CreatedAt.UTC().Format(time.RFC3339Nano), cursor.ID))
+}
+
+// ParseCursor decodes the cursor of a client, the empty cursor is the first page
+func ParseCursor(value string) (*Cursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	createdAt, id, ok := strings.Cut(string(decoded), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	cursor := &Cursor{}
+	cursor.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	cursor.ID, err = uuid.FromString(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	return cursor, nil
+}
@@ -1,24 +1,84 @@
 package common
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
 	MaxPageSize = 500
 	MinPageSize = 10
+	// KeysetOffset is the offset above which pages start at the key of their first row instead of skipping rows, 0 disables it
+	KeysetOffset = 10000
+	// RowLevelSecurity leaves the ownership of the objects to the row-level security policies of PostgreSQL,
+	// which read the app.user_id set in the transaction of the request, instead of filtering by user_id
+	RowLevelSecurity = false
 )
 
+// keysetOrder is the order of the lists, created_at with the ID as tiebreaker
+const keysetOrder = "created_at, id"
+
 type DBScopes struct {
 	PageSize int
 	Page     int
 	Offset   int
 	User     *domain.User
 	Global   bool
+	// OwnedOnly limits the objects to the ones of the user, for local resources without global permission
+	OwnedOnly bool
+	Origin    domain.Origin
+	// Shared are the objects of other users that the user has a relation to, they are in scope with the owned ones
+	Shared []uuid.UUID
+	// Near limits the objects to the ones within the radius of the point of their location
+	Near *Near
+	// Arrays limit the objects by the elements of their array fields
+	Arrays []ArrayFilter
+	// After is the cursor of the cursor pagination, the page starts after its object instead of at the offset
+	After *Cursor
+	// Session are the PostgreSQL parameters that are set in the transaction of the queries of the request
+	Session *Session
+}
+
+// ArrayFilter is a filter of the lists by the elements of an array field, e.g. ?tags__contains=urgent
+type ArrayFilter struct {
+	Field Field
+	// Operator is contains for arrays with all the values or overlap for arrays with any of them
+	Operator string
+	Values   domain.Strings
+}
+
+// arrayOperators are the SQL operators of the array filters
+var arrayOperators = map[string]string{
+	"contains": "@>",
+	"overlap":  "&&",
+}
+
+// Near is the proximity filter of the lists, e.g. ?near=42.69,23.32&radius=5km
+type Near struct {
+	Point    domain.Point
+	Radius   float64 // Meters
+	Location Field
+}
+
+// radiusUnits are the units of the radius in meters, radiuses without unit are in meters
+var radiusUnits = []struct {
+	suffix string
+	meters float64
+}{
+	{"km", 1000},
+	{"mi", 1609.344},
+	{"m", 1},
 }
 
 func NewDBScopes(pageSize, pageNumber, offset int, user *domain.User, isGlobal bool) DBScopes {
@@ -38,13 +98,38 @@ func NewDBScopesFromRequest(request *http.Request, isGlobal bool) DBScopes {
 		Offset:   getOffset(request),
 		User:     getCurrentUser(request),
 		Global:   isGlobal,
+		Origin:   getOrigin(request),
 	}
 }
 
 func (dbs *DBScopes) Paginate() func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
-		return db.Offset(dbs.Offset).Limit(dbs.PageSize)
+		// Counts are not ordered, as databases reject the order of columns that are not aggregated
+		if _, counting := db.Statement.Dest.(*int64); counting {
+			return db.Offset(dbs.Offset).Limit(dbs.PageSize)
+		}
+		db = db.Order(keysetOrder)
+		if dbs.After != nil {
+			return db.Where("("+keysetOrder+") > (?, ?)", dbs.After.CreatedAt, dbs.After.ID).Limit(dbs.PageSize)
+		}
+		if KeysetOffset <= 0 || dbs.Offset <= KeysetOffset {
+			return db.Offset(dbs.Offset).Limit(dbs.PageSize)
+		}
+		// Deep pages start at the key of their first row, it is looked up on the narrow (created_at, id) columns
+		// with the same filters instead of loading all the skipped rows
+		start := db.Session(&gorm.Session{NewDB: true}).Model(db.Statement.Model).Scopes(dbs.filters()...).
+			Select(keysetOrder).Order(keysetOrder).Offset(dbs.Offset).Limit(1)
+		return db.Where("("+keysetOrder+") >= (?)", start).Limit(dbs.PageSize)
+	}
+}
+
+// filters returns the scopes that select the objects of the lists
+func (dbs *DBScopes) filters() []func(db *gorm.DB) *gorm.DB {
+	filters := []func(db *gorm.DB) *gorm.DB{dbs.FromOrigin(), dbs.Nearby(), dbs.WithElements()}
+	if dbs.OwnedOnly && !RowLevelSecurity {
+		filters = append(filters, dbs.Owned())
 	}
+	return filters
 }
 
 func (dbs *DBScopes) Owned() func(db *gorm.DB) *gorm.DB {
@@ -52,11 +137,164 @@ func (dbs *DBScopes) Owned() func(db *gorm.DB) *gorm.DB {
 		if dbs.Global {
 			return db
 		} else {
+			if len(dbs.Shared) > 0 {
+				return db.Where("(user_id = ? OR id IN ?)", dbs.User.ID.String(), dbs.Shared)
+			}
 			return db.Where("user_id = ?", dbs.User.ID.String())
 		}
 	}
 }
 
+// FromOrigin filters objects by the requested region and labels
+func (dbs *DBScopes) FromOrigin() func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if dbs.Origin.Region != "" {
+			db = db.Where("region = ?", dbs.Origin.Region)
+		}
+		if len(dbs.Origin.Labels) > 0 {
+			labels, _ := json.Marshal(dbs.Origin.Labels) // Error is ignored because maps of strings are always encoded
+			db = db.Where("labels @> ?::jsonb", string(labels))
+		}
+		return db
+	}
+}
+
+// Nearby filters objects by the distance of their location to the point of the near filter
+func (dbs *DBScopes) Nearby() func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if dbs.Near == nil {
+			return db
+		}
+		column := clause.Column{Table: clause.CurrentTable, Name: dbs.Near.Location.Column}
+		return db.Where("ST_DWithin(?, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?)",
+			column, dbs.Near.Point.Lng, dbs.Near.Point.Lat, dbs.Near.Radius)
+	}
+}
+
+// WithElements filters objects by the elements of their array fields
+func (dbs *DBScopes) WithElements() func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, filter := range dbs.Arrays {
+			column := clause.Column{Table: clause.CurrentTable, Name: filter.Field.Column}
+			db = db.Where("? "+arrayOperators[filter.Operator]+" ?::text[]", column, filter.Values)
+		}
+		return db
+	}
+}
+
+// ParseArrayFilters returns the filters of the array fields given as column__contains or column__overlap
+// parameters with comma separated values, e.g. ?tags__contains=urgent&tags__overlap=home,work
+func ParseArrayFilters(request *http.Request, resource Resource) ([]ArrayFilter, error) {
+	var filters []ArrayFilter
+	for key, values := range request.URL.Query() {
+		column, operator, found := strings.Cut(key, "__")
+		if _, ok := arrayOperators[operator]; !found || !ok {
+			continue
+		}
+		field, ok := resource.Array(column)
+		if !ok {
+			return nil, fmt.Errorf("resource %s has no array field %s", resource.Name, column)
+		}
+		elements := domain.Strings{}
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				if element = strings.TrimSpace(element); element != "" {
+					elements = append(elements, element)
+				}
+			}
+		}
+		if len(elements) == 0 {
+			return nil, fmt.Errorf("%s requires at least one value", key)
+		}
+		filters = append(filters, ArrayFilter{Field: field, Operator: operator, Values: elements})
+	}
+	// The parameters are sorted, so that the queries of the same filters are the same
+	sort.Slice(filters, func(i, j int) bool {
+		if filters[i].Field.Column != filters[j].Field.Column {
+			return filters[i].Field.Column < filters[j].Field.Column
+		}
+		return filters[i].Operator < filters[j].Operator
+	})
+	return filters, nil
+}
+
+// IsArrayFilter checks that the parameter is a filter of an array field of the resource, e.g. tags__contains
+func IsArrayFilter(resource Resource, key string) bool {
+	column, operator, found := strings.Cut(key, "__")
+	if _, ok := arrayOperators[operator]; !found || !ok {
+		return false
+	}
+	_, ok := resource.Array(column)
+	return ok
+}
+
+// ParseNear returns the proximity filter of the near=lat,lng and radius parameters, nil when near is not given.
+// The radius is required and may have a unit, e.g. 500m, 5km or 3mi.
+func ParseNear(request *http.Request, resource Resource) (*Near, error) {
+	query := request.URL.Query()
+	if !query.Has("near") {
+		return nil, nil
+	}
+	if resource.Location == nil {
+		return nil, fmt.Errorf("resource %s has no location to filter by proximity", resource.Name)
+	}
+	lat, lng, found := strings.Cut(query.Get("near"), ",")
+	if !found {
+		return nil, fmt.Errorf("invalid near %q, expected lat,lng", query.Get("near"))
+	}
+	latitude, errLat := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	longitude, errLng := strconv.ParseFloat(strings.TrimSpace(lng), 64)
+	if errLat != nil || errLng != nil {
+		return nil, fmt.Errorf("invalid near %q, expected lat,lng", query.Get("near"))
+	}
+	point, err := domain.NewPoint(latitude, longitude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid near: %w", err)
+	}
+	radius, err := parseRadius(query.Get("radius"))
+	if err != nil {
+		return nil, err
+	}
+	return &Near{Point: point, Radius: radius, Location: *resource.Location}, nil
+}
+
+// parseRadius parses the radius in meters, km or mi
+func parseRadius(value string) (float64, error) {
+	if value == "" {
+		return 0, errors.New("radius is required with near")
+	}
+	number, meters := strings.TrimSpace(strings.ToLower(value)), 1.0
+	for _, unit := range radiusUnits {
+		if trimmed, found := strings.CutSuffix(number, unit.suffix); found {
+			number, meters = strings.TrimSpace(trimmed), unit.meters
+			break
+		}
+	}
+	radius, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(radius) || math.IsInf(radius, 0) || radius <= 0 {
+		return 0, fmt.Errorf("invalid radius %q, expected a positive distance like 500m, 5km or 3mi", value)
+	}
+	return radius * meters, nil
+}
+
+// NormalizePage applies the page defaults and the page size limits
+func NormalizePage(page, pageSize int) (int, int) {
+	return NormalizePageWithin(page, pageSize, MaxPageSize)
+}
+
+// NormalizePageWithin applies the page defaults and limits the page size to the maximum, the default page size
+// is limited as well, e.g. for the maximum of a tenant
+func NormalizePageWithin(page, pageSize, maxPageSize int) (int, int) {
+	if pageSize <= 0 {
+		pageSize = MinPageSize
+	}
+	pageSize = min(pageSize, maxPageSize)
+	if page <= 0 {
+		page = 1
+	}
+	return page, pageSize
+}
+
 // getCurrentUser returns the current request user ID
 func getCurrentUser(request *http.Request) *domain.User {
 	logger := GetLogger(request.Context())
@@ -70,6 +308,23 @@ func getCurrentUser(request *http.Request) *domain.User {
 	return nil
 }
 
+// getOrigin returns the requested region and labels, labels are given as repeated label=key:value parameters
+func getOrigin(request *http.Request) domain.Origin {
+	query := request.URL.Query()
+	origin := domain.Origin{Region: query.Get("region")}
+	for _, label := range query["label"] {
+		key, value, _ := strings.Cut(label, ":")
+		if key == "" {
+			continue
+		}
+		if origin.Labels == nil {
+			origin.Labels = map[string]string{}
+		}
+		origin.Labels[key] = value
+	}
+	return origin
+}
+
 func getPageSize(request *http.Request) int {
 	query := request.URL.Query()
 	pageSize, _ := strconv.Atoi(query.Get("page_size")) // Error is ignored because wrong or missing parameters are handled as 0
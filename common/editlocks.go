@@ -0,0 +1,115 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrEditLocked is returned for the updates of the objects whose edit lock the caller does not hold, and for the
+// locks of the objects whose lock another user holds
+var ErrEditLocked = errors.New("edit locked")
+
+// EditLocks are the pessimistic edit locks of the objects of the resources, kept in the edit_locks table so that
+// they are shared by the replicas. The objects are updated only by the user holding their lock.
+type EditLocks struct {
+	DB        *gorm.DB
+	TTL       time.Duration
+	Resources []string
+}
+
+// Locks checks if the objects of the resource are locked to edit them
+func (locks *EditLocks) Locks(resource string) bool {
+	return locks != nil && slices.Contains(locks.Resources, resource)
+}
+
+// Acquire locks the object for the user or renews the lock of the user. The lock of another user that has not
+// expired is broken only with force.
+func (locks *EditLocks) Acquire(ctx context.Context, resource string, id, userID uuid.UUID, force bool) (*domain.EditLock, error) {
+	now := domain.Now()
+	lock := &domain.EditLock{Resource: resource, ObjectID: id, UserID: userID, LockedAt: now, ExpiresAt: now.Add(locks.TTL)}
+	onConflict := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "resource"}, {Name: "object_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "locked_at", "expires_at"}),
+	}
+	if !force {
+		onConflict.Where = clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "edit_locks.user_id = excluded.user_id OR edit_locks.expires_at < ?", Vars: []any{now}},
+		}}
+	}
+	result := locks.DB.WithContext(ctx).Clauses(onConflict).Create(lock)
+	if result.Error != nil {
+		return nil, fmt.Errorf("cannot lock %s %s: %w", resource, id, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		held, err := locks.Get(ctx, resource, id)
+		if err != nil {
+			return nil, err
+		}
+		if held == nil {
+			return nil, fmt.Errorf("%w: %s %s was locked concurrently", ErrEditLocked, resource, id)
+		}
+		return nil, heldError(held)
+	}
+	return lock, nil
+}
+
+// Release removes the lock of the user on the object, the lock of another user is removed only with force
+func (locks *EditLocks) Release(ctx context.Context, resource string, id, userID uuid.UUID, force bool) error {
+	db := locks.DB.WithContext(ctx).Where("resource = ? AND object_id = ?", resource, id)
+	if !force {
+		held, err := locks.Get(ctx, resource, id)
+		if err != nil {
+			return err
+		}
+		if held != nil && held.UserID != userID {
+			return heldError(held)
+		}
+		db = db.Where("user_id = ?", userID)
+	}
+	return db.Delete(&domain.EditLock{}).Error
+}
+
+// Get returns the lock of the object, nil when it is not locked or its lock expired
+func (locks *EditLocks) Get(ctx context.Context, resource string, id uuid.UUID) (*domain.EditLock, error) {
+	var held []domain.EditLock
+	err := locks.DB.WithContext(ctx).Where("resource = ? AND object_id = ? AND expires_at >= ?", resource, id, domain.Now()).Limit(1).Find(&held).Error
+	if err != nil {
+		return nil, fmt.Errorf("cannot load the lock of %s %s: %w", resource, id, err)
+	}
+	if len(held) == 0 {
+		return nil, nil
+	}
+	return &held[0], nil
+}
+
+// Check returns ErrEditLocked unless the user holds the lock of the object, the objects of the resources that are
+// not locked are not checked
+func (locks *EditLocks) Check(ctx context.Context, resource string, id uuid.UUID, user *domain.User) error {
+	if !locks.Locks(resource) {
+		return nil
+	}
+	held, err := locks.Get(ctx, resource, id)
+	if err != nil {
+		return err
+	}
+	if held == nil {
+		return fmt.Errorf("%w: %s %s must be locked to edit it", ErrEditLocked, resource, id)
+	}
+	if user == nil || held.UserID != user.ID {
+		return heldError(held)
+	}
+	return nil
+}
+
+// heldError is the error of the objects whose lock another user holds
+func heldError(held *domain.EditLock) error {
+	return fmt.Errorf("%w: %s %s is locked by %s until %s", ErrEditLocked, held.Resource, held.ObjectID, held.UserID, held.ExpiresAt.Format(time.RFC3339))
+}
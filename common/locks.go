@@ -0,0 +1,115 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// ErrNoRequestContext is returned for locks taken outside of a request, e.g. in goroutines of their own
+var ErrNoRequestContext = errors.New("no request context")
+
+// AdvisoryLocks locks the objects with the advisory locks of PostgreSQL, CockroachDB has none
+var AdvisoryLocks = true
+
+// objectLock is the lock of an object in the process, it is removed when no caller holds or waits for it
+type objectLock struct {
+	mutex sync.Mutex
+	refs  int
+}
+
+// objectLocks are the locks of the objects without PostgreSQL, e.g. with SQLite or the memory backend
+var objectLocks = struct {
+	sync.Mutex
+	locks map[string]*objectLock
+}{locks: map[string]*objectLock{}}
+
+// WithObjectLock runs fn while holding the lock of the object, so that conflicting operations on it, e.g. the
+// updates of a balance, run one after another across the replicas. With PostgreSQL fn runs in a transaction of the
+// request holding the advisory lock of the object, which is released when the transaction ends. fn must run its
+// statements with the tx it gets. Without PostgreSQL the lock is held in the process, tx is nil with a repository.
+func WithObjectLock(ctx context.Context, resource string, id uuid.UUID, fn func(tx *gorm.DB) error) error {
+	requestContext := GetRequestContext(ctx)
+	if requestContext == nil {
+		return fmt.Errorf("cannot lock %s %s: %w", resource, id, ErrNoRequestContext)
+	}
+	if requestContext.Repository != nil || requestContext.DB == nil {
+		return lockInProcess(resource, id, func() error {
+			return fn(nil)
+		})
+	}
+	db := requestContext.DB.Session(&gorm.Session{NewDB: true}).WithContext(ctx)
+	operation := func(tx *gorm.DB) error {
+		err := requestContext.DBScopes.Session.Apply(tx)
+		if err != nil {
+			return err
+		}
+		err = LockObject(tx, resource, id)
+		if err != nil {
+			return err
+		}
+		return fn(tx)
+	}
+	if !AdvisoryLocks {
+		return domain.Transaction(db, operation)
+	}
+	if db.Dialector.Name() != "postgres" {
+		return lockInProcess(resource, id, func() error {
+			return domain.Transaction(db, operation)
+		})
+	}
+	return domain.Transaction(db, operation)
+}
+
+// LockObject takes the advisory lock of the object in the transaction, e.g. in the Save of a model, it waits for
+// the transactions holding it and is released when the transaction ends. It does nothing without PostgreSQL.
+func LockObject(tx *gorm.DB, resource string, id uuid.UUID) error {
+	if !AdvisoryLocks || tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	err := tx.Session(&gorm.Session{NewDB: true}).Exec("SELECT pg_advisory_xact_lock(?)", ObjectLockKey(resource, id)).Error
+	if err != nil {
+		return fmt.Errorf("cannot lock %s %s: %w", resource, id, err)
+	}
+	return nil
+}
+
+// ObjectLockKey maps the object to its advisory lock key
+func ObjectLockKey(resource string, id uuid.UUID) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(resource))
+	hash.Write([]byte{0})
+	hash.Write(id.Bytes())
+	return int64(hash.Sum64())
+}
+
+// lockInProcess runs fn while holding the lock of the object in the process
+func lockInProcess(resource string, id uuid.UUID, fn func() error) error {
+	key := resource + "/" + id.String()
+	objectLocks.Lock()
+	lock, ok := objectLocks.locks[key]
+	if !ok {
+		lock = &objectLock{}
+		objectLocks.locks[key] = lock
+	}
+	lock.refs++
+	objectLocks.Unlock()
+
+	lock.mutex.Lock()
+	defer func() {
+		lock.mutex.Unlock()
+		objectLocks.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(objectLocks.locks, key)
+		}
+		objectLocks.Unlock()
+	}()
+	return fn()
+}
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrAmbiguousLookup is returned for the lookups that find several objects
+var ErrAmbiguousLookup = errors.New("ambiguous lookup")
+
+// Lookup returns the ID of the object of the resource whose field, by JSON name, has the value, among the objects
+// visible to the user. The field is expected to be unique, gorm.ErrRecordNotFound is returned when no object has
+// the value and ErrAmbiguousLookup when several have it.
+func (requestContext *RequestContext) Lookup(ctx context.Context, field string, value any) (uuid.UUID, error) {
+	if requestContext.Repository != nil {
+		return uuid.Nil, fmt.Errorf("%w: objects are looked up only in the database", errors.ErrUnsupported)
+	}
+	if _, ok := domain.JSONField(requestContext.Resource.Type, field); !ok {
+		return uuid.Nil, fmt.Errorf("lookup field %s is not a field of %s", field, requestContext.Resource.Name)
+	}
+	object, err := requestContext.Resources.New(requestContext.Resource.Name)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	var ids []uuid.UUID
+	err = requestContext.inTransaction(ctx, false, func(db *gorm.DB) error {
+		column := clause.Column{Table: clause.CurrentTable, Name: field}
+		return db.Session(&gorm.Session{NewDB: true}).Model(object).Scopes(requestContext.DBScopes.filters()...).
+			Where(clause.Eq{Column: column, Value: value}).Limit(2).Pluck("id", &ids).Error
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	switch len(ids) {
+	case 0:
+		return uuid.Nil, fmt.Errorf("no %s with %s %v: %w", requestContext.Resource.Name, field, value, gorm.ErrRecordNotFound)
+	case 1:
+		return ids[0], nil
+	}
+	return uuid.Nil, fmt.Errorf("%w: several %s objects with %s %v", ErrAmbiguousLookup, requestContext.Resource.Name, field, value)
+}
@@ -0,0 +1,134 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/events"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrMergeConflict is returned for the merges whose related objects cannot be moved, e.g. the ones of other users
+var ErrMergeConflict = errors.New("merge conflict")
+
+// Merge merges the source object into the target object and deletes the source, e.g. a duplicate contact. The
+// fields are merged by the rules of the resource, the rules given take precedence, and the objects of its
+// relations that refer to the source are moved to the target, all in one transaction. The target emits the
+// events.MERGED event and the source the events.DELETED one. Objects of owned relations are moved only when they
+// belong to the user, unless the user has the global permission of their resource.
+func (requestContext *RequestContext) Merge(ctx context.Context, targetID, sourceID uuid.UUID, rules map[string]string) (domain.Object, error) {
+	merge := requestContext.Resource.Merge
+	if merge == nil {
+		return nil, fmt.Errorf("%w: %s objects are not mergeable", errors.ErrUnsupported, requestContext.Resource.Name)
+	}
+	if requestContext.Repository != nil {
+		return nil, fmt.Errorf("%w: objects are merged only in the database", errors.ErrUnsupported)
+	}
+	if targetID == sourceID {
+		return nil, fmt.Errorf("%w: an object cannot be merged into itself", domain.ErrValidation)
+	}
+	err := domain.CheckMergeRules(requestContext.Resource.Type, rules)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrValidation, err)
+	}
+	fields := maps.Clone(merge.Fields)
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	maps.Copy(fields, rules)
+
+	target, err := requestContext.Get(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+	err = requestContext.EditLocks.Check(ctx, requestContext.Resource.Name, targetID, requestContext.DBScopes.User)
+	if err != nil {
+		return nil, err
+	}
+	source, err := requestContext.Get(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	domain.MergeFields(target, source, fields)
+	err = target.Validate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrValidation, err)
+	}
+	err = requestContext.callWebhook(ctx, "update", &targetID, target)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []change{{action: events.MERGED, object: target}, {action: events.DELETED, object: source}}
+	err = requestContext.mutateAll(ctx, changes, func(db *gorm.DB) error {
+		err := requestContext.update(ctx, db, target)
+		if err != nil {
+			return err
+		}
+		for _, relation := range merge.Relations {
+			err = requestContext.moveRelated(ctx, db, relation, sourceID, targetID)
+			if err != nil {
+				return err
+			}
+		}
+		err = requestContext.delete(ctx, db, source)
+		if err != nil {
+			return err
+		}
+		// The moved objects have no events, the counters of the target are recounted in any mode
+		for _, counter := range requestContext.Resource.Counters {
+			err = requestContext.Resources.Recount(ctx, db, requestContext.Resource, counter, []uuid.UUID{targetID})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// moveRelated moves the objects of the relation that refer to the source to the target
+func (requestContext *RequestContext) moveRelated(ctx context.Context, db *gorm.DB, relation domain.Relation, sourceID, targetID uuid.UUID) error {
+	resource, ok := requestContext.Resources.Resources[relation.Resource]
+	if !ok {
+		return fmt.Errorf("merge relation of unrecognized resource: %s", relation.Resource)
+	}
+	if _, ok := domain.JSONField(resource.Type, relation.Field); !ok {
+		return fmt.Errorf("merge relation field %s is not a field of %s", relation.Field, resource.Name)
+	}
+	related, err := requestContext.Resources.New(resource.Name)
+	if err != nil {
+		return err
+	}
+	referring := func() *gorm.DB {
+		column := clause.Column{Table: clause.CurrentTable, Name: relation.Field}
+		return db.Session(&gorm.Session{NewDB: true}).WithContext(ctx).Model(related).Where(clause.Eq{Column: column, Value: sourceID})
+	}
+	if resource.IsOwned() && !GetPermissions(ctx).CanGlobal(resource.Name) {
+		others := referring()
+		if user := requestContext.DBScopes.User; user != nil {
+			others = others.Where("user_id <> ?", user.ID.String())
+		}
+		var count int64
+		err = others.Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: %d %s objects of other users refer to %s", ErrMergeConflict, count, resource.Name, sourceID)
+		}
+	}
+	err = referring().Update(relation.Field, targetID).Error
+	if err != nil {
+		return fmt.Errorf("cannot move %s objects to %s: %w", resource.Name, targetID, err)
+	}
+	return nil
+}
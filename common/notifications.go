@@ -0,0 +1,267 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cache"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Inbox keeps the notifications of the users in the notifications table and passes the delivered ones to the
+// watchers of their users, e.g. the streams of the clients. With Share the replicas pass on the notifications
+// delivered by the others, so that the streams receive them at any replica.
+type Inbox struct {
+	DB *gorm.DB
+	// Retention is how long the notifications are kept, 0 keeps them until they are dismissed
+	Retention   time.Duration
+	mutex       sync.Mutex
+	watchers    map[*func(domain.Notification)]uuid.UUID
+	broadcaster cache.Broadcaster
+	channel     string
+	instance    uuid.UUID
+}
+
+// inboxMessage is a delivered notification sent to the replicas
+type inboxMessage struct {
+	Instance     uuid.UUID           `json:"instance"`
+	Notification domain.Notification `json:"notification"`
+}
+
+// NewInbox creates the inbox of the notifications table
+func NewInbox(db *gorm.DB, retention time.Duration) *Inbox {
+	return &Inbox{
+		DB:        db,
+		Retention: retention,
+		watchers:  map[*func(domain.Notification)]uuid.UUID{},
+		instance:  uuid.Must(uuid.NewV4()),
+	}
+}
+
+// Share sends the delivered notifications to the replicas on the channel and receives theirs, once Run is started
+func (inbox *Inbox) Share(broadcaster cache.Broadcaster, channel string) {
+	inbox.broadcaster = broadcaster
+	inbox.channel = channel
+}
+
+// Notify delivers the notifications from the hooks and the handlers of the requests, e.g. to the users mentioned in
+// a comment. The actor of the notifications is the user of the request unless it is set. Without the inbox and in
+// dry runs the notifications are dropped.
+func Notify(ctx context.Context, notifications ...domain.Notification) error {
+	requestContext := GetRequestContext(ctx)
+	if requestContext == nil || requestContext.Inbox == nil || requestContext.DryRun {
+		return nil
+	}
+	if user := requestContext.DBScopes.User; user != nil {
+		for i := range notifications {
+			if notifications[i].ActorID == nil {
+				notifications[i].ActorID = &user.ID
+			}
+		}
+	}
+	return requestContext.Inbox.Deliver(ctx, notifications...)
+}
+
+// Deliver stores the notifications and passes them to the watchers of their users. The notifications without an
+// ID or a creation time get new ones, and the ones whose ID is stored already are skipped, so that the deliveries
+// of the same event that are retried are not repeated.
+func (inbox *Inbox) Deliver(ctx context.Context, notifications ...domain.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	now := domain.Now()
+	for i := range notifications {
+		if notifications[i].UserID == uuid.Nil {
+			return errors.New("cannot deliver a notification without its user")
+		}
+		if notifications[i].ID == uuid.Nil {
+			notifications[i].ID = uuid.Must(uuid.NewV4())
+		}
+		if notifications[i].CreatedAt.IsZero() {
+			notifications[i].CreatedAt = now
+		}
+	}
+	var delivered []domain.Notification
+	err := domain.Transaction(inbox.DB.WithContext(ctx), func(tx *gorm.DB) error {
+		delivered = nil
+		for _, notification := range notifications {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&notification)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				delivered = append(delivered, notification)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot deliver notifications: %w", err)
+	}
+	for _, notification := range delivered {
+		inbox.notify(notification)
+		inbox.broadcast(ctx, notification)
+	}
+	return nil
+}
+
+// List returns the notifications of the user, the newest first, only the unread ones with unread
+func (inbox *Inbox) List(ctx context.Context, userID uuid.UUID, unread bool, offset, limit int) ([]domain.Notification, int64, error) {
+	query := inbox.DB.WithContext(ctx).Model(&domain.Notification{}).Where("user_id = ?", userID)
+	if unread {
+		query = query.Where("read_at IS NULL")
+	}
+	var count int64
+	err := query.Count(&count).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot count notifications: %w", err)
+	}
+	notifications := []domain.Notification{}
+	err = query.Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&notifications).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot load notifications: %w", err)
+	}
+	return notifications, count, nil
+}
+
+// Unread counts the unread notifications of the user
+func (inbox *Inbox) Unread(ctx context.Context, userID uuid.UUID) (int64, error) {
+	var count int64
+	err := inbox.DB.WithContext(ctx).Model(&domain.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("cannot count unread notifications: %w", err)
+	}
+	return count, nil
+}
+
+// MarkRead marks the unread notifications of the IDs of the user as read, all of them without IDs, and returns
+// how many were marked
+func (inbox *Inbox) MarkRead(ctx context.Context, userID uuid.UUID, ids ...uuid.UUID) (int64, error) {
+	query := inbox.DB.WithContext(ctx).Model(&domain.Notification{}).Where("user_id = ? AND read_at IS NULL", userID)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	result := query.Update("read_at", domain.Now())
+	if result.Error != nil {
+		return 0, fmt.Errorf("cannot mark notifications as read: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// Dismiss deletes the notification of the user, gorm.ErrRecordNotFound when the user has no such notification
+func (inbox *Inbox) Dismiss(ctx context.Context, userID, id uuid.UUID) error {
+	result := inbox.DB.WithContext(ctx).Where("user_id = ?", userID).Delete(&domain.Notification{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("cannot dismiss notification: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Erase deletes all notifications of the user, e.g. when the data of the user is erased, and returns how many
+// were deleted
+func (inbox *Inbox) Erase(ctx context.Context, userID uuid.UUID) (int64, error) {
+	result := inbox.DB.WithContext(ctx).Delete(&domain.Notification{}, "user_id = ?", userID)
+	if result.Error != nil {
+		return 0, fmt.Errorf("cannot erase notifications: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// Watch calls notify with the notifications delivered to the user until stop is called. The notifications run in
+// the goroutine of the delivery, so they must not block.
+func (inbox *Inbox) Watch(userID uuid.UUID, notify func(domain.Notification)) (stop func()) {
+	inbox.mutex.Lock()
+	defer inbox.mutex.Unlock()
+	inbox.watchers[&notify] = userID
+	return func() {
+		inbox.mutex.Lock()
+		defer inbox.mutex.Unlock()
+		delete(inbox.watchers, &notify)
+	}
+}
+
+// Run removes the notifications older than the retention every hour until the context is cancelled. With Share it
+// passes the notifications delivered by the replicas to the watchers.
+func (inbox *Inbox) Run(ctx context.Context) {
+	if inbox.broadcaster != nil {
+		go inbox.broadcaster.Listen(ctx, inbox.channel, inbox.receive)
+	}
+	if inbox.Retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		err := inbox.cleanup(ctx)
+		if err != nil {
+			slog.Error("Error cleaning up notifications", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanup removes the notifications older than the retention
+func (inbox *Inbox) cleanup(ctx context.Context) error {
+	threshold := time.Now().UTC().Add(-inbox.Retention)
+	return inbox.DB.WithContext(ctx).Where("created_at < ?", threshold).Delete(&domain.Notification{}).Error
+}
+
+// notify passes the notification to the watchers of its user
+func (inbox *Inbox) notify(notification domain.Notification) {
+	inbox.mutex.Lock()
+	var watchers []func(domain.Notification)
+	for watcher, userID := range inbox.watchers {
+		if userID == notification.UserID {
+			watchers = append(watchers, *watcher)
+		}
+	}
+	inbox.mutex.Unlock()
+	for _, watcher := range watchers {
+		watcher(notification)
+	}
+}
+
+// broadcast sends the delivered notification to the replicas
+func (inbox *Inbox) broadcast(ctx context.Context, notification domain.Notification) {
+	if inbox.broadcaster == nil {
+		return
+	}
+	body, err := json.Marshal(inboxMessage{Instance: inbox.instance, Notification: notification})
+	if err != nil {
+		slog.Error("Error encoding notification", "error", err)
+		return
+	}
+	err = inbox.broadcaster.Broadcast(ctx, inbox.channel, body)
+	if err != nil {
+		slog.Error("Error broadcasting notification", "notification", notification.ID, "error", err)
+	}
+}
+
+// receive passes a notification delivered by a replica to the watchers
+func (inbox *Inbox) receive(body []byte) {
+	var message inboxMessage
+	err := json.Unmarshal(body, &message)
+	if err != nil {
+		slog.Error("Error decoding notification", "error", err)
+		return
+	}
+	if message.Instance == inbox.instance {
+		return
+	}
+	inbox.notify(message.Notification)
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// Permissions are the permissions of the current user resolved from the roles of the token, e.g. meal.read.
+// Custom handlers read them with GetPermissions instead of matching the permission strings.
+type Permissions []string
+
+// GetPermissions returns the permissions of the current user of the context, none without an authenticated user
+func GetPermissions(ctx context.Context) Permissions {
+	switch permissions := ctx.Value(CurrentUserPermissionsKey).(type) {
+	case Permissions:
+		return permissions
+	case []string:
+		return permissions
+	}
+	return nil
+}
+
+// Can checks that the user may perform the action on the resource, e.g. Can("meal", "read")
+func (permissions Permissions) Can(resource, action string) bool {
+	resourcePermission := resource + "." + action
+	for _, permission := range permissions {
+		if strings.EqualFold(permission, resourcePermission) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanGlobal checks that the user sees the objects of all users of the resource, not only the own ones
+func (permissions Permissions) CanGlobal(resource string) bool {
+	return permissions.Can(resource, GLOBAL)
+}
+
+// Actions returns the sorted actions the user may perform on the resource
+func (permissions Permissions) Actions(resource string) []string {
+	return permissions.ByResource()[strings.ToLower(resource)]
+}
+
+// Resources returns the sorted names of the resources the user has any permission on
+func (permissions Permissions) Resources() []string {
+	byResource := permissions.ByResource()
+	resources := make([]string, 0, len(byResource))
+	for resource := range byResource {
+		resources = append(resources, resource)
+	}
+	slices.Sort(resources)
+	return resources
+}
+
+// ByResource returns the sorted actions of each resource, e.g. {"meal": ["read", "write"]}
+func (permissions Permissions) ByResource() map[string][]string {
+	byResource := map[string][]string{}
+	for _, permission := range permissions {
+		resource, action, ok := strings.Cut(strings.ToLower(permission), ".")
+		if !ok || slices.Contains(byResource[resource], action) {
+			continue
+		}
+		byResource[resource] = append(byResource[resource], action)
+	}
+	for _, actions := range byResource {
+		slices.Sort(actions)
+	}
+	return byResource
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/dzahariev/respite/domain"
+)
+
+const (
+	// READ_PERSONAL reveals the personal fields of the resource
+	READ_PERSONAL = "read_personal"
+	// READ_SENSITIVE reveals the personal and the sensitive fields of the resource
+	READ_SENSITIVE = "read_sensitive"
+)
+
+// Reveals checks that the user sees the fields of the PII level of the resource unmasked
+func (permissions Permissions) Reveals(resource, level string) bool {
+	if level == domain.PII_PERSONAL && permissions.Can(resource, READ_PERSONAL) {
+		return true
+	}
+	return permissions.Can(resource, READ_SENSITIVE)
+}
+
+// Mask returns a copy of the object with the PII fields that the permissions do not reveal masked, the object
+// itself is returned when nothing is masked
+func (resource Resource) Mask(object domain.Object, permissions Permissions) domain.Object {
+	var masked reflect.Value
+	for _, field := range resource.PII {
+		if permissions.Reveals(resource.Name, field.Level) {
+			continue
+		}
+		if !masked.IsValid() {
+			value := reflect.ValueOf(object)
+			if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Type() != resource.Type {
+				return object
+			}
+			masked = reflect.New(resource.Type)
+			masked.Elem().Set(value.Elem())
+		}
+		value := masked.Elem().FieldByName(field.Name)
+		if value.Kind() == reflect.String && value.Len() > 0 {
+			value.SetString(domain.PII_MASK)
+		} else {
+			value.SetZero()
+		}
+	}
+	if !masked.IsValid() {
+		return object
+	}
+	return masked.Interface().(domain.Object)
+}
+
+// Unmask keeps the stored values of the PII strings that are sent back masked, e.g. by clients updating the
+// objects they read without the permissions
+func (resource Resource) Unmask(object, existing domain.Object) {
+	value, stored := reflect.ValueOf(object), reflect.ValueOf(existing)
+	if value.Kind() != reflect.Pointer || stored.Kind() != reflect.Pointer || value.Type() != stored.Type() {
+		return
+	}
+	for _, field := range resource.PII {
+		fieldValue := value.Elem().FieldByName(field.Name)
+		if fieldValue.Kind() == reflect.String && fieldValue.String() == domain.PII_MASK {
+			fieldValue.Set(stored.Elem().FieldByName(field.Name))
+		}
+	}
+}
+
+// MaskList returns a copy of the list with the objects masked by Mask
+func (resource Resource) MaskList(list *domain.List, permissions Permissions) *domain.List {
+	if len(resource.PII) == 0 {
+		return list
+	}
+	masked := *list
+	masked.Data = make([]domain.Object, len(list.Data))
+	for i, object := range list.Data {
+		masked.Data[i] = resource.Mask(object, permissions)
+	}
+	return &masked
+}
+
+// Redact masks the PII fields that the permissions do not reveal in the JSON of an object of the resource like
+// Mask, without permissions all of them, e.g. for the audit log. Data that is not a JSON object is returned as it is.
+func (resource Resource) Redact(data json.RawMessage, permissions Permissions) json.RawMessage {
+	if len(resource.PII) == 0 || len(data) == 0 {
+		return data
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return data
+	}
+	for _, field := range resource.PII {
+		value, ok := fields[field.JSON]
+		if !ok || permissions.Reveals(resource.Name, field.Level) {
+			continue
+		}
+		var text string
+		if json.Unmarshal(value, &text) == nil && text != "" {
+			fields[field.JSON], _ = json.Marshal(domain.PII_MASK)
+		} else {
+			fields[field.JSON] = json.RawMessage("null")
+		}
+	}
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return data
+	}
+	return redacted
+}
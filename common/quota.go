@@ -0,0 +1,49 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded is wrapped by the errors of creations beyond the quota of the owner
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Usage is the number of objects of a resource that the owner has and may have
+type Usage struct {
+	Resource string `json:"resource"`
+	Limit    int64  `json:"limit"`
+	Used     int64  `json:"used"`
+}
+
+// Remaining returns how many objects the owner may still create
+func (usage Usage) Remaining() int64 {
+	return max(usage.Limit-usage.Used, 0)
+}
+
+// Quota limits the objects of the resource that the owner of the request creates
+type Quota struct {
+	Limit int64
+	// Count returns the number of objects of the owner, the user or all users of the tenant
+	Count func(ctx context.Context) (int64, error)
+	// Usage is the usage after the creation, set by Check
+	Usage *Usage
+}
+
+// Check counts the objects of the owner and returns ErrQuotaExceeded when the limit is reached. The quota is
+// soft, concurrent creations may exceed it slightly. Requests without quota are not checked.
+func (quota *Quota) Check(ctx context.Context, resourceName string) error {
+	if quota == nil {
+		return nil
+	}
+	count, err := quota.Count(ctx)
+	if err != nil {
+		return err
+	}
+	if count >= quota.Limit {
+		quota.Usage = &Usage{Resource: resourceName, Limit: quota.Limit, Used: count}
+		return fmt.Errorf("%w: %d of %d %s objects are used", ErrQuotaExceeded, count, quota.Limit, resourceName)
+	}
+	quota.Usage = &Usage{Resource: resourceName, Limit: quota.Limit, Used: count + 1}
+	return nil
+}
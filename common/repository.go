@@ -0,0 +1,18 @@
+package common
+
+import (
+	"context"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+// Repository persists the objects instead of the database, it applies the ownership, origin and pagination scopes itself
+type Repository interface {
+	Count(ctx context.Context, scopes DBScopes, object domain.Object) (int64, error)
+	FindAll(ctx context.Context, scopes DBScopes, object domain.Object) (*[]domain.Object, error)
+	FindByID(ctx context.Context, scopes DBScopes, object domain.Object, uid uuid.UUID) error
+	Save(ctx context.Context, object domain.Object) error
+	Update(ctx context.Context, object domain.Object) error
+	Delete(ctx context.Context, object domain.Object) error
+}
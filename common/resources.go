@@ -1,10 +1,15 @@
 package common
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"reflect"
+	"strings"
+	"sync"
 
 	"github.com/dzahariev/respite/domain"
+	"gorm.io/gorm/schema"
 )
 
 // Resource represent a resource entity in the system.
@@ -12,6 +17,69 @@ type Resource struct {
 	Name     string
 	IsGlobal bool
 	Type     reflect.Type
+	// DefaultPageSize is used for lists without requested page size, 0 uses MinPageSize
+	DefaultPageSize int
+	// Shared decides the access to the objects by the relations of the users to them, see domain.SharedObject
+	Shared bool
+	// ConfirmDelete requires the confirm=true parameter to delete the objects, see domain.DangerousObject
+	ConfirmDelete bool
+	// Location is the first domain.Point field of the resource, lists are filtered by proximity to it
+	Location *Field
+	// Arrays are the domain.Strings fields of the resource, lists are filtered by their elements
+	Arrays []Field
+	// CacheControl is the cacheability of the responses, see domain.CacheableObject
+	CacheControl *domain.CacheControl
+	// Webhook validates and enriches the objects before they are created or updated, see domain.WebhookObject
+	Webhook *domain.Webhook
+	// Erasure declares how the objects are erased with the data of their owner, see domain.ErasableObject
+	Erasure *domain.Erasure
+	// PII are the fields of personal data, masked in the responses unless the permissions reveal them
+	PII []domain.PIIField
+	// Deprecation announces the removal of the resource in the responses, see domain.DeprecatedObject
+	Deprecation *domain.Deprecation
+	// Aggregates are the summaries returned in the meta of the lists, see domain.AggregatedObject
+	Aggregates []domain.Aggregate
+	// Merge declares how the duplicates are merged, see domain.MergeableObject
+	Merge *domain.Merge
+	// Counters are the fields counting the objects of other resources that refer to the objects, see
+	// domain.CountedObject
+	Counters []domain.Counter
+	// SQLView is the SQL view of a read-only resource, see domain.SQLViewObject
+	SQLView *domain.SQLView
+	// factory creates the objects, see domain.FactoryObject
+	factory func() domain.Object
+	// pool keeps the objects that are used only within the requests for their reuse, the objects of the factories
+	// are not zero values and are not pooled
+	pool *sync.Pool
+}
+
+// IsOwned checks if the objects of the resource belong to the users, the other resources are global or the users
+func (resource Resource) IsOwned() bool {
+	return !resource.IsGlobal && resource.Type != reflect.TypeFor[domain.User]()
+}
+
+// Field is a field of a resource addressed by the list filters, by its Go name and its column
+type Field struct {
+	Name   string
+	Column string
+}
+
+// Array returns the array field of the column, false if the resource has no such array
+func (resource Resource) Array(column string) (Field, bool) {
+	for _, field := range resource.Arrays {
+		if field.Column == column {
+			return field, true
+		}
+	}
+	return Field{}, false
+}
+
+// PageSize returns the page size, or the default page size of the resource when it is not given
+func (resource Resource) PageSize(pageSize int) int {
+	if pageSize <= 0 && resource.DefaultPageSize > 0 {
+		return min(resource.DefaultPageSize, MaxPageSize)
+	}
+	return pageSize
 }
 
 // Resources is used to hold information about supported resources
@@ -19,16 +87,231 @@ type Resources struct {
 	Resources map[string]Resource
 }
 
-// Register is used to register a resource type
-func (resources *Resources) Register(object domain.Object) {
+// Register is used to register a resource type, the type is validated so that misconfigured models fail on
+// start instead of during requests
+func (resources *Resources) Register(object domain.Object) error {
+	objectType := reflect.TypeOf(object)
+	if object == nil || objectType.Kind() != reflect.Pointer || objectType.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("resource %T must be a pointer to a struct", object)
+	}
+	objectType = objectType.Elem()
 	name := object.ResourceName()
-	isGlobal := object.IsGlobal()
-	objectType := reflect.TypeOf(object).Elem()
-	resources.Resources[name] = Resource{
+	if name == "" {
+		return fmt.Errorf("resource %s has no name", objectType)
+	}
+	if registered, ok := resources.Resources[name]; ok {
+		return fmt.Errorf("resource name %s is used by both %s and %s", name, registered.Type, objectType)
+	}
+	err := validateResource(object, objectType)
+	if err != nil {
+		return fmt.Errorf("invalid resource %s (%s): %w", name, objectType, err)
+	}
+	resource := Resource{
 		Name:     name,
-		IsGlobal: isGlobal,
+		IsGlobal: object.IsGlobal(),
 		Type:     objectType,
 	}
+	resource.factory, err = objectFactory(object, objectType)
+	if err != nil {
+		return fmt.Errorf("invalid resource %s (%s): %w", name, objectType, err)
+	}
+	if _, ok := object.(domain.FactoryObject); !ok {
+		resource.pool = &sync.Pool{New: func() any { return reflect.New(objectType).Interface() }}
+	}
+	resource.Location, resource.Arrays = filterFields(object)
+	resource.PII, err = domain.PIIFields(objectType)
+	if err != nil {
+		return fmt.Errorf("invalid resource %s (%s): %w", name, objectType, err)
+	}
+	if shared, ok := object.(domain.SharedObject); ok && shared.Shared() {
+		if !resource.IsOwned() {
+			return fmt.Errorf("invalid resource %s (%s): only the objects owned by the users are shared", name, objectType)
+		}
+		resource.Shared = true
+	}
+	if dangerous, ok := object.(domain.DangerousObject); ok {
+		resource.ConfirmDelete = dangerous.ConfirmDelete()
+	}
+	if paged, ok := object.(domain.PagedObject); ok {
+		resource.DefaultPageSize = paged.DefaultPageSize()
+		if resource.DefaultPageSize < 1 {
+			return fmt.Errorf("invalid resource %s (%s): default page size must be greater than 0, got %d", name, objectType, resource.DefaultPageSize)
+		}
+	}
+	if cacheable, ok := object.(domain.CacheableObject); ok {
+		cacheControl := cacheable.CacheControl()
+		err = cacheControl.Check()
+		if err != nil {
+			return fmt.Errorf("invalid resource %s (%s): %w", name, objectType, err)
+		}
+		resource.CacheControl = &cacheControl
+	}
+	if hooked, ok := object.(domain.WebhookObject); ok {
+		webhook := hooked.Webhook()
+		err = webhook.Check()
+		if err != nil {
+			return fmt.Errorf("invalid resource %s (%s): %w", name, objectType, err)
+		}
+		resource.Webhook = &webhook
+	}
+	if erasable, ok := object.(domain.ErasableObject); ok {
+		erasure := erasable.Erasure()
+		err = erasure.Check(objectType)
+		if err != nil {
+			return fmt.Errorf("invalid resource %s (%s): %w", name, objectType, err)
+		}
+		resource.Erasure = &erasure
+	}
+	if deprecated, ok := object.(domain.DeprecatedObject); ok {
+		deprecation := deprecated.Deprecation()
+		err = deprecation.Check()
+		if err != nil {
+			return fmt.Errorf("invalid resource %s (%s): %w", name, objectType, err)
+		}
+		resource.Deprecation = &deprecation
+	}
+	if mergeable, ok := object.(domain.MergeableObject); ok {
+		merge := mergeable.Merge()
+		err = merge.Check(objectType)
+		if err != nil {
+			return fmt.Errorf("invalid resource %s (%s): %w", name, objectType, err)
+		}
+		resource.Merge = &merge
+	}
+	if aggregated, ok := object.(domain.AggregatedObject); ok {
+		resource.Aggregates = aggregated.Aggregates()
+		err = checkAggregates(resource)
+		if err != nil {
+			return fmt.Errorf("invalid resource %s (%s): %w", name, objectType, err)
+		}
+	}
+	if counted, ok := object.(domain.CountedObject); ok {
+		resource.Counters = counted.Counters()
+		for _, counter := range resource.Counters {
+			err = counter.Check(objectType)
+			if err != nil {
+				return fmt.Errorf("invalid resource %s (%s): %w", name, objectType, err)
+			}
+		}
+	}
+	if viewed, ok := object.(domain.SQLViewObject); ok {
+		view := viewed.SQLView()
+		err = view.Check()
+		if err == nil && (resource.Merge != nil || len(resource.Counters) > 0) {
+			err = errors.New("SQL views are read-only, they cannot be merged or have counters")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid resource %s (%s): %w", name, objectType, err)
+		}
+		resource.SQLView = &view
+	}
+	resources.Resources[name] = resource
+	return nil
+}
+
+// checkAggregates validates the aggregates of the resource, their names are unique and the personal data fields are
+// not aggregated, as the aggregates are not masked
+func checkAggregates(resource Resource) error {
+	names := map[string]bool{}
+	for _, aggregate := range resource.Aggregates {
+		err := aggregate.Check(resource.Type)
+		if err != nil {
+			return err
+		}
+		if names[aggregate.Name] {
+			return fmt.Errorf("aggregate name %s is used twice", aggregate.Name)
+		}
+		names[aggregate.Name] = true
+		for _, field := range resource.PII {
+			if field.JSON == aggregate.Field || field.JSON == aggregate.GroupBy {
+				return fmt.Errorf("aggregate %s cannot aggregate the personal data field %s", aggregate.Name, field.JSON)
+			}
+		}
+	}
+	return nil
+}
+
+// validateResource checks that the type embeds domain.Base, is owned by the users unless it is global, and that
+// the JSON names of the columns are the column names, as filters and scopes address the columns by them
+func validateResource(object domain.Object, objectType reflect.Type) error {
+	var problems []error
+	baseField, ok := objectType.FieldByName("Base")
+	if !ok || !baseField.Anonymous || baseField.Type != reflect.TypeFor[domain.Base]() {
+		problems = append(problems, errors.New("domain.Base must be embedded"))
+	}
+	resourceSchema, err := schema.Parse(object, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		return errors.Join(append(problems, fmt.Errorf("cannot map to a table: %w", err))...)
+	}
+	// Users own the other resources, they are listed with the global permission
+	_, isUser := object.(*domain.User)
+	if !object.IsGlobal() && !isUser {
+		if _, ok := object.(domain.LocalObject); !ok {
+			problems = append(problems, errors.New("resources that are not global must implement SetUserID of domain.LocalObject"))
+		}
+		if resourceSchema.LookUpField("user_id") == nil {
+			problems = append(problems, errors.New("resources that are not global must have the user_id column"))
+		}
+	}
+	jsonNames := map[string]string{}
+	for _, field := range resourceSchema.Fields {
+		if field.DBName == "" || !field.Readable || field.StructField.Tag.Get("json") == "-" {
+			continue
+		}
+		jsonName, _, _ := strings.Cut(field.StructField.Tag.Get("json"), ",")
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+		if other, ok := jsonNames[jsonName]; ok {
+			problems = append(problems, fmt.Errorf("fields %s and %s have the same JSON name %s", other, field.Name, jsonName))
+			continue
+		}
+		jsonNames[jsonName] = field.Name
+		if jsonName != field.DBName {
+			problems = append(problems, fmt.Errorf("field %s has the JSON name %s but the column %s", field.Name, jsonName, field.DBName))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// filterFields returns the first point field and the array fields of the resource
+func filterFields(object domain.Object) (*Field, []Field) {
+	resourceSchema, err := schema.Parse(object, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		return nil, nil
+	}
+	pointType, stringsType := reflect.TypeFor[domain.Point](), reflect.TypeFor[domain.Strings]()
+	var location *Field
+	var arrays []Field
+	for _, field := range resourceSchema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		switch field.FieldType {
+		case pointType, reflect.PointerTo(pointType):
+			if location == nil {
+				location = &Field{Name: field.Name, Column: field.DBName}
+			}
+		case stringsType:
+			arrays = append(arrays, Field{Name: field.Name, Column: field.DBName})
+		}
+	}
+	return location, arrays
+}
+
+// Clone returns a copy of the registered resources, registering and unregistering resources of the copy does
+// not change the original, so that the resources can be replaced while they are used
+func (resources *Resources) Clone() *Resources {
+	return &Resources{Resources: maps.Clone(resources.Resources)}
+}
+
+// Unregister removes the registered resource
+func (resources *Resources) Unregister(name string) error {
+	if _, ok := resources.Resources[name]; !ok {
+		return fmt.Errorf("unrecognized resource name: %s", name)
+	}
+	delete(resources.Resources, name)
+	return nil
 }
 
 // Names returns the names of all registered resources
@@ -46,6 +329,9 @@ func (resources *Resources) New(name string) (domain.Object, error) {
 	if !ok {
 		return nil, fmt.Errorf("unrecognized resource name: %s", name)
 	}
+	if t.factory != nil {
+		return t.factory(), nil
+	}
 
 	obj, ok := reflect.New(t.Type).Interface().(domain.Object)
 	if !ok {
@@ -54,6 +340,45 @@ func (resources *Resources) New(name string) (domain.Object, error) {
 	return obj, nil
 }
 
+// Borrow returns an empty object of the resource from the objects given back with Return, for the objects that are
+// used only within a request, e.g. the previous versions of the updated objects. The objects of the models that are
+// domain.FactoryObject are created with New, as their factories may set defaults that zeroing would lose.
+func (resources *Resources) Borrow(name string) (domain.Object, error) {
+	resource, ok := resources.Resources[name]
+	if !ok || resource.pool == nil {
+		return resources.New(name)
+	}
+	return resource.pool.Get().(domain.Object), nil
+}
+
+// Return gives the borrowed object back for its reuse, zeroed, it must not be used or referenced after it
+func (resources *Resources) Return(object domain.Object) {
+	if object == nil {
+		return
+	}
+	resource, ok := resources.Resources[object.ResourceName()]
+	if !ok || resource.pool == nil || reflect.TypeOf(object) != reflect.PointerTo(resource.Type) {
+		return
+	}
+	reflect.ValueOf(object).Elem().SetZero()
+	resource.pool.Put(object)
+}
+
+// objectFactory returns the constructor of the objects of the type, the NewObject of the domain.FactoryObject models
+// or reflection for the others
+func objectFactory(object domain.Object, objectType reflect.Type) (func() domain.Object, error) {
+	if factoryObject, ok := object.(domain.FactoryObject); ok {
+		created := factoryObject.NewObject()
+		if reflect.TypeOf(created) != reflect.PointerTo(objectType) {
+			return nil, fmt.Errorf("NewObject must return %s, got %T", reflect.PointerTo(objectType), created)
+		}
+		return factoryObject.NewObject, nil
+	}
+	return func() domain.Object {
+		return reflect.New(objectType).Interface().(domain.Object)
+	}, nil
+}
+
 // IsGlobal is used to check if a resource is global
 func (resources *Resources) IsGlobal(name string) bool {
 	resource, ok := resources.Resources[name]
@@ -62,3 +387,12 @@ func (resources *Resources) IsGlobal(name string) bool {
 	}
 	return resource.IsGlobal
 }
+
+// HasOrigin is used to check if objects of a resource are stamped with their origin
+func (resources *Resources) HasOrigin(name string) bool {
+	resource, ok := resources.Resources[name]
+	if !ok {
+		return false
+	}
+	return reflect.PointerTo(resource.Type).Implements(reflect.TypeFor[domain.OriginObject]())
+}
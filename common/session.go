@@ -0,0 +1,86 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxApplicationName is the length of the application_name that PostgreSQL keeps, longer names are truncated
+const maxApplicationName = 63
+
+// Session are the PostgreSQL parameters of the queries of a request, e.g. to bound them with a statement timeout,
+// to run them as a role that row-level security policies apply to and to attribute them to the request in
+// pg_stat_activity. They are set with SET LOCAL semantics, so they end with the transaction of the request.
+type Session struct {
+	StatementTimeout time.Duration
+	Role             string
+	ApplicationName  string
+	// Settings are other parameters, e.g. custom ones like app.tenant_id that are read by the policies
+	Settings map[string]string
+}
+
+// NewSession creates the session parameters of the request, the application name is followed by the request ID
+func NewSession(statementTimeout time.Duration, role, applicationName, requestID string) *Session {
+	if applicationName != "" && requestID != "" {
+		applicationName += "/" + requestID
+	}
+	if len(applicationName) > maxApplicationName {
+		applicationName = applicationName[:maxApplicationName]
+	}
+	session := &Session{StatementTimeout: statementTimeout, Role: role, ApplicationName: applicationName}
+	if session.IsEmpty() {
+		return nil
+	}
+	return session
+}
+
+// IsEmpty checks that no parameter is set, the queries of such requests run without a transaction
+func (session *Session) IsEmpty() bool {
+	return session == nil || (session.StatementTimeout <= 0 && session.Role == "" && session.ApplicationName == "" && len(session.Settings) == 0)
+}
+
+// Parameters returns the parameters and their values sorted by name
+func (session *Session) Parameters() [][2]string {
+	if session.IsEmpty() {
+		return nil
+	}
+	var parameters [][2]string
+	if session.ApplicationName != "" {
+		parameters = append(parameters, [2]string{"application_name", session.ApplicationName})
+	}
+	if session.Role != "" {
+		parameters = append(parameters, [2]string{"role", session.Role})
+	}
+	if session.StatementTimeout > 0 {
+		parameters = append(parameters, [2]string{"statement_timeout", fmt.Sprintf("%dms", session.StatementTimeout.Milliseconds())})
+	}
+	for name, value := range session.Settings {
+		parameters = append(parameters, [2]string{name, value})
+	}
+	slices.SortFunc(parameters, func(a, b [2]string) int { return strings.Compare(a[0], b[0]) })
+	return parameters
+}
+
+// Apply sets the parameters for the rest of the transaction with set_config, which takes the values as bind
+// parameters. The statement runs without the scopes of the request, e.g. the pagination.
+func (session *Session) Apply(tx *gorm.DB) error {
+	parameters := session.Parameters()
+	if len(parameters) == 0 {
+		return nil
+	}
+	calls := make([]string, 0, len(parameters))
+	args := make([]any, 0, 2*len(parameters))
+	for _, parameter := range parameters {
+		calls = append(calls, "set_config(?, ?, true)")
+		args = append(args, parameter[0], parameter[1])
+	}
+	err := tx.Session(&gorm.Session{NewDB: true}).Exec("SELECT "+strings.Join(calls, ", "), args...).Error
+	if err != nil {
+		return fmt.Errorf("cannot set the session parameters: %w", err)
+	}
+	return nil
+}
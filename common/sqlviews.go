@@ -0,0 +1,75 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// ErrReadOnlyResource is returned for the mutations of the resources backed by SQL views
+var ErrReadOnlyResource = errors.New("read-only resource")
+
+// RefreshView refreshes the materialized view of the resource. The databases without materialized views have plain
+// views, which are not refreshed.
+func RefreshView(ctx context.Context, db *gorm.DB, resource Resource) error {
+	if resource.SQLView == nil || !resource.SQLView.Materialized {
+		return fmt.Errorf("resource %s is not a materialized view", resource.Name)
+	}
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	table, err := tableName(db, resource)
+	if err != nil {
+		return err
+	}
+	statement := "REFRESH MATERIALIZED VIEW "
+	if resource.SQLView.Concurrently {
+		statement += "CONCURRENTLY "
+	}
+	err = db.WithContext(ctx).Exec(statement + db.Statement.Quote(table)).Error
+	if err != nil {
+		return fmt.Errorf("cannot refresh view of %s: %w", resource.Name, err)
+	}
+	return nil
+}
+
+// Migrate creates the tables of the resources and then their SQL views from the queries of the views, for the
+// development mode and the tests. The views are created once, the materialized views are plain views in the
+// databases without them.
+func Migrate(db *gorm.DB, resources *Resources) error {
+	var views []Resource
+	for _, resource := range resources.Resources {
+		if resource.SQLView != nil {
+			views = append(views, resource)
+			continue
+		}
+		err := db.AutoMigrate(reflect.New(resource.Type).Interface())
+		if err != nil {
+			return fmt.Errorf("cannot migrate resource %s: %w", resource.Name, err)
+		}
+	}
+	for _, resource := range views {
+		if resource.SQLView.Query == "" {
+			return fmt.Errorf("cannot migrate resource %s: the view has no query", resource.Name)
+		}
+		table, err := tableName(db, resource)
+		if err != nil {
+			return err
+		}
+		statement := "CREATE VIEW IF NOT EXISTS "
+		if db.Dialector.Name() == "postgres" {
+			statement = "CREATE OR REPLACE VIEW "
+			if resource.SQLView.Materialized {
+				statement = "CREATE MATERIALIZED VIEW IF NOT EXISTS "
+			}
+		}
+		err = db.Exec(statement + db.Statement.Quote(table) + " AS " + resource.SQLView.Query).Error
+		if err != nil {
+			return fmt.Errorf("cannot migrate resource %s: %w", resource.Name, err)
+		}
+	}
+	return nil
+}
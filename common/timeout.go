@@ -0,0 +1,66 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// timeoutKey keeps the cancel function and the context of a statement with the query timeout
+const timeoutKey = "respite:timeout"
+
+// timedStatement is the cancel function of the timeout of a statement and the context to restore when it ends
+type timedStatement struct {
+	cancel context.CancelFunc
+	parent context.Context
+}
+
+// RegisterQueryTimeout bounds each statement of the database by the timeout, on top of the deadline of the context
+// of the statement. Statements of canceled requests are canceled as well, the driver cancels them on the server.
+// Rows returned by Rows() are read after the statement and are bounded only by the context of the caller.
+func RegisterQueryTimeout(db *gorm.DB, timeout time.Duration) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("timeout:before_create", startTimeout(timeout)),
+		callbacks.Create().After("gorm:create").Register("timeout:after_create", endTimeout),
+		callbacks.Query().Before("gorm:query").Register("timeout:before_query", startTimeout(timeout)),
+		callbacks.Query().After("gorm:query").Register("timeout:after_query", endTimeout),
+		callbacks.Update().Before("gorm:update").Register("timeout:before_update", startTimeout(timeout)),
+		callbacks.Update().After("gorm:update").Register("timeout:after_update", endTimeout),
+		callbacks.Delete().Before("gorm:delete").Register("timeout:before_delete", startTimeout(timeout)),
+		callbacks.Delete().After("gorm:delete").Register("timeout:after_delete", endTimeout),
+		callbacks.Raw().Before("gorm:raw").Register("timeout:before_raw", startTimeout(timeout)),
+		callbacks.Raw().After("gorm:raw").Register("timeout:after_raw", endTimeout),
+	)
+}
+
+// startTimeout sets the timeout on the context of the statement, statements without a request context are logged
+// so that the callers missing WithContext are found
+func startTimeout(timeout time.Duration) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		parent := db.Statement.Context
+		if parent == nil {
+			parent = context.Background()
+		}
+		if parent.Done() == nil {
+			slog.Debug("Statement without context, it cannot be canceled", "table", db.Statement.Table)
+		}
+		ctx, cancel := context.WithTimeout(parent, timeout)
+		db.Statement.Context = ctx
+		db.InstanceSet(timeoutKey, timedStatement{cancel: cancel, parent: parent})
+	}
+}
+
+// endTimeout releases the timeout of the statement and restores its context
+func endTimeout(db *gorm.DB) {
+	value, ok := db.InstanceGet(timeoutKey)
+	if !ok {
+		return
+	}
+	statement := value.(timedStatement)
+	statement.cancel()
+	db.Statement.Context = statement.parent
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// totalColumn is the column with the total of the list in the rows of its page
+	totalColumn = "respite_total"
+	// totalKey is the setting of the queries that read the total of the list
+	totalKey = "respite:total"
+)
+
+var (
+	// WindowCount counts the lists with count(*) OVER() in the query of the page instead of a separate COUNT query
+	WindowCount = false
+	// ParallelCount counts the lists concurrently with the query of the page when they are counted separately
+	ParallelCount = false
+)
+
+// RegisterWindowCount replaces the query callback of the database, so that the total of the lists is read from
+// the rows of their page. Other queries are executed by the default callback.
+func RegisterWindowCount(db *gorm.DB) error {
+	return db.Callback().Query().Replace("gorm:query", queryWithTotal)
+}
+
+// queryWithTotal is the query callback that keeps the total column of the queries with the total setting
+func queryWithTotal(db *gorm.DB) {
+	value, ok := db.Get(totalKey)
+	total, isTotal := value.(*int64)
+	if !ok || !isTotal || db.Error != nil {
+		callbacks.Query(db)
+		return
+	}
+	callbacks.BuildQuerySQL(db)
+	if db.DryRun || db.Error != nil {
+		return
+	}
+	rows, err := db.Statement.ConnPool.QueryContext(db.Statement.Context, db.Statement.SQL.String(), db.Statement.Vars...)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	defer func() {
+		db.AddError(rows.Close())
+	}()
+	gorm.Scan(&totalRows{Rows: rows, total: total, index: -1}, db, 0)
+	if db.Statement.Result != nil {
+		db.Statement.Result.RowsAffected = db.RowsAffected
+	}
+}
+
+// totalRows scans the total column of the rows into the total, the other columns into the objects
+type totalRows struct {
+	gorm.Rows
+	total *int64
+	index int
+}
+
+// Columns returns the columns and keeps the index of the total column
+func (rows *totalRows) Columns() ([]string, error) {
+	columns, err := rows.Rows.Columns()
+	for i, column := range columns {
+		if column == totalColumn {
+			rows.index = i
+		}
+	}
+	return columns, err
+}
+
+// Scan scans the row, the total column is the same in all rows of the page
+func (rows *totalRows) Scan(dest ...any) error {
+	if rows.index >= 0 && rows.index < len(dest) {
+		dest[rows.index] = rows.total
+	}
+	return rows.Rows.Scan(dest...)
+}
+
+// countsInQuery checks that the total of the list can be counted in the query of its page. Pages loaded by
+// keyset or after a cursor only have the rows from their first key on.
+func (dbs *DBScopes) countsInQuery() bool {
+	return WindowCount && dbs.After == nil && (KeysetOffset <= 0 || dbs.Offset <= KeysetOffset)
+}
+
+// withTotal selects the total of the list in each row of the page
+func withTotal(db *gorm.DB, total *int64) *gorm.DB {
+	return db.Set(totalKey, total).Select("?.*, count(*) OVER() AS "+totalColumn, clause.Table{Name: clause.CurrentTable})
+}
@@ -0,0 +1,139 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// webhookTimeout limits the webhook calls without a timeout
+	webhookTimeout = 5 * time.Second
+	// maxWebhookResponse limits the responses of the webhooks
+	maxWebhookResponse = 1 << 20
+)
+
+// webhookRequest is the body sent to the webhooks
+type webhookRequest struct {
+	Resource  string        `json:"resource"`
+	Operation string        `json:"operation"`
+	ID        *uuid.UUID    `json:"id,omitempty"`
+	UserID    *uuid.UUID    `json:"user_id,omitempty"`
+	Object    domain.Object `json:"object"`
+	DryRun    bool          `json:"dry_run,omitempty"`
+}
+
+// webhookResponse is the decision of the webhooks, the object replaces the fields of the sent object
+type webhookResponse struct {
+	Allowed *bool           `json:"allowed"`
+	Message string          `json:"message"`
+	Object  json.RawMessage `json:"object"`
+}
+
+// callWebhook sends the object to the webhook of the resource and applies its decision. Objects are rejected
+// with a validation error when the webhook answers allowed=false, 400 or 422, and the fields of the returned
+// object replace the fields of the object, which is validated again. Unavailable or failing webhooks reject
+// the object unless the failure policy ignores them. The ID is sent for updates.
+func (requestContext *RequestContext) callWebhook(ctx context.Context, operation string, id *uuid.UUID, object domain.Object) error {
+	webhook := requestContext.Resource.Webhook
+	if webhook == nil {
+		return nil
+	}
+	logger := GetLogger(ctx)
+	response, err := requestContext.sendWebhook(ctx, webhook, operation, id, object)
+	if err != nil {
+		if webhook.Ignores() {
+			logger.Warn("Webhook failed, the object is persisted", "resource", requestContext.Resource.Name, "error", err)
+			return nil
+		}
+		logger.Error("Webhook failed", "resource", requestContext.Resource.Name, "error", err)
+		return err
+	}
+	if response.Allowed != nil && !*response.Allowed {
+		return fmt.Errorf("%w: %s", domain.ErrValidation, webhookMessage(response.Message, "rejected by the webhook"))
+	}
+	if len(response.Object) == 0 || string(response.Object) == "null" {
+		return nil
+	}
+	// The ID is kept, the webhook changes only the fields of the object
+	previousID := object.GetID()
+	err = json.Unmarshal(response.Object, object)
+	object.SetID(previousID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid object of the webhook: %w", domain.ErrWebhook, err)
+	}
+	err = object.Validate(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", domain.ErrValidation, err)
+	}
+	return nil
+}
+
+// sendWebhook posts the object to the webhook and returns its decision, rejections of the webhook are returned
+// as decisions and the other failures as errors
+func (requestContext *RequestContext) sendWebhook(ctx context.Context, webhook *domain.Webhook, operation string, id *uuid.UUID, object domain.Object) (*webhookResponse, error) {
+	body := webhookRequest{Resource: requestContext.Resource.Name, Operation: operation, ID: id, Object: object, DryRun: requestContext.DryRun}
+	if requestContext.DBScopes.User != nil {
+		body.UserID = &requestContext.DBScopes.User.ID
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	timeout := webhook.Timeout
+	if timeout <= 0 {
+		timeout = webhookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrWebhook, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	client := requestContext.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResponse, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", domain.ErrWebhook, webhook.URL, err)
+	}
+	defer httpResponse.Body.Close()
+	data, err = io.ReadAll(io.LimitReader(httpResponse.Body, maxWebhookResponse))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", domain.ErrWebhook, webhook.URL, err)
+	}
+	response := &webhookResponse{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		err = json.Unmarshal(data, response)
+		if err != nil && httpResponse.StatusCode < http.StatusMultipleChoices {
+			return nil, fmt.Errorf("%w: %s: invalid response: %w", domain.ErrWebhook, webhook.URL, err)
+		}
+	}
+	switch {
+	case httpResponse.StatusCode == http.StatusBadRequest || httpResponse.StatusCode == http.StatusUnprocessableEntity:
+		allowed := false
+		response.Allowed = &allowed
+		response.Message = webhookMessage(response.Message, http.StatusText(httpResponse.StatusCode))
+		return response, nil
+	case httpResponse.StatusCode >= http.StatusMultipleChoices:
+		return nil, fmt.Errorf("%w: %s: status %d", domain.ErrWebhook, webhook.URL, httpResponse.StatusCode)
+	}
+	return response, nil
+}
+
+// webhookMessage returns the message of the webhook or the fallback without it
+func webhookMessage(message, fallback string) string {
+	if message == "" {
+		return fallback
+	}
+	return message
+}
@@ -0,0 +1,271 @@
+package coordination
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// Elector elects the leader of a named lock among the instances, the instance holding the advisory lock or the
+// lease of the name, so that only one of multiple replicas does the work of the lock
+type Elector struct {
+	Name string
+	DB   *gorm.DB
+	// Retry is how often the followers try to become leader, and how often the leader renews its lease
+	Retry time.Duration
+	// Standalone makes the instance the leader without election, for a single instance
+	Standalone bool
+	// TransactionLock holds the advisory lock in a transaction that stays open, for poolers in transaction mode
+	// that do not keep the sessions of the clients, e.g. PgBouncer
+	TransactionLock bool
+	// Lease holds a row of the scheduler_leases table that expires unless the leader renews it, for databases
+	// without advisory locks, e.g. CockroachDB
+	Lease        bool
+	mutex        sync.Mutex
+	leader       *sql.Conn
+	leaderTx     *sql.Tx
+	holder       uuid.UUID
+	leaseRenewed time.Time
+	lastAttempt  time.Time
+}
+
+// NewElector creates the elector of the lock, a zero retry is replaced by 10 seconds
+func NewElector(db *gorm.DB, name string, retry time.Duration) *Elector {
+	if retry <= 0 {
+		retry = 10 * time.Second
+	}
+	return &Elector{Name: name, DB: db, Retry: retry}
+}
+
+// Named creates the elector of another lock with the same settings
+func (elector *Elector) Named(name string) *Elector {
+	return &Elector{
+		Name:            name,
+		DB:              elector.DB,
+		Retry:           elector.Retry,
+		Standalone:      elector.Standalone,
+		TransactionLock: elector.TransactionLock,
+		Lease:           elector.Lease,
+	}
+}
+
+// single checks if the instance is the only one, without database or with a database that is not PostgreSQL,
+// e.g. SQLite
+func (elector *Elector) single() bool {
+	return elector.DB == nil || elector.Standalone || elector.DB.Dialector.Name() != "postgres"
+}
+
+// Lead checks that the instance is the leader, and tries to become leader at most every Retry when it is not
+func (elector *Elector) Lead(ctx context.Context) bool {
+	if elector.IsLeader(ctx) {
+		return true
+	}
+	elector.mutex.Lock()
+	due := time.Since(elector.lastAttempt) >= elector.Retry
+	if due {
+		elector.lastAttempt = time.Now()
+	}
+	elector.mutex.Unlock()
+	return due && elector.Elect(ctx)
+}
+
+// Elect tries to become leader by acquiring the advisory lock on a dedicated connection
+func (elector *Elector) Elect(ctx context.Context) bool {
+	if elector.single() {
+		return true
+	}
+	if elector.Lease {
+		return elector.acquireLease(ctx)
+	}
+	sqlDB, err := elector.DB.DB()
+	if err != nil {
+		slog.Error("Error getting database connection pool", "error", err)
+		return false
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		slog.Error("Error getting database connection for leader election", "lock", elector.Name, "error", err)
+		return false
+	}
+	var acquired bool
+	var tx *sql.Tx
+	if elector.TransactionLock {
+		tx, err = conn.BeginTx(ctx, nil)
+		if err == nil {
+			err = tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", lockKey(elector.Name)).Scan(&acquired)
+			if err != nil || !acquired {
+				tx.Rollback()
+			}
+		}
+	} else {
+		err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", lockKey(elector.Name)).Scan(&acquired)
+	}
+	if err != nil || !acquired {
+		if err != nil {
+			slog.Error("Error acquiring lock", "lock", elector.Name, "error", err)
+		}
+		conn.Close()
+		return false
+	}
+	elector.mutex.Lock()
+	elector.leader = conn
+	elector.leaderTx = tx
+	elector.mutex.Unlock()
+	slog.Info("Leadership acquired", "lock", elector.Name)
+	return true
+}
+
+// IsLeader checks that the lock connection is still alive, the lock is released by the database when it is lost.
+// A single instance is always the leader.
+func (elector *Elector) IsLeader(ctx context.Context) bool {
+	if elector.single() {
+		return true
+	}
+	if elector.Lease {
+		return elector.renewLease(ctx)
+	}
+	elector.mutex.Lock()
+	conn, tx := elector.leader, elector.leaderTx
+	elector.mutex.Unlock()
+	if conn == nil {
+		return false
+	}
+	var err error
+	if tx != nil {
+		// The connection is in use by the transaction of the lock
+		_, err = tx.ExecContext(ctx, "SELECT 1")
+	} else {
+		err = conn.PingContext(ctx)
+	}
+	if err == nil {
+		return true
+	}
+	slog.Error("Leadership lost", "lock", elector.Name, "error", err)
+	elector.mutex.Lock()
+	elector.leader = nil
+	elector.leaderTx = nil
+	elector.mutex.Unlock()
+	if tx != nil {
+		tx.Rollback()
+	}
+	conn.Close()
+	return false
+}
+
+// Resign releases the advisory lock, the lock of the transaction ends with it
+func (elector *Elector) Resign() {
+	if elector.Lease {
+		elector.releaseLease()
+		return
+	}
+	elector.mutex.Lock()
+	conn, tx := elector.leader, elector.leaderTx
+	elector.leader = nil
+	elector.leaderTx = nil
+	elector.mutex.Unlock()
+	if conn == nil {
+		return
+	}
+	if tx != nil {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			slog.Error("Error releasing lock", "lock", elector.Name, "error", err)
+		}
+		conn.Close()
+		return
+	}
+	_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey(elector.Name))
+	if err != nil {
+		slog.Error("Error releasing lock", "lock", elector.Name, "error", err)
+	}
+	conn.Close()
+}
+
+// leaseDuration is how long the lease is held without being renewed, the leader renews it every Retry, so a
+// follower takes over within two more tries when the leader is gone
+func (elector *Elector) leaseDuration() time.Duration {
+	return 3 * elector.Retry
+}
+
+// acquireLease takes the lease when it is not held or has expired, the expiry uses the clock of the database
+func (elector *Elector) acquireLease(ctx context.Context) bool {
+	elector.mutex.Lock()
+	if elector.holder == uuid.Nil {
+		elector.holder = uuid.Must(uuid.NewV4())
+	}
+	holder := elector.holder
+	elector.mutex.Unlock()
+	result := elector.DB.WithContext(ctx).Exec(`INSERT INTO scheduler_leases (name, holder, expires_at)
+VALUES (?, ?, now() + ? * INTERVAL '1 second')
+ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+WHERE scheduler_leases.expires_at < now() OR scheduler_leases.holder = excluded.holder`,
+		elector.Name, holder, elector.leaseDuration().Seconds())
+	if result.Error != nil {
+		slog.Error("Error acquiring lease", "lease", elector.Name, "error", result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	elector.mutex.Lock()
+	elector.leaseRenewed = time.Now()
+	elector.mutex.Unlock()
+	slog.Info("Leadership acquired", "lease", elector.Name)
+	return true
+}
+
+// renewLease extends the lease of the leader every Retry, the leadership is lost when the lease cannot be
+// renewed, e.g. when it expired and a follower took it
+func (elector *Elector) renewLease(ctx context.Context) bool {
+	elector.mutex.Lock()
+	holder, renewed := elector.holder, elector.leaseRenewed
+	elector.mutex.Unlock()
+	if renewed.IsZero() {
+		return false
+	}
+	if time.Since(renewed) < elector.Retry {
+		return true
+	}
+	result := elector.DB.WithContext(ctx).Exec("UPDATE scheduler_leases SET expires_at = now() + ? * INTERVAL '1 second' WHERE name = ? AND holder = ? AND expires_at > now()",
+		elector.leaseDuration().Seconds(), elector.Name, holder)
+	if result.Error == nil && result.RowsAffected == 1 {
+		elector.mutex.Lock()
+		elector.leaseRenewed = time.Now()
+		elector.mutex.Unlock()
+		return true
+	}
+	slog.Error("Leadership lost", "lease", elector.Name, "error", result.Error)
+	elector.mutex.Lock()
+	elector.leaseRenewed = time.Time{}
+	elector.mutex.Unlock()
+	return false
+}
+
+// releaseLease gives the lease up, so a follower does not wait for its expiry
+func (elector *Elector) releaseLease() {
+	elector.mutex.Lock()
+	holder, renewed := elector.holder, elector.leaseRenewed
+	elector.leaseRenewed = time.Time{}
+	elector.mutex.Unlock()
+	if renewed.IsZero() {
+		return
+	}
+	err := elector.DB.Exec("DELETE FROM scheduler_leases WHERE name = ? AND holder = ?", elector.Name, holder).Error
+	if err != nil {
+		slog.Error("Error releasing lease", "lease", elector.Name, "error", err)
+	}
+}
+
+// lockKey maps a lock name to an advisory lock key
+func lockKey(name string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return int64(hash.Sum64())
+}
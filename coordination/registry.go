@@ -0,0 +1,104 @@
+package coordination
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Instance is a running instance of the deployment, it is alive while it sends its heartbeats
+type Instance struct {
+	ID          uuid.UUID `json:"id" gorm:"primaryKey"`
+	Host        string    `json:"host"`
+	StartedAt   time.Time `json:"started_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+	// Current marks the instance answering the request
+	Current bool `json:"current" gorm:"-"`
+}
+
+// TableName returns the instances table name
+func (i *Instance) TableName() string {
+	return "instances"
+}
+
+// Registry registers the instance in the instances table and refreshes its heartbeat, so that the instances of
+// the deployment are known, e.g. to the operators. The instances are alive until they miss three heartbeats.
+type Registry struct {
+	DB       *gorm.DB
+	Interval time.Duration
+	Instance Instance
+}
+
+// NewRegistry creates the registry of a new instance, a zero interval is replaced by 10 seconds
+func NewRegistry(db *gorm.DB, interval time.Duration) *Registry {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	host, _ := os.Hostname()
+	now := time.Now().UTC()
+	return &Registry{
+		DB:       db,
+		Interval: interval,
+		Instance: Instance{ID: uuid.Must(uuid.NewV4()), Host: host, StartedAt: now, HeartbeatAt: now},
+	}
+}
+
+// Run sends the heartbeats until the context is cancelled, then removes the instance
+func (registry *Registry) Run(ctx context.Context) {
+	slog.Info("Instance registered", "instance", registry.Instance.ID, "host", registry.Instance.Host)
+	ticker := time.NewTicker(registry.Interval)
+	defer ticker.Stop()
+	for {
+		err := registry.heartbeat(ctx)
+		if err != nil {
+			slog.Error("Error sending instance heartbeat", "instance", registry.Instance.ID, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			err = registry.DB.Delete(&Instance{}, "id = ?", registry.Instance.ID).Error
+			if err != nil {
+				slog.Error("Error removing instance", "instance", registry.Instance.ID, "error", err)
+			}
+			slog.Info("Instance removed", "instance", registry.Instance.ID)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expiry is when the instances that did not send a heartbeat since are no longer alive
+func (registry *Registry) expiry() time.Time {
+	return time.Now().UTC().Add(-3 * registry.Interval)
+}
+
+// heartbeat refreshes the heartbeat of the instance and removes the instances that are no longer alive, e.g.
+// after a crash
+func (registry *Registry) heartbeat(ctx context.Context) error {
+	db := registry.DB.WithContext(ctx)
+	instance := registry.Instance
+	instance.HeartbeatAt = time.Now().UTC()
+	err := db.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"heartbeat_at"})}).Create(&instance).Error
+	if err != nil {
+		return fmt.Errorf("cannot refresh heartbeat: %w", err)
+	}
+	return db.Delete(&Instance{}, "heartbeat_at < ?", registry.expiry()).Error
+}
+
+// Instances returns the instances that are alive ordered by their start
+func (registry *Registry) Instances(ctx context.Context) ([]Instance, error) {
+	var instances []Instance
+	err := registry.DB.WithContext(ctx).Where("heartbeat_at >= ?", registry.expiry()).Order("started_at, id").Find(&instances).Error
+	if err != nil {
+		return nil, fmt.Errorf("cannot load instances: %w", err)
+	}
+	for i := range instances {
+		instances[i].Current = instances[i].ID == registry.Instance.ID
+	}
+	return instances, nil
+}
@@ -0,0 +1,44 @@
+package coordination
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLeadershipLost is the cause of the cancellation of a singleton task whose instance lost its lock
+var ErrLeadershipLost = errors.New("leadership lost")
+
+// Singleton runs the task while the elector holds its lock, so that it runs on one instance at a time, e.g. a
+// scheduled purge. It returns false without running the task while another instance holds the lock. The context
+// of the task is cancelled when the lock is lost.
+func Singleton(ctx context.Context, elector *Elector, run func(ctx context.Context) error) (bool, error) {
+	if !elector.Elect(ctx) {
+		return false, nil
+	}
+	defer elector.Resign()
+	taskCtx, cancel := context.WithCancelCause(ctx)
+	// The lock is released once it is no longer checked
+	watched := make(chan struct{})
+	defer func() {
+		cancel(nil)
+		<-watched
+	}()
+	go func() {
+		defer close(watched)
+		ticker := time.NewTicker(elector.Retry)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-taskCtx.Done():
+				return
+			case <-ticker.C:
+				if !elector.IsLeader(taskCtx) && taskCtx.Err() == nil {
+					cancel(ErrLeadershipLost)
+					return
+				}
+			}
+		}
+	}()
+	return true, run(taskCtx)
+}
@@ -0,0 +1,70 @@
+package dev
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/dzahariev/respite/api"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// memory is the SQLite database name that is not persisted
+const memory = ":memory:"
+
+// Flag registers the --dev flag that enables the development mode
+func Flag(flagSet *flag.FlagSet) *bool {
+	return flagSet.Bool("dev", false, "run with SQLite, without authentication and with fake data")
+}
+
+// NewServer creates the server with api.NewServerFromConfig. In the development mode the resources are kept in the
+// SQLite database, whose tables are created by auto-migration, requests are not authenticated, logging is verbose
+// and fake data is created when the database is new. SQLite requires cgo.
+func NewServer(config *cfg.Config, modelObjects []domain.Object, roleToPermissions map[string][]string, options ...api.Option) (*api.Server, error) {
+	if !config.Dev.Enabled {
+		return api.NewServerFromConfig(config, modelObjects, roleToPermissions, options...)
+	}
+	config.Logger.Level = "debug"
+	_, err := os.Stat(config.Dev.Database)
+	created := config.Dev.Database == memory || os.IsNotExist(err)
+	db, err := open(config.Dev.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	devOptions := []api.Option{api.WithDB(db), api.WithDevMode()}
+	if created && config.Dev.FakeData > 0 {
+		devOptions = append(devOptions, api.WithFakeData(config.Dev.FakeData))
+	}
+	server, err := api.NewServerFromConfig(config, modelObjects, roleToPermissions, append(devOptions, options...)...)
+	if err != nil {
+		return nil, err
+	}
+	err = common.Migrate(db, server.Resources)
+	if err != nil {
+		return nil, err
+	}
+	slog.Warn("Development mode enabled, do not use it in production", "database", config.Dev.Database, "created", created)
+	return server, nil
+}
+
+// open connects to the SQLite database, the SQL statements are logged
+func open(path string) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Info), NowFunc: domain.Now})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open sqlite database %s: %w", path, err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer and every connection opens its own in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	return db, nil
+}
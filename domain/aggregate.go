@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+const (
+	AGGREGATE_COUNT = "count"
+	AGGREGATE_SUM   = "sum"
+	AGGREGATE_AVG   = "avg"
+	AGGREGATE_MIN   = "min"
+	AGGREGATE_MAX   = "max"
+)
+
+// AggregatedObject is implemented by objects whose lists carry summary aggregates, e.g. for dashboards
+type AggregatedObject interface {
+	Aggregates() []Aggregate
+}
+
+// Aggregate is a summary of the objects of a list, computed for its filters and returned in the meta of the list
+type Aggregate struct {
+	// Name is the key of the aggregate in the meta of the list
+	Name string
+	// Function is AGGREGATE_COUNT, AGGREGATE_SUM, AGGREGATE_AVG, AGGREGATE_MIN or AGGREGATE_MAX
+	Function string
+	// Field is the JSON name of the aggregated field, not needed by AGGREGATE_COUNT
+	Field string
+	// GroupBy is the JSON name of the field whose values the aggregate is computed for, e.g. the status
+	GroupBy string
+}
+
+// Check validates the function and the fields of the aggregate of the type
+func (a Aggregate) Check(objectType reflect.Type) error {
+	if a.Name == "" {
+		return errors.New("aggregate requires the name")
+	}
+	switch a.Function {
+	case AGGREGATE_COUNT:
+	case AGGREGATE_SUM, AGGREGATE_AVG, AGGREGATE_MIN, AGGREGATE_MAX:
+		if a.Field == "" {
+			return fmt.Errorf("aggregate %s requires the field", a.Name)
+		}
+	default:
+		return fmt.Errorf("aggregate %s function must be %s, %s, %s, %s or %s, got %q", a.Name, AGGREGATE_COUNT, AGGREGATE_SUM, AGGREGATE_AVG, AGGREGATE_MIN, AGGREGATE_MAX, a.Function)
+	}
+	for _, field := range []string{a.Field, a.GroupBy} {
+		if field == "" {
+			continue
+		}
+		if _, ok := JSONField(objectType, field); !ok {
+			return fmt.Errorf("aggregate %s field %s is not a field of %s", a.Name, field, objectType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Strings is a list of strings stored in a PostgreSQL text[] column, e.g. tags, and serialized as a JSON array.
+// Lists are filtered by the elements with the __contains and __overlap parameters, e.g. ?tags__contains=urgent.
+type Strings []string
+
+// MarshalJSON encodes the strings as a JSON array, empty and nil lists as []
+func (s Strings) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]string(s))
+}
+
+// UnmarshalJSON decodes JSON arrays of strings, other values are validation errors
+func (s *Strings) UnmarshalJSON(data []byte) error {
+	var values []string
+	err := json.Unmarshal(data, &values)
+	if err != nil {
+		return fmt.Errorf("%w: an array of strings is expected: %w", ErrValidation, err)
+	}
+	*s = values
+	return nil
+}
+
+// GormDBDataType stores the strings in text[] columns, other databases, e.g. SQLite of the tests and the
+// development mode, keep the array literal as text
+func (Strings) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == "postgres" {
+		return "text[]"
+	}
+	return "text"
+}
+
+// Value stores the strings as array literal, e.g. {"urgent","home"}
+func (s Strings) Value() (driver.Value, error) {
+	quoted := make([]string, 0, len(s))
+	for _, value := range s {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		value = strings.ReplaceAll(value, `"`, `\"`)
+		quoted = append(quoted, `"`+value+`"`)
+	}
+	return "{" + strings.Join(quoted, ",") + "}", nil
+}
+
+// Scan loads the strings from an array literal, NULL elements are skipped
+func (s *Strings) Scan(value any) error {
+	var text string
+	switch typed := value.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case []byte:
+		text = string(typed)
+	case string:
+		text = typed
+	default:
+		return fmt.Errorf("cannot scan %T into strings", value)
+	}
+	text = strings.TrimSpace(text)
+	if len(text) < 2 || text[0] != '{' || text[len(text)-1] != '}' {
+		return fmt.Errorf("invalid array %q", text)
+	}
+	values := Strings{}
+	var element strings.Builder
+	quoted, inQuotes, escaped := false, false, false
+	add := func() {
+		if quoted || element.String() != "NULL" {
+			values = append(values, element.String())
+		}
+		element.Reset()
+		quoted = false
+	}
+	body := text[1 : len(text)-1]
+	for _, r := range body {
+		switch {
+		case escaped:
+			element.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+			quoted = true
+		case r == ',' && !inQuotes:
+			add()
+		default:
+			element.WriteRune(r)
+		}
+	}
+	if body != "" {
+		add()
+	}
+	*s = values
+	return nil
+}
+
+// Contains checks that all the values are elements of the strings
+func (s Strings) Contains(values ...string) bool {
+	for _, value := range values {
+		if !slices.Contains(s, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Overlaps checks that any of the values is an element of the strings
+func (s Strings) Overlaps(values ...string) bool {
+	for _, value := range values {
+		if slices.Contains(s, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package domain
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"time"
@@ -11,6 +12,9 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+// ErrValidation is wrapped by the errors of objects failing validation
+var ErrValidation = errors.New("validation failed")
+
 // Object is an abstration of all Base objects
 type Object interface {
 	ResourceName() string
@@ -35,6 +39,37 @@ type LocalObject interface {
 	SetUserID(uuid.UUID)
 }
 
+// PagedObject is implemented by objects that are listed with another page size than SERVER_MIN_PAGE_SIZE
+// when the page size is not requested
+type PagedObject interface {
+	DefaultPageSize() int
+}
+
+// FactoryObject is implemented by the models that create their instances without reflection, which is faster on the
+// hot paths of the requests, e.g.
+//
+//	func (m *Meal) NewObject() domain.Object { return &Meal{} }
+type FactoryObject interface {
+	NewObject() Object
+}
+
+// SharedObject is implemented by the objects that are shared with other users, their access is decided by the
+// relations of the users to them with the authorizer of the server instead of by their owners only
+type SharedObject interface {
+	Shared() bool
+}
+
+// ManagedObject is implemented by the objects with fields that only the server writes, e.g. the status of the
+// uploaded files, the updates of the clients keep them from the previous version
+type ManagedObject interface {
+	KeepManaged(previous Object)
+}
+
+// DangerousObject is implemented by objects that are deleted through the API only with the confirm=true parameter
+type DangerousObject interface {
+	ConfirmDelete() bool
+}
+
 // Base holds technical fields
 type Base struct {
 	ID        uuid.UUID  `json:"id"`
@@ -97,6 +132,7 @@ func (b *Base) BasePrepare(ctx context.Context) error {
 
 // Count returns count of all known objects of this type
 func (b *Base) Count(ctx context.Context, db *gorm.DB, object Object) (int64, error) {
+	db = db.WithContext(ctx)
 	var count int64
 	err := db.Model(object).Count(&count).Error
 	if err != nil {
@@ -107,13 +143,14 @@ func (b *Base) Count(ctx context.Context, db *gorm.DB, object Object) (int64, er
 
 // FindByID returns an objects with corresponding ID if exists
 func (b *Base) FindByID(ctx context.Context, db *gorm.DB, object Object, uid uuid.UUID) error {
+	db = db.WithContext(ctx)
 	preloads := object.Preloads()
 	if len(preloads) != 0 {
 		for _, preload := range preloads {
-			db.Preload(preload)
+			db = db.Preload(preload)
 		}
 	} else {
-		db.Preload(clause.Associations)
+		db = db.Preload(clause.Associations)
 	}
 	err := db.Model(object).First(object, uid).Error
 	if err != nil {
@@ -124,14 +161,15 @@ func (b *Base) FindByID(ctx context.Context, db *gorm.DB, object Object, uid uui
 
 // FindAll returns all known objects of this type
 func (b *Base) FindAll(ctx context.Context, db *gorm.DB, object Object) (*[]Object, error) {
+	db = db.WithContext(ctx)
 	entites := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(object)), 0, 0).Interface()
 	preloads := object.Preloads()
 	if len(preloads) != 0 {
 		for _, preload := range preloads {
-			db.Preload(preload)
+			db = db.Preload(preload)
 		}
 	} else {
-		db.Preload(clause.Associations)
+		db = db.Preload(clause.Associations)
 	}
 	err := db.Model(&object).Find(&entites).Error
 	if err != nil {
@@ -149,6 +187,7 @@ func (b *Base) FindAll(ctx context.Context, db *gorm.DB, object Object) (*[]Obje
 
 // Delete is removing existing objects
 func (b *Base) Delete(ctx context.Context, db *gorm.DB, object Object) error {
+	db = db.WithContext(ctx)
 	err := db.Delete(object).Error
 	if err != nil {
 		return err
@@ -165,10 +204,10 @@ func (b *Base) Save(ctx context.Context, db *gorm.DB, object Object) error {
 
 	err = object.Validate(ctx)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrValidation, err)
 	}
 
-	err = db.Create(object).Error
+	err = db.WithContext(ctx).Create(object).Error
 	if err != nil {
 		return err
 	}
@@ -183,10 +222,10 @@ func (b *Base) Update(ctx context.Context, db *gorm.DB, object Object) error {
 
 	err := object.Validate(ctx)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrValidation, err)
 	}
 
-	err = db.Updates(object).Error
+	err = db.WithContext(ctx).Updates(object).Error
 
 	if err != nil {
 		return err
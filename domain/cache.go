@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// CacheableObject is implemented by objects whose responses may be cached, e.g. reference data that rarely changes
+type CacheableObject interface {
+	CacheControl() CacheControl
+}
+
+// CacheControl describes how long the responses of a resource may be cached by the clients and by the server
+type CacheControl struct {
+	// MaxAge is the max-age of the Cache-Control header, 0 requires clients to revalidate the responses
+	MaxAge time.Duration
+	// Public allows shared caches, e.g. proxies and CDNs, to keep the responses, otherwise only the client keeps them
+	Public bool
+	// TTL is how long the server keeps the responses when the response cache is configured, 0 uses MaxAge
+	TTL time.Duration
+}
+
+// Check validates that the durations are not negative
+func (c CacheControl) Check() error {
+	if c.MaxAge < 0 {
+		return fmt.Errorf("cache max-age must not be negative, got %s", c.MaxAge)
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("cache TTL must not be negative, got %s", c.TTL)
+	}
+	return nil
+}
+
+// Header returns the value of the Cache-Control header, e.g. private, max-age=60
+func (c CacheControl) Header() string {
+	visibility := "private"
+	if c.Public {
+		visibility = "public"
+	}
+	if c.MaxAge < time.Second {
+		return visibility + ", no-cache"
+	}
+	return fmt.Sprintf("%s, max-age=%d", visibility, int64(c.MaxAge/time.Second))
+}
+
+// ServerTTL returns how long the server keeps the responses
+func (c CacheControl) ServerTTL() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return c.MaxAge
+}
@@ -0,0 +1,22 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// Consent is the acceptance of a version of a document by a user, e.g. of the terms of service. Consents are
+// the evidence of the acceptance, they are kept when the data of the user is erased.
+type Consent struct {
+	ID         uuid.UUID `gorm:"primaryKey" json:"id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Document   string    `json:"document"`
+	Version    string    `json:"version"`
+	AcceptedAt time.Time `json:"accepted_at"`
+}
+
+// TableName returns the consents table name
+func (c *Consent) TableName() string {
+	return "consents"
+}
@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+const (
+	COUNTER_TRANSACTION = "transaction"
+	COUNTER_EVENTS      = "events"
+)
+
+// CountedObject is implemented by objects with counters of the objects of other resources that refer to them, e.g.
+// the comments_count of the posts, so that the reads do not count them
+type CountedObject interface {
+	Counters() []Counter
+}
+
+// Counter is an integer field that counts the objects of the relation that refer to the object, it is updated on
+// the mutations of the counted objects
+type Counter struct {
+	// Field is the JSON name of the counter field
+	Field string
+	// Counted is the field of the counted objects with the ID of the object, e.g. the post_id of the comments
+	Counted Relation
+	// Mode is COUNTER_TRANSACTION, the default, to update the counter in the transaction of the mutations of the
+	// counted objects, or COUNTER_EVENTS to update it from their mutation events
+	Mode string
+}
+
+// Check validates the counter field of the type and the mode
+func (c Counter) Check(objectType reflect.Type) error {
+	field, ok := JSONField(objectType, c.Field)
+	if !ok {
+		return fmt.Errorf("counter field %s is not a field of %s", c.Field, objectType)
+	}
+	switch field.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return fmt.Errorf("counter field %s must be an integer, got %s", c.Field, field.Type)
+	}
+	if c.Counted.Resource == "" || c.Counted.Field == "" {
+		return errors.New("counter requires the resource and the field of the counted objects")
+	}
+	switch c.Mode {
+	case "", COUNTER_TRANSACTION, COUNTER_EVENTS:
+	default:
+		return fmt.Errorf("counter %s mode must be %s or %s, got %q", c.Field, COUNTER_TRANSACTION, COUNTER_EVENTS, c.Mode)
+	}
+	return nil
+}
+
+// InTransaction checks if the counter is updated in the transaction of the mutations of the counted objects
+func (c Counter) InTransaction() bool {
+	return c.Mode != COUNTER_EVENTS
+}
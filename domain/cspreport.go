@@ -0,0 +1,27 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// CSPReport is a violation of the Content Security Policy of the static files reported by a browser
+type CSPReport struct {
+	ID                 uuid.UUID `gorm:"primaryKey" json:"id"`
+	ReceivedAt         time.Time `json:"received_at"`
+	DocumentURL        string    `json:"document_url"`
+	BlockedURL         string    `json:"blocked_url"`
+	EffectiveDirective string    `json:"effective_directive"`
+	Disposition        string    `json:"disposition"`
+	SourceFile         string    `json:"source_file,omitempty"`
+	LineNumber         int       `json:"line_number,omitempty"`
+	ColumnNumber       int       `json:"column_number,omitempty"`
+	Sample             string    `json:"sample,omitempty"`
+	UserAgent          string    `json:"user_agent,omitempty"`
+}
+
+// TableName returns the CSP reports table name
+func (r *CSPReport) TableName() string {
+	return "csp_reports"
+}
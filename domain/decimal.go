@@ -0,0 +1,63 @@
+package domain
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// Decimal is an exact decimal number for amounts of money and other values that lose precision as floats.
+// It is stored as numeric and serialized as a JSON string, e.g. "12.30", and numbers are accepted as input.
+type Decimal struct {
+	decimal.Decimal
+}
+
+// NewDecimal parses the decimal number, e.g. "12.30"
+func NewDecimal(value string) (Decimal, error) {
+	parsed, err := decimal.NewFromString(value)
+	if err != nil {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", value)
+	}
+	return Decimal{Decimal: parsed}, nil
+}
+
+// MustDecimal parses the decimal number and panics if it is not valid, e.g. for constants
+func MustDecimal(value string) Decimal {
+	parsed, err := NewDecimal(value)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// DecimalOf wraps the result of the arithmetic of the decimals, e.g. DecimalOf(price.Mul(quantity.Decimal))
+func DecimalOf(value decimal.Decimal) Decimal {
+	return Decimal{Decimal: value}
+}
+
+// UnmarshalJSON decodes strings and numbers, values that are not decimals are validation errors
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	err := d.Decimal.UnmarshalJSON(data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrValidation, err)
+	}
+	return nil
+}
+
+// GormDataType stores decimals in numeric columns, use the type tag for the precision, e.g. numeric(12,2)
+func (Decimal) GormDataType() string {
+	return "numeric"
+}
+
+// Check validates that the decimal has at most the given digits and digits after the decimal point,
+// like a numeric(precision, scale) column
+func (d Decimal) Check(precision, scale int) error {
+	if !d.Equal(d.Truncate(int32(scale))) {
+		return fmt.Errorf("%s has more than %d decimal places", d.String(), scale)
+	}
+	limit := decimal.New(1, int32(precision-scale))
+	if d.Abs().GreaterThanOrEqual(limit) {
+		return fmt.Errorf("%s has more than %d digits before the decimal point", d.String(), precision-scale)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// DeprecatedObject is implemented by resources that are replaced by others and will be removed, e.g. after a
+// new version of the model
+type DeprecatedObject interface {
+	Deprecation() Deprecation
+}
+
+// Deprecation announces the deprecation of a resource or route to the clients with the Deprecation, Sunset and
+// Link headers of the responses
+type Deprecation struct {
+	// Since is when the resource was deprecated
+	Since time.Time
+	// Sunset is when the resource is removed, zero when it is not planned yet
+	Sunset time.Time
+	// Link is the URL of the migration guide, e.g. to the replacing resource
+	Link string
+	// Gone rejects the requests after the sunset with 410 Gone instead of serving them
+	Gone bool
+}
+
+// Check validates that the deprecation has a date, and that the sunset and the link are valid
+func (d Deprecation) Check() error {
+	if d.Since.IsZero() {
+		return errors.New("deprecation requires the date of the deprecation")
+	}
+	if !d.Sunset.IsZero() && d.Sunset.Before(d.Since) {
+		return fmt.Errorf("deprecation sunset %s is before the deprecation %s", d.Sunset.Format(time.DateOnly), d.Since.Format(time.DateOnly))
+	}
+	if d.Gone && d.Sunset.IsZero() {
+		return errors.New("deprecation gone after the sunset requires the sunset")
+	}
+	if d.Link != "" {
+		link, err := url.Parse(d.Link)
+		if err != nil || !link.IsAbs() {
+			return fmt.Errorf("deprecation link must be an absolute URL, got %q", d.Link)
+		}
+	}
+	return nil
+}
+
+// DeprecationHeader returns the value of the Deprecation header, the date as seconds since the epoch, e.g. @1688169599
+func (d Deprecation) DeprecationHeader() string {
+	return fmt.Sprintf("@%d", d.Since.Unix())
+}
+
+// IsGone checks if the requests are rejected at the time
+func (d Deprecation) IsGone(now time.Time) bool {
+	return d.Gone && !now.Before(d.Sunset)
+}
@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// EditLock is the pessimistic lock of a user on an object, the other users cannot update the object until it is
+// released or expires
+type EditLock struct {
+	Resource string    `gorm:"primaryKey" json:"resource"`
+	ObjectID uuid.UUID `gorm:"primaryKey" json:"object_id"`
+	UserID   uuid.UUID `json:"user_id"`
+	// LockedAt is the time the lock was acquired or renewed
+	LockedAt  time.Time `json:"locked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TableName returns the edit locks table name
+func (l *EditLock) TableName() string {
+	return "edit_locks"
+}
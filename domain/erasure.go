@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	ERASURE_DELETE    = "delete"
+	ERASURE_ANONYMIZE = "anonymize"
+	ERASURE_RETAIN    = "retain"
+)
+
+// ErasableObject is implemented by objects that are not deleted when the data of their owner is erased, e.g.
+// invoices that must be kept for the tax authorities
+type ErasableObject interface {
+	Erasure() Erasure
+}
+
+// Erasure declares how the objects of a user are erased on request of the user, the objects without it are deleted
+type Erasure struct {
+	// Policy is ERASURE_DELETE, ERASURE_ANONYMIZE to clear the personal fields and keep the objects, or
+	// ERASURE_RETAIN to keep the objects as they are
+	Policy string
+	// Fields are the JSON names of the personal fields cleared by ERASURE_ANONYMIZE
+	Fields []string
+	// Reason is the legal ground of keeping the objects, recorded in the erasure reports
+	Reason string
+}
+
+// Check validates the policy, and the fields of the anonymized objects of the type
+func (e Erasure) Check(objectType reflect.Type) error {
+	switch e.Policy {
+	case ERASURE_DELETE, ERASURE_RETAIN:
+	case ERASURE_ANONYMIZE:
+		if len(e.Fields) == 0 {
+			return errors.New("erasure by anonymization requires the personal fields")
+		}
+		for _, field := range e.Fields {
+			if field == "user_id" {
+				return errors.New("erasure field user_id cannot be cleared, the objects keep their owner")
+			}
+			if _, ok := JSONField(objectType, field); !ok {
+				return fmt.Errorf("erasure field %s is not a field of %s", field, objectType)
+			}
+		}
+	default:
+		return fmt.Errorf("erasure policy must be %s, %s or %s, got %q", ERASURE_DELETE, ERASURE_ANONYMIZE, ERASURE_RETAIN, e.Policy)
+	}
+	if e.Policy == ERASURE_RETAIN && e.Reason == "" {
+		return errors.New("erasure by retention requires the reason")
+	}
+	return nil
+}
+
+// JSONField returns the field of the struct type with the JSON name, the fields of embedded structs are not
+// included
+func JSONField(objectType reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < objectType.NumField(); i++ {
+		field := objectType.Field(i)
+		if field.Anonymous || !field.IsExported() {
+			continue
+		}
+		if jsonName(field) == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// jsonName returns the JSON name of the struct field, its Go name without the json tag
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
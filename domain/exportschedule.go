@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// ExportSchedule is an export template of a user, the filters and the format of the export of a resource, which is
+// delivered on its schedule to the destination, e.g. a weekly report by email
+type ExportSchedule struct {
+	ID       uuid.UUID `gorm:"primaryKey" json:"id"`
+	Resource string    `gorm:"index" json:"resource"`
+	UserID   uuid.UUID `gorm:"index" json:"user_id"`
+	Name     string    `json:"name"`
+	// Parameters are the filters of the exported objects, as the query parameters of the lists
+	Parameters map[string][]string `gorm:"serializer:json;type:jsonb" json:"parameters"`
+	// Columns are the JSON names of the fields of the CSV exports, all fields when empty
+	Columns []string `gorm:"serializer:json;type:jsonb" json:"columns"`
+	Format  string   `json:"format"`
+	// Schedule is the cron expression of the deliveries, e.g. 0 6 * * 1
+	Schedule    string            `json:"schedule"`
+	Destination ExportDestination `gorm:"serializer:json;type:jsonb" json:"destination"`
+	Enabled     bool              `json:"enabled"`
+	// Roles are the roles of the token of the user when the schedule was saved, every export runs with their
+	// current permissions
+	Roles     []string   `gorm:"serializer:json" json:"-"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// ExportDestination is where the exports of a schedule are delivered, the recipients of the emails with their
+// download links or the path of the storage
+type ExportDestination struct {
+	Type string   `json:"type"`
+	To   []string `json:"to,omitempty"`
+	Path string   `json:"path,omitempty"`
+}
+
+// TableName returns the export schedules table name
+func (s *ExportSchedule) TableName() string {
+	return "export_schedules"
+}
+
+// ExportRun is a delivery of an export schedule, the history of the schedule
+type ExportRun struct {
+	ID         uuid.UUID `gorm:"primaryKey" json:"id"`
+	ScheduleID uuid.UUID `gorm:"index" json:"schedule_id"`
+	JobID      uuid.UUID `json:"job_id"`
+	Status     string    `json:"status"`
+	Objects    int64     `json:"objects"`
+	// Location is the storage key of the delivered export or the recipients of the email
+	Location   string     `json:"location,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// TableName returns the export runs table name
+func (r *ExportRun) TableName() string {
+	return "export_runs"
+}
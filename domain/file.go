@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	FILE_PENDING  = "pending"
+	FILE_UPLOADED = "uploaded"
+	// FILE_QUARANTINED is the status of the files in which the virus scanner found a threat, they are not downloadable
+	FILE_QUARANTINED = "quarantined"
+)
+
+// File holds the metadata of an attachment, the content is kept in the configured storage
+type File struct {
+	Base
+	UserID      uuid.UUID `json:"user_id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Status      string    `json:"status"`
+	StorageKey  string    `json:"-"`
+}
+
+func (f *File) ResourceName() string {
+	return "file"
+}
+
+// SetUserID sets the owner of the file
+func (f *File) SetUserID(uid uuid.UUID) {
+	f.UserID = uid
+}
+
+// KeepManaged keeps the status and the size of the uploaded content, they are set by the uploads only
+func (f *File) KeepManaged(previous Object) {
+	if file, ok := previous.(*File); ok {
+		f.Status = file.Status
+		f.Size = file.Size
+	}
+}
+
+// Validate checks structure consistency
+func (f *File) Validate(ctx context.Context) error {
+	if f.Name == "" {
+		return fmt.Errorf("file name is required")
+	}
+	return nil
+}
+
+func (f *File) Prepare(ctx context.Context) error {
+	err := f.BasePrepare(ctx)
+	if err != nil {
+		return err
+	}
+	if f.StorageKey == "" {
+		f.StorageKey = fmt.Sprintf("files/%s", f.ID)
+	}
+	f.Status = FILE_PENDING
+	f.Size = 0
+	return nil
+}
@@ -6,4 +6,6 @@ type List struct {
 	Page     int      `json:"page,omitempty"`
 	Count    int64    `json:"count"`
 	Data     []Object `json:"data"`
+	// Meta are the summaries of the list, e.g. its aggregates
+	Meta map[string]any `json:"meta,omitempty"`
 }
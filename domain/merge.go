@@ -0,0 +1,119 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+const (
+	MERGE_FILL   = "fill"
+	MERGE_TARGET = "target"
+	MERGE_SOURCE = "source"
+	MERGE_UNION  = "union"
+)
+
+// MergeableObject is implemented by objects whose duplicates are merged through the API, e.g. the contacts of a CRM
+type MergeableObject interface {
+	Merge() Merge
+}
+
+// Merge declares how a duplicate object, the source, is merged into the object that is kept, the target
+type Merge struct {
+	// Fields are the rules of the fields by JSON name, the other fields use MERGE_FILL. MERGE_FILL keeps the value
+	// of the target and takes the one of the source when the target has none, MERGE_TARGET always keeps the value
+	// of the target, MERGE_SOURCE takes the value of the source when it has one and MERGE_UNION adds the elements
+	// of the source to the array of the target.
+	Fields map[string]string
+	// Relations are the fields of other resources that refer to the objects, they are moved to the target
+	Relations []Relation
+}
+
+// Relation is a field of a resource that holds the ID of an object of another resource, e.g. the contact_id of deals
+type Relation struct {
+	Resource string
+	// Field is the JSON name of the field
+	Field string
+}
+
+// Check validates the rules of the fields of the type
+func (m Merge) Check(objectType reflect.Type) error {
+	err := CheckMergeRules(objectType, m.Fields)
+	if err != nil {
+		return err
+	}
+	for _, relation := range m.Relations {
+		if relation.Resource == "" || relation.Field == "" {
+			return errors.New("merge relation requires the resource and the field")
+		}
+	}
+	return nil
+}
+
+// CheckMergeRules validates the merge rules of the fields of the type, e.g. given by a merge request
+func CheckMergeRules(objectType reflect.Type, rules map[string]string) error {
+	for name, rule := range rules {
+		field, ok := JSONField(objectType, name)
+		if !ok || name == "user_id" {
+			return fmt.Errorf("merge field %s is not a field of %s", name, objectType)
+		}
+		switch rule {
+		case MERGE_FILL, MERGE_TARGET, MERGE_SOURCE:
+		case MERGE_UNION:
+			if field.Type.Kind() != reflect.Slice {
+				return fmt.Errorf("merge field %s is not an array, it cannot be merged by %s", name, MERGE_UNION)
+			}
+		default:
+			return fmt.Errorf("merge rule of %s must be %s, %s, %s or %s, got %q", name, MERGE_FILL, MERGE_TARGET, MERGE_SOURCE, MERGE_UNION, rule)
+		}
+	}
+	return nil
+}
+
+// MergeFields merges the fields of the source into the target with the rules by JSON name, the fields without rule
+// use MERGE_FILL. The fields of embedded structs, e.g. of Base, and the owner of the target are kept.
+func MergeFields(target, source Object, rules map[string]string) {
+	targetValue := reflect.ValueOf(target).Elem()
+	sourceValue := reflect.ValueOf(source).Elem()
+	objectType := targetValue.Type()
+	for i := 0; i < objectType.NumField(); i++ {
+		field := objectType.Field(i)
+		if field.Anonymous || !field.IsExported() {
+			continue
+		}
+		name := jsonName(field)
+		if name == "-" || name == "user_id" {
+			continue
+		}
+		to, from := targetValue.Field(i), sourceValue.Field(i)
+		switch rules[name] {
+		case MERGE_TARGET:
+		case MERGE_SOURCE:
+			if !from.IsZero() {
+				to.Set(from)
+			}
+		case MERGE_UNION:
+			union := to
+			for j := 0; j < from.Len(); j++ {
+				if !containsElement(union, from.Index(j)) {
+					union = reflect.Append(union, from.Index(j))
+				}
+			}
+			to.Set(union)
+		default:
+			if to.IsZero() {
+				to.Set(from)
+			}
+		}
+	}
+}
+
+// containsElement checks if the slice contains the element
+func containsElement(slice, element reflect.Value) bool {
+	for i := 0; i < slice.Len(); i++ {
+		if reflect.DeepEqual(slice.Index(i).Interface(), element.Interface()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// Types of the notifications delivered by the server, the applications add their own, e.g. for their events
+const (
+	NOTIFICATION_MENTION = "mention"
+	NOTIFICATION_SHARE   = "share"
+)
+
+// Notification is a message of the inbox of a user, e.g. that another user mentioned them in a comment
+type Notification struct {
+	ID     uuid.UUID `gorm:"primaryKey" json:"id"`
+	UserID uuid.UUID `gorm:"index" json:"user_id"`
+	Type   string    `json:"type"`
+	// Resource and ObjectID are the object the notification is about, if any
+	Resource string     `json:"resource,omitempty"`
+	ObjectID *uuid.UUID `json:"object_id,omitempty"`
+	// ActorID is the user that caused the notification, if any
+	ActorID   *uuid.UUID `json:"actor_id,omitempty"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	// ReadAt is when the user read the notification, nil while it is unread
+	ReadAt *time.Time `json:"read_at,omitempty"`
+}
+
+// TableName returns the notifications table name
+func (n *Notification) TableName() string {
+	return "notifications"
+}
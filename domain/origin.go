@@ -0,0 +1,29 @@
+package domain
+
+// Origin holds the deployment region and labels of the instance that created an object,
+// models embed it to let deployments in multiple regions reconcile data origins
+type Origin struct {
+	Region string            `json:"region,omitempty" gorm:"index"`
+	Labels map[string]string `json:"labels,omitempty" gorm:"type:jsonb;serializer:json"`
+}
+
+// OriginObject is an abstraction of objects stamped with their origin
+type OriginObject interface {
+	GetOrigin() Origin
+	SetOrigin(Origin)
+}
+
+// GetOrigin returns the origin
+func (o *Origin) GetOrigin() Origin {
+	return *o
+}
+
+// SetOrigin sets the origin
+func (o *Origin) SetOrigin(origin Origin) {
+	*o = origin
+}
+
+// IsEmpty checks if neither region nor labels are set
+func (o Origin) IsEmpty() bool {
+	return o.Region == "" && len(o.Labels) == 0
+}
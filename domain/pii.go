@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	PII_PERSONAL  = "personal"
+	PII_SENSITIVE = "sensitive"
+
+	// PII_MASK replaces the masked strings, the other masked values are cleared
+	PII_MASK = "***"
+)
+
+// PIIField is a field of personal data, tagged with its classification, e.g. `pii:"personal"` for names and
+// emails or `pii:"sensitive"` for health or financial data
+type PIIField struct {
+	Name  string
+	JSON  string
+	Level string
+}
+
+// PIIFields returns the tagged fields of the struct type, the fields of embedded structs are not included
+func PIIFields(objectType reflect.Type) ([]PIIField, error) {
+	var fields []PIIField
+	for i := 0; i < objectType.NumField(); i++ {
+		field := objectType.Field(i)
+		level, ok := field.Tag.Lookup("pii")
+		if !ok || field.Anonymous || !field.IsExported() {
+			continue
+		}
+		if level != PII_PERSONAL && level != PII_SENSITIVE {
+			return nil, fmt.Errorf("pii level of field %s must be %s or %s, got %q", field.Name, PII_PERSONAL, PII_SENSITIVE, level)
+		}
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+		if jsonName == "id" || jsonName == "user_id" {
+			return nil, fmt.Errorf("field %s identifies the object and cannot be masked", field.Name)
+		}
+		fields = append(fields, PIIField{Name: field.Name, JSON: jsonName, Level: level})
+	}
+	return fields, nil
+}
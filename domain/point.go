@@ -0,0 +1,198 @@
+package domain
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// earthRadius is the mean radius of the Earth in meters
+const earthRadius = 6371008.8
+
+// ewkbSRID is the flag of the geometry type of EWKB values that are followed by their SRID
+const ewkbSRID = 0x20000000
+
+// Point is a location in WGS 84 coordinates, stored in a PostGIS geography(Point,4326) column and serialized
+// as a GeoJSON point, e.g. {"type":"Point","coordinates":[23.32,42.69]} with the longitude first
+type Point struct {
+	Lat float64
+	Lng float64
+}
+
+// NewPoint creates the point and validates its coordinates
+func NewPoint(lat, lng float64) (Point, error) {
+	point := Point{Lat: lat, Lng: lng}
+	return point, point.Check()
+}
+
+// Check validates that the latitude is between -90 and 90 and the longitude between -180 and 180
+func (p Point) Check() error {
+	if math.IsNaN(p.Lat) || p.Lat < -90 || p.Lat > 90 {
+		return fmt.Errorf("latitude %v is not between -90 and 90", p.Lat)
+	}
+	if math.IsNaN(p.Lng) || p.Lng < -180 || p.Lng > 180 {
+		return fmt.Errorf("longitude %v is not between -180 and 180", p.Lng)
+	}
+	return nil
+}
+
+// Distance returns the great-circle distance to the other point in meters
+func (p Point) Distance(other Point) float64 {
+	lat1, lat2 := p.Lat*math.Pi/180, other.Lat*math.Pi/180
+	dLat, dLng := lat2-lat1, (other.Lng-p.Lng)*math.Pi/180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// geoJSONPoint is the GeoJSON representation of the points
+type geoJSONPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// MarshalJSON encodes the point as a GeoJSON point
+func (p Point) MarshalJSON() ([]byte, error) {
+	return json.Marshal(geoJSONPoint{Type: "Point", Coordinates: []float64{p.Lng, p.Lat}})
+}
+
+// UnmarshalJSON decodes GeoJSON points, other geometries and invalid coordinates are validation errors
+func (p *Point) UnmarshalJSON(data []byte) error {
+	var point geoJSONPoint
+	err := json.Unmarshal(data, &point)
+	if err != nil {
+		return fmt.Errorf("%w: invalid GeoJSON point: %w", ErrValidation, err)
+	}
+	if point.Type != "Point" || len(point.Coordinates) < 2 {
+		return fmt.Errorf("%w: a GeoJSON point with [longitude, latitude] coordinates is expected", ErrValidation)
+	}
+	parsed := Point{Lat: point.Coordinates[1], Lng: point.Coordinates[0]}
+	err = parsed.Check()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrValidation, err)
+	}
+	*p = parsed
+	return nil
+}
+
+// GormDBDataType stores points in PostGIS geography columns, distances of geographies are in meters.
+// Other databases, e.g. SQLite of the tests and the development mode, keep them as EWKT text.
+func (Point) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == "postgres" {
+		return "geography(Point,4326)"
+	}
+	return "text"
+}
+
+// Value stores the point as EWKT, e.g. SRID=4326;POINT(23.32 42.69)
+func (p Point) Value() (driver.Value, error) {
+	return "SRID=4326;POINT(" + formatCoordinate(p.Lng) + " " + formatCoordinate(p.Lat) + ")", nil
+}
+
+// Scan loads points returned as hex encoded EWKB, which is the text format of PostGIS, as WKB or as (E)WKT
+func (p *Point) Scan(value any) error {
+	var data []byte
+	switch typed := value.(type) {
+	case []byte:
+		data = typed
+	case string:
+		data = []byte(typed)
+	default:
+		return fmt.Errorf("cannot scan %T into a point", value)
+	}
+	text := strings.TrimSpace(string(data))
+	switch {
+	case strings.HasPrefix(strings.ToUpper(text), "SRID=") || strings.HasPrefix(strings.ToUpper(text), "POINT"):
+		return p.parseWKT(text)
+	case len(text) > 0 && isHex(text):
+		decoded, err := hex.DecodeString(text)
+		if err != nil {
+			return err
+		}
+		data = decoded
+	}
+	return p.parseWKB(data)
+}
+
+// parseWKT parses points like SRID=4326;POINT(23.32 42.69)
+func (p *Point) parseWKT(text string) error {
+	if _, rest, found := strings.Cut(text, ";"); found {
+		text = rest
+	}
+	start, end := strings.Index(text, "("), strings.LastIndex(text, ")")
+	if start < 0 || end < start {
+		return fmt.Errorf("invalid point %q", text)
+	}
+	coordinates := strings.Fields(text[start+1 : end])
+	if len(coordinates) < 2 {
+		return fmt.Errorf("invalid point %q", text)
+	}
+	lng, err := strconv.ParseFloat(coordinates[0], 64)
+	if err != nil {
+		return fmt.Errorf("invalid point %q: %w", text, err)
+	}
+	lat, err := strconv.ParseFloat(coordinates[1], 64)
+	if err != nil {
+		return fmt.Errorf("invalid point %q: %w", text, err)
+	}
+	*p = Point{Lat: lat, Lng: lng}
+	return nil
+}
+
+// parseWKB parses points in the well-known binary format, with the SRID of EWKB or without it
+func (p *Point) parseWKB(data []byte) error {
+	if len(data) < 5 {
+		return errors.New("invalid point: too short")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 0 {
+		order = binary.BigEndian
+	}
+	reader := bytes.NewReader(data[1:])
+	var geometryType uint32
+	err := binary.Read(reader, order, &geometryType)
+	if err != nil {
+		return fmt.Errorf("invalid point: %w", err)
+	}
+	if geometryType&ewkbSRID != 0 {
+		var srid uint32
+		err = binary.Read(reader, order, &srid)
+		if err != nil {
+			return fmt.Errorf("invalid point: %w", err)
+		}
+	}
+	if geometryType&0xff != 1 {
+		return fmt.Errorf("invalid point: geometry type %d is not a point", geometryType&0xff)
+	}
+	var coordinates [2]float64
+	err = binary.Read(reader, order, &coordinates)
+	if err != nil {
+		return fmt.Errorf("invalid point: %w", err)
+	}
+	*p = Point{Lat: coordinates[1], Lng: coordinates[0]}
+	return nil
+}
+
+// formatCoordinate formats the coordinate without losing precision
+func formatCoordinate(coordinate float64) string {
+	return strconv.FormatFloat(coordinate, 'f', -1, 64)
+}
+
+// isHex checks that the text has only hexadecimal digits
+func isHex(text string) bool {
+	for _, r := range text {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
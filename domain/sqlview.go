@@ -0,0 +1,31 @@
+package domain
+
+import "errors"
+
+// SQLViewObject is implemented by read-only resources backed by SQL views instead of tables, e.g. the reporting
+// shapes of the other resources
+type SQLViewObject interface {
+	SQLView() SQLView
+}
+
+// SQLView declares the SQL view of a read-only resource, the migrations of the application create the view with the
+// name of the table of the model
+type SQLView struct {
+	// Materialized views keep the results of their query until they are refreshed
+	Materialized bool
+	// Refresh is the schedule of the refreshes of a materialized view, e.g. @every 15m or 0 * * * *, empty refreshes
+	// only on the requests
+	Refresh string
+	// Concurrently refreshes without blocking the reads of the view, the view requires a unique index
+	Concurrently bool
+	// Query is the SELECT of the view, the development mode and the test harness create the view with it
+	Query string
+}
+
+// Check validates that only the materialized views are refreshed
+func (v SQLView) Check() error {
+	if !v.Materialized && (v.Refresh != "" || v.Concurrently) {
+		return errors.New("only materialized views are refreshed")
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TimestampPrecision is the precision of the stored and serialized timestamps, e.g. time.Millisecond
+var TimestampPrecision = time.Microsecond
+
+// timestampLayouts are the accepted input formats, timestamps without offset are in UTC
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.DateOnly,
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Timestamp converts the time to UTC with the timestamp precision
+func Timestamp(t time.Time) time.Time {
+	t = t.UTC()
+	if TimestampPrecision > 0 {
+		t = t.Truncate(TimestampPrecision)
+	}
+	return t
+}
+
+// Now returns the current timestamp, the database sessions use it for the timestamps they set
+func Now() time.Time {
+	return Timestamp(time.Now())
+}
+
+// ParseTimestamp parses RFC 3339 timestamps, timestamps with a space instead of T, without offset, RFC 1123 dates
+// and dates without time, and returns them in UTC
+func ParseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range timestampLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return Timestamp(t), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q, expected RFC 3339 like %s", value, time.RFC3339)
+}
+
+// normalizeTimestamps converts the timestamps of the object to UTC with the timestamp precision
+func (b *Base) normalizeTimestamps() {
+	for _, t := range []*time.Time{b.CreatedAt, b.UpdatedAt} {
+		if t != nil {
+			*t = Timestamp(*t)
+		}
+	}
+}
+
+// AfterFind converts the loaded timestamps to UTC, whatever the time zone of the database
+func (b *Base) AfterFind(tx *gorm.DB) error {
+	b.normalizeTimestamps()
+	return nil
+}
+
+// BeforeSave converts the timestamps to UTC before they are stored
+func (b *Base) BeforeSave(tx *gorm.DB) error {
+	b.normalizeTimestamps()
+	return nil
+}
+
+// NormalizeTimestamps rewrites the values of the time fields of the JSON object that are given in the accepted
+// input formats or as Unix seconds to RFC 3339 in UTC, so that they can be decoded into the object. Values that
+// are not timestamps are validation errors, other fields and invalid JSON are left to the decoding.
+func NormalizeTimestamps(data []byte, object any) ([]byte, error) {
+	names := timeFields(reflect.TypeOf(object))
+	if len(names) == 0 {
+		return data, nil
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return data, nil
+	}
+	changed := false
+	for _, name := range names {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		t, ok, err := parseRawTimestamp(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrValidation, name, err)
+		}
+		if !ok {
+			continue
+		}
+		fields[name], err = json.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(fields)
+}
+
+// parseRawTimestamp parses a JSON string or Unix seconds, null is not a timestamp
+func parseRawTimestamp(raw json.RawMessage) (time.Time, bool, error) {
+	var value any
+	err := json.Unmarshal(raw, &value)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	switch typed := value.(type) {
+	case nil:
+		return time.Time{}, false, nil
+	case string:
+		t, err := ParseTimestamp(typed)
+		return t, err == nil, err
+	case float64:
+		return Timestamp(time.Unix(0, int64(typed*float64(time.Second)))), true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid timestamp %s", raw)
+}
+
+// timeFields returns the JSON names of the time fields of the struct, including the ones of embedded structs
+func timeFields(t reflect.Type) []string {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		switch {
+		case fieldType == timeType:
+			if name == "" {
+				name = field.Name
+			}
+			names = append(names, name)
+		case field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct:
+			names = append(names, timeFields(fieldType)...)
+		}
+	}
+	return names
+}
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// SERIALIZATION_FAILURE is the SQLSTATE of the transactions aborted by the database in favor of concurrent ones.
+// CockroachDB runs the transactions serializable and aborts the conflicting ones instead of making them wait.
+const SERIALIZATION_FAILURE = "40001"
+
+// retryBackoff is the wait before the first retry of a transaction, it doubles with each further retry
+const retryBackoff = 10 * time.Millisecond
+
+// TransactionRetries is how many times Transaction runs the transactions aborted by serialization failures again
+var TransactionRetries = 0
+
+// Transaction runs the function in a transaction of the database and runs it again in a new transaction when the
+// database aborted it with a serialization failure, up to TransactionRetries times. The function must start from
+// scratch on each call, e.g. reset the results of previous attempts. Transactions nested in a transaction are
+// retried with the outer one.
+func Transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if committer, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok && committer != nil {
+		return db.Transaction(fn)
+	}
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := db.Transaction(fn)
+		if attempt >= TransactionRetries || !IsSerializationFailure(err) {
+			return err
+		}
+		slog.Debug("Retrying transaction after serialization failure", "attempt", attempt+1, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// IsSerializationFailure checks if the transaction was aborted by the database and can be retried
+func IsSerializationFailure(err error) bool {
+	var pgError *pgconn.PgError
+	return errors.As(err, &pgError) && pgError.Code == SERIALIZATION_FAILURE
+}
@@ -9,6 +9,14 @@ type User struct {
 	GivenName        string `json:"given_name"`
 	FamilyName       string `json:"family_name"`
 	Email            string `json:"email"`
+	// ExternalID is the ID of the user at the identity provider that provisions it with SCIM
+	ExternalID string `json:"external_id,omitempty"`
+	// Deactivated users are not authenticated, e.g. the users deprovisioned with SCIM
+	Deactivated bool `json:"deactivated,omitempty"`
+	// Pending users are provisioned with SCIM and are linked to their Keycloak subject at their first login
+	Pending bool `json:"pending,omitempty"`
+	// SCIMID is the ID given to the identity provider at the provisioning, it is kept when the user is linked
+	SCIMID string `gorm:"column:scim_id;index" json:"scim_id,omitempty"`
 }
 
 func (u *User) ResourceName() string {
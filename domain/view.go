@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// View is a saved list view of a user, the parameters of the list requests of a resource and the columns of its
+// objects, so that the clients do not build complex queries again. Shared views are seen by all users of the tenant.
+type View struct {
+	ID       uuid.UUID `gorm:"primaryKey" json:"id"`
+	Resource string    `gorm:"index" json:"resource"`
+	UserID   uuid.UUID `json:"user_id"`
+	Tenant   string    `json:"tenant,omitempty"`
+	Name     string    `json:"name"`
+	// Parameters are the query parameters of the list, e.g. the filters and the page size
+	Parameters map[string][]string `gorm:"serializer:json;type:jsonb" json:"parameters"`
+	// Columns are the JSON names of the fields of the listed objects, all fields when empty
+	Columns []string `gorm:"serializer:json;type:jsonb" json:"columns"`
+	// Settings are the settings of the clients, e.g. the sort and the widths of the columns, kept as they are
+	Settings  map[string]any `gorm:"serializer:json;type:jsonb" json:"settings,omitempty"`
+	Shared    bool           `json:"shared"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// TableName returns the views table name
+func (v *View) TableName() string {
+	return "views"
+}
@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	WEBHOOK_FAIL   = "fail"
+	WEBHOOK_IGNORE = "ignore"
+)
+
+// ErrWebhook is wrapped by the errors of webhooks that cannot be called or fail
+var ErrWebhook = errors.New("webhook failed")
+
+// WebhookObject is implemented by objects that another system validates or enriches before they are created
+// or updated, e.g. when it is the source of truth for their validity
+type WebhookObject interface {
+	Webhook() Webhook
+}
+
+// Webhook is the external API called synchronously with the objects before they are persisted
+type Webhook struct {
+	// URL receives the objects with POST, e.g. https://erp.example.com/hooks/order
+	URL string
+	// Timeout limits the call, 0 uses 5 seconds
+	Timeout time.Duration
+	// FailurePolicy is WEBHOOK_FAIL to reject the objects when the webhook cannot be called or fails, which is the
+	// default, or WEBHOOK_IGNORE to persist them as they are
+	FailurePolicy string
+}
+
+// Check validates the URL, the timeout and the failure policy
+func (w Webhook) Check() error {
+	parsed, err := url.Parse(w.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("webhook URL %q is not an http or https URL", w.URL)
+	}
+	if w.Timeout < 0 {
+		return fmt.Errorf("webhook timeout must not be negative, got %s", w.Timeout)
+	}
+	if w.FailurePolicy != "" && w.FailurePolicy != WEBHOOK_FAIL && w.FailurePolicy != WEBHOOK_IGNORE {
+		return fmt.Errorf("webhook failure policy must be %s or %s, got %q", WEBHOOK_FAIL, WEBHOOK_IGNORE, w.FailurePolicy)
+	}
+	return nil
+}
+
+// Ignores checks if the objects are persisted when the webhook cannot be called or fails
+func (w Webhook) Ignores() bool {
+	return w.FailurePolicy == WEBHOOK_IGNORE
+}
@@ -0,0 +1,167 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// AMQPPublisher publishes events to an AMQP exchange
+type AMQPPublisher struct {
+	Config     cfg.AMQP
+	Encoder    Encoder
+	mutex      sync.RWMutex
+	connection *amqp.Connection
+	channel    *amqp.Channel
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewAMQPPublisher connects to the broker and declares the configured exchange, events are encoded as JSON
+// unless another Encoder is set
+func NewAMQPPublisher(config cfg.AMQP) (*AMQPPublisher, error) {
+	publisher := &AMQPPublisher{
+		Config:  config,
+		Encoder: JSONEncoder{},
+		done:    make(chan struct{}),
+	}
+	err := publisher.connect()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to amqp broker: %w", err)
+	}
+	go publisher.recover()
+	slog.Info("AMQP publisher initialized", "exchange", config.Exchange, "type", config.ExchangeType, "confirms", config.Confirms)
+	return publisher, nil
+}
+
+// connect opens a connection and a channel and declares the exchange
+func (publisher *AMQPPublisher) connect() error {
+	connection, err := amqp.Dial(publisher.Config.URL)
+	if err != nil {
+		return err
+	}
+	channel, err := connection.Channel()
+	if err != nil {
+		connection.Close()
+		return err
+	}
+	err = channel.ExchangeDeclare(publisher.Config.Exchange, publisher.Config.ExchangeType, true, false, false, false, nil)
+	if err != nil {
+		connection.Close()
+		return err
+	}
+	if publisher.Config.Confirms {
+		err = channel.Confirm(false)
+		if err != nil {
+			connection.Close()
+			return err
+		}
+	}
+
+	publisher.mutex.Lock()
+	publisher.connection = connection
+	publisher.channel = channel
+	publisher.mutex.Unlock()
+	return nil
+}
+
+// recover waits for connection loss and reconnects until the publisher is closed
+func (publisher *AMQPPublisher) recover() {
+	for {
+		publisher.mutex.RLock()
+		connection := publisher.connection
+		publisher.mutex.RUnlock()
+		closed := connection.NotifyClose(make(chan *amqp.Error, 1))
+
+		select {
+		case <-publisher.done:
+			return
+		case amqpErr := <-closed:
+			slog.Error("AMQP connection lost", "error", amqpErr)
+		}
+
+		for {
+			select {
+			case <-publisher.done:
+				return
+			case <-time.After(publisher.Config.ReconnectDelay):
+			}
+			err := publisher.connect()
+			if err == nil {
+				slog.Info("AMQP connection recovered", "exchange", publisher.Config.Exchange)
+				break
+			}
+			slog.Error("AMQP reconnect failed", "error", err)
+		}
+	}
+}
+
+// Publish sends the event to the exchange and waits for broker confirmation if enabled
+func (publisher *AMQPPublisher) Publish(ctx context.Context, event Event) error {
+	contentType, body, err := publisher.Encoder.Encode(event)
+	if err != nil {
+		return err
+	}
+	message := amqp.Publishing{
+		ContentType:  contentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    event.ID.String(),
+		Timestamp:    event.Time,
+		Type:         event.Type,
+		Body:         body,
+	}
+	routingKey := publisher.routingKey(event)
+
+	publisher.mutex.RLock()
+	channel := publisher.channel
+	publisher.mutex.RUnlock()
+	if channel == nil || channel.IsClosed() {
+		return errors.New("amqp publisher is not connected")
+	}
+
+	if !publisher.Config.Confirms {
+		return channel.PublishWithContext(ctx, publisher.Config.Exchange, routingKey, false, false, message)
+	}
+
+	confirmation, err := channel.PublishWithDeferredConfirmWithContext(ctx, publisher.Config.Exchange, routingKey, false, false, message)
+	if err != nil {
+		return err
+	}
+	confirmCtx, cancel := context.WithTimeout(ctx, publisher.Config.ConfirmTimeout)
+	defer cancel()
+	acked, err := confirmation.WaitContext(confirmCtx)
+	if err != nil {
+		return fmt.Errorf("amqp publish confirmation failed: %w", err)
+	}
+	if !acked {
+		return fmt.Errorf("amqp broker rejected event %s", event.ID)
+	}
+	return nil
+}
+
+// Close stops connection recovery and closes the connection
+func (publisher *AMQPPublisher) Close() error {
+	var err error
+	publisher.closeOnce.Do(func() {
+		close(publisher.done)
+		publisher.mutex.Lock()
+		defer publisher.mutex.Unlock()
+		if publisher.connection != nil {
+			err = publisher.connection.Close()
+		}
+	})
+	return err
+}
+
+// routingKey resolves the configured routing key template for the event
+func (publisher *AMQPPublisher) routingKey(event Event) string {
+	replacer := strings.NewReplacer("{resource}", event.Resource, "{action}", event.Action, "{type}", event.Type)
+	return replacer.Replace(publisher.Config.RoutingKey)
+}
@@ -0,0 +1,112 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// Broker fans out published events to in-process subscribers
+type Broker struct {
+	BufferSize  int
+	mutex       sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events accepted by its filter
+type Subscription struct {
+	Events chan Event
+	filter func(Event) bool
+	broker *Broker
+	once   sync.Once
+}
+
+// NewBroker creates a broker, each subscription buffers up to bufferSize events
+func NewBroker(bufferSize int) *Broker {
+	return &Broker{
+		BufferSize:  bufferSize,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Subscribe registers a new subscription that receives events accepted by the filter
+func (broker *Broker) Subscribe(filter func(Event) bool) *Subscription {
+	subscription := &Subscription{
+		Events: make(chan Event, broker.BufferSize),
+		filter: filter,
+		broker: broker,
+	}
+	broker.mutex.Lock()
+	broker.subscribers[subscription] = struct{}{}
+	broker.mutex.Unlock()
+	return subscription
+}
+
+// Close removes the subscription from the broker and closes its channel
+func (subscription *Subscription) Close() {
+	subscription.once.Do(func() {
+		subscription.broker.mutex.Lock()
+		delete(subscription.broker.subscribers, subscription)
+		subscription.broker.mutex.Unlock()
+		close(subscription.Events)
+	})
+}
+
+// Publish delivers the event to all matching subscribers, slow subscribers lose the event
+func (broker *Broker) Publish(ctx context.Context, event Event) error {
+	broker.mutex.RLock()
+	defer broker.mutex.RUnlock()
+	for subscription := range broker.subscribers {
+		if subscription.filter != nil && !subscription.filter(event) {
+			continue
+		}
+		select {
+		case subscription.Events <- event:
+		default:
+			slog.Warn("Subscriber buffer is full, event dropped", "event", event.ID, "type", event.Type)
+		}
+	}
+	return nil
+}
+
+// Close closes all subscriptions
+func (broker *Broker) Close() error {
+	broker.mutex.RLock()
+	subscriptions := make([]*Subscription, 0, len(broker.subscribers))
+	for subscription := range broker.subscribers {
+		subscriptions = append(subscriptions, subscription)
+	}
+	broker.mutex.RUnlock()
+	for _, subscription := range subscriptions {
+		subscription.Close()
+	}
+	return nil
+}
+
+// MultiPublisher publishes every event to all contained publishers
+type MultiPublisher []Publisher
+
+// Publish sends the event to all publishers and returns the joined errors
+func (publishers MultiPublisher) Publish(ctx context.Context, event Event) error {
+	var errs []error
+	for _, publisher := range publishers {
+		err := publisher.Publish(ctx, event)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes all publishers and returns the joined errors
+func (publishers MultiPublisher) Close() error {
+	var errs []error
+	for _, publisher := range publishers {
+		err := publisher.Close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,105 @@
+package events
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// ErrInvalidCursor is returned for cursors not issued by Changes
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Change is a reference to a mutated object
+type Change struct {
+	ID       uuid.UUID `json:"id"`
+	Action   string    `json:"action"`
+	Resource string    `json:"resource"`
+	ObjectID uuid.UUID `json:"object_id"`
+	Time     time.Time `json:"time"`
+}
+
+// ChangeList is a page of changes in mutation order, the cursor continues after the last change
+type ChangeList struct {
+	Data    []Change `json:"data"`
+	Cursor  string   `json:"cursor"`
+	HasMore bool     `json:"has_more"`
+}
+
+// Changes returns up to limit changes of the resource after the cursor, from the outbox entries that are not cleaned up yet.
+// Entries younger than the changes delay are not returned, so that transactions committing late do not get skipped.
+// If owner is set, only changes of objects owned by that user are returned.
+func (outbox *Outbox) Changes(ctx context.Context, resource string, owner *uuid.UUID, cursor string, limit int) (*ChangeList, error) {
+	after, afterID, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	query := outbox.DB.WithContext(ctx).
+		Where("payload->>'resource' = ?", resource).
+		Where("(created_at, id) > (?, ?)", after, afterID).
+		Where("created_at < ?", time.Now().UTC().Add(-outbox.Config.ChangesDelay))
+	if owner != nil {
+		query = query.Where("payload->'data'->>'user_id' = ?", owner.String())
+	}
+	var entries []OutboxEntry
+	err = query.Order("created_at, id").Limit(limit + 1).Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	list := &ChangeList{Data: []Change{}, Cursor: cursor}
+	if len(entries) > limit {
+		list.HasMore = true
+		entries = entries[:limit]
+	}
+	for _, entry := range entries {
+		var event Event
+		err = json.Unmarshal([]byte(entry.Payload), &event)
+		if err != nil {
+			return nil, err
+		}
+		list.Data = append(list.Data, Change{
+			ID:       event.ID,
+			Action:   event.Action,
+			Resource: event.Resource,
+			ObjectID: event.ObjectID,
+			Time:     entry.CreatedAt,
+		})
+		list.Cursor = encodeCursor(entry.CreatedAt, entry.ID)
+	}
+	return list, nil
+}
+
+// encodeCursor encodes the position of an entry
+func encodeCursor(createdAt time.Time, id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%s|%s", createdAt.UTC().Format(time.RFC3339Nano), id))
+}
+
+// decodeCursor decodes the position of an entry, the empty cursor is the beginning
+func decodeCursor(cursor string) (time.Time, uuid.UUID, error) {
+	if cursor == "" {
+		return time.Time{}, uuid.Nil, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	createdAt, id, ok := strings.Cut(string(decoded), "|")
+	if !ok {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	after, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Time{}, uuid.Nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	afterID, err := uuid.FromString(id)
+	if err != nil {
+		return time.Time{}, uuid.Nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	return after, afterID, nil
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+)
+
+const (
+	JSON        = "json"
+	CLOUDEVENTS = "cloudevents"
+)
+
+// Encoder serialises events for transports
+type Encoder interface {
+	Encode(event Event) (contentType string, body []byte, err error)
+}
+
+// NewEncoder creates the encoder for the configured format
+func NewEncoder(config cfg.Events) Encoder {
+	if config.Format == CLOUDEVENTS {
+		return CloudEventsEncoder{Source: config.Source, TypePrefix: config.TypePrefix}
+	}
+	return JSONEncoder{}
+}
+
+// JSONEncoder serialises events as they are
+type JSONEncoder struct{}
+
+// Encode returns the JSON representation of the event
+func (e JSONEncoder) Encode(event Event) (string, []byte, error) {
+	body, err := json.Marshal(event)
+	return "application/json", body, err
+}
+
+// CloudEvent is the structured mode representation of an event as defined by CloudEvents 1.0
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	Resource        string          `json:"resource"`
+	Action          string          `json:"action"`
+	UserID          string          `json:"userid,omitempty"`
+	Region          string          `json:"region,omitempty"`
+}
+
+// CloudEventsEncoder serialises events in CloudEvents structured content mode
+type CloudEventsEncoder struct {
+	Source     string
+	TypePrefix string
+}
+
+// Encode returns the CloudEvents JSON representation of the event
+func (e CloudEventsEncoder) Encode(event Event) (string, []byte, error) {
+	body, err := json.Marshal(e.CloudEvent(event))
+	return "application/cloudevents+json", body, err
+}
+
+// CloudEvent converts the event, resource, action, user and region are kept as extension attributes
+func (e CloudEventsEncoder) CloudEvent(event Event) CloudEvent {
+	cloudEvent := CloudEvent{
+		SpecVersion: "1.0",
+		ID:          event.ID.String(),
+		Source:      e.Source,
+		Type:        e.TypePrefix + event.Type,
+		Subject:     event.ObjectID.String(),
+		Time:        event.Time,
+		Data:        event.Data,
+		Resource:    event.Resource,
+		Action:      event.Action,
+		Region:      event.Region,
+	}
+	if len(event.Data) > 0 {
+		cloudEvent.DataContentType = "application/json"
+	}
+	if event.UserID != nil {
+		cloudEvent.UserID = event.UserID.String()
+	}
+	return cloudEvent
+}
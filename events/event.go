@@ -0,0 +1,76 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	CREATED = "created"
+	UPDATED = "updated"
+	DELETED = "deleted"
+	// MERGED is the action of the objects that a duplicate was merged into, the duplicate is deleted
+	MERGED = "merged"
+)
+
+// Event describes a mutation of a resource object, by the user of UserID of the object owned by the user of OwnerID
+type Event struct {
+	ID       uuid.UUID         `json:"id"`
+	Type     string            `json:"type"`
+	Resource string            `json:"resource"`
+	Action   string            `json:"action"`
+	ObjectID uuid.UUID         `json:"object_id"`
+	UserID   *uuid.UUID        `json:"user_id,omitempty"`
+	OwnerID  *uuid.UUID        `json:"owner_id,omitempty"`
+	Region   string            `json:"region,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Time     time.Time         `json:"time"`
+	Data     json.RawMessage   `json:"data,omitempty"`
+}
+
+// Publisher is an abstraction of all event publishing backends
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+	Close() error
+}
+
+// NewEvent creates an event for the given resource object
+func NewEvent(resource, action string, objectID uuid.UUID, userID *uuid.UUID, data any) (Event, error) {
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return Event{}, err
+	}
+	event := Event{
+		ID:       uid,
+		Type:     fmt.Sprintf("%s.%s", resource, action),
+		Resource: resource,
+		Action:   action,
+		ObjectID: objectID,
+		UserID:   userID,
+		Time:     time.Now().UTC(),
+	}
+	if data != nil {
+		event.Data, err = json.Marshal(data)
+		if err != nil {
+			return Event{}, err
+		}
+	}
+	return event, nil
+}
+
+// NoopPublisher drops all events, it is used when no backend is configured
+type NoopPublisher struct{}
+
+// Publish does nothing
+func (p NoopPublisher) Publish(ctx context.Context, event Event) error {
+	return nil
+}
+
+// Close does nothing
+func (p NoopPublisher) Close() error {
+	return nil
+}
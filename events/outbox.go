@@ -0,0 +1,150 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/coordination"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OutboxEntry is a persisted event waiting to be relayed to the publisher
+type OutboxEntry struct {
+	ID          uuid.UUID `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	PublishedAt *time.Time
+	Attempts    int
+	Payload     string
+}
+
+// TableName returns the outbox table name
+func (e *OutboxEntry) TableName() string {
+	return "outbox"
+}
+
+// Outbox stores events in the mutation transaction and relays them to a publisher afterwards.
+// Delivery is at least once: consumers should deduplicate using the event ID.
+type Outbox struct {
+	DB        *gorm.DB
+	Publisher Publisher
+	Config    cfg.Outbox
+	// OnFailure is called when publishing an entry fails, with the number of attempts so far
+	OnFailure func(event Event, attempts int, err error)
+	// Elector elects the instance relaying the outbox among the replicas, all instances relay without it
+	Elector *coordination.Elector
+}
+
+// NewOutbox creates an outbox that relays stored events to the given publisher
+func NewOutbox(db *gorm.DB, publisher Publisher, config cfg.Outbox) *Outbox {
+	return &Outbox{
+		DB:        db,
+		Publisher: publisher,
+		Config:    config,
+	}
+}
+
+// Write stores the event using the transaction of the mutation
+func (outbox *Outbox) Write(tx *gorm.DB, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	entry := &OutboxEntry{
+		ID:        event.ID,
+		CreatedAt: event.Time,
+		Payload:   string(payload),
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Create(entry).Error
+}
+
+// Run relays pending entries until the context is cancelled
+func (outbox *Outbox) Run(ctx context.Context) {
+	slog.Info("Outbox relay started", "interval", outbox.Config.PollInterval, "batch", outbox.Config.BatchSize)
+	ticker := time.NewTicker(outbox.Config.PollInterval)
+	defer ticker.Stop()
+	if outbox.Elector != nil {
+		defer outbox.Elector.Resign()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Outbox relay stopped")
+			return
+		case <-ticker.C:
+		}
+		if outbox.Elector != nil && !outbox.Elector.Lead(ctx) {
+			continue
+		}
+		// Drain full batches without waiting for the next tick
+		for {
+			relayed, err := outbox.relay(ctx)
+			if err != nil {
+				slog.Error("Error relaying outbox entries", "error", err)
+				break
+			}
+			if relayed < outbox.Config.BatchSize {
+				break
+			}
+		}
+		err := outbox.cleanup(ctx)
+		if err != nil {
+			slog.Error("Error cleaning up outbox entries", "error", err)
+		}
+	}
+}
+
+// relay publishes one batch of pending entries in creation order and marks them as published
+func (outbox *Outbox) relay(ctx context.Context) (int, error) {
+	relayed := 0
+	err := domain.Transaction(outbox.DB.WithContext(ctx), func(tx *gorm.DB) error {
+		relayed = 0
+		var entries []OutboxEntry
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("published_at IS NULL").
+			Order("created_at").
+			Limit(outbox.Config.BatchSize).
+			Find(&entries).Error
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			var event Event
+			err = json.Unmarshal([]byte(entry.Payload), &event)
+			if err != nil {
+				return err
+			}
+			err = outbox.Publisher.Publish(ctx, event)
+			if err != nil {
+				slog.Error("Error publishing outbox entry", "event", entry.ID, "attempts", entry.Attempts+1, "error", err)
+				if outbox.OnFailure != nil {
+					outbox.OnFailure(event, entry.Attempts+1, err)
+				}
+				// Keep ordering by stopping the batch on the first failure
+				return tx.Model(&OutboxEntry{}).Where("id = ?", entry.ID).Update("attempts", gorm.Expr("attempts + 1")).Error
+			}
+			now := time.Now().UTC()
+			err = tx.Model(&OutboxEntry{}).Where("id = ?", entry.ID).Updates(map[string]any{"published_at": now, "attempts": entry.Attempts + 1}).Error
+			if err != nil {
+				return err
+			}
+			relayed++
+		}
+		return nil
+	})
+	return relayed, err
+}
+
+// cleanup removes published entries older than the configured retention
+func (outbox *Outbox) cleanup(ctx context.Context) error {
+	if outbox.Config.Retention <= 0 {
+		return nil
+	}
+	threshold := time.Now().UTC().Add(-outbox.Config.Retention)
+	return outbox.DB.WithContext(ctx).Where("published_at IS NOT NULL AND published_at < ?", threshold).Delete(&OutboxEntry{}).Error
+}
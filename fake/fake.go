@@ -0,0 +1,410 @@
+package fake
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+	"github.com/shopspring/decimal"
+)
+
+// attempts is how many instances are generated until one passes the validation of the resource
+const attempts = 20
+
+var (
+	uuidType    = reflect.TypeOf(uuid.UUID{})
+	timeType    = reflect.TypeOf(time.Time{})
+	decimalType = reflect.TypeOf(domain.Decimal{})
+	pointType   = reflect.TypeOf(domain.Point{})
+
+	givenNames  = []string{"Alice", "Bob", "Carol", "David", "Emma", "Frank", "Grace", "Henry", "Irene", "Jack", "Maria", "Nikolay", "Olivia", "Peter"}
+	familyNames = []string{"Anderson", "Brown", "Clark", "Davis", "Evans", "Garcia", "Ivanov", "Johnson", "Miller", "Petrov", "Smith", "Taylor", "Wilson"}
+	words       = []string{"apple", "river", "stone", "garden", "silver", "morning", "harbor", "forest", "lantern", "meadow", "orbit", "maple", "copper", "breeze", "summit", "velvet"}
+	cities      = []string{"Amsterdam", "Berlin", "Lisbon", "Madrid", "Oslo", "Paris", "Prague", "Sofia", "Vienna", "Warsaw"}
+	countries   = []string{"Austria", "Bulgaria", "Czechia", "France", "Germany", "Netherlands", "Norway", "Poland", "Portugal", "Spain"}
+	streets     = []string{"Main Street", "Oak Avenue", "Park Lane", "Station Road", "Mill Street", "Church Road"}
+	domains     = []string{"example.com", "example.org", "example.net"}
+)
+
+// Generator creates random instances of the registered resources. Fields are filled by their name, e.g. Email,
+// Name or Phone, and type. The fake tag overrides it:
+//
+//	Status string `fake:"oneof=draft|published"`
+//	Pages  int    `fake:"min=10,max=500"`
+//	Code   string `fake:"len=8"`
+//	Note   string `fake:"-"`
+//
+// Belongs-to relations, a XxxID field next to a Xxx field of a registered resource, reference the generated IDs.
+type Generator struct {
+	Resources *common.Resources
+	// IDs are the known IDs per resource used for the relations, generated objects are added by Generate
+	IDs  map[string][]uuid.UUID
+	rand *rand.Rand
+}
+
+// New creates a generator, the same seed produces the same instances
+func New(resources *common.Resources, seed uint64) *Generator {
+	return &Generator{
+		Resources: resources,
+		IDs:       map[string][]uuid.UUID{},
+		rand:      rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)),
+	}
+}
+
+// SeedFlag registers the --seed-fake flag, the number of fake instances created for each resource on start
+func SeedFlag(flagSet *flag.FlagSet) *int {
+	return flagSet.Int("seed-fake", 0, "number of fake instances to create for each resource on start")
+}
+
+// Generate creates a random instance of the resource that passes its validation, the ID is set
+func (generator *Generator) Generate(ctx context.Context, resourceName string) (domain.Object, error) {
+	var err error
+	for range attempts {
+		var object domain.Object
+		object, err = generator.Resources.New(resourceName)
+		if err != nil {
+			return nil, err
+		}
+		generator.fill(reflect.ValueOf(object).Elem())
+		object.SetID(generator.uuid())
+		err = object.Validate(ctx)
+		if err == nil {
+			generator.IDs[resourceName] = append(generator.IDs[resourceName], object.GetID())
+			return object, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot generate valid %s: %w", resourceName, err)
+}
+
+// User creates a random user
+func (generator *Generator) User() *domain.User {
+	givenName := generator.pick(givenNames)
+	familyName := generator.pick(familyNames)
+	user := &domain.User{
+		PreferedUserName: strings.ToLower(givenName + "." + familyName),
+		GivenName:        givenName,
+		FamilyName:       familyName,
+	}
+	user.ID = generator.uuid()
+	user.Email = fmt.Sprintf("%s@%s", user.PreferedUserName, generator.pick(domains))
+	return user
+}
+
+// Order returns the registered resources with the referenced ones before the referencing ones, without the SQL views
+// that are read-only
+func (generator *Generator) Order() []string {
+	names := generator.Resources.Names()
+	visited := map[string]bool{}
+	var ordered []string
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		resource := generator.Resources.Resources[name]
+		if resource.SQLView != nil {
+			return
+		}
+		for i := 0; i < resource.Type.NumField(); i++ {
+			if related, ok := generator.relation(resource.Type, resource.Type.Field(i)); ok {
+				visit(related)
+			}
+		}
+		ordered = append(ordered, name)
+	}
+	// Sorted names keep the order stable between runs
+	sort.Strings(names)
+	for _, name := range names {
+		visit(name)
+	}
+	return ordered
+}
+
+// fill sets random values to the exported fields of the struct
+func (generator *Generator) fill(value reflect.Value) {
+	structType := value.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous {
+			// Base fields and the origin are set by the repository layer
+			continue
+		}
+		tag := field.Tag.Get("fake")
+		if tag == "-" || field.Tag.Get("json") == "-" || field.Name == "UserID" {
+			continue
+		}
+		if related, ok := generator.relation(structType, field); ok {
+			ids := generator.IDs[related]
+			if len(ids) > 0 {
+				setUUID(value.Field(i), ids[generator.rand.IntN(len(ids))])
+			}
+			continue
+		}
+		generator.set(value.Field(i), field.Name, parseTag(tag))
+	}
+}
+
+// set fills a single value according to its type and field name
+func (generator *Generator) set(value reflect.Value, name string, options tagOptions) {
+	if value.Kind() == reflect.Pointer {
+		elem := reflect.New(value.Type().Elem())
+		generator.set(elem.Elem(), name, options)
+		if elem.Elem().Kind() == reflect.Struct && elem.Elem().Type() != timeType && elem.Elem().Type() != decimalType && elem.Elem().Type() != pointType {
+			return
+		}
+		value.Set(elem)
+		return
+	}
+	if len(options.oneOf) > 0 {
+		setString(value, options.oneOf[generator.rand.IntN(len(options.oneOf))])
+		return
+	}
+	switch {
+	case value.Type() == uuidType:
+		value.Set(reflect.ValueOf(generator.uuid()))
+		return
+	case value.Type() == timeType:
+		offset := time.Duration(generator.rand.Int64N(int64(365 * 24 * time.Hour)))
+		value.Set(reflect.ValueOf(time.Now().UTC().Add(-offset).Truncate(time.Second)))
+		return
+	case value.Type() == decimalType:
+		minimum, maximum := options.bounds(1, 100)
+		cents := int64((minimum + generator.rand.Float64()*(maximum-minimum)) * 100)
+		value.Set(reflect.ValueOf(domain.DecimalOf(decimal.New(cents, -2))))
+		return
+	case value.Type() == pointType:
+		lat := float64(int64((generator.rand.Float64()*180-90)*1e6)) / 1e6
+		lng := float64(int64((generator.rand.Float64()*360-180)*1e6)) / 1e6
+		value.Set(reflect.ValueOf(domain.Point{Lat: lat, Lng: lng}))
+		return
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(generator.text(name, options))
+	case reflect.Bool:
+		value.SetBool(generator.rand.IntN(2) == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(int64(generator.number(options, 1, 100)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value.SetUint(uint64(generator.number(options, 1, 100)))
+	case reflect.Float32, reflect.Float64:
+		minimum, maximum := options.bounds(1, 100)
+		number := minimum + generator.rand.Float64()*(maximum-minimum)
+		value.SetFloat(float64(int64(number*100)) / 100)
+	}
+}
+
+// text generates a string matching the field name, the length options limit it
+func (generator *Generator) text(name string, options tagOptions) string {
+	kind := options.kind
+	if kind == "" {
+		kind = strings.ToLower(name)
+	}
+	var text string
+	switch {
+	case strings.Contains(kind, "email"):
+		text = fmt.Sprintf("%s.%s@%s", strings.ToLower(generator.pick(givenNames)), strings.ToLower(generator.pick(familyNames)), generator.pick(domains))
+	case strings.Contains(kind, "url"), strings.Contains(kind, "website"), strings.Contains(kind, "link"):
+		text = fmt.Sprintf("https://%s/%s", generator.pick(domains), generator.pick(words))
+	case strings.Contains(kind, "phone"):
+		text = fmt.Sprintf("+359 %d %03d %04d", 2+generator.rand.IntN(8), generator.rand.IntN(1000), generator.rand.IntN(10000))
+	case kind == "givenname", kind == "firstname":
+		text = generator.pick(givenNames)
+	case kind == "familyname", kind == "lastname", kind == "surname":
+		text = generator.pick(familyNames)
+	case strings.Contains(kind, "username"), strings.Contains(kind, "login"):
+		text = strings.ToLower(generator.pick(givenNames)) + strconv.Itoa(generator.rand.IntN(100))
+	case strings.Contains(kind, "city"):
+		text = generator.pick(cities)
+	case strings.Contains(kind, "country"):
+		text = generator.pick(countries)
+	case strings.Contains(kind, "address"), strings.Contains(kind, "street"):
+		text = fmt.Sprintf("%d %s", 1+generator.rand.IntN(200), generator.pick(streets))
+	case strings.Contains(kind, "description"), strings.Contains(kind, "comment"), strings.Contains(kind, "note"), strings.Contains(kind, "text"), strings.Contains(kind, "body"):
+		text = generator.sentence(8 + generator.rand.IntN(12))
+	case strings.Contains(kind, "name"), strings.Contains(kind, "title"):
+		text = capitalize(generator.sentence(1 + generator.rand.IntN(3)))
+	case strings.Contains(kind, "color"), strings.Contains(kind, "colour"):
+		text = fmt.Sprintf("#%06x", generator.rand.IntN(0x1000000))
+	case strings.Contains(kind, "code"):
+		text = strings.ToUpper(generator.pick(words)[:3]) + strconv.Itoa(100+generator.rand.IntN(900))
+	default:
+		text = generator.pick(words)
+	}
+	return options.fit(text, generator)
+}
+
+// sentence joins random words
+func (generator *Generator) sentence(count int) string {
+	parts := make([]string, count)
+	for i := range parts {
+		parts[i] = generator.pick(words)
+	}
+	return capitalize(strings.Join(parts, " "))
+}
+
+// number returns a random integer within the bounds of the options
+func (generator *Generator) number(options tagOptions, minimum, maximum float64) int64 {
+	low, high := options.bounds(minimum, maximum)
+	if high <= low {
+		return int64(low)
+	}
+	return int64(low) + generator.rand.Int64N(int64(high)-int64(low)+1)
+}
+
+// pick returns a random item
+func (generator *Generator) pick(items []string) string {
+	return items[generator.rand.IntN(len(items))]
+}
+
+// uuid returns a random UUID from the seeded source
+func (generator *Generator) uuid() uuid.UUID {
+	var id uuid.UUID
+	for i := range id {
+		id[i] = byte(generator.rand.UintN(256))
+	}
+	id.SetVersion(uuid.V4)
+	id.SetVariant(uuid.VariantRFC9562)
+	return id
+}
+
+// relation returns the referenced resource of a belongs-to field, i.e. XxxID next to a Xxx field of a registered resource
+func (generator *Generator) relation(structType reflect.Type, field reflect.StructField) (string, bool) {
+	fieldType := field.Type
+	if fieldType.Kind() == reflect.Pointer {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType != uuidType || !strings.HasSuffix(field.Name, "ID") {
+		return "", false
+	}
+	related, ok := structType.FieldByName(strings.TrimSuffix(field.Name, "ID"))
+	if !ok {
+		return "", false
+	}
+	relatedType := related.Type
+	if relatedType.Kind() == reflect.Pointer {
+		relatedType = relatedType.Elem()
+	}
+	for name, resource := range generator.Resources.Resources {
+		if resource.Type == relatedType {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// tagOptions are the parsed options of the fake tag
+type tagOptions struct {
+	kind   string
+	oneOf  []string
+	min    *float64
+	max    *float64
+	length int
+}
+
+// parseTag parses e.g. "email", "oneof=a|b" or "min=1,max=10"
+func parseTag(tag string) tagOptions {
+	var options tagOptions
+	for _, part := range strings.Split(tag, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			options.kind = strings.ToLower(key)
+			continue
+		}
+		switch key {
+		case "oneof":
+			options.oneOf = strings.Split(value, "|")
+		case "min", "max":
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			if key == "min" {
+				options.min = &number
+			} else {
+				options.max = &number
+			}
+		case "len":
+			options.length, _ = strconv.Atoi(value)
+		}
+	}
+	return options
+}
+
+// bounds returns the minimum and maximum with the defaults for the missing ones
+func (options tagOptions) bounds(minimum, maximum float64) (float64, float64) {
+	if options.min != nil {
+		minimum = *options.min
+		if options.max == nil && maximum < minimum {
+			maximum = minimum * 2
+		}
+	}
+	if options.max != nil {
+		maximum = *options.max
+		if options.min == nil && minimum > maximum {
+			minimum = 0
+		}
+	}
+	return minimum, maximum
+}
+
+// fit pads or truncates the text to the length options, min and max are lengths for strings
+func (options tagOptions) fit(text string, generator *Generator) string {
+	minimum, maximum := 0, 0
+	if options.length > 0 {
+		minimum, maximum = options.length, options.length
+	}
+	if options.min != nil {
+		minimum = int(*options.min)
+	}
+	if options.max != nil {
+		maximum = int(*options.max)
+	}
+	for len(text) < minimum {
+		text += " " + generator.pick(words)
+	}
+	if maximum > 0 && len(text) > maximum {
+		text = strings.TrimSpace(text[:maximum])
+		for len(text) < minimum {
+			text += "x"
+		}
+	}
+	return text
+}
+
+// setUUID sets the ID to a UUID or a UUID pointer
+func setUUID(value reflect.Value, id uuid.UUID) {
+	if value.Kind() == reflect.Pointer {
+		value.Set(reflect.ValueOf(&id))
+		return
+	}
+	value.Set(reflect.ValueOf(id))
+}
+
+// setString sets the enum value to a string or a string based type
+func setString(value reflect.Value, text string) {
+	if value.Kind() == reflect.String {
+		value.SetString(text)
+	}
+}
+
+// capitalize upper cases the first letter
+func capitalize(text string) string {
+	if text == "" {
+		return text
+	}
+	return strings.ToUpper(text[:1]) + text[1:]
+}
@@ -0,0 +1,195 @@
+package fga
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/gofrs/uuid/v5"
+)
+
+// readPageSize is the page size of the reads of the relations of an object
+const readPageSize = 100
+
+var _ common.Authorizer = (*Client)(nil)
+
+// Client is the authorizer of the shared resources backed by the relationship tuples of an OpenFGA store. The
+// users are of the type of FGA_USER_TYPE, the objects of the type with the name of their resource, e.g.
+// user:5f0f... is viewer of document:8c1e...
+type Client struct {
+	Config cfg.FGA
+	Client *http.Client
+}
+
+// New creates the client of the store, FGA_URL and FGA_STORE_ID are required
+func New(config cfg.FGA) *Client {
+	slog.Info("OpenFGA client initialized", "url", config.URL, "store", config.StoreID, "model", config.ModelID)
+	return &Client{
+		Config: config,
+		Client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// tupleKey is a relationship tuple of the API
+type tupleKey struct {
+	User     string `json:"user,omitempty"`
+	Relation string `json:"relation,omitempty"`
+	Object   string `json:"object"`
+}
+
+// tupleKeys are the tuples of the writes or the deletes of a write request, the writes of existing tuples and the
+// deletes of missing ones are ignored
+type tupleKeys struct {
+	TupleKeys   []tupleKey `json:"tuple_keys"`
+	OnDuplicate string     `json:"on_duplicate,omitempty"`
+	OnMissing   string     `json:"on_missing,omitempty"`
+}
+
+// Check checks that the user has the relation to the object, the relations derived by the authorization model, e.g.
+// the viewers that are editors, are allowed
+func (client *Client) Check(ctx context.Context, tuple common.Tuple) (bool, error) {
+	request := map[string]any{"tuple_key": client.key(tuple)}
+	response := struct {
+		Allowed bool `json:"allowed"`
+	}{}
+	err := client.do(ctx, "check", request, &response)
+	return response.Allowed, err
+}
+
+// ListObjects returns the IDs of the objects of the resource that the user has the relation to
+func (client *Client) ListObjects(ctx context.Context, userID uuid.UUID, relation, resource string) ([]uuid.UUID, error) {
+	request := map[string]any{"type": resource, "relation": relation, "user": client.user(userID)}
+	response := struct {
+		Objects []string `json:"objects"`
+	}{}
+	err := client.do(ctx, "list-objects", request, &response)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, 0, len(response.Objects))
+	for _, object := range response.Objects {
+		id, err := uuid.FromString(strings.TrimPrefix(object, resource+":"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid object %q: %w", object, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Read returns the relations of the users granted on the object, all the pages of them
+func (client *Client) Read(ctx context.Context, resource string, objectID uuid.UUID) ([]common.Tuple, error) {
+	var tuples []common.Tuple
+	token := ""
+	for {
+		request := map[string]any{"tuple_key": tupleKey{Object: resource + ":" + objectID.String()}, "page_size": readPageSize}
+		if token != "" {
+			request["continuation_token"] = token
+		}
+		response := struct {
+			Tuples []struct {
+				Key tupleKey `json:"key"`
+			} `json:"tuples"`
+			ContinuationToken string `json:"continuation_token"`
+		}{}
+		err := client.do(ctx, "read", request, &response)
+		if err != nil {
+			return nil, err
+		}
+		for _, tuple := range response.Tuples {
+			// The usersets, e.g. group:admins#member, are not relations of users and are not returned
+			userType, user, _ := strings.Cut(tuple.Key.User, ":")
+			userID, err := uuid.FromString(user)
+			if userType != client.Config.UserType || err != nil {
+				continue
+			}
+			tuples = append(tuples, common.Tuple{UserID: userID, Relation: tuple.Key.Relation, Resource: resource, ObjectID: objectID})
+		}
+		token = response.ContinuationToken
+		if token == "" {
+			return tuples, nil
+		}
+	}
+}
+
+// Write writes the tuples of the writes and deletes the ones of the deletes in one transaction of the store
+func (client *Client) Write(ctx context.Context, writes, deletes []common.Tuple) error {
+	request := map[string]any{}
+	if len(writes) > 0 {
+		keys := client.keys(writes)
+		keys.OnDuplicate = "ignore"
+		request["writes"] = keys
+	}
+	if len(deletes) > 0 {
+		keys := client.keys(deletes)
+		keys.OnMissing = "ignore"
+		request["deletes"] = keys
+	}
+	if len(request) == 0 {
+		return nil
+	}
+	return client.do(ctx, "write", request, nil)
+}
+
+// user returns the user of the tuples of the user ID
+func (client *Client) user(userID uuid.UUID) string {
+	return client.Config.UserType + ":" + userID.String()
+}
+
+// key returns the tuple of the API
+func (client *Client) key(tuple common.Tuple) tupleKey {
+	return tupleKey{User: client.user(tuple.UserID), Relation: tuple.Relation, Object: tuple.Resource + ":" + tuple.ObjectID.String()}
+}
+
+// keys returns the tuples of the API
+func (client *Client) keys(tuples []common.Tuple) tupleKeys {
+	keys := tupleKeys{TupleKeys: make([]tupleKey, 0, len(tuples))}
+	for _, tuple := range tuples {
+		keys.TupleKeys = append(keys.TupleKeys, client.key(tuple))
+	}
+	return keys
+}
+
+// do posts the request to the endpoint of the store, with the authorization model of FGA_MODEL_ID when it is set
+// and the latest model otherwise, and decodes the response into the result
+func (client *Client) do(ctx context.Context, endpoint string, request map[string]any, result any) error {
+	if client.Config.ModelID != "" {
+		request["authorization_model_id"] = client.Config.ModelID
+	}
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/stores/%s/%s", strings.TrimSuffix(client.Config.URL, "/"), client.Config.StoreID, endpoint)
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if client.Config.APIToken != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+client.Config.APIToken)
+	}
+	response, err := client.Client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("OpenFGA %s failed with status %d: %s", endpoint, response.StatusCode, body)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(body, result)
+}
@@ -0,0 +1,232 @@
+package fixtures
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// USER is the resource name used in references to the owner users, e.g. @user.alice
+const USER = "user"
+
+// namespace is used to derive the identifiers of the fixtures from their keys
+var namespace = uuid.NewV5(uuid.NamespaceURL, "https://github.com/dzahariev/respite/fixtures")
+
+// Dataset is the content of a fixtures file
+type Dataset struct {
+	// Users are the owner users by key
+	Users map[string]User `json:"users"`
+	// Objects are created in the given order, so references point to the previous ones
+	Objects []Entry `json:"objects"`
+}
+
+// User is an owner user of the fixtures, the ID is derived from the key unless it is given
+type User struct {
+	ID               uuid.UUID `json:"id"`
+	PreferedUserName string    `json:"prefered_user_name"`
+	GivenName        string    `json:"given_name"`
+	FamilyName       string    `json:"family_name"`
+	Email            string    `json:"email"`
+}
+
+// Entry describes an instance of a resource. String values of the data starting with @ reference the ID of
+// a previous entry or user as @resource.key, @@ escapes a leading @.
+type Entry struct {
+	Resource string         `json:"resource"`
+	Key      string         `json:"key"`
+	Owner    string         `json:"owner"`
+	Data     map[string]any `json:"data"`
+}
+
+// Store creates the users and the objects of the fixtures
+type Store interface {
+	// SaveUser stores the user unless it already exists
+	SaveUser(ctx context.Context, user *domain.User) error
+	// NewRequestContext creates the request context of the resource for the owner, which is nil for global resources
+	NewRequestContext(resourceName string, owner *domain.User) (*common.RequestContext, error)
+}
+
+// References maps the references, e.g. meal.tomato, to the IDs of the loaded fixtures
+type References map[string]uuid.UUID
+
+// ID returns the deterministic ID of the fixture with the resource and key
+func ID(resource, key string) uuid.UUID {
+	return uuid.NewV5(namespace, resource+"."+key)
+}
+
+// ReadFile reads a YAML or JSON fixtures file
+func ReadFile(path string) (*Dataset, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read fixtures file: %w", err)
+	}
+	dataset, err := Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fixtures file %s: %w", path, err)
+	}
+	return dataset, nil
+}
+
+// Parse decodes YAML or JSON fixtures, JSON is also valid YAML
+func Parse(content []byte) (*Dataset, error) {
+	var document any
+	err := yaml.Unmarshal(content, &document)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	dataset := &Dataset{}
+	err = json.Unmarshal(data, dataset)
+	if err != nil {
+		return nil, err
+	}
+	return dataset, nil
+}
+
+// Load creates the users and then the objects of the datasets in order, objects that already exist are left
+// unchanged so the same fixtures can be loaded again. The returned references include the previous ones.
+func Load(ctx context.Context, store Store, references References, datasets ...*Dataset) (References, error) {
+	if references == nil {
+		references = References{}
+	}
+	users := map[string]*domain.User{}
+	for _, dataset := range datasets {
+		for key, fixture := range dataset.Users {
+			user := &domain.User{
+				Base:             domain.Base{ID: fixture.ID},
+				PreferedUserName: fixture.PreferedUserName,
+				GivenName:        fixture.GivenName,
+				FamilyName:       fixture.FamilyName,
+				Email:            fixture.Email,
+			}
+			if user.ID.IsNil() {
+				user.ID = ID(USER, key)
+			}
+			if user.PreferedUserName == "" {
+				user.PreferedUserName = key
+			}
+			err := store.SaveUser(ctx, user)
+			if err != nil {
+				return nil, fmt.Errorf("cannot save user %s: %w", key, err)
+			}
+			users[key] = user
+			references[USER+"."+key] = user.ID
+		}
+	}
+	for _, dataset := range datasets {
+		for i, entry := range dataset.Objects {
+			if entry.Key == "" {
+				entry.Key = strconv.Itoa(i)
+			}
+			reference := entry.Resource + "." + entry.Key
+			id, err := load(ctx, store, references, users, entry)
+			if err != nil {
+				return nil, fmt.Errorf("cannot load %s: %w", reference, err)
+			}
+			references[reference] = id
+		}
+	}
+	return references, nil
+}
+
+// load creates the object of the entry unless it exists and returns its ID
+func load(ctx context.Context, store Store, references References, users map[string]*domain.User, entry Entry) (uuid.UUID, error) {
+	var owner *domain.User
+	if entry.Owner != "" {
+		var ok bool
+		owner, ok = users[entry.Owner]
+		if !ok {
+			return uuid.Nil, fmt.Errorf("unknown owner %s", entry.Owner)
+		}
+	}
+	requestContext, err := store.NewRequestContext(entry.Resource, owner)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	data, err := resolve(entry.Data, references)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	fields, _ := data.(map[string]any)
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	id := ID(entry.Resource, entry.Key)
+	if value, ok := fields["id"].(string); ok {
+		id, err = uuid.FromString(value)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("invalid id: %w", err)
+		}
+	}
+	fields["id"] = id.String()
+
+	_, err = requestContext.Get(ctx, id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return uuid.Nil, err
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	_, err = requestContext.Create(ctx, body)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
+
+// resolve replaces the references in the value with the IDs
+func resolve(value any, references References) (any, error) {
+	switch typed := value.(type) {
+	case string:
+		if strings.HasPrefix(typed, "@@") {
+			return typed[1:], nil
+		}
+		if !strings.HasPrefix(typed, "@") {
+			return typed, nil
+		}
+		id, ok := references[typed[1:]]
+		if !ok {
+			return nil, fmt.Errorf("unknown reference %s", typed)
+		}
+		return id.String(), nil
+	case map[string]any:
+		resolved := make(map[string]any, len(typed))
+		for key, item := range typed {
+			value, err := resolve(item, references)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = value
+		}
+		return resolved, nil
+	case []any:
+		resolved := make([]any, len(typed))
+		for i, item := range typed {
+			value, err := resolve(item, references)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = value
+		}
+		return resolved, nil
+	default:
+		return value, nil
+	}
+}
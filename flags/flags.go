@@ -0,0 +1,170 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/domain"
+	"gorm.io/gorm"
+)
+
+type contextKey string
+
+const flagsKey contextKey = "FeatureFlagsKey"
+
+// Flag is a feature flag definition. An enabled flag without targeting is on for everybody,
+// otherwise it is on for the listed users and for the given percentage of all users.
+type Flag struct {
+	Name       string   `json:"name" gorm:"primaryKey"`
+	Enabled    bool     `json:"enabled"`
+	Users      []string `json:"users,omitempty" gorm:"serializer:json"`
+	Percentage int      `json:"percentage,omitempty"`
+}
+
+// TableName returns the feature flags table name
+func (f *Flag) TableName() string {
+	return "feature_flags"
+}
+
+// Evaluate checks if the flag is on for the user, users are matched by ID, email or username
+func (f *Flag) Evaluate(user *domain.User) bool {
+	if !f.Enabled {
+		return false
+	}
+	if len(f.Users) == 0 && f.Percentage == 0 {
+		return true
+	}
+	if user == nil {
+		return false
+	}
+	for _, target := range f.Users {
+		if strings.EqualFold(target, user.ID.String()) || (user.Email != "" && strings.EqualFold(target, user.Email)) || (user.PreferedUserName != "" && strings.EqualFold(target, user.PreferedUserName)) {
+			return true
+		}
+	}
+	return bucket(f.Name, user.ID.String()) < f.Percentage
+}
+
+// Set is the evaluated flags of a caller
+type Set map[string]bool
+
+// Enabled checks if the flag is on, unknown flags are off
+func (set Set) Enabled(name string) bool {
+	return set[name]
+}
+
+// NewContext returns a context carrying the evaluated flags
+func NewContext(ctx context.Context, set Set) context.Context {
+	return context.WithValue(ctx, flagsKey, set)
+}
+
+// FromContext returns the evaluated flags of the context, all flags are off if there are none
+func FromContext(ctx context.Context) Set {
+	set, _ := ctx.Value(flagsKey).(Set)
+	return set
+}
+
+// Enabled checks if the flag is on for the caller of the context
+func Enabled(ctx context.Context, name string) bool {
+	return FromContext(ctx).Enabled(name)
+}
+
+// Flags holds the flag definitions from the configuration and optionally from the feature_flags table,
+// which take precedence
+type Flags struct {
+	Config     cfg.Flags
+	DB         *gorm.DB
+	configured map[string]Flag
+	mutex      sync.RWMutex
+	flags      map[string]Flag
+}
+
+// New parses the configured definitions and loads the flags from the database if enabled
+func New(ctx context.Context, config cfg.Flags, db *gorm.DB) (*Flags, error) {
+	featureFlags := &Flags{
+		Config:     config,
+		DB:         db,
+		configured: map[string]Flag{},
+	}
+	if config.Definitions != "" {
+		err := json.Unmarshal([]byte(config.Definitions), &featureFlags.configured)
+		if err != nil {
+			return nil, fmt.Errorf("invalid feature flag definitions: %w", err)
+		}
+	}
+	for name, flag := range featureFlags.configured {
+		flag.Name = name
+		featureFlags.configured[name] = flag
+	}
+	featureFlags.flags = featureFlags.configured
+	err := featureFlags.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Feature flags initialized", "flags", slices.Sorted(maps.Keys(featureFlags.flags)), "database", config.Database)
+	return featureFlags, nil
+}
+
+// Load reloads the flags from the database
+func (featureFlags *Flags) Load(ctx context.Context) error {
+	if !featureFlags.Config.Database {
+		return nil
+	}
+	var stored []Flag
+	err := featureFlags.DB.WithContext(ctx).Find(&stored).Error
+	if err != nil {
+		return fmt.Errorf("cannot load feature flags: %w", err)
+	}
+	loaded := maps.Clone(featureFlags.configured)
+	for _, flag := range stored {
+		loaded[flag.Name] = flag
+	}
+	featureFlags.mutex.Lock()
+	featureFlags.flags = loaded
+	featureFlags.mutex.Unlock()
+	return nil
+}
+
+// Run reloads the flags from the database periodically until the context is cancelled
+func (featureFlags *Flags) Run(ctx context.Context) {
+	ticker := time.NewTicker(featureFlags.Config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := featureFlags.Load(ctx)
+			if err != nil {
+				slog.Error("Error refreshing feature flags", "error", err)
+			}
+		}
+	}
+}
+
+// Evaluate returns the flags evaluated for the user, a nil user gets only the flags without targeting
+func (featureFlags *Flags) Evaluate(user *domain.User) Set {
+	featureFlags.mutex.RLock()
+	defer featureFlags.mutex.RUnlock()
+	set := Set{}
+	for name, flag := range featureFlags.flags {
+		set[name] = flag.Evaluate(user)
+	}
+	return set
+}
+
+// bucket assigns the user a stable bucket between 0 and 99 per flag
+func bucket(name, userID string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(name + ":" + userID))
+	return int(hash.Sum32() % 100)
+}
@@ -0,0 +1,98 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+)
+
+// Checker pings the database periodically and decides, based on how long it is unavailable, if the instance
+// should stop receiving traffic or be restarted
+type Checker struct {
+	Config           cfg.Health
+	ping             func(ctx context.Context) error
+	mutex            sync.RWMutex
+	unavailableSince time.Time
+	lastError        error
+}
+
+// New creates a checker, zero values in the configuration are replaced by defaults
+func New(config cfg.Health, ping func(ctx context.Context) error) *Checker {
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = 5 * time.Second
+	}
+	if config.CheckTimeout <= 0 {
+		config.CheckTimeout = 2 * time.Second
+	}
+	return &Checker{
+		Config: config,
+		ping:   ping,
+	}
+}
+
+// Run checks the database until the context is cancelled
+func (checker *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(checker.Config.CheckInterval)
+	defer ticker.Stop()
+	for {
+		checker.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check pings the database and records since when it is unavailable
+func (checker *Checker) check(ctx context.Context) {
+	pingCtx, cancel := context.WithTimeout(ctx, checker.Config.CheckTimeout)
+	err := checker.ping(pingCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	switch {
+	case err != nil && checker.lastError == nil:
+		checker.unavailableSince = time.Now()
+		slog.Warn("Database health check failed", "error", err)
+	case err == nil && checker.lastError != nil:
+		slog.Info("Database health check recovered", "unavailable", time.Since(checker.unavailableSince))
+		checker.unavailableSince = time.Time{}
+	}
+	checker.lastError = err
+}
+
+// Live reports an error when the database is unavailable longer than the liveness threshold, without threshold
+// the instance stays live
+func (checker *Checker) Live() error {
+	if checker.Config.LivenessThreshold <= 0 {
+		return nil
+	}
+	return checker.unavailable(checker.Config.LivenessThreshold)
+}
+
+// Ready reports an error when the database is unavailable longer than the readiness threshold
+func (checker *Checker) Ready() error {
+	return checker.unavailable(checker.Config.ReadinessThreshold)
+}
+
+// unavailable reports the last error when the database is unavailable at least for the threshold
+func (checker *Checker) unavailable(threshold time.Duration) error {
+	checker.mutex.RLock()
+	defer checker.mutex.RUnlock()
+	if checker.lastError == nil {
+		return nil
+	}
+	duration := time.Since(checker.unavailableSince)
+	if duration < threshold {
+		return nil
+	}
+	return fmt.Errorf("database unavailable for %s: %w", duration.Round(time.Second), checker.lastError)
+}
@@ -0,0 +1,155 @@
+package i18n
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+)
+
+type contextKey string
+
+const localeKey contextKey = "LocaleKey"
+
+// Catalog keeps the messages of the error codes per locale, e.g. "de" or "pt-BR"
+type Catalog struct {
+	mutex    sync.RWMutex
+	fallback string
+	messages map[string]map[string]string
+	locales  []string
+	matcher  language.Matcher
+}
+
+// NewCatalog creates an empty catalog, messages missing in a locale are taken from the fallback locale
+func NewCatalog(fallback string) *Catalog {
+	return &Catalog{fallback: fallback, messages: map[string]map[string]string{}}
+}
+
+// SetFallback changes the locale used when the requested locales have no messages
+func (catalog *Catalog) SetFallback(fallback string) {
+	catalog.mutex.Lock()
+	defer catalog.mutex.Unlock()
+	catalog.fallback = fallback
+	catalog.matcher = nil
+}
+
+// Add adds the messages of the codes to the locale, existing messages of the codes are replaced
+func (catalog *Catalog) Add(locale string, messages map[string]string) {
+	catalog.mutex.Lock()
+	defer catalog.mutex.Unlock()
+	if catalog.messages[locale] == nil {
+		catalog.messages[locale] = map[string]string{}
+		catalog.locales = append(catalog.locales, locale)
+		catalog.matcher = nil
+	}
+	for code, message := range messages {
+		catalog.messages[locale][code] = message
+	}
+}
+
+// Locales returns the locales with messages
+func (catalog *Catalog) Locales() []string {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	return slices.Clone(catalog.locales)
+}
+
+// Negotiate selects the locale of the catalog that matches the Accept-Language header best,
+// the fallback locale is returned for missing or unmatched headers
+func (catalog *Catalog) Negotiate(acceptLanguage string) string {
+	catalog.mutex.Lock()
+	defer catalog.mutex.Unlock()
+	if strings.TrimSpace(acceptLanguage) == "" || len(catalog.locales) == 0 {
+		return catalog.fallback
+	}
+	locales := catalog.supported()
+	if catalog.matcher == nil {
+		tags := make([]language.Tag, 0, len(locales))
+		for _, locale := range locales {
+			tags = append(tags, language.Make(locale))
+		}
+		catalog.matcher = language.NewMatcher(tags)
+	}
+	tags := preferences(acceptLanguage)
+	if len(tags) == 0 {
+		return catalog.fallback
+	}
+	_, index, confidence := catalog.matcher.Match(tags...)
+	if confidence == language.No {
+		return catalog.fallback
+	}
+	return locales[index]
+}
+
+// preferences parses the languages of the Accept-Language header ordered by their quality, entries that are
+// not valid or unknown are skipped instead of rejecting the header
+func preferences(acceptLanguage string) []language.Tag {
+	type preference struct {
+		tag     language.Tag
+		quality float32
+	}
+	var parsed []preference
+	for _, entry := range strings.Split(acceptLanguage, ",") {
+		tags, qualities, err := language.ParseAcceptLanguage(entry)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+		parsed = append(parsed, preference{tag: tags[0], quality: qualities[0]})
+	}
+	slices.SortStableFunc(parsed, func(a, b preference) int {
+		switch {
+		case a.quality > b.quality:
+			return -1
+		case a.quality < b.quality:
+			return 1
+		}
+		return 0
+	})
+	tags := make([]language.Tag, 0, len(parsed))
+	for _, preference := range parsed {
+		tags = append(tags, preference.tag)
+	}
+	return tags
+}
+
+// supported lists the fallback locale first, so that it is the default of the matcher
+func (catalog *Catalog) supported() []string {
+	locales := []string{catalog.fallback}
+	for _, locale := range catalog.locales {
+		if locale != catalog.fallback {
+			locales = append(locales, locale)
+		}
+	}
+	return locales
+}
+
+// Message returns the message of the code in the locale, then in its base language, e.g. "pt" for "pt-BR",
+// and then in the fallback locale
+func (catalog *Catalog) Message(locale, code string) (string, bool) {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	candidates := []string{locale}
+	if base, _, found := strings.Cut(locale, "-"); found {
+		candidates = append(candidates, base)
+	}
+	candidates = append(candidates, catalog.fallback)
+	for _, candidate := range candidates {
+		if message, ok := catalog.messages[candidate][code]; ok {
+			return message, true
+		}
+	}
+	return "", false
+}
+
+// WithLocale returns the context with the negotiated locale of the request
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey, locale)
+}
+
+// Locale returns the negotiated locale of the request, empty if it was not negotiated
+func Locale(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey).(string)
+	return locale
+}
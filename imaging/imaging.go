@@ -0,0 +1,203 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+const (
+	JPEG = "image/jpeg"
+	PNG  = "image/png"
+	GIF  = "image/gif"
+
+	// maxPixels limits the images that are decoded, e.g. the small files that decompress to huge images
+	maxPixels = 50_000_000
+	// jpegQuality is the quality of the encoded JPEG variants
+	jpegQuality = 85
+)
+
+var (
+	// ErrUnsupported is returned for the content types that are not processed
+	ErrUnsupported = errors.New("unsupported image format")
+	// ErrTooLarge is returned for the images with more than 50 megapixels
+	ErrTooLarge = errors.New("image is too large")
+)
+
+// Size is the box a variant fits in, the aspect ratio of the image is kept
+type Size struct {
+	Width  int
+	Height int
+}
+
+// ParseSize parses the sizes given as <width>x<height>, e.g. 200x200
+func ParseSize(value string) (Size, error) {
+	width, height, ok := strings.Cut(value, "x")
+	if ok {
+		w, wErr := strconv.Atoi(width)
+		h, hErr := strconv.Atoi(height)
+		if wErr == nil && hErr == nil && w > 0 && h > 0 {
+			return Size{Width: w, Height: h}, nil
+		}
+	}
+	return Size{}, fmt.Errorf("invalid image size %q, expected <width>x<height>", value)
+}
+
+// Supported reports whether images of the content type are processed, JPEG, PNG and GIF
+func Supported(contentType string) bool {
+	switch mediaType(contentType) {
+	case JPEG, PNG, GIF:
+		return true
+	}
+	return false
+}
+
+// VariantType returns the content type of the variants of the images of the content type, JPEG for JPEG and PNG
+// for the others
+func VariantType(contentType string) string {
+	if mediaType(contentType) == JPEG {
+		return JPEG
+	}
+	return PNG
+}
+
+// Variant decodes the image, turns it upright by its EXIF orientation and scales it down to fit the size. Images
+// that fit already are not scaled up. The variant is encoded in the format of VariantType without any metadata,
+// the variants of animated GIFs have the first frame.
+func Variant(data []byte, contentType string, size Size) ([]byte, error) {
+	if !Supported(contentType) {
+		return nil, ErrUnsupported
+	}
+	img, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType(contentType) == JPEG {
+		img = orient(img, jpegOrientation(data))
+	}
+	img = fit(img, size)
+	return encode(img, VariantType(contentType))
+}
+
+// decode decodes the image after checking its dimensions
+func decode(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooLarge, config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+	return img, nil
+}
+
+// encode encodes the image as JPEG or PNG
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var buffer bytes.Buffer
+	var err error
+	if contentType == JPEG {
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buffer, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// fit scales the image down to fit the size, every pixel of the result is the average of the pixels it covers
+func fit(img image.Image, size Size) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	scale := min(float64(size.Width)/float64(width), float64(size.Height)/float64(height))
+	if scale >= 1 {
+		return img
+	}
+	targetWidth := max(1, int(float64(width)*scale+0.5))
+	targetHeight := max(1, int(float64(height)*scale+0.5))
+
+	source := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(source, source.Bounds(), img, bounds.Min, draw.Src)
+	target := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		top, bottom := y*height/targetHeight, max((y+1)*height/targetHeight, y*height/targetHeight+1)
+		for x := 0; x < targetWidth; x++ {
+			left, right := x*width/targetWidth, max((x+1)*width/targetWidth, x*width/targetWidth+1)
+			var sum [4]int
+			for sy := top; sy < bottom; sy++ {
+				offset := source.PixOffset(left, sy)
+				for sx := left; sx < right; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(source.Pix[offset+c])
+					}
+					offset += 4
+				}
+			}
+			count := (bottom - top) * (right - left)
+			offset := target.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				target.Pix[offset+c] = uint8((sum[c] + count/2) / count)
+			}
+		}
+	}
+	return target
+}
+
+// orient turns the image upright by the EXIF orientation, 1 is upright and 2 to 8 are the flips and rotations
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	targetWidth, targetHeight := width, height
+	if orientation >= 5 {
+		targetWidth, targetHeight = height, width
+	}
+	target := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		for x := 0; x < targetWidth; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = width-1-x, y
+			case 3:
+				sx, sy = width-1-x, height-1-y
+			case 4:
+				sx, sy = x, height-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, height-1-x
+			case 7:
+				sx, sy = width-1-y, height-1-x
+			case 8:
+				sx, sy = width-1-y, x
+			}
+			target.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return target
+}
+
+// mediaType returns the media type of the content type without its parameters
+func mediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
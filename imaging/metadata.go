@@ -0,0 +1,153 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// errInvalidJPEG is returned for the JPEG files whose segments cannot be read
+var errInvalidJPEG = errors.New("invalid image: malformed JPEG segments")
+
+// StripMetadata removes the metadata that may reveal the owner, e.g. the GPS position and the camera of a photo:
+// the EXIF, XMP and IPTC segments of JPEG files and the EXIF and text chunks of PNG files. The pixels are kept
+// unchanged, except for the JPEG files with an EXIF orientation. They are re-encoded upright since the
+// orientation is removed with the EXIF. GIF files have no such metadata and are returned as they are.
+func StripMetadata(data []byte, contentType string) ([]byte, error) {
+	switch mediaType(contentType) {
+	case JPEG:
+		if orientation := jpegOrientation(data); orientation > 1 && orientation <= 8 {
+			img, err := decode(data)
+			if err != nil {
+				return nil, err
+			}
+			return encode(orient(img, orientation), JPEG)
+		}
+		return stripJPEG(data)
+	case PNG:
+		return stripPNG(data)
+	case GIF:
+		return data, nil
+	}
+	return nil, ErrUnsupported
+}
+
+// jpegSegments calls fn with the marker and the content of every segment of the JPEG file up to the start of the
+// scan, the offset of the scan is returned
+func jpegSegments(data []byte, fn func(marker byte, segment []byte)) (int, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, errInvalidJPEG
+	}
+	offset := 2
+	for offset+4 <= len(data) {
+		if data[offset] != 0xFF {
+			return 0, errInvalidJPEG
+		}
+		marker := data[offset+1]
+		if marker == 0xFF {
+			// Fill byte before the marker
+			offset++
+			continue
+		}
+		if marker == 0xDA {
+			return offset, nil
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if length < 2 || offset+2+length > len(data) {
+			return 0, errInvalidJPEG
+		}
+		fn(marker, data[offset:offset+2+length])
+		offset += 2 + length
+	}
+	return 0, errInvalidJPEG
+}
+
+// stripJPEG removes the APP1 segments, EXIF and XMP, and the APP13 segments, IPTC, of the JPEG file
+func stripJPEG(data []byte) ([]byte, error) {
+	stripped := make([]byte, 0, len(data))
+	stripped = append(stripped, 0xFF, 0xD8)
+	scan, err := jpegSegments(data, func(marker byte, segment []byte) {
+		if marker != 0xE1 && marker != 0xED {
+			stripped = append(stripped, segment...)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(stripped, data[scan:]...), nil
+}
+
+// jpegOrientation returns the EXIF orientation of the JPEG file, 1 when it has none
+func jpegOrientation(data []byte) int {
+	orientation := 1
+	jpegSegments(data, func(marker byte, segment []byte) {
+		exif, ok := bytes.CutPrefix(segment[4:], []byte("Exif\x00\x00"))
+		if marker == 0xE1 && ok {
+			if value, found := exifOrientation(exif); found {
+				orientation = value
+			}
+		}
+	})
+	return orientation
+}
+
+// exifOrientation reads the orientation tag of the first image file directory of the EXIF TIFF structure
+func exifOrientation(tiff []byte) (int, bool) {
+	if len(tiff) < 8 {
+		return 0, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 0, false
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0, false
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:])), true
+		}
+	}
+	return 0, false
+}
+
+// stripPNG removes the eXIf, tEXt, zTXt, iTXt and tIME chunks of the PNG file
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("invalid image: missing PNG signature")
+	}
+	stripped := make([]byte, 0, len(data))
+	stripped = append(stripped, pngSignature...)
+	offset := len(pngSignature)
+	for offset < len(data) {
+		if offset+12 > len(data) {
+			return nil, errors.New("invalid image: truncated PNG chunk")
+		}
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		end := offset + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errors.New("invalid image: truncated PNG chunk")
+		}
+		switch string(data[offset+4 : offset+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			stripped = append(stripped, data[offset:end]...)
+		}
+		offset = end
+	}
+	return stripped, nil
+}
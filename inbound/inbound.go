@@ -0,0 +1,148 @@
+package inbound
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeTolerance is the default age of the Stripe signatures, as in the Stripe libraries
+const stripeTolerance = 5 * time.Minute
+
+// ErrSignature is returned for the deliveries whose signature is missing or does not match
+var ErrSignature = errors.New("invalid webhook signature")
+
+// Verifier checks that a delivery was sent by the provider of the webhook, from its headers and its raw body
+type Verifier interface {
+	Verify(header http.Header, body []byte) error
+}
+
+// SecretHeaders is implemented by the verifiers whose headers carry a secret, the headers are not kept with the
+// deliveries
+type SecretHeaders interface {
+	Headers() []string
+}
+
+// GitHub verifies the X-Hub-Signature-256 header of GitHub, the HMAC-SHA256 of the body with the secret of the
+// webhook
+type GitHub struct {
+	Secret string
+}
+
+// Verify checks the signature of the GitHub delivery
+func (github GitHub) Verify(header http.Header, body []byte) error {
+	signature, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok || !validMAC(github.Secret, body, signature) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Stripe verifies the Stripe-Signature header of Stripe, the HMAC-SHA256 of the timestamp and of the body with the
+// signing secret of the endpoint. Signatures older than Tolerance are rejected against replays, 5 minutes without it.
+type Stripe struct {
+	Secret    string
+	Tolerance time.Duration
+}
+
+// Verify checks the signature and the timestamp of the Stripe delivery
+func (stripe Stripe) Verify(header http.Header, body []byte) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignature
+	}
+	tolerance := stripe.Tolerance
+	if tolerance <= 0 {
+		tolerance = stripeTolerance
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return ErrSignature
+	}
+	payload := append([]byte(timestamp+"."), body...)
+	for _, signature := range signatures {
+		if validMAC(stripe.Secret, payload, signature) {
+			return nil
+		}
+	}
+	return ErrSignature
+}
+
+// Keycloak verifies the X-Keycloak-Signature header of the webhooks of the Keycloak admin and login events, the
+// HMAC-SHA256 of the body with the secret of the webhook, as sent by the keycloak-events extension
+type Keycloak struct {
+	Secret string
+}
+
+// Verify checks the signature of the Keycloak delivery
+func (keycloak Keycloak) Verify(header http.Header, body []byte) error {
+	if !validMAC(keycloak.Secret, body, header.Get("X-Keycloak-Signature")) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// HMAC verifies the hex HMAC-SHA256 of the body in the header, after the prefix, e.g. for the providers that sign
+// like GitHub with other headers
+type HMAC struct {
+	Header string
+	Prefix string
+	Secret string
+}
+
+// Verify checks the signature of the header
+func (mac HMAC) Verify(header http.Header, body []byte) error {
+	signature, ok := strings.CutPrefix(header.Get(mac.Header), mac.Prefix)
+	if !ok || !validMAC(mac.Secret, body, signature) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Token verifies the shared secret sent in the header, e.g. X-Gitlab-Token of GitLab, for the providers that do
+// not sign the deliveries
+type Token struct {
+	Header string
+	Token  string
+}
+
+// Verify checks the token of the header
+func (token Token) Verify(header http.Header, body []byte) error {
+	if token.Token == "" || subtle.ConstantTimeCompare([]byte(header.Get(token.Header)), []byte(token.Token)) != 1 {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Headers returns the header of the token
+func (token Token) Headers() []string {
+	return []string{token.Header}
+}
+
+// validMAC compares the hex signature with the HMAC-SHA256 of the payload in constant time, empty secrets never
+// match
+func validMAC(secret string, payload []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if secret == "" || err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
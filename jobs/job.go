@@ -0,0 +1,95 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	PENDING   = "pending"
+	RUNNING   = "running"
+	COMPLETED = "completed"
+	FAILED    = "failed"
+)
+
+// Job is a long running operation executed in the background, callers poll its status
+type Job struct {
+	ID          uuid.UUID  `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	UserID      *uuid.UUID `json:"user_id,omitempty"`
+	Kind        string     `json:"kind"`
+	Resource    string     `json:"resource"`
+	Status      string     `json:"status"`
+	Processed   int64      `json:"processed"`
+	Total       int64      `json:"total"`
+	Failed      int64      `json:"failed"`
+	Error       string     `json:"error,omitempty"`
+	Errors      []string   `json:"errors,omitempty" gorm:"serializer:json"`
+	Permissions []string   `json:"-" gorm:"serializer:json"`
+	Parameters  string     `json:"-" gorm:"type:jsonb"`
+	Artifact    string     `json:"-"`
+	// HeartbeatAt is refreshed by the worker running the job, the jobs without it for JOBS_STALE_AFTER are retried
+	HeartbeatAt *time.Time `json:"-"`
+	// Attempt counts the claims of the job, the workers of the earlier claims stop and their updates are ignored
+	Attempt int `json:"-"`
+	// Result is the JSON outcome of the long-running operations, e.g. the created object
+	Result json.RawMessage `json:"-" gorm:"serializer:json"`
+}
+
+// TableName returns the jobs table name
+func (j *Job) TableName() string {
+	return "jobs"
+}
+
+// maxErrors limits the item errors kept in a job
+const maxErrors = 100
+
+// Progress stores the processed, total and failed counters of a running job
+type Progress func(ctx context.Context, processed, total, failed int64) error
+
+// Handler executes a job of a kind, errors fail the job
+type Handler func(ctx context.Context, job *Job, progress Progress) error
+
+// NewJob creates a pending job
+func NewJob(kind, resource string, userID *uuid.UUID, permissions []string, parameters string) (*Job, error) {
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	if parameters == "" {
+		parameters = "{}"
+	}
+	return &Job{
+		ID:          uid,
+		UserID:      userID,
+		Kind:        kind,
+		Resource:    resource,
+		Status:      PENDING,
+		Permissions: permissions,
+		Parameters:  parameters,
+	}, nil
+}
+
+// AddError keeps the error of an item of the job, up to a limit
+func (job *Job) AddError(err error) {
+	if len(job.Errors) < maxErrors {
+		job.Errors = append(job.Errors, err.Error())
+	}
+}
+
+// Load returns the job with the given ID
+func Load(ctx context.Context, db *gorm.DB, uid uuid.UUID) (*Job, error) {
+	job := &Job{}
+	err := db.WithContext(ctx).First(job, "id = ?", uid).Error
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
@@ -0,0 +1,231 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/respitectx"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrClaimLost is returned by the progress of the jobs that another worker claimed since, e.g. after a pause of
+// the worker longer than JOBS_STALE_AFTER
+var ErrClaimLost = errors.New("job claimed by another worker")
+
+// Runner executes pending jobs from the jobs table. Jobs are claimed with row locks, so several
+// replicas can run workers, and jobs of crashed workers are retried once their heartbeat is stale.
+// The claims are counted in the attempts of the jobs, so that the workers of the earlier claims stop.
+type Runner struct {
+	DB       *gorm.DB
+	Config   cfg.Jobs
+	mutex    sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewRunner creates a runner, zero values in the configuration are replaced by defaults
+func NewRunner(db *gorm.DB, config cfg.Jobs) *Runner {
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.StaleAfter <= 0 {
+		config.StaleAfter = 10 * time.Minute
+	}
+	return &Runner{
+		DB:       db,
+		Config:   config,
+		handlers: map[string]Handler{},
+	}
+}
+
+// Register sets the handler executing jobs of the kind
+func (runner *Runner) Register(kind string, handler Handler) {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	runner.handlers[kind] = handler
+}
+
+// Enqueue stores the job, it is executed by the next free worker
+func (runner *Runner) Enqueue(ctx context.Context, job *Job) error {
+	runner.mutex.RLock()
+	_, ok := runner.handlers[job.Kind]
+	runner.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("unsupported job kind: %s", job.Kind)
+	}
+	return runner.DB.WithContext(ctx).Create(job).Error
+}
+
+// Run starts the workers and blocks until the context is cancelled and running jobs are finished
+func (runner *Runner) Run(ctx context.Context) {
+	slog.Info("Job runner started", "workers", runner.Config.Workers)
+	var group sync.WaitGroup
+	for range runner.Config.Workers {
+		group.Go(func() {
+			runner.work(ctx)
+		})
+	}
+	group.Wait()
+	slog.Info("Job runner stopped")
+}
+
+// work claims and executes jobs until the context is cancelled
+func (runner *Runner) work(ctx context.Context) {
+	ticker := time.NewTicker(runner.Config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// Execute jobs without waiting for the next tick while there are pending ones
+		for ctx.Err() == nil {
+			job, err := runner.claim(ctx)
+			if err != nil {
+				slog.Error("Error claiming job", "error", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			runner.execute(ctx, job)
+		}
+	}
+}
+
+// claim marks the oldest pending job, or running job without heartbeat for JOBS_STALE_AFTER, as running
+func (runner *Runner) claim(ctx context.Context) (*Job, error) {
+	var claimed *Job
+	err := domain.Transaction(runner.DB.WithContext(ctx), func(tx *gorm.DB) error {
+		claimed = nil
+		job := &Job{}
+		stale := time.Now().Add(-runner.Config.StaleAfter)
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? OR (status = ? AND COALESCE(heartbeat_at, updated_at) < ?)", PENDING, RUNNING, stale).
+			Order("created_at").
+			Limit(1).
+			Find(job)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		now := time.Now()
+		job.Attempt++
+		err := tx.Model(job).Updates(map[string]any{"status": RUNNING, "started_at": now, "heartbeat_at": now, "attempt": job.Attempt, "updated_at": now}).Error
+		if err != nil {
+			return err
+		}
+		claimed = job
+		return nil
+	})
+	return claimed, err
+}
+
+// heartbeat refreshes the heartbeat of the running job every third of JOBS_STALE_AFTER until the context ends,
+// and cancels the job when another worker claimed it
+func (runner *Runner) heartbeat(ctx context.Context, cancel context.CancelCauseFunc, job *Job) {
+	ticker := time.NewTicker(runner.Config.StaleAfter / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		result := runner.claimed(ctx, job).Update("heartbeat_at", time.Now())
+		if result.Error != nil {
+			slog.Warn("Error refreshing job heartbeat", "job", job.ID, "error", result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			cancel(ErrClaimLost)
+			return
+		}
+	}
+}
+
+// claimed scopes the updates to the job while it is claimed by the worker
+func (runner *Runner) claimed(ctx context.Context, job *Job) *gorm.DB {
+	return runner.DB.WithContext(ctx).Model(job).Where("attempt = ?", job.Attempt)
+}
+
+// execute runs the job handler and stores the outcome
+func (runner *Runner) execute(ctx context.Context, job *Job) {
+	logger := slog.Default().With("job", job.ID, "kind", job.Kind, "resource", job.Resource, "attempt", job.Attempt)
+	jobCtx, cancel := context.WithCancelCause(respitectx.WithLogger(ctx, logger))
+	defer cancel(nil)
+	go runner.heartbeat(jobCtx, cancel, job)
+	runner.mutex.RLock()
+	handler, ok := runner.handlers[job.Kind]
+	runner.mutex.RUnlock()
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("unsupported job kind: %s", job.Kind)
+	} else {
+		logger.Info("Job started")
+		err = runner.safeExecute(jobCtx, handler, job)
+	}
+	if errors.Is(err, ErrClaimLost) || errors.Is(context.Cause(jobCtx), ErrClaimLost) {
+		logger.Warn("Job stopped, it was claimed by another worker")
+		return
+	}
+
+	now := time.Now()
+	updates := map[string]any{"status": COMPLETED, "finished_at": now, "updated_at": now}
+	if err != nil {
+		logger.Error("Job failed", "error", err)
+		updates["status"] = FAILED
+		updates["error"] = err.Error()
+	} else {
+		logger.Info("Job completed", "processed", job.Processed, "failed", job.Failed)
+	}
+	// Store the outcome even if the runner is stopping
+	err = runner.claimed(context.WithoutCancel(ctx), job).Updates(updates).Error
+	if err != nil {
+		logger.Error("Error storing job status", "error", err)
+	}
+}
+
+// safeExecute runs the handler and turns panics into errors
+func (runner *Runner) safeExecute(ctx context.Context, handler Handler, job *Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+	return handler(ctx, job, func(ctx context.Context, processed, total, failed int64) error {
+		job.Processed, job.Total, job.Failed = processed, total, failed
+		now := time.Now()
+		result := runner.claimed(ctx, job).Updates(map[string]any{
+			"processed":    processed,
+			"total":        total,
+			"failed":       failed,
+			"heartbeat_at": now,
+			"updated_at":   now,
+		})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return ErrClaimLost
+		}
+		return result.Error
+	})
+}
+
+// Save stores the item errors, the artifact and the result of the job, ErrClaimLost is returned when another
+// worker claimed the job since
+func (runner *Runner) Save(ctx context.Context, job *Job) error {
+	result := runner.claimed(ctx, job).Select("errors", "artifact", "result").Updates(job)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrClaimLost
+	}
+	return result.Error
+}
@@ -0,0 +1,189 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Tags of the BER elements of the LDAP messages, RFC 4511
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	tagBindRequest     = 0x60
+	tagBindResponse    = 0x61
+	tagUnbindRequest   = 0x42
+	tagSearchRequest   = 0x63
+	tagSearchEntry     = 0x64
+	tagSearchDone      = 0x65
+	tagSearchReference = 0x73
+	tagSimpleAuth      = 0x80
+
+	// maxElement limits the size of the read elements
+	maxElement = 16 << 20
+)
+
+// errMalformed is returned for the BER elements that cannot be decoded
+var errMalformed = errors.New("malformed LDAP message")
+
+// element is a decoded BER element, the constructed elements have children
+type element struct {
+	tag      byte
+	value    []byte
+	children []element
+}
+
+// encode returns the BER encoding of the element with the content
+func encode(tag byte, content ...[]byte) []byte {
+	length := 0
+	for _, part := range content {
+		length += len(part)
+	}
+	encoded := append([]byte{tag}, encodeLength(length)...)
+	for _, part := range content {
+		encoded = append(encoded, part...)
+	}
+	return encoded
+}
+
+// encodeLength returns the definite length in the short form below 128 and in the long form otherwise
+func encodeLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	var octets []byte
+	for ; length > 0; length >>= 8 {
+		octets = append([]byte{byte(length)}, octets...)
+	}
+	return append([]byte{0x80 | byte(len(octets))}, octets...)
+}
+
+// encodeInteger returns the BER integer or enumerated with the tag
+func encodeInteger(tag byte, value int) []byte {
+	octets := []byte{byte(value)}
+	for value >>= 8; value > 0; value >>= 8 {
+		octets = append([]byte{byte(value)}, octets...)
+	}
+	if octets[0]&0x80 != 0 {
+		octets = append([]byte{0}, octets...)
+	}
+	return encode(tag, octets)
+}
+
+// encodeString returns the BER octet string with the tag
+func encodeString(tag byte, value string) []byte {
+	return encode(tag, []byte(value))
+}
+
+// encodeBoolean returns the BER boolean with the tag
+func encodeBoolean(tag byte, value bool) []byte {
+	if value {
+		return encode(tag, []byte{0xff})
+	}
+	return encode(tag, []byte{0})
+}
+
+// readElement reads the next element of the connection
+func readElement(reader *bufio.Reader) (element, error) {
+	tag, err := reader.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	length, err := readLength(reader)
+	if err != nil {
+		return element{}, err
+	}
+	value := make([]byte, length)
+	_, err = io.ReadFull(reader, value)
+	if err != nil {
+		return element{}, err
+	}
+	return decode(tag, value)
+}
+
+// readLength reads the definite length of an element
+func readLength(reader io.ByteReader) (int, error) {
+	first, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return int(first), nil
+	}
+	count := int(first & 0x7f)
+	if count == 0 || count > 4 {
+		return 0, fmt.Errorf("%w: unsupported length", errMalformed)
+	}
+	length := 0
+	for range count {
+		octet, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(octet)
+	}
+	if length > maxElement {
+		return 0, fmt.Errorf("%w: element of %d bytes", errMalformed, length)
+	}
+	return length, nil
+}
+
+// decode decodes the children of the constructed elements
+func decode(tag byte, value []byte) (element, error) {
+	decoded := element{tag: tag, value: value}
+	if tag&0x20 == 0 {
+		return decoded, nil
+	}
+	for rest := value; len(rest) > 0; {
+		if len(rest) < 2 {
+			return element{}, errMalformed
+		}
+		childTag := rest[0]
+		reader := &sliceReader{data: rest[1:]}
+		length, err := readLength(reader)
+		if err != nil {
+			return element{}, err
+		}
+		start := 1 + reader.offset
+		if length > len(rest)-start {
+			return element{}, errMalformed
+		}
+		child, err := decode(childTag, rest[start:start+length])
+		if err != nil {
+			return element{}, err
+		}
+		decoded.children = append(decoded.children, child)
+		rest = rest[start+length:]
+	}
+	return decoded, nil
+}
+
+// integer returns the value of the integer or enumerated element
+func (decoded element) integer() int {
+	value := 0
+	for _, octet := range decoded.value {
+		value = value<<8 | int(octet)
+	}
+	return value
+}
+
+// sliceReader reads the bytes of a slice and counts them
+type sliceReader struct {
+	data   []byte
+	offset int
+}
+
+func (reader *sliceReader) ReadByte() (byte, error) {
+	if reader.offset >= len(reader.data) {
+		return 0, errMalformed
+	}
+	octet := reader.data[reader.offset]
+	reader.offset++
+	return octet, nil
+}
@@ -0,0 +1,214 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Tags of the filters of the search requests
+const (
+	filterAnd            = 0xa0
+	filterOr             = 0xa1
+	filterNot            = 0xa2
+	filterEquality       = 0xa3
+	filterSubstrings     = 0xa4
+	filterGreaterOrEqual = 0xa5
+	filterLessOrEqual    = 0xa6
+	filterPresent        = 0x87
+	filterApprox         = 0xa8
+	filterExtensible     = 0xa9
+)
+
+// EscapeFilter escapes the value for a filter, RFC 4515, e.g. the usernames substituted into the filters
+func EscapeFilter(value string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch octet := value[i]; octet {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&escaped, "\\%02x", octet)
+		default:
+			escaped.WriteByte(octet)
+		}
+	}
+	return escaped.String()
+}
+
+// compileFilter returns the BER encoding of the string filter, e.g. (&(objectClass=person)(uid=alice)). The
+// and, or, not, equality, substrings, ordering, presence, approximate and extensible filters are supported.
+func compileFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+	compiled, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid filter %q, unexpected %q", filter, rest)
+	}
+	return compiled, nil
+}
+
+// parseFilter parses the parenthesized filter at the start of the text and returns the rest
+func parseFilter(text string) ([]byte, string, error) {
+	if !strings.HasPrefix(text, "(") {
+		return nil, "", fmt.Errorf("invalid filter, expected ( at %q", text)
+	}
+	text = text[1:]
+	if text == "" {
+		return nil, "", fmt.Errorf("invalid filter, unexpected end")
+	}
+	switch text[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if text[0] == '|' {
+			tag = filterOr
+		}
+		var children [][]byte
+		rest := text[1:]
+		for strings.HasPrefix(rest, "(") {
+			child, next, err := parseFilter(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+			rest = next
+		}
+		if !strings.HasPrefix(rest, ")") || len(children) == 0 {
+			return nil, "", fmt.Errorf("invalid filter, expected a list of filters at %q", text)
+		}
+		return encode(tag, children...), rest[1:], nil
+	case '!':
+		child, rest, err := parseFilter(text[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("invalid filter, expected ) at %q", rest)
+		}
+		return encode(filterNot, child), rest[1:], nil
+	}
+	end := strings.IndexByte(text, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("invalid filter, missing ) in %q", text)
+	}
+	compiled, err := parseItem(text[:end])
+	if err != nil {
+		return nil, "", err
+	}
+	return compiled, text[end+1:], nil
+}
+
+// parseItem parses a comparison of an attribute, e.g. uid=alice, cn=adm*, mail=* or
+// member:1.2.840.113556.1.4.1941:=cn=alice,dc=example,dc=com
+func parseItem(item string) ([]byte, error) {
+	equals := strings.IndexByte(item, '=')
+	if equals <= 0 {
+		return nil, fmt.Errorf("invalid filter item %q", item)
+	}
+	attribute, value := item[:equals], item[equals+1:]
+	tag := byte(filterEquality)
+	switch attribute[len(attribute)-1] {
+	case '>':
+		tag, attribute = filterGreaterOrEqual, attribute[:len(attribute)-1]
+	case '<':
+		tag, attribute = filterLessOrEqual, attribute[:len(attribute)-1]
+	case '~':
+		tag, attribute = filterApprox, attribute[:len(attribute)-1]
+	case ':':
+		return parseExtensible(attribute[:len(attribute)-1], value)
+	}
+	if attribute == "" {
+		return nil, fmt.Errorf("invalid filter item %q", item)
+	}
+	if tag == filterEquality && value == "*" {
+		return encodeString(filterPresent, attribute), nil
+	}
+	if tag == filterEquality && strings.Contains(value, "*") {
+		return parseSubstrings(attribute, value)
+	}
+	unescaped, err := unescapeFilter(value)
+	if err != nil {
+		return nil, err
+	}
+	return encode(tag, encodeString(tagOctetString, attribute), encodeString(tagOctetString, unescaped)), nil
+}
+
+// parseSubstrings parses the value with wildcards of a substrings filter
+func parseSubstrings(attribute, value string) ([]byte, error) {
+	parts := strings.Split(value, "*")
+	var substrings [][]byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		unescaped, err := unescapeFilter(part)
+		if err != nil {
+			return nil, err
+		}
+		tag := byte(0x81)
+		switch i {
+		case 0:
+			tag = 0x80
+		case len(parts) - 1:
+			tag = 0x82
+		}
+		substrings = append(substrings, encodeString(tag, unescaped))
+	}
+	return encode(filterSubstrings, encodeString(tagOctetString, attribute), encode(tagSequence, substrings...)), nil
+}
+
+// parseExtensible parses the extensible filter of the attribute, its matching rule and dn flag and the value
+func parseExtensible(description, value string) ([]byte, error) {
+	parts := strings.Split(description, ":")
+	var content [][]byte
+	attribute, rule, dnAttributes := parts[0], "", false
+	for _, part := range parts[1:] {
+		if strings.EqualFold(part, "dn") {
+			dnAttributes = true
+		} else {
+			rule = part
+		}
+	}
+	if rule != "" {
+		content = append(content, encodeString(0x81, rule))
+	}
+	if attribute != "" {
+		content = append(content, encodeString(0x82, attribute))
+	}
+	unescaped, err := unescapeFilter(value)
+	if err != nil {
+		return nil, err
+	}
+	content = append(content, encodeString(0x83, unescaped))
+	if dnAttributes {
+		content = append(content, encodeBoolean(0x84, true))
+	}
+	return encode(filterExtensible, content...), nil
+}
+
+// unescapeFilter replaces the \XX escapes of a filter value with their bytes
+func unescapeFilter(value string) (string, error) {
+	if !strings.Contains(value, "\\") {
+		return value, nil
+	}
+	var unescaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			unescaped.WriteByte(value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return "", fmt.Errorf("invalid escape in filter value %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in filter value %q", value)
+		}
+		unescaped.Write(decoded)
+		i += 2
+	}
+	return unescaped.String(), nil
+}
@@ -0,0 +1,300 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+)
+
+const (
+	// resultSuccess is the result code of the successful operations
+	resultSuccess = 0
+	// scopeSubtree searches the base object and all its descendants
+	scopeSubtree = 2
+	// searchSizeLimit limits the groups of a user found by a search
+	searchSizeLimit = 1000
+)
+
+// ErrUserNotFound is returned when the filter of the users finds no user
+var ErrUserNotFound = errors.New("LDAP user not found")
+
+// Client resolves the groups of the users from an LDAP directory, e.g. Active Directory, with a simple bind of
+// the service account
+type Client struct {
+	// URL is ldap://host:389 or ldaps://host:636
+	URL          string
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the user, {username} and {email} are replaced with the escaped values of the user
+	UserFilter string
+	// GroupAttribute is the attribute of the user with the DNs of its groups, e.g. memberOf
+	GroupAttribute string
+	// GroupFilter finds the groups of the user by its DN in {dn} instead of the attribute, e.g. (member={dn})
+	GroupFilter string
+	Timeout     time.Duration
+}
+
+// New creates the client of the configuration, nil when LDAP_URL is not set
+func New(config cfg.LDAP) *Client {
+	if config.URL == "" {
+		return nil
+	}
+	return &Client{
+		URL:            config.URL,
+		BindDN:         config.BindDN,
+		BindPassword:   config.BindPassword,
+		BaseDN:         config.BaseDN,
+		UserFilter:     config.UserFilter,
+		GroupAttribute: config.GroupAttribute,
+		GroupFilter:    config.GroupFilter,
+		Timeout:        config.Timeout,
+	}
+}
+
+// Groups returns the names of the groups of the user, the common names of the group DNs, e.g. Admins of
+// CN=Admins,OU=Groups,DC=example,DC=com
+func (client *Client) Groups(ctx context.Context, username, email string) ([]string, error) {
+	conn, err := client.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to LDAP: %w", err)
+	}
+	defer conn.close()
+	err = conn.bind(client.BindDN, client.BindPassword)
+	if err != nil {
+		return nil, err
+	}
+	filter := strings.NewReplacer("{username}", EscapeFilter(username), "{email}", EscapeFilter(email)).Replace(client.UserFilter)
+	attributes := []string{client.GroupAttribute}
+	if client.GroupFilter != "" {
+		attributes = []string{"1.1"}
+	}
+	entries, err := conn.search(client.BaseDN, filter, attributes, 2)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(entries) == 0:
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	case len(entries) > 1:
+		return nil, fmt.Errorf("LDAP user filter finds several users for %s", username)
+	}
+	var dns []string
+	if client.GroupFilter == "" {
+		for name, values := range entries[0].attributes {
+			if strings.EqualFold(name, client.GroupAttribute) {
+				dns = values
+			}
+		}
+	} else {
+		groupFilter := strings.ReplaceAll(client.GroupFilter, "{dn}", EscapeFilter(entries[0].dn))
+		groups, err := conn.search(client.BaseDN, groupFilter, []string{"1.1"}, searchSizeLimit)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			dns = append(dns, group.dn)
+		}
+	}
+	names := make([]string, 0, len(dns))
+	for _, dn := range dns {
+		names = append(names, CommonName(dn))
+	}
+	return names, nil
+}
+
+// CommonName returns the value of the first RDN of the DN when it is a CN, and the DN otherwise
+func CommonName(dn string) string {
+	var value strings.Builder
+	for i := 0; i < len(dn); i++ {
+		if dn[i] == '\\' && i+1 < len(dn) {
+			i++
+			value.WriteByte(dn[i])
+			continue
+		}
+		if dn[i] == ',' || dn[i] == '+' {
+			break
+		}
+		value.WriteByte(dn[i])
+	}
+	attribute, name, ok := strings.Cut(value.String(), "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(attribute), "cn") {
+		return dn
+	}
+	return strings.TrimSpace(name)
+}
+
+// entry is a found entry with its attributes
+type entry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// connection is a connection to the directory, the operations are sent one after the other
+type connection struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int
+}
+
+// dial connects to the directory of the URL, with TLS for ldaps, the deadline of the connection is the timeout
+// or the one of the context
+func (client *Client) dial(ctx context.Context) (*connection, error) {
+	address, err := url.Parse(client.URL)
+	if err != nil {
+		return nil, err
+	}
+	host := address.Host
+	if address.Port() == "" {
+		port := "389"
+		if address.Scheme == "ldaps" {
+			port = "636"
+		}
+		host = net.JoinHostPort(address.Hostname(), port)
+	}
+	if client.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.Timeout)
+		defer cancel()
+	}
+	var conn net.Conn
+	if address.Scheme == "ldaps" {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: address.Hostname(), MinVersion: tls.VersionTLS12}}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return &connection{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// send writes the operation in a message with the next message ID
+func (conn *connection) send(operation []byte) error {
+	conn.messageID++
+	_, err := conn.conn.Write(encode(tagSequence, encodeInteger(tagInteger, conn.messageID), operation))
+	return err
+}
+
+// receive reads the operation of the next message
+func (conn *connection) receive() (element, error) {
+	message, err := readElement(conn.reader)
+	if err != nil {
+		return element{}, fmt.Errorf("cannot read LDAP response: %w", err)
+	}
+	if message.tag != tagSequence || len(message.children) < 2 {
+		return element{}, errMalformed
+	}
+	return message.children[1], nil
+}
+
+// bind authenticates the connection with the DN and the password
+func (conn *connection) bind(dn, password string) error {
+	err := conn.send(encode(tagBindRequest, encodeInteger(tagInteger, 3), encodeString(tagOctetString, dn), encodeString(tagSimpleAuth, password)))
+	if err != nil {
+		return fmt.Errorf("cannot send LDAP bind: %w", err)
+	}
+	response, err := conn.receive()
+	if err != nil {
+		return err
+	}
+	if response.tag != tagBindResponse {
+		return fmt.Errorf("%w: unexpected response to bind", errMalformed)
+	}
+	return result("bind", response)
+}
+
+// search returns the entries of the subtree of the base that match the filter, with the attributes
+func (conn *connection) search(base, filter string, attributes []string, sizeLimit int) ([]entry, error) {
+	compiled, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var selection [][]byte
+	for _, attribute := range attributes {
+		selection = append(selection, encodeString(tagOctetString, attribute))
+	}
+	err = conn.send(encode(tagSearchRequest,
+		encodeString(tagOctetString, base),
+		encodeInteger(tagEnumerated, scopeSubtree),
+		encodeInteger(tagEnumerated, 0),
+		encodeInteger(tagInteger, sizeLimit),
+		encodeInteger(tagInteger, 0),
+		encodeBoolean(tagBoolean, false),
+		compiled,
+		encode(tagSequence, selection...),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("cannot send LDAP search: %w", err)
+	}
+	var entries []entry
+	for {
+		response, err := conn.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch response.tag {
+		case tagSearchEntry:
+			found, err := parseEntry(response)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, found)
+		case tagSearchReference:
+			// The referrals to other directories are not followed
+		case tagSearchDone:
+			return entries, result("search", response)
+		default:
+			return nil, fmt.Errorf("%w: unexpected response to search", errMalformed)
+		}
+	}
+}
+
+// close unbinds and closes the connection
+func (conn *connection) close() {
+	conn.send(encode(tagUnbindRequest))
+	conn.conn.Close()
+}
+
+// parseEntry reads the DN and the attributes of a search result entry
+func parseEntry(response element) (entry, error) {
+	if len(response.children) < 2 {
+		return entry{}, errMalformed
+	}
+	found := entry{dn: string(response.children[0].value), attributes: map[string][]string{}}
+	for _, attribute := range response.children[1].children {
+		if len(attribute.children) < 2 {
+			return entry{}, errMalformed
+		}
+		name := string(attribute.children[0].value)
+		for _, value := range attribute.children[1].children {
+			found.attributes[name] = append(found.attributes[name], string(value.value))
+		}
+	}
+	return found, nil
+}
+
+// result returns the error of the LDAPResult of the response, nil for success
+func result(operation string, response element) error {
+	if len(response.children) < 3 {
+		return errMalformed
+	}
+	code := response.children[0].integer()
+	if code == resultSuccess {
+		return nil
+	}
+	return fmt.Errorf("LDAP %s failed with result %d: %s", operation, code, response.children[2].value)
+}
@@ -0,0 +1,62 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dzahariev/respite/domain"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is the declared configuration of an instance, e.g. rendered by an operator from the spec of its custom
+// resource into a mounted ConfigMap. Applying it again gives the same instance, so that it is reconciled on changes.
+type Manifest struct {
+	// Generation identifies the declared configuration, e.g. the metadata.generation of the custom resource, it is
+	// reported as the observed generation once the manifest is applied
+	Generation int64 `yaml:"generation" json:"generation"`
+	// Resources are the resources of the catalog of the application that are registered, the other resources of
+	// the catalog are unregistered
+	Resources []string `yaml:"resources" json:"resources"`
+	// Roles replace the permissions of the roles mapped by the application, the other roles keep them
+	Roles map[string][]string `yaml:"roles" json:"roles,omitempty"`
+	// Webhooks replace the webhooks of the resources by the resource name
+	Webhooks map[string]Webhook `yaml:"webhooks" json:"webhooks,omitempty"`
+}
+
+// Webhook is the webhook of a resource, see domain.Webhook
+type Webhook struct {
+	URL           string        `yaml:"url" json:"url"`
+	Timeout       time.Duration `yaml:"timeout" json:"timeout,omitempty"`
+	FailurePolicy string        `yaml:"failure_policy" json:"failure_policy,omitempty"`
+}
+
+// Domain returns the webhook of the resources
+func (w Webhook) Domain() domain.Webhook {
+	return domain.Webhook{URL: w.URL, Timeout: w.Timeout, FailurePolicy: w.FailurePolicy}
+}
+
+// Load reads the manifest of the YAML or JSON file and returns it with the digest of the file, the digest changes
+// with the content of the file
+func Load(path string) (*Manifest, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot read manifest: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	digest := hex.EncodeToString(hash[:])
+	manifest := &Manifest{}
+	err = yaml.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, digest, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	for name, webhook := range manifest.Webhooks {
+		err = webhook.Domain().Check()
+		if err != nil {
+			return nil, digest, fmt.Errorf("invalid manifest %s: webhook of %s: %w", path, name, err)
+		}
+	}
+	return manifest, digest, nil
+}
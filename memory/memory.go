@@ -0,0 +1,248 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+var _ common.Repository = (*Repository)(nil)
+
+// Repository keeps the objects in maps per resource, it is not shared between replicas and is lost on restart.
+// Objects are copied when they are stored and loaded, belongs-to relations are resolved for the preloads.
+type Repository struct {
+	mutex   sync.RWMutex
+	objects map[string]map[uuid.UUID]reflect.Value
+}
+
+// New creates an empty repository
+func New() *Repository {
+	return &Repository{objects: map[string]map[uuid.UUID]reflect.Value{}}
+}
+
+// Count returns the number of objects visible within the scopes, pagination is not applied
+func (repository *Repository) Count(ctx context.Context, scopes common.DBScopes, object domain.Object) (int64, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+	return int64(len(repository.visible(scopes, object))), nil
+}
+
+// FindAll returns the page of objects visible within the scopes ordered by creation
+func (repository *Repository) FindAll(ctx context.Context, scopes common.DBScopes, object domain.Object) (*[]domain.Object, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+	values := repository.visible(scopes, object)
+	start := min(max(scopes.Offset, 0), len(values))
+	end := len(values)
+	if scopes.PageSize > 0 {
+		end = min(start+scopes.PageSize, end)
+	}
+	objects := make([]domain.Object, 0, end-start)
+	for _, value := range values[start:end] {
+		loaded := reflect.New(value.Type())
+		loaded.Elem().Set(value)
+		repository.preload(loaded.Elem(), loaded.Interface().(domain.Object).Preloads())
+		objects = append(objects, loaded.Interface().(domain.Object))
+	}
+	return &objects, nil
+}
+
+// FindByID loads the object if it is visible within the scopes
+func (repository *Repository) FindByID(ctx context.Context, scopes common.DBScopes, object domain.Object, uid uuid.UUID) error {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+	value, ok := repository.objects[object.ResourceName()][uid]
+	if !ok || !inScopes(scopes, value) {
+		return gorm.ErrRecordNotFound
+	}
+	target := reflect.ValueOf(object).Elem()
+	target.Set(value)
+	repository.preload(target, object.Preloads())
+	return nil
+}
+
+// Save prepares, validates and stores a new object
+func (repository *Repository) Save(ctx context.Context, object domain.Object) error {
+	err := object.Prepare(ctx)
+	if err != nil {
+		return err
+	}
+	err = object.Validate(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", domain.ErrValidation, err)
+	}
+	now := domain.Now()
+	value := reflect.ValueOf(object).Elem()
+	setTime(value, "CreatedAt", now)
+	setTime(value, "UpdatedAt", now)
+
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	objects, ok := repository.objects[object.ResourceName()]
+	if !ok {
+		objects = map[uuid.UUID]reflect.Value{}
+		repository.objects[object.ResourceName()] = objects
+	}
+	if _, exists := objects[object.GetID()]; exists {
+		return fmt.Errorf("%s %s already exists", object.ResourceName(), object.GetID())
+	}
+	objects[object.GetID()] = copyValue(value)
+	return nil
+}
+
+// Update validates the object and replaces the stored fields that are set, like the database updates
+func (repository *Repository) Update(ctx context.Context, object domain.Object) error {
+	if object.GetID() == uuid.Nil {
+		return fmt.Errorf("cannot update non saved entity")
+	}
+	err := object.Validate(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", domain.ErrValidation, err)
+	}
+	value := reflect.ValueOf(object).Elem()
+	setTime(value, "UpdatedAt", domain.Now())
+
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	stored, ok := repository.objects[object.ResourceName()][object.GetID()]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	updated := copyValue(stored)
+	merge(updated, value)
+	repository.objects[object.ResourceName()][object.GetID()] = updated
+	return nil
+}
+
+// Delete removes the object
+func (repository *Repository) Delete(ctx context.Context, object domain.Object) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	delete(repository.objects[object.ResourceName()], object.GetID())
+	return nil
+}
+
+// visible returns the objects of the resource within the scopes ordered by creation time and ID
+func (repository *Repository) visible(scopes common.DBScopes, object domain.Object) []reflect.Value {
+	values := []reflect.Value{}
+	for _, value := range repository.objects[object.ResourceName()] {
+		if inScopes(scopes, value) {
+			values = append(values, value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		first := values[i].Addr().Interface().(domain.Object)
+		second := values[j].Addr().Interface().(domain.Object)
+		if !first.GetCreatedAt().Equal(*second.GetCreatedAt()) {
+			return first.GetCreatedAt().Before(*second.GetCreatedAt())
+		}
+		return first.GetID().String() < second.GetID().String()
+	})
+	return values
+}
+
+// inScopes checks the ownership and the origin of the object
+func inScopes(scopes common.DBScopes, value reflect.Value) bool {
+	if scopes.OwnedOnly && scopes.User != nil {
+		userID := value.FieldByName("UserID")
+		if !userID.IsValid() || userID.Interface() != scopes.User.ID && !slices.Contains(scopes.Shared, value.FieldByName("ID").Interface().(uuid.UUID)) {
+			return false
+		}
+	}
+	if scopes.Near != nil {
+		field := reflect.Indirect(value.FieldByName(scopes.Near.Location.Name))
+		if !field.IsValid() {
+			return false
+		}
+		location, ok := field.Interface().(domain.Point)
+		if !ok || location.Distance(scopes.Near.Point) > scopes.Near.Radius {
+			return false
+		}
+	}
+	for _, filter := range scopes.Arrays {
+		elements, _ := value.FieldByName(filter.Field.Name).Interface().(domain.Strings)
+		if filter.Operator == "contains" && !elements.Contains(filter.Values...) || filter.Operator == "overlap" && !elements.Overlaps(filter.Values...) {
+			return false
+		}
+	}
+	if originObject, ok := value.Addr().Interface().(domain.OriginObject); ok && !scopes.Origin.IsEmpty() {
+		origin := originObject.GetOrigin()
+		if scopes.Origin.Region != "" && origin.Region != scopes.Origin.Region {
+			return false
+		}
+		for key, label := range scopes.Origin.Labels {
+			if origin.Labels[key] != label {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// preload sets the belongs-to relations named by the preloads, e.g. Category loaded by CategoryID
+func (repository *Repository) preload(value reflect.Value, preloads []string) {
+	for _, name := range preloads {
+		relation := value.FieldByName(name)
+		foreignKey := value.FieldByName(name + "ID")
+		if !relation.IsValid() || !foreignKey.IsValid() || relation.Kind() != reflect.Struct {
+			continue
+		}
+		uid, ok := foreignKey.Interface().(uuid.UUID)
+		if !ok {
+			continue
+		}
+		related, ok := relation.Addr().Interface().(domain.Object)
+		if !ok {
+			continue
+		}
+		stored, ok := repository.objects[related.ResourceName()][uid]
+		if ok {
+			relation.Set(stored)
+		}
+	}
+}
+
+// copyValue copies the struct, so that later changes of the object do not change the stored one
+func copyValue(value reflect.Value) reflect.Value {
+	copied := reflect.New(value.Type()).Elem()
+	copied.Set(value)
+	return copied
+}
+
+// merge sets the fields of the target that are set in the source, embedded structs are merged field by field
+func merge(target, source reflect.Value) {
+	for i := 0; i < source.NumField(); i++ {
+		field := source.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			merge(target.Field(i), source.Field(i))
+			continue
+		}
+		if !source.Field(i).IsZero() {
+			target.Field(i).Set(source.Field(i))
+		}
+	}
+}
+
+// setTime sets a time field of the struct if it exists, both time.Time and *time.Time are supported
+func setTime(value reflect.Value, name string, now time.Time) {
+	field := value.FieldByName(name)
+	switch {
+	case !field.IsValid() || !field.CanSet():
+	case field.Type() == reflect.TypeFor[*time.Time]():
+		field.Set(reflect.ValueOf(&now))
+	case field.Type() == reflect.TypeFor[time.Time]():
+		field.Set(reflect.ValueOf(now))
+	}
+}
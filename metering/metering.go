@@ -0,0 +1,125 @@
+package metering
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/dzahariev/respite/events"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	USER   = "user"
+	TENANT = "tenant"
+)
+
+// ErrNotMetered is returned by the measurements of resources without owners, e.g. the global ones
+var ErrNotMetered = errors.New("resource is not metered")
+
+// Usage is the metered storage of a user in a resource, the number of objects and the bytes of their content
+type Usage struct {
+	UserID    uuid.UUID `gorm:"primaryKey" json:"user_id"`
+	Resource  string    `gorm:"primaryKey" json:"resource"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Rows      int64     `gorm:"column:row_count" json:"rows"`
+	Bytes     int64     `gorm:"column:byte_count" json:"bytes"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName returns the metering table name
+func (u *Usage) TableName() string {
+	return "usage_meter"
+}
+
+// Total is the usage of a user or a tenant in a resource
+type Total struct {
+	Owner    string `json:"owner"`
+	Resource string `json:"resource"`
+	Rows     int64  `gorm:"column:row_count" json:"rows"`
+	Bytes    int64  `gorm:"column:byte_count" json:"bytes"`
+}
+
+// MeasureFunc counts the objects of the user in the resource and the bytes of their content
+type MeasureFunc func(ctx context.Context, resource string, userID uuid.UUID) (rows int64, bytes int64, err error)
+
+// TenantFunc returns the tenant of the user, empty without tenants
+type TenantFunc func(ctx context.Context, userID uuid.UUID) (string, error)
+
+// Meter keeps the usage of the users up to date. It is an events.Publisher, the usage of the user in the
+// resource of a mutation is measured again, so repeated events of the outbox do not count twice. Updates
+// are measured only for the sized resources, whose content may change.
+type Meter struct {
+	DB      *gorm.DB
+	Measure MeasureFunc
+	Tenant  TenantFunc
+	Sized   map[string]bool
+}
+
+// NewMeter creates a meter storing the usage in the usage_meter table
+func NewMeter(db *gorm.DB, measure MeasureFunc, tenant TenantFunc, sized map[string]bool) *Meter {
+	slog.Info("Usage metering initialized")
+	return &Meter{
+		DB:      db,
+		Measure: measure,
+		Tenant:  tenant,
+		Sized:   sized,
+	}
+}
+
+// Publish measures the usage of the user of the event, events without a user are not metered
+func (meter *Meter) Publish(ctx context.Context, event events.Event) error {
+	if event.UserID == nil {
+		return nil
+	}
+	if event.Action == events.UPDATED && !meter.Sized[event.Resource] {
+		return nil
+	}
+	return meter.Refresh(ctx, event.Resource, *event.UserID)
+}
+
+// Refresh measures the usage of the user in the resource and stores it
+func (meter *Meter) Refresh(ctx context.Context, resource string, userID uuid.UUID) error {
+	rows, bytes, err := meter.Measure(ctx, resource, userID)
+	if errors.Is(err, ErrNotMetered) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	usage := &Usage{UserID: userID, Resource: resource, Rows: rows, Bytes: bytes, UpdatedAt: time.Now().UTC()}
+	if meter.Tenant != nil {
+		usage.Tenant, err = meter.Tenant(ctx, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return meter.DB.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(usage).Error
+}
+
+// Report returns the usage summed per user or per tenant and resource, ordered by owner and resource. An
+// empty resource reports all resources.
+func (meter *Meter) Report(ctx context.Context, groupBy string, resource string) ([]Total, error) {
+	owner := "CAST(user_id AS TEXT)"
+	if groupBy == TENANT {
+		owner = "tenant"
+	}
+	query := meter.DB.WithContext(ctx).Model(&Usage{}).
+		Select(owner + " AS owner, resource, SUM(row_count) AS row_count, SUM(byte_count) AS byte_count").
+		Group(owner + ", resource").
+		Order("owner, resource")
+	if resource != "" {
+		query = query.Where("resource = ?", resource)
+	}
+	totals := []Total{}
+	err := query.Scan(&totals).Error
+	return totals, err
+}
+
+// Close does nothing, the database is owned by the server
+func (meter *Meter) Close() error {
+	return nil
+}
@@ -0,0 +1,186 @@
+package metering
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RequestUsage is the number of requests of a user to a resource with a method in a period, and how many of them
+// failed. Anonymous requests are counted for the nil user.
+type RequestUsage struct {
+	Bucket       time.Time `gorm:"primaryKey" json:"bucket"`
+	UserID       uuid.UUID `gorm:"primaryKey" json:"user_id"`
+	Resource     string    `gorm:"primaryKey" json:"resource"`
+	Method       string    `gorm:"primaryKey" json:"method"`
+	Requests     int64     `json:"requests"`
+	ClientErrors int64     `json:"client_errors"`
+	ServerErrors int64     `json:"server_errors"`
+}
+
+// TableName returns the request metering table name
+func (u *RequestUsage) TableName() string {
+	return "request_usage"
+}
+
+// RequestTotal is the number of requests of a user to a resource with a method in the reported period
+type RequestTotal struct {
+	UserID       uuid.UUID `json:"user_id"`
+	Resource     string    `json:"resource"`
+	Method       string    `json:"method"`
+	Requests     int64     `json:"requests"`
+	ClientErrors int64     `json:"client_errors"`
+	ServerErrors int64     `json:"server_errors"`
+	ErrorRate    float64   `gorm:"-" json:"error_rate"`
+}
+
+// RequestFilter selects the requests of a report, the zero values select all of them
+type RequestFilter struct {
+	UserID   uuid.UUID
+	Resource string
+	From     time.Time
+	To       time.Time
+}
+
+// requestKey identifies the counters of a bucket
+type requestKey struct {
+	bucket   time.Time
+	userID   uuid.UUID
+	resource string
+	method   string
+}
+
+// RequestMeter counts the requests in memory and adds the counts to the request_usage table periodically, so the
+// requests do not wait for the database. Several replicas add their counts to the same rows.
+type RequestMeter struct {
+	DB      *gorm.DB
+	Bucket  time.Duration
+	mutex   sync.Mutex
+	pending map[requestKey]*RequestUsage
+}
+
+// NewRequestMeter creates a meter counting the requests in buckets of the duration
+func NewRequestMeter(db *gorm.DB, bucket time.Duration) *RequestMeter {
+	slog.Info("Request metering initialized", "bucket", bucket)
+	return &RequestMeter{
+		DB:      db,
+		Bucket:  bucket,
+		pending: map[requestKey]*RequestUsage{},
+	}
+}
+
+// Record counts a request of the user with its response status
+func (meter *RequestMeter) Record(userID uuid.UUID, resource, method string, status int) {
+	key := requestKey{bucket: time.Now().UTC().Truncate(meter.Bucket), userID: userID, resource: resource, method: method}
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+	usage, ok := meter.pending[key]
+	if !ok {
+		usage = &RequestUsage{Bucket: key.bucket, UserID: userID, Resource: resource, Method: method}
+		meter.pending[key] = usage
+	}
+	usage.Requests++
+	switch {
+	case status >= 500:
+		usage.ServerErrors++
+	case status >= 400:
+		usage.ClientErrors++
+	}
+}
+
+// Flush adds the counted requests to the table, the counts that cannot be stored are kept for the next flush
+func (meter *RequestMeter) Flush(ctx context.Context) error {
+	meter.mutex.Lock()
+	pending := meter.pending
+	meter.pending = map[requestKey]*RequestUsage{}
+	meter.mutex.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	usages := make([]*RequestUsage, 0, len(pending))
+	for _, usage := range pending {
+		usages = append(usages, usage)
+	}
+	err := meter.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "bucket"}, {Name: "user_id"}, {Name: "resource"}, {Name: "method"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"requests":      gorm.Expr("request_usage.requests + excluded.requests"),
+			"client_errors": gorm.Expr("request_usage.client_errors + excluded.client_errors"),
+			"server_errors": gorm.Expr("request_usage.server_errors + excluded.server_errors"),
+		}),
+	}).Create(&usages).Error
+	if err != nil {
+		meter.restore(pending)
+	}
+	return err
+}
+
+// restore adds the counts that were not stored to the pending counts
+func (meter *RequestMeter) restore(counts map[requestKey]*RequestUsage) {
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+	for key, usage := range counts {
+		pending, ok := meter.pending[key]
+		if !ok {
+			meter.pending[key] = usage
+			continue
+		}
+		pending.Requests += usage.Requests
+		pending.ClientErrors += usage.ClientErrors
+		pending.ServerErrors += usage.ServerErrors
+	}
+}
+
+// Run flushes the counts at the interval until the context is canceled
+func (meter *RequestMeter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := meter.Flush(ctx)
+			if err != nil {
+				slog.Error("Error storing request counts", "error", err)
+			}
+		}
+	}
+}
+
+// Report returns the requests summed per user, resource and method in the period of the filter, ordered by user,
+// resource and method. The counts that are not flushed yet are not reported.
+func (meter *RequestMeter) Report(ctx context.Context, filter RequestFilter) ([]RequestTotal, error) {
+	query := meter.DB.WithContext(ctx).Model(&RequestUsage{}).
+		Select("user_id, resource, method, SUM(requests) AS requests, SUM(client_errors) AS client_errors, SUM(server_errors) AS server_errors").
+		Group("user_id, resource, method").
+		Order("user_id, resource, method")
+	if filter.UserID != uuid.Nil {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Resource != "" {
+		query = query.Where("resource = ?", filter.Resource)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("bucket >= ?", filter.From.UTC().Truncate(meter.Bucket))
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("bucket < ?", filter.To.UTC())
+	}
+	totals := []RequestTotal{}
+	err := query.Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range totals {
+		if totals[i].Requests > 0 {
+			totals[i].ErrorRate = float64(totals[i].ClientErrors+totals[i].ServerErrors) / float64(totals[i].Requests)
+		}
+	}
+	return totals, nil
+}
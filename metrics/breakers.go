@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"github.com/dzahariev/respite/breaker"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// breakerStates are the states of the breakers, exported as one series per state with 1 for the current one
+var breakerStates = []string{breaker.CLOSED, breaker.OPEN, breaker.HALF_OPEN}
+
+// breakers exports the states of the circuit breakers and how often they opened
+type breakers struct {
+	registry   *breaker.Registry
+	stateDesc  *prometheus.Desc
+	openedDesc *prometheus.Desc
+}
+
+// RegisterBreakers exports the circuit breakers of the registry, the breakers added later are exported as well
+func (metrics *Metrics) RegisterBreakers(registry *breaker.Registry) error {
+	return metrics.Registry.Register(&breakers{
+		registry: registry,
+		stateDesc: prometheus.NewDesc(prometheus.BuildFQName(metrics.Config.Namespace, "circuit_breaker", "state"),
+			"State of the circuit breaker of a dependency, 1 for the current state.", []string{"breaker", "state"}, nil),
+		openedDesc: prometheus.NewDesc(prometheus.BuildFQName(metrics.Config.Namespace, "circuit_breaker", "opened_total"),
+			"Number of times the circuit breaker of a dependency opened.", []string{"breaker"}, nil),
+	})
+}
+
+// Describe sends the descriptors of the exported metrics
+func (breakers *breakers) Describe(descs chan<- *prometheus.Desc) {
+	descs <- breakers.stateDesc
+	descs <- breakers.openedDesc
+}
+
+// Collect reads the states of the breakers
+func (breakers *breakers) Collect(metrics chan<- prometheus.Metric) {
+	for _, status := range breakers.registry.Statuses() {
+		for _, state := range breakerStates {
+			value := 0.0
+			if state == status.State {
+				value = 1
+			}
+			metrics <- prometheus.MustNewConstMetric(breakers.stateDesc, prometheus.GaugeValue, value, status.Name, state)
+		}
+		metrics <- prometheus.MustNewConstMetric(breakers.openedDesc, prometheus.CounterValue, float64(status.Opened), status.Name)
+	}
+}
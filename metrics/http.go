@@ -0,0 +1,212 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// RouteSummary describes the requests served by a route
+type RouteSummary struct {
+	Route    string            `json:"route"`
+	Method   string            `json:"method"`
+	Count    uint64            `json:"count"`
+	Mean     float64           `json:"mean_seconds"`
+	P50      float64           `json:"p50_seconds"`
+	P95      float64           `json:"p95_seconds"`
+	P99      float64           `json:"p99_seconds"`
+	Statuses map[string]uint64 `json:"statuses"`
+}
+
+// statusRecorder keeps the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the implicit status code
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(data)
+}
+
+// Unwrap returns the original writer, so that the connection can be hijacked by the subscriptions
+func (recorder *statusRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
+
+// newHTTPDuration creates the histogram of the request durations, buckets default to the Prometheus ones
+func newHTTPDuration(namespace string, buckets []float64) *prometheus.HistogramVec {
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of HTTP requests by route template, method and status class.",
+		Buckets:   buckets,
+	}, []string{"route", "method", "status"})
+}
+
+// newDeprecatedRequests creates the counter of the requests of deprecated routes
+func newDeprecatedRequests(namespace string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "deprecated_requests_total",
+		Help:      "Requests of deprecated routes by route template and method.",
+	}, []string{"route", "method"})
+}
+
+// CountDeprecated counts the request of a deprecated route, so the remaining clients are known before the sunset
+func (metrics *Metrics) CountDeprecated(r *http.Request) {
+	metrics.deprecated.WithLabelValues(routeTemplate(r), r.Method).Inc()
+}
+
+// Middleware records the duration of the requests labeled by the route template instead of the path,
+// so that identifiers in the path do not create new series
+func (metrics *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		duration := time.Since(started)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		route := routeTemplate(r)
+		metrics.httpDuration.WithLabelValues(route, r.Method, statusClass(recorder.status)).Observe(duration.Seconds())
+		if metrics.slos != nil {
+			metrics.slos.observe(route, recorder.status, duration)
+		}
+	})
+}
+
+// routeTemplate returns the template of the matched route
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "unmatched"
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		template, err = route.GetPathRegexp()
+		if err != nil {
+			return "unmatched"
+		}
+	}
+	return template
+}
+
+// statusClass groups the status codes by their first digit, e.g. 2xx
+func statusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// Routes summarizes the recorded request durations per route and method, quantiles are estimated from the buckets
+func (metrics *Metrics) Routes() ([]RouteSummary, error) {
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	name := prometheus.BuildFQName(metrics.Config.Namespace, "http", "request_duration_seconds")
+	type routeKey struct{ route, method string }
+	summaries := map[routeKey]*RouteSummary{}
+	buckets := map[routeKey][]*dto.Bucket{}
+	sums := map[routeKey]float64{}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			key := routeKey{route: labels["route"], method: labels["method"]}
+			summary, ok := summaries[key]
+			if !ok {
+				summary = &RouteSummary{Route: key.route, Method: key.method, Statuses: map[string]uint64{}}
+				summaries[key] = summary
+			}
+			histogram := metric.GetHistogram()
+			summary.Count += histogram.GetSampleCount()
+			summary.Statuses[labels["status"]] += histogram.GetSampleCount()
+			sums[key] += histogram.GetSampleSum()
+			buckets[key] = mergeBuckets(buckets[key], histogram.GetBucket())
+		}
+	}
+
+	result := make([]RouteSummary, 0, len(summaries))
+	for key, summary := range summaries {
+		if summary.Count > 0 {
+			summary.Mean = sums[key] / float64(summary.Count)
+		}
+		summary.P50 = quantile(0.5, summary.Count, buckets[key])
+		summary.P95 = quantile(0.95, summary.Count, buckets[key])
+		summary.P99 = quantile(0.99, summary.Count, buckets[key])
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Route != result[j].Route {
+			return result[i].Route < result[j].Route
+		}
+		return result[i].Method < result[j].Method
+	})
+	return result, nil
+}
+
+// mergeBuckets adds the cumulative counts of histograms with the same bucket bounds
+func mergeBuckets(merged, buckets []*dto.Bucket) []*dto.Bucket {
+	if merged == nil {
+		merged = make([]*dto.Bucket, len(buckets))
+		for i, bucket := range buckets {
+			count := bucket.GetCumulativeCount()
+			merged[i] = &dto.Bucket{UpperBound: bucket.UpperBound, CumulativeCount: &count}
+		}
+		return merged
+	}
+	for i, bucket := range buckets {
+		*merged[i].CumulativeCount += bucket.GetCumulativeCount()
+	}
+	return merged
+}
+
+// quantile estimates the quantile by linear interpolation within the bucket containing it, like histogram_quantile,
+// observations above the highest bound are reported as the highest bound
+func quantile(q float64, count uint64, buckets []*dto.Bucket) float64 {
+	if count == 0 || len(buckets) == 0 {
+		return 0
+	}
+	rank := q * float64(count)
+	lowerBound := 0.0
+	lowerCount := 0.0
+	for _, bucket := range buckets {
+		upperCount := float64(bucket.GetCumulativeCount())
+		if upperCount >= rank {
+			if upperCount == lowerCount {
+				return bucket.GetUpperBound()
+			}
+			return lowerBound + (bucket.GetUpperBound()-lowerBound)*(rank-lowerCount)/(upperCount-lowerCount)
+		}
+		lowerBound = bucket.GetUpperBound()
+		lowerCount = upperCount
+	}
+	return lowerBound
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+const startedKey = "metrics:started"
+
+// Metrics holds the registry with the collectors exported by the server
+type Metrics struct {
+	Config       cfg.Metrics
+	Registry     *prometheus.Registry
+	dbQueries    *prometheus.CounterVec
+	dbDuration   *prometheus.HistogramVec
+	httpDuration *prometheus.HistogramVec
+	deprecated   *prometheus.CounterVec
+	dropped      prometheus.Counter
+	slos         *SLOs
+}
+
+// New creates the registry with the Go runtime and process collectors, the request durations and the
+// service level objectives if configured
+func New(config cfg.Metrics) *Metrics {
+	registry := prometheus.NewRegistry()
+	httpDuration := newHTTPDuration(config.Namespace, config.HTTPBuckets)
+	deprecated := newDeprecatedRequests(config.Namespace)
+	dropped := newDroppedRequests(config.Namespace)
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpDuration,
+		deprecated,
+		dropped,
+	)
+	metrics := &Metrics{
+		Config:       config,
+		Registry:     registry,
+		httpDuration: httpDuration,
+		deprecated:   deprecated,
+		dropped:      dropped,
+	}
+	if len(config.SLOGroups) > 0 {
+		metrics.slos = NewSLOs(config)
+		registry.MustRegister(metrics.slos)
+	}
+	return metrics
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (metrics *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{Registry: metrics.Registry})
+}
+
+// InstrumentDB exports the connection pool statistics and counts and times the queries per resource and operation
+func (metrics *Metrics) InstrumentDB(db *gorm.DB, databaseName string) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	metrics.dbQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Config.Namespace,
+		Subsystem: "db",
+		Name:      "queries_total",
+		Help:      "Number of database queries by resource, operation and status.",
+	}, []string{"resource", "operation", "status"})
+	metrics.dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Config.Namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of database queries by resource and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"resource", "operation"})
+	err = metrics.Registry.Register(collectors.NewDBStatsCollector(sqlDB, databaseName))
+	if err != nil {
+		return err
+	}
+	err = metrics.Registry.Register(metrics.dbQueries)
+	if err != nil {
+		return err
+	}
+	err = metrics.Registry.Register(metrics.dbDuration)
+	if err != nil {
+		return err
+	}
+
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("metrics:before_create", start),
+		callbacks.Create().After("gorm:create").Register("metrics:after_create", metrics.observe("create")),
+		callbacks.Query().Before("gorm:query").Register("metrics:before_query", start),
+		callbacks.Query().After("gorm:query").Register("metrics:after_query", metrics.observe("query")),
+		callbacks.Update().Before("gorm:update").Register("metrics:before_update", start),
+		callbacks.Update().After("gorm:update").Register("metrics:after_update", metrics.observe("update")),
+		callbacks.Delete().Before("gorm:delete").Register("metrics:before_delete", start),
+		callbacks.Delete().After("gorm:delete").Register("metrics:after_delete", metrics.observe("delete")),
+		callbacks.Row().Before("gorm:row").Register("metrics:before_row", start),
+		callbacks.Row().After("gorm:row").Register("metrics:after_row", metrics.observe("row")),
+		callbacks.Raw().Before("gorm:raw").Register("metrics:before_raw", start),
+		callbacks.Raw().After("gorm:raw").Register("metrics:after_raw", metrics.observe("raw")),
+	)
+}
+
+// start keeps the start time of the query in the statement
+func start(db *gorm.DB) {
+	db.InstanceSet(startedKey, time.Now())
+}
+
+// observe records the outcome and the duration of the query
+func (metrics *Metrics) observe(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startedKey)
+		if !ok {
+			return
+		}
+		started := value.(time.Time)
+		resource := resourceName(db.Statement)
+		status := "ok"
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			status = "error"
+		}
+		metrics.dbQueries.WithLabelValues(resource, operation, status).Inc()
+		metrics.dbDuration.WithLabelValues(resource, operation).Observe(time.Since(started).Seconds())
+	}
+}
+
+// resourceName resolves the resource of the statement model, queries of other tables are labeled by table name
+func resourceName(statement *gorm.Statement) string {
+	if statement.Schema != nil {
+		if object, ok := reflect.New(statement.Schema.ModelType).Interface().(domain.Object); ok {
+			return object.ResourceName()
+		}
+	}
+	if statement.Table != "" {
+		return statement.Table
+	}
+	return "none"
+}
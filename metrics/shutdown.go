@@ -0,0 +1,42 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// newDroppedRequests creates the counter of the requests still in flight when the shutdown deadline passed
+func newDroppedRequests(namespace string) prometheus.Counter {
+	return prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "shutdown_dropped_requests_total",
+		Help:      "Requests in flight that were dropped when the shutdown deadline passed.",
+	})
+}
+
+// RegisterInFlight exports the number of the requests in flight and of the open hijacked connections that the count
+// functions return
+func (metrics *Metrics) RegisterInFlight(count, hijacked func() int64) error {
+	err := metrics.Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Config.Namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "Requests that are being served.",
+	}, func() float64 {
+		return float64(count())
+	}))
+	if err != nil {
+		return err
+	}
+	return metrics.Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Config.Namespace,
+		Subsystem: "http",
+		Name:      "hijacked_connections",
+		Help:      "Open hijacked connections, e.g. WebSockets, which are not counted in the requests in flight.",
+	}, func() float64 {
+		return float64(hijacked())
+	}))
+}
+
+// CountDropped counts the requests that were dropped by the shutdown
+func (metrics *Metrics) CountDropped(count int64) {
+	metrics.dropped.Add(float64(count))
+}
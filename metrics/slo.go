@@ -0,0 +1,218 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	AVAILABILITY = "availability"
+	LATENCY      = "latency"
+
+	// recentSlot is the resolution of the burn rate windows
+	recentSlot = time.Minute
+	// periodSlots is the number of slots of the error budget period
+	periodSlots = 720
+)
+
+// sloCounts are the requests of an objective within a slot
+type sloCounts struct {
+	total  uint64
+	failed uint64
+	slow   uint64
+}
+
+// sloRing keeps the request counts of consecutive slots, slots older than the ring are reused
+type sloRing struct {
+	slot   time.Duration
+	counts []sloCounts
+	last   int64
+}
+
+// newSLORing creates a ring with slots of the given duration covering the span
+func newSLORing(slot, span time.Duration) *sloRing {
+	return &sloRing{
+		slot:   slot,
+		counts: make([]sloCounts, int(span/slot)+1),
+		last:   time.Now().UnixNano() / int64(slot),
+	}
+}
+
+// advance clears the slots between the last used one and the current one
+func (ring *sloRing) advance(now time.Time) int64 {
+	index := now.UnixNano() / int64(ring.slot)
+	size := int64(len(ring.counts))
+	for i := ring.last + 1; i <= index && i <= ring.last+size; i++ {
+		ring.counts[i%size] = sloCounts{}
+	}
+	if index > ring.last {
+		ring.last = index
+	}
+	return index
+}
+
+// add counts a request in the current slot
+func (ring *sloRing) add(now time.Time, failed, slow bool) {
+	index := ring.advance(now)
+	counts := &ring.counts[index%int64(len(ring.counts))]
+	counts.total++
+	if failed {
+		counts.failed++
+	}
+	if slow {
+		counts.slow++
+	}
+}
+
+// sum adds the counts of the slots covering the span up to now
+func (ring *sloRing) sum(now time.Time, span time.Duration) sloCounts {
+	index := ring.advance(now)
+	size := int64(len(ring.counts))
+	slots := min(max(int64(span/ring.slot), 1), size)
+	var sum sloCounts
+	for i := index - slots + 1; i <= index; i++ {
+		counts := ring.counts[i%size]
+		sum.total += counts.total
+		sum.failed += counts.failed
+		sum.slow += counts.slow
+	}
+	return sum
+}
+
+// objective is a service level objective of a route group
+type objective struct {
+	name          string
+	prefix        string
+	availability  float64
+	latency       time.Duration
+	latencyTarget float64
+	recent        *sloRing
+	period        *sloRing
+}
+
+// targets returns the target ratio of each configured indicator
+func (objective *objective) targets() map[string]float64 {
+	targets := map[string]float64{}
+	if objective.availability > 0 {
+		targets[AVAILABILITY] = objective.availability
+	}
+	if objective.latency > 0 {
+		targets[LATENCY] = objective.latencyTarget
+	}
+	return targets
+}
+
+// SLOs tracks the service level objectives and exports their burn rates and remaining error budgets
+type SLOs struct {
+	mutex         sync.Mutex
+	objectives    []*objective
+	windows       []time.Duration
+	period        time.Duration
+	objectiveDesc *prometheus.Desc
+	burnRateDesc  *prometheus.Desc
+	budgetDesc    *prometheus.Desc
+}
+
+// NewSLOs creates the objectives of the configured route groups, the longest matching prefix wins
+func NewSLOs(config cfg.Metrics) *SLOs {
+	slos := &SLOs{
+		windows: config.SLOWindows,
+		period:  config.SLOPeriod,
+		objectiveDesc: prometheus.NewDesc(prometheus.BuildFQName(config.Namespace, "slo", "objective"),
+			"Target ratio of good requests of the service level objective.", []string{"slo", "sli"}, nil),
+		burnRateDesc: prometheus.NewDesc(prometheus.BuildFQName(config.Namespace, "slo", "burn_rate"),
+			"Rate at which the error budget is consumed within the window, 1 consumes exactly the budget over the period.", []string{"slo", "sli", "window"}, nil),
+		budgetDesc: prometheus.NewDesc(prometheus.BuildFQName(config.Namespace, "slo", "error_budget_remaining"),
+			"Ratio of the error budget of the period that is not consumed yet, negative when it is exceeded.", []string{"slo", "sli"}, nil),
+	}
+	recentSpan := recentSlot
+	for _, window := range config.SLOWindows {
+		recentSpan = max(recentSpan, window)
+	}
+	periodSlot := max(config.SLOPeriod/periodSlots, recentSlot)
+	for name, prefix := range config.SLOGroups {
+		slos.objectives = append(slos.objectives, &objective{
+			name:          name,
+			prefix:        prefix,
+			availability:  config.SLOAvailability[name] / 100,
+			latency:       config.SLOLatency[name],
+			latencyTarget: config.SLOLatencyTarget[name] / 100,
+			recent:        newSLORing(recentSlot, recentSpan),
+			period:        newSLORing(periodSlot, config.SLOPeriod),
+		})
+	}
+	sort.Slice(slos.objectives, func(i, j int) bool {
+		return len(slos.objectives[i].prefix) > len(slos.objectives[j].prefix)
+	})
+	return slos
+}
+
+// observe counts the request in the objective matching the route template, server errors fail the availability
+func (slos *SLOs) observe(route string, status int, duration time.Duration) {
+	for _, objective := range slos.objectives {
+		if !strings.HasPrefix(route, objective.prefix) {
+			continue
+		}
+		now := time.Now()
+		failed := status >= 500
+		slow := objective.latency > 0 && duration > objective.latency
+		slos.mutex.Lock()
+		objective.recent.add(now, failed, slow)
+		objective.period.add(now, failed, slow)
+		slos.mutex.Unlock()
+		return
+	}
+}
+
+// Describe sends the descriptors of the exported metrics
+func (slos *SLOs) Describe(descs chan<- *prometheus.Desc) {
+	descs <- slos.objectiveDesc
+	descs <- slos.burnRateDesc
+	descs <- slos.budgetDesc
+}
+
+// Collect computes the burn rates of all windows and the remaining error budgets
+func (slos *SLOs) Collect(metrics chan<- prometheus.Metric) {
+	slos.mutex.Lock()
+	defer slos.mutex.Unlock()
+	now := time.Now()
+	for _, objective := range slos.objectives {
+		for sli, target := range objective.targets() {
+			metrics <- prometheus.MustNewConstMetric(slos.objectiveDesc, prometheus.GaugeValue, target, objective.name, sli)
+			for _, window := range slos.windows {
+				rate := burnRate(sli, target, objective.recent.sum(now, window))
+				metrics <- prometheus.MustNewConstMetric(slos.burnRateDesc, prometheus.GaugeValue, rate, objective.name, sli, windowLabel(window))
+			}
+			metrics <- prometheus.MustNewConstMetric(slos.budgetDesc, prometheus.GaugeValue, 1-burnRate(sli, target, objective.period.sum(now, slos.period)), objective.name, sli)
+		}
+	}
+}
+
+// windowLabel formats the window without zero units, e.g. 1h instead of 1h0m0s
+func windowLabel(window time.Duration) string {
+	label := window.String()
+	if strings.HasSuffix(label, "m0s") {
+		label = strings.TrimSuffix(label, "0s")
+	}
+	if strings.HasSuffix(label, "h0m") {
+		label = strings.TrimSuffix(label, "0m")
+	}
+	return label
+}
+
+// burnRate is the ratio of bad requests relative to the ratio allowed by the target
+func burnRate(sli string, target float64, counts sloCounts) float64 {
+	if counts.total == 0 || target >= 1 {
+		return 0
+	}
+	bad := counts.failed
+	if sli == LATENCY {
+		bad = counts.slow
+	}
+	return float64(bad) / float64(counts.total) / (1 - target)
+}
@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/events"
+	"gorm.io/gorm"
+)
+
+// Recipients resolves the recipients of an event notification
+type Recipients func(ctx context.Context, event events.Event) ([]string, error)
+
+// Rule renders the template for all events of the given type, e.g. order.created
+type Rule struct {
+	Type       string
+	Template   *Template
+	Recipients Recipients
+}
+
+// TemplateData is passed to the templates of event notifications
+type TemplateData struct {
+	Event  events.Event
+	Object map[string]any
+}
+
+// EventNotifier sends emails for events matching its rules. It implements events.Publisher so it can be
+// combined with other publishers; with the outbox enabled failed deliveries are retried by the relay.
+type EventNotifier struct {
+	Notifier Notifier
+	Rules    []Rule
+}
+
+// NewEventNotifier creates an event notifier with the given rules
+func NewEventNotifier(notifier Notifier, rules ...Rule) *EventNotifier {
+	return &EventNotifier{
+		Notifier: notifier,
+		Rules:    rules,
+	}
+}
+
+// Publish sends the notifications of all rules matching the event
+func (eventNotifier *EventNotifier) Publish(ctx context.Context, event events.Event) error {
+	var errs []error
+	for _, rule := range eventNotifier.Rules {
+		if rule.Type != event.Type {
+			continue
+		}
+		err := eventNotifier.notify(ctx, rule, event)
+		if err != nil {
+			slog.Error("Error sending event notification", "event", event.ID, "type", event.Type, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close does nothing
+func (eventNotifier *EventNotifier) Close() error {
+	return nil
+}
+
+// notify renders and sends the notification of a single rule
+func (eventNotifier *EventNotifier) notify(ctx context.Context, rule Rule, event events.Event) error {
+	recipients, err := rule.Recipients(ctx, event)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+	data := TemplateData{Event: event}
+	if len(event.Data) > 0 {
+		err = json.Unmarshal(event.Data, &data.Object)
+		if err != nil {
+			return err
+		}
+	}
+	message, err := rule.Template.Render(recipients, data)
+	if err != nil {
+		return err
+	}
+	return eventNotifier.Notifier.Send(ctx, message)
+}
+
+// StaticRecipients always returns the given addresses
+func StaticRecipients(addresses ...string) Recipients {
+	return func(ctx context.Context, event events.Event) ([]string, error) {
+		return addresses, nil
+	}
+}
+
+// UserRecipients returns the email of the user that performed the mutation
+func UserRecipients(db *gorm.DB) Recipients {
+	return func(ctx context.Context, event events.Event) ([]string, error) {
+		if event.UserID == nil {
+			return nil, nil
+		}
+		user := &domain.User{}
+		err := user.FindByID(ctx, db.WithContext(ctx), user, *event.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user.Email == "" {
+			return nil, nil
+		}
+		return []string{user.Email}, nil
+	}
+}
@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dzahariev/respite/cfg"
+)
+
+const (
+	SMTP     = "smtp"
+	SENDGRID = "sendgrid"
+)
+
+// Message is an email message, at least one of Text and HTML should be set
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Notifier is an abstraction of all email delivery backends
+type Notifier interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewNotifier creates the notifier for the configured provider
+func NewNotifier(config cfg.Email) (Notifier, error) {
+	switch config.Provider {
+	case SMTP:
+		return NewSMTPNotifier(config), nil
+	case SENDGRID:
+		return NewSendGridNotifier(config), nil
+	default:
+		return nil, fmt.Errorf("unsupported email provider: %s", config.Provider)
+	}
+}
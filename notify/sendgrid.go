@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+)
+
+// SendGridNotifier sends emails through the SendGrid v3 mail API
+type SendGridNotifier struct {
+	URL    string
+	APIKey string
+	From   string
+	Client *http.Client
+}
+
+// NewSendGridNotifier creates a notifier for the SendGrid API
+func NewSendGridNotifier(config cfg.Email) *SendGridNotifier {
+	return &SendGridNotifier{
+		URL:    config.SendGridURL,
+		APIKey: config.SendGridAPIKey,
+		From:   config.From,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+}
+
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridMail struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+}
+
+// Send delivers the message
+func (notifier *SendGridNotifier) Send(ctx context.Context, message Message) error {
+	if len(message.To) == 0 {
+		return fmt.Errorf("email without recipients")
+	}
+	mail := sendGridMail{
+		From:    sendGridAddress{Email: notifier.From},
+		Subject: message.Subject,
+	}
+	personalization := sendGridPersonalization{}
+	for _, to := range message.To {
+		personalization.To = append(personalization.To, sendGridAddress{Email: to})
+	}
+	mail.Personalizations = []sendGridPersonalization{personalization}
+	// SendGrid requires text/plain to precede text/html
+	if message.Text != "" {
+		mail.Content = append(mail.Content, sendGridContent{Type: "text/plain", Value: message.Text})
+	}
+	if message.HTML != "" {
+		mail.Content = append(mail.Content, sendGridContent{Type: "text/html", Value: message.HTML})
+	}
+
+	body, err := json.Marshal(mail)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, notifier.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+notifier.APIKey)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := notifier.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusMultipleChoices {
+		details, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("sendgrid rejected email with status %d: %s", response.StatusCode, details)
+	}
+	return nil
+}
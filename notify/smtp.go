@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/gofrs/uuid/v5"
+)
+
+// SMTPNotifier sends emails through an SMTP relay, STARTTLS is used when the server supports it
+type SMTPNotifier struct {
+	Address string
+	From    string
+	Auth    smtp.Auth
+}
+
+// NewSMTPNotifier creates a notifier for the configured SMTP relay
+func NewSMTPNotifier(config cfg.Email) *SMTPNotifier {
+	notifier := &SMTPNotifier{
+		Address: net.JoinHostPort(config.SMTPHost, config.SMTPPort),
+		From:    config.From,
+	}
+	if config.SMTPUser != "" {
+		notifier.Auth = smtp.PlainAuth("", config.SMTPUser, config.SMTPPassword, config.SMTPHost)
+	}
+	return notifier
+}
+
+// Send delivers the message
+func (notifier *SMTPNotifier) Send(ctx context.Context, message Message) error {
+	if len(message.To) == 0 {
+		return fmt.Errorf("email without recipients")
+	}
+	body, err := notifier.compose(message)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(notifier.Address, notifier.Auth, notifier.From, message.To, body)
+}
+
+// compose builds a MIME message with text and HTML alternatives
+func (notifier *SMTPNotifier) compose(message Message) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+	messageID := uuid.Must(uuid.NewV4())
+	headers := []string{
+		"From: " + notifier.From,
+		"To: " + strings.Join(message.To, ", "),
+		"Subject: " + mime.BEncoding.Encode("UTF-8", message.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <%s@respite>", messageID),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + writer.Boundary(),
+	}
+	buffer.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", message.Text},
+		{"text/html; charset=UTF-8", message.HTML},
+	}
+	for _, part := range parts {
+		if part.content == "" {
+			continue
+		}
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(partWriter)
+		_, err = encoder.Write([]byte(part.content))
+		if err != nil {
+			return nil, err
+		}
+		err = encoder.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+// Template renders messages, the subject and text use text/template and the HTML body uses html/template
+type Template struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// NewTemplate parses the templates of a message, empty bodies are skipped
+func NewTemplate(subject, text, html string) (*Template, error) {
+	var err error
+	template := &Template{}
+	template.subject, err = texttemplate.New("subject").Parse(subject)
+	if err != nil {
+		return nil, err
+	}
+	if text != "" {
+		template.text, err = texttemplate.New("text").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if html != "" {
+		template.html, err = htmltemplate.New("html").Parse(html)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return template, nil
+}
+
+// Render creates a message for the recipients using the data
+func (template *Template) Render(to []string, data any) (Message, error) {
+	message := Message{To: to}
+	var buffer bytes.Buffer
+	err := template.subject.Execute(&buffer, data)
+	if err != nil {
+		return message, err
+	}
+	message.Subject = buffer.String()
+	if template.text != nil {
+		buffer.Reset()
+		err = template.text.Execute(&buffer, data)
+		if err != nil {
+			return message, err
+		}
+		message.Text = buffer.String()
+	}
+	if template.html != nil {
+		buffer.Reset()
+		err = template.html.Execute(&buffer, data)
+		if err != nil {
+			return message, err
+		}
+		message.HTML = buffer.String()
+	}
+	return message, nil
+}
@@ -0,0 +1,190 @@
+package outbound
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dzahariev/respite/breaker"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/dzahariev/respite/tracing"
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// defaultTimeout limits the requests when no timeout is configured
+	defaultTimeout = 10 * time.Second
+	// defaultBackoff is the delay of the first retry when no backoff is configured
+	defaultBackoff = 200 * time.Millisecond
+	// RequestIDHeader carries the request ID of the logs to the called services
+	RequestIDHeader = "X-Request-ID"
+)
+
+// retryStatuses are the responses of overloaded or unavailable services, which are repeated
+var retryStatuses = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// idempotentMethods are repeated on failures, other requests only with an Idempotency-Key header
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// TokenSource provides the bearer token of the requests, e.g. the service token of the client credentials
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenFunc adapts a function to a token source
+type TokenFunc func(ctx context.Context) (string, error)
+
+// Token calls the function
+func (tokenFunc TokenFunc) Token(ctx context.Context) (string, error) {
+	return tokenFunc(ctx)
+}
+
+// New creates the HTTP client of the integrations. The requests carry the request ID of the context and the
+// trace context, are authorized with the token of the source unless they have an Authorization header, and
+// failed idempotent requests are repeated. A nil source sends no token.
+func New(config cfg.Outbound, tokens TokenSource) *http.Client {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	backoff := config.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &Transport{
+			Base:    tracing.Transport(http.DefaultTransport),
+			Tokens:  tokens,
+			Retries: config.Retries,
+			Backoff: backoff,
+		},
+	}
+}
+
+// Transport sets the headers of the requests and repeats the failed ones
+type Transport struct {
+	Base    http.RoundTripper
+	Tokens  TokenSource
+	Retries int
+	Backoff time.Duration
+	// Breakers keep a circuit breaker per host, nil sends all requests
+	Breakers *breaker.Registry
+}
+
+// RoundTrip fails the request with breaker.ErrOpen while the circuit breaker of the host is open. The requests
+// that fail with a network error or with 429, 502, 503 or 504 after their retries count as failures of the host.
+func (transport *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if transport.Breakers == nil {
+		return transport.roundTrip(r)
+	}
+	hostBreaker := transport.Breakers.Get("outbound:" + r.URL.Host)
+	if !hostBreaker.Allow() {
+		return nil, hostBreaker.Err()
+	}
+	response, err := transport.roundTrip(r)
+	// The canceled requests do not show that the host is down
+	hostBreaker.Record(r.Context().Err() == nil && (err != nil || retryStatuses[response.StatusCode]))
+	return response, err
+}
+
+// roundTrip sends the request with the headers, requests that fail with a network error or with 429, 502, 503
+// or 504 are repeated with an exponential backoff, or after the Retry-After of the response if it is longer.
+// Requests are not repeated when the deadline of the context would pass before the retry.
+func (transport *Transport) roundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	r = r.Clone(ctx)
+	if r.Header.Get(RequestIDHeader) == "" {
+		if requestID := requestID(ctx); !requestID.IsNil() {
+			r.Header.Set(RequestIDHeader, requestID.String())
+		}
+	}
+	if transport.Tokens != nil && r.Header.Get("Authorization") == "" {
+		token, err := transport.Tokens.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	retries := transport.Retries
+	if !retryable(r) {
+		retries = 0
+	}
+	request := r
+	for attempt := 0; ; attempt++ {
+		response, err := transport.Base.RoundTrip(request)
+		if attempt >= retries || ctx.Err() != nil || (err == nil && !retryStatuses[response.StatusCode]) {
+			return response, err
+		}
+		delay := transport.Backoff << attempt
+		if err == nil {
+			delay = max(delay, retryAfter(response))
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return response, err
+		}
+		if response != nil {
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+		common.GetLogger(ctx).Debug("Repeating outbound request", "method", r.Method, "url", r.URL.Redacted(), "attempt", attempt+1, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		// Every attempt sends its own copy of the request with the body read again
+		request = r.Clone(ctx)
+		if r.GetBody != nil {
+			request.Body, err = r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// retryable checks if the request may be repeated, its body must be readable again
+func retryable(r *http.Request) bool {
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return false
+	}
+	return idempotentMethods[r.Method] || r.Header.Get("Idempotency-Key") != ""
+}
+
+// retryAfter returns the delay of the Retry-After header in seconds, 0 without it
+func retryAfter(response *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(response.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// requestID returns the ID of the request of the context, of the REST routes or of the other request contexts
+func requestID(ctx context.Context) uuid.UUID {
+	if requestID, ok := respitectx.RequestID(ctx); ok {
+		return requestID
+	}
+	if requestContext := common.GetRequestContext(ctx); requestContext != nil {
+		return requestContext.RequestID
+	}
+	return uuid.Nil
+}
@@ -0,0 +1,332 @@
+package presence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cache"
+	"github.com/gofrs/uuid/v5"
+)
+
+// States of the users on the objects, editing takes precedence over viewing for the users on several connections
+const (
+	VIEWING = "viewing"
+	EDITING = "editing"
+)
+
+// Key identifies the object of the presence
+type Key struct {
+	Resource string
+	ID       uuid.UUID
+}
+
+// Entry is the presence of a user on an object
+type Entry struct {
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name,omitempty"`
+	State  string    `json:"state"`
+	Since  time.Time `json:"since"`
+}
+
+// session is the presence of a connection on an object, it expires unless it is refreshed
+type session struct {
+	entry   Entry
+	expires time.Time
+	// local marks the connections of this instance, the others are received from the replicas
+	local bool
+}
+
+// message is the change of the presence of a connection sent to the replicas, without entry it left the object
+type message struct {
+	Instance uuid.UUID `json:"instance"`
+	Resource string    `json:"resource"`
+	ID       uuid.UUID `json:"id"`
+	Session  uuid.UUID `json:"session"`
+	Entry    *Entry    `json:"entry,omitempty"`
+}
+
+// Tracker keeps who is on which object, e.g. for the presence indicators of collaborative clients. The presence of
+// a connection expires after the TTL unless it is refreshed, so that the connections that are lost without leaving
+// their objects, e.g. of a crashed replica, are removed.
+type Tracker struct {
+	TTL         time.Duration
+	mutex       sync.Mutex
+	objects     map[Key]map[uuid.UUID]session
+	watchers    map[*func(Key, []Entry)]struct{}
+	broadcaster cache.Broadcaster
+	channel     string
+	instance    uuid.UUID
+}
+
+// NewTracker creates a tracker whose presence expires after the TTL
+func NewTracker(ttl time.Duration) *Tracker {
+	return &Tracker{
+		TTL:      ttl,
+		objects:  map[Key]map[uuid.UUID]session{},
+		watchers: map[*func(Key, []Entry)]struct{}{},
+		instance: uuid.Must(uuid.NewV4()),
+	}
+}
+
+// Share sends the changes of the presence of the connections of this instance to the replicas on the channel and
+// receives theirs, once Run is started
+func (tracker *Tracker) Share(broadcaster cache.Broadcaster, channel string) {
+	tracker.broadcaster = broadcaster
+	tracker.channel = channel
+}
+
+// Watch calls notify with the presence of the objects whose presence changes until stop is called. The
+// notifications run in the goroutine of the change, so they must not block.
+func (tracker *Tracker) Watch(notify func(Key, []Entry)) (stop func()) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.watchers[&notify] = struct{}{}
+	return func() {
+		tracker.mutex.Lock()
+		defer tracker.mutex.Unlock()
+		delete(tracker.watchers, &notify)
+	}
+}
+
+// Join sets the presence of the connection on the object, a join of the same connection changes its state
+func (tracker *Tracker) Join(ctx context.Context, key Key, connection uuid.UUID, entry Entry) {
+	tracker.mutex.Lock()
+	sessions, ok := tracker.objects[key]
+	if !ok {
+		sessions = map[uuid.UUID]session{}
+		tracker.objects[key] = sessions
+	}
+	existing, joined := sessions[connection]
+	if joined {
+		entry.Since = existing.entry.Since
+	} else if entry.Since.IsZero() {
+		entry.Since = time.Now().UTC()
+	}
+	sessions[connection] = session{entry: entry, expires: time.Now().Add(tracker.TTL), local: true}
+	tracker.mutex.Unlock()
+	tracker.broadcast(ctx, key, connection, &entry)
+	if !joined || existing.entry.State != entry.State {
+		tracker.notify(key)
+	}
+}
+
+// Leave removes the presence of the connection on the object
+func (tracker *Tracker) Leave(ctx context.Context, key Key, connection uuid.UUID) {
+	tracker.mutex.Lock()
+	_, joined := tracker.objects[key][connection]
+	tracker.remove(key, connection)
+	tracker.mutex.Unlock()
+	if joined {
+		tracker.broadcast(ctx, key, connection, nil)
+		tracker.notify(key)
+	}
+}
+
+// LeaveAll removes the presence of the connection on all objects, e.g. when it is closed
+func (tracker *Tracker) LeaveAll(ctx context.Context, connection uuid.UUID) {
+	for _, key := range tracker.joined(connection) {
+		tracker.Leave(ctx, key, connection)
+	}
+}
+
+// Refresh extends the presence of the connection on its objects by the TTL, e.g. when the client answers a ping
+func (tracker *Tracker) Refresh(connection uuid.UUID) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	expires := time.Now().Add(tracker.TTL)
+	for _, sessions := range tracker.objects {
+		if current, ok := sessions[connection]; ok {
+			current.expires = expires
+			sessions[connection] = current
+		}
+	}
+}
+
+// Present returns the users on the object ordered by the time they joined, once per user
+func (tracker *Tracker) Present(key Key) []Entry {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.present(key)
+}
+
+// Run removes the expired presence until the context is cancelled. With Share it receives the changes of the
+// replicas and sends the presence of the connections of this instance every half TTL, so that it does not expire
+// at the replicas.
+func (tracker *Tracker) Run(ctx context.Context) {
+	if tracker.broadcaster != nil {
+		go tracker.broadcaster.Listen(ctx, tracker.channel, tracker.receive)
+	}
+	ticker := time.NewTicker(tracker.TTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, key := range tracker.expire() {
+				tracker.notify(key)
+			}
+			tracker.share(ctx)
+		}
+	}
+}
+
+// joined returns the objects of the connection
+func (tracker *Tracker) joined(connection uuid.UUID) []Key {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	var keys []Key
+	for key, sessions := range tracker.objects {
+		if _, ok := sessions[connection]; ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// remove deletes the presence of the connection on the object, the tracker is locked by the caller
+func (tracker *Tracker) remove(key Key, connection uuid.UUID) {
+	delete(tracker.objects[key], connection)
+	if len(tracker.objects[key]) == 0 {
+		delete(tracker.objects, key)
+	}
+}
+
+// expire removes the expired presence and returns the objects whose presence changed
+func (tracker *Tracker) expire() []Key {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	now := time.Now()
+	var changed []Key
+	for key, sessions := range tracker.objects {
+		expired := false
+		for connection, current := range sessions {
+			if now.After(current.expires) {
+				tracker.remove(key, connection)
+				expired = true
+			}
+		}
+		if expired {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// present returns the users on the object, the tracker is locked by the caller
+func (tracker *Tracker) present(key Key) []Entry {
+	byUser := map[uuid.UUID]Entry{}
+	for _, current := range tracker.objects[key] {
+		entry, ok := byUser[current.entry.UserID]
+		if !ok {
+			byUser[current.entry.UserID] = current.entry
+			continue
+		}
+		if current.entry.State == EDITING {
+			entry.State = EDITING
+		}
+		if current.entry.Since.Before(entry.Since) {
+			entry.Since = current.entry.Since
+		}
+		byUser[current.entry.UserID] = entry
+	}
+	entries := make([]Entry, 0, len(byUser))
+	for _, entry := range byUser {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		if c := a.Since.Compare(b.Since); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.UserID.Bytes(), b.UserID.Bytes())
+	})
+	return entries
+}
+
+// notify passes the presence of the object to the watchers
+func (tracker *Tracker) notify(key Key) {
+	tracker.mutex.Lock()
+	entries := tracker.present(key)
+	watchers := make([]func(Key, []Entry), 0, len(tracker.watchers))
+	for watcher := range tracker.watchers {
+		watchers = append(watchers, *watcher)
+	}
+	tracker.mutex.Unlock()
+	for _, watcher := range watchers {
+		watcher(key, entries)
+	}
+}
+
+// broadcast sends the change of the presence of a connection of this instance to the replicas
+func (tracker *Tracker) broadcast(ctx context.Context, key Key, connection uuid.UUID, entry *Entry) {
+	if tracker.broadcaster == nil {
+		return
+	}
+	body, err := json.Marshal(message{Instance: tracker.instance, Resource: key.Resource, ID: key.ID, Session: connection, Entry: entry})
+	if err != nil {
+		slog.Error("Error encoding presence", "error", err)
+		return
+	}
+	err = tracker.broadcaster.Broadcast(ctx, tracker.channel, body)
+	if err != nil {
+		slog.Error("Error broadcasting presence", "resource", key.Resource, "id", key.ID, "error", err)
+	}
+}
+
+// share sends the presence of the connections of this instance to the replicas
+func (tracker *Tracker) share(ctx context.Context) {
+	if tracker.broadcaster == nil {
+		return
+	}
+	type shared struct {
+		key        Key
+		connection uuid.UUID
+		entry      Entry
+	}
+	var local []shared
+	tracker.mutex.Lock()
+	for key, sessions := range tracker.objects {
+		for connection, current := range sessions {
+			if current.local {
+				local = append(local, shared{key: key, connection: connection, entry: current.entry})
+			}
+		}
+	}
+	tracker.mutex.Unlock()
+	for _, current := range local {
+		tracker.broadcast(ctx, current.key, current.connection, &current.entry)
+	}
+}
+
+// receive applies a change of the presence of a replica
+func (tracker *Tracker) receive(body []byte) {
+	var change message
+	err := json.Unmarshal(body, &change)
+	if err != nil {
+		slog.Error("Error decoding presence", "error", err)
+		return
+	}
+	if change.Instance == tracker.instance {
+		return
+	}
+	key := Key{Resource: change.Resource, ID: change.ID}
+	tracker.mutex.Lock()
+	existing, joined := tracker.objects[key][change.Session]
+	if change.Entry == nil {
+		tracker.remove(key, change.Session)
+	} else {
+		if tracker.objects[key] == nil {
+			tracker.objects[key] = map[uuid.UUID]session{}
+		}
+		tracker.objects[key][change.Session] = session{entry: *change.Entry, expires: time.Now().Add(tracker.TTL)}
+	}
+	tracker.mutex.Unlock()
+	if joined != (change.Entry != nil) || (joined && existing.entry.State != change.Entry.State) {
+		tracker.notify(key)
+	}
+}
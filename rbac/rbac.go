@@ -0,0 +1,186 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Grant is a permission granted to a role at runtime, e.g. the role editor granted meal.write
+type Grant struct {
+	Role       string     `json:"role" gorm:"primaryKey"`
+	Permission string     `json:"permission" gorm:"primaryKey"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+}
+
+// TableName returns the role permissions table name
+func (g *Grant) TableName() string {
+	return "role_permissions"
+}
+
+// Store holds the role to permissions mapping of the application and optionally the grants of the
+// role_permissions table, which are added to the permissions of the roles
+type Store struct {
+	Config     cfg.Permissions
+	DB         *gorm.DB
+	configured map[string][]string
+	mutex      sync.RWMutex
+	roles      map[string][]string
+}
+
+// New creates the store with the mapping of the application and loads the grants from the database if enabled
+func New(ctx context.Context, config cfg.Permissions, db *gorm.DB, roleToPermissions map[string][]string) (*Store, error) {
+	store := &Store{
+		Config:     config,
+		DB:         db,
+		configured: roleToPermissions,
+		roles:      roleToPermissions,
+	}
+	err := store.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Role permissions initialized", "roles", slices.Sorted(maps.Keys(store.Roles())), "database", config.Database)
+	return store, nil
+}
+
+// Load reloads the grants from the database
+func (store *Store) Load(ctx context.Context) error {
+	if !store.Config.Database {
+		return nil
+	}
+	grants, err := store.Grants(ctx)
+	if err != nil {
+		return err
+	}
+	store.mutex.RLock()
+	configured := store.configured
+	store.mutex.RUnlock()
+	loaded := make(map[string][]string, len(configured))
+	for role, permissions := range configured {
+		loaded[role] = slices.Clone(permissions)
+	}
+	for _, grant := range grants {
+		if !slices.Contains(loaded[grant.Role], grant.Permission) {
+			loaded[grant.Role] = append(loaded[grant.Role], grant.Permission)
+		}
+	}
+	store.mutex.Lock()
+	store.roles = loaded
+	store.mutex.Unlock()
+	return nil
+}
+
+// Configure replaces the role to permissions mapping of the application, e.g. after resources are registered
+// at runtime, and reloads the grants
+func (store *Store) Configure(ctx context.Context, roleToPermissions map[string][]string) error {
+	store.mutex.Lock()
+	store.configured = roleToPermissions
+	if !store.Config.Database {
+		store.roles = roleToPermissions
+	}
+	store.mutex.Unlock()
+	return store.Load(ctx)
+}
+
+// Run reloads the grants from the database periodically until the context is cancelled, so that the grants
+// of the other replicas are applied
+func (store *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(store.Config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := store.Load(ctx)
+			if err != nil {
+				slog.Error("Error refreshing role permissions", "error", err)
+			}
+		}
+	}
+}
+
+// Permissions returns the permissions of the role
+func (store *Store) Permissions(role string) []string {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return store.roles[role]
+}
+
+// Roles returns the permissions of all roles
+func (store *Store) Roles() map[string][]string {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return maps.Clone(store.roles)
+}
+
+// Grants returns the grants of the database ordered by role and permission
+func (store *Store) Grants(ctx context.Context) ([]Grant, error) {
+	var grants []Grant
+	err := store.DB.WithContext(ctx).Order("role, permission").Find(&grants).Error
+	if err != nil {
+		return nil, fmt.Errorf("cannot load role permissions: %w", err)
+	}
+	return grants, nil
+}
+
+// Grant grants the permission to the role, granting it again is not an error
+func (store *Store) Grant(ctx context.Context, role, permission string) (*Grant, error) {
+	now := domain.Now()
+	grant := &Grant{Role: role, Permission: permission, CreatedAt: &now}
+	err := store.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(grant).Error
+	if err != nil {
+		return nil, fmt.Errorf("cannot grant %s to %s: %w", permission, role, err)
+	}
+	return grant, store.Load(ctx)
+}
+
+// Revoke revokes the permission granted to the role, the permissions of the application are not changed.
+// It returns false if the permission was not granted in the database.
+func (store *Store) Revoke(ctx context.Context, role, permission string) (bool, error) {
+	result := store.DB.WithContext(ctx).Where("role = ? AND permission = ?", role, permission).Delete(&Grant{})
+	if result.Error != nil {
+		return false, fmt.Errorf("cannot revoke %s from %s: %w", permission, role, result.Error)
+	}
+	return result.RowsAffected > 0, store.Load(ctx)
+}
+
+// Replace replaces the permissions granted to the role in the database with the permissions in one transaction,
+// the permissions of the application are not changed. It returns false if they were granted already.
+func (store *Store) Replace(ctx context.Context, role string, permissions []string) (bool, error) {
+	changed := false
+	err := store.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		revoked := tx.Where("role = ?", role)
+		if len(permissions) > 0 {
+			revoked = revoked.Where("permission NOT IN ?", permissions)
+		}
+		result := revoked.Delete(&Grant{})
+		if result.Error != nil {
+			return result.Error
+		}
+		changed = result.RowsAffected > 0
+		now := domain.Now()
+		for _, permission := range permissions {
+			result = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Grant{Role: role, Permission: permission, CreatedAt: &now})
+			if result.Error != nil {
+				return result.Error
+			}
+			changed = changed || result.RowsAffected > 0
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("cannot replace the permissions of %s: %w", role, err)
+	}
+	return changed, store.Load(ctx)
+}
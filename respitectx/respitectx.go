@@ -0,0 +1,75 @@
+// Package respitectx reads and sets the values that the server keeps in the context of a request, so that the
+// handlers, hooks and plugins do not depend on the context keys.
+package respitectx
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/gofrs/uuid/v5"
+)
+
+// WithCurrentUser returns the context with the authenticated user of the request
+func WithCurrentUser(ctx context.Context, user *domain.User) context.Context {
+	return context.WithValue(ctx, common.CurrentUserKey, user)
+}
+
+// CurrentUser returns the authenticated user of the request, false without an authenticated user
+func CurrentUser(ctx context.Context) (*domain.User, bool) {
+	user, ok := ctx.Value(common.CurrentUserKey).(*domain.User)
+	return user, ok
+}
+
+// WithPermissions returns the context with the permissions of the current user
+func WithPermissions(ctx context.Context, permissions common.Permissions) context.Context {
+	return context.WithValue(ctx, common.CurrentUserPermissionsKey, permissions)
+}
+
+// Permissions returns the permissions of the current user, none without an authenticated user
+func Permissions(ctx context.Context) common.Permissions {
+	return common.GetPermissions(ctx)
+}
+
+// WithRequestID returns the context with the ID of the request, which is sent in the logs and to other services
+func WithRequestID(ctx context.Context, requestID uuid.UUID) context.Context {
+	return context.WithValue(ctx, common.RequestIDKey, requestID)
+}
+
+// RequestID returns the ID of the request, false outside of a request
+func RequestID(ctx context.Context) (uuid.UUID, bool) {
+	requestID, ok := ctx.Value(common.RequestIDKey).(uuid.UUID)
+	return requestID, ok
+}
+
+// WithTenant returns the context with the tenant of the request
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, common.TenantKey, tenant)
+}
+
+// Tenant returns the tenant of the request, empty without a tenant
+func Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(common.TenantKey).(string)
+	return tenant
+}
+
+// WithRequestContext returns the context with the request context of the resource of the request
+func WithRequestContext(ctx context.Context, requestContext *common.RequestContext) context.Context {
+	return context.WithValue(ctx, common.RequestContextKey, requestContext)
+}
+
+// RequestContext returns the request context of the resource of the request, nil outside of the resource routes
+func RequestContext(ctx context.Context) *common.RequestContext {
+	return common.GetRequestContext(ctx)
+}
+
+// WithLogger returns the context with the logger of the request
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, common.LoggerKey, logger)
+}
+
+// Logger returns the logger of the request, the default logger outside of a request
+func Logger(ctx context.Context) *slog.Logger {
+	return common.GetLogger(ctx)
+}
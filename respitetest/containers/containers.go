@@ -0,0 +1,261 @@
+package containers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v14"
+	"github.com/dzahariev/respite/api"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/respitetest"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+)
+
+// Images of the containers, the defaults match the docker-compose.yml of new projects
+var (
+	PostgresImage  = "postgres:17"
+	CockroachImage = "cockroachdb/cockroach:v24.3.5"
+	KeycloakImage  = "quay.io/keycloak/keycloak:26.0"
+)
+
+// REQUIRE_DOCKER is the environment variable that makes the tests fail instead of being skipped without Docker,
+// e.g. RESPITE_REQUIRE_DOCKER=true in CI
+const REQUIRE_DOCKER = "RESPITE_REQUIRE_DOCKER"
+
+// Expiry is the time after which Docker removes the containers of tests that did not clean up, e.g. when killed
+var Expiry = 10 * time.Minute
+
+// MaxWait is how long to wait for a container to become ready
+var MaxWait = 2 * time.Minute
+
+var (
+	poolOnce  sync.Once
+	pool      *dockertest.Pool
+	poolError error
+)
+
+// getPool connects to the Docker daemon of DOCKER_HOST once, the test is skipped if it is not available
+// unless RESPITE_REQUIRE_DOCKER is true
+func getPool(t testing.TB) *dockertest.Pool {
+	t.Helper()
+	poolOnce.Do(func() {
+		pool, poolError = dockertest.NewPool("")
+		if poolError == nil {
+			poolError = pool.Client.Ping()
+		}
+		if poolError == nil {
+			pool.MaxWait = MaxWait
+		}
+	})
+	if poolError != nil {
+		required, _ := strconv.ParseBool(os.Getenv(REQUIRE_DOCKER))
+		if required {
+			t.Fatalf("docker is not available: %v", poolError)
+		}
+		t.Skipf("docker is not available: %v", poolError)
+	}
+	return pool
+}
+
+// run starts the container and removes it at the end of the test
+func run(t testing.TB, image string, options *dockertest.RunOptions) *dockertest.Resource {
+	t.Helper()
+	pool := getPool(t)
+	repository, tag, _ := strings.Cut(image, ":")
+	options.Repository = repository
+	options.Tag = tag
+	resource, err := pool.RunWithOptions(options, func(config *docker.HostConfig) {
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		t.Fatalf("cannot start %s: %v", image, err)
+	}
+	t.Cleanup(func() {
+		err := pool.Purge(resource)
+		if err != nil {
+			t.Logf("cannot remove %s: %v", image, err)
+		}
+	})
+	err = resource.Expire(uint(Expiry.Seconds()))
+	if err != nil {
+		t.Fatalf("cannot set expiry of %s: %v", image, err)
+	}
+	return resource
+}
+
+// Postgres starts a throwaway PostgreSQL database and returns its configuration for api.NewServer,
+// the container is removed at the end of the test
+func Postgres(t testing.TB) cfg.DataBase {
+	t.Helper()
+	dbConfig := cfg.DataBase{
+		Backend:                  "postgres",
+		User:                     "respite",
+		Password:                 "respite",
+		Host:                     "localhost",
+		DatabaseName:             "respite",
+		CredentialsCheckInterval: 30 * time.Second,
+	}
+	resource := run(t, PostgresImage, &dockertest.RunOptions{
+		Env: []string{
+			"POSTGRES_USER=" + dbConfig.User,
+			"POSTGRES_PASSWORD=" + dbConfig.Password,
+			"POSTGRES_DB=" + dbConfig.DatabaseName,
+		},
+	})
+	hostPort := resource.GetHostPort("5432/tcp")
+	host, port, _ := strings.Cut(hostPort, ":")
+	dbConfig.Host = host
+	dbConfig.Port = port
+
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, dbConfig.User, dbConfig.Password, dbConfig.DatabaseName)
+	err := getPool(t).Retry(func() error {
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return db.Ping()
+	})
+	if err != nil {
+		t.Fatalf("postgres is not ready: %v", err)
+	}
+	return dbConfig
+}
+
+// Cockroach starts a throwaway single-node CockroachDB cluster in insecure mode and returns its configuration for
+// api.NewServer, so the tests of applications deployed on CockroachDB run against it. The container is removed at
+// the end of the test.
+func Cockroach(t testing.TB) cfg.DataBase {
+	t.Helper()
+	dbConfig := cfg.DataBase{
+		Backend:                  "cockroachdb",
+		User:                     "root",
+		Host:                     "localhost",
+		DatabaseName:             "respite",
+		CredentialsCheckInterval: 30 * time.Second,
+		TransactionRetries:       3,
+	}
+	resource := run(t, CockroachImage, &dockertest.RunOptions{
+		Cmd: []string{"start-single-node", "--insecure"},
+		Env: []string{"COCKROACH_DATABASE=" + dbConfig.DatabaseName},
+	})
+	hostPort := resource.GetHostPort("26257/tcp")
+	host, port, _ := strings.Cut(hostPort, ":")
+	dbConfig.Host = host
+	dbConfig.Port = port
+
+	dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable", host, port, dbConfig.User, dbConfig.DatabaseName)
+	err := getPool(t).Retry(func() error {
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		// The database of COCKROACH_DATABASE is created after the node accepts connections
+		var found int
+		return db.QueryRow("SELECT 1 FROM crdb_internal.databases WHERE name = $1", dbConfig.DatabaseName).Scan(&found)
+	})
+	if err != nil {
+		t.Fatalf("cockroachdb is not ready: %v", err)
+	}
+	return dbConfig
+}
+
+// realm is the part of a realm export used to configure the client
+type realm struct {
+	Realm   string `json:"realm"`
+	Clients []struct {
+		ClientID     string `json:"clientId"`
+		Secret       string `json:"secret"`
+		PublicClient bool   `json:"publicClient"`
+	} `json:"clients"`
+}
+
+// Keycloak starts a throwaway Keycloak in development mode with the realm of the export file, e.g. the realm.json
+// of new projects, and returns the configuration of its first confidential client for auth.NewClient.
+// The container is removed at the end of the test.
+func Keycloak(t testing.TB, realmFile string) cfg.Keycloak {
+	t.Helper()
+	path, err := filepath.Abs(realmFile)
+	if err != nil {
+		t.Fatalf("invalid realm file: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cannot read realm file: %v", err)
+	}
+	var export realm
+	err = json.Unmarshal(content, &export)
+	if err != nil {
+		t.Fatalf("invalid realm file %s: %v", path, err)
+	}
+	keycloakConfig := cfg.Keycloak{AuthRealm: export.Realm}
+	for _, client := range export.Clients {
+		if !client.PublicClient && client.Secret != "" {
+			keycloakConfig.AuthClientID = client.ClientID
+			keycloakConfig.AuthClientSecret = client.Secret
+			break
+		}
+	}
+	if keycloakConfig.AuthRealm == "" || keycloakConfig.AuthClientID == "" {
+		t.Fatalf("realm file %s has no realm with a confidential client", path)
+	}
+
+	resource := run(t, KeycloakImage, &dockertest.RunOptions{
+		Cmd: []string{"start-dev", "--import-realm"},
+		Env: []string{
+			"KC_BOOTSTRAP_ADMIN_USERNAME=admin",
+			"KC_BOOTSTRAP_ADMIN_PASSWORD=admin",
+		},
+		Mounts: []string{path + ":/opt/keycloak/data/import/realm.json:ro"},
+	})
+	keycloakConfig.AuthURL = "http://" + resource.GetHostPort("8080/tcp")
+
+	wellKnown := fmt.Sprintf("%s/realms/%s/.well-known/openid-configuration", keycloakConfig.AuthURL, keycloakConfig.AuthRealm)
+	err = getPool(t).Retry(func() error {
+		response, err := http.Get(wellKnown)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("realm is not available, status %d", response.StatusCode)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("keycloak is not ready: %v", err)
+	}
+	return keycloakConfig
+}
+
+// Token logs in the user of the realm with the password and returns the access token
+func Token(t testing.TB, keycloakConfig cfg.Keycloak, username, password string) string {
+	t.Helper()
+	token, err := gocloak.NewClient(keycloakConfig.AuthURL).Login(context.Background(), keycloakConfig.AuthClientID, keycloakConfig.AuthClientSecret, keycloakConfig.AuthRealm, username, password)
+	if err != nil {
+		t.Fatalf("cannot log in %s: %v", username, err)
+	}
+	return token.AccessToken
+}
+
+// Harness creates the test harness of respitetest with a throwaway PostgreSQL database instead of SQLite,
+// the tables of the resources are migrated
+func Harness(t testing.TB, modelObjects []domain.Object, roleToPermissions map[string][]string, options ...api.Option) *respitetest.Harness {
+	t.Helper()
+	return respitetest.NewWithDatabase(t, Postgres(t), modelObjects, roleToPermissions, options...)
+}
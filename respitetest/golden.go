@@ -0,0 +1,166 @@
+package respitetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// updateGolden rewrites the golden files with the actual responses, e.g. go test ./... -update-golden
+var updateGolden = flag.Bool("update-golden", false, "rewrite the golden files with the actual responses")
+
+// MASKED replaces the values of the masked fields
+const MASKED = "<masked>"
+
+// Canonicalize formats the JSON with sorted keys and indentation. UUIDs are replaced with placeholders numbered
+// by their first occurrence, e.g. <uuid-1>, so that references between objects stay comparable, and timestamps
+// are replaced with <time>. The values of the fields with the masked names are replaced with <masked>.
+func Canonicalize(data []byte, masked ...string) ([]byte, error) {
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&document)
+	if err != nil {
+		return nil, err
+	}
+	canonicalizer := &canonicalizer{masked: masked, uuids: map[string]string{}}
+	document = canonicalizer.value(document)
+
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(document)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// canonicalizer remembers the placeholders of the UUIDs of a document
+type canonicalizer struct {
+	masked []string
+	uuids  map[string]string
+}
+
+// value replaces the UUIDs, timestamps and masked fields, object keys are visited in sorted order
+func (canonicalizer *canonicalizer) value(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			if slices.Contains(canonicalizer.masked, key) {
+				typed[key] = MASKED
+				continue
+			}
+			typed[key] = canonicalizer.value(typed[key])
+		}
+		return typed
+	case []any:
+		for i, item := range typed {
+			typed[i] = canonicalizer.value(item)
+		}
+		return typed
+	case string:
+		return canonicalizer.string(typed)
+	default:
+		return value
+	}
+}
+
+// string replaces UUIDs and timestamps
+func (canonicalizer *canonicalizer) string(value string) string {
+	if id, err := uuid.FromString(value); err == nil && len(value) == 36 {
+		if id.IsNil() {
+			return value
+		}
+		placeholder, ok := canonicalizer.uuids[value]
+		if !ok {
+			placeholder = fmt.Sprintf("<uuid-%d>", len(canonicalizer.uuids)+1)
+			canonicalizer.uuids[value] = placeholder
+		}
+		return placeholder
+	}
+	if _, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return "<time>"
+	}
+	return value
+}
+
+// AssertGolden compares the canonicalized JSON with the golden file testdata/<name>.golden.json. With the
+// -update-golden flag the file is written instead, missing files are reported with the actual content.
+func AssertGolden(t testing.TB, name string, actual []byte, masked ...string) {
+	t.Helper()
+	canonical, err := Canonicalize(actual, masked...)
+	if err != nil {
+		t.Fatalf("cannot canonicalize %s: %v", actual, err)
+	}
+	path := filepath.Join("testdata", name+".golden.json")
+	if *updateGolden {
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+		if err == nil {
+			err = os.WriteFile(path, canonical, 0o644)
+		}
+		if err != nil {
+			t.Fatalf("cannot write golden file: %v", err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cannot read golden file, run the test with -update-golden to create it: %v\n%s", err, canonical)
+	}
+	if !bytes.Equal(expected, canonical) {
+		t.Errorf("response differs from %s, run the test with -update-golden to accept it:\n%s", path, diff(string(expected), string(canonical)))
+	}
+}
+
+// Golden checks the status of the response and compares its body with the golden file, the body is closed
+func (harness *Harness) Golden(response *http.Response, status int, name string, masked ...string) {
+	harness.T.Helper()
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		harness.T.Fatalf("cannot read response body: %v", err)
+	}
+	if response.StatusCode != status {
+		harness.T.Fatalf("expected status %d, got %d: %s", status, response.StatusCode, data)
+	}
+	AssertGolden(harness.T, name, data, masked...)
+}
+
+// diff lists the lines that differ, prefixed with - for the expected and + for the actual ones
+func diff(expected, actual string) string {
+	expectedLines := strings.Split(expected, "\n")
+	actualLines := strings.Split(actual, "\n")
+	builder := &strings.Builder{}
+	for i := 0; i < max(len(expectedLines), len(actualLines)); i++ {
+		var expectedLine, actualLine string
+		if i < len(expectedLines) {
+			expectedLine = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			actualLine = actualLines[i]
+		}
+		if expectedLine == actualLine {
+			continue
+		}
+		fmt.Fprintf(builder, "line %d:\n- %s\n+ %s\n", i+1, expectedLine, actualLine)
+	}
+	return builder.String()
+}
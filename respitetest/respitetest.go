@@ -0,0 +1,171 @@
+package respitetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dzahariev/respite/api"
+	"github.com/dzahariev/respite/auth/authtest"
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/domain"
+	"github.com/dzahariev/respite/fixtures"
+	"github.com/sethvargo/go-envconfig"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Harness is a server with the registered resources backed by an in-memory SQLite database and the fake
+// auth client, served by an httptest server. Background workers are not started.
+type Harness struct {
+	T      testing.TB
+	API    *api.Server
+	Auth   *authtest.Client
+	DB     *gorm.DB
+	Server *httptest.Server
+}
+
+// New creates the harness, the tables of the resources are migrated and the server is closed at the end of the test.
+// The server configuration uses the defaults, options configure the components.
+func New(t testing.TB, modelObjects []domain.Object, roleToPermissions map[string][]string, options ...api.Option) *Harness {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent), NowFunc: domain.Now})
+	if err != nil {
+		t.Fatalf("cannot open sqlite database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("cannot get sqlite connection: %v", err)
+	}
+	// Every connection opens its own in-memory database, so only one is kept
+	sqlDB.SetMaxOpenConns(1)
+
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+	return newHarness(t, cfg.DataBase{}, modelObjects, roleToPermissions, append([]api.Option{api.WithDB(db)}, options...))
+}
+
+// NewWithDatabase creates the harness with the PostgreSQL database of the configuration, e.g. a container
+// started by the containers package. The tables of the resources are migrated.
+func NewWithDatabase(t testing.TB, dbConfig cfg.DataBase, modelObjects []domain.Object, roleToPermissions map[string][]string, options ...api.Option) *Harness {
+	t.Helper()
+	harness := newHarness(t, dbConfig, modelObjects, roleToPermissions, options)
+	t.Cleanup(func() {
+		sqlDB, err := harness.DB.DB()
+		if err == nil {
+			sqlDB.Close()
+		}
+	})
+	return harness
+}
+
+// newHarness creates the server with the default configuration and the fake auth client, and migrates the resources
+func newHarness(t testing.TB, dbConfig cfg.DataBase, modelObjects []domain.Object, roleToPermissions map[string][]string, options []api.Option) *Harness {
+	t.Helper()
+	var serverConfig cfg.Server
+	var logConfig cfg.Logger
+	for _, target := range []any{&serverConfig, &logConfig} {
+		err := envconfig.ProcessWith(context.Background(), &envconfig.Config{Target: target, Lookuper: envconfig.MapLookuper(map[string]string{"LOG_LEVEL": "error"})})
+		if err != nil {
+			t.Fatalf("cannot create default configuration: %v", err)
+		}
+	}
+
+	authClient := authtest.New()
+	server, err := api.NewServer(serverConfig, logConfig, dbConfig, modelObjects, authClient, roleToPermissions, options...)
+	if err != nil {
+		t.Fatalf("cannot create server: %v", err)
+	}
+	err = common.Migrate(server.DB, server.Resources)
+	if err != nil {
+		t.Fatalf("cannot migrate resources: %v", err)
+	}
+
+	harness := &Harness{
+		T:      t,
+		API:    server,
+		Auth:   authClient,
+		DB:     server.DB,
+		Server: httptest.NewServer(server.Handler()),
+	}
+	t.Cleanup(harness.Server.Close)
+	return harness
+}
+
+// Load loads the fixtures files and returns the references to the IDs, the test fails if they cannot be loaded
+func (harness *Harness) Load(paths ...string) fixtures.References {
+	harness.T.Helper()
+	references, err := harness.API.LoadFixtures(context.Background(), paths...)
+	if err != nil {
+		harness.T.Fatalf("cannot load fixtures: %v", err)
+	}
+	return references
+}
+
+// Token creates a user with the roles and returns its token
+func (harness *Harness) Token(username string, roles ...string) string {
+	_, token := harness.Auth.NewUser(username, roles...)
+	return token
+}
+
+// URL returns the URL of the path on the API path, e.g. meals/{id}
+func (harness *Harness) URL(path string) string {
+	return fmt.Sprintf("%s/%s/%s", harness.Server.URL, harness.API.ServerConfig.APIPath, path)
+}
+
+// Do sends a request to the API path with the bearer token, the body is encoded as JSON unless it is nil.
+// The test fails if the request cannot be sent.
+func (harness *Harness) Do(method, path, token string, body any) *http.Response {
+	harness.T.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			harness.T.Fatalf("cannot encode request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequest(method, harness.URL(path), reader)
+	if err != nil {
+		harness.T.Fatalf("cannot create request: %v", err)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := harness.Server.Client().Do(request)
+	if err != nil {
+		harness.T.Fatalf("cannot send request %s %s: %v", method, path, err)
+	}
+	return response
+}
+
+// Decode checks the status of the response and decodes its JSON body into the target, the body is closed
+func (harness *Harness) Decode(response *http.Response, status int, target any) {
+	harness.T.Helper()
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		harness.T.Fatalf("cannot read response body: %v", err)
+	}
+	if response.StatusCode != status {
+		harness.T.Fatalf("expected status %d, got %d: %s", status, response.StatusCode, data)
+	}
+	if target == nil {
+		return
+	}
+	err = json.Unmarshal(data, target)
+	if err != nil {
+		harness.T.Fatalf("cannot decode response body %s: %v", data, err)
+	}
+}
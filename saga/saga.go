@@ -0,0 +1,186 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/dzahariev/respite/common"
+)
+
+const (
+	RUNNING      = "running"
+	COMPENSATING = "compensating"
+	COMPLETED    = "completed"
+	COMPENSATED  = "compensated"
+	FAILED       = "failed"
+)
+
+// ErrCompensated is returned for the sagas whose failed step was compensated
+var ErrCompensated = errors.New("saga compensated")
+
+// Step is a step of a saga that touches a resource or an external system. Compensate undoes the step when a later
+// step fails, it is nil for the steps without side effects. Both may run again after a crash, e.g. of the job
+// worker, so they must be idempotent.
+type Step struct {
+	Name       string
+	Run        func(ctx context.Context, state *State) error
+	Compensate func(ctx context.Context, state *State) error
+}
+
+// Saga runs its steps in order and compensates the completed steps in reverse order when a step fails, so that
+// the actions touching several resources or systems roll back consistently
+type Saga struct {
+	Name  string
+	Steps []Step
+}
+
+// New creates a saga of the steps
+func New(name string, steps ...Step) *Saga {
+	return &Saga{Name: name, Steps: steps}
+}
+
+// State is the progress of a saga, it is persisted after each step so that an interrupted saga resumes after its
+// completed steps
+type State struct {
+	Status      string   `json:"status"`
+	Completed   []string `json:"completed,omitempty"`
+	Compensated []string `json:"compensated,omitempty"`
+	// Data is shared by the steps, e.g. the IDs of the objects created by a step for its compensation
+	Data               map[string]json.RawMessage `json:"data,omitempty"`
+	Error              string                     `json:"error,omitempty"`
+	CompensationErrors []string                   `json:"compensation_errors,omitempty"`
+	// Input are the JSON parameters of the saga, e.g. of its operation, they are not persisted with the state
+	Input json.RawMessage `json:"-"`
+}
+
+// Set keeps the value in the data of the state
+func (state *State) Set(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("cannot encode %s: %w", key, err)
+	}
+	if state.Data == nil {
+		state.Data = map[string]json.RawMessage{}
+	}
+	state.Data[key] = data
+	return nil
+}
+
+// Get reads the value from the data of the state, false if it was not set
+func (state *State) Get(key string, value any) (bool, error) {
+	data, ok := state.Data[key]
+	if !ok {
+		return false, nil
+	}
+	err := json.Unmarshal(data, value)
+	if err != nil {
+		return true, fmt.Errorf("cannot decode %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// Run runs the saga from its state, a new state starts with the first step. The state is passed to save after
+// each change when save is given, e.g. to persist it in the job of the saga. Steps that fail or panic stop the
+// saga, the completed steps are compensated and ErrCompensated is returned with the error of the step. Errors of
+// compensations do not stop the other compensations, the saga fails with them. The failed step itself is not
+// compensated.
+func (saga *Saga) Run(ctx context.Context, state *State, save func(ctx context.Context, state *State) error) error {
+	logger := common.GetLogger(ctx).With("saga", saga.Name)
+	store := func() error {
+		if save == nil {
+			return nil
+		}
+		err := save(ctx, state)
+		if err != nil {
+			return fmt.Errorf("cannot save the state of saga %s: %w", saga.Name, err)
+		}
+		return nil
+	}
+	if state.Status == "" {
+		state.Status = RUNNING
+	}
+	if state.Status == RUNNING {
+		for _, step := range saga.Steps {
+			if slices.Contains(state.Completed, step.Name) {
+				continue
+			}
+			err := safeRun(ctx, step.Run, state)
+			if err != nil {
+				logger.Warn("Saga step failed, compensating", "step", step.Name, "error", err)
+				state.Status = COMPENSATING
+				state.Error = fmt.Sprintf("%s: %v", step.Name, err)
+				break
+			}
+			state.Completed = append(state.Completed, step.Name)
+			err = store()
+			if err != nil {
+				return err
+			}
+		}
+		if state.Status == RUNNING {
+			state.Status = COMPLETED
+			logger.Info("Saga completed", "steps", len(state.Completed))
+		}
+		err := store()
+		if err != nil {
+			return err
+		}
+	}
+	if state.Status == COMPENSATING {
+		// The steps are compensated also when the saga failed because it was canceled, e.g. by a shutdown
+		ctx = context.WithoutCancel(ctx)
+		for i := len(saga.Steps) - 1; i >= 0; i-- {
+			step := saga.Steps[i]
+			if !slices.Contains(state.Completed, step.Name) || slices.Contains(state.Compensated, step.Name) {
+				continue
+			}
+			if step.Compensate != nil {
+				err := safeRun(ctx, step.Compensate, state)
+				if err != nil {
+					logger.Error("Saga compensation failed", "step", step.Name, "error", err)
+					state.CompensationErrors = append(state.CompensationErrors, fmt.Sprintf("%s: %v", step.Name, err))
+					continue
+				}
+			}
+			state.Compensated = append(state.Compensated, step.Name)
+			err := store()
+			if err != nil {
+				return err
+			}
+		}
+		state.Status = COMPENSATED
+		if len(state.CompensationErrors) > 0 {
+			state.Status = FAILED
+		}
+		logger.Info("Saga compensated", "status", state.Status, "compensated", len(state.Compensated))
+		err := store()
+		if err != nil {
+			return err
+		}
+	}
+	return state.Err()
+}
+
+// Err returns the error of the finished saga, nil for the completed ones
+func (state *State) Err() error {
+	switch state.Status {
+	case COMPENSATED:
+		return fmt.Errorf("%w: %s", ErrCompensated, state.Error)
+	case FAILED:
+		return fmt.Errorf("saga failed: %s, compensations failed: %v", state.Error, state.CompensationErrors)
+	}
+	return nil
+}
+
+// safeRun runs the function of a step and turns panics into errors
+func safeRun(ctx context.Context, run func(ctx context.Context, state *State) error, state *State) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("step panicked: %v", recovered)
+		}
+	}()
+	return run(ctx, state)
+}
@@ -0,0 +1,93 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamavChunkSize is the size of the chunks streamed to clamd, below its default StreamMaxLength
+const clamavChunkSize = 64 * 1024
+
+// ClamAV scans with the INSTREAM command of a clamd daemon listening on TCP
+type ClamAV struct {
+	// Address is the host and port of clamd, e.g. clamav:3310
+	Address string
+	Timeout time.Duration
+}
+
+// Scan streams the content to clamd in chunks and reads its verdict
+func (clamav *ClamAV) Scan(ctx context.Context, content io.Reader) (Result, error) {
+	connection, err := dial(ctx, clamav.Address, clamav.Timeout)
+	if err != nil {
+		return Result{}, fmt.Errorf("cannot connect to clamd: %w", err)
+	}
+	defer connection.Close()
+
+	_, err = connection.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return Result{}, fmt.Errorf("cannot send to clamd: %w", err)
+	}
+	chunk := make([]byte, clamavChunkSize+4)
+	for {
+		n, readErr := io.ReadFull(content, chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			_, err = connection.Write(chunk[:n+4])
+			if err != nil {
+				return Result{}, fmt.Errorf("cannot send to clamd: %w", err)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+	// The zero length chunk ends the stream
+	_, err = connection.Write([]byte{0, 0, 0, 0})
+	if err != nil {
+		return Result{}, fmt.Errorf("cannot send to clamd: %w", err)
+	}
+	reply, err := bufio.NewReader(connection).ReadString(0)
+	if err != nil && err != io.EOF {
+		return Result{}, fmt.Errorf("cannot read the reply of clamd: %w", err)
+	}
+	return parseClamAVReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamAVReply reads the verdict of clamd, e.g. "stream: OK" or "stream: Eicar-Signature FOUND"
+func parseClamAVReply(reply string) (Result, error) {
+	verdict := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case verdict == "OK":
+		return Result{Clean: true}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return Result{Threat: strings.TrimSuffix(verdict, " FOUND")}, nil
+	}
+	return Result{}, fmt.Errorf("clamd failed: %s", reply)
+}
+
+// dial connects to the scanner, the deadline of the connection is the timeout or the one of the context
+func dial(ctx context.Context, address string, timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	connection, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		connection.SetDeadline(deadline)
+	}
+	return connection, nil
+}
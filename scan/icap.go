@@ -0,0 +1,109 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// icapThreatHeaders are the headers of the ICAP services that name the threat, e.g. of c-icap and Symantec
+var icapThreatHeaders = []string{"X-Infection-Found", "X-Violations-Found", "X-Virus-Id", "X-Virus-Name"}
+
+// ICAPScanner scans with the RESPMOD method of an ICAP service (RFC 3507), e.g. c-icap with ClamAV or the
+// antivirus appliances
+type ICAPScanner struct {
+	// Address is the host and port of the ICAP server, e.g. icap:1344
+	Address string
+	// Service is the path of the scanning service, e.g. avscan
+	Service string
+	Timeout time.Duration
+}
+
+// Scan sends the content as the body of an HTTP response to the service. The service answers 204 for the clean
+// content, other answers are the content it blocked.
+func (scanner *ICAPScanner) Scan(ctx context.Context, content io.Reader) (Result, error) {
+	connection, err := dial(ctx, scanner.Address, scanner.Timeout)
+	if err != nil {
+		return Result{}, fmt.Errorf("cannot connect to the ICAP server: %w", err)
+	}
+	defer connection.Close()
+
+	responseHeader := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n\r\n"
+	writer := bufio.NewWriter(connection)
+	fmt.Fprintf(writer, "RESPMOD icap://%s/%s ICAP/1.0\r\n", scanner.Address, strings.TrimPrefix(scanner.Service, "/"))
+	fmt.Fprintf(writer, "Host: %s\r\nAllow: 204\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n", scanner.Address, len(responseHeader))
+	writer.WriteString(responseHeader)
+	chunk := make([]byte, clamavChunkSize)
+	for {
+		n, readErr := content.Read(chunk)
+		if n > 0 {
+			fmt.Fprintf(writer, "%x\r\n", n)
+			writer.Write(chunk[:n])
+			writer.WriteString("\r\n")
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+	writer.WriteString("0\r\n\r\n")
+	err = writer.Flush()
+	if err != nil {
+		return Result{}, fmt.Errorf("cannot send to the ICAP server: %w", err)
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(connection))
+	status, err := reader.ReadLine()
+	if err != nil {
+		return Result{}, fmt.Errorf("cannot read the reply of the ICAP server: %w", err)
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return Result{}, fmt.Errorf("cannot read the reply of the ICAP server: %w", err)
+	}
+	return parseICAPReply(status, header)
+}
+
+// parseICAPReply reads the verdict of the ICAP service from the status and the headers of its reply
+func parseICAPReply(status string, header textproto.MIMEHeader) (Result, error) {
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return Result{}, fmt.Errorf("invalid reply of the ICAP server: %s", status)
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid reply of the ICAP server: %s", status)
+	}
+	switch {
+	case code == 204:
+		return Result{Clean: true}, nil
+	case code >= 200 && code < 300:
+		threat := "blocked by the ICAP service"
+		for _, name := range icapThreatHeaders {
+			if value := header.Get(name); value != "" {
+				threat = icapThreat(value)
+				break
+			}
+		}
+		return Result{Threat: threat}, nil
+	}
+	return Result{}, fmt.Errorf("ICAP server failed: %s", status)
+}
+
+// icapThreat returns the name of the threat of a header, e.g. of "Type=0; Resolution=2; Threat=Eicar-Signature;"
+func icapThreat(value string) string {
+	for _, part := range strings.Split(value, ";") {
+		key, threat, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && strings.EqualFold(key, "Threat") {
+			return threat
+		}
+	}
+	return strings.TrimSpace(value)
+}
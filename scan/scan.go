@@ -0,0 +1,52 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dzahariev/respite/cfg"
+)
+
+const (
+	CLAMAV = "clamav"
+	ICAP   = "icap"
+)
+
+// ErrInfected is returned for the content in which the scanner found a threat
+var ErrInfected = errors.New("content is infected")
+
+// Result is the outcome of a scan, Threat names what was found in the infected content
+type Result struct {
+	Clean  bool
+	Threat string
+}
+
+// Err returns ErrInfected with the threat of the infected content, nil for the clean one
+func (result Result) Err() error {
+	if result.Clean {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrInfected, result.Threat)
+}
+
+// Scanner checks uploaded content for malware before it becomes downloadable. Errors mean the content could not
+// be scanned, e.g. the scanner is unavailable, the infected content is reported by the result.
+type Scanner interface {
+	Scan(ctx context.Context, content io.Reader) (Result, error)
+}
+
+// New creates the scanner of the configured backend, nil when scanning is disabled
+func New(config cfg.Storage) (Scanner, error) {
+	switch config.ScanBackend {
+	case "":
+		return nil, nil
+	case CLAMAV:
+		return &ClamAV{Address: config.ScanAddress, Timeout: config.ScanTimeout}, nil
+	case ICAP:
+		return &ICAPScanner{Address: config.ScanAddress, Service: config.ScanICAPService, Timeout: config.ScanTimeout}, nil
+	default:
+		return nil, fmt.Errorf("unsupported scan backend: %s", config.ScanBackend)
+	}
+}
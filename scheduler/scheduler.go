@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/common"
+	"github.com/dzahariev/respite/coordination"
+	"github.com/dzahariev/respite/respitectx"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+// TaskFunc is executed on schedule
+type TaskFunc func(ctx context.Context, taskContext *TaskContext) error
+
+// TaskContext gives tasks access to the repository layer
+type TaskContext struct {
+	DB        *gorm.DB
+	Resources *common.Resources
+	// NewRequestContext creates a request context for the resource that is not scoped to an owner
+	NewRequestContext func(resourceName string, pageSize, page int) (*common.RequestContext, error)
+}
+
+// Task is a registered recurring task
+type Task struct {
+	Name     string
+	Spec     string
+	schedule cron.Schedule
+	run      TaskFunc
+	next     time.Time
+	running  bool
+}
+
+// Scheduler runs registered tasks on the instance holding the scheduler advisory lock or lease,
+// so that only one of multiple replicas executes them
+type Scheduler struct {
+	Config      cfg.Scheduler
+	DB          *gorm.DB
+	TaskContext *TaskContext
+	// Elector elects the leader running the tasks, each task runs with a lock of its own as well, so that a task
+	// of a previous leader that is still running is not started again
+	Elector *coordination.Elector
+	mutex   sync.Mutex
+	tasks   []*Task
+}
+
+// New creates a scheduler, zero values in the configuration are replaced by defaults
+func New(config cfg.Scheduler, taskContext *TaskContext) *Scheduler {
+	if config.LockName == "" {
+		config.LockName = "respite.scheduler"
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = time.Second
+	}
+	if config.LeaderRetry <= 0 {
+		config.LeaderRetry = 10 * time.Second
+	}
+	return &Scheduler{
+		Config:      config,
+		DB:          taskContext.DB,
+		TaskContext: taskContext,
+		Elector:     coordination.NewElector(taskContext.DB, config.LockName, config.LeaderRetry),
+	}
+}
+
+// Register adds a task executed according to the standard cron expression (minute hour day month weekday).
+// Descriptors like @hourly and @every 5m are supported as well.
+func (scheduler *Scheduler) Register(name, spec string, run TaskFunc) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q for task %s: %w", spec, name, err)
+	}
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	for _, task := range scheduler.tasks {
+		if task.Name == name {
+			return fmt.Errorf("task %s is already registered", name)
+		}
+	}
+	scheduler.tasks = append(scheduler.tasks, &Task{
+		Name:     name,
+		Spec:     spec,
+		schedule: schedule,
+		run:      run,
+		next:     schedule.Next(time.Now()),
+	})
+	slog.Info("Scheduled task registered", "task", name, "spec", spec)
+	return nil
+}
+
+// HasTasks checks if any task is registered
+func (scheduler *Scheduler) HasTasks() bool {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	return len(scheduler.tasks) > 0
+}
+
+// Run executes due tasks until the context is cancelled
+func (scheduler *Scheduler) Run(ctx context.Context) {
+	slog.Info("Scheduler started", "lock", scheduler.Config.LockName)
+	ticker := time.NewTicker(scheduler.Config.CheckInterval)
+	defer ticker.Stop()
+	defer scheduler.Elector.Resign()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Scheduler stopped")
+			return
+		case now := <-ticker.C:
+			scheduler.dispatch(ctx, now, scheduler.Elector.Lead(ctx))
+		}
+	}
+}
+
+// dispatch starts tasks that are due, tasks are skipped on followers and while their previous run is not finished
+func (scheduler *Scheduler) dispatch(ctx context.Context, now time.Time, leader bool) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	for _, task := range scheduler.tasks {
+		if now.Before(task.next) {
+			continue
+		}
+		task.next = task.schedule.Next(now)
+		if !leader || task.running {
+			continue
+		}
+		task.running = true
+		go scheduler.execute(ctx, task)
+	}
+}
+
+// execute runs a single task and logs the outcome
+func (scheduler *Scheduler) execute(ctx context.Context, task *Task) {
+	logger := slog.Default().With("task", task.Name)
+	taskCtx := respitectx.WithLogger(ctx, logger)
+	started := time.Now()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger.Error("Scheduled task panicked", "panic", recovered)
+		}
+		scheduler.mutex.Lock()
+		task.running = false
+		scheduler.mutex.Unlock()
+	}()
+	ran, err := coordination.Singleton(taskCtx, scheduler.Elector.Named(scheduler.Config.LockName+"."+task.Name), func(ctx context.Context) error {
+		logger.Info("Scheduled task started")
+		return task.run(ctx, scheduler.TaskContext)
+	})
+	if err != nil {
+		logger.Error("Scheduled task failed", "duration", time.Since(started), "error", err)
+		return
+	}
+	if !ran {
+		logger.Warn("Scheduled task skipped, it is running on another instance")
+		return
+	}
+	logger.Info("Scheduled task completed", "duration", time.Since(started))
+}
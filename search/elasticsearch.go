@@ -0,0 +1,180 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/gofrs/uuid/v5"
+)
+
+// Elasticsearch keeps the searchable objects in Elasticsearch or OpenSearch indices, one index per resource
+type Elasticsearch struct {
+	Config cfg.Search
+	Client *http.Client
+}
+
+// NewElasticsearch creates the indices of the searchable resources if they do not exist
+func NewElasticsearch(ctx context.Context, config cfg.Search) (*Elasticsearch, error) {
+	elasticsearch := &Elasticsearch{
+		Config: config,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, resource := range config.Resources {
+		err := elasticsearch.ensureIndex(ctx, resource)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create search index for %s: %w", resource, err)
+		}
+	}
+	slog.Info("Elasticsearch search provider initialized", "url", config.ElasticsearchURL)
+	return elasticsearch, nil
+}
+
+// Index adds or replaces the document of the object, the owner is kept in its user_id field that the owner scopes
+// filter on
+func (elasticsearch *Elasticsearch) Index(ctx context.Context, resource, id string, owner *uuid.UUID, document json.RawMessage) error {
+	if owner != nil {
+		var fields map[string]json.RawMessage
+		err := json.Unmarshal(document, &fields)
+		if err != nil {
+			return err
+		}
+		fields["user_id"], err = json.Marshal(owner)
+		if err != nil {
+			return err
+		}
+		document, err = json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := elasticsearch.do(ctx, http.MethodPut, fmt.Sprintf("/%s/_doc/%s", elasticsearch.index(resource), id), document)
+	return err
+}
+
+// Delete removes the document of the object
+func (elasticsearch *Elasticsearch) Delete(ctx context.Context, resource, id string) error {
+	_, err := elasticsearch.do(ctx, http.MethodDelete, fmt.Sprintf("/%s/_doc/%s", elasticsearch.index(resource), id), nil, http.StatusNotFound)
+	return err
+}
+
+// Query returns the objects matching the text in any field, ordered by relevance
+func (elasticsearch *Elasticsearch) Query(ctx context.Context, text string, scopes []Scope, page, pageSize int) (*Result, error) {
+	var indices []string
+	var should []map[string]any
+	for _, scope := range scopes {
+		index := elasticsearch.index(scope.Resource)
+		indices = append(indices, index)
+		filter := []map[string]any{{"term": map[string]any{"_index": index}}}
+		if scope.Owner != nil {
+			filter = append(filter, map[string]any{"term": map[string]any{"user_id": scope.Owner.String()}})
+		}
+		should = append(should, map[string]any{"bool": map[string]any{"filter": filter}})
+	}
+	result := &Result{PageSize: pageSize, Page: page, Data: []Hit{}}
+	if len(indices) == 0 {
+		return result, nil
+	}
+	query := map[string]any{
+		"from": (page - 1) * pageSize,
+		"size": pageSize,
+		"query": map[string]any{
+			"bool": map[string]any{
+				"must":   map[string]any{"multi_match": map[string]any{"query": text, "fields": []string{"*"}, "lenient": true}},
+				"filter": map[string]any{"bool": map[string]any{"should": should, "minimum_should_match": 1}},
+			},
+		},
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	response, err := elasticsearch.do(ctx, http.MethodPost, fmt.Sprintf("/%s/_search?ignore_unavailable=true", strings.Join(indices, ",")), body)
+	if err != nil {
+		return nil, err
+	}
+	var found struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Index  string          `json:"_index"`
+				ID     string          `json:"_id"`
+				Score  float64         `json:"_score"`
+				Source json.RawMessage `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	err = json.Unmarshal(response, &found)
+	if err != nil {
+		return nil, err
+	}
+	result.Count = found.Hits.Total.Value
+	for _, hit := range found.Hits.Hits {
+		result.Data = append(result.Data, Hit{
+			Resource: strings.TrimPrefix(hit.Index, elasticsearch.Config.IndexPrefix),
+			ID:       hit.ID,
+			Score:    hit.Score,
+			Object:   hit.Source,
+		})
+	}
+	return result, nil
+}
+
+// ensureIndex creates the index with keyword mapping of the identifiers used in filters
+func (elasticsearch *Elasticsearch) ensureIndex(ctx context.Context, resource string) error {
+	index := elasticsearch.index(resource)
+	_, err := elasticsearch.do(ctx, http.MethodHead, "/"+index, nil)
+	if err == nil {
+		return nil
+	}
+	mapping := []byte(`{"mappings":{"properties":{"id":{"type":"keyword"},"user_id":{"type":"keyword"}}}}`)
+	_, err = elasticsearch.do(ctx, http.MethodPut, "/"+index, mapping)
+	return err
+}
+
+// index returns the index name of the resource
+func (elasticsearch *Elasticsearch) index(resource string) string {
+	return elasticsearch.Config.IndexPrefix + resource
+}
+
+// do sends the request and returns the response body, statuses in accepted are not treated as errors
+func (elasticsearch *Elasticsearch) do(ctx context.Context, method, path string, body []byte, accepted ...int) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(elasticsearch.Config.ElasticsearchURL, "/")+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	switch {
+	case elasticsearch.Config.ElasticsearchAPIKey != "":
+		request.Header.Set("Authorization", "ApiKey "+elasticsearch.Config.ElasticsearchAPIKey)
+	case elasticsearch.Config.ElasticsearchUsername != "":
+		request.SetBasicAuth(elasticsearch.Config.ElasticsearchUsername, elasticsearch.Config.ElasticsearchPassword)
+	}
+	response, err := elasticsearch.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= http.StatusMultipleChoices && !slices.Contains(accepted, response.StatusCode) {
+		return nil, fmt.Errorf("elasticsearch %s %s failed with status %d: %s", method, path, response.StatusCode, responseBody)
+	}
+	return responseBody, nil
+}
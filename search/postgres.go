@@ -0,0 +1,127 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SearchDocument is the indexed copy of a searchable object
+type SearchDocument struct {
+	Resource  string    `gorm:"primaryKey"`
+	ID        uuid.UUID `gorm:"primaryKey"`
+	UserID    *uuid.UUID
+	Document  string `gorm:"type:jsonb"`
+	UpdatedAt time.Time
+}
+
+// TableName returns the search documents table name
+func (d *SearchDocument) TableName() string {
+	return "search_documents"
+}
+
+// Postgres keeps the searchable objects in a table with a full-text search vector of all string and numeric values
+type Postgres struct {
+	DB       *gorm.DB
+	Language string
+}
+
+// languagePattern matches valid text search configuration names, the name is part of the queries
+var languagePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// NewPostgres creates a provider using the text search configuration of the language, e.g. simple or english
+func NewPostgres(db *gorm.DB, language string) (*Postgres, error) {
+	if !languagePattern.MatchString(language) {
+		return nil, fmt.Errorf("invalid text search configuration: %q", language)
+	}
+	slog.Info("Postgres search provider initialized", "language", language)
+	return &Postgres{
+		DB:       db,
+		Language: language,
+	}, nil
+}
+
+// Index adds or replaces the document of the object and its owner
+func (postgres *Postgres) Index(ctx context.Context, resource, id string, owner *uuid.UUID, document json.RawMessage) error {
+	uid, err := uuid.FromString(id)
+	if err != nil {
+		return err
+	}
+	entry := &SearchDocument{
+		Resource:  resource,
+		ID:        uid,
+		UserID:    owner,
+		Document:  string(document),
+		UpdatedAt: time.Now(),
+	}
+	return postgres.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "resource"}, {Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "document", "updated_at"}),
+	}).Create(entry).Error
+}
+
+// Delete removes the document of the object
+func (postgres *Postgres) Delete(ctx context.Context, resource, id string) error {
+	return postgres.DB.WithContext(ctx).Where("resource = ? AND id = ?", resource, id).Delete(&SearchDocument{}).Error
+}
+
+// Query matches the text in web search syntax against the search vector, ordered by rank
+func (postgres *Postgres) Query(ctx context.Context, text string, scopes []Scope, page, pageSize int) (*Result, error) {
+	result := &Result{PageSize: pageSize, Page: page, Data: []Hit{}}
+	if len(scopes) == 0 {
+		return result, nil
+	}
+	var conditions []string
+	var args []any
+	for _, scope := range scopes {
+		if scope.Owner != nil {
+			conditions = append(conditions, "(resource = ? AND user_id = ?)")
+			args = append(args, scope.Resource, *scope.Owner)
+		} else {
+			conditions = append(conditions, "resource = ?")
+			args = append(args, scope.Resource)
+		}
+	}
+	vector := fmt.Sprintf("jsonb_to_tsvector('%s', document, '[\"string\", \"numeric\"]')", postgres.Language)
+	query := postgres.DB.WithContext(ctx).Model(&SearchDocument{}).
+		Where(fmt.Sprintf("%s @@ websearch_to_tsquery('%s', ?)", vector, postgres.Language), text).
+		Where("("+strings.Join(conditions, " OR ")+")", args...).
+		Session(&gorm.Session{})
+
+	err := query.Count(&result.Count).Error
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Resource string
+		ID       string
+		Score    float64
+		Document string
+	}
+	err = query.
+		Select(fmt.Sprintf("resource, id, document, ts_rank(%s, websearch_to_tsquery('%s', ?)) AS score", vector, postgres.Language), text).
+		Order("score DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result.Data = append(result.Data, Hit{
+			Resource: row.Resource,
+			ID:       row.ID,
+			Score:    row.Score,
+			Object:   json.RawMessage(row.Document),
+		})
+	}
+	return result, nil
+}
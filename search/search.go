@@ -0,0 +1,100 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/events"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	POSTGRES      = "postgres"
+	ELASTICSEARCH = "elasticsearch"
+)
+
+// Scope restricts a query to a resource, and to the objects of an owner if set
+type Scope struct {
+	Resource string
+	Owner    *uuid.UUID
+}
+
+// Hit is a matched object
+type Hit struct {
+	Resource string          `json:"resource"`
+	ID       string          `json:"id"`
+	Score    float64         `json:"score"`
+	Object   json.RawMessage `json:"object"`
+}
+
+// Result is a page of matched objects ordered by relevance
+type Result struct {
+	Count    int64 `json:"count"`
+	PageSize int   `json:"page_size"`
+	Page     int   `json:"page"`
+	Data     []Hit `json:"data"`
+}
+
+// Provider is an abstraction of the search engines keeping the searchable objects
+type Provider interface {
+	// Index adds or replaces the JSON document of the object, owner is the owner of the objects of owned resources
+	Index(ctx context.Context, resource, id string, owner *uuid.UUID, document json.RawMessage) error
+	// Delete removes the object, missing objects are ignored
+	Delete(ctx context.Context, resource, id string) error
+	// Query returns the objects matching the text within the scopes, ordered by relevance
+	Query(ctx context.Context, text string, scopes []Scope, page, pageSize int) (*Result, error)
+}
+
+// NewProvider creates the provider for the configured search engine
+func NewProvider(ctx context.Context, config cfg.Search, db *gorm.DB) (Provider, error) {
+	switch config.Provider {
+	case POSTGRES:
+		return NewPostgres(db, config.PostgresLanguage)
+	case ELASTICSEARCH:
+		return NewElasticsearch(ctx, config)
+	default:
+		return nil, fmt.Errorf("unsupported search provider: %s", config.Provider)
+	}
+}
+
+// Indexer keeps the provider in sync with the mutation events of the searchable resources
+type Indexer struct {
+	Provider  Provider
+	Resources []string
+}
+
+// NewIndexer creates an indexer for the given resources
+func NewIndexer(provider Provider, resources []string) *Indexer {
+	slog.Info("Search indexer initialized", "resources", resources)
+	return &Indexer{
+		Provider:  provider,
+		Resources: resources,
+	}
+}
+
+// Searchable checks if the resource is indexed
+func (indexer *Indexer) Searchable(resource string) bool {
+	return slices.Contains(indexer.Resources, resource)
+}
+
+// Publish indexes created and updated objects and removes deleted ones, the events carry the stored objects and
+// their owners
+func (indexer *Indexer) Publish(ctx context.Context, event events.Event) error {
+	if !indexer.Searchable(event.Resource) {
+		return nil
+	}
+	if event.Action == events.DELETED {
+		return indexer.Provider.Delete(ctx, event.Resource, event.ObjectID.String())
+	}
+	return indexer.Provider.Index(ctx, event.Resource, event.ObjectID.String(), event.OwnerID, event.Data)
+}
+
+// Close does nothing, providers keep no state to release
+func (indexer *Indexer) Close() error {
+	return nil
+}
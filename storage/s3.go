@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+const (
+	SSE_NONE = "none"
+	SSE_S3   = "s3"
+	SSE_KMS  = "kms"
+)
+
+// S3Storage keeps objects in an S3 compatible bucket, e.g. AWS S3 or MinIO
+type S3Storage struct {
+	Client     *minio.Client
+	Bucket     string
+	Prefix     string
+	Encryption encrypt.ServerSide
+}
+
+// NewS3Storage creates the client for the configured bucket
+func NewS3Storage(config cfg.Storage) (*S3Storage, error) {
+	client, err := minio.New(config.S3Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.S3AccessKey, config.S3SecretKey, ""),
+		Secure: config.S3UseSSL,
+		Region: config.S3Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create s3 client: %w", err)
+	}
+	storage := &S3Storage{
+		Client: client,
+		Bucket: config.S3Bucket,
+		Prefix: config.S3Prefix,
+	}
+	switch config.S3Encryption {
+	case SSE_NONE, "":
+	case SSE_S3:
+		storage.Encryption = encrypt.NewSSE()
+	case SSE_KMS:
+		storage.Encryption, err = encrypt.NewSSEKMS(config.S3KMSKeyID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3 kms configuration: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported s3 encryption: %s", config.S3Encryption)
+	}
+	return storage, nil
+}
+
+// Put uploads the object
+func (storage *S3Storage) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	_, err := storage.Client.PutObject(ctx, storage.Bucket, storage.objectName(key), reader, size, minio.PutObjectOptions{
+		ContentType:          contentType,
+		ServerSideEncryption: storage.Encryption,
+	})
+	return err
+}
+
+// Get opens the object for reading
+func (storage *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	_, err := storage.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Client.GetObject(ctx, storage.Bucket, storage.objectName(key), minio.GetObjectOptions{})
+}
+
+// Stat returns the size of the object
+func (storage *S3Storage) Stat(ctx context.Context, key string) (int64, error) {
+	info, err := storage.Client.StatObject(ctx, storage.Bucket, storage.objectName(key), minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// Delete removes the object
+func (storage *S3Storage) Delete(ctx context.Context, key string) error {
+	return storage.Client.RemoveObject(ctx, storage.Bucket, storage.objectName(key), minio.RemoveObjectOptions{})
+}
+
+// PresignGet returns a time limited download URL
+func (storage *S3Storage) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	url, err := storage.Client.PresignedGetObject(ctx, storage.Bucket, storage.objectName(key), expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return url.String(), nil
+}
+
+// PresignPut returns a time limited upload URL
+func (storage *S3Storage) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	url, err := storage.Client.PresignedPutObject(ctx, storage.Bucket, storage.objectName(key), expiry)
+	if err != nil {
+		return "", err
+	}
+	return url.String(), nil
+}
+
+// objectName prepends the configured prefix
+func (storage *S3Storage) objectName(key string) string {
+	if storage.Prefix == "" {
+		return key
+	}
+	return path.Join(storage.Prefix, key)
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+)
+
+const (
+	LOCAL = "local"
+	S3    = "s3"
+)
+
+var (
+	// ErrNotFound is returned when the object does not exist
+	ErrNotFound = errors.New("object not found")
+	// ErrPresignNotSupported is returned by backends that cannot issue presigned URLs
+	ErrPresignNotSupported = errors.New("presigned urls are not supported by the storage backend")
+)
+
+// Storage is an abstraction of all binary object storage backends
+type Storage interface {
+	Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (int64, error)
+	Delete(ctx context.Context, key string) error
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+	PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// New creates the storage for the configured backend
+func New(config cfg.Storage) (Storage, error) {
+	switch config.Backend {
+	case LOCAL:
+		return NewLocalStorage(config.LocalPath)
+	case S3:
+		return NewS3Storage(config)
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %s", config.Backend)
+	}
+}
@@ -0,0 +1,216 @@
+package tenants
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/dzahariev/respite/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrExists is returned for the creation of a tenant that exists
+var ErrExists = errors.New("tenant exists")
+
+// Tenant overrides the limits and the feature flags of the configuration for the users of a tenant, e.g. with the
+// limits of its plan. Zero and missing values keep the limits of the configuration.
+type Tenant struct {
+	ID   string `json:"id" gorm:"primaryKey"`
+	Name string `json:"name,omitempty"`
+	// Suspended rejects the requests of the users of the tenant
+	Suspended bool `json:"suspended"`
+	// Domains are the custom domains of the tenant, e.g. of white-label deployments, the requests to them are of the tenant
+	Domains []string `json:"domains,omitempty" gorm:"serializer:json"`
+	// MaxPageSize replaces SERVER_MAX_PAGE_SIZE, it may be lower or higher
+	MaxPageSize int `json:"max_page_size,omitempty"`
+	// Quotas replace QUOTA_LIMITS per resource, * applies to the others
+	Quotas map[string]int64 `json:"quotas,omitempty" gorm:"serializer:json"`
+	// RateLimits replace CACHE_RESOURCE_RATE_LIMITS per resource, * applies to the others
+	RateLimits map[string]int64 `json:"rate_limits,omitempty" gorm:"serializer:json"`
+	// Flags turn the feature flags on or off for all users of the tenant
+	Flags map[string]bool `json:"flags,omitempty" gorm:"serializer:json"`
+	// ProvisionedAt is when the provisioning of the tenant completed, ProvisionError is the error of its last run
+	ProvisionedAt  *time.Time `json:"provisioned_at,omitempty"`
+	ProvisionError string     `json:"provision_error,omitempty"`
+	CreatedAt      *time.Time `json:"created_at,omitempty"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+// TableName returns the tenants table name
+func (t *Tenant) TableName() string {
+	return "tenants"
+}
+
+// Quota returns the quota of the resource, false if the tenant keeps the configured one
+func (t *Tenant) Quota(resource string) (int64, bool) {
+	return limit(t.Quotas, resource)
+}
+
+// RateLimit returns the rate limit of the resource, false if the tenant keeps the configured one
+func (t *Tenant) RateLimit(resource string) (int64, bool) {
+	return limit(t.RateLimits, resource)
+}
+
+// limit returns the limit of the resource or of *
+func limit(limits map[string]int64, resource string) (int64, bool) {
+	value, ok := limits[resource]
+	if !ok {
+		value, ok = limits["*"]
+	}
+	return value, ok
+}
+
+// Store keeps the tenants of the tenants table in memory, so that the requests do not query them
+type Store struct {
+	Config  cfg.Tenants
+	DB      *gorm.DB
+	mutex   sync.RWMutex
+	tenants map[string]Tenant
+	domains map[string]string
+}
+
+// New creates the store and loads the tenants
+func New(ctx context.Context, config cfg.Tenants, db *gorm.DB) (*Store, error) {
+	store := &Store{
+		Config:  config,
+		DB:      db,
+		tenants: map[string]Tenant{},
+		domains: map[string]string{},
+	}
+	err := store.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Tenants initialized", "tenants", len(store.tenants))
+	return store, nil
+}
+
+// Load reloads the tenants from the database
+func (store *Store) Load(ctx context.Context) error {
+	tenants, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]Tenant, len(tenants))
+	domains := map[string]string{}
+	for _, tenant := range tenants {
+		loaded[tenant.ID] = tenant
+		for _, domain := range tenant.Domains {
+			domains[NormalizeDomain(domain)] = tenant.ID
+		}
+	}
+	store.mutex.Lock()
+	store.tenants = loaded
+	store.domains = domains
+	store.mutex.Unlock()
+	return nil
+}
+
+// Run reloads the tenants from the database periodically until the context is cancelled, so that the changes
+// of the other replicas are applied
+func (store *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(store.Config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := store.Load(ctx)
+			if err != nil {
+				slog.Error("Error refreshing tenants", "error", err)
+			}
+		}
+	}
+}
+
+// Get returns the loaded tenant, false if it does not exist
+func (store *Store) Get(id string) (*Tenant, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	tenant, ok := store.tenants[id]
+	return &tenant, ok
+}
+
+// ForDomain returns the ID of the tenant of the custom domain, false if the domain is not of a tenant
+func (store *Store) ForDomain(domain string) (string, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	id, ok := store.domains[NormalizeDomain(domain)]
+	return id, ok
+}
+
+// NormalizeDomain returns the domain in lower case without the port and the trailing dot, e.g. of the Host header
+func NormalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+	return strings.TrimSuffix(domain, ".")
+}
+
+// List returns the tenants of the database ordered by ID
+func (store *Store) List(ctx context.Context) ([]Tenant, error) {
+	var tenants []Tenant
+	err := store.DB.WithContext(ctx).Order("id").Find(&tenants).Error
+	if err != nil {
+		return nil, fmt.Errorf("cannot load tenants: %w", err)
+	}
+	return tenants, nil
+}
+
+// Find returns the tenant of the database, gorm.ErrRecordNotFound if it does not exist
+func (store *Store) Find(ctx context.Context, id string) (*Tenant, error) {
+	tenant := &Tenant{}
+	err := store.DB.WithContext(ctx).Where("id = ?", id).First(tenant).Error
+	if err != nil {
+		return nil, fmt.Errorf("cannot load tenant %s: %w", id, err)
+	}
+	return tenant, nil
+}
+
+// Create creates the tenant, ErrExists if a tenant with its ID exists
+func (store *Store) Create(ctx context.Context, tenant *Tenant) error {
+	now := domain.Now()
+	tenant.CreatedAt = &now
+	tenant.UpdatedAt = &now
+	result := store.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(tenant)
+	if result.Error != nil {
+		return fmt.Errorf("cannot create tenant %s: %w", tenant.ID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrExists, tenant.ID)
+	}
+	return store.Load(ctx)
+}
+
+// Save creates or replaces the tenant
+func (store *Store) Save(ctx context.Context, tenant *Tenant) error {
+	now := domain.Now()
+	tenant.UpdatedAt = &now
+	if tenant.CreatedAt == nil {
+		tenant.CreatedAt = &now
+	}
+	err := store.DB.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(tenant).Error
+	if err != nil {
+		return fmt.Errorf("cannot save tenant %s: %w", tenant.ID, err)
+	}
+	return store.Load(ctx)
+}
+
+// Delete removes the tenant, its users get the limits of the configuration again. It returns false if the tenant
+// does not exist.
+func (store *Store) Delete(ctx context.Context, id string) (bool, error) {
+	result := store.DB.WithContext(ctx).Where("id = ?", id).Delete(&Tenant{})
+	if result.Error != nil {
+		return false, fmt.Errorf("cannot delete tenant %s: %w", id, result.Error)
+	}
+	return result.RowsAffected > 0, store.Load(ctx)
+}
@@ -0,0 +1,175 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dzahariev/respite/cfg"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	instrumentationName = "github.com/dzahariev/respite"
+	spanKey             = "tracing:span"
+)
+
+// Tracer returns the tracer of the library, spans are dropped until a tracer provider is registered
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Tracing holds the tracer provider exporting spans to the OTLP endpoint
+type Tracing struct {
+	Config   cfg.Tracing
+	Provider *sdktrace.TracerProvider
+}
+
+// New creates the tracer provider and registers it globally together with the W3C trace context propagator.
+// The exporter follows the standard OTEL_EXPORTER_OTLP_* variables unless an endpoint is configured.
+func New(ctx context.Context, config cfg.Tracing) (*Tracing, error) {
+	var options []otlptracehttp.Option
+	if config.Endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpointURL(config.Endpoint))
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create trace exporter: %w", err)
+	}
+	serviceResource, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(config.ServiceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create trace resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(serviceResource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return &Tracing{
+		Config:   config,
+		Provider: provider,
+	}, nil
+}
+
+// Shutdown exports the pending spans and stops the tracer provider
+func (tracing *Tracing) Shutdown(ctx context.Context) error {
+	return tracing.Provider.Shutdown(ctx)
+}
+
+// Middleware continues the trace of the incoming request in a server span named by the route template
+func Middleware(next http.Handler) http.Handler {
+	return otelhttp.NewMiddleware("http", otelhttp.WithSpanNameFormatter(spanName))(next)
+}
+
+// Transport wraps the transport to create client spans and propagate the trace context to outgoing requests
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
+
+// spanName uses the route template instead of the path to keep the number of span names bounded
+func spanName(operation string, r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return r.Method
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return r.Method
+	}
+	return r.Method + " " + template
+}
+
+// LogAttributes returns the trace_id and span_id attributes of the span in the context, to join logs with traces
+func LogAttributes(ctx context.Context) []any {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil
+	}
+	return []any{"trace_id", spanContext.TraceID().String(), "span_id", spanContext.SpanID().String()}
+}
+
+// statementSpan keeps the span of a statement and the context to restore when it ends
+type statementSpan struct {
+	span   trace.Span
+	parent context.Context
+}
+
+// InstrumentDB creates a client span for each statement with the database attributes, the query text
+// contains only placeholders and is recorded if statements are enabled
+func InstrumentDB(db *gorm.DB, statements bool) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("tracing:before_create", start("create")),
+		callbacks.Create().After("gorm:create").Register("tracing:after_create", end("create", statements)),
+		callbacks.Query().Before("gorm:query").Register("tracing:before_query", start("query")),
+		callbacks.Query().After("gorm:query").Register("tracing:after_query", end("query", statements)),
+		callbacks.Update().Before("gorm:update").Register("tracing:before_update", start("update")),
+		callbacks.Update().After("gorm:update").Register("tracing:after_update", end("update", statements)),
+		callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", start("delete")),
+		callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", end("delete", statements)),
+		callbacks.Row().Before("gorm:row").Register("tracing:before_row", start("row")),
+		callbacks.Row().After("gorm:row").Register("tracing:after_row", end("row", statements)),
+		callbacks.Raw().Before("gorm:raw").Register("tracing:before_raw", start("raw")),
+		callbacks.Raw().After("gorm:raw").Register("tracing:after_raw", end("raw", statements)),
+	)
+}
+
+// start opens the span of the statement as a child of the statement context
+func start(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		parent := db.Statement.Context
+		if parent == nil {
+			parent = context.Background()
+		}
+		ctx, span := Tracer().Start(parent, "gorm."+operation, trace.WithSpanKind(trace.SpanKindClient))
+		db.Statement.Context = ctx
+		db.InstanceSet(spanKey, statementSpan{span: span, parent: parent})
+	}
+}
+
+// end records the statement attributes and the outcome and closes the span
+func end(operation string, statements bool) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(spanKey)
+		if !ok {
+			return
+		}
+		current := value.(statementSpan)
+		db.Statement.Context = current.parent
+		span := current.span
+		defer span.End()
+		span.SetAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBOperationName(operation),
+			attribute.Int64("db.rows_affected", db.RowsAffected),
+		)
+		if db.Statement.Table != "" {
+			span.SetAttributes(semconv.DBCollectionName(db.Statement.Table))
+		}
+		if statements {
+			span.SetAttributes(semconv.DBQueryText(db.Statement.SQL.String()))
+		}
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			span.RecordError(db.Error)
+			span.SetStatus(codes.Error, db.Error.Error())
+		}
+	}
+}
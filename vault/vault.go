@@ -0,0 +1,290 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzahariev/respite/cfg"
+)
+
+const (
+	TOKEN      = "token"
+	KUBERNETES = "kubernetes"
+	APPROLE    = "approle"
+)
+
+// Secret is the response of Vault for secrets and logins
+type Secret struct {
+	LeaseID       string         `json:"lease_id"`
+	LeaseDuration int            `json:"lease_duration"`
+	Renewable     bool           `json:"renewable"`
+	Data          map[string]any `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
+
+// Credentials are the username and password of a Vault secret, with its lease for dynamic credentials
+type Credentials struct {
+	Username      string
+	Password      string
+	LeaseID       string
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// Client reads the credentials of the server from Vault
+type Client struct {
+	Config cfg.Vault
+	Client *http.Client
+	mutex  sync.RWMutex
+	token  string
+	login  *Secret
+}
+
+// New creates the client and logs in with the configured auth method
+func New(ctx context.Context, config cfg.Vault) (*Client, error) {
+	client := &Client{
+		Config: config,
+		Client: &http.Client{Timeout: 30 * time.Second},
+		token:  config.Token,
+	}
+	err := client.authenticate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot log in to vault: %w", err)
+	}
+	slog.Info("Vault client initialized", "address", config.Address, "auth", config.AuthMethod)
+	return client, nil
+}
+
+// authenticate obtains the client token, tokens given in the configuration are used as they are
+func (client *Client) authenticate(ctx context.Context) error {
+	var path string
+	var body map[string]string
+	switch client.Config.AuthMethod {
+	case TOKEN:
+		if client.Config.Token == "" {
+			return errors.New("vault token is required for the token auth method")
+		}
+		return nil
+	case KUBERNETES:
+		jwt, err := os.ReadFile(client.Config.KubernetesTokenPath)
+		if err != nil {
+			return fmt.Errorf("cannot read service account token: %w", err)
+		}
+		path = fmt.Sprintf("/v1/auth/%s/login", client.Config.KubernetesMount)
+		body = map[string]string{"role": client.Config.Role, "jwt": strings.TrimSpace(string(jwt))}
+	case APPROLE:
+		path = fmt.Sprintf("/v1/auth/%s/login", client.Config.AppRoleMount)
+		body = map[string]string{"role_id": client.Config.AppRoleID, "secret_id": client.Config.AppRoleSecretID}
+	default:
+		return fmt.Errorf("unsupported vault auth method: %s", client.Config.AuthMethod)
+	}
+	login, err := client.do(ctx, http.MethodPost, path, body, false)
+	if err != nil {
+		return err
+	}
+	if login.Auth == nil || login.Auth.ClientToken == "" {
+		return errors.New("vault login returned no token")
+	}
+	client.mutex.Lock()
+	client.token = login.Auth.ClientToken
+	client.login = login
+	client.mutex.Unlock()
+	return nil
+}
+
+// Read returns the secret at the path, like creds/{role} of a database secrets engine
+func (client *Client) Read(ctx context.Context, path string) (*Secret, error) {
+	return client.do(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(path, "/"), nil, true)
+}
+
+// KV returns the data of the secret in the configured KV version 2 secrets engine
+func (client *Client) KV(ctx context.Context, path string) (map[string]any, error) {
+	secret, err := client.Read(ctx, fmt.Sprintf("%s/data/%s", client.Config.KVMount, strings.TrimPrefix(path, "/")))
+	if err != nil {
+		return nil, err
+	}
+	data, _ := secret.Data["data"].(map[string]any)
+	if data == nil {
+		return nil, fmt.Errorf("vault secret %s has no data", path)
+	}
+	return data, nil
+}
+
+// KVString returns a string value of the secret in the KV secrets engine
+func (client *Client) KVString(ctx context.Context, path, key string) (string, error) {
+	data, err := client.KV(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no %s", path, key)
+	}
+	return value, nil
+}
+
+// DatabaseCredentials returns dynamic credentials of the configured database role, or the static
+// username and password of the configured KV secret
+func (client *Client) DatabaseCredentials(ctx context.Context) (*Credentials, error) {
+	if client.Config.DatabaseRole == "" {
+		data, err := client.KV(ctx, client.Config.DatabaseSecret)
+		if err != nil {
+			return nil, err
+		}
+		username, _ := data["username"].(string)
+		password, _ := data["password"].(string)
+		return &Credentials{Username: username, Password: password}, nil
+	}
+	secret, err := client.Read(ctx, fmt.Sprintf("%s/creds/%s", client.Config.DatabaseMount, client.Config.DatabaseRole))
+	if err != nil {
+		return nil, err
+	}
+	username, _ := secret.Data["username"].(string)
+	password, _ := secret.Data["password"].(string)
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("vault database role %s returned no credentials", client.Config.DatabaseRole)
+	}
+	return &Credentials{
+		Username:      username,
+		Password:      password,
+		LeaseID:       secret.LeaseID,
+		LeaseDuration: time.Duration(secret.LeaseDuration) * time.Second,
+		Renewable:     secret.Renewable,
+	}, nil
+}
+
+// KeepDatabaseCredentials renews the lease of dynamic credentials until the context is cancelled. When the
+// lease cannot be renewed, or reaches its maximum TTL, new credentials are read and passed to rotate.
+func (client *Client) KeepDatabaseCredentials(ctx context.Context, credentials *Credentials, rotate func(*Credentials)) {
+	if credentials.LeaseID == "" {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(renewDelay(credentials.LeaseDuration)):
+		}
+		if credentials.Renewable {
+			secret, err := client.do(ctx, http.MethodPut, "/v1/sys/leases/renew", map[string]any{
+				"lease_id":  credentials.LeaseID,
+				"increment": int(credentials.LeaseDuration.Seconds()),
+			}, true)
+			if err == nil && time.Duration(secret.LeaseDuration)*time.Second >= credentials.LeaseDuration/2 {
+				credentials.LeaseDuration = time.Duration(secret.LeaseDuration) * time.Second
+				slog.Debug("Database credentials lease renewed", "lease", credentials.LeaseID, "duration", credentials.LeaseDuration)
+				continue
+			}
+			if err != nil {
+				slog.Error("Error renewing database credentials lease", "lease", credentials.LeaseID, "error", err)
+			}
+		}
+		next, err := client.DatabaseCredentials(ctx)
+		if err != nil {
+			slog.Error("Error reading new database credentials", "error", err)
+			credentials.LeaseDuration = time.Minute
+			credentials.Renewable = false
+			continue
+		}
+		slog.Info("Database credentials rotated", "username", next.Username, "lease", next.LeaseID)
+		rotate(next)
+		credentials = next
+	}
+}
+
+// KeepToken renews the login token until the context is cancelled, and logs in again when it cannot be renewed
+func (client *Client) KeepToken(ctx context.Context) {
+	for {
+		client.mutex.RLock()
+		login := client.login
+		client.mutex.RUnlock()
+		if login == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(renewDelay(time.Duration(login.Auth.LeaseDuration) * time.Second)):
+		}
+		if login.Auth.Renewable {
+			renewed, err := client.do(ctx, http.MethodPost, "/v1/auth/token/renew-self", map[string]any{}, true)
+			if err == nil && renewed.Auth != nil {
+				client.mutex.Lock()
+				client.login = renewed
+				client.mutex.Unlock()
+				continue
+			}
+			slog.Error("Error renewing vault token", "error", err)
+		}
+		err := client.authenticate(ctx)
+		if err != nil {
+			slog.Error("Error logging in to vault", "error", err)
+		}
+	}
+}
+
+// renewDelay returns the time to wait before renewing a lease, two thirds of its duration
+func renewDelay(leaseDuration time.Duration) time.Duration {
+	delay := leaseDuration * 2 / 3
+	if delay < time.Second {
+		return time.Second
+	}
+	return delay
+}
+
+// do sends the request to Vault and decodes the response
+func (client *Client) do(ctx context.Context, method, path string, body any, authenticated bool) (*Secret, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(client.Config.Address, "/")+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if client.Config.Namespace != "" {
+		request.Header.Set("X-Vault-Namespace", client.Config.Namespace)
+	}
+	if authenticated {
+		client.mutex.RLock()
+		request.Header.Set("X-Vault-Token", client.token)
+		client.mutex.RUnlock()
+	}
+	response, err := client.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("vault %s %s failed with status %d: %s", method, path, response.StatusCode, responseBody)
+	}
+	secret := &Secret{}
+	err = json.Unmarshal(responseBody, secret)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode vault response: %w", err)
+	}
+	return secret, nil
+}